@@ -0,0 +1,14 @@
+// Command incident-jira-webhook-postgres is incident-jira-webhook with the pgx database/sql
+// driver linked for STATE_STORE=postgres. It is a module of its own so the main module keeps
+// no dependencies.
+package main
+
+import (
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/cli"
+)
+
+func main() {
+	cli.Main()
+}
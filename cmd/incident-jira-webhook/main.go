@@ -0,0 +1,7 @@
+package main
+
+import "github.com/magzbaxter/incident-jira-webhook/pkg/cli"
+
+func main() {
+	cli.Main()
+}
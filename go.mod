@@ -1,7 +1,3 @@
-module incident-jira-webhook
+module github.com/magzbaxter/incident-jira-webhook
 
 go 1.21
-
-require (
-	// No external dependencies needed - using only Go standard library
-)
\ No newline at end of file
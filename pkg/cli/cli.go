@@ -0,0 +1,159 @@
+// Package cli is the incident-jira-webhook command, shared by the binaries built with and
+// without a Postgres driver
+package cli
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/server"
+)
+
+// Main runs the service, or the subcommand named by the first argument
+func Main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the command with its arguments, reading documents from stdin and writing output
+// to stdout
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	// "schema" prints the JSON Schema of MAPPING_RULES_FILE, for editors
+	case "schema":
+		_, err := stdout.Write(mapping.RulesFileSchema)
+		return err
+
+	// "simulate" runs recorded webhook payloads through the configuration and reports the
+	// upstream requests they would make, without contacting incident.io or Jira
+	case "simulate":
+		return simulate(args[1:], stdout)
+
+	// "report" summarizes the sync history over a period for the ops review
+	case "report":
+		return report(args[1:], stdout)
+
+	// "mappings export" and "mappings import" read and apply the mapping rules of a running
+	// service through its admin API, for managing them as code
+	case "mappings":
+		return mappings(args[1:], stdin, stdout)
+	}
+
+	flags := flag.NewFlagSet(serviceName, flag.ExitOnError)
+	// --migrate-only upgrades persisted state to this release's formats and exits, to run
+	// once before rolling out an upgrade
+	migrateOnly := flags.Bool("migrate-only", false, "migrate persisted state to this release's formats and exit")
+	// --log-file is for services without a console to log to, such as Windows services
+	logFile := flags.String("log-file", "", "append the log to this file instead of standard error")
+	flags.Parse(args)
+
+	if *logFile != "" {
+		file, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		log.SetOutput(file)
+	}
+
+	config, err := server.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	if *migrateOnly {
+		if err := server.Migrate(config); err != nil {
+			return err
+		}
+		log.Printf("Persisted state is up to date")
+		return nil
+	}
+
+	// Started by the Windows service control manager, the service runs under its control
+	if isService, err := server.RunAsService(serviceName, func() error { return serve(config) }); isService || err != nil {
+		return err
+	}
+
+	return serve(config)
+}
+
+// serviceName is the name the service is installed under on Windows
+const serviceName = "incident-jira-webhook"
+
+func serve(config server.Config) error {
+	syncHandler, err := server.NewIncidentJiraSync(config)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Starting incident.io to Jira webhook listener on %s...", strings.Join(config.ListenAddresses, ", "))
+	return syncHandler.Run()
+}
+
+func simulate(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	fixtures := flags.String("fixtures", "", "directory of recorded webhook payloads (*.json)")
+	flags.Parse(args)
+	if *fixtures == "" {
+		return errors.New("simulate: --fixtures is required")
+	}
+
+	config, err := server.LoadConfig()
+	if err != nil {
+		return err
+	}
+	return server.Simulate(config, *fixtures, stdout)
+}
+
+func report(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	since := flags.String("since", "168h", "start of the period: a duration before now, an RFC 3339 time or a date")
+	until := flags.String("until", "", "end of the period, as for --since (default now)")
+	format := flags.String("format", "text", "output format: text, json or markdown")
+	flags.Parse(args)
+
+	config, err := server.LoadConfig()
+	if err != nil {
+		return err
+	}
+	return server.Report(config, *since, *until, *format, stdout)
+}
+
+func mappings(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		return errors.New("mappings: expected export or import")
+	}
+	flags := flag.NewFlagSet("mappings "+args[0], flag.ExitOnError)
+	adminURL := flags.String("url", "http://localhost:5000", "base URL of the service's admin API")
+	file := flags.String("file", "-", "rules document to import, - for standard input")
+	dryRun := flags.Bool("dry-run", false, "report whether the import would change the rules without applying it")
+	flags.Parse(args[1:])
+
+	// The key is read from the environment to keep it out of process listings
+	apiKey := os.Getenv("ADMIN_API_KEY")
+	if args[0] == "export" {
+		return server.ExportMappings(*adminURL, apiKey, stdout)
+	}
+
+	var document []byte
+	var err error
+	if *file == "-" {
+		document, err = io.ReadAll(stdin)
+	} else {
+		document, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		return err
+	}
+	return server.ImportMappings(*adminURL, apiKey, document, *dryRun, stdout)
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+const testRules = `{"rules": [{"pattern": "* components", "jira_fields": {"Impacted components": "customfield_1"}}]}`
+
+// adminServer answers the mapping rules endpoints of the admin API, recording the imports
+type adminServer struct {
+	*httptest.Server
+	imported []string
+}
+
+func newAdminServer(t *testing.T) *adminServer {
+	s := &adminServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer key" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/admin/mappings":
+			w.Write([]byte(testRules))
+		case "/admin/mappings/import":
+			body, _ := io.ReadAll(r.Body)
+			s.imported = append(s.imported, string(body))
+			status := "applied"
+			if r.URL.Query().Get("dry_run") == "true" {
+				status = "would_apply"
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "rules": 1, "checksum": "abc"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSchema(t *testing.T) {
+	var out bytes.Buffer
+	if err := run([]string{"schema"}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), mapping.RulesFileSchema) {
+		t.Error("schema didn't print the rules file schema")
+	}
+}
+
+func TestMappings(t *testing.T) {
+	admin := newAdminServer(t)
+	t.Setenv("ADMIN_API_KEY", "key")
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(rulesFile, []byte(testRules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "export", args: []string{"mappings", "export", "--url", admin.URL}, want: testRules},
+		{name: "import", args: []string{"mappings", "import", "--url", admin.URL + "/", "--file", rulesFile}, want: "applied: 1 mapping rules (checksum abc)\n"},
+		{name: "dry run from stdin", args: []string{"mappings", "import", "--url", admin.URL, "--dry-run"}, want: "would_apply: 1 mapping rules (checksum abc)\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(test.args, strings.NewReader(testRules), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.want {
+				t.Errorf("output = %q, want %q", out.String(), test.want)
+			}
+		})
+	}
+	if len(admin.imported) != 2 || admin.imported[0] != testRules || admin.imported[1] != testRules {
+		t.Errorf("imported %q, want the rules document twice", admin.imported)
+	}
+}
+
+func TestMappingsErrors(t *testing.T) {
+	admin := newAdminServer(t)
+	t.Setenv("ADMIN_API_KEY", "key")
+
+	tests := []struct {
+		name    string
+		args    []string
+		stdin   string
+		wantErr string
+	}{
+		{name: "no verb", args: []string{"mappings"}, wantErr: "expected export or import"},
+		{name: "unknown verb", args: []string{"mappings", "sync"}, wantErr: "expected export or import"},
+		{name: "invalid rules", args: []string{"mappings", "import", "--url", admin.URL}, stdin: `{"rules": [{"pattern": "*"}]}`, wantErr: "jira_fields"},
+		{name: "missing file", args: []string{"mappings", "import", "--url", admin.URL, "--file", filepath.Join(t.TempDir(), "missing.json")}, wantErr: "no such file"},
+		{name: "rejected", args: []string{"mappings", "export", "--url", admin.URL + "/other"}, wantErr: "status 404"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := run(test.args, strings.NewReader(test.stdin), io.Discard)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("run(%q) = %v, want an error containing %q", test.args, err, test.wantErr)
+			}
+		})
+	}
+
+	t.Setenv("ADMIN_API_KEY", "")
+	if err := run([]string{"mappings", "export", "--url", admin.URL}, nil, io.Discard); err == nil {
+		t.Error("export without ADMIN_API_KEY succeeded")
+	}
+}
+
+func TestSimulateRequiresFixtures(t *testing.T) {
+	if err := run([]string{"simulate"}, nil, io.Discard); err == nil || !strings.Contains(err.Error(), "--fixtures") {
+		t.Errorf("simulate without --fixtures = %v, want an error", err)
+	}
+}
@@ -0,0 +1,252 @@
+// Package incidentio is a client for the parts of the incident.io API used to sync incidents
+// to Jira, and the types of incident.io webhook payloads.
+package incidentio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultBaseURL is the incident.io API
+const DefaultBaseURL = "https://api.incident.io"
+
+// Client calls the incident.io API
+type Client struct {
+	BaseURL  string
+	APIToken string
+	// OperationTokens, keyed by operation type, replace APIToken for those operations
+	OperationTokens map[string]string
+	HTTPClient      *http.Client
+	// Pagination bounds the list calls that follow pages
+	Pagination Pagination
+	// Redact, when set, is applied to error response bodies before they are logged
+	Redact func(body []byte) string
+}
+
+// NewClient returns a client for the incident.io API sending requests with httpClient
+// (http.DefaultClient when nil), retried and rate limited as DefaultRetryConfig
+func NewClient(apiToken string, httpClient *http.Client) *Client {
+	return NewClientWithRetries(apiToken, httpClient, DefaultRetryConfig)
+}
+
+// NewClientWithRetries returns a client for the incident.io API whose requests are retried and
+// rate limited as retries. A zero RetryConfig sends them with httpClient as it is, for callers
+// whose transport already retries.
+func NewClientWithRetries(apiToken string, httpClient *http.Client, retries RetryConfig) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if retries.Retries > 0 || retries.RateLimit > 0 {
+		retrying := *httpClient
+		retrying.Transport = &Transport{Next: httpClient.Transport, Config: retries}
+		httpClient = &retrying
+	}
+	return &Client{BaseURL: DefaultBaseURL, APIToken: apiToken, HTTPClient: httpClient, Pagination: DefaultPagination}
+}
+
+func (c *Client) redact(body []byte) string {
+	if c.Redact == nil {
+		return string(body)
+	}
+	return c.Redact(body)
+}
+
+// do sends a request to the API and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		payloadBytes, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		reqBody = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token(operationFor(method, path))))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call incident.io API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(resp.Body)
+		log.Printf("incident.io API error response: %s", c.redact(respBody))
+		return newAPIError(resp.StatusCode, respBody)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// GetIncident fetches the full incident, including every custom field entry
+func (c *Client) GetIncident(ctx context.Context, incidentID string) (*Incident, error) {
+	var incidentResp struct {
+		Incident Incident `json:"incident"`
+	}
+	if err := c.do(ctx, "GET", "/v2/incidents/"+incidentID, nil, &incidentResp); err != nil {
+		return nil, fmt.Errorf("failed to fetch incident: %w", err)
+	}
+	return &incidentResp.Incident, nil
+}
+
+// ListIncidents returns one page of incidents, newest first, and the cursor of the next page
+// (empty on the last page). A pageSize of 0 uses the client's page size.
+func (c *Client) ListIncidents(ctx context.Context, pageSize int, after string) ([]Incident, string, error) {
+	if pageSize <= 0 {
+		pageSize = c.Pagination.PageSize
+	}
+	query := url.Values{}
+	query.Set("page_size", strconv.Itoa(pageSize))
+	if after != "" {
+		query.Set("after", after)
+	}
+
+	var listResp struct {
+		Incidents      []Incident `json:"incidents"`
+		PaginationMeta struct {
+			After string `json:"after"`
+		} `json:"pagination_meta"`
+	}
+	if err := c.do(ctx, "GET", "/v2/incidents?"+query.Encode(), nil, &listResp); err != nil {
+		return nil, "", fmt.Errorf("failed to list incidents: %w", err)
+	}
+
+	next := listResp.PaginationMeta.After
+	if len(listResp.Incidents) < pageSize {
+		next = ""
+	}
+	return listResp.Incidents, next, nil
+}
+
+// ListIncidentsUpdatedSince returns every incident updated at or after since
+func (c *Client) ListIncidentsUpdatedSince(ctx context.Context, since time.Time) ([]Incident, error) {
+	query := url.Values{}
+	query.Set("updated_at[gte]", since.UTC().Format(time.RFC3339))
+
+	var incidents []Incident
+	err := c.paginate(ctx, "/v2/incidents", query, "incidents", func(items json.RawMessage) (int, error) {
+		var page []Incident
+		if err := json.Unmarshal(items, &page); err != nil {
+			return 0, err
+		}
+		incidents = append(incidents, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list updated incidents: %w", err)
+	}
+	return incidents, nil
+}
+
+// ListIncidentAttachments returns the external resources attached to an incident
+func (c *Client) ListIncidentAttachments(ctx context.Context, incidentID string) ([]IncidentAttachment, error) {
+	query := url.Values{}
+	query.Set("incident_id", incidentID)
+
+	var listResp struct {
+		IncidentAttachments []IncidentAttachment `json:"incident_attachments"`
+	}
+	if err := c.do(ctx, "GET", "/v1/incident_attachments?"+query.Encode(), nil, &listResp); err != nil {
+		return nil, fmt.Errorf("failed to list incident attachments: %w", err)
+	}
+	return listResp.IncidentAttachments, nil
+}
+
+// ListIncidentUpdates returns every update posted to an incident, oldest first
+func (c *Client) ListIncidentUpdates(ctx context.Context, incidentID string) ([]IncidentUpdate, error) {
+	query := url.Values{}
+	query.Set("incident_id", incidentID)
+
+	var updates []IncidentUpdate
+	err := c.paginate(ctx, "/v2/incident_updates", query, "incident_updates", func(items json.RawMessage) (int, error) {
+		var page []IncidentUpdate
+		if err := json.Unmarshal(items, &page); err != nil {
+			return 0, err
+		}
+		updates = append(updates, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list incident updates: %w", err)
+	}
+
+	sort.Slice(updates, func(i, j int) bool { return updates[i].CreatedAt.Before(updates[j].CreatedAt) })
+	return updates, nil
+}
+
+// ListEscalations returns the escalations created at or after since
+func (c *Client) ListEscalations(ctx context.Context, since time.Time) ([]Escalation, error) {
+	query := url.Values{}
+	query.Set("created_at[gte]", since.UTC().Format(time.RFC3339))
+
+	var escalations []Escalation
+	err := c.paginate(ctx, "/v2/escalations", query, "escalations", func(items json.RawMessage) (int, error) {
+		var page []Escalation
+		if err := json.Unmarshal(items, &page); err != nil {
+			return 0, err
+		}
+		escalations = append(escalations, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list escalations: %w", err)
+	}
+	return escalations, nil
+}
+
+// GetCatalogEntry fetches a catalog entry with its attribute values and catalog type schema
+func (c *Client) GetCatalogEntry(ctx context.Context, catalogEntryID string) (*CatalogResponse, error) {
+	var catalogResp CatalogResponse
+	if err := c.do(ctx, "GET", "/v2/catalog_entries/"+catalogEntryID, nil, &catalogResp); err != nil {
+		return nil, fmt.Errorf("failed to fetch catalog entry: %w", err)
+	}
+	return &catalogResp, nil
+}
+
+// EditIncident applies the edit incident action
+func (c *Client) EditIncident(ctx context.Context, incidentID string, edit EditRequest) error {
+	if err := c.do(ctx, "POST", fmt.Sprintf("/v2/incidents/%s/actions/edit", incidentID), edit, nil); err != nil {
+		return fmt.Errorf("failed to edit incident: %w", err)
+	}
+	return nil
+}
+
+// SetTextField sets a text custom field on an incident, optionally announcing the change in
+// the incident's Slack channel
+func (c *Client) SetTextField(ctx context.Context, incidentID, customFieldID, text string, notifyChannel bool) error {
+	return c.EditIncident(ctx, incidentID, TextFieldEdit(customFieldID, text, notifyChannel))
+}
+
+// TextFieldEdit is the edit setting a text custom field, optionally announcing the change in
+// the incident's Slack channel
+func TextFieldEdit(customFieldID, text string, notifyChannel bool) EditRequest {
+	var edit EditRequest
+	edit.Incident.CustomFieldEntries = []EditFieldEntry{{
+		CustomFieldID: customFieldID,
+		Values:        []Value{{ValueText: text}},
+	}}
+	edit.NotifyIncidentChannel = notifyChannel
+	return edit
+}
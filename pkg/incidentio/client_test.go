@@ -0,0 +1,54 @@
+package incidentio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientRequests(t *testing.T) {
+	var edit EditRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v2/incidents/01ABC":
+			w.Write([]byte(`{"incident": {"id": "01ABC", "name": "Payments down"}}`))
+		case "POST /v2/incidents/01ABC/actions/edit":
+			if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+				t.Error(err)
+			}
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient("token", server.Client())
+	client.BaseURL = server.URL
+	ctx := context.Background()
+
+	incident, err := client.GetIncident(ctx, "01ABC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incident.Name != "Payments down" {
+		t.Errorf("incident = %+v", incident)
+	}
+
+	if err := client.SetTextField(ctx, "01ABC", "field_1", "SUP-1", true); err != nil {
+		t.Fatal(err)
+	}
+	entries := edit.Incident.CustomFieldEntries
+	if len(entries) != 1 || entries[0].CustomFieldID != "field_1" || entries[0].Values[0].ValueText != "SUP-1" || !edit.NotifyIncidentChannel {
+		t.Errorf("edit = %+v", edit)
+	}
+
+	if _, err := client.GetCatalogEntry(ctx, "missing"); err == nil {
+		t.Error("GetCatalogEntry() of a missing entry succeeded")
+	}
+}
@@ -0,0 +1,52 @@
+package incidentio
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// JSONCodec unmarshals the parts of webhook payloads. The default is encoding/json; a drop-in
+// replacement with the same behaviour, e.g. a faster JSON library, can be set with
+// SetJSONCodec. Type mismatches are only skipped as warnings when the codec reports them as
+// *json.UnmarshalTypeError.
+type JSONCodec interface {
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONDecoder reads JSON from a stream a token or a value at a time, like *json.Decoder
+type JSONDecoder interface {
+	Token() (json.Token, error)
+	More() bool
+	Decode(v interface{}) error
+}
+
+// JSONStreamCodec is a JSONCodec that can also decode from a stream. DecodeWebhookPayload
+// decodes with it as the delivery arrives; with a codec that can't, the delivery is read whole
+// and unmarshalled.
+type JSONStreamCodec interface {
+	JSONCodec
+	NewDecoder(r io.Reader) JSONDecoder
+}
+
+// standardCodec is encoding/json
+type standardCodec struct{}
+
+func (standardCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (standardCodec) NewDecoder(r io.Reader) JSONDecoder {
+	return json.NewDecoder(r)
+}
+
+// jsonCodec is the codec payloads are unmarshalled with
+var jsonCodec JSONCodec = standardCodec{}
+
+// SetJSONCodec replaces the codec payloads are unmarshalled with; nil restores encoding/json.
+// It must be called before payloads are decoded.
+func SetJSONCodec(codec JSONCodec) {
+	if codec == nil {
+		codec = standardCodec{}
+	}
+	jsonCodec = codec
+}
@@ -0,0 +1,42 @@
+package incidentio
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Operation types, each of which can use its own API key
+const (
+	// OperationCatalog reads catalog entries
+	OperationCatalog = "catalog"
+	// OperationRead reads incidents, their attachments and updates, escalations and custom fields
+	OperationRead = "read"
+	// OperationWrite edits incidents, e.g. failure notes written back to a custom field
+	OperationWrite = "write"
+	// OperationWebhooks lists and manages webhook endpoints
+	OperationWebhooks = "webhooks"
+)
+
+// Operations lists every operation type
+var Operations = []string{OperationCatalog, OperationRead, OperationWrite, OperationWebhooks}
+
+// operationFor returns the operation type of a request
+func operationFor(method, path string) string {
+	switch {
+	case strings.HasPrefix(path, "/v2/webhook_endpoints"):
+		return OperationWebhooks
+	case method != http.MethodGet:
+		return OperationWrite
+	case strings.HasPrefix(path, "/v2/catalog_"):
+		return OperationCatalog
+	}
+	return OperationRead
+}
+
+// token returns the API key for an operation type, falling back to APIToken
+func (c *Client) token(operation string) string {
+	if token := c.OperationTokens[operation]; token != "" {
+		return token
+	}
+	return c.APIToken
+}
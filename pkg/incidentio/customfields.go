@@ -0,0 +1,90 @@
+package incidentio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ListCustomFields returns every custom field of the organisation
+func (c *Client) ListCustomFields(ctx context.Context) ([]CustomField, error) {
+	var listResp struct {
+		CustomFields []CustomField `json:"custom_fields"`
+	}
+	if err := c.do(ctx, "GET", "/v2/custom_fields", nil, &listResp); err != nil {
+		return nil, fmt.Errorf("failed to list custom fields: %w", err)
+	}
+	return listResp.CustomFields, nil
+}
+
+// ListCustomFieldOptions returns the options of a select custom field
+func (c *Client) ListCustomFieldOptions(ctx context.Context, customFieldID string) ([]OptionValue, error) {
+	query := url.Values{}
+	query.Set("custom_field_id", customFieldID)
+
+	var options []OptionValue
+	err := c.paginate(ctx, "/v1/custom_field_options", query, "custom_field_options", func(items json.RawMessage) (int, error) {
+		var page []OptionValue
+		if err := json.Unmarshal(items, &page); err != nil {
+			return 0, err
+		}
+		options = append(options, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list options of %s: %w", customFieldID, err)
+	}
+	return options, nil
+}
+
+// ListCatalogEntries returns up to limit entries of a catalog type, from the first page
+func (c *Client) ListCatalogEntries(ctx context.Context, catalogTypeID string, limit int) ([]CatalogEntry, error) {
+	query := url.Values{}
+	query.Set("catalog_type_id", catalogTypeID)
+	query.Set("page_size", strconv.Itoa(limit))
+
+	var listResp struct {
+		CatalogEntries []CatalogEntry `json:"catalog_entries"`
+	}
+	if err := c.do(ctx, "GET", "/v2/catalog_entries?"+query.Encode(), nil, &listResp); err != nil {
+		return nil, fmt.Errorf("failed to list catalog entries: %w", err)
+	}
+	if len(listResp.CatalogEntries) > limit {
+		listResp.CatalogEntries = listResp.CatalogEntries[:limit]
+	}
+	return listResp.CatalogEntries, nil
+}
+
+// ListAllCatalogEntries returns every entry of a catalog type with its attribute values,
+// following pages. Each is returned with the catalog type schema, as GetCatalogEntry returns it.
+func (c *Client) ListAllCatalogEntries(ctx context.Context, catalogTypeID string) ([]CatalogResponse, error) {
+	var catalogType CatalogResponse
+	if err := c.do(ctx, "GET", "/v2/catalog_types/"+catalogTypeID, nil, &catalogType); err != nil {
+		return nil, fmt.Errorf("failed to fetch catalog type: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set("catalog_type_id", catalogTypeID)
+
+	var entries []CatalogResponse
+	err := c.paginate(ctx, "/v2/catalog_entries", query, "catalog_entries", func(items json.RawMessage) (int, error) {
+		var page []json.RawMessage
+		if err := json.Unmarshal(items, &page); err != nil {
+			return 0, err
+		}
+		for _, item := range page {
+			entry := CatalogResponse{CatalogType: catalogType.CatalogType}
+			if err := json.Unmarshal(item, &entry.CatalogEntry); err != nil {
+				return 0, err
+			}
+			entries = append(entries, entry)
+		}
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list entries of catalog type %s: %w", catalogTypeID, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,73 @@
+package incidentio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors an APIError wraps by status, for errors.Is
+var (
+	// ErrNotFound is an incident, catalog entry or other resource that doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrPermission is a request the API key may not make
+	ErrPermission = errors.New("permission denied")
+	// ErrValidation is a request incident.io rejected as invalid, e.g. an edit of a custom field
+	// that was deleted
+	ErrValidation = errors.New("validation failed")
+)
+
+// APIError is an error response from the incident.io API, with the messages it gave
+type APIError struct {
+	StatusCode int
+	// Messages are the messages of the response's errors
+	Messages []string
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("incident.io API request failed with status: %d", e.StatusCode)
+	if len(e.Messages) == 0 {
+		return message
+	}
+	return message + ": " + strings.Join(e.Messages, "; ")
+}
+
+// Unwrap returns the error the status stands for, if any
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrPermission
+	case http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity:
+		return ErrValidation
+	}
+	return nil
+}
+
+// newAPIError reads the messages out of an error response body. incident.io answers
+// {"type": ..., "errors": [{"code": ..., "message": ...}]}; other bodies are left out.
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode}
+	var response struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &response) != nil {
+		return apiErr
+	}
+	for _, responseErr := range response.Errors {
+		if responseErr.Message != "" {
+			apiErr.Messages = append(apiErr.Messages, responseErr.Message)
+		}
+	}
+	return apiErr
+}
+
+// IsPermanent reports whether err is an error response that won't succeed when retried
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrPermission) || errors.Is(err, ErrValidation)
+}
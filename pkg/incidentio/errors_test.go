@@ -0,0 +1,87 @@
+package incidentio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAPIErrorStatuses(t *testing.T) {
+	tests := []struct {
+		status    int
+		want      error
+		permanent bool
+	}{
+		{status: http.StatusNotFound, want: ErrNotFound, permanent: true},
+		{status: http.StatusUnauthorized, want: ErrPermission, permanent: true},
+		{status: http.StatusForbidden, want: ErrPermission, permanent: true},
+		{status: http.StatusBadRequest, want: ErrValidation, permanent: true},
+		{status: http.StatusUnprocessableEntity, want: ErrValidation, permanent: true},
+		{status: http.StatusTooManyRequests},
+		{status: http.StatusInternalServerError},
+		{status: http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+			client := NewClient("token", server.Client())
+			client.BaseURL = server.URL
+
+			err := client.EditIncident(context.Background(), "01ABC", EditRequest{})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != test.status {
+				t.Fatalf("EditIncident() = %v, want an API error with status %d", err, test.status)
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("errors.Is(%v, %v) = false", err, test.want)
+			}
+			if permanent := IsPermanent(err); permanent != test.permanent {
+				t.Errorf("IsPermanent() = %v, want %v", permanent, test.permanent)
+			}
+		})
+	}
+}
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		messages []string
+		message  string
+	}{
+		{
+			name:     "errors",
+			body:     `{"type": "validation_error", "status": 422, "errors": [{"code": "invalid_value", "message": "Custom field not found"}, {"code": "other"}]}`,
+			messages: []string{"Custom field not found"},
+			message:  "incident.io API request failed with status: 422: Custom field not found",
+		},
+		{
+			name:    "not JSON",
+			body:    `<html>Bad Gateway</html>`,
+			message: "incident.io API request failed with status: 422",
+		},
+		{
+			name:    "empty",
+			message: "incident.io API request failed with status: 422",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiErr := newAPIError(http.StatusUnprocessableEntity, []byte(test.body))
+			if !reflect.DeepEqual(apiErr.Messages, test.messages) {
+				t.Errorf("Messages = %q, want %q", apiErr.Messages, test.messages)
+			}
+			if message := apiErr.Error(); message != test.message {
+				t.Errorf("Error() = %q, want %q", message, test.message)
+			}
+		})
+	}
+}
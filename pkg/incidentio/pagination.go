@@ -0,0 +1,73 @@
+package incidentio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// MaxPageSize is the largest page the incident.io API returns
+const MaxPageSize = 250
+
+// Pagination bounds the list calls that follow pages
+type Pagination struct {
+	// PageSize is the number of items requested per page
+	PageSize int
+	// MaxPages stops a listing that has not finished after this many pages
+	MaxPages int
+}
+
+// DefaultPagination requests full pages and gives up after 100 of them
+var DefaultPagination = Pagination{PageSize: MaxPageSize, MaxPages: 100}
+
+// ErrTooManyPages is returned when a listing reaches Pagination.MaxPages, rather than returning a
+// truncated list
+var ErrTooManyPages = errors.New("too many pages")
+
+// paginate lists path page by page, following the pagination_meta.after cursor, and passes the
+// items under listKey on each page to appendItems, which returns how many it got
+func (c *Client) paginate(ctx context.Context, path string, query url.Values, listKey string, appendItems func(items json.RawMessage) (int, error)) error {
+	pageSize := c.Pagination.PageSize
+	query.Set("page_size", strconv.Itoa(pageSize))
+	query.Del("after")
+
+	seen := map[string]bool{}
+	for pages := 0; ; pages++ {
+		if pages >= c.Pagination.MaxPages {
+			return fmt.Errorf("%w: %s has more than %d pages of %d", ErrTooManyPages, path, c.Pagination.MaxPages, pageSize)
+		}
+
+		var page map[string]json.RawMessage
+		if err := c.do(ctx, "GET", path+"?"+query.Encode(), nil, &page); err != nil {
+			return err
+		}
+		count := 0
+		if items, ok := page[listKey]; ok {
+			var err error
+			if count, err = appendItems(items); err != nil {
+				return fmt.Errorf("failed to decode %s: %w", listKey, err)
+			}
+		}
+
+		var meta struct {
+			After string `json:"after"`
+		}
+		if raw, ok := page["pagination_meta"]; ok {
+			if err := json.Unmarshal(raw, &meta); err != nil {
+				return fmt.Errorf("failed to decode pagination: %w", err)
+			}
+		}
+		if meta.After == "" || count < pageSize {
+			return nil
+		}
+		// A cursor seen before would list the same pages forever
+		if seen[meta.After] {
+			return fmt.Errorf("%s returned cursor %q twice", path, meta.After)
+		}
+		seen[meta.After] = true
+		query.Set("after", meta.After)
+	}
+}
@@ -0,0 +1,114 @@
+package incidentio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pagedServer answers /v2/incidents with the pages given, keyed by the after cursor, recording
+// each query
+func pagedServer(t *testing.T, pages map[string]string) (*Client, *[]string) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		page, found := pages[r.URL.Query().Get("after")]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(page))
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient("token", server.Client())
+	client.BaseURL = server.URL
+	client.Pagination = Pagination{PageSize: 2, MaxPages: 3}
+	return client, &queries
+}
+
+func TestPaginateFollowsCursor(t *testing.T) {
+	client, queries := pagedServer(t, map[string]string{
+		"":  `{"incidents": [{"id": "1"}, {"id": "2"}], "pagination_meta": {"after": "2"}}`,
+		"2": `{"incidents": [{"id": "3"}, {"id": "4"}], "pagination_meta": {"after": "4"}}`,
+		"4": `{"incidents": [{"id": "5"}], "pagination_meta": {"after": "5"}}`,
+		"5": `{"incidents": [{"id": "6"}]}`,
+	})
+
+	incidents, err := client.ListIncidentsUpdatedSince(context.Background(), time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, incident := range incidents {
+		ids = append(ids, incident.ID)
+	}
+	// A short page is the last, whatever cursor it carries
+	if strings.Join(ids, ",") != "1,2,3,4,5" {
+		t.Errorf("listed %q, want incidents 1 to 5", ids)
+	}
+	if len(*queries) != 3 || !strings.Contains((*queries)[0], "page_size=2") || !strings.Contains((*queries)[0], "updated_at%5Bgte%5D=2023-11-14T22%3A13%3A20Z") || !strings.Contains((*queries)[2], "after=4") {
+		t.Errorf("queries = %q", *queries)
+	}
+}
+
+func TestPaginateStops(t *testing.T) {
+	tests := []struct {
+		name    string
+		pages   map[string]string
+		wantErr string
+	}{
+		{
+			name: "too many pages",
+			pages: map[string]string{
+				"":  `{"incidents": [{}, {}], "pagination_meta": {"after": "a"}}`,
+				"a": `{"incidents": [{}, {}], "pagination_meta": {"after": "b"}}`,
+				"b": `{"incidents": [{}, {}], "pagination_meta": {"after": "c"}}`,
+				"c": `{"incidents": []}`,
+			},
+			wantErr: "more than 3 pages",
+		},
+		{
+			name: "repeated cursor",
+			pages: map[string]string{
+				"":  `{"incidents": [{}, {}], "pagination_meta": {"after": "a"}}`,
+				"a": `{"incidents": [{}, {}], "pagination_meta": {"after": "a"}}`,
+			},
+			wantErr: `returned cursor "a" twice`,
+		},
+		{
+			name: "failed page",
+			pages: map[string]string{
+				"": `{"incidents": [{}, {}], "pagination_meta": {"after": "missing"}}`,
+			},
+			wantErr: "status: 404",
+		},
+		{
+			name:    "undecodable items",
+			pages:   map[string]string{"": `{"incidents": {"id": "1"}}`},
+			wantErr: "failed to decode incidents",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, _ := pagedServer(t, test.pages)
+			_, err := client.ListIncidentsUpdatedSince(context.Background(), time.Now())
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("ListIncidentsUpdatedSince() = %v, want an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+
+	client, _ := pagedServer(t, map[string]string{
+		"":  `{"incidents": [{}, {}], "pagination_meta": {"after": "a"}}`,
+		"a": `{"incidents": [{}, {}], "pagination_meta": {"after": "b"}}`,
+		"b": `{"incidents": [{}, {}], "pagination_meta": {"after": "c"}}`,
+	})
+	if _, err := client.ListIncidentsUpdatedSince(context.Background(), time.Now()); !errors.Is(err, ErrTooManyPages) {
+		t.Errorf("ListIncidentsUpdatedSince() = %v, want %v", err, ErrTooManyPages)
+	}
+}
@@ -0,0 +1,324 @@
+package incidentio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KnownEventTypes are the webhook event types that carry an incident this module can sync.
+// Later versions of the public_incident.* events are known too, see IsKnownEventType.
+var KnownEventTypes = map[string]bool{
+	"incident.custom_field_updated":       true,
+	"public_incident.incident_created_v2": true,
+	"public_incident.incident_updated_v2": true,
+}
+
+// eventVersionSuffix matches the version suffix of public_incident.* event types, e.g. "_v2"
+var eventVersionSuffix = regexp.MustCompile(`_v(\d+)$`)
+
+// EventVersion returns the payload version of an event type: 1 for the legacy incident.*
+// events, otherwise the version in the type's suffix. A suffix that isn't a valid version, such
+// as "_v0" or one too large for an int, gives 0.
+func EventVersion(eventType string) int {
+	match := eventVersionSuffix.FindStringSubmatch(eventType)
+	if match == nil || !strings.HasPrefix(eventType, "public_incident.") {
+		return 1
+	}
+	version, err := strconv.Atoi(match[1])
+	if err != nil || version < 1 {
+		return 0
+	}
+	return version
+}
+
+// EventName returns an event type without its version suffix, e.g.
+// "public_incident.incident_created" for "public_incident.incident_created_v2"
+func EventName(eventType string) string {
+	if !strings.HasPrefix(eventType, "public_incident.") {
+		return eventType
+	}
+	return eventVersionSuffix.ReplaceAllString(eventType, "")
+}
+
+// IsKnownEventType reports whether an event type carries an incident this module can sync:
+// one of KnownEventTypes, or a later version of one of them
+func IsKnownEventType(eventType string) bool {
+	if KnownEventTypes[eventType] {
+		return true
+	}
+	version := EventVersion(eventType)
+	if version <= 2 {
+		return false
+	}
+	return KnownEventTypes[eventVersionSuffix.ReplaceAllString(eventType, "_v2")]
+}
+
+// WebhookPayload is an incident.io webhook delivery, normalised across event types and payload
+// versions
+type WebhookPayload struct {
+	EventType string `json:"event_type"`
+	// Version is the payload version of the event type, see EventVersion
+	Version int `json:"-"`
+	// Incident is the incident the event is about
+	Incident Incident `json:"incident"`
+	// PreviousState is the incident before the change, when the event includes it
+	PreviousState *Incident `json:"previous_state,omitempty"`
+	// Warnings lists parts of the payload that were skipped because they didn't have the
+	// expected type, e.g. after incident.io changed a field
+	Warnings []string `json:"-"`
+}
+
+// eventParser reads the incident and its previous state from the top-level keys of a delivery
+type eventParser func(p *WebhookPayload, raw map[string]json.RawMessage) error
+
+// eventParsers by payload version; versions beyond the last are read by the last parser, and
+// invalid versions by the legacy parser
+var eventParsers = []eventParser{
+	1: parseLegacyEvent,
+	2: parseV2Event,
+	3: parseV3Event,
+}
+
+// errDuplicateEventType is a payload with more than one event_type, which decoders disagree on
+var errDuplicateEventType = errors.New("payload has more than one event_type")
+
+// UnmarshalJSON decodes a webhook payload with the parser for its event type's version.
+// Unknown keys are ignored, and values of an unexpected type are skipped and recorded in
+// Warnings rather than failing the delivery.
+func (p *WebhookPayload) UnmarshalJSON(data []byte) error {
+	if err := checkEventTypeUnique(data); err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := jsonCodec.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	return p.parse(raw)
+}
+
+// DecodeWebhookPayload reads a webhook payload from r one top-level key at a time, so a large
+// delivery is parsed as it arrives rather than read into memory first. Once event_type has been
+// read, the incident and its previous state are decoded straight from the stream when the
+// event's version has them at a known key; other keys are kept for the version's parser.
+// Codecs that can't decode streams get the delivery read whole.
+func DecodeWebhookPayload(r io.Reader) (WebhookPayload, error) {
+	var p WebhookPayload
+	codec, streams := jsonCodec.(JSONStreamCodec)
+	if !streams {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return p, err
+		}
+		err = p.UnmarshalJSON(data)
+		return p, err
+	}
+
+	decoder := codec.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil {
+		return p, err
+	} else if token != json.Delim('{') {
+		return p, errors.New("payload is not a JSON object")
+	}
+
+	raw := make(map[string]json.RawMessage)
+	typed, decodedIncident := false, false
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return p, err
+		}
+		key, _ := token.(string)
+		switch {
+		case key == "event_type" && typed:
+			return p, errDuplicateEventType
+		case key == "event_type":
+			if err := decoder.Decode(&p.EventType); err != nil {
+				return p, fmt.Errorf("invalid event_type: %w", err)
+			}
+			p.Version, typed = EventVersion(p.EventType), true
+		case typed && key == p.incidentKey() && p.incidentKey() != "":
+			if err := p.decodeStream(key, decoder, &p.Incident); err != nil {
+				return p, err
+			}
+			decodedIncident = true
+		case typed && key == "previous_state" && p.incidentKey() != "":
+			if err := p.decodeStream(key, decoder, &p.PreviousState); err != nil {
+				return p, err
+			}
+		default:
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return p, err
+			}
+			raw[key] = value
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return p, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return p, errors.New("unexpected data after the payload")
+	}
+
+	if !typed {
+		err := p.parse(raw)
+		return p, err
+	}
+	if decodedIncident {
+		err := p.decodePreviousState(raw)
+		return p, err
+	}
+	err := p.parseVersion(raw)
+	return p, err
+}
+
+// checkEventTypeUnique returns errDuplicateEventType if a payload has event_type more than
+// once. Unmarshalling into a map keeps the last, where the stream decoder reads the first.
+func checkEventTypeUnique(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		// Left for the codec to report
+		return nil
+	}
+	typed := false
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+		if token == "event_type" {
+			if typed {
+				return errDuplicateEventType
+			}
+			typed = true
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// incidentKey returns the top-level key holding the incident for the payload's version, or an
+// empty string when only its parser can tell
+func (p *WebhookPayload) incidentKey() string {
+	switch {
+	case p.Version < 2:
+		return "incident"
+	case p.Version == 2:
+		return p.EventType
+	}
+	return ""
+}
+
+// parse reads a payload from its top-level keys
+func (p *WebhookPayload) parse(raw map[string]json.RawMessage) error {
+	*p = WebhookPayload{}
+	if eventType, exists := raw["event_type"]; exists {
+		if err := jsonCodec.Unmarshal(eventType, &p.EventType); err != nil {
+			return fmt.Errorf("invalid event_type: %w", err)
+		}
+	}
+	p.Version = EventVersion(p.EventType)
+	return p.parseVersion(raw)
+}
+
+// parseVersion reads a payload whose event type is known from its other top-level keys, with
+// the parser for its version
+func (p *WebhookPayload) parseVersion(raw map[string]json.RawMessage) error {
+	parser := eventParsers[len(eventParsers)-1]
+	switch {
+	case p.Version < 1:
+		parser = parseLegacyEvent
+	case p.Version < len(eventParsers):
+		parser = eventParsers[p.Version]
+	}
+	return parser(p, raw)
+}
+
+// decode unmarshals a part of the payload, recording type mismatches as warnings
+func (p *WebhookPayload) decode(key string, data json.RawMessage, out interface{}) error {
+	return p.warnOnTypeError(key, jsonCodec.Unmarshal(data, out))
+}
+
+// warnOnTypeError records a type mismatch in decoding part of the payload as a warning, and
+// returns other errors
+func (p *WebhookPayload) warnOnTypeError(key string, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("%s.%s: expected %s, got %s", key, typeErr.Field, typeErr.Type, typeErr.Value))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return nil
+}
+
+// decodeStream decodes the next value of a payload's stream, recording type mismatches as
+// warnings
+func (p *WebhookPayload) decodeStream(key string, decoder JSONDecoder, out interface{}) error {
+	return p.warnOnTypeError(key, decoder.Decode(out))
+}
+
+// decodePreviousState reads previous_state, if the delivery has it
+func (p *WebhookPayload) decodePreviousState(raw map[string]json.RawMessage) error {
+	data, exists := raw["previous_state"]
+	if !exists || string(data) == "null" {
+		return nil
+	}
+	p.PreviousState = &Incident{}
+	return p.decode("previous_state", data, p.PreviousState)
+}
+
+// parseLegacyEvent reads incident.* events, which carry the incident under "incident"
+func parseLegacyEvent(p *WebhookPayload, raw map[string]json.RawMessage) error {
+	if data, exists := raw["incident"]; exists {
+		if err := p.decode("incident", data, &p.Incident); err != nil {
+			return err
+		}
+	}
+	return p.decodePreviousState(raw)
+}
+
+// parseV2Event reads public_incident.*_v2 events, which carry the incident under a key named
+// after the event type
+func parseV2Event(p *WebhookPayload, raw map[string]json.RawMessage) error {
+	if data, exists := raw[p.EventType]; exists {
+		if err := p.decode(p.EventType, data, &p.Incident); err != nil {
+			return err
+		}
+	}
+	return p.decodePreviousState(raw)
+}
+
+// parseV3Event reads public_incident.* events from v3 on. The body under the event type key
+// is taken either as the incident, as in v2, or as an envelope holding "incident" and
+// "previous_state"; deliveries without it fall back to the legacy layout.
+func parseV3Event(p *WebhookPayload, raw map[string]json.RawMessage) error {
+	data, exists := raw[p.EventType]
+	if !exists {
+		return parseLegacyEvent(p, raw)
+	}
+
+	var envelope map[string]json.RawMessage
+	if err := jsonCodec.Unmarshal(data, &envelope); err == nil {
+		if _, isEnvelope := envelope["incident"]; isEnvelope {
+			if previous, hasPrevious := raw["previous_state"]; hasPrevious && envelope["previous_state"] == nil {
+				envelope["previous_state"] = previous
+			}
+			return parseLegacyEvent(p, envelope)
+		}
+	}
+	if err := p.decode(p.EventType, data, &p.Incident); err != nil {
+		return err
+	}
+	return p.decodePreviousState(raw)
+}
@@ -0,0 +1,258 @@
+package incidentio
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWebhookPayloadVersions(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		version   int
+		known     bool
+		// body is the delivery without its event_type
+		body string
+	}{
+		{
+			name:      "legacy event",
+			eventType: "incident.custom_field_updated",
+			version:   1,
+			known:     true,
+			body:      `{"incident": {"id": "01ABC"}}`,
+		},
+		{
+			name:      "v1",
+			eventType: "public_incident.incident_created_v1",
+			version:   1,
+			body:      `{"incident": {"id": "01ABC"}}`,
+		},
+		{
+			name:      "v2",
+			eventType: "public_incident.incident_created_v2",
+			version:   2,
+			known:     true,
+			body:      `{"public_incident.incident_created_v2": {"id": "01ABC"}}`,
+		},
+		{
+			name:      "v3 incident",
+			eventType: "public_incident.incident_updated_v3",
+			version:   3,
+			known:     true,
+			body:      `{"public_incident.incident_updated_v3": {"id": "01ABC"}}`,
+		},
+		{
+			name:      "v3 envelope",
+			eventType: "public_incident.incident_updated_v3",
+			version:   3,
+			known:     true,
+			body:      `{"public_incident.incident_updated_v3": {"incident": {"id": "01ABC"}}}`,
+		},
+		{
+			name:      "beyond the last parser",
+			eventType: "public_incident.incident_updated_v7",
+			version:   7,
+			known:     true,
+			body:      `{"public_incident.incident_updated_v7": {"id": "01ABC"}}`,
+		},
+		{
+			name:      "v0",
+			eventType: "public_incident.incident_created_v0",
+			version:   0,
+			body:      `{"incident": {"id": "01ABC"}}`,
+		},
+		{
+			name:      "zero padded v0",
+			eventType: "public_incident.incident_created_v000",
+			version:   0,
+			body:      `{"incident": {"id": "01ABC"}}`,
+		},
+		{
+			name:      "out of range",
+			eventType: "public_incident.incident_created_v99999999999999999999999",
+			version:   0,
+			body:      `{"incident": {"id": "01ABC"}}`,
+		},
+		{
+			name:      "version suffix on a legacy event",
+			eventType: "incident.custom_field_updated_v2",
+			version:   1,
+			body:      `{"incident": {"id": "01ABC"}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if version := EventVersion(test.eventType); version != test.version {
+				t.Errorf("EventVersion(%q) = %d, want %d", test.eventType, version, test.version)
+			}
+			if known := IsKnownEventType(test.eventType); known != test.known {
+				t.Errorf("IsKnownEventType(%q) = %v, want %v", test.eventType, known, test.known)
+			}
+
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(test.body), &fields); err != nil {
+				t.Fatal(err)
+			}
+			fields["event_type"], _ = json.Marshal(test.eventType)
+			body, err := json.Marshal(fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var unmarshalled WebhookPayload
+			if err := json.Unmarshal(body, &unmarshalled); err != nil {
+				t.Fatalf("UnmarshalJSON: %v", err)
+			}
+			decoded, err := DecodeWebhookPayload(strings.NewReader(string(body)))
+			if err != nil {
+				t.Fatalf("DecodeWebhookPayload: %v", err)
+			}
+			for _, payload := range []WebhookPayload{unmarshalled, decoded} {
+				if payload.Version != test.version {
+					t.Errorf("Version = %d, want %d", payload.Version, test.version)
+				}
+				if payload.Incident.ID != "01ABC" {
+					t.Errorf("Incident.ID = %q, want %q", payload.Incident.ID, "01ABC")
+				}
+			}
+		})
+	}
+}
+
+// countingCodec is encoding/json, counting what it decodes
+type countingCodec struct {
+	standardCodec
+	unmarshals int
+	decodes    int
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.unmarshals++
+	return c.standardCodec.Unmarshal(data, v)
+}
+
+func (c *countingCodec) NewDecoder(r io.Reader) JSONDecoder {
+	return countingDecoder{json.NewDecoder(r), c}
+}
+
+type countingDecoder struct {
+	*json.Decoder
+	codec *countingCodec
+}
+
+func (d countingDecoder) Decode(v interface{}) error {
+	d.codec.decodes++
+	return d.Decoder.Decode(v)
+}
+
+// unmarshalOnlyCodec is encoding/json without stream decoding
+type unmarshalOnlyCodec struct {
+	unmarshals int
+}
+
+func (c *unmarshalOnlyCodec) Unmarshal(data []byte, v interface{}) error {
+	c.unmarshals++
+	return json.Unmarshal(data, v)
+}
+
+func TestDecodeWebhookPayloadCodecs(t *testing.T) {
+	const body = `{
+		"event_type": "public_incident.incident_updated_v2",
+		"public_incident.incident_updated_v2": {"id": "01ABC", "name": 42},
+		"previous_state": {"id": "01ABC"}
+	}`
+
+	streaming := &countingCodec{}
+	SetJSONCodec(streaming)
+	defer SetJSONCodec(nil)
+	payload, err := DecodeWebhookPayload(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streaming.unmarshals != 0 || streaming.decodes != 3 {
+		t.Errorf("streaming codec unmarshalled %d and decoded %d values, want 0 and 3", streaming.unmarshals, streaming.decodes)
+	}
+	if payload.Incident.ID != "01ABC" || payload.PreviousState == nil || len(payload.Warnings) != 1 {
+		t.Errorf("payload = %+v, want incident 01ABC with its previous state and one warning", payload)
+	}
+
+	unmarshalling := &unmarshalOnlyCodec{}
+	SetJSONCodec(unmarshalling)
+	fallback, err := DecodeWebhookPayload(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unmarshalling.unmarshals == 0 {
+		t.Error("codec without stream decoding wasn't used")
+	}
+	if !reflect.DeepEqual(fallback, payload) {
+		t.Errorf("payload read whole = %+v, want %+v", fallback, payload)
+	}
+}
+
+func TestDecodeWebhookPayloadKeyOrder(t *testing.T) {
+	bodies := []string{
+		`{"event_type": "incident.custom_field_updated", "incident": {"id": "01ABC"}, "previous_state": null}`,
+		`{"incident": {"id": "01ABC"}, "previous_state": null, "event_type": "incident.custom_field_updated"}`,
+		`{"previous_state": {"id": "01ABC"}, "event_type": "public_incident.incident_updated_v2", "public_incident.incident_updated_v2": {"id": "01ABC"}}`,
+		`{"event_type": "public_incident.incident_updated_v3", "public_incident.incident_updated_v3": {"incident": {"id": "01ABC"}, "previous_state": {"id": "01ABC"}}}`,
+	}
+	for _, body := range bodies {
+		var want WebhookPayload
+		if err := json.Unmarshal([]byte(body), &want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeWebhookPayload(strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("DecodeWebhookPayload(%s): %v", body, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DecodeWebhookPayload(%s) = %+v, want %+v", body, got, want)
+		}
+		if got.Incident.ID != "01ABC" {
+			t.Errorf("DecodeWebhookPayload(%s) incident = %q, want 01ABC", body, got.Incident.ID)
+		}
+	}
+}
+
+func TestDecodeWebhookPayloadEmptyKey(t *testing.T) {
+	// v3 payloads have no fixed incident key, so an empty key isn't taken for the incident
+	const body = `{"event_type": "public_incident.incident_updated_v3", "": {"id": "WRONG"}, "public_incident.incident_updated_v3": {"id": "01ABC"}}`
+	var want WebhookPayload
+	if err := json.Unmarshal([]byte(body), &want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeWebhookPayload(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Incident.ID != "01ABC" || want.Incident.ID != "01ABC" {
+		t.Errorf("incident = %q decoded and %q unmarshalled, want 01ABC", got.Incident.ID, want.Incident.ID)
+	}
+}
+
+func TestWebhookPayloadDuplicateEventType(t *testing.T) {
+	bodies := []string{
+		`{"event_type": "public_incident.incident_updated_v2", "event_type": "incident.custom_field_updated", "incident": {"id": "01ABC"}}`,
+		`{"event_type": "public_incident.incident_updated_v2", "incident": {"id": "01ABC"}, "event_type": "incident.custom_field_updated"}`,
+	}
+	for _, body := range bodies {
+		var payload WebhookPayload
+		if err := json.Unmarshal([]byte(body), &payload); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded, want an error", body)
+		}
+		if _, err := DecodeWebhookPayload(strings.NewReader(body)); err == nil {
+			t.Errorf("DecodeWebhookPayload(%s) succeeded, want an error", body)
+		}
+		SetJSONCodec(&unmarshalOnlyCodec{})
+		_, err := DecodeWebhookPayload(strings.NewReader(body))
+		SetJSONCodec(nil)
+		if err == nil {
+			t.Errorf("DecodeWebhookPayload(%s) without stream decoding succeeded, want an error", body)
+		}
+	}
+}
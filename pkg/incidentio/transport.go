@@ -0,0 +1,185 @@
+package incidentio
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryConfig configures how a Transport retries and rate limits requests
+type RetryConfig struct {
+	// Retries is how many times a request that is safe to repeat is retried after a transport
+	// error or one of Statuses. Delay doubles with each retry.
+	Retries  int
+	Delay    time.Duration
+	Statuses []int
+	// RetryAfterMax is the longest Retry-After waited for before a retry; responses asking for
+	// longer are returned as they are
+	RetryAfterMax time.Duration
+	// RateLimit caps the requests sent per minute, retries included (0 for no limit)
+	RateLimit int
+}
+
+// DefaultRetryConfig is how NewClient retries: twice, after transport errors, 429s and 5xx
+// responses, waiting for a Retry-After of up to 5s, and spacing requests out to stay under the
+// incident.io API's limit of 1200 requests a minute per API key
+var DefaultRetryConfig = RetryConfig{
+	Retries:       2,
+	Delay:         200 * time.Millisecond,
+	Statuses:      []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	RetryAfterMax: 5 * time.Second,
+	RateLimit:     1200,
+}
+
+// RetryReasonRetryAfterTooLong is the reason given to Transport.Retrying for a response whose
+// Retry-After is longer than RetryAfterMax; it is never retried
+const RetryReasonRetryAfterTooLong = "retry_after_too_long"
+
+// Transport retries requests after transport errors and transient statuses, waiting for
+// Retry-After, and spaces requests out to stay under a rate limit. Only requests that are safe
+// to repeat are retried: idempotent methods, and POSTs carrying an Idempotency-Key header.
+type Transport struct {
+	// Next sends each attempt; http.DefaultTransport when nil
+	Next   http.RoundTripper
+	Config RetryConfig
+	// Retrying, when set, is called before each retry with why it is made: "error" or the
+	// status code, or RetryReasonRetryAfterTooLong for a response that won't be retried.
+	// Returning false gives up and returns the last response.
+	Retrying func(req *http.Request, reason string) bool
+
+	mu     sync.Mutex
+	nextAt time.Time
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.send(req)
+	if t.Config.Retries <= 0 || !isIdempotent(req) {
+		return resp, err
+	}
+
+	delay := t.Config.Delay
+	for attempt := 1; attempt <= t.Config.Retries; attempt, delay = attempt+1, delay*2 {
+		reason := t.retryReason(resp, err)
+		if reason == "" {
+			break
+		}
+		if req.Body != nil && req.GetBody == nil {
+			break
+		}
+
+		// The server says when to come back; wait for it unless that is too long
+		wait := delay
+		if resp != nil {
+			if retryAfter, ok := RetryAfterDelay(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if retryAfter > t.Config.RetryAfterMax {
+					if t.Retrying != nil {
+						t.Retrying(req, RetryReasonRetryAfterTooLong)
+					}
+					break
+				}
+				wait = max(wait, retryAfter)
+			}
+		}
+		if t.Retrying != nil && !t.Retrying(req, reason) {
+			break
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := sleepContext(req.Context(), wait); err != nil {
+			return nil, err
+		}
+
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			retry.Body = body
+		}
+		resp, err = t.send(retry)
+	}
+	return resp, err
+}
+
+// send makes one attempt, once the rate limit allows it
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	if t.Config.RateLimit > 0 {
+		t.mu.Lock()
+		now := time.Now()
+		if t.nextAt.Before(now) {
+			t.nextAt = now
+		}
+		delay := t.nextAt.Sub(now)
+		t.nextAt = t.nextAt.Add(time.Minute / time.Duration(t.Config.RateLimit))
+		t.mu.Unlock()
+
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req)
+}
+
+// retryReason returns why a response should be retried, or "" if it should not
+func (t *Transport) retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		return "error"
+	}
+	for _, status := range t.Config.Statuses {
+		if resp.StatusCode == status {
+			return strconv.Itoa(status)
+		}
+	}
+	return ""
+}
+
+// RetryAfterDelay reads a Retry-After header, in seconds or as an HTTP date
+func RetryAfterDelay(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// isIdempotent reports whether repeating a request cannot apply a change twice
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// sleepContext waits for delay or until ctx is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
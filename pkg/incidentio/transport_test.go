@@ -0,0 +1,139 @@
+package incidentio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewClientRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"incident": {"id": "01ABC"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient("token", server.Client())
+	client.BaseURL = server.URL
+	incident, err := client.GetIncident(context.Background(), "01ABC")
+	if err != nil || incident.ID != "01ABC" {
+		t.Fatalf("GetIncident() = %v, %v, want the incident after a retry", incident, err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("%d requests, want 2", requests.Load())
+	}
+
+	// Without retries, the first answer is returned
+	requests.Store(0)
+	client = NewClientWithRetries("token", server.Client(), RetryConfig{})
+	client.BaseURL = server.URL
+	if _, err := client.GetIncident(context.Background(), "01ABC"); err == nil || requests.Load() != 1 {
+		t.Errorf("GetIncident() without retries = %v after %d requests, want the error after 1", err, requests.Load())
+	}
+}
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		header       string
+		retryAfter   string
+		status       int
+		wantStatus   int
+		wantRequests int32
+		wantReasons  []string
+	}{
+		{name: "transient status", method: http.MethodGet, status: 503, wantStatus: 200, wantRequests: 2, wantReasons: []string{"503"}},
+		{name: "other status", method: http.MethodGet, status: 404, wantStatus: 404, wantRequests: 1},
+		{name: "POST", method: http.MethodPost, status: 503, wantStatus: 503, wantRequests: 1},
+		{name: "POST with an idempotency key", method: http.MethodPost, header: "key", status: 503, wantStatus: 200, wantRequests: 2, wantReasons: []string{"503"}},
+		{name: "Retry-After", method: http.MethodGet, retryAfter: "0", status: 429, wantStatus: 200, wantRequests: 2, wantReasons: []string{"429"}},
+		{name: "Retry-After too long", method: http.MethodGet, retryAfter: "60", status: 429, wantStatus: 429, wantRequests: 1, wantReasons: []string{RetryReasonRetryAfterTooLong}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) == 1 {
+					if test.retryAfter != "" {
+						w.Header().Set("Retry-After", test.retryAfter)
+					}
+					w.WriteHeader(test.status)
+				}
+			}))
+			defer server.Close()
+
+			var reasons []string
+			config := DefaultRetryConfig
+			config.Delay = time.Millisecond
+			transport := &Transport{Next: server.Client().Transport, Config: config, Retrying: func(req *http.Request, reason string) bool {
+				reasons = append(reasons, reason)
+				return true
+			}}
+			req, _ := http.NewRequest(test.method, server.URL, nil)
+			if test.header != "" {
+				req.Header.Set("Idempotency-Key", test.header)
+			}
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus || requests.Load() != test.wantRequests {
+				t.Errorf("status %d after %d requests, want %d after %d", resp.StatusCode, requests.Load(), test.wantStatus, test.wantRequests)
+			}
+			if len(reasons) != len(test.wantReasons) || (len(reasons) > 0 && reasons[0] != test.wantReasons[0]) {
+				t.Errorf("retry reasons = %q, want %q", reasons, test.wantReasons)
+			}
+		})
+	}
+}
+
+func TestTransportRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// 600 a minute is one request every 100ms
+	transport := &Transport{Next: server.Client().Transport, Config: RetryConfig{RateLimit: 600}}
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if elapsed := time.Since(started); elapsed < 200*time.Millisecond {
+		t.Errorf("3 requests took %s, want at least 200ms", elapsed)
+	}
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "3", want: 3 * time.Second, ok: true},
+		{value: "-1", ok: false},
+		{value: "Wed, 01 May 2024 10:00:30 GMT", want: 30 * time.Second, ok: true},
+		{value: "Wed, 01 May 2024 09:59:00 GMT", want: 0, ok: true},
+		{value: "soon", ok: false},
+	}
+	for _, test := range tests {
+		if delay, ok := RetryAfterDelay(test.value, now); delay != test.want || ok != test.ok {
+			t.Errorf("RetryAfterDelay(%q) = %s, %v, want %s, %v", test.value, delay, ok, test.want, test.ok)
+		}
+	}
+}
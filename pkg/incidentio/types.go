@@ -0,0 +1,326 @@
+package incidentio
+
+import (
+	"strings"
+	"time"
+)
+
+type Incident struct {
+	ID                     string                 `json:"id"`
+	Name                   string                 `json:"name"`
+	Reference              string                 `json:"reference,omitempty"`
+	Summary                string                 `json:"summary,omitempty"`
+	Permalink              string                 `json:"permalink,omitempty"`
+	ExternalIssueReference ExternalIssueReference `json:"external_issue_reference"`
+	CustomFieldEntries     []CustomFieldEntry     `json:"custom_field_entries"`
+	IncidentStatus         IncidentStatus         `json:"incident_status"`
+	Severity               *Severity              `json:"severity,omitempty"`
+	PostmortemDocumentURL  string                 `json:"postmortem_document_url,omitempty"`
+	IncidentType           *IncidentType          `json:"incident_type,omitempty"`
+	CreatedAt              time.Time              `json:"created_at"`
+	TimestampValues        []TimestampValue       `json:"incident_timestamp_values,omitempty"`
+	RoleAssignments        []RoleAssignment       `json:"incident_role_assignments,omitempty"`
+}
+
+// Timestamp returns the value of the incident timestamp with the given name (case-insensitive),
+// if it is set
+func (i Incident) Timestamp(name string) (time.Time, bool) {
+	for _, timestamp := range i.TimestampValues {
+		if strings.EqualFold(timestamp.IncidentTimestamp.Name, name) && timestamp.Value != nil && !timestamp.Value.Value.IsZero() {
+			return timestamp.Value.Value, true
+		}
+	}
+	return time.Time{}, false
+}
+
+type IncidentStatus struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+}
+
+type IncidentType struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Severity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Rank int    `json:"rank"`
+}
+
+// TimestampValue is the value of one of the organisation's incident timestamps, e.g. "Accepted at"
+type TimestampValue struct {
+	IncidentTimestamp struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"incident_timestamp"`
+	Value *struct {
+		Value time.Time `json:"value"`
+	} `json:"value,omitempty"`
+}
+
+// RoleAssignment is a responder role on an incident, such as the incident lead, and who holds it
+type RoleAssignment struct {
+	Role struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"role"`
+	Assignee *User `json:"assignee,omitempty"`
+}
+
+type User struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// IncidentUpdate is an entry of an incident's update timeline
+type IncidentUpdate struct {
+	ID                string          `json:"id"`
+	Message           string          `json:"message"`
+	NewIncidentStatus *IncidentStatus `json:"new_incident_status,omitempty"`
+	NewSeverity       *Severity       `json:"new_severity,omitempty"`
+	Updater           struct {
+		User *User `json:"user,omitempty"`
+	} `json:"updater"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IncidentAttachment is an external resource attached to an incident, such as a Jira issue
+type IncidentAttachment struct {
+	ID       string `json:"id"`
+	Resource struct {
+		ExternalID   string `json:"external_id"`
+		Permalink    string `json:"permalink"`
+		ResourceType string `json:"resource_type"`
+		Title        string `json:"title"`
+	} `json:"resource"`
+}
+
+type ExternalIssueReference struct {
+	Provider       string `json:"provider"`
+	IssueName      string `json:"issue_name"`
+	IssuePermalink string `json:"issue_permalink"`
+}
+
+type CustomFieldEntry struct {
+	CustomField CustomField `json:"custom_field"`
+	Values      []Value     `json:"values"`
+}
+
+type CustomField struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	FieldType   string `json:"field_type"`
+	// CatalogTypeID is the catalog type of catalog fields, as listed by the custom fields API
+	CatalogTypeID string `json:"catalog_type_id,omitempty"`
+}
+
+type Value struct {
+	ValueCatalogEntry *CatalogEntry `json:"value_catalog_entry,omitempty"`
+	ValueOption       *OptionValue  `json:"value_option,omitempty"`
+	ValueText         string        `json:"value_text,omitempty"`
+	// ValueNumeric is the value of a numeric field, as a decimal string
+	ValueNumeric string `json:"value_numeric,omitempty"`
+}
+
+// CustomFieldEntry returns the entry for the custom field with the given ID, if present
+func (i Incident) CustomFieldEntry(customFieldID string) (CustomFieldEntry, bool) {
+	for _, entry := range i.CustomFieldEntries {
+		if entry.CustomField.ID == customFieldID {
+			return entry, true
+		}
+	}
+	return CustomFieldEntry{}, false
+}
+
+// RemovedCatalogEntries returns the catalog entries in previous that are no longer in current
+func RemovedCatalogEntries(previous, current CustomFieldEntry) []CatalogEntry {
+	present := make(map[string]bool)
+	for _, value := range current.Values {
+		if value.ValueCatalogEntry != nil {
+			present[value.ValueCatalogEntry.ID] = true
+		}
+	}
+
+	var removed []CatalogEntry
+	for _, value := range previous.Values {
+		if value.ValueCatalogEntry != nil && value.ValueCatalogEntry.ID != "" && !present[value.ValueCatalogEntry.ID] {
+			removed = append(removed, *value.ValueCatalogEntry)
+		}
+	}
+	return removed
+}
+
+type OptionValue struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+// Text returns the human-readable form of a value regardless of the field type
+func (v Value) Text() string {
+	switch {
+	case v.ValueText != "":
+		return v.ValueText
+	case v.ValueNumeric != "":
+		return v.ValueNumeric
+	case v.ValueOption != nil:
+		return v.ValueOption.Value
+	case v.ValueCatalogEntry != nil:
+		return v.ValueCatalogEntry.Name
+	}
+	return ""
+}
+
+type CatalogEntry struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	ExternalID string `json:"external_id"`
+}
+
+// CatalogResponse is a catalog entry together with the schema of its catalog type
+type CatalogResponse struct {
+	CatalogEntry struct {
+		ID              string                    `json:"id"`
+		Name            string                    `json:"name"`
+		ExternalID      string                    `json:"external_id"`
+		CatalogTypeID   string                    `json:"catalog_type_id"`
+		AttributeValues map[string]AttributeValue `json:"attribute_values"`
+		UpdatedAt       time.Time                 `json:"updated_at"`
+	} `json:"catalog_entry"`
+	CatalogType struct {
+		Schema struct {
+			Attributes []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"attributes"`
+		} `json:"schema"`
+	} `json:"catalog_type"`
+}
+
+// AttributeValue is the value of a catalog entry attribute: a single value, or an array for
+// attributes holding several
+type AttributeValue struct {
+	Value      AttributeValueItem   `json:"value"`
+	ArrayValue []AttributeValueItem `json:"array_value,omitempty"`
+}
+
+// AttributeValueItem is a literal, or a reference to another catalog entry
+type AttributeValueItem struct {
+	Literal      string `json:"literal,omitempty"`
+	Label        string `json:"label,omitempty"`
+	CatalogEntry *struct {
+		CatalogEntryID   string `json:"catalog_entry_id"`
+		CatalogEntryName string `json:"catalog_entry_name"`
+	} `json:"catalog_entry,omitempty"`
+}
+
+// Text returns the literal, or the name of the referenced catalog entry
+func (v AttributeValueItem) Text() string {
+	switch {
+	case v.Literal != "":
+		return v.Literal
+	case v.CatalogEntry != nil && v.CatalogEntry.CatalogEntryName != "":
+		return v.CatalogEntry.CatalogEntryName
+	}
+	return v.Label
+}
+
+// Text returns the attribute's value as text, joining array values with ", "
+func (a AttributeValue) Text() string {
+	if text := a.Value.Text(); text != "" || len(a.ArrayValue) == 0 {
+		return text
+	}
+
+	texts := make([]string, 0, len(a.ArrayValue))
+	for _, item := range a.ArrayValue {
+		if text := item.Text(); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, ", ")
+}
+
+// Texts returns the text of each value of the attribute, for attributes holding several
+func (a AttributeValue) Texts() []string {
+	if text := a.Value.Text(); text != "" || len(a.ArrayValue) == 0 {
+		if text == "" {
+			return nil
+		}
+		return []string{text}
+	}
+
+	texts := make([]string, 0, len(a.ArrayValue))
+	for _, item := range a.ArrayValue {
+		if text := item.Text(); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
+
+// CatalogEntryIDs returns the catalog entries the attribute references
+func (a AttributeValue) CatalogEntryIDs() []string {
+	var ids []string
+	for _, item := range append([]AttributeValueItem{a.Value}, a.ArrayValue...) {
+		if item.CatalogEntry != nil && item.CatalogEntry.CatalogEntryID != "" {
+			ids = append(ids, item.CatalogEntry.CatalogEntryID)
+		}
+	}
+	return ids
+}
+
+// AttributeValue returns the value of the attribute with the given name (case-insensitive)
+func (c CatalogResponse) AttributeValue(name string) (AttributeValue, bool) {
+	for _, attr := range c.CatalogType.Schema.Attributes {
+		if strings.EqualFold(attr.Name, name) {
+			attrValue, exists := c.CatalogEntry.AttributeValues[attr.ID]
+			return attrValue, exists
+		}
+	}
+	return AttributeValue{}, false
+}
+
+// Attribute returns the value of the attribute with the given name (case-insensitive) as text
+func (c CatalogResponse) Attribute(name string) (string, bool) {
+	attrValue, exists := c.AttributeValue(name)
+	return attrValue.Text(), exists
+}
+
+// Attributes returns the entry's attribute values as text, by attribute name
+func (c CatalogResponse) Attributes() map[string]string {
+	attributes := make(map[string]string, len(c.CatalogType.Schema.Attributes))
+	for _, attr := range c.CatalogType.Schema.Attributes {
+		if attrValue, exists := c.CatalogEntry.AttributeValues[attr.ID]; exists {
+			attributes[attr.Name] = attrValue.Text()
+		}
+	}
+	return attributes
+}
+
+// EditRequest is the body of the edit incident action
+type EditRequest struct {
+	Incident struct {
+		CustomFieldEntries []EditFieldEntry `json:"custom_field_entries"`
+	} `json:"incident"`
+	NotifyIncidentChannel bool `json:"notify_incident_channel"`
+}
+
+type EditFieldEntry struct {
+	CustomFieldID string  `json:"custom_field_id"`
+	Values        []Value `json:"values"`
+}
+
+// Escalation is a page sent to responders through an escalation path
+type Escalation struct {
+	ID               string    `json:"id"`
+	Title            string    `json:"title"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"created_at"`
+	RelatedIncidents []struct {
+		ID string `json:"id"`
+	} `json:"related_incidents"`
+}
@@ -0,0 +1,61 @@
+package incidentio
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWebhookPayloadIncident(t *testing.T) {
+	tests := map[string]string{
+		"legacy":     `{"event_type": "incident.custom_field_updated", "incident": {"id": "01ABC"}}`,
+		"v2 updated": `{"event_type": "public_incident.incident_updated_v2", "public_incident.incident_updated_v2": {"id": "01ABC"}}`,
+		"v2 created": `{"event_type": "public_incident.incident_created_v2", "public_incident.incident_created_v2": {"id": "01ABC"}}`,
+	}
+	for name, body := range tests {
+		var payload WebhookPayload
+		if err := json.Unmarshal([]byte(body), &payload); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if id := payload.Incident.ID; id != "01ABC" {
+			t.Errorf("%s: incident ID = %q, want 01ABC", name, id)
+		}
+	}
+}
+
+func TestValueText(t *testing.T) {
+	tests := []struct {
+		value Value
+		want  string
+	}{
+		{value: Value{ValueText: "text"}, want: "text"},
+		{value: Value{ValueOption: &OptionValue{Value: "option"}}, want: "option"},
+		{value: Value{ValueCatalogEntry: &CatalogEntry{Name: "entry"}}, want: "entry"},
+		{value: Value{}, want: ""},
+	}
+	for _, test := range tests {
+		if got := test.value.Text(); got != test.want {
+			t.Errorf("Text() of %+v = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCatalogResponseAttribute(t *testing.T) {
+	var catalog CatalogResponse
+	err := json.Unmarshal([]byte(`{
+		"catalog_entry": {"attribute_values": {"attr_1": {"value": {"literal": "PIN-3"}}}},
+		"catalog_type": {"schema": {"attributes": [{"id": "attr_1", "name": "Object Key"}, {"id": "attr_2", "name": "Owner"}]}}
+	}`), &catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, found := catalog.Attribute("object key"); !found || value != "PIN-3" {
+		t.Errorf("Attribute(object key) = %q, %v, want PIN-3, true", value, found)
+	}
+	if _, found := catalog.Attribute("Owner"); found {
+		t.Error("attribute without a value was found")
+	}
+	if _, found := catalog.Attribute("Missing"); found {
+		t.Error("attribute missing from the schema was found")
+	}
+}
@@ -0,0 +1,48 @@
+package incidentio
+
+import (
+	"context"
+	"fmt"
+)
+
+// WebhookEndpoint is a webhook subscription delivering events to a URL
+type WebhookEndpoint struct {
+	ID         string   `json:"id,omitempty"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	// SigningSecret is only returned when the endpoint is created
+	SigningSecret string `json:"signing_secret,omitempty"`
+}
+
+// ListWebhookEndpoints returns every webhook endpoint of the organisation
+func (c *Client) ListWebhookEndpoints(ctx context.Context) ([]WebhookEndpoint, error) {
+	var listResp struct {
+		WebhookEndpoints []WebhookEndpoint `json:"webhook_endpoints"`
+	}
+	if err := c.do(ctx, "GET", "/v2/webhook_endpoints", nil, &listResp); err != nil {
+		return nil, fmt.Errorf("failed to list webhook endpoints: %w", err)
+	}
+	return listResp.WebhookEndpoints, nil
+}
+
+// CreateWebhookEndpoint creates a webhook endpoint
+func (c *Client) CreateWebhookEndpoint(ctx context.Context, endpoint WebhookEndpoint) (*WebhookEndpoint, error) {
+	var createResp struct {
+		WebhookEndpoint WebhookEndpoint `json:"webhook_endpoint"`
+	}
+	if err := c.do(ctx, "POST", "/v2/webhook_endpoints", endpoint, &createResp); err != nil {
+		return nil, fmt.Errorf("failed to create webhook endpoint: %w", err)
+	}
+	return &createResp.WebhookEndpoint, nil
+}
+
+// UpdateWebhookEndpoint replaces the URL and event types of a webhook endpoint
+func (c *Client) UpdateWebhookEndpoint(ctx context.Context, endpoint WebhookEndpoint) (*WebhookEndpoint, error) {
+	var updateResp struct {
+		WebhookEndpoint WebhookEndpoint `json:"webhook_endpoint"`
+	}
+	if err := c.do(ctx, "PUT", "/v2/webhook_endpoints/"+endpoint.ID, endpoint, &updateResp); err != nil {
+		return nil, fmt.Errorf("failed to update webhook endpoint: %w", err)
+	}
+	return &updateResp.WebhookEndpoint, nil
+}
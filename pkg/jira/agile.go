@@ -0,0 +1,75 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Jira Agile API structures
+type BoardList struct {
+	Values []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"values"`
+}
+
+type Sprint struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// ProjectKey returns the project part of an issue key (e.g. 'SUP-68' -> 'SUP')
+func ProjectKey(issueKey string) string {
+	if i := strings.LastIndex(issueKey, "-"); i > 0 {
+		return issueKey[:i]
+	}
+	return issueKey
+}
+
+// FindScrumBoardID returns the ID of the first scrum board of a project
+func (c *Client) FindScrumBoardID(ctx context.Context, projectKey string) (string, error) {
+	path := fmt.Sprintf("/rest/agile/1.0/board?type=scrum&projectKeyOrId=%s", url.QueryEscape(projectKey))
+
+	var boards BoardList
+	if err := c.Get(ctx, path, &boards); err != nil {
+		return "", fmt.Errorf("failed to search boards: %w", err)
+	}
+
+	if len(boards.Values) == 0 {
+		return "", fmt.Errorf("no scrum board found for project %s", projectKey)
+	}
+
+	return fmt.Sprintf("%d", boards.Values[0].ID), nil
+}
+
+// FindSprintID resolves a sprint name (case-insensitive) to its ID among the board's active and future sprints
+func (c *Client) FindSprintID(ctx context.Context, boardID, sprintName string) (int, error) {
+	query := url.Values{}
+	query.Set("state", "active,future")
+
+	var sprints []Sprint
+	path := fmt.Sprintf("/rest/agile/1.0/board/%s/sprint", url.PathEscape(boardID))
+	err := c.getPages(ctx, path, query, func(values json.RawMessage) (int, error) {
+		var page []Sprint
+		if err := json.Unmarshal(values, &page); err != nil {
+			return 0, err
+		}
+		sprints = append(sprints, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list sprints: %w", err)
+	}
+
+	for _, sprint := range sprints {
+		if strings.EqualFold(strings.TrimSpace(sprint.Name), sprintName) {
+			return sprint.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no active or future sprint named %q on board %s", sprintName, boardID)
+}
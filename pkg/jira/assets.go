@@ -0,0 +1,102 @@
+package jira
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Jira Assets API structures
+type AssetsAttributeValue struct {
+	Value string `json:"value"`
+}
+
+type AssetsAttribute struct {
+	ObjectTypeAttributeID string                 `json:"objectTypeAttributeId"`
+	ObjectAttributeValues []AssetsAttributeValue `json:"objectAttributeValues"`
+}
+
+type AssetsCreateObjectRequest struct {
+	ObjectTypeID string            `json:"objectTypeId"`
+	Attributes   []AssetsAttribute `json:"attributes"`
+}
+
+type AssetsObject struct {
+	ID        string `json:"id"`
+	ObjectKey string `json:"objectKey"`
+	Label     string `json:"label"`
+}
+
+type AssetsAQLRequest struct {
+	QLQuery string `json:"qlQuery"`
+}
+
+type AssetsAQLResponse struct {
+	Values []AssetsObject `json:"values"`
+}
+
+// assetsURL builds an Assets API URL for a workspace, or the client's workspace when empty
+func (c *Client) assetsURL(workspaceID, path string) string {
+	if workspaceID == "" {
+		workspaceID = c.WorkspaceID
+	}
+	return fmt.Sprintf("%s/workspace/%s/v1/%s", strings.TrimRight(c.AssetsBaseURL, "/"), workspaceID, path)
+}
+
+// aqlEscaper escapes a string for use inside double quotes in AQL
+var aqlEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// FindAssetsObject returns the first object of a type in an Assets workspace whose attribute
+// equals value, or nil
+func (c *Client) FindAssetsObject(ctx context.Context, workspaceID, objectTypeID, attribute, value string) (*AssetsObject, error) {
+	query := fmt.Sprintf(`objectTypeId = %s AND "%s" = "%s"`,
+		objectTypeID,
+		aqlEscaper.Replace(attribute),
+		aqlEscaper.Replace(value))
+
+	var aqlResp AssetsAQLResponse
+	if err := c.send(ctx, "POST", c.assetsURL(workspaceID, "object/aql"), AssetsAQLRequest{QLQuery: query}, &aqlResp, "Assets"); err != nil {
+		return nil, err
+	}
+
+	if len(aqlResp.Values) == 0 {
+		return nil, nil
+	}
+
+	return &aqlResp.Values[0], nil
+}
+
+// GetAssetsObject reads an object of an Assets workspace
+func (c *Client) GetAssetsObject(ctx context.Context, workspaceID, objectID string) (*AssetsObject, error) {
+	var object AssetsObject
+	if err := c.send(ctx, "GET", c.assetsURL(workspaceID, "object/"+url.PathEscape(objectID)), nil, &object, "Assets"); err != nil {
+		return nil, err
+	}
+	return &object, nil
+}
+
+// FindAssetsObjectByKey returns the object with an object key in an Assets workspace, or nil
+func (c *Client) FindAssetsObjectByKey(ctx context.Context, workspaceID, objectKey string) (*AssetsObject, error) {
+	query := fmt.Sprintf(`Key = "%s"`, aqlEscaper.Replace(objectKey))
+
+	var aqlResp AssetsAQLResponse
+	if err := c.send(ctx, "POST", c.assetsURL(workspaceID, "object/aql"), AssetsAQLRequest{QLQuery: query}, &aqlResp, "Assets"); err != nil {
+		return nil, err
+	}
+
+	if len(aqlResp.Values) == 0 {
+		return nil, nil
+	}
+
+	return &aqlResp.Values[0], nil
+}
+
+// CreateAssetsObject creates an object in an Assets workspace
+func (c *Client) CreateAssetsObject(ctx context.Context, workspaceID string, request AssetsCreateObjectRequest) (*AssetsObject, error) {
+	var object AssetsObject
+	if err := c.send(ctx, "POST", c.assetsURL(workspaceID, "object/create"), request, &object, "Assets"); err != nil {
+		return nil, err
+	}
+	return &object, nil
+}
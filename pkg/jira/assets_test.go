@@ -0,0 +1,67 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFindAssetsObjectEscapesAQL(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/workspace/ws/v1/object/aql" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		var request AssetsAQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		query = request.QLQuery
+		w.Write([]byte(`{"values": []}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "user", "token", server.Client())
+	client.AssetsBaseURL = server.URL
+	client.WorkspaceID = "ws"
+
+	object, err := client.FindAssetsObject(context.Background(), "", "7", `Na"me`, `C:\path "quoted"\`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object != nil {
+		t.Errorf("object = %+v, want nil", object)
+	}
+	if want := `objectTypeId = 7 AND "Na\"me" = "C:\\path \"quoted\"\\"`; query != want {
+		t.Errorf("query = %s, want %s", query, want)
+	}
+}
+
+func TestAssetsObjectsInFieldWorkspace(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`{"id": "12", "objectKey": "CMP-12", "values": []}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "user", "token", server.Client())
+	client.AssetsBaseURL = server.URL
+	client.WorkspaceID = "ws"
+
+	if _, err := client.FindAssetsObject(context.Background(), "other", "7", "Name", "Payments"); err != nil {
+		t.Fatal(err)
+	}
+	object, err := client.CreateAssetsObject(context.Background(), "other", AssetsCreateObjectRequest{ObjectTypeID: "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.ID != "12" {
+		t.Errorf("object = %+v", object)
+	}
+	if len(paths) != 2 || paths[0] != "/workspace/other/v1/object/aql" || paths[1] != "/workspace/other/v1/object/create" {
+		t.Errorf("paths = %q, want both in workspace other", paths)
+	}
+}
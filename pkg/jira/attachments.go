@@ -0,0 +1,77 @@
+package jira
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+)
+
+// Attachment is a file attached to an issue
+type Attachment struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+// Attachments returns the files attached to an issue
+func (c *Client) Attachments(ctx context.Context, issueKey string) ([]Attachment, error) {
+	var issue struct {
+		Fields struct {
+			Attachment []Attachment `json:"attachment"`
+		} `json:"fields"`
+	}
+	if err := c.Get(ctx, IssuePath(issueKey)+"?fields=attachment", &issue); err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	return issue.Fields.Attachment, nil
+}
+
+// AddAttachment uploads content as a file attached to an issue
+func (c *Client) AddAttachment(ctx context.Context, issueKey, filename string, content []byte) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return fmt.Errorf("failed to build attachment: %w", err)
+	}
+	part.Write(content)
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to build attachment: %w", err)
+	}
+
+	url := c.BaseURL + IssuePath(issueKey) + "/attachments"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	c.authenticate(req)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	// Jira rejects multipart uploads without this header as a CSRF protection
+	req.Header.Set("X-Atlassian-Token", "no-check")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Jira API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		log.Printf("Jira API error response: %s", c.redact(respBody))
+		return newAPIError("Jira", resp.StatusCode, respBody)
+	}
+
+	if c.Cache != nil {
+		c.Cache.InvalidatePrefix(c.BaseURL + IssuePath(issueKey))
+	}
+
+	log.Printf("Attached %s (%d bytes) to %s", filename, len(content), issueKey)
+	return nil
+}
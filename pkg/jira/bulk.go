@@ -0,0 +1,124 @@
+package jira
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// MaxBulkIssues is the most issues one bulk edit can change
+const MaxBulkIssues = 1000
+
+// Bulk task states that end a task
+const (
+	BulkTaskComplete  = "COMPLETE"
+	BulkTaskFailed    = "FAILED"
+	BulkTaskCancelled = "CANCELLED"
+	BulkTaskDead      = "DEAD"
+)
+
+// Field is a Jira field, system or custom
+type Field struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Custom bool   `json:"custom"`
+	Schema struct {
+		Type   string `json:"type"`
+		Items  string `json:"items,omitempty"`
+		Custom string `json:"custom,omitempty"`
+	} `json:"schema"`
+}
+
+// BulkEdit is a bulk edit setting the same field values on several issues
+type BulkEdit struct {
+	IssueKeys []string
+	FieldIDs  []string
+	// Input is the editedFieldsInput of the request: field values grouped by kind, such as
+	// singleLineTextFields or clearableNumberFields
+	Input map[string]interface{}
+}
+
+// BulkTask is the progress of a bulk edit
+type BulkTask struct {
+	TaskID          string `json:"taskId"`
+	Status          string `json:"status"`
+	ProgressPercent int    `json:"progressPercent"`
+	TotalIssueCount int    `json:"totalIssueCount"`
+	// FailedAccessibleIssues are the errors of issues that could not be edited, by issue ID
+	FailedAccessibleIssues          map[string][]string `json:"failedAccessibleIssues"`
+	InvalidOrInaccessibleIssueCount int                 `json:"invalidOrInaccessibleIssueCount"`
+}
+
+// Done reports whether the task has stopped
+func (t BulkTask) Done() bool {
+	switch t.Status {
+	case BulkTaskComplete, BulkTaskFailed, BulkTaskCancelled, BulkTaskDead:
+		return true
+	}
+	return false
+}
+
+// Succeeded reports whether the task completed with every issue edited
+func (t BulkTask) Succeeded() bool {
+	return t.Status == BulkTaskComplete && len(t.FailedAccessibleIssues) == 0 && t.InvalidOrInaccessibleIssueCount == 0
+}
+
+// Fields returns every field of the Jira site
+func (c *Client) Fields(ctx context.Context) ([]Field, error) {
+	var fields []Field
+	if err := c.Get(ctx, "/rest/api/3/field", &fields); err != nil {
+		return nil, fmt.Errorf("failed to list fields: %w", err)
+	}
+	return fields, nil
+}
+
+// SubmitBulkEdit queues a bulk edit without notifications and returns its task ID
+func (c *Client) SubmitBulkEdit(ctx context.Context, edit BulkEdit) (string, error) {
+	if len(edit.IssueKeys) > MaxBulkIssues {
+		return "", fmt.Errorf("a bulk edit can change at most %d issues, not %d", MaxBulkIssues, len(edit.IssueKeys))
+	}
+
+	payload := map[string]interface{}{
+		"selectedIssueIdsOrKeys": edit.IssueKeys,
+		"selectedActions":        edit.FieldIDs,
+		"editedFieldsInput":      edit.Input,
+		"sendBulkNotification":   false,
+	}
+	var submitResp struct {
+		TaskID string `json:"taskId"`
+	}
+	if err := c.Do(ctx, "POST", "/rest/api/3/bulk/issues/fields", payload, &submitResp); err != nil {
+		return "", fmt.Errorf("failed to submit bulk edit: %w", err)
+	}
+	return submitResp.TaskID, nil
+}
+
+// GetBulkTask returns the progress of a bulk edit
+func (c *Client) GetBulkTask(ctx context.Context, taskID string) (*BulkTask, error) {
+	// Bypass the response cache: progress changes between polls
+	var task BulkTask
+	if err := c.send(ctx, "GET", fmt.Sprintf("%s/rest/api/3/bulk/queue/%s", c.BaseURL, url.PathEscape(taskID)), nil, &task, "Jira"); err != nil {
+		return nil, fmt.Errorf("failed to read bulk task %s: %w", taskID, err)
+	}
+	return &task, nil
+}
+
+// WaitForBulkTask polls a bulk edit every interval until it stops
+func (c *Client) WaitForBulkTask(ctx context.Context, taskID string, interval time.Duration) (*BulkTask, error) {
+	for {
+		task, err := c.GetBulkTask(ctx, taskID)
+		if err != nil {
+			return nil, err
+		}
+		if task.Done() {
+			return task, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return task, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package jira
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachedResponse is a Jira GET response body kept for conditional revalidation
+type cachedResponse struct {
+	ETag     string
+	Body     []byte
+	StoredAt time.Time
+}
+
+// ResponseCache caches Jira GET responses by URL. Entries are revalidated with
+// If-None-Match unless they are younger than the TTL.
+type ResponseCache struct {
+	mu         sync.Mutex
+	entries    map[string]cachedResponse
+	ttl        time.Duration
+	maxEntries int
+}
+
+// NewResponseCache returns a cache holding at most maxEntries responses
+func NewResponseCache(ttl time.Duration, maxEntries int) *ResponseCache {
+	return &ResponseCache{
+		entries:    make(map[string]cachedResponse),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+func (c *ResponseCache) get(url string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, exists := c.entries[url]
+	return entry, exists
+}
+
+func (c *ResponseCache) put(url string, entry cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Evict the oldest entry once full
+	if _, exists := c.entries[url]; !exists && len(c.entries) >= c.maxEntries {
+		var oldestURL string
+		var oldest time.Time
+		for key, existing := range c.entries {
+			if oldestURL == "" || existing.StoredAt.Before(oldest) {
+				oldestURL, oldest = key, existing.StoredAt
+			}
+		}
+		delete(c.entries, oldestURL)
+	}
+
+	c.entries[url] = entry
+}
+
+// InvalidatePrefix drops every cached response whose URL starts with prefix
+func (c *ResponseCache) InvalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for url := range c.entries {
+		if strings.HasPrefix(url, prefix) {
+			delete(c.entries, url)
+		}
+	}
+}
+
+// Len returns the number of cached responses
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,171 @@
+package jira
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// issueServer serves one issue with an ETag, counting the requests it gets
+type issueServer struct {
+	*httptest.Server
+	mu          sync.Mutex
+	requests    int
+	revalidated int
+	users       []string
+}
+
+func newIssueServer(t *testing.T) *issueServer {
+	s := &issueServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		username, _, _ := r.BasicAuth()
+		s.users = append(s.users, username)
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		if r.Header.Get("If-None-Match") == `"1"` {
+			s.revalidated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`{"key": "INC-1"}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *issueServer) counts() (requests, revalidated int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.revalidated
+}
+
+func getIssue(t *testing.T, ctx context.Context, client *Client, path string) {
+	t.Helper()
+	var issue Issue
+	if err := client.Get(ctx, path, &issue); err != nil {
+		t.Fatal(err)
+	}
+	if issue.Key != "INC-1" {
+		t.Fatalf("issue key = %q, want INC-1", issue.Key)
+	}
+}
+
+func TestCacheServesWithinTTL(t *testing.T) {
+	server := newIssueServer(t)
+	client := NewClient(server.URL, "user", "token", server.Client())
+	client.Cache = NewResponseCache(time.Hour, 10)
+	ctx := context.Background()
+
+	getIssue(t, ctx, client, IssuePath("INC-1"))
+	getIssue(t, ctx, client, IssuePath("INC-1"))
+	if requests, _ := server.counts(); requests != 1 {
+		t.Errorf("requests = %d, want 1", requests)
+	}
+}
+
+func TestCacheRevalidates(t *testing.T) {
+	server := newIssueServer(t)
+	client := NewClient(server.URL, "user", "token", server.Client())
+	client.Cache = NewResponseCache(0, 10)
+	ctx := context.Background()
+
+	getIssue(t, ctx, client, IssuePath("INC-1"))
+	getIssue(t, ctx, client, IssuePath("INC-1"))
+	if requests, revalidated := server.counts(); requests != 2 || revalidated != 1 {
+		t.Errorf("requests = %d, revalidated = %d, want 2 and 1", requests, revalidated)
+	}
+}
+
+func TestGetFreshRevalidatesWithinTTL(t *testing.T) {
+	server := newIssueServer(t)
+	client := NewClient(server.URL, "user", "token", server.Client())
+	client.Cache = NewResponseCache(time.Hour, 10)
+	ctx := context.Background()
+
+	getIssue(t, ctx, client, IssuePath("INC-1"))
+	var issue Issue
+	if err := client.GetFresh(ctx, IssuePath("INC-1"), &issue); err != nil || issue.Key != "INC-1" {
+		t.Fatalf("GetFresh() = %+v, %v", issue, err)
+	}
+	if requests, revalidated := server.counts(); requests != 2 || revalidated != 1 {
+		t.Errorf("requests = %d, revalidated = %d, want 2 and 1", requests, revalidated)
+	}
+}
+
+func TestCacheIsPerCredentials(t *testing.T) {
+	server := newIssueServer(t)
+	client := NewClient(server.URL, "user", "token", server.Client())
+	client.Cache = NewResponseCache(time.Hour, 10)
+	ctx := context.Background()
+	serviceCtx := WithCredentials(ctx, Credentials{Username: "service", APIToken: "secret"})
+
+	getIssue(t, ctx, client, IssuePath("INC-1"))
+	getIssue(t, serviceCtx, client, IssuePath("INC-1"))
+	getIssue(t, serviceCtx, client, IssuePath("INC-1"))
+	if requests, _ := server.counts(); requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+	if want := []string{"user", "service"}; len(server.users) != 2 || server.users[0] != want[0] || server.users[1] != want[1] {
+		t.Errorf("requests authenticated as %q, want %q", server.users, want)
+	}
+
+	// A write invalidates every account's responses under the path
+	if err := client.UpdateIssue(ctx, "INC-1", UpdateRequest{Fields: map[string]interface{}{"summary": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if entries := client.Cache.Len(); entries != 0 {
+		t.Errorf("cached responses after a write = %d, want 0", entries)
+	}
+}
+
+func TestCacheInvalidatesOnWrite(t *testing.T) {
+	server := newIssueServer(t)
+	client := NewClient(server.URL, "user", "token", server.Client())
+	client.Cache = NewResponseCache(time.Hour, 10)
+	ctx := context.Background()
+
+	getIssue(t, ctx, client, IssuePath("INC-1"))
+	getIssue(t, ctx, client, IssuePath("INC-10"))
+	if err := client.UpdateIssue(ctx, "INC-1", UpdateRequest{Fields: map[string]interface{}{"summary": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The prefix of INC-1 also covers INC-10, which is only refetched
+	if entries := client.Cache.Len(); entries != 0 {
+		t.Errorf("cached responses after a write = %d, want 0", entries)
+	}
+	getIssue(t, ctx, client, IssuePath("INC-1"))
+	if requests, _ := server.counts(); requests != 4 {
+		t.Errorf("requests = %d, want 4", requests)
+	}
+}
+
+func TestCacheEvictsOldest(t *testing.T) {
+	cache := NewResponseCache(time.Hour, 2)
+	now := time.Now()
+	cache.put("a", cachedResponse{StoredAt: now.Add(-time.Minute)})
+	cache.put("b", cachedResponse{StoredAt: now.Add(-2 * time.Minute)})
+	cache.put("a", cachedResponse{StoredAt: now})
+	cache.put("c", cachedResponse{StoredAt: now})
+
+	if entries := cache.Len(); entries != 2 {
+		t.Errorf("entries = %d, want 2", entries)
+	}
+	if _, exists := cache.get("b"); exists {
+		t.Error("oldest entry b wasn't evicted")
+	}
+	for _, url := range []string{"a", "c"} {
+		if _, exists := cache.get(url); !exists {
+			t.Errorf("entry %s was evicted", url)
+		}
+	}
+}
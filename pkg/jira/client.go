@@ -0,0 +1,252 @@
+// Package jira is a client for the Jira Cloud REST, Agile and Assets APIs, covering what is
+// needed to keep Jira issues in sync with incidents.
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultAssetsBaseURL is the Jira Service Management Assets API
+const DefaultAssetsBaseURL = "https://api.atlassian.com/jsm/assets"
+
+// Client calls the Jira APIs with basic authentication
+type Client struct {
+	BaseURL    string
+	Username   string
+	APIToken   string
+	HTTPClient *http.Client
+	// Cache, when set, caches GET responses; writes invalidate the cached responses under their path
+	Cache *ResponseCache
+	// AssetsBaseURL and WorkspaceID locate the Assets API
+	AssetsBaseURL string
+	WorkspaceID   string
+	// Pagination bounds the list calls that follow pages
+	Pagination Pagination
+	// Redact, when set, is applied to request and error response bodies before they are logged
+	Redact func(body []byte) string
+}
+
+// NewClient returns a client for the Jira site at baseURL using httpClient
+func NewClient(baseURL, username, apiToken string, httpClient *http.Client) *Client {
+	return &Client{
+		BaseURL:       baseURL,
+		Username:      username,
+		APIToken:      apiToken,
+		HTTPClient:    httpClient,
+		AssetsBaseURL: DefaultAssetsBaseURL,
+		Pagination:    DefaultPagination,
+	}
+}
+
+func (c *Client) redact(body []byte) string {
+	if c.Redact == nil {
+		return string(body)
+	}
+	return c.Redact(body)
+}
+
+// IssuePath returns the REST path of an issue
+func IssuePath(issueKey string) string {
+	return fmt.Sprintf("/rest/api/3/issue/%s", issueKey)
+}
+
+// Get performs a GET against the Jira REST API, serving from and revalidating the response cache
+func (c *Client) Get(ctx context.Context, path string, out interface{}) error {
+	return c.get(ctx, path, out, false)
+}
+
+// GetFresh performs a GET that always reaches Jira, revalidating a cached response with its
+// ETag even within the cache TTL. Reads that look for changes made in Jira, such as drift
+// checks and read-after-write verification, use it.
+func (c *Client) GetFresh(ctx context.Context, path string, out interface{}) error {
+	return c.get(ctx, path, out, true)
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}, fresh bool) error {
+	url := fmt.Sprintf("%s%s", c.BaseURL, path)
+	// Accounts may see different things, so each caches its own responses; the suffix keeps
+	// them under the path for invalidation
+	cacheKey := url
+	if credentials, ok := CredentialsFrom(ctx); ok {
+		cacheKey += "#" + credentials.Username
+	}
+
+	var cached cachedResponse
+	var hasCached bool
+	if c.Cache != nil {
+		cached, hasCached = c.Cache.get(cacheKey)
+		if hasCached && !fresh && c.Cache.ttl > 0 && time.Since(cached.StoredAt) < c.Cache.ttl {
+			return json.Unmarshal(cached.Body, out)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	c.authenticate(req)
+	req.Header.Set("Accept", "application/json")
+	if hasCached && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Jira API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && hasCached {
+		cached.StoredAt = time.Now()
+		c.Cache.put(cacheKey, cached)
+		return json.Unmarshal(cached.Body, out)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Jira API error response: %s", c.redact(body))
+		return newAPIError("Jira", resp.StatusCode, body)
+	}
+
+	if c.Cache != nil {
+		if etag := resp.Header.Get("ETag"); etag != "" || c.Cache.ttl > 0 {
+			c.Cache.put(cacheKey, cachedResponse{ETag: etag, Body: body, StoredAt: time.Now()})
+		}
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+// Do sends a write request to the Jira REST API and decodes the JSON response into out,
+// when out is non-nil. Cached responses under the request path are discarded.
+func (c *Client) Do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	return c.send(ctx, method, fmt.Sprintf("%s%s", c.BaseURL, path), body, out, "Jira")
+}
+
+func (c *Client) send(ctx context.Context, method, url string, body interface{}, out interface{}, api string) error {
+	var payload io.Reader
+	if body != nil {
+		payloadBytes, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		payload = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	c.authenticate(req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s API: %w", api, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		respBody, _ := io.ReadAll(resp.Body)
+		log.Printf("%s API error response: %s", api, c.redact(respBody))
+		return newAPIError(api, resp.StatusCode, respBody)
+	}
+
+	if c.Cache != nil {
+		c.Cache.InvalidatePrefix(url)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateIssue sends an edit request for an issue
+func (c *Client) UpdateIssue(ctx context.Context, issueKey string, update UpdateRequest) error {
+	fieldIDs := update.FieldIDs()
+
+	payloadBytes, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	log.Printf("Updating Jira %s with payload: %s", issueKey, c.redact(payloadBytes))
+
+	if err := c.Do(ctx, "PUT", IssuePath(issueKey), update, nil); err != nil {
+		return err
+	}
+
+	log.Printf("Successfully updated %s in %s", strings.Join(fieldIDs, ", "), issueKey)
+	return nil
+}
+
+// AddComment adds a plain-text comment to an issue, ending with a paragraph that @mentions the
+// given accounts, which notifies them
+func (c *Client) AddComment(ctx context.Context, issueKey, text string, mentionAccountIDs ...string) error {
+	body := PlainTextDocument(text)
+	if len(mentionAccountIDs) > 0 {
+		var mentions []interface{}
+		for i, accountID := range mentionAccountIDs {
+			if i > 0 {
+				mentions = append(mentions, map[string]interface{}{"type": "text", "text": " "})
+			}
+			mentions = append(mentions, map[string]interface{}{
+				"type":  "mention",
+				"attrs": map[string]interface{}{"id": accountID},
+			})
+		}
+		paragraphs, _ := body["content"].([]interface{})
+		body["content"] = append(paragraphs, map[string]interface{}{"type": "paragraph", "content": mentions})
+	}
+
+	payload := map[string]interface{}{"body": body}
+	return c.Do(ctx, "POST", IssuePath(issueKey)+"/comment", payload, nil)
+}
+
+// PlainTextDocument converts plain text to Atlassian Document Format, which Jira REST v3 takes
+// for comments and rich text fields. Blank lines separate paragraphs; other line breaks are kept.
+func PlainTextDocument(text string) map[string]interface{} {
+	var paragraphs []interface{}
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		paragraph = strings.Trim(paragraph, "\n")
+		if paragraph == "" {
+			continue
+		}
+
+		var content []interface{}
+		for i, line := range strings.Split(paragraph, "\n") {
+			if i > 0 {
+				content = append(content, map[string]interface{}{"type": "hardBreak"})
+			}
+			if line != "" {
+				content = append(content, map[string]interface{}{"type": "text", "text": line})
+			}
+		}
+		paragraphs = append(paragraphs, map[string]interface{}{"type": "paragraph", "content": content})
+	}
+
+	return map[string]interface{}{
+		"type":    "doc",
+		"version": 1,
+		"content": paragraphs,
+	}
+}
@@ -0,0 +1,35 @@
+package jira
+
+import (
+	"context"
+	"net/http"
+)
+
+// Credentials are the basic authentication of a Jira account
+type Credentials struct {
+	Username string
+	APIToken string
+}
+
+type credentialsKey struct{}
+
+// WithCredentials returns a context whose requests authenticate as another account than the
+// client's, e.g. a service account with Assets permissions on some projects
+func WithCredentials(ctx context.Context, credentials Credentials) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, credentials)
+}
+
+// CredentialsFrom returns the credentials overriding the client's in ctx, if any
+func CredentialsFrom(ctx context.Context) (Credentials, bool) {
+	credentials, ok := ctx.Value(credentialsKey{}).(Credentials)
+	return credentials, ok
+}
+
+// authenticate sets the basic authentication of a request, preferring credentials from its context
+func (c *Client) authenticate(req *http.Request) {
+	if credentials, ok := CredentialsFrom(req.Context()); ok {
+		req.SetBasicAuth(credentials.Username, credentials.APIToken)
+		return
+	}
+	req.SetBasicAuth(c.Username, c.APIToken)
+}
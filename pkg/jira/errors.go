@@ -0,0 +1,99 @@
+package jira
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Errors an APIError wraps by status, for errors.Is
+var (
+	// ErrNotFound is an issue, field or object that doesn't exist or isn't visible to the user
+	ErrNotFound = errors.New("not found")
+	// ErrPermission is a request the credentials may not make
+	ErrPermission = errors.New("permission denied")
+	// ErrValidation is a request Jira rejected as invalid, e.g. a value a field doesn't take
+	ErrValidation = errors.New("validation failed")
+	// ErrConflict is an edit that collided with another change to the issue, which a retry may
+	// get past
+	ErrConflict = errors.New("conflict")
+)
+
+// APIError is an error response from a Jira API, with the messages Jira gave
+type APIError struct {
+	API        string
+	StatusCode int
+	// Messages are Jira's errorMessages, not tied to a field
+	Messages []string
+	// FieldErrors maps field IDs to the reason Jira rejected their values
+	FieldErrors map[string]string
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("%s API request failed with status: %d", e.API, e.StatusCode)
+	details := append([]string(nil), e.Messages...)
+	fieldIDs := make([]string, 0, len(e.FieldErrors))
+	for fieldID := range e.FieldErrors {
+		fieldIDs = append(fieldIDs, fieldID)
+	}
+	sort.Strings(fieldIDs)
+	for _, fieldID := range fieldIDs {
+		details = append(details, fieldID+": "+e.FieldErrors[fieldID])
+	}
+	if len(details) == 0 {
+		return message
+	}
+	return message + ": " + strings.Join(details, "; ")
+}
+
+// Unwrap returns the error the status stands for, if any
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrPermission
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrValidation
+	case http.StatusConflict:
+		return ErrConflict
+	}
+	return nil
+}
+
+// newAPIError reads the messages out of an error response body. Jira REST and Assets answer
+// {"errorMessages": [...], "errors": {"field": "reason"}}; other bodies are left out.
+func newAPIError(api string, statusCode int, body []byte) *APIError {
+	apiErr := &APIError{API: api, StatusCode: statusCode}
+	var response struct {
+		ErrorMessages []string        `json:"errorMessages"`
+		Errors        json.RawMessage `json:"errors"`
+	}
+	if json.Unmarshal(body, &response) != nil {
+		return apiErr
+	}
+	apiErr.Messages = response.ErrorMessages
+	// Some APIs answer errors as a list rather than by field
+	var fieldErrors map[string]string
+	if json.Unmarshal(response.Errors, &fieldErrors) == nil && len(fieldErrors) > 0 {
+		apiErr.FieldErrors = fieldErrors
+	}
+	return apiErr
+}
+
+// FieldErrors returns the per-field reasons of a Jira validation error, or nil
+func FieldErrors(err error) map[string]string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.FieldErrors
+	}
+	return nil
+}
+
+// IsPermanent reports whether err is an error response that won't succeed when retried
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrPermission) || errors.Is(err, ErrValidation)
+}
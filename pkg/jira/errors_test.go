@@ -0,0 +1,112 @@
+package jira
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAPIErrorStatuses(t *testing.T) {
+	tests := []struct {
+		status    int
+		want      error
+		permanent bool
+	}{
+		{status: http.StatusNotFound, want: ErrNotFound, permanent: true},
+		{status: http.StatusUnauthorized, want: ErrPermission, permanent: true},
+		{status: http.StatusForbidden, want: ErrPermission, permanent: true},
+		{status: http.StatusBadRequest, want: ErrValidation, permanent: true},
+		{status: http.StatusConflict, want: ErrConflict, permanent: false},
+		{status: http.StatusUnprocessableEntity, want: ErrValidation, permanent: true},
+		{status: http.StatusTooManyRequests},
+		{status: http.StatusInternalServerError},
+		{status: http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			// Wrapped as callers wrap it
+			err := fmt.Errorf("failed to update issue: %w", newAPIError("Jira", test.status, nil))
+			if unwrapped := errors.Unwrap(errors.Unwrap(err)); unwrapped != test.want {
+				t.Errorf("Unwrap() = %v, want %v", unwrapped, test.want)
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("errors.Is(%v, %v) = false", err, test.want)
+			}
+			if permanent := IsPermanent(err); permanent != test.permanent {
+				t.Errorf("IsPermanent() = %v, want %v", permanent, test.permanent)
+			}
+		})
+	}
+}
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		messages    []string
+		fieldErrors map[string]string
+		message     string
+	}{
+		{
+			name:        "messages and field errors",
+			body:        `{"errorMessages": ["Issue is closed"], "errors": {"customfield_2": "Not an option", "customfield_1": "Required"}}`,
+			messages:    []string{"Issue is closed"},
+			fieldErrors: map[string]string{"customfield_1": "Required", "customfield_2": "Not an option"},
+			message:     "Jira API request failed with status: 400: Issue is closed; customfield_1: Required; customfield_2: Not an option",
+		},
+		{
+			name:    "errors as a list",
+			body:    `{"errorMessages": [], "errors": [{"message": "Bad AQL"}]}`,
+			message: "Jira API request failed with status: 400",
+		},
+		{
+			name:    "not JSON",
+			body:    `<html>Bad Request</html>`,
+			message: "Jira API request failed with status: 400",
+		},
+		{
+			name:    "empty",
+			message: "Jira API request failed with status: 400",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiErr := newAPIError("Jira", http.StatusBadRequest, []byte(test.body))
+			if len(apiErr.Messages) != len(test.messages) || len(test.messages) > 0 && !reflect.DeepEqual(apiErr.Messages, test.messages) {
+				t.Errorf("Messages = %q, want %q", apiErr.Messages, test.messages)
+			}
+			if !reflect.DeepEqual(apiErr.FieldErrors, test.fieldErrors) {
+				t.Errorf("FieldErrors = %v, want %v", apiErr.FieldErrors, test.fieldErrors)
+			}
+			if message := apiErr.Error(); message != test.message {
+				t.Errorf("Error() = %q, want %q", message, test.message)
+			}
+		})
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors": {"customfield_1": "Not an option"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "user", "token", server.Client())
+	err := client.UpdateIssue(context.Background(), "INC-1", UpdateRequest{Fields: map[string]interface{}{"customfield_1": "x"}})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("UpdateIssue() = %v, want a validation error", err)
+	}
+	if fieldErrors := FieldErrors(err); !reflect.DeepEqual(fieldErrors, map[string]string{"customfield_1": "Not an option"}) {
+		t.Errorf("FieldErrors() = %v", fieldErrors)
+	}
+	if fieldErrors := FieldErrors(errors.New("timeout")); fieldErrors != nil {
+		t.Errorf("FieldErrors() of a non-API error = %v, want nil", fieldErrors)
+	}
+}
@@ -0,0 +1,107 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// IssueProjectID returns the ID of the project an issue belongs to
+func (c *Client) IssueProjectID(ctx context.Context, issueKey string) (string, error) {
+	var issue struct {
+		Fields struct {
+			Project struct {
+				ID string `json:"id"`
+			} `json:"project"`
+		} `json:"fields"`
+	}
+	if err := c.Get(ctx, IssuePath(issueKey)+"?fields=project", &issue); err != nil {
+		return "", fmt.Errorf("failed to read project of %s: %w", issueKey, err)
+	}
+	return issue.Fields.Project.ID, nil
+}
+
+// FieldContextID returns the ID of the custom field context that applies to a project: the
+// project's own context, or else the global context. Team-managed fields have a single context.
+func (c *Client) FieldContextID(ctx context.Context, fieldID, projectID string) (string, error) {
+	query := url.Values{}
+	query.Set("projectId", projectID)
+
+	type contextMapping struct {
+		ContextID       string `json:"contextId"`
+		ProjectID       string `json:"projectId"`
+		IsGlobalContext bool   `json:"isGlobalContext"`
+	}
+	var mappings []contextMapping
+	path := fmt.Sprintf("/rest/api/3/field/%s/context/projectmapping", url.PathEscape(fieldID))
+	err := c.getPages(ctx, path, query, func(values json.RawMessage) (int, error) {
+		var page []contextMapping
+		if err := json.Unmarshal(values, &page); err != nil {
+			return 0, err
+		}
+		mappings = append(mappings, page...)
+		return len(page), nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read contexts of %s: %w", fieldID, err)
+	}
+
+	globalContextID := ""
+	for _, mapping := range mappings {
+		if mapping.ProjectID == projectID {
+			return mapping.ContextID, nil
+		}
+		if mapping.IsGlobalContext {
+			globalContextID = mapping.ContextID
+		}
+	}
+	if globalContextID == "" {
+		return "", fmt.Errorf("no context of %s applies to project %s", fieldID, projectID)
+	}
+	return globalContextID, nil
+}
+
+// CreateFieldOption adds an option to a select field context
+func (c *Client) CreateFieldOption(ctx context.Context, fieldID, contextID, value string) error {
+	payload := map[string]interface{}{
+		"options": []map[string]interface{}{{"value": value, "disabled": false}},
+	}
+	path := fmt.Sprintf("/rest/api/3/field/%s/context/%s/option", url.PathEscape(fieldID), url.PathEscape(contextID))
+	if err := c.Do(ctx, "POST", path, payload, nil); err != nil {
+		return fmt.Errorf("failed to create option %q of %s: %w", value, fieldID, err)
+	}
+	return nil
+}
+
+// AssetsFieldWorkspaceID returns the Assets workspace an Assets object field context is
+// configured with, or "" if the context has no workspace configured
+func (c *Client) AssetsFieldWorkspaceID(ctx context.Context, fieldID, contextID string) (string, error) {
+	query := url.Values{}
+	query.Set("contextId", contextID)
+
+	type contextConfiguration struct {
+		FieldContextID string `json:"fieldContextId"`
+		Configuration  struct {
+			WorkspaceID string `json:"workspaceId"`
+		} `json:"configuration"`
+	}
+	workspaceID := ""
+	path := fmt.Sprintf("/rest/api/3/app/field/%s/context/configuration", url.PathEscape(fieldID))
+	err := c.getPages(ctx, path, query, func(values json.RawMessage) (int, error) {
+		var page []contextConfiguration
+		if err := json.Unmarshal(values, &page); err != nil {
+			return 0, err
+		}
+		for _, configuration := range page {
+			if workspaceID == "" && configuration.FieldContextID == contextID {
+				workspaceID = configuration.Configuration.WorkspaceID
+			}
+		}
+		return len(page), nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read configuration of %s: %w", fieldID, err)
+	}
+	return workspaceID, nil
+}
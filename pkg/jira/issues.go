@@ -0,0 +1,164 @@
+package jira
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GetIssueProperty decodes the value of an issue property into out
+func (c *Client) GetIssueProperty(ctx context.Context, issueKey, propertyKey string, out interface{}) error {
+	property := struct {
+		Value interface{} `json:"value"`
+	}{Value: out}
+	return c.Get(ctx, issuePropertyPath(issueKey, propertyKey), &property)
+}
+
+// SetIssueProperty stores value as an issue property
+func (c *Client) SetIssueProperty(ctx context.Context, issueKey, propertyKey string, value interface{}) error {
+	return c.Do(ctx, "PUT", issuePropertyPath(issueKey, propertyKey), value, nil)
+}
+
+func issuePropertyPath(issueKey, propertyKey string) string {
+	return fmt.Sprintf("%s/properties/%s", IssuePath(issueKey), url.PathEscape(propertyKey))
+}
+
+// RemoteLinks returns the issue's remote links with the given global ID
+func (c *Client) RemoteLinks(ctx context.Context, issueKey, globalID string) ([]RemoteLink, error) {
+	var links []RemoteLink
+	err := c.Get(ctx, fmt.Sprintf("%s/remotelink?globalId=%s", IssuePath(issueKey), url.QueryEscape(globalID)), &links)
+	return links, err
+}
+
+// PutRemoteLink creates a remote link, or replaces the one with the same global ID
+func (c *Client) PutRemoteLink(ctx context.Context, issueKey string, link RemoteLink) error {
+	return c.Do(ctx, "POST", IssuePath(issueKey)+"/remotelink", link, nil)
+}
+
+// TransitionIssue applies the transition whose name, or target status name, matches name
+// (case-insensitive) and returns it
+func (c *Client) TransitionIssue(ctx context.Context, issueKey, name string) (*Transition, error) {
+	var transitions struct {
+		Transitions []Transition `json:"transitions"`
+	}
+	if err := c.Get(ctx, IssuePath(issueKey)+"/transitions", &transitions); err != nil {
+		return nil, fmt.Errorf("failed to list transitions: %w", err)
+	}
+
+	for _, transition := range transitions.Transitions {
+		if strings.EqualFold(transition.Name, name) || strings.EqualFold(transition.To.Name, name) {
+			payload := map[string]interface{}{"transition": map[string]string{"id": transition.ID}}
+			if err := c.Do(ctx, "POST", IssuePath(issueKey)+"/transitions", payload, nil); err != nil {
+				return nil, err
+			}
+			return &transition, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no transition named %q available from the current status", name)
+}
+
+// CreateIssue creates an issue with the given fields, keyed by field ID, and returns it with
+// its key
+func (c *Client) CreateIssue(ctx context.Context, fields map[string]interface{}) (*Issue, error) {
+	var created Issue
+	if err := c.Do(ctx, "POST", "/rest/api/3/issue", map[string]interface{}{"fields": fields}, &created); err != nil {
+		return nil, fmt.Errorf("failed to create issue: %w", err)
+	}
+	if created.Key == "" {
+		return nil, fmt.Errorf("failed to create issue: Jira returned no issue key")
+	}
+	return &created, nil
+}
+
+// SearchIssues returns every issue matching jql with the requested fields
+func (c *Client) SearchIssues(ctx context.Context, jql string, fields []string) ([]Issue, error) {
+	return c.searchIssues(ctx, jql, fields, false)
+}
+
+// SearchIssuesFresh is SearchIssues revalidating every page with Jira, like GetFresh
+func (c *Client) SearchIssuesFresh(ctx context.Context, jql string, fields []string) ([]Issue, error) {
+	return c.searchIssues(ctx, jql, fields, true)
+}
+
+func (c *Client) searchIssues(ctx context.Context, jql string, fields []string, fresh bool) ([]Issue, error) {
+	var issues []Issue
+	nextPageToken := ""
+	for pages := 0; ; pages++ {
+		if pages >= c.Pagination.MaxPages {
+			return issues, fmt.Errorf("failed to search issues: %w: more than %d pages match %q", ErrTooManyPages, c.Pagination.MaxPages, jql)
+		}
+
+		query := url.Values{}
+		query.Set("jql", jql)
+		query.Set("fields", strings.Join(fields, ","))
+		query.Set("maxResults", strconv.Itoa(c.Pagination.PageSize))
+		if nextPageToken != "" {
+			query.Set("nextPageToken", nextPageToken)
+		}
+
+		var page SearchResults
+		if err := c.get(ctx, "/rest/api/3/search/jql?"+query.Encode(), &page, fresh); err != nil {
+			return issues, fmt.Errorf("failed to search issues: %w", err)
+		}
+		issues = append(issues, page.Issues...)
+
+		if page.IsLast || page.NextPageToken == "" {
+			return issues, nil
+		}
+		nextPageToken = page.NextPageToken
+	}
+}
+
+// IssueType is a Jira issue type
+type IssueType struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// FieldMeta describes how a field of an issue can be edited
+type FieldMeta struct {
+	Schema struct {
+		Type  string `json:"type"`
+		Items string `json:"items,omitempty"`
+		// Custom is the type of a custom field, e.g. com.atlassian.jira.plugin.system.customfieldtypes:url
+		Custom string `json:"custom,omitempty"`
+	} `json:"schema"`
+	// AllowedValues are the options (or, for issuetype, issue types) the field accepts on the issue
+	AllowedValues []AllowedValue `json:"allowedValues,omitempty"`
+}
+
+// AllowedValue is an option of a select field, or an issue type, in edit metadata
+type AllowedValue struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// EditMeta returns the fields that can be edited on an issue, keyed by field ID
+func (c *Client) EditMeta(ctx context.Context, issueKey string) (map[string]FieldMeta, error) {
+	var editMeta struct {
+		Fields map[string]FieldMeta `json:"fields"`
+	}
+	if err := c.Get(ctx, IssuePath(issueKey)+"/editmeta", &editMeta); err != nil {
+		return nil, fmt.Errorf("failed to read edit metadata: %w", err)
+	}
+	return editMeta.Fields, nil
+}
+
+// AllowedIssueTypes returns the issue types an issue can be changed to through the edit API,
+// which is limited to types sharing the issue's project and workflow
+func (c *Client) AllowedIssueTypes(ctx context.Context, issueKey string) ([]IssueType, error) {
+	fields, err := c.EditMeta(ctx, issueKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var issueTypes []IssueType
+	for _, value := range fields["issuetype"].AllowedValues {
+		issueTypes = append(issueTypes, IssueType{ID: value.ID, Name: value.Name})
+	}
+	return issueTypes, nil
+}
@@ -0,0 +1,65 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Pagination bounds the list calls that follow pages
+type Pagination struct {
+	// PageSize is the maxResults requested per page; Jira may return fewer
+	PageSize int
+	// MaxPages stops a listing that has not finished after this many pages
+	MaxPages int
+}
+
+// DefaultPagination requests Jira's usual page size and gives up after 100 pages
+var DefaultPagination = Pagination{PageSize: 50, MaxPages: 100}
+
+// ErrTooManyPages is returned when a listing reaches Pagination.MaxPages, rather than returning a
+// truncated list
+var ErrTooManyPages = errors.New("too many pages")
+
+// offsetPage is the envelope of Jira's offset-paginated lists
+type offsetPage struct {
+	StartAt    int             `json:"startAt"`
+	MaxResults int             `json:"maxResults"`
+	Total      int             `json:"total"`
+	IsLast     bool            `json:"isLast"`
+	Values     json.RawMessage `json:"values"`
+}
+
+// getPages GETs path page by page with startAt and maxResults, passing the values of each page
+// to appendValues, which returns how many it got, until the last page
+func (c *Client) getPages(ctx context.Context, path string, query url.Values, appendValues func(values json.RawMessage) (int, error)) error {
+	query.Set("maxResults", strconv.Itoa(c.Pagination.PageSize))
+
+	startAt := 0
+	for pages := 0; ; pages++ {
+		if pages >= c.Pagination.MaxPages {
+			return fmt.Errorf("%w: %s has more than %d pages", ErrTooManyPages, path, c.Pagination.MaxPages)
+		}
+		query.Set("startAt", strconv.Itoa(startAt))
+
+		var page offsetPage
+		if err := c.Get(ctx, path+"?"+query.Encode(), &page); err != nil {
+			return err
+		}
+		count := 0
+		if len(page.Values) > 0 {
+			var err error
+			if count, err = appendValues(page.Values); err != nil {
+				return fmt.Errorf("failed to decode page of %s: %w", path, err)
+			}
+		}
+
+		startAt += count
+		if page.IsLast || count == 0 || (page.Total > 0 && startAt >= page.Total) {
+			return nil
+		}
+	}
+}
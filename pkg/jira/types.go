@@ -0,0 +1,124 @@
+package jira
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// UpdateRequest is the body of an issue edit
+type UpdateRequest struct {
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// Update holds field operations (set, add, remove) for fields edited incrementally
+	Update map[string][]map[string]interface{} `json:"update,omitempty"`
+}
+
+// FieldIDs returns the sorted IDs of every field the request edits
+func (u UpdateRequest) FieldIDs() []string {
+	fieldIDs := make([]string, 0, len(u.Fields)+len(u.Update))
+	for fieldID := range u.Fields {
+		fieldIDs = append(fieldIDs, fieldID)
+	}
+	for fieldID := range u.Update {
+		fieldIDs = append(fieldIDs, fieldID)
+	}
+	sort.Strings(fieldIDs)
+	return fieldIDs
+}
+
+// ComponentValue is the value format of an Assets object field
+type ComponentValue struct {
+	ID       string `json:"id"`
+	ObjectID string `json:"objectId"`
+	// WorkspaceObjectIDs are the IDs of the same object in each Assets workspace it was
+	// resolved in, when fields use different workspaces. They aren't sent to Jira.
+	WorkspaceObjectIDs map[string]string `json:"-"`
+}
+
+// ObjectIDSet returns a canonical string of the object IDs in values, ignoring order
+func ObjectIDSet(values []ComponentValue) string {
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		ids = append(ids, value.ObjectID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// SelectValue is the value format of a single-select field
+type SelectValue struct {
+	Value string `json:"value"`
+}
+
+// Issue is an issue with the fields requested from the API, undecoded
+type Issue struct {
+	Key    string                     `json:"key"`
+	Fields map[string]json.RawMessage `json:"fields"`
+}
+
+// WebhookEvent is the subset of a Jira issue webhook payload used for sync
+type WebhookEvent struct {
+	WebhookEvent string `json:"webhookEvent"`
+	User         struct {
+		AccountID   string `json:"accountId"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	Issue     Issue `json:"issue"`
+	Changelog struct {
+		Items []struct {
+			FieldID string `json:"fieldId"`
+			Field   string `json:"field"`
+		} `json:"items"`
+	} `json:"changelog"`
+}
+
+// RemoteLink is a link from an issue to a page outside Jira
+type RemoteLink struct {
+	GlobalID     string `json:"globalId"`
+	Relationship string `json:"relationship,omitempty"`
+	// Application groups the links of one application in the issue view
+	Application *RemoteLinkApplication `json:"application,omitempty"`
+	Object      struct {
+		URL     string `json:"url"`
+		Title   string `json:"title"`
+		Summary string `json:"summary,omitempty"`
+		Icon    struct {
+			URL16x16 string `json:"url16x16,omitempty"`
+			Title    string `json:"title,omitempty"`
+		} `json:"icon"`
+		// Status, when set, shows whether the linked object is resolved; Jira strikes through
+		// resolved links
+		Status *RemoteLinkStatus `json:"status,omitempty"`
+	} `json:"object"`
+}
+
+// RemoteLinkApplication is the application a remote link points into
+type RemoteLinkApplication struct {
+	Type string `json:"type,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// RemoteLinkStatus is the state of the object a remote link points at
+type RemoteLinkStatus struct {
+	Resolved bool `json:"resolved"`
+	Icon     struct {
+		URL16x16 string `json:"url16x16,omitempty"`
+		Title    string `json:"title,omitempty"`
+	} `json:"icon"`
+}
+
+// Transition is a workflow transition available from an issue's current status
+type Transition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	To   struct {
+		Name string `json:"name"`
+	} `json:"to"`
+}
+
+// SearchResults is a page of the enhanced JQL search
+type SearchResults struct {
+	Issues        []Issue `json:"issues"`
+	NextPageToken string  `json:"nextPageToken"`
+	IsLast        bool    `json:"isLast"`
+}
@@ -0,0 +1,33 @@
+package jira
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpdateRequestFieldIDs(t *testing.T) {
+	update := UpdateRequest{
+		Fields: map[string]interface{}{"customfield_2": nil, "summary": "x"},
+		Update: map[string][]map[string]interface{}{"labels": {{"add": "incident"}}},
+	}
+	if got, want := update.FieldIDs(), []string{"customfield_2", "labels", "summary"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldIDs() = %q, want %q", got, want)
+	}
+}
+
+func TestObjectIDSet(t *testing.T) {
+	a := ObjectIDSet([]ComponentValue{{ObjectID: "3"}, {ObjectID: "12"}})
+	b := ObjectIDSet([]ComponentValue{{ObjectID: "12"}, {ObjectID: "3"}})
+	if a != b || a != "12,3" {
+		t.Errorf("ObjectIDSet() = %q and %q, want 12,3 for both orders", a, b)
+	}
+}
+
+func TestProjectKey(t *testing.T) {
+	tests := map[string]string{"SUP-68": "SUP", "TEAM-SUB-42": "TEAM-SUB", "SUP": "SUP"}
+	for issueKey, want := range tests {
+		if got := ProjectKey(issueKey); got != want {
+			t.Errorf("ProjectKey(%q) = %q, want %q", issueKey, got, want)
+		}
+	}
+}
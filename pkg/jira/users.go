@@ -0,0 +1,90 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// User is a Jira user account
+type User struct {
+	AccountID    string `json:"accountId"`
+	EmailAddress string `json:"emailAddress,omitempty"`
+	DisplayName  string `json:"displayName,omitempty"`
+	Active       bool   `json:"active"`
+}
+
+// FindUserAccountID returns the account ID of the active user with the given email address
+func (c *Client) FindUserAccountID(ctx context.Context, email string) (string, error) {
+	query := url.Values{}
+	query.Set("query", email)
+
+	var users []User
+	if err := c.Get(ctx, "/rest/api/3/user/search?"+query.Encode(), &users); err != nil {
+		return "", fmt.Errorf("failed to search users: %w", err)
+	}
+
+	// Email addresses hidden by profile visibility settings come back empty, so a single
+	// active match for the query is accepted too
+	var active []User
+	for _, user := range users {
+		if !user.Active {
+			continue
+		}
+		if strings.EqualFold(user.EmailAddress, email) {
+			return user.AccountID, nil
+		}
+		active = append(active, user)
+	}
+	if len(active) == 1 && active[0].EmailAddress == "" {
+		return active[0].AccountID, nil
+	}
+	return "", fmt.Errorf("no Jira user found for %s", email)
+}
+
+// Watchers returns the account IDs watching an issue
+func (c *Client) Watchers(ctx context.Context, issueKey string) ([]string, error) {
+	var watchers struct {
+		Watchers []User `json:"watchers"`
+	}
+	if err := c.Get(ctx, IssuePath(issueKey)+"/watchers", &watchers); err != nil {
+		return nil, fmt.Errorf("failed to list watchers: %w", err)
+	}
+
+	accountIDs := make([]string, 0, len(watchers.Watchers))
+	for _, watcher := range watchers.Watchers {
+		accountIDs = append(accountIDs, watcher.AccountID)
+	}
+	return accountIDs, nil
+}
+
+// AddWatcher adds a user as a watcher of an issue
+func (c *Client) AddWatcher(ctx context.Context, issueKey, accountID string) error {
+	return c.Do(ctx, "POST", IssuePath(issueKey)+"/watchers", accountID, nil)
+}
+
+// GroupMemberAccountIDs returns the account IDs of the active members of a group
+func (c *Client) GroupMemberAccountIDs(ctx context.Context, groupName string) ([]string, error) {
+	query := url.Values{}
+	query.Set("groupname", groupName)
+
+	var accountIDs []string
+	err := c.getPages(ctx, "/rest/api/3/group/member", query, func(values json.RawMessage) (int, error) {
+		var page []User
+		if err := json.Unmarshal(values, &page); err != nil {
+			return 0, err
+		}
+		for _, user := range page {
+			if user.Active {
+				accountIDs = append(accountIDs, user.AccountID)
+			}
+		}
+		return len(page), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members of group %s: %w", groupName, err)
+	}
+	return accountIDs, nil
+}
@@ -0,0 +1,55 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// WorklogTimeFormat is the format of a worklog's start time
+const WorklogTimeFormat = "2006-01-02T15:04:05.000-0700"
+
+// maxWorklogs is the most worklogs Jira returns for an issue in one request
+const maxWorklogs = 5000
+
+// Worklog is time logged on an issue
+type Worklog struct {
+	ID               string           `json:"id,omitempty"`
+	Started          string           `json:"started"`
+	TimeSpentSeconds int64            `json:"timeSpentSeconds"`
+	Comment          interface{}      `json:"comment,omitempty"`
+	Properties       []EntityProperty `json:"properties,omitempty"`
+}
+
+// EntityProperty is a property stored on a Jira entity, such as a worklog
+type EntityProperty struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Property returns the value of the worklog's property with the given key
+func (w Worklog) Property(key string) (json.RawMessage, bool) {
+	for _, property := range w.Properties {
+		if property.Key == key {
+			return property.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Worklogs returns the time logged on an issue, with the properties of each worklog
+func (c *Client) Worklogs(ctx context.Context, issueKey string) ([]Worklog, error) {
+	var page struct {
+		Worklogs []Worklog `json:"worklogs"`
+	}
+	if err := c.Get(ctx, fmt.Sprintf("%s/worklog?maxResults=%d&expand=properties", IssuePath(issueKey), maxWorklogs), &page); err != nil {
+		return nil, fmt.Errorf("failed to list worklogs: %w", err)
+	}
+	return page.Worklogs, nil
+}
+
+// AddWorklog logs time on an issue, as the authenticated account, leaving the remaining
+// estimate as it is and without notifying watchers
+func (c *Client) AddWorklog(ctx context.Context, issueKey string, worklog Worklog) error {
+	return c.Do(ctx, "POST", IssuePath(issueKey)+"/worklog?adjustEstimate=leave&notifyUsers=false", worklog, nil)
+}
@@ -0,0 +1,19 @@
+package mapping
+
+import (
+	"time"
+	// Time zones load in images without a tzdata package
+	_ "time/tzdata"
+)
+
+// Location returns the time zone the mapping's dates are written in, falling back to UTC
+func (m FieldMapping) Location() *time.Location {
+	if m.location != nil {
+		return m.location
+	}
+	location, err := time.LoadLocation(m.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
@@ -0,0 +1,26 @@
+package mapping
+
+import (
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// EventInitialSync stands for the initial sync of a newly attached issue in a mapping's events
+const EventInitialSync = "initial_sync"
+
+// AppliesTo reports whether the mapping is written on an event type. Versions of the
+// public_incident.* events are interchangeable, so "public_incident.incident_created_v2" also
+// matches later versions.
+func (m FieldMapping) AppliesTo(eventType string) bool {
+	if len(m.Events) == 0 {
+		return true
+	}
+	name := incidentio.EventName(eventType)
+	for _, event := range m.Events {
+		if strings.EqualFold(incidentio.EventName(strings.TrimSpace(event)), name) {
+			return true
+		}
+	}
+	return false
+}
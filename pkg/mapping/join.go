@@ -0,0 +1,62 @@
+package mapping
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// ValueTemplateInput is what a value template is rendered with
+type ValueTemplateInput struct {
+	// Value is the text of the incident value, or of the mapping's catalog attribute
+	Value string
+	// Attributes are the attributes of the value's catalog entry as text, by name; empty for
+	// values that aren't catalog entries
+	Attributes map[string]string
+	// Field is the incident field name
+	Field string
+	// Incident is the incident being synced
+	Incident incidentio.Incident
+}
+
+// ValidateJoin checks a mapping's join_separator and value_template
+func ValidateJoin(mappingType, separator, valueTemplate, transform string) error {
+	if separator == "" && valueTemplate == "" {
+		return nil
+	}
+	if mappingType != TypeText {
+		return fmt.Errorf("join_separator and value_template are only for text mappings")
+	}
+	if valueTemplate != "" && separator == "" {
+		return fmt.Errorf("value_template needs join_separator")
+	}
+	if valueTemplate != "" && transform != "" {
+		return fmt.Errorf("value_template and transform can't be combined")
+	}
+	return nil
+}
+
+// Joins reports whether the mapping writes every value of the incident field, joined into one
+func (m FieldMapping) Joins() bool {
+	return m.JoinSeparator != ""
+}
+
+// HasValueTemplate reports whether the mapping renders its values with a value template
+func (m FieldMapping) HasValueTemplate() bool {
+	return m.valueTemplate != nil
+}
+
+// RenderValue renders the mapping's value template for one incident value, trimmed of
+// surrounding whitespace. Without a value template the value is returned as is.
+func (m FieldMapping) RenderValue(input ValueTemplateInput, timeout time.Duration) (string, error) {
+	if m.valueTemplate == nil {
+		return input.Value, nil
+	}
+	output, err := renderTemplate(m.valueTemplate, input, "value template of "+m.IncidentFieldName, timeout)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
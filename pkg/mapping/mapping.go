@@ -0,0 +1,339 @@
+// Package mapping decides which Jira fields incident.io custom fields are synced to: the
+// field mappings, the rules file that routes further fields, object key parsing and the
+// policies applied when Jira rejects a write.
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// FieldMapping maps one incident.io custom field to one or more Jira fields
+type FieldMapping struct {
+	IncidentFieldName string       `json:"incident_field_name"`
+	JiraFieldID       string       `json:"jira_field_id"`
+	JiraTargets       []JiraTarget `json:"jira_targets,omitempty"`
+	Type              string       `json:"type,omitempty"`
+	// ObjectKeyPattern is a regex whose first capture group extracts the Assets object ID from the object key
+	ObjectKeyPattern string `json:"object_key_pattern,omitempty"`
+	// CatalogAttribute names the catalog entry attribute supplying the Jira value. Nested
+	// attributes of referenced entries are separated by dots, e.g. "Team.Owner email".
+	CatalogAttribute string `json:"catalog_attribute,omitempty"`
+	// UseExternalID reads the object key from the catalog entry's external ID carried by the
+	// event, looking up CatalogAttribute only when the external ID holds no object ID
+	UseExternalID bool `json:"use_external_id,omitempty"`
+	// MultiValuePolicy decides what happens when Jira rejects multiple values for the field
+	MultiValuePolicy string `json:"multi_value_policy,omitempty"`
+	// MaxValues caps the number of values written to the field; 0 means no limit
+	MaxValues int `json:"max_values,omitempty"`
+	// OverflowPolicy decides which values are written when there are more than MaxValues
+	OverflowPolicy string `json:"overflow_policy,omitempty"`
+	// PriorityAttribute names the catalog entry attribute ranking values for the prioritize
+	// overflow policy, lowest first (e.g. "Tier")
+	PriorityAttribute string `json:"priority_attribute,omitempty"`
+	// Transform is a template turning each incident value into the Jira values, one per line,
+	// for select and sprint mappings
+	Transform string `json:"transform,omitempty"`
+	// Order sequences the field's write among the fields of an event, lowest first
+	Order int `json:"order,omitempty"`
+	// After lists incident fields whose writes must come before this field's
+	After []string `json:"after,omitempty"`
+	// Credential names the Jira credential the field is read and written with, instead of the
+	// default account
+	Credential string `json:"credential,omitempty"`
+	// DateFormat is the Go time layout date mappings write in, e.g. "02/Jan/06"; by default
+	// the format Jira takes for the field
+	DateFormat string `json:"date_format,omitempty"`
+	// Timezone is the IANA time zone date mappings write in, and read incident values without
+	// an offset in (defaults to UTC)
+	Timezone string `json:"timezone,omitempty"`
+	// JoinSeparator makes a text mapping write every value of the incident field, joined by the
+	// separator, instead of only the first
+	JoinSeparator string `json:"join_separator,omitempty"`
+	// ValueTemplate is a template rendering each value of a joined text mapping, with the
+	// attributes of its catalog entry, e.g. {{.Value}} ({{index .Attributes "Tier"}})
+	ValueTemplate string `json:"value_template,omitempty"`
+	// Events limits the mapping to these event types (any version), "backfill", "reconcile" or
+	// "initial_sync"; empty applies it on every event
+	Events []string `json:"events,omitempty"`
+
+	transform     *template.Template
+	valueTemplate *template.Template
+	location      *time.Location
+}
+
+// Mapping types, selecting how incident values are converted for Jira
+const (
+	TypeAssets = "assets"
+	TypeSprint = "sprint"
+	TypeSelect = "select"
+	TypeText   = "text"
+	// TypeTimeTracking writes the original estimate of the issue's time tracking
+	TypeTimeTracking = "timetracking"
+	// TypeParent links the issue to an epic through the parent or Epic Link field
+	TypeParent = "parent"
+	// TypeDate writes a date or date-time field, in the mapping's date format and time zone
+	TypeDate = "date"
+)
+
+// JiraTarget is a Jira field written by a mapping. Mappings can have several targets
+// (e.g. old and new field during a field migration), each enabled independently.
+type JiraTarget struct {
+	FieldID string `json:"field_id"`
+	Enabled bool   `json:"enabled"`
+}
+
+// EnabledFieldIDs returns the Jira field IDs the mapping should write to
+func (m FieldMapping) EnabledFieldIDs() []string {
+	if len(m.JiraTargets) == 0 {
+		if m.JiraFieldID == "" {
+			return nil
+		}
+		return []string{m.JiraFieldID}
+	}
+
+	var fieldIDs []string
+	for _, target := range m.JiraTargets {
+		if target.Enabled && target.FieldID != "" {
+			fieldIDs = append(fieldIDs, target.FieldID)
+		}
+	}
+	return fieldIDs
+}
+
+// CatalogAttributeOr returns the mapping's catalog attribute, falling back to defaultAttribute
+func (m FieldMapping) CatalogAttributeOr(defaultAttribute string) string {
+	if m.CatalogAttribute != "" {
+		return m.CatalogAttribute
+	}
+	return defaultAttribute
+}
+
+// ObjectKeyPatternOr returns the mapping's object key pattern, falling back to defaultPattern
+func (m FieldMapping) ObjectKeyPatternOr(defaultPattern string) string {
+	if m.ObjectKeyPattern != "" {
+		return m.ObjectKeyPattern
+	}
+	return defaultPattern
+}
+
+// Rule routes every incident custom field whose name matches the rule to a Jira
+// field looked up by the incident field name, so new component-like fields only need
+// an entry in the rules file
+type Rule struct {
+	// Pattern is a case-insensitive glob on the incident field name, e.g. "* components"
+	Pattern string `json:"pattern,omitempty"`
+	// Regex is used instead of Pattern when set
+	Regex string `json:"regex,omitempty"`
+	// JiraFields maps incident field names (case-insensitive) to Jira field IDs
+	JiraFields map[string]string `json:"jira_fields"`
+	// Type is the mapping type of the routed fields (defaults to assets)
+	Type string `json:"type,omitempty"`
+	// ObjectKeyPattern overrides object ID extraction for the routed fields
+	ObjectKeyPattern string `json:"object_key_pattern,omitempty"`
+	// CatalogAttribute picks the catalog attribute supplying the value of the routed fields
+	CatalogAttribute string `json:"catalog_attribute,omitempty"`
+	// UseExternalID reads the object keys of the routed fields from catalog entry external IDs
+	UseExternalID bool `json:"use_external_id,omitempty"`
+	// MultiValuePolicy overrides MULTI_VALUE_POLICY for the routed fields
+	MultiValuePolicy string `json:"multi_value_policy,omitempty"`
+	// MaxValues caps the number of values written to each routed field
+	MaxValues int `json:"max_values,omitempty"`
+	// OverflowPolicy decides which values of the routed fields are written beyond MaxValues
+	OverflowPolicy string `json:"overflow_policy,omitempty"`
+	// PriorityAttribute ranks values of the routed fields for the prioritize overflow policy
+	PriorityAttribute string `json:"priority_attribute,omitempty"`
+	// Transform is the transform template of the routed fields
+	Transform string `json:"transform,omitempty"`
+	// Order sequences writes of the routed fields among the fields of an event, lowest first
+	Order int `json:"order,omitempty"`
+	// After lists incident fields written before the routed fields
+	After []string `json:"after,omitempty"`
+	// Credential names the Jira credential the routed fields are read and written with
+	Credential string `json:"credential,omitempty"`
+	// DateFormat is the Go time layout the routed date fields are written in
+	DateFormat string `json:"date_format,omitempty"`
+	// Timezone is the IANA time zone the routed date fields are written in
+	Timezone string `json:"timezone,omitempty"`
+	// JoinSeparator joins every value of the routed text fields into one Jira value
+	JoinSeparator string `json:"join_separator,omitempty"`
+	// ValueTemplate renders each value of the routed text fields before they are joined
+	ValueTemplate string `json:"value_template,omitempty"`
+	// Events limits the routed fields to these lifecycle events
+	Events []string `json:"events,omitempty"`
+
+	matcher       *regexp.Regexp
+	transform     *template.Template
+	valueTemplate *template.Template
+	location      *time.Location
+}
+
+// RulesFile is the format of MAPPING_RULES_FILE
+type RulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// globToRegexp converts a glob with * and ? wildcards into an anchored, case-insensitive regex
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.Compile("(?i)^" + quoted + "$")
+}
+
+// LoadRules reads mapping rules from a JSON file, validates them against RulesFileSchema and
+// compiles them
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return ParseRules(data, path)
+}
+
+// ParseRules validates a rules document against RulesFileSchema and compiles its rules. source
+// names the document in errors.
+func ParseRules(data []byte, source string) ([]Rule, error) {
+	document, err := validateRulesDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s:\n%w", source, err)
+	}
+
+	var rulesFile RulesFile
+	if err := json.Unmarshal(data, &rulesFile); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+	}
+
+	rules := document.value.(map[string]*jsonNode)["rules"].value.([]*jsonNode)
+	for i := range rulesFile.Rules {
+		if err := rulesFile.Rules[i].Compile(); err != nil {
+			line, column := lineColumn(data, rules[i].offset)
+			return nil, fmt.Errorf("invalid %s: line %d, column %d: rules[%d]: %w", source, line, column, i, err)
+		}
+	}
+
+	return rulesFile.Rules, nil
+}
+
+// Compile validates the rule and prepares its matcher. Rules built in code must be
+// compiled before use; LoadRules compiles the rules it reads.
+func (r *Rule) Compile() error {
+	var err error
+	switch {
+	case r.Regex != "":
+		r.matcher, err = regexp.Compile("(?i)" + r.Regex)
+	case r.Pattern != "":
+		r.matcher, err = globToRegexp(r.Pattern)
+	default:
+		err = fmt.Errorf("pattern or regex is required")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateObjectKeyPattern(r.ObjectKeyPattern); err != nil {
+		return err
+	}
+
+	if err := ValidateMultiValuePolicy(r.MultiValuePolicy); err != nil {
+		return err
+	}
+
+	if err := ValidateValueLimit(r.MaxValues, r.OverflowPolicy, r.PriorityAttribute); err != nil {
+		return err
+	}
+
+	if r.transform, err = compileTransform("transform", r.Transform); err != nil {
+		return err
+	}
+
+	if err := ValidateJoin(r.Type, r.JoinSeparator, r.ValueTemplate, r.Transform); err != nil {
+		return err
+	}
+
+	if r.valueTemplate, err = compileTransform("value_template", r.ValueTemplate); err != nil {
+		return err
+	}
+
+	if r.location, err = time.LoadLocation(r.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", r.Timezone)
+	}
+
+	if len(r.JiraFields) == 0 {
+		return fmt.Errorf("jira_fields is required")
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to an incident field
+func (r Rule) Matches(fieldName string) bool {
+	return r.matcher != nil && r.matcher.MatchString(fieldName)
+}
+
+// LookupJiraField returns the Jira field ID the rule's lookup table routes an incident field to
+func (r Rule) LookupJiraField(fieldName string) (string, bool) {
+	for name, fieldID := range r.JiraFields {
+		if strings.EqualFold(name, fieldName) {
+			return fieldID, true
+		}
+	}
+
+	return "", false
+}
+
+// Resolver finds the mapping for incident custom fields, checking the built-in mappings
+// (in order) before the rules
+type Resolver struct {
+	Builtins []FieldMapping
+	Rules    []Rule
+}
+
+// Resolve returns the mapping for an incident custom field, if any
+func (r Resolver) Resolve(fieldName string) (FieldMapping, bool) {
+	for _, fieldMapping := range r.Builtins {
+		if fieldName == fieldMapping.IncidentFieldName {
+			return fieldMapping, true
+		}
+	}
+
+	for _, rule := range r.Rules {
+		if !rule.Matches(fieldName) {
+			continue
+		}
+		if fieldID, found := rule.LookupJiraField(fieldName); found {
+			return FieldMapping{
+				IncidentFieldName: fieldName,
+				JiraFieldID:       fieldID,
+				Type:              rule.Type,
+				ObjectKeyPattern:  rule.ObjectKeyPattern,
+				CatalogAttribute:  rule.CatalogAttribute,
+				UseExternalID:     rule.UseExternalID,
+				MultiValuePolicy:  rule.MultiValuePolicy,
+				MaxValues:         rule.MaxValues,
+				OverflowPolicy:    rule.OverflowPolicy,
+				PriorityAttribute: rule.PriorityAttribute,
+				Transform:         rule.Transform,
+				Order:             rule.Order,
+				After:             rule.After,
+				Credential:        rule.Credential,
+				DateFormat:        rule.DateFormat,
+				Timezone:          rule.Timezone,
+				JoinSeparator:     rule.JoinSeparator,
+				ValueTemplate:     rule.ValueTemplate,
+				Events:            rule.Events,
+				transform:         rule.transform,
+				valueTemplate:     rule.valueTemplate,
+				location:          rule.location,
+			}, true
+		}
+		log.Printf("Field %s matches mapping rule %q but has no Jira field in its lookup table", fieldName, rule.Pattern+rule.Regex)
+	}
+
+	return FieldMapping{}, false
+}
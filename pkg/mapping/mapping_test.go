@@ -0,0 +1,204 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	rules := []Rule{
+		{Pattern: "* components", JiraFields: map[string]string{"Impacted Components": "customfield_1"}, ObjectKeyPattern: `^X(\d+)$`},
+		{Regex: "^team", JiraFields: map[string]string{"Team": "customfield_2"}, Type: TypeSprint},
+	}
+	for i := range rules {
+		if err := rules[i].Compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resolver := Resolver{
+		Builtins: []FieldMapping{{IncidentFieldName: "Impacted Components", JiraFieldID: "customfield_0"}},
+		Rules:    rules,
+	}
+
+	tests := []struct {
+		fieldName string
+		found     bool
+		fieldID   string
+		fieldType string
+	}{
+		// Built-in mappings come before the rules
+		{fieldName: "Impacted Components", found: true, fieldID: "customfield_0"},
+		// Built-in names are exact; rule lookups ignore case
+		{fieldName: "impacted components", found: true, fieldID: "customfield_1"},
+		{fieldName: "TEAM", found: true, fieldID: "customfield_2", fieldType: TypeSprint},
+		// Matches a rule without an entry in its lookup table
+		{fieldName: "Other Components", found: false},
+		{fieldName: "Severity", found: false},
+	}
+	for _, test := range tests {
+		fieldMapping, found := resolver.Resolve(test.fieldName)
+		if found != test.found || fieldMapping.JiraFieldID != test.fieldID || fieldMapping.Type != test.fieldType {
+			t.Errorf("Resolve(%q) = %+v, %v, want %s (%s), %v", test.fieldName, fieldMapping, found, test.fieldID, test.fieldType, test.found)
+		}
+	}
+}
+
+func TestEnabledFieldIDs(t *testing.T) {
+	tests := []struct {
+		mapping FieldMapping
+		want    []string
+	}{
+		{mapping: FieldMapping{}, want: nil},
+		{mapping: FieldMapping{JiraFieldID: "customfield_1"}, want: []string{"customfield_1"}},
+		{
+			mapping: FieldMapping{JiraFieldID: "customfield_1", JiraTargets: []JiraTarget{
+				{FieldID: "customfield_1", Enabled: false},
+				{FieldID: "customfield_2", Enabled: true},
+				{FieldID: "", Enabled: true},
+			}},
+			want: []string{"customfield_2"},
+		},
+	}
+	for _, test := range tests {
+		got := test.mapping.EnabledFieldIDs()
+		if len(got) != len(test.want) || len(got) > 0 && got[0] != test.want[0] {
+			t.Errorf("EnabledFieldIDs() of %+v = %q, want %q", test.mapping, got, test.want)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	rules, err := LoadRules(write("rules.json", `{"rules": [{"pattern": "* components", "jira_fields": {"Impacted Components": "customfield_1"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || !rules[0].Matches("IMPACTED COMPONENTS") || rules[0].Matches("Impacted Components Owner") {
+		t.Errorf("loaded rules = %+v, want one compiled glob rule", rules)
+	}
+
+	invalid := map[string]string{
+		"no matcher":         `{"rules": [{"jira_fields": {"a": "b"}}]}`,
+		"no jira fields":     `{"rules": [{"pattern": "*"}]}`,
+		"bad regex":          `{"rules": [{"regex": "(", "jira_fields": {"a": "b"}}]}`,
+		"bad object pattern": `{"rules": [{"pattern": "*", "object_key_pattern": "\\d+", "jira_fields": {"a": "b"}}]}`,
+		"bad multi-value":    `{"rules": [{"pattern": "*", "multi_value_policy": "most", "jira_fields": {"a": "b"}}]}`,
+		"not a rules file":   `[]`,
+	}
+	for name, content := range invalid {
+		if _, err := LoadRules(write("invalid.json", content)); err == nil {
+			t.Errorf("%s: rules were accepted", name)
+		}
+	}
+	if _, err := LoadRules(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing rules file was accepted")
+	}
+}
+
+const testRulesDocument = `{
+  "rules": [
+    {
+      "pattern": "* components",
+      "jira_fields": {"Impacted components": "customfield_10100", "Affected Components": "customfield_10101"},
+      "object_key_pattern": "^cmp-(\\d+)$"
+    },
+    {
+      "regex": "^team( owner)?$",
+      "type": "select",
+      "jira_fields": {"Team": "customfield_10200", "Team owner": "customfield_10201"}
+    },
+    {
+      "pattern": "Region?",
+      "type": "select",
+      "jira_fields": {"Regions": "customfield_10300"}
+    }
+  ]
+}`
+
+func TestRuleMatching(t *testing.T) {
+	rules, err := ParseRules([]byte(testRulesDocument), "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := Resolver{
+		Builtins: []FieldMapping{{IncidentFieldName: "Impacted components", JiraFieldID: "customfield_1", Type: TypeAssets}},
+		Rules:    rules,
+	}
+
+	tests := []struct {
+		fieldName   string
+		wantFieldID string
+		wantType    string
+	}{
+		// Built-in mappings come before rules
+		{"Impacted components", "customfield_1", TypeAssets},
+		// Globs and lookups are case-insensitive
+		{"affected components", "customfield_10101", ""},
+		{"TEAM", "customfield_10200", TypeSelect},
+		{"team owner", "customfield_10201", TypeSelect},
+		// ? matches one character
+		{"Regions", "customfield_10300", TypeSelect},
+		// Matching a rule without an entry in its lookup table
+		{"Responsible components", "", ""},
+		{"Region", "", ""},
+		// Regexes are anchored only where they say so
+		{"team owners", "", ""},
+		{"Customer", "", ""},
+	}
+	for _, test := range tests {
+		mapping, found := resolver.Resolve(test.fieldName)
+		if found != (test.wantFieldID != "") {
+			t.Errorf("Resolve(%q) found = %v, want %v", test.fieldName, found, test.wantFieldID != "")
+			continue
+		}
+		if mapping.JiraFieldID != test.wantFieldID || mapping.Type != test.wantType {
+			t.Errorf("Resolve(%q) = %s (%q), want %s (%q)", test.fieldName, mapping.JiraFieldID, mapping.Type, test.wantFieldID, test.wantType)
+		}
+	}
+
+	mapping, _ := resolver.Resolve("Affected Components")
+	if mapping.ObjectKeyPattern != `^cmp-(\d+)$` || mapping.IncidentFieldName != "Affected Components" {
+		t.Errorf("Resolve carried %+v, want the rule's object key pattern and the field name", mapping)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     string
+	}{
+		{"not JSON", `{"rules": [`, "invalid rules"},
+		{"no pattern", `{"rules": [{"jira_fields": {"Team": "customfield_1"}}]}`, "pattern is required, or regex is required"},
+		{"bad regex", `{"rules": [{"regex": "(", "jira_fields": {"Team": "customfield_1"}}]}`, "rules[0]"},
+		{"bad object key pattern", `{"rules": [{"pattern": "*", "object_key_pattern": "\\d+", "jira_fields": {"Team": "customfield_1"}}]}`, "capture group"},
+		{"bad transform", `{"rules": [{"pattern": "*", "type": "select", "transform": "{{.Value", "jira_fields": {"Team": "customfield_1"}}]}`, "invalid transform"},
+		{"unknown timezone", `{"rules": [{"pattern": "*", "type": "date", "timezone": "Mars/Olympus", "jira_fields": {"Team": "customfield_1"}}]}`, "unknown timezone"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseRules([]byte(test.document), "rules")
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("ParseRules error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRulesReportsLine(t *testing.T) {
+	document := "{\n  \"rules\": [\n    {\"pattern\": \"*\", \"jira_fields\": {\"A\": \"customfield_1\"}},\n    {\"regex\": \"(\", \"jira_fields\": {\"B\": \"customfield_2\"}}\n  ]\n}"
+	_, err := ParseRules([]byte(document), "rules.json")
+	if err == nil || !strings.Contains(err.Error(), "line 4") || !strings.Contains(err.Error(), "rules[1]") {
+		t.Errorf("ParseRules error = %v, want it to point at rules[1] on line 4", err)
+	}
+}
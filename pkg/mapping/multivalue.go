@@ -0,0 +1,36 @@
+package mapping
+
+import "fmt"
+
+// Policies for when Jira rejects multiple values for a field
+const (
+	// MultiValueFirst writes only the first value
+	MultiValueFirst = "first"
+	// MultiValueFirstWithComment writes the first value and comments the dropped ones on the issue
+	MultiValueFirstWithComment = "first_with_comment"
+	// MultiValueAppend sets the first value and adds the rest one request at a time
+	MultiValueAppend = "append"
+	// MultiValueFail fails the sync so the rejection is visible
+	MultiValueFail = "fail"
+)
+
+// ValidateMultiValuePolicy checks that policy is empty or one of the known policies
+func ValidateMultiValuePolicy(policy string) error {
+	switch policy {
+	case "", MultiValueFirst, MultiValueFirstWithComment, MultiValueAppend, MultiValueFail:
+		return nil
+	}
+	return fmt.Errorf("unknown multi-value policy: %s", policy)
+}
+
+// MultiValuePolicyOr returns the mapping's multi-value policy, falling back to defaultPolicy
+// and then to MultiValueFirst
+func (m FieldMapping) MultiValuePolicyOr(defaultPolicy string) string {
+	if m.MultiValuePolicy != "" {
+		return m.MultiValuePolicy
+	}
+	if defaultPolicy != "" {
+		return defaultPolicy
+	}
+	return MultiValueFirst
+}
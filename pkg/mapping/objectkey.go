@@ -0,0 +1,103 @@
+package mapping
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var (
+	// Trailing number of keys like 'PIN-3', 'SUP-10' or 'TEAM-SUB-42'
+	objectKeyNumberPattern = regexp.MustCompile(`-(\d+)$`)
+	numericObjectIDPattern = regexp.MustCompile(`^\d+$`)
+	uuidObjectIDPattern    = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+	// Compiled per-mapping object key patterns, keyed by pattern source
+	objectKeyPatterns sync.Map
+)
+
+// ValidateObjectKeyPattern checks that a configured pattern compiles and has a capture group
+func ValidateObjectKeyPattern(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid object key pattern %q: %w", pattern, err)
+	}
+	if re.NumSubexp() < 1 {
+		return fmt.Errorf("object key pattern %q must have a capture group", pattern)
+	}
+	return nil
+}
+
+// compiledObjectKeyPattern returns the compiled form of a validated pattern
+func compiledObjectKeyPattern(pattern string) *regexp.Regexp {
+	if re, exists := objectKeyPatterns.Load(pattern); exists {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	objectKeyPatterns.Store(pattern, re)
+	return re
+}
+
+// NormalizeObjectKey cleans up object keys copied from documents and chat: it trims
+// whitespace, drops invisible formatting characters (zero-width spaces, BOMs, emoji
+// variation selectors), turns Unicode dashes into '-' and non-ASCII digits into ASCII
+func NormalizeObjectKey(objectKey string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(objectKey) {
+		switch {
+		case unicode.In(r, unicode.Cf, unicode.Mn, unicode.Me) || unicode.Is(unicode.Variation_Selector, r):
+			continue
+		case r != '-' && unicode.In(r, unicode.Pd) || r == '−':
+			b.WriteRune('-')
+		case r > unicode.MaxASCII && unicode.IsDigit(r):
+			b.WriteRune('0' + digitValue(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// digitValue returns the value of a decimal digit rune. Unicode decimal digits are
+// encoded in contiguous runs of 0-9, so the value is the offset from the run start.
+func digitValue(r rune) rune {
+	start := r
+	for unicode.IsDigit(start - 1) {
+		start--
+	}
+	return (r - start) % 10
+}
+
+// ExtractObjectID extracts the Assets object ID from an object key. A configured pattern's
+// first capture group wins; otherwise UUID and numeric IDs are used as-is and the trailing
+// number of keys like 'PIN-3' or 'TEAM-SUB-42' is extracted.
+func ExtractObjectID(objectKey, pattern string) (string, error) {
+	objectKey = NormalizeObjectKey(objectKey)
+	if objectKey == "" {
+		return "", fmt.Errorf("empty object key")
+	}
+
+	if pattern != "" {
+		matches := compiledObjectKeyPattern(pattern).FindStringSubmatch(objectKey)
+		if len(matches) > 1 && matches[1] != "" {
+			return matches[1], nil
+		}
+		return "", fmt.Errorf("object key %s does not match pattern %s", objectKey, pattern)
+	}
+
+	// If it's already an ID
+	if numericObjectIDPattern.MatchString(objectKey) || uuidObjectIDPattern.MatchString(objectKey) {
+		return objectKey, nil
+	}
+
+	if matches := objectKeyNumberPattern.FindStringSubmatch(objectKey); len(matches) > 1 {
+		return matches[1], nil
+	}
+
+	return "", fmt.Errorf("could not extract object ID from object key: %s", objectKey)
+}
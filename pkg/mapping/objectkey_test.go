@@ -0,0 +1,69 @@
+package mapping
+
+import "testing"
+
+func TestNormalizeObjectKey(t *testing.T) {
+	tests := map[string]string{
+		"  PIN-3 ":         "PIN-3",
+		"PIN\u20133":       "PIN-3",
+		"PIN\u22123":       "PIN-3",
+		"PIN-\u200b3":      "PIN-3",
+		"\ufeffPIN-3":      "PIN-3",
+		"PIN-\u0663":       "PIN-3",
+		"PIN-\uff14\uff12": "PIN-42",
+	}
+	for objectKey, want := range tests {
+		if got := NormalizeObjectKey(objectKey); got != want {
+			t.Errorf("NormalizeObjectKey(%q) = %q, want %q", objectKey, got, want)
+		}
+	}
+}
+
+func TestExtractObjectID(t *testing.T) {
+	tests := []struct {
+		objectKey string
+		pattern   string
+		want      string
+		wantErr   bool
+	}{
+		{objectKey: "PIN-3", want: "3"},
+		{objectKey: "TEAM-SUB-42", want: "42"},
+		{objectKey: "1234", want: "1234"},
+		{objectKey: "0f8fad5b-d9cb-469f-a165-70867728950e", want: "0f8fad5b-d9cb-469f-a165-70867728950e"},
+		{objectKey: "PIN\u20133", want: "3"},
+		{objectKey: "CMP:77", pattern: `^CMP:(\d+)$`, want: "77"},
+		{objectKey: "PIN-3", pattern: `^CMP:(\d+)$`, wantErr: true},
+		{objectKey: "PIN", wantErr: true},
+		{objectKey: " ", wantErr: true},
+		{objectKey: "3F2504E0-4F89-11D3-9A0C-0305E82C3301", want: "3F2504E0-4F89-11D3-9A0C-0305E82C3301"},
+		{objectKey: "  SUP-10\n", want: "10"},
+		{objectKey: "PIN-\u200b3", want: "3"},
+		{objectKey: "PIN\u22127", want: "7"},
+		{objectKey: "PIN-\u0664\u0662", want: "42"},
+		{objectKey: "PIN-\uff11\uff15", want: "15"},
+		{objectKey: "cmp/17/a", pattern: `^cmp/(\d+)/`, want: "17"},
+		{objectKey: "PIN-3", pattern: `^([A-Z]+)-`, want: "PIN"},
+		{objectKey: " \u200b ", wantErr: true},
+		{objectKey: "PIN-3a", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ExtractObjectID(test.objectKey, test.pattern)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ExtractObjectID(%q, %q) = %q, %v, want %q (error %v)", test.objectKey, test.pattern, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestValidateObjectKeyPattern(t *testing.T) {
+	for pattern, valid := range map[string]bool{
+		"":          true,
+		`-(\d+)$`:   true,
+		`-\d+$`:     false,
+		`-(\d+$`:    false,
+		`(?P<id>.)`: true,
+	} {
+		if err := ValidateObjectKeyPattern(pattern); (err == nil) != valid {
+			t.Errorf("ValidateObjectKeyPattern(%q) = %v, want valid %v", pattern, err, valid)
+		}
+	}
+}
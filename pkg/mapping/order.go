@@ -0,0 +1,59 @@
+package mapping
+
+import (
+	"sort"
+	"strings"
+)
+
+// Sequence returns the order in which incident fields are written, as indexes into fieldNames.
+// Fields are sorted by their mapping's Order, and a field listing others in After is written
+// after those of them present. Otherwise fields keep their given order. Fields caught in a
+// dependency cycle are written in their sorted order and returned in cycle.
+func Sequence(fieldNames []string, resolve func(string) (FieldMapping, bool)) (order []int, cycle []string) {
+	mappings := make([]FieldMapping, len(fieldNames))
+	for i, name := range fieldNames {
+		mappings[i], _ = resolve(name)
+	}
+
+	pending := make([]int, len(fieldNames))
+	for i := range pending {
+		pending[i] = i
+	}
+	sort.SliceStable(pending, func(a, b int) bool {
+		return mappings[pending[a]].Order < mappings[pending[b]].Order
+	})
+
+	// waitsFor reports whether field i must wait for a pending field
+	waitsFor := func(i int) bool {
+		for _, dependency := range mappings[i].After {
+			for _, other := range pending {
+				if other != i && strings.EqualFold(fieldNames[other], dependency) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for len(pending) > 0 {
+		next := -1
+		for position, i := range pending {
+			if !waitsFor(i) {
+				next = position
+				break
+			}
+		}
+		if next < 0 {
+			// Every pending field waits for another: break the cycle at the first one
+			next = 0
+			if cycle == nil {
+				for _, i := range pending {
+					cycle = append(cycle, fieldNames[i])
+				}
+			}
+		}
+		order = append(order, pending[next])
+		pending = append(pending[:next], pending[next+1:]...)
+	}
+	return order, cycle
+}
@@ -0,0 +1,39 @@
+package mapping
+
+import "fmt"
+
+// Policies for when a field has more values than its mapping's max_values
+const (
+	// OverflowTruncate writes the first values and comments the dropped ones on the issue
+	OverflowTruncate = "truncate"
+	// OverflowPrioritize keeps the values ranking first by the mapping's priority attribute and
+	// comments the dropped ones on the issue
+	OverflowPrioritize = "prioritize"
+	// OverflowFail fails the sync without writing the field
+	OverflowFail = "fail"
+)
+
+// ValidateValueLimit checks a mapping's max_values, overflow_policy and priority_attribute
+func ValidateValueLimit(maxValues int, policy, priorityAttribute string) error {
+	if maxValues < 0 {
+		return fmt.Errorf("max_values must not be negative")
+	}
+	switch policy {
+	case "", OverflowTruncate, OverflowFail:
+	case OverflowPrioritize:
+		if priorityAttribute == "" {
+			return fmt.Errorf("overflow policy %s needs priority_attribute", policy)
+		}
+	default:
+		return fmt.Errorf("unknown overflow policy: %s", policy)
+	}
+	return nil
+}
+
+// OverflowPolicyOr returns the mapping's overflow policy, falling back to OverflowTruncate
+func (m FieldMapping) OverflowPolicyOr() string {
+	if m.OverflowPolicy != "" {
+		return m.OverflowPolicy
+	}
+	return OverflowTruncate
+}
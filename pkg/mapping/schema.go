@@ -0,0 +1,338 @@
+package mapping
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// RulesFileSchema is the JSON Schema of MAPPING_RULES_FILE
+//
+//go:embed rules.schema.json
+var RulesFileSchema []byte
+
+// ValidationError locates a problem in a JSON document
+type ValidationError struct {
+	// Path is the location of the offending value, e.g. "rules[1].type"
+	Path    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "document"
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %s", e.Line, e.Column, path, e.Message)
+}
+
+// schemaNode is the subset of JSON Schema the rules schema uses
+type schemaNode struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*schemaNode `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Required             []string               `json:"required"`
+	AnyOf                []*schemaNode          `json:"anyOf"`
+	Enum                 []string               `json:"enum"`
+	Items                *schemaNode            `json:"items"`
+	MinLength            int                    `json:"minLength"`
+	MinProperties        int                    `json:"minProperties"`
+	Minimum              *int64                 `json:"minimum"`
+}
+
+var rulesSchema = func() *schemaNode {
+	var schema schemaNode
+	if err := json.Unmarshal(RulesFileSchema, &schema); err != nil {
+		panic(fmt.Sprintf("invalid embedded rules schema: %v", err))
+	}
+	return &schema
+}()
+
+// ValidateRulesFile checks a rules file against RulesFileSchema, returning every violation
+// with its line and column
+func ValidateRulesFile(data []byte) error {
+	_, err := validateRulesDocument(data)
+	return err
+}
+
+func validateRulesDocument(data []byte) (*jsonNode, error) {
+	document, err := parseJSONNodes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []ValidationError
+	rulesSchema.validate(document, "", data, &violations)
+	if len(violations) == 0 {
+		return document, nil
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Line != violations[j].Line {
+			return violations[i].Line < violations[j].Line
+		}
+		return violations[i].Column < violations[j].Column
+	})
+	errs := make([]error, len(violations))
+	for i, violation := range violations {
+		errs[i] = violation
+	}
+	return document, errors.Join(errs...)
+}
+
+func (s *schemaNode) validate(node *jsonNode, path string, data []byte, violations *[]ValidationError) {
+	fail := func(format string, args ...interface{}) {
+		line, column := lineColumn(data, node.offset)
+		*violations = append(*violations, ValidationError{Path: path, Line: line, Column: column, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type == "integer" {
+		if number, isNumber := node.value.(json.Number); isNumber {
+			integer, err := number.Int64()
+			if err != nil {
+				fail("must be an integer, not %s", number)
+			} else if s.Minimum != nil && integer < *s.Minimum {
+				fail("must be at least %d, not %d", *s.Minimum, integer)
+			}
+			return
+		}
+	}
+
+	if s.Type != "" && node.typeName() != s.Type {
+		fail("must be %s, not %s", withArticle(s.Type), withArticle(node.typeName()))
+		return
+	}
+
+	switch value := node.value.(type) {
+	case string:
+		if len(value) < s.MinLength {
+			fail("must not be empty")
+		}
+		if len(s.Enum) > 0 && !containsString(s.Enum, value) {
+			fail("must be one of %s, not %q", strings.Join(s.Enum, ", "), value)
+		}
+
+	case []*jsonNode:
+		if s.Items != nil {
+			for i, item := range value {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), data, violations)
+			}
+		}
+
+	case map[string]*jsonNode:
+		for _, name := range s.Required {
+			if _, set := value[name]; !set {
+				fail("%s is required", name)
+			}
+		}
+		if len(value) < s.MinProperties {
+			if s.MinProperties == 1 {
+				fail("must not be empty")
+			} else {
+				fail("must have at least %d entries", s.MinProperties)
+			}
+		}
+
+		additional, allowed := s.additionalSchema()
+		for _, key := range node.keys {
+			child := value[key]
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+
+			if property, known := s.Properties[key]; known {
+				property.validate(child, childPath, data, violations)
+			} else if !allowed {
+				line, column := lineColumn(data, child.keyOffset)
+				*violations = append(*violations, ValidationError{Path: childPath, Line: line, Column: column, Message: "unknown field"})
+			} else if additional != nil {
+				additional.validate(child, childPath, data, violations)
+			}
+		}
+	}
+
+	if len(s.AnyOf) > 0 {
+		var alternatives []string
+		for _, alternative := range s.AnyOf {
+			var alternativeViolations []ValidationError
+			alternative.validate(node, path, data, &alternativeViolations)
+			if len(alternativeViolations) == 0 {
+				return
+			}
+			alternatives = append(alternatives, alternativeViolations[0].Message)
+		}
+		fail("%s", strings.Join(alternatives, ", or "))
+	}
+}
+
+// additionalSchema returns the schema of properties not listed in Properties, and whether
+// such properties are allowed
+func (s *schemaNode) additionalSchema() (*schemaNode, bool) {
+	if len(s.AdditionalProperties) == 0 {
+		return nil, true
+	}
+	var allowed bool
+	if json.Unmarshal(s.AdditionalProperties, &allowed) == nil {
+		return nil, allowed
+	}
+	var additional schemaNode
+	if err := json.Unmarshal(s.AdditionalProperties, &additional); err != nil {
+		return nil, true
+	}
+	return &additional, true
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func withArticle(typeName string) string {
+	switch typeName {
+	case "object", "array", "integer":
+		return "an " + typeName
+	case "null":
+		return "null"
+	}
+	return "a " + typeName
+}
+
+// jsonNode is a parsed JSON value that remembers where it appears in the document
+type jsonNode struct {
+	// value is a map[string]*jsonNode, []*jsonNode, string, json.Number, bool or nil
+	value interface{}
+	// keys are an object's keys in document order
+	keys      []string
+	offset    int64
+	keyOffset int64
+}
+
+func (n *jsonNode) typeName() string {
+	switch n.value.(type) {
+	case map[string]*jsonNode:
+		return "object"
+	case []*jsonNode:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// parseJSONNodes parses a JSON document, reporting syntax errors with their line and column
+func parseJSONNodes(data []byte) (*jsonNode, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	node, err := decodeJSONNode(decoder, data)
+	if err == nil {
+		if _, err := decoder.Token(); err != io.EOF {
+			line, column := lineColumn(data, decoder.InputOffset())
+			return nil, fmt.Errorf("line %d, column %d: unexpected data after the document", line, column)
+		}
+		return node, nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column := lineColumn(data, syntaxErr.Offset)
+		return nil, fmt.Errorf("line %d, column %d: %w", line, column, err)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		line, column := lineColumn(data, int64(len(data)))
+		return nil, fmt.Errorf("line %d, column %d: unexpected end of document", line, column)
+	}
+	return nil, err
+}
+
+func decodeJSONNode(decoder *json.Decoder, data []byte) (*jsonNode, error) {
+	node := &jsonNode{offset: skipJSONSeparators(data, decoder.InputOffset())}
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		fields := make(map[string]*jsonNode)
+		for decoder.More() {
+			keyOffset := skipJSONSeparators(data, decoder.InputOffset())
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			child, err := decodeJSONNode(decoder, data)
+			if err != nil {
+				return nil, err
+			}
+			child.keyOffset = keyOffset
+			name := key.(string)
+			if _, duplicate := fields[name]; !duplicate {
+				node.keys = append(node.keys, name)
+			}
+			fields[name] = child
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		node.value = fields
+
+	case json.Delim('['):
+		items := []*jsonNode{}
+		for decoder.More() {
+			child, err := decodeJSONNode(decoder, data)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, child)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		node.value = items
+
+	default:
+		node.value = token
+	}
+	return node, nil
+}
+
+// skipJSONSeparators advances offset past whitespace, commas and colons to the next token
+func skipJSONSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+// lineColumn converts a byte offset into a 1-based line and column
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
@@ -0,0 +1,485 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// maxTransformOutput bounds what a transform may render; rendering stops when it is exceeded
+const maxTransformOutput = 64 << 10
+
+// maxTransformIterations bounds the iterations of every range in one rendering together, nested
+// ranges included; rendering stops when it is exceeded
+const maxTransformIterations = 10000
+
+// maxTransformTemplateCalls bounds the {{template}} calls of one rendering, so a template
+// calling itself can't run on after its timeout
+const maxTransformTemplateCalls = 10000
+
+// Functions compileTransform inserts into the pipelines of a transform
+const (
+	// rangeLimitFunc ends every range pipeline, checking what is ranged over against the
+	// rendering's iteration budget
+	rangeLimitFunc = "_rangeLimit"
+	// templateCallFunc ends the pipeline of every {{template}} call, charging the call to the
+	// rendering's budget of maxTransformTemplateCalls
+	templateCallFunc = "_templateCall"
+	// checkFunc follows every call of a builtin the sandbox doesn't replace, such as eq or
+	// slice, so a canceled rendering stops there too
+	checkFunc = "_check"
+)
+
+// guardedBuiltins are the text/template builtins followed by checkFunc. They are guarded in the
+// parse tree rather than replaced, so and and or still short-circuit and eq keeps its rules for
+// comparing numbers of different types.
+var guardedBuiltins = map[string]bool{
+	"and": true, "or": true, "not": true, "len": true, "index": true, "slice": true, "call": true,
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+}
+
+// TransformInput is what a transform template is rendered with
+type TransformInput struct {
+	// Value is the text of the incident value being mapped
+	Value string
+	// Values are the texts of every value of the incident field
+	Values []string
+	// Field is the incident field name
+	Field string
+	// Incident is the incident being synced
+	Incident incidentio.Incident
+}
+
+// transformFuncs are the functions available to transforms, as a compiled template sees them.
+// None of them reach outside the template, so a transform can only compute its output from its
+// input. Each rendering is given its own, bounded by a transformSandbox.
+var transformFuncs = (&transformSandbox{}).funcs()
+
+// transformSandbox bounds one rendering of a transform: the iterations of its ranges, together
+// at most maxTransformIterations, its {{template}} calls, at most maxTransformTemplateCalls, and
+// every string its functions produce, each at most maxTransformOutput, so intermediate values
+// can't grow without bound either. Once the rendering times out it is canceled, and the next
+// function or builtin call, range, template call or write fails.
+type transformSandbox struct {
+	remaining int
+	calls     int
+	canceled  atomic.Bool
+}
+
+func newTransformSandbox() *transformSandbox {
+	return &transformSandbox{remaining: maxTransformIterations, calls: maxTransformTemplateCalls}
+}
+
+// check fails once the rendering is canceled
+func (s *transformSandbox) check() error {
+	if s.canceled.Load() {
+		return errTransformCanceled
+	}
+	return nil
+}
+
+// limit fails a function whose result would be longer than maxTransformOutput
+func (s *transformSandbox) limit(size int) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	if size > maxTransformOutput {
+		return errTransformValueTooLarge
+	}
+	return nil
+}
+
+// result returns what a function produced, failing if it is too large
+func (s *transformSandbox) result(value string) (string, error) {
+	if err := s.limit(len(value)); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// stringFunc wraps a function of one string whose result is checked after it is computed,
+// because it is at most a few times as long as its argument
+func (s *transformSandbox) stringFunc(f func(string) string) func(string) (string, error) {
+	return func(value string) (string, error) {
+		if err := s.check(); err != nil {
+			return "", err
+		}
+		return s.result(f(value))
+	}
+}
+
+// boundedFunc wraps a builtin template function, such as print or html, whose result is at
+// most a few times as long as its arguments, checking the result
+func (s *transformSandbox) boundedFunc(f func(...interface{}) string) func(...interface{}) (string, error) {
+	return func(args ...interface{}) (string, error) {
+		if err := s.check(); err != nil {
+			return "", err
+		}
+		return s.result(f(args...))
+	}
+}
+
+func (s *transformSandbox) funcs() template.FuncMap {
+	return template.FuncMap{
+		"lower": s.stringFunc(strings.ToLower),
+		"upper": s.stringFunc(strings.ToUpper),
+		"trim":  s.stringFunc(strings.TrimSpace),
+		"replace": func(text, old, replacement string) (string, error) {
+			// Checked before replacing, as each match may grow the text by the replacement
+			matches := strings.Count(text, old)
+			if err := s.limit(len(text) + matches*(len(replacement)-len(old))); err != nil {
+				return "", err
+			}
+			return strings.ReplaceAll(text, old, replacement), nil
+		},
+		"split": func(text, separator string) ([]string, error) {
+			if err := s.check(); err != nil {
+				return nil, err
+			}
+			return strings.Split(text, separator), nil
+		},
+		"join": func(values []string, separator string) (string, error) {
+			size := len(separator) * max(len(values)-1, 0)
+			for _, value := range values {
+				size += len(value)
+			}
+			if err := s.limit(size); err != nil {
+				return "", err
+			}
+			return strings.Join(values, separator), nil
+		},
+		"hasPrefix": s.predicate(strings.HasPrefix),
+		"hasSuffix": s.predicate(strings.HasSuffix),
+		"contains":  s.predicate(strings.Contains),
+		"regexReplace": func(pattern, replacement, text string) (string, error) {
+			if err := s.check(); err != nil {
+				return "", err
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return "", err
+			}
+			// Expanded one match at a time, as a replacement such as "$1$1" multiplies the text
+			var output []byte
+			last := 0
+			for _, match := range re.FindAllStringSubmatchIndex(text, -1) {
+				output = append(output, text[last:match[0]]...)
+				output = re.ExpandString(output, replacement, text, match)
+				last = match[1]
+				if err := s.limit(len(output)); err != nil {
+					return "", err
+				}
+			}
+			return s.result(string(append(output, text[last:]...)))
+		},
+		"default": func(fallback, value string) (string, error) {
+			if err := s.check(); err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(value) == "" {
+				return fallback, nil
+			}
+			return value, nil
+		},
+		// Builtins producing strings are bounded the same way
+		"print":   s.boundedFunc(fmt.Sprint),
+		"println": s.boundedFunc(fmt.Sprintln),
+		"printf": func(format string, args ...interface{}) (string, error) {
+			if err := s.check(); err != nil {
+				return "", err
+			}
+			// A width or precision is padded out before anything could be checked
+			if err := checkFormatWidths(format); err != nil {
+				return "", err
+			}
+			return s.result(fmt.Sprintf(format, args...))
+		},
+		"html":           s.boundedFunc(template.HTMLEscaper),
+		"js":             s.boundedFunc(template.JSEscaper),
+		"urlquery":       s.boundedFunc(template.URLQueryEscaper),
+		rangeLimitFunc:   s.rangeLimit,
+		templateCallFunc: s.templateCall,
+		checkFunc:        s.passThrough,
+	}
+}
+
+// predicate wraps a test of two strings, failing once the rendering is canceled
+func (s *transformSandbox) predicate(f func(string, string) bool) func(string, string) (bool, error) {
+	return func(text, part string) (bool, error) {
+		if err := s.check(); err != nil {
+			return false, err
+		}
+		return f(text, part), nil
+	}
+}
+
+// templateCall is the guard of {{template}} calls, charging each to the rendering's budget of
+// maxTransformTemplateCalls. It returns the data the template is called with, if any.
+func (s *transformSandbox) templateCall(data ...interface{}) (interface{}, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	if s.calls--; s.calls < 0 {
+		return nil, errTransformTooManyTemplateCalls
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return data[0], nil
+}
+
+// passThrough is the guard of builtin calls, returning the builtin's result unless the
+// rendering is canceled
+func (s *transformSandbox) passThrough(value interface{}) (interface{}, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// formatWidth matches the width and precision of a printf verb
+var formatWidth = regexp.MustCompile(`%[-+# 0]*(?:\[\d+\])?(\*|\d*)(?:\.(?:\[\d+\])?(\*|\d*))?`)
+
+// checkFormatWidths rejects printf formats padding a value beyond maxTransformOutput, or taking
+// the width or precision from an argument
+func checkFormatWidths(format string) error {
+	for _, match := range formatWidth.FindAllStringSubmatch(format, -1) {
+		for _, size := range match[1:] {
+			if size == "*" {
+				return errors.New("printf widths and precisions taken from arguments are not supported")
+			}
+			if n, err := strconv.Atoi(size); size != "" && (err != nil || n > maxTransformOutput) {
+				return errTransformValueTooLarge
+			}
+		}
+	}
+	return nil
+}
+
+// rangeLimit is the range guard, charging what is ranged over to the rendering's budget of
+// maxTransformIterations. Ranging over an integer or a function is refused, since their length
+// can't be known before looping; slices, arrays and maps are charged their length.
+func (s *transformSandbox) rangeLimit(value interface{}) (interface{}, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return nil, errors.New("range over an integer is not supported")
+	case reflect.Func, reflect.Chan:
+		return nil, fmt.Errorf("range over a %s is not supported", v.Kind())
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if s.remaining -= v.Len(); s.remaining < 0 {
+			return nil, errTransformTooManyIterations
+		}
+	}
+	return value, nil
+}
+
+// compileTransform parses a transform template
+func compileTransform(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Funcs(transformFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := guardTree(t.Tree, t.Tree.Root); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return tmpl, nil
+}
+
+// guardTree inserts the sandbox's guards into every pipeline under node: what ranges range over
+// passes through rangeLimitFunc, {{template}} calls through templateCallFunc and the results of
+// guardedBuiltins through checkFunc. Ranges over an integer literal are rejected.
+func guardTree(tree *parse.Tree, node parse.Node) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			if err := guardTree(tree, child); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		guardPipe(tree, node.Pipe)
+	case *parse.IfNode:
+		return guardBranch(tree, &node.BranchNode)
+	case *parse.WithNode:
+		return guardBranch(tree, &node.BranchNode)
+	case *parse.RangeNode:
+		if cmds := node.Pipe.Cmds; len(cmds) == 1 && len(cmds[0].Args) == 1 {
+			if _, isNumber := cmds[0].Args[0].(*parse.NumberNode); isNumber {
+				location, _ := tree.ErrorContext(node)
+				return fmt.Errorf("%s: range over an integer is not supported", location)
+			}
+		}
+		if err := guardBranch(tree, &node.BranchNode); err != nil {
+			return err
+		}
+		node.Pipe.Cmds = append(node.Pipe.Cmds, guardCommand(tree, rangeLimitFunc, node.Pipe.Pos))
+	case *parse.TemplateNode:
+		if node.Pipe == nil {
+			node.Pipe = &parse.PipeNode{NodeType: parse.NodePipe, Pos: node.Pos, Line: node.Line}
+		}
+		guardPipe(tree, node.Pipe)
+		node.Pipe.Cmds = append(node.Pipe.Cmds, guardCommand(tree, templateCallFunc, node.Pipe.Pos))
+	}
+	return nil
+}
+
+func guardBranch(tree *parse.Tree, node *parse.BranchNode) error {
+	guardPipe(tree, node.Pipe)
+	if err := guardTree(tree, node.List); err != nil {
+		return err
+	}
+	return guardTree(tree, node.ElseList)
+}
+
+// guardPipe follows every call of a guarded builtin in pipe, including those in parenthesized
+// pipelines, with checkFunc
+func guardPipe(tree *parse.Tree, pipe *parse.PipeNode) {
+	if pipe == nil {
+		return
+	}
+	cmds := make([]*parse.CommandNode, 0, len(pipe.Cmds))
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			if nested, isPipe := arg.(*parse.PipeNode); isPipe {
+				guardPipe(tree, nested)
+			}
+		}
+		cmds = append(cmds, cmd)
+		if identifier, isIdentifier := cmd.Args[0].(*parse.IdentifierNode); isIdentifier && guardedBuiltins[identifier.Ident] {
+			cmds = append(cmds, guardCommand(tree, checkFunc, cmd.Pos))
+		}
+	}
+	pipe.Cmds = cmds
+}
+
+// guardCommand returns a command calling the named guard
+func guardCommand(tree *parse.Tree, name string, pos parse.Pos) *parse.CommandNode {
+	guard := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: pos}
+	guard.Args = []parse.Node{parse.NewIdentifier(name).SetTree(tree).SetPos(pos)}
+	return guard
+}
+
+// errTransformTooManyIterations stops a transform looping more than maxTransformIterations times
+var errTransformTooManyIterations = fmt.Errorf("transform ranges over more than %d values", maxTransformIterations)
+
+// errTransformTooManyTemplateCalls stops a transform making more than maxTransformTemplateCalls
+// {{template}} calls
+var errTransformTooManyTemplateCalls = fmt.Errorf("transform makes more than %d template calls", maxTransformTemplateCalls)
+
+// errTransformOutputTooLarge stops a transform rendering more than maxTransformOutput
+var errTransformOutputTooLarge = fmt.Errorf("transform output exceeds %d bytes", maxTransformOutput)
+
+// errTransformValueTooLarge stops a transform function producing more than maxTransformOutput
+var errTransformValueTooLarge = fmt.Errorf("transform value exceeds %d bytes", maxTransformOutput)
+
+// errTransformCanceled stops a transform that timed out
+var errTransformCanceled = errors.New("transform canceled")
+
+// limitedBuffer fails writes beyond maxTransformOutput, or once its rendering is canceled
+type limitedBuffer struct {
+	strings.Builder
+	sandbox *transformSandbox
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if err := b.sandbox.check(); err != nil {
+		return 0, err
+	}
+	if b.Len()+len(p) > maxTransformOutput {
+		return 0, errTransformOutputTooLarge
+	}
+	return b.Builder.Write(p)
+}
+
+// ApplyTransform runs the mapping's transform on an incident value and returns the Jira values it
+// renders, one per line; blank lines are dropped. Without a transform the value is returned as
+// is. Rendering longer than timeout fails the value.
+func (m FieldMapping) ApplyTransform(input TransformInput, timeout time.Duration) ([]string, error) {
+	if m.transform == nil {
+		return []string{input.Value}, nil
+	}
+
+	output, err := renderTemplate(m.transform, input, "transform of "+m.IncidentFieldName, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+	return values, nil
+}
+
+// renderTemplate renders a transform or value template in a sandbox of its own, failing after
+// timeout or beyond maxTransformOutput or maxTransformIterations. what names the template in
+// errors.
+func renderTemplate(tmpl *template.Template, data interface{}, what string, timeout time.Duration) (string, error) {
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w", what, err)
+	}
+	sandbox := newTransformSandbox()
+	tmpl.Funcs(sandbox.funcs())
+
+	type rendered struct {
+		output string
+		err    error
+	}
+	done := make(chan rendered, 1)
+	go func() {
+		output := limitedBuffer{sandbox: sandbox}
+		err := tmpl.Execute(&output, data)
+		done <- rendered{output.String(), err}
+	}()
+
+	var result rendered
+	select {
+	case result = <-done:
+	case <-time.After(timeout):
+		// Stops the rendering at its next function or builtin call, range, template call or write
+		sandbox.canceled.Store(true)
+		return "", fmt.Errorf("%s timed out after %s", what, timeout)
+	}
+	if result.err != nil {
+		for _, limitErr := range []error{errTransformOutputTooLarge, errTransformValueTooLarge} {
+			if errors.Is(result.err, limitErr) {
+				return "", fmt.Errorf("%s: %w", what, limitErr)
+			}
+		}
+		for _, budgetErr := range []error{errTransformTooManyIterations, errTransformTooManyTemplateCalls} {
+			if errors.Is(result.err, budgetErr) {
+				return "", fmt.Errorf("%s: %w", what, budgetErr)
+			}
+		}
+		return "", fmt.Errorf("%s failed: %w", what, result.err)
+	}
+	return result.output, nil
+}
@@ -0,0 +1,320 @@
+package mapping
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransformRanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		transform  string
+		wantOutput string
+		// compileErr and renderErr are substrings of the expected errors
+		compileErr string
+		renderErr  string
+	}{
+		{
+			name:       "range over values",
+			transform:  "{{range .Values}}{{upper .}}\n{{end}}",
+			wantOutput: "A\nB\n",
+		},
+		{
+			name:       "range with variables",
+			transform:  "{{range $i, $v := .Values}}{{$i}}={{$v}} {{end}}",
+			wantOutput: "0=a 1=b ",
+		},
+		{
+			name:       "range with else",
+			transform:  "{{range split .Value \",\"}}{{.}}{{else}}none{{end}}",
+			wantOutput: "a",
+		},
+		{
+			name:       "range over an integer literal",
+			transform:  "{{range 1000000000000}}x{{end}}",
+			compileErr: "range over an integer is not supported",
+		},
+		{
+			name:      "range over an integer variable",
+			transform: "{{$n := 1000000000000}}{{range $n}}x{{end}}",
+			renderErr: "range over an integer is not supported",
+		},
+		{
+			name:      "range over a computed integer",
+			transform: "{{range len .Values}}x{{end}}",
+			renderErr: "range over an integer is not supported",
+		},
+		{
+			name:      "nested ranges beyond the budget",
+			transform: "{{$s := split (printf \"%0200d\" 0) \"\"}}{{range $s}}{{range $s}}{{range $s}}{{end}}{{end}}{{end}}",
+			renderErr: errTransformTooManyIterations.Error(),
+		},
+		{
+			name:      "range in a nested template",
+			transform: "{{define \"loop\"}}{{range .}}x{{end}}{{end}}{{template \"loop\" 5}}",
+			renderErr: "range over an integer is not supported",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := compileTransform("transform", test.transform)
+			if test.compileErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.compileErr) {
+					t.Fatalf("compileTransform error = %v, want %q", err, test.compileErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileTransform: %v", err)
+			}
+
+			output, err := renderTemplate(tmpl, TransformInput{Value: "a", Values: []string{"a", "b"}}, "transform", time.Second)
+			if test.renderErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.renderErr) {
+					t.Fatalf("renderTemplate error = %v, want %q", err, test.renderErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderTemplate: %v", err)
+			}
+			if output != test.wantOutput {
+				t.Errorf("output = %q, want %q", output, test.wantOutput)
+			}
+		})
+	}
+}
+
+func TestTransformIterationBudgetIsPerRendering(t *testing.T) {
+	tmpl, err := compileTransform("transform", "{{range .Values}}{{end}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := TransformInput{Values: make([]string, maxTransformIterations)}
+	for i := 0; i < 3; i++ {
+		if _, err := renderTemplate(tmpl, input, "transform", time.Second); err != nil {
+			t.Fatalf("rendering %d: %v", i, err)
+		}
+	}
+
+	input.Values = append(input.Values, "")
+	if _, err := renderTemplate(tmpl, input, "transform", time.Second); !errors.Is(err, errTransformTooManyIterations) {
+		t.Errorf("error = %v, want %v", err, errTransformTooManyIterations)
+	}
+}
+
+func TestApplyTransform(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform string
+		value     string
+		want      []string
+		wantErr   string
+	}{
+		{name: "no transform", value: "eu-west", want: []string{"eu-west"}},
+		{name: "rename", transform: `{{if hasPrefix .Value "eu-"}}Europe{{else}}{{upper .Value}}{{end}}`, value: "eu-west", want: []string{"Europe"}},
+		{name: "split into lines", transform: `{{range split .Value ","}}{{trim .}}` + "\n{{end}}", value: "a, b ,c", want: []string{"a", "b", "c"}},
+		{name: "drop", transform: `{{if ne .Value "n/a"}}{{.Value}}{{end}}`, value: "n/a", want: nil},
+		{name: "regexReplace", transform: `{{regexReplace "^team-" "" .Value}}`, value: "team-payments", want: []string{"payments"}},
+		{name: "default", transform: `{{default "unknown" .Value}}`, value: " ", want: []string{"unknown"}},
+		{name: "field and incident", transform: `{{.Field}}/{{.Incident.Name}}`, value: "x", want: []string{"Region/Payments down"}},
+		{name: "missing key", transform: `{{.Nope}}`, value: "x", wantErr: "failed"},
+		{name: "output limit", transform: `{{printf "%070000d" 0}}`, value: "x", wantErr: "exceeds"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := Rule{Pattern: "*", Type: TypeSelect, Transform: test.transform, JiraFields: map[string]string{"Region": "customfield_1"}}
+			if err := rule.Compile(); err != nil {
+				t.Fatal(err)
+			}
+			mapping, _ := Resolver{Rules: []Rule{rule}}.Resolve("Region")
+
+			input := TransformInput{Value: test.value, Values: []string{test.value}, Field: "Region"}
+			input.Incident.Name = "Payments down"
+			got, err := mapping.ApplyTransform(input, time.Second)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("ApplyTransform error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ApplyTransform = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyTransformTimeout(t *testing.T) {
+	rule := Rule{Pattern: "*", Type: TypeSelect, Transform: `{{range .Values}}{{$replaced := regexReplace "0" "1" (printf "%060000d" 0)}}{{end}}`, JiraFields: map[string]string{"Region": "customfield_1"}}
+	if err := rule.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	mapping, _ := Resolver{Rules: []Rule{rule}}.Resolve("Region")
+	input := TransformInput{Values: make([]string, 1000)}
+	goroutines := runtime.NumGoroutine()
+	if _, err := mapping.ApplyTransform(input, time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("ApplyTransform error = %v, want a timeout", err)
+	}
+
+	// The rendering stops soon after timing out rather than running on in the background
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > goroutines; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines running after the timeout, want %d", runtime.NumGoroutine(), goroutines)
+		}
+	}
+}
+
+func TestTransformTemplateCalls(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform string
+		// renderErr is a substring of the expected error, if any
+		renderErr  string
+		wantOutput string
+	}{
+		{
+			name:       "template calls",
+			transform:  `{{define "item"}}[{{.}}]{{end}}{{define "static"}}-{{end}}{{template "item" upper .Value}}{{template "static"}}`,
+			wantOutput: "[A]-",
+		},
+		{
+			name:      "recursion beyond the budget",
+			transform: `{{define "a"}}{{if .}}{{template "a" (slice . 1)}}{{template "a" (slice . 1)}}{{end}}{{end}}{{template "a" (printf "%040d" 0)}}`,
+			renderErr: errTransformTooManyTemplateCalls.Error(),
+		},
+		{
+			name:       "guarded builtins",
+			transform:  `{{if and (eq .Value "a") (gt (len .Values) 1)}}{{index .Values 1}}{{end}} {{or "" (slice .Value 0 1)}} {{not (ne 1 2)}}`,
+			wantOutput: "b a false",
+		},
+		{
+			name:       "and still short-circuits",
+			transform:  `{{and false (index .Values 5)}}`,
+			wantOutput: "false",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := compileTransform("transform", test.transform)
+			if err != nil {
+				t.Fatalf("compileTransform: %v", err)
+			}
+			output, err := renderTemplate(tmpl, TransformInput{Value: "a", Values: []string{"a", "b"}}, "transform", 5*time.Second)
+			if test.renderErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.renderErr) {
+					t.Fatalf("renderTemplate error = %v, want %q", err, test.renderErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderTemplate: %v", err)
+			}
+			if output != test.wantOutput {
+				t.Errorf("output = %q, want %q", output, test.wantOutput)
+			}
+		})
+	}
+}
+
+func TestTransformValueLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform string
+		// renderErr is a substring of the expected error, if any
+		renderErr  string
+		wantOutput string
+	}{
+		{
+			name:      "nested replace",
+			transform: `{{$v := printf "%01000d" 0}}{{$v = replace $v "" $v}}{{$v = replace $v "" $v}}{{$v = replace $v "" $v}}{{len $v}}`,
+			renderErr: errTransformValueTooLarge.Error(),
+		},
+		{
+			name:      "regexReplace doubling",
+			transform: `{{$v := printf "%020000d" 0}}{{$v = regexReplace "(.+)" "$1$1" $v}}{{$v = regexReplace "(.+)" "$1$1" $v}}{{len $v}}`,
+			renderErr: errTransformValueTooLarge.Error(),
+		},
+		{
+			name:      "split and join",
+			transform: `{{$v := printf "%01000d" 0}}{{$v = join (split $v "") $v}}{{len $v}}`,
+			renderErr: errTransformValueTooLarge.Error(),
+		},
+		{
+			name:      "nested html escaping",
+			transform: `{{$v := replace (printf "%010000d" 0) "0" "&"}}{{$v = html $v}}{{$v = html $v}}{{len $v}}`,
+			renderErr: errTransformValueTooLarge.Error(),
+		},
+		{
+			name:      "printf width",
+			transform: `{{len (printf "%0100000000d" 0)}}`,
+			renderErr: errTransformValueTooLarge.Error(),
+		},
+		{
+			name:      "printf width from an argument",
+			transform: `{{len (printf "%[1]*d" 100000000 0)}}`,
+			renderErr: "not supported",
+		},
+		{
+			name:       "values within the limit",
+			transform:  `{{$v := printf "%0100d" 0}}{{$v = replace $v "0" "ab"}}{{$v = regexReplace "(a)" "$1$1" $v}}{{len $v}}`,
+			wantOutput: "300",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := compileTransform("transform", test.transform)
+			if err != nil {
+				t.Fatalf("compileTransform: %v", err)
+			}
+			output, err := renderTemplate(tmpl, TransformInput{}, "transform", time.Second)
+			if test.renderErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.renderErr) {
+					t.Fatalf("renderTemplate error = %v, want %q", err, test.renderErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderTemplate: %v", err)
+			}
+			if output != test.wantOutput {
+				t.Errorf("output = %q, want %q", output, test.wantOutput)
+			}
+		})
+	}
+}
+
+func TestTransformSandboxCanceled(t *testing.T) {
+	// None of these write output, so each is stopped by the guard of what it calls
+	transforms := []string{
+		`{{range .Values}}{{upper .}}{{end}}`,
+		`{{if eq (len .Values) 1}}{{end}}`,
+		`{{$v := slice .Value 0}}`,
+		`{{define "empty"}}{{end}}{{template "empty"}}`,
+		`{{if contains .Value "a"}}{{end}}`,
+	}
+	for _, transform := range transforms {
+		tmpl, err := compileTransform("transform", transform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sandbox := newTransformSandbox()
+		sandbox.canceled.Store(true)
+		tmpl.Funcs(sandbox.funcs())
+		var output strings.Builder
+		if err := tmpl.Execute(&output, TransformInput{Value: "a", Values: []string{"a"}}); !errors.Is(err, errTransformCanceled) {
+			t.Errorf("Execute of canceled %s = %v, want %v", transform, err, errTransformCanceled)
+		}
+	}
+}
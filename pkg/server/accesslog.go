@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessLogEntry is one line of the access log
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	Listener   string    `json:"listener"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+	Bytes      int64     `json:"bytes"`
+	Remote     string    `json:"remote"`
+	// SampleRate is the share of such requests logged, when sampled
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// accessLogger writes a JSON line for each HTTP request, apart from the application log. Paths
+// in ACCESS_LOG_SAMPLE_RATES are sampled, but failed requests are always logged.
+type accessLogger struct {
+	rates map[string]float64
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// parseSampleRates reads ACCESS_LOG_SAMPLE_RATES, e.g. "/health=0.01,/metrics=0.1"
+func parseSampleRates(value string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for path, rate := range parseKeyValueList(value) {
+		parsed, err := strconv.ParseFloat(rate, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return nil, fmt.Errorf("sample rate of %s must be between 0 and 1, not %q", path, rate)
+		}
+		rates[path] = parsed
+	}
+	return rates, nil
+}
+
+// newAccessLogger opens ACCESS_LOG: "stdout", "stderr" or a file appended to
+func newAccessLogger(destination string, rates map[string]float64) (*accessLogger, error) {
+	logger := &accessLogger{rates: rates}
+	switch destination {
+	case "stdout":
+		logger.out = os.Stdout
+	case "stderr":
+		logger.out = os.Stderr
+	default:
+		file, err := os.OpenFile(destination, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		logger.out = file
+	}
+	return logger, nil
+}
+
+// sampled reports whether a request is logged, and the sample rate applied to its path
+func (a *accessLogger) sampled(path string, status int) (bool, float64) {
+	rate, limited := a.rates[strings.TrimRight(path, "/")]
+	if !limited {
+		rate, limited = a.rates[path]
+	}
+	if !limited || status >= http.StatusBadRequest {
+		return true, 0
+	}
+	return rand.Float64() < rate, rate
+}
+
+// wrap logs the requests served by next on a listener
+func (a *accessLogger) wrap(listener string, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		logged, rate := a.sampled(r.URL.Path, recorder.status)
+		if !logged {
+			return
+		}
+		line, err := json.Marshal(accessLogEntry{
+			Time:       started.UTC(),
+			Listener:   listener,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     recorder.status,
+			DurationMS: float64(time.Since(started).Microseconds()) / 1000,
+			Bytes:      recorder.bytes,
+			Remote:     r.RemoteAddr,
+			SampleRate: rate,
+		})
+		if err != nil {
+			return
+		}
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if _, err := a.out.Write(append(line, '\n')); err != nil {
+			log.Printf("Warning: failed to write access log: %v", err)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Admin roles. Operators can do everything viewers can.
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+)
+
+// AdminAPIKey is an admin credential; only the SHA-256 hash of the key is kept in config
+type AdminAPIKey struct {
+	Name string
+	Role string
+	Hash [sha256.Size]byte
+}
+
+// parseAdminAPIKeys parses "name:role:sha256hex,..." into admin API keys
+func parseAdminAPIKeys(value string) ([]AdminAPIKey, error) {
+	var keys []AdminAPIKey
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid admin API key entry %q, expected name:role:sha256", entry)
+		}
+
+		name, role, hashHex := parts[0], parts[1], parts[2]
+		if role != roleViewer && role != roleOperator {
+			return nil, fmt.Errorf("invalid role %q for admin API key %s", role, name)
+		}
+
+		hash, err := hex.DecodeString(hashHex)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 hash for admin API key %s", name)
+		}
+
+		key := AdminAPIKey{Name: name, Role: role}
+		copy(key.Hash[:], hash)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// roleAllows reports whether a key with role may perform actions requiring required
+func roleAllows(role, required string) bool {
+	return role == roleOperator || role == required
+}
+
+// authenticateAdmin returns the admin key matching the request's bearer token or X-API-Key header
+func (s *IncidentJiraSync) authenticateAdmin(r *http.Request) (*AdminAPIKey, bool) {
+	presented := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		presented = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if presented == "" {
+		return nil, false
+	}
+
+	hash := sha256.Sum256([]byte(presented))
+	for i := range s.config.AdminAPIKeys {
+		key := &s.config.AdminAPIKeys[i]
+		if subtle.ConstantTimeCompare(hash[:], key.Hash[:]) == 1 {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// statusRecorder captures the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// requireAdmin wraps an admin handler with API key authentication, role checks and audit logging
+func (s *IncidentJiraSync) requireAdmin(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := s.authenticateAdmin(r)
+		if !ok {
+			log.Printf("AUDIT admin=- action=%q status=%d remote=%s", r.Method+" "+r.URL.Path, http.StatusUnauthorized, r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !roleAllows(key.Role, requiredRole) {
+			log.Printf("AUDIT admin=%s role=%s action=%q status=%d remote=%s", key.Name, key.Role, r.Method+" "+r.URL.Path, http.StatusForbidden, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+
+		log.Printf("AUDIT admin=%s role=%s action=%q status=%d remote=%s", key.Name, key.Role, r.Method+" "+r.URL.Path, recorder.status, r.RemoteAddr)
+	}
+}
+
+// registerAdminRoutes adds the admin API to mux when admin API keys are configured
+func (s *IncidentJiraSync) registerAdminRoutes(mux *http.ServeMux) {
+	if len(s.config.AdminAPIKeys) == 0 {
+		log.Printf("No ADMIN_API_KEYS configured, admin endpoints disabled")
+		return
+	}
+
+	mux.HandleFunc("/admin/status", s.requireAdmin(roleViewer, s.adminStatusHandler))
+	mux.HandleFunc("/admin/cache/purge", s.requireAdmin(roleOperator, s.adminCachePurgeHandler))
+	mux.HandleFunc("/admin/stream", s.requireAdmin(roleViewer, s.adminStreamHandler))
+	mux.HandleFunc("/admin/backfill", s.requireAdmin(roleViewer, s.adminBackfillHandler))
+	mux.HandleFunc("/admin/backfill/start", s.requireAdmin(roleOperator, s.adminBackfillStartHandler))
+	mux.HandleFunc("/admin/backfill/cancel", s.requireAdmin(roleOperator, s.adminBackfillCancelHandler))
+	mux.HandleFunc("/admin/mappings", s.requireAdmin(roleViewer, s.adminMappingsHandler))
+	mux.HandleFunc("/admin/mappings/import", s.requireAdmin(roleOperator, s.adminMappingsImportHandler))
+	mux.HandleFunc("/admin/shadow", s.requireAdmin(roleViewer, s.adminShadowHandler))
+	mux.HandleFunc("/admin/shadow/reset", s.requireAdmin(roleOperator, s.adminShadowResetHandler))
+	mux.HandleFunc("/admin/skip", s.requireAdmin(roleViewer, s.adminSkipListHandler))
+	mux.HandleFunc("/admin/skip/add", s.requireAdmin(roleOperator, s.adminSkipAddHandler))
+	mux.HandleFunc("/admin/skip/remove", s.requireAdmin(roleOperator, s.adminSkipRemoveHandler))
+	mux.HandleFunc("/admin/reconcile", s.requireAdmin(roleViewer, s.adminReconcileHandler))
+	mux.HandleFunc("/admin/reconcile/run", s.requireAdmin(roleOperator, s.adminReconcileRunHandler))
+	mux.HandleFunc("/admin/drift", s.requireAdmin(roleViewer, s.adminDriftHandler))
+	mux.HandleFunc("/admin/test-payload", s.requireAdmin(roleOperator, s.adminTestPayloadHandler))
+	mux.HandleFunc("/admin/unknown-events", s.requireAdmin(roleViewer, s.adminUnknownEventsHandler))
+	mux.HandleFunc("/admin/drain", s.requireAdmin(roleOperator, s.adminDrainHandler))
+	mux.HandleFunc("/admin/report", s.requireAdmin(roleViewer, s.adminReportHandler))
+	mux.HandleFunc("/admin/debug/incident/", s.requireAdmin(roleOperator, s.adminDebugIncidentHandler))
+	mux.HandleFunc("/admin/debug/incidents", s.requireAdmin(roleViewer, s.adminDebugIncidentsHandler))
+}
+
+// adminStatusHandler reports runtime state of the sync service
+func (s *IncidentJiraSync) adminStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"retry_queue_depth":   len(s.retryQueue),
+		"draining":            s.draining.Load(),
+		"jira_cache_entries":  s.jira.Cache.Len(),
+		"catalog_entries":     s.catalog.size(),
+		"mapping_rules":       len(s.settings().MappingRules),
+		"shadow_rules":        len(s.settings().ShadowMappingRules),
+		"jira_rate_limit":     s.jiraBudget.status(),
+		"incident_rate_limit": s.incidentBudget.status(),
+		"organizations":       s.organizationStatuses(),
+		"incident_outbox":     s.outboxBacklog(),
+	})
+}
+
+// adminCachePurgeHandler drops all cached Jira responses, Assets object lookups and catalog
+// entries
+func (s *IncidentJiraSync) adminCachePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.jira.Cache.InvalidatePrefix("")
+
+	s.assetsMu.Lock()
+	s.createdAssetsObjects = make(map[string]string)
+	s.assetsMu.Unlock()
+
+	for _, organization := range s.allOrganizations() {
+		organization.catalog.purge()
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "purged"})
+}
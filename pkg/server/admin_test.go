@@ -0,0 +1,68 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseAdminAPIKeys(t *testing.T) {
+	hash := sha256.Sum256([]byte("key"))
+	hashHex := hex.EncodeToString(hash[:])
+
+	keys, err := parseAdminAPIKeys("ops:operator:" + hashHex + ", dashboards:viewer:" + hashHex + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Name != "ops" || keys[0].Role != roleOperator || keys[1].Role != roleViewer || keys[0].Hash != hash {
+		t.Errorf("keys = %+v", keys)
+	}
+
+	for _, value := range []string{"ops:operator", "ops:admin:" + hashHex, "ops:operator:abc"} {
+		if _, err := parseAdminAPIKeys(value); err == nil {
+			t.Errorf("parseAdminAPIKeys(%q) succeeded", value)
+		}
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	operatorHash := sha256.Sum256([]byte("operator-key"))
+	viewerHash := sha256.Sum256([]byte("viewer-key"))
+	s := &IncidentJiraSync{config: Config{AdminAPIKeys: []AdminAPIKey{
+		{Name: "ops", Role: roleOperator, Hash: operatorHash},
+		{Name: "dashboards", Role: roleViewer, Hash: viewerHash},
+	}}}
+
+	tests := []struct {
+		name     string
+		required string
+		header   string
+		value    string
+		want     int
+	}{
+		{name: "operator", required: roleOperator, header: "Authorization", value: "Bearer operator-key", want: http.StatusNoContent},
+		{name: "operator viewing", required: roleViewer, header: "X-API-Key", value: "operator-key", want: http.StatusNoContent},
+		{name: "viewer", required: roleViewer, header: "X-API-Key", value: "viewer-key", want: http.StatusNoContent},
+		{name: "viewer operating", required: roleOperator, header: "Authorization", value: "Bearer viewer-key", want: http.StatusForbidden},
+		{name: "unknown key", required: roleViewer, header: "X-API-Key", value: "other", want: http.StatusUnauthorized},
+		{name: "no key", required: roleViewer, want: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := s.requireAdmin(test.required, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			r := httptest.NewRequest(http.MethodPost, "/admin/cache/purge", nil)
+			if test.header != "" {
+				r.Header.Set(test.header, test.value)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != test.want {
+				t.Errorf("status = %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// catalogEntryAttribute returns the catalog entry property referenced by an attribute mapping
+func catalogEntryAttribute(catalogEntry *incidentio.CatalogEntry, property string) (string, error) {
+	switch property {
+	case "id":
+		return catalogEntry.ID, nil
+	case "name":
+		return catalogEntry.Name, nil
+	case "external_id":
+		return catalogEntry.ExternalID, nil
+	}
+	return "", fmt.Errorf("unknown catalog entry property: %s", property)
+}
+
+// createAssetsObject creates an Assets object for a catalog entry in an Assets workspace, using
+// the configured attribute mapping
+func (s *IncidentJiraSync) createAssetsObject(ctx context.Context, workspaceID string, catalogEntry *incidentio.CatalogEntry) (*jira.AssetsObject, error) {
+	payload := jira.AssetsCreateObjectRequest{ObjectTypeID: s.config.AssetsObjectTypeID}
+
+	for attributeID, property := range s.config.AssetsAttributeMapping {
+		value, err := catalogEntryAttribute(catalogEntry, property)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			continue
+		}
+		payload.Attributes = append(payload.Attributes, jira.AssetsAttribute{
+			ObjectTypeAttributeID: attributeID,
+			ObjectAttributeValues: []jira.AssetsAttributeValue{{Value: value}},
+		})
+	}
+
+	object, err := s.jira.CreateAssetsObject(ctx, workspaceID, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Created Assets object %s (%s) in workspace %s for catalog entry %s", object.ObjectKey, object.ID, workspaceID, catalogEntry.ID)
+	return object, nil
+}
+
+// assetsLookup is a search for, or creation of, the Assets object of one catalog entry, which
+// concurrent syncs of the same entry wait for rather than repeat
+type assetsLookup struct {
+	done     chan struct{}
+	objectID string
+	err      error
+}
+
+// assetsObjectKey keys the Assets object of a catalog entry in a workspace, as object IDs
+// differ between workspaces
+func assetsObjectKey(workspaceID, catalogEntryID string) string {
+	return workspaceID + "/" + catalogEntryID
+}
+
+// ensureAssetsObject returns the ID of the Assets object in a workspace for a catalog entry that
+// has no object key, reusing a matching object if one exists and creating one otherwise
+func (s *IncidentJiraSync) ensureAssetsObject(ctx context.Context, workspaceID string, catalogEntry *incidentio.CatalogEntry) (string, error) {
+	return s.assetsObject(ctx, assetsObjectKey(workspaceID, catalogEntry.ID), func() (string, error) {
+		return s.findOrCreateAssetsObject(ctx, workspaceID, catalogEntry)
+	})
+}
+
+// assetsObjectWithKey returns the ID of the object with an object key in a workspace, for
+// fields whose workspace isn't the one the catalog's object keys come from
+func (s *IncidentJiraSync) assetsObjectWithKey(ctx context.Context, workspaceID, objectKey string) (string, error) {
+	return s.assetsObject(ctx, workspaceID+"/key:"+objectKey, func() (string, error) {
+		object, err := s.jira.FindAssetsObjectByKey(ctx, workspaceID, objectKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to search Assets objects: %w", err)
+		}
+		if object == nil {
+			return "", fmt.Errorf("no Assets object %s in workspace %s", objectKey, workspaceID)
+		}
+		return object.ID, nil
+	})
+}
+
+// assetsObject returns the object ID cached under key, running find on a miss. Only one find
+// per key runs at a time; assetsMu guards the maps but isn't held while Jira is called.
+func (s *IncidentJiraSync) assetsObject(ctx context.Context, key string, find func() (string, error)) (string, error) {
+	s.assetsMu.Lock()
+	if objectID, exists := s.createdAssetsObjects[key]; exists {
+		s.assetsMu.Unlock()
+		return objectID, nil
+	}
+	if lookup, running := s.assetsLookups[key]; running {
+		s.assetsMu.Unlock()
+		select {
+		case <-lookup.done:
+			return lookup.objectID, lookup.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	lookup := &assetsLookup{done: make(chan struct{})}
+	s.assetsLookups[key] = lookup
+	s.assetsMu.Unlock()
+
+	lookup.objectID, lookup.err = find()
+
+	s.assetsMu.Lock()
+	delete(s.assetsLookups, key)
+	if lookup.err == nil {
+		s.createdAssetsObjects[key] = lookup.objectID
+	}
+	s.assetsMu.Unlock()
+	close(lookup.done)
+	return lookup.objectID, lookup.err
+}
+
+// findOrCreateAssetsObject searches a workspace for the Assets object matching a catalog entry,
+// creating one if none exists
+func (s *IncidentJiraSync) findOrCreateAssetsObject(ctx context.Context, workspaceID string, catalogEntry *incidentio.CatalogEntry) (string, error) {
+	object, err := s.jira.FindAssetsObject(ctx, workspaceID, s.config.AssetsObjectTypeID, s.config.AssetsMatchAttribute, catalogEntry.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to search Assets objects: %w", err)
+	}
+
+	if object != nil {
+		log.Printf("Found existing Assets object %s for catalog entry %s", object.ObjectKey, catalogEntry.ID)
+	} else {
+		object, err = s.createAssetsObject(ctx, workspaceID, catalogEntry)
+		if err != nil {
+			return "", fmt.Errorf("failed to create Assets object: %w", err)
+		}
+	}
+
+	return object.ID, nil
+}
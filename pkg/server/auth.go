@@ -0,0 +1,372 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Inbound authentication checks
+const (
+	authHMAC        = "hmac"
+	authBearer      = "bearer"
+	authIPAllowlist = "ip_allowlist"
+	authMTLS        = "mtls"
+)
+
+// Endpoints whose inbound authentication can be configured
+const (
+	endpointWebhook     = "webhook"
+	endpointJiraWebhook = "jira_webhook"
+	endpointMetrics     = "metrics"
+)
+
+// Signature enforcement modes selectable with SIGNATURE_ENFORCEMENT, applying to hmac checks
+const (
+	// signatureEnforcementOff skips hmac checks
+	signatureEnforcementOff = "off"
+	// signatureEnforcementReport verifies signatures, logging and counting mismatches, but
+	// processes the request as if the check had been skipped
+	signatureEnforcementReport  = "report"
+	signatureEnforcementEnforce = "enforce"
+)
+
+func validateSignatureEnforcement(mode string) error {
+	switch mode {
+	case signatureEnforcementOff, signatureEnforcementReport, signatureEnforcementEnforce:
+		return nil
+	}
+	return fmt.Errorf("unknown signature enforcement mode: %s", mode)
+}
+
+// webhookSignatureTolerance bounds the age of a signed incident.io delivery, to limit replays
+const webhookSignatureTolerance = 5 * time.Minute
+
+// EndpointAuth is the ordered chain of authentication checks for one endpoint
+type EndpointAuth struct {
+	Checks []string
+	// RequireAll requires every check to pass; otherwise any one passing is enough
+	RequireAll bool
+
+	HMACSecret        string
+	BearerTokenHashes [][sha256.Size]byte
+	AllowedNetworks   []*net.IPNet
+	ClientCommonNames map[string]bool
+}
+
+// parseEndpointAuth reads AUTH_<ENDPOINT> (a comma-separated list of checks) and the settings
+// of those checks. hmacSecret is used when AUTH_<ENDPOINT>_HMAC_SECRET is not set.
+func parseEndpointAuth(endpoint, hmacSecret string) (EndpointAuth, error) {
+	prefix := "AUTH_" + strings.ToUpper(endpoint)
+	auth := EndpointAuth{
+		HMACSecret:        getEnv(prefix+"_HMAC_SECRET", hmacSecret),
+		ClientCommonNames: parseList(getEnv(prefix+"_CLIENT_CNS", "")),
+	}
+
+	for _, check := range strings.Split(getEnv(prefix, ""), ",") {
+		check = strings.TrimSpace(check)
+		switch check {
+		case "":
+			continue
+		case authHMAC, authBearer, authIPAllowlist, authMTLS:
+			auth.Checks = append(auth.Checks, check)
+		default:
+			return auth, fmt.Errorf("unknown auth check %q in %s", check, prefix)
+		}
+	}
+
+	switch policy := getEnv(prefix+"_POLICY", "all"); policy {
+	case "all":
+		auth.RequireAll = true
+	case "any":
+	default:
+		return auth, fmt.Errorf("invalid %s_POLICY %q, expected any or all", prefix, policy)
+	}
+
+	for _, hashHex := range strings.Split(getEnv(prefix+"_BEARER_TOKENS", ""), ",") {
+		if hashHex = strings.TrimSpace(hashHex); hashHex == "" {
+			continue
+		}
+		hash, err := hex.DecodeString(hashHex)
+		if err != nil || len(hash) != sha256.Size {
+			return auth, fmt.Errorf("invalid SHA-256 hash in %s_BEARER_TOKENS", prefix)
+		}
+		var tokenHash [sha256.Size]byte
+		copy(tokenHash[:], hash)
+		auth.BearerTokenHashes = append(auth.BearerTokenHashes, tokenHash)
+	}
+
+	for _, cidr := range strings.Split(getEnv(prefix+"_ALLOWED_IPS", ""), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return auth, fmt.Errorf("invalid network %q in %s_ALLOWED_IPS: %w", cidr, prefix, err)
+		}
+		auth.AllowedNetworks = append(auth.AllowedNetworks, network)
+	}
+
+	for _, check := range auth.Checks {
+		switch {
+		case check == authHMAC && auth.HMACSecret == "":
+			return auth, fmt.Errorf("%s uses hmac but no secret is configured", prefix)
+		case check == authBearer && len(auth.BearerTokenHashes) == 0:
+			return auth, fmt.Errorf("%s uses bearer but %s_BEARER_TOKENS is empty", prefix, prefix)
+		case check == authIPAllowlist && len(auth.AllowedNetworks) == 0:
+			return auth, fmt.Errorf("%s uses ip_allowlist but %s_ALLOWED_IPS is empty", prefix, prefix)
+		}
+	}
+
+	return auth, nil
+}
+
+// loadEndpointAuth reads the authentication chain of every configurable endpoint. The incident.io
+// webhook verifies signatures with WEBHOOK_SECRET by default when the secret is set.
+func loadEndpointAuth(webhookSecret string) (map[string]EndpointAuth, error) {
+	endpointAuth := make(map[string]EndpointAuth)
+	for _, endpoint := range []string{endpointWebhook, endpointJiraWebhook, endpointMetrics} {
+		secret := ""
+		if endpoint == endpointWebhook {
+			secret = webhookSecret
+		}
+
+		auth, err := parseEndpointAuth(endpoint, secret)
+		if err != nil {
+			return nil, err
+		}
+		if endpoint == endpointWebhook && len(auth.Checks) == 0 && webhookSecret != "" {
+			auth.Checks = []string{authHMAC}
+		}
+		if len(auth.Checks) > 0 {
+			endpointAuth[endpoint] = auth
+		}
+	}
+	return endpointAuth, nil
+}
+
+// checksSignature reports whether the chain verifies the body's signature
+func (a EndpointAuth) checksSignature(enforcement string) bool {
+	if enforcement == signatureEnforcementOff {
+		return false
+	}
+	for _, check := range a.Checks {
+		if check == authHMAC {
+			return true
+		}
+	}
+	return false
+}
+
+// runCheck applies one authentication check to a request whose body has already been read
+func (a EndpointAuth) runCheck(check string, r *http.Request, body []byte) error {
+	switch check {
+	case authHMAC:
+		return verifyWebhookSignature(r, body, a.HMACSecret, time.Now())
+	case authBearer:
+		return a.verifyBearerToken(r)
+	case authIPAllowlist:
+		return a.verifyRemoteIP(r)
+	case authMTLS:
+		return a.verifyClientCertificate(r)
+	}
+	return fmt.Errorf("unknown auth check %q", check)
+}
+
+// verifyWebhookSignature checks an incident.io signature (webhook-id, webhook-timestamp and
+// webhook-signature headers) or, for Jira webhooks, an X-Hub-Signature header
+func verifyWebhookSignature(r *http.Request, body []byte, secret string, now time.Time) error {
+	if signature := r.Header.Get("X-Hub-Signature"); signature != "" && r.Header.Get("webhook-signature") == "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+
+	id, timestamp, signatures := r.Header.Get("webhook-id"), r.Header.Get("webhook-timestamp"), r.Header.Get("webhook-signature")
+	if id == "" || timestamp == "" || signatures == "" {
+		return errors.New("missing signature headers")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > webhookSignatureTolerance || age < -webhookSignatureTolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+
+	expected, err := webhookSignature(id, timestamp, body, secret)
+	if err != nil {
+		return err
+	}
+
+	for _, signature := range strings.Fields(signatures) {
+		version, encoded, found := strings.Cut(signature, ",")
+		if !found || version != "v1" {
+			continue
+		}
+		if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+// webhookSignature computes the incident.io signature of a delivery. Secrets prefixed with
+// "whsec_" are base64-encoded keys.
+func webhookSignature(id, timestamp string, body []byte, secret string) ([]byte, error) {
+	key := []byte(secret)
+	if encoded, found := strings.CutPrefix(secret, "whsec_"); found {
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, errors.New("invalid signing secret")
+		}
+	}
+
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%s.", id, timestamp)
+	mac.Write(body)
+	return mac.Sum(nil), nil
+}
+
+func (a EndpointAuth) verifyBearerToken(r *http.Request) error {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return errors.New("missing bearer token")
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	for _, tokenHash := range a.BearerTokenHashes {
+		if subtle.ConstantTimeCompare(hash[:], tokenHash[:]) == 1 {
+			return nil
+		}
+	}
+	return errors.New("unknown bearer token")
+}
+
+func (a EndpointAuth) verifyRemoteIP(r *http.Request) error {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid remote address %s", r.RemoteAddr)
+	}
+
+	for _, network := range a.AllowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not in the allowlist", ip)
+}
+
+func (a EndpointAuth) verifyClientCertificate(r *http.Request) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return errors.New("no verified client certificate")
+	}
+	if len(a.ClientCommonNames) == 0 {
+		return nil
+	}
+
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if !a.ClientCommonNames[commonName] {
+		return fmt.Errorf("client certificate %q is not allowed", commonName)
+	}
+	return nil
+}
+
+// requireAuth wraps an endpoint handler with the endpoint's authentication chain, if configured
+func (s *IncidentJiraSync) requireAuth(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	auth, configured := s.config.EndpointAuth[endpoint]
+	if !configured {
+		return next
+	}
+	return s.authenticate(endpoint, auth, next)
+}
+
+// authenticate wraps an endpoint handler with an authentication chain
+func (s *IncidentJiraSync) authenticate(endpoint string, auth EndpointAuth, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Only signature checks need the body; it is read once and handed on to the handler
+		var body []byte
+		if r.Body != nil && auth.checksSignature(s.config.SignatureEnforcement) {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				rejectBody(w, r, endpoint, err)
+				return
+			}
+			r.Body = newBufferedBody(body)
+		}
+
+		var failures []string
+		for _, check := range auth.Checks {
+			if check == authHMAC && s.config.SignatureEnforcement == signatureEnforcementOff {
+				continue
+			}
+			err := auth.runCheck(check, r, body)
+			if check == authHMAC && err != nil {
+				signatureMismatchesTotal.inc(endpoint, s.config.SignatureEnforcement)
+			}
+			// Reporting only skips a failing check: a valid signature still admits the request as
+			// it does under enforce
+			if check == authHMAC && err != nil && s.config.SignatureEnforcement == signatureEnforcementReport {
+				log.Printf("Signature mismatch on %s request from %s, processing it anyway: %v", endpoint, r.RemoteAddr, err)
+				continue
+			}
+			if err == nil && !auth.RequireAll {
+				next(w, r)
+				return
+			}
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", check, err))
+				if auth.RequireAll {
+					break
+				}
+			}
+		}
+
+		if len(failures) == 0 {
+			next(w, r)
+			return
+		}
+
+		log.Printf("Rejected %s request from %s: %s", endpoint, r.RemoteAddr, strings.Join(failures, "; "))
+		authFailuresTotal.inc(endpoint)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+var (
+	authFailuresTotal = newCounterVec(
+		"incident_jira_webhook_auth_failures_total",
+		"Inbound requests rejected by the authentication chain, by endpoint.",
+		"endpoint")
+	signatureMismatchesTotal = newCounterVec(
+		"incident_jira_webhook_signature_mismatches_total",
+		"Inbound requests whose signature failed the hmac check, by endpoint and SIGNATURE_ENFORCEMENT mode.",
+		"endpoint", "enforcement")
+)
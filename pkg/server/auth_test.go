@@ -0,0 +1,448 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "secret"
+
+// signIncidentIO sets the incident.io signature headers of a request
+func signIncidentIO(t *testing.T, r *http.Request, body string, sentAt time.Time) {
+	t.Helper()
+	timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+	signature, err := webhookSignature("msg_1", timestamp, []byte(body), testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("webhook-id", "msg_1")
+	r.Header.Set("webhook-timestamp", timestamp)
+	r.Header.Set("webhook-signature", "v1,"+base64.StdEncoding.EncodeToString(signature))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	const body = `{"event_type": "public_incident.incident_updated_v2"}`
+	now := time.Now()
+	hubSignature := func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name    string
+		sign    func(r *http.Request)
+		wantErr string
+	}{
+		{
+			name: "incident.io",
+			sign: func(r *http.Request) { signIncidentIO(t, r, body, now) },
+		},
+		{
+			name: "one of several signatures",
+			sign: func(r *http.Request) {
+				signIncidentIO(t, r, body, now)
+				r.Header.Set("webhook-signature", "v1,bm90IGl0 v2,abc "+r.Header.Get("webhook-signature"))
+			},
+		},
+		{
+			name:    "stale",
+			sign:    func(r *http.Request) { signIncidentIO(t, r, body, now.Add(-time.Hour)) },
+			wantErr: "signature timestamp outside tolerance",
+		},
+		{
+			name: "other body",
+			sign: func(r *http.Request) {
+				signIncidentIO(t, r, body+" ", now)
+			},
+			wantErr: "signature mismatch",
+		},
+		{
+			name:    "unsigned",
+			sign:    func(r *http.Request) {},
+			wantErr: "missing signature headers",
+		},
+		{
+			name: "Jira",
+			sign: func(r *http.Request) { r.Header.Set("X-Hub-Signature", hubSignature(testSecret)) },
+		},
+		{
+			name:    "Jira with another secret",
+			sign:    func(r *http.Request) { r.Header.Set("X-Hub-Signature", hubSignature("other")) },
+			wantErr: "signature mismatch",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+			test.sign(r)
+			err := verifyWebhookSignature(r, []byte(body), testSecret, now)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("verifyWebhookSignature() = %v, want nil", err)
+			case test.wantErr != "" && (err == nil || err.Error() != test.wantErr):
+				t.Errorf("verifyWebhookSignature() = %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookSignatureEncodedSecret(t *testing.T) {
+	key := []byte("raw key bytes")
+	encoded, err := webhookSignature("msg_1", "1700000000", []byte("{}"), "whsec_"+base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := webhookSignature("msg_1", "1700000000", []byte("{}"), string(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hmac.Equal(encoded, raw) {
+		t.Error("whsec_ secret wasn't decoded to its key")
+	}
+	if _, err := webhookSignature("msg_1", "1700000000", []byte("{}"), "whsec_!"); err == nil {
+		t.Error("invalid whsec_ secret was accepted")
+	}
+}
+
+func TestAuthChain(t *testing.T) {
+	const body = `{"event_type": "public_incident.incident_updated_v2"}`
+	tokenHash := sha256.Sum256([]byte("token"))
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	auth := func(requireAll bool, checks ...string) EndpointAuth {
+		return EndpointAuth{
+			Checks:            checks,
+			RequireAll:        requireAll,
+			HMACSecret:        testSecret,
+			BearerTokenHashes: [][sha256.Size]byte{tokenHash},
+			AllowedNetworks:   []*net.IPNet{network},
+			ClientCommonNames: map[string]bool{"incident.io": true},
+		}
+	}
+	signed := func(r *http.Request) { signIncidentIO(t, r, body, time.Now()) }
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }
+	inNetwork := func(r *http.Request) { r.RemoteAddr = "10.1.2.3:4567" }
+	certificate := func(commonName string) func(r *http.Request) {
+		return func(r *http.Request) {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+	}
+
+	tests := []struct {
+		name        string
+		auth        EndpointAuth
+		enforcement string
+		request     []func(r *http.Request)
+		want        int
+	}{
+		{
+			name:    "all passing",
+			auth:    auth(true, authHMAC, authBearer, authIPAllowlist),
+			request: []func(r *http.Request){signed, bearer, inNetwork},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "all with one failing",
+			auth:    auth(true, authHMAC, authBearer, authIPAllowlist),
+			request: []func(r *http.Request){signed, bearer},
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name:    "any with the last passing",
+			auth:    auth(false, authBearer, authIPAllowlist, authHMAC),
+			request: []func(r *http.Request){signed},
+			want:    http.StatusOK,
+		},
+		{
+			name: "any with none passing",
+			auth: auth(false, authBearer, authIPAllowlist),
+			request: []func(r *http.Request){func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer other")
+				r.RemoteAddr = "192.0.2.1:4567"
+			}},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:    "allowed client certificate",
+			auth:    auth(true, authMTLS),
+			request: []func(r *http.Request){certificate("incident.io")},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "other client certificate",
+			auth:    auth(true, authMTLS),
+			request: []func(r *http.Request){certificate("example.com")},
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name: "no client certificate",
+			auth: auth(true, authMTLS),
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:        "unsigned when reporting",
+			auth:        auth(true, authHMAC, authBearer),
+			enforcement: signatureEnforcementReport,
+			request:     []func(r *http.Request){bearer},
+			want:        http.StatusOK,
+		},
+		{
+			name:        "unsigned when off",
+			auth:        auth(true, authHMAC),
+			enforcement: signatureEnforcementOff,
+			want:        http.StatusOK,
+		},
+		{
+			name:        "signed when reporting",
+			auth:        auth(false, authHMAC, authBearer),
+			enforcement: signatureEnforcementReport,
+			request:     []func(r *http.Request){signed},
+			want:        http.StatusOK,
+		},
+		{
+			name:        "reporting doesn't pass other checks",
+			auth:        auth(false, authHMAC, authBearer),
+			enforcement: signatureEnforcementReport,
+			want:        http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			enforcement := test.enforcement
+			if enforcement == "" {
+				enforcement = signatureEnforcementEnforce
+			}
+			s := &IncidentJiraSync{config: Config{SignatureEnforcement: enforcement}}
+
+			var handled string
+			handler := s.authenticate(endpointWebhook, test.auth, func(w http.ResponseWriter, r *http.Request) {
+				// The body read for the signature is handed on
+				data, _ := io.ReadAll(r.Body)
+				handled = string(data)
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+			r.RemoteAddr = "192.0.2.1:4567"
+			for _, set := range test.request {
+				set(r)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != test.want {
+				t.Errorf("status = %d, want %d", w.Code, test.want)
+			}
+			if test.want == http.StatusOK && handled != body {
+				t.Errorf("handler read body %q, want %q", handled, body)
+			}
+		})
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	tokenHash := sha256.Sum256([]byte("token"))
+	s := &IncidentJiraSync{config: Config{
+		SignatureEnforcement: signatureEnforcementEnforce,
+		EndpointAuth: map[string]EndpointAuth{
+			endpointMetrics: {Checks: []string{authBearer}, RequireAll: true, BearerTokenHashes: [][sha256.Size]byte{tokenHash}},
+		},
+	}}
+	handled := 0
+	handler := func(w http.ResponseWriter, r *http.Request) { handled++ }
+
+	// Endpoints without a chain are left open
+	s.requireAuth(endpointJiraWebhook, handler)(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/jira/webhook", nil))
+	if handled != 1 {
+		t.Errorf("endpoint without a chain handled %d requests, want 1", handled)
+	}
+
+	tests := []struct {
+		authorization string
+		want          int
+	}{
+		{authorization: "Bearer token", want: http.StatusOK},
+		{authorization: "Bearer other", want: http.StatusUnauthorized},
+		{authorization: "token", want: http.StatusUnauthorized},
+		{authorization: "Bearer ", want: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		handled = 0
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.Header.Set("Authorization", test.authorization)
+		w := httptest.NewRecorder()
+		s.requireAuth(endpointMetrics, handler)(w, r)
+		if wantHandled := map[bool]int{true: 1}[test.want == http.StatusOK]; w.Code != test.want || handled != wantHandled {
+			t.Errorf("Authorization %q: status = %d after %d handled, want %d", test.authorization, w.Code, handled, test.want)
+		}
+	}
+}
+
+func TestVerifyRemoteIP(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	auth := EndpointAuth{AllowedNetworks: []*net.IPNet{network}}
+	tests := []struct {
+		remoteAddr string
+		wantErr    bool
+	}{
+		{remoteAddr: "10.1.2.3:4567"},
+		{remoteAddr: "10.1.2.3"},
+		{remoteAddr: "192.0.2.1:4567", wantErr: true},
+		{remoteAddr: "[2001:db8::1]:4567", wantErr: true},
+		{remoteAddr: "unknown", wantErr: true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.RemoteAddr = test.remoteAddr
+		if err := auth.verifyRemoteIP(r); (err != nil) != test.wantErr {
+			t.Errorf("verifyRemoteIP(%s) = %v, want error %v", test.remoteAddr, err, test.wantErr)
+		}
+	}
+}
+
+func TestSignatureEnforcementCountsMismatches(t *testing.T) {
+	mismatches := func(enforcement string) float64 {
+		signatureMismatchesTotal.mu.Lock()
+		defer signatureMismatchesTotal.mu.Unlock()
+		return signatureMismatchesTotal.values[endpointJiraWebhook+labelSeparator+enforcement]
+	}
+	auth := EndpointAuth{Checks: []string{authHMAC}, RequireAll: true, HMACSecret: testSecret}
+
+	tests := []struct {
+		enforcement    string
+		want           int
+		wantMismatches float64
+	}{
+		{enforcement: signatureEnforcementEnforce, want: http.StatusUnauthorized, wantMismatches: 1},
+		{enforcement: signatureEnforcementReport, want: http.StatusOK, wantMismatches: 1},
+		{enforcement: signatureEnforcementOff, want: http.StatusOK},
+	}
+	for _, test := range tests {
+		s := &IncidentJiraSync{config: Config{SignatureEnforcement: test.enforcement}}
+		before := mismatches(test.enforcement)
+		handler := s.authenticate(endpointJiraWebhook, auth, func(w http.ResponseWriter, r *http.Request) {})
+
+		r := httptest.NewRequest(http.MethodPost, "/jira/webhook", strings.NewReader(`{}`))
+		r.Header.Set("X-Hub-Signature", "sha256=00")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.enforcement, w.Code, test.want)
+		}
+		if counted := mismatches(test.enforcement) - before; counted != test.wantMismatches {
+			t.Errorf("%s: counted %v mismatches, want %v", test.enforcement, counted, test.wantMismatches)
+		}
+	}
+}
+
+func TestLoadConfigSignatureEnforcement(t *testing.T) {
+	setRequiredEnv(t)
+	for _, mode := range []string{signatureEnforcementOff, signatureEnforcementReport, signatureEnforcementEnforce} {
+		t.Setenv("SIGNATURE_ENFORCEMENT", mode)
+		if config, err := LoadConfig(); err != nil || config.SignatureEnforcement != mode {
+			t.Errorf("LoadConfig() with %s = %q, %v", mode, config.SignatureEnforcement, err)
+		}
+	}
+	t.Setenv("SIGNATURE_ENFORCEMENT", "warn")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "invalid SIGNATURE_ENFORCEMENT") {
+		t.Errorf("LoadConfig() with an unknown mode = %v, want an error", err)
+	}
+}
+
+func TestParseEndpointAuth(t *testing.T) {
+	tokenHash := sha256.Sum256([]byte("token"))
+	t.Setenv("AUTH_METRICS", "bearer, ip_allowlist")
+	t.Setenv("AUTH_METRICS_POLICY", "any")
+	t.Setenv("AUTH_METRICS_BEARER_TOKENS", hex.EncodeToString(tokenHash[:]))
+	t.Setenv("AUTH_METRICS_ALLOWED_IPS", "10.0.0.0/8, 192.0.2.1, 2001:db8::1")
+
+	auth, err := parseEndpointAuth(endpointMetrics, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auth.Checks) != 2 || auth.Checks[0] != authBearer || auth.Checks[1] != authIPAllowlist || auth.RequireAll {
+		t.Errorf("checks = %q, require all = %v, want bearer and ip_allowlist, any", auth.Checks, auth.RequireAll)
+	}
+	if len(auth.AllowedNetworks) != 3 || auth.AllowedNetworks[1].String() != "192.0.2.1/32" || auth.AllowedNetworks[2].String() != "2001:db8::1/128" {
+		t.Errorf("networks = %v", auth.AllowedNetworks)
+	}
+
+	invalid := map[string]string{
+		"AUTH_METRICS":               "bearer,basic",
+		"AUTH_METRICS_POLICY":        "most",
+		"AUTH_METRICS_BEARER_TOKENS": "not-hex",
+		"AUTH_METRICS_ALLOWED_IPS":   "10.0.0.0/33",
+	}
+	for key, value := range invalid {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := parseEndpointAuth(endpointMetrics, ""); err == nil {
+				t.Errorf("%s=%s was accepted", key, value)
+			}
+		})
+	}
+
+	for check, settings := range map[string]string{authBearer: "AUTH_METRICS_BEARER_TOKENS", authIPAllowlist: "AUTH_METRICS_ALLOWED_IPS"} {
+		t.Run(check+" without settings", func(t *testing.T) {
+			t.Setenv("AUTH_METRICS", check)
+			t.Setenv(settings, "")
+			if _, err := parseEndpointAuth(endpointMetrics, ""); err == nil || !strings.Contains(err.Error(), settings+" is empty") {
+				t.Errorf("%s without %s: err = %v", check, settings, err)
+			}
+		})
+	}
+
+	t.Run("endpoint secret", func(t *testing.T) {
+		t.Setenv("AUTH_METRICS", "hmac")
+		t.Setenv("AUTH_METRICS_HMAC_SECRET", "metrics-secret")
+		if auth, err := parseEndpointAuth(endpointMetrics, "shared-secret"); err != nil || auth.HMACSecret != "metrics-secret" {
+			t.Errorf("secret = %q, %v, want AUTH_METRICS_HMAC_SECRET", auth.HMACSecret, err)
+		}
+	})
+
+	t.Run("hmac without a secret", func(t *testing.T) {
+		t.Setenv("AUTH_METRICS", "hmac")
+		if _, err := parseEndpointAuth(endpointMetrics, ""); err == nil {
+			t.Error("hmac without a secret was accepted")
+		}
+	})
+}
+
+func TestLoadEndpointAuthDefaultsToSignatures(t *testing.T) {
+	for _, key := range []string{"AUTH_WEBHOOK", "AUTH_WEBHOOK_HMAC_SECRET", "AUTH_JIRA_WEBHOOK", "AUTH_METRICS"} {
+		t.Setenv(key, "")
+	}
+	endpointAuth, err := loadEndpointAuth(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook, configured := endpointAuth[endpointWebhook]
+	if !configured || len(webhook.Checks) != 1 || webhook.Checks[0] != authHMAC || webhook.HMACSecret != testSecret {
+		t.Errorf("webhook auth = %+v, want hmac with WEBHOOK_SECRET", webhook)
+	}
+	if _, configured := endpointAuth[endpointMetrics]; configured {
+		t.Error("metrics auth configured without AUTH_METRICS")
+	}
+
+	if endpointAuth, err = loadEndpointAuth(""); err != nil {
+		t.Fatal(err)
+	}
+	if len(endpointAuth) != 0 {
+		t.Errorf("endpoint auth without a secret = %+v, want none", endpointAuth)
+	}
+}
@@ -0,0 +1,469 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// backfillEventType is the event type backfilled incidents are processed as
+const backfillEventType = "backfill"
+
+// backfillCheckpointInterval bounds how often progress is written to the checkpoint file
+const backfillCheckpointInterval = 5 * time.Second
+
+// Backfill states
+const (
+	backfillListing  = "listing"
+	backfillRunning  = "running"
+	backfillFinished = "finished"
+	backfillCanceled = "canceled"
+	backfillFailed   = "failed"
+)
+
+// backfillRequest is the body of POST /admin/backfill/start
+type backfillRequest struct {
+	// IncidentIDs limits the backfill to these incidents; otherwise every incident is synced
+	IncidentIDs []string `json:"incident_ids,omitempty"`
+	// Resume continues the backfill recorded in the checkpoint file
+	Resume bool `json:"resume,omitempty"`
+	// Organization names the INCIDENT_ORGANIZATIONS organization to backfill, rather than
+	// that of INCIDENT_API_TOKEN
+	Organization string `json:"organization,omitempty"`
+}
+
+// backfillCheckpoint is saved to BACKFILL_CHECKPOINT_FILE so an interrupted backfill can resume
+// where it stopped. Failed incidents are retried on resume.
+type backfillCheckpoint struct {
+	// Version is the file format, see backfillCheckpointMigrations
+	Version     int               `json:"version"`
+	IncidentIDs []string          `json:"incident_ids,omitempty"`
+	Completed   []string          `json:"completed"`
+	Failed      map[string]string `json:"failed,omitempty"`
+	StartedAt   time.Time         `json:"started_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	Finished    bool              `json:"finished"`
+	// Organization is the INCIDENT_ORGANIZATIONS organization backfilled, if any
+	Organization string `json:"organization,omitempty"`
+}
+
+// backfillProgress is reported by GET /admin/backfill
+type backfillProgress struct {
+	State              string            `json:"state"`
+	Total              int               `json:"total"`
+	Completed          int               `json:"completed"`
+	Skipped            int               `json:"skipped"`
+	Failed             int               `json:"failed"`
+	Remaining          int               `json:"remaining"`
+	Resumed            int               `json:"resumed,omitempty"`
+	StartedAt          time.Time         `json:"started_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+	IncidentsPerMinute float64           `json:"incidents_per_minute"`
+	EstimatedRemaining string            `json:"estimated_remaining,omitempty"`
+	BulkEdits          int               `json:"bulk_edits,omitempty"`
+	BulkEditedIssues   int               `json:"bulk_edited_issues,omitempty"`
+	IndividualWrites   int               `json:"individual_writes,omitempty"`
+	Errors             map[string]string `json:"errors,omitempty"`
+	Error              string            `json:"error,omitempty"`
+}
+
+// backfillRun is a backfill in progress, or the last one
+type backfillRun struct {
+	mu         sync.Mutex
+	progress   backfillProgress
+	checkpoint backfillCheckpoint
+	lastSaved  time.Time
+	cancel     context.CancelFunc
+	// bulk coalesces the run's writes into bulk edits when BACKFILL_BULK is on
+	bulk *bulkWriter
+}
+
+// backfillItem is an incident to backfill; Incident is nil when only the ID is known
+type backfillItem struct {
+	ID       string
+	Incident *incidentio.Incident
+}
+
+// loadBackfillCheckpoint reads the checkpoint file
+func loadBackfillCheckpoint(path string) (backfillCheckpoint, error) {
+	var checkpoint backfillCheckpoint
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return checkpoint, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	// Files from older releases are read in the current format
+	if data, _, err = migrateBackfillCheckpoint(data); err == nil {
+		err = json.Unmarshal(data, &checkpoint)
+	}
+	if err != nil {
+		return checkpoint, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	return checkpoint, nil
+}
+
+// saveBackfillCheckpoint writes the checkpoint file atomically, in the current format
+func saveBackfillCheckpoint(path string, checkpoint backfillCheckpoint) error {
+	checkpoint.Version = backfillCheckpointVersion
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// snapshot returns a copy of the run's progress
+func (b *backfillRun) snapshot() backfillProgress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	progress := b.progress
+	if b.bulk != nil {
+		b.bulk.mu.Lock()
+		progress.BulkEdits, progress.BulkEditedIssues, progress.IndividualWrites = b.bulk.edits, b.bulk.issues, b.bulk.fallbacks
+		b.bulk.mu.Unlock()
+	}
+	progress.Errors = make(map[string]string, len(b.checkpoint.Failed))
+	for id, message := range b.checkpoint.Failed {
+		progress.Errors[id] = message
+	}
+
+	done := progress.Completed + progress.Skipped + progress.Failed - progress.Resumed
+	if elapsed := time.Since(progress.StartedAt); done > 0 && elapsed > 0 {
+		progress.IncidentsPerMinute = float64(done) / elapsed.Minutes()
+		if progress.Remaining > 0 && progress.State == backfillRunning {
+			remaining := time.Duration(float64(progress.Remaining) / progress.IncidentsPerMinute * float64(time.Minute))
+			progress.EstimatedRemaining = remaining.Round(time.Second).String()
+		}
+	}
+	return progress
+}
+
+// recordBackfillResult updates progress after an incident and saves the checkpoint when it is due
+func (s *IncidentJiraSync) recordBackfillResult(run *backfillRun, id, outcome string, err error) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	switch outcome {
+	case "skipped":
+		run.progress.Skipped++
+	case "failed":
+		run.progress.Failed++
+		run.checkpoint.Failed[id] = err.Error()
+	default:
+		run.progress.Completed++
+	}
+	if outcome != "failed" {
+		run.checkpoint.Completed = append(run.checkpoint.Completed, id)
+	}
+	run.progress.Remaining--
+	run.progress.UpdatedAt = time.Now()
+	backfillIncidentsTotal.inc(outcome)
+
+	if s.config.BackfillCheckpointFile != "" && time.Since(run.lastSaved) >= backfillCheckpointInterval {
+		s.saveBackfillProgress(run)
+	}
+}
+
+// saveBackfillProgress writes the run's checkpoint; run.mu must be held
+func (s *IncidentJiraSync) saveBackfillProgress(run *backfillRun) {
+	run.checkpoint.UpdatedAt = time.Now().UTC()
+	if err := saveBackfillCheckpoint(s.config.BackfillCheckpointFile, run.checkpoint); err != nil {
+		log.Printf("Warning: failed to save backfill checkpoint: %v", err)
+		return
+	}
+	run.lastSaved = time.Now()
+}
+
+// startBackfill starts a backfill in the background, unless one is already running
+func (s *IncidentJiraSync) startBackfill(request backfillRequest) (*backfillRun, error) {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+
+	if s.backfill != nil {
+		if state := s.backfill.snapshot().State; state == backfillListing || state == backfillRunning {
+			return nil, errBackfillRunning
+		}
+	}
+
+	checkpoint := backfillCheckpoint{IncidentIDs: request.IncidentIDs, Organization: request.Organization, StartedAt: time.Now().UTC()}
+	if request.Resume {
+		if s.config.BackfillCheckpointFile == "" {
+			return nil, errors.New("resuming requires BACKFILL_CHECKPOINT_FILE")
+		}
+		var err error
+		if checkpoint, err = loadBackfillCheckpoint(s.config.BackfillCheckpointFile); err != nil {
+			return nil, err
+		}
+		if checkpoint.Finished {
+			return nil, errors.New("the checkpointed backfill already finished")
+		}
+	}
+	if _, err := s.lookupOrganization(checkpoint.Organization); err != nil {
+		return nil, err
+	}
+	// Failed incidents are retried
+	checkpoint.Failed = make(map[string]string)
+
+	ctx, cancel := context.WithCancel(withOrganization(context.Background(), checkpoint.Organization))
+	run := &backfillRun{
+		progress: backfillProgress{
+			State:     backfillListing,
+			Completed: len(checkpoint.Completed),
+			Resumed:   len(checkpoint.Completed),
+			StartedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		checkpoint: checkpoint,
+		cancel:     cancel,
+	}
+	if s.config.BackfillBulk {
+		run.bulk = newBulkWriter(s)
+		ctx = withBulkWriter(ctx, run.bulk)
+	}
+	s.backfill = run
+
+	go s.runBackfill(ctx, run)
+	return run, nil
+}
+
+var errBackfillRunning = errors.New("a backfill is already running")
+
+// runBackfill lists the incidents to backfill and syncs them with limited concurrency and rate
+func (s *IncidentJiraSync) runBackfill(ctx context.Context, run *backfillRun) {
+	defer run.cancel()
+
+	run.mu.Lock()
+	completed := make(map[string]bool, len(run.checkpoint.Completed))
+	for _, id := range run.checkpoint.Completed {
+		completed[id] = true
+	}
+	incidentIDs := run.checkpoint.IncidentIDs
+	run.mu.Unlock()
+
+	items, err := s.listBackfillItems(ctx, incidentIDs, completed)
+
+	run.mu.Lock()
+	if err != nil {
+		run.progress.State = backfillFailed
+		run.progress.Error = err.Error()
+		run.mu.Unlock()
+		log.Printf("Backfill failed: %v", err)
+		return
+	}
+	run.progress.State = backfillRunning
+	run.progress.Total = len(items) + run.progress.Resumed
+	run.progress.Remaining = len(items)
+	run.mu.Unlock()
+	log.Printf("Backfilling %d incidents (%d already done) with %d workers at up to %d per minute",
+		len(items), len(completed), s.config.BackfillConcurrency, s.config.BackfillRate)
+
+	rate := time.NewTicker(time.Minute / time.Duration(s.config.BackfillRate))
+	defer rate.Stop()
+
+	queue := make(chan backfillItem)
+	var workers sync.WaitGroup
+	for i := 0; i < s.config.BackfillConcurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for item := range queue {
+				outcome, err := s.backfillIncident(ctx, item)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					log.Printf("Backfill of incident %s failed: %v", item.ID, err)
+				}
+				s.recordBackfillResult(run, item.ID, outcome, err)
+			}
+		}()
+	}
+
+feed:
+	for _, item := range items {
+		select {
+		case <-ctx.Done():
+			break feed
+		case <-rate.C:
+		}
+		if err := s.jiraBudget.wait(ctx); err != nil {
+			break feed
+		}
+		select {
+		case <-ctx.Done():
+			break feed
+		case queue <- item:
+		}
+	}
+	close(queue)
+	workers.Wait()
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if ctx.Err() != nil {
+		run.progress.State = backfillCanceled
+	} else {
+		run.progress.State = backfillFinished
+		run.checkpoint.Finished = len(run.checkpoint.Failed) == 0
+	}
+	run.progress.UpdatedAt = time.Now()
+	if s.config.BackfillCheckpointFile != "" {
+		s.saveBackfillProgress(run)
+	}
+	log.Printf("Backfill %s: %d synced, %d skipped, %d failed", run.progress.State, run.progress.Completed, run.progress.Skipped, run.progress.Failed)
+}
+
+// listBackfillItems returns the incidents still to backfill: the given IDs, or every incident
+func (s *IncidentJiraSync) listBackfillItems(ctx context.Context, incidentIDs []string, completed map[string]bool) ([]backfillItem, error) {
+	var items []backfillItem
+	if len(incidentIDs) > 0 {
+		for _, id := range incidentIDs {
+			if !completed[id] {
+				items = append(items, backfillItem{ID: id})
+			}
+		}
+		return items, nil
+	}
+
+	after := ""
+	for {
+		incidents, next, err := s.incidentClient(ctx).ListIncidents(ctx, 0, after)
+		if err != nil {
+			return nil, err
+		}
+		for i := range incidents {
+			if !completed[incidents[i].ID] {
+				items = append(items, backfillItem{ID: incidents[i].ID, Incident: &incidents[i]})
+			}
+		}
+		if next == "" {
+			return items, nil
+		}
+		if next == after {
+			return nil, fmt.Errorf("incident.io returned cursor %q twice", next)
+		}
+		after = next
+	}
+}
+
+// backfillIncident syncs one incident and returns "synced", "skipped" or "failed"
+func (s *IncidentJiraSync) backfillIncident(ctx context.Context, item backfillItem) (string, error) {
+	ctx, cancel := s.processingContext(ctx)
+	defer cancel()
+
+	incident := item.Incident
+	if incident == nil {
+		var err error
+		if incident, err = s.incidentClient(ctx).GetIncident(ctx, item.ID); err != nil {
+			return "failed", err
+		}
+	}
+
+	if incident.ExternalIssueReference.IssueName == "" {
+		return "skipped", nil
+	}
+
+	payload := incidentio.WebhookPayload{EventType: backfillEventType, Incident: *incident}
+	_, err := s.processIncidentUpdate(ctx, payload)
+	if errors.Is(err, errIncidentSkipped) || errors.Is(err, errIssueRestricted) {
+		return "skipped", nil
+	}
+	if err != nil {
+		return "failed", err
+	}
+	return "synced", nil
+}
+
+// adminBackfillHandler reports the progress of the running or last backfill
+func (s *IncidentJiraSync) adminBackfillHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.backfillMu.Lock()
+	run := s.backfill
+	s.backfillMu.Unlock()
+
+	if run == nil {
+		json.NewEncoder(w).Encode(map[string]string{"state": "idle"})
+		return
+	}
+	json.NewEncoder(w).Encode(run.snapshot())
+}
+
+// adminBackfillStartHandler starts a backfill
+func (s *IncidentJiraSync) adminBackfillStartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request backfillRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+	}
+
+	run, err := s.startBackfill(request)
+	if errors.Is(err, errBackfillRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run.snapshot())
+}
+
+// adminBackfillCancelHandler stops the running backfill; it can be resumed from its checkpoint
+func (s *IncidentJiraSync) adminBackfillCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.backfillMu.Lock()
+	run := s.backfill
+	s.backfillMu.Unlock()
+
+	if run == nil {
+		http.Error(w, "No backfill running", http.StatusNotFound)
+		return
+	}
+	run.cancel()
+	json.NewEncoder(w).Encode(map[string]string{"status": "canceling"})
+}
+
+var backfillIncidentsTotal = newCounterVec(
+	"incident_jira_webhook_backfill_incidents_total",
+	"Incidents processed by backfills, by outcome.",
+	"outcome")
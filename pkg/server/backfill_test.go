@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+func TestBackfillCheckpointRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	checkpoint := backfillCheckpoint{
+		Completed: []string{"01A", "01B"},
+		Failed:    map[string]string{"01C": "Jira answered 500"},
+		StartedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := saveBackfillCheckpoint(path, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadBackfillCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint.Version = backfillCheckpointVersion
+	if !reflect.DeepEqual(loaded, checkpoint) {
+		t.Errorf("loaded %+v, want %+v", loaded, checkpoint)
+	}
+
+	if _, err := loadBackfillCheckpoint(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loading a missing checkpoint succeeded")
+	}
+}
+
+func TestRecordBackfillResult(t *testing.T) {
+	s := &IncidentJiraSync{}
+	run := &backfillRun{
+		progress:   backfillProgress{State: backfillRunning, Remaining: 3},
+		checkpoint: backfillCheckpoint{Failed: make(map[string]string)},
+	}
+	s.recordBackfillResult(run, "01A", "synced", nil)
+	s.recordBackfillResult(run, "01B", "skipped", nil)
+	s.recordBackfillResult(run, "01C", "failed", errors.New("Jira answered 500"))
+
+	if run.progress.Completed != 1 || run.progress.Skipped != 1 || run.progress.Failed != 1 || run.progress.Remaining != 0 {
+		t.Errorf("progress = %+v, want one of each outcome and none remaining", run.progress)
+	}
+	// Failed incidents aren't completed, so a resumed backfill retries them
+	if want := []string{"01A", "01B"}; !reflect.DeepEqual(run.checkpoint.Completed, want) {
+		t.Errorf("completed = %v, want %v", run.checkpoint.Completed, want)
+	}
+	if run.checkpoint.Failed["01C"] != "Jira answered 500" {
+		t.Errorf("failed = %v, want 01C's error", run.checkpoint.Failed)
+	}
+}
+
+func TestListBackfillItems(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("after") {
+		case "":
+			w.Write([]byte(`{"incidents": [{"id": "01A"}, {"id": "01B"}], "pagination_meta": {"after": "01B"}}`))
+		case "01B":
+			w.Write([]byte(`{"incidents": [{"id": "01C"}], "pagination_meta": {}}`))
+		default:
+			t.Errorf("unexpected cursor %q", r.URL.Query().Get("after"))
+		}
+	}))
+	defer server.Close()
+
+	client := incidentio.NewClient("token", server.Client())
+	client.BaseURL = server.URL
+	client.Pagination.PageSize = 2
+	s := &IncidentJiraSync{defaultOrganization: &incidentOrganization{client: client}}
+	ctx := context.Background()
+	completed := map[string]bool{"01B": true}
+
+	items, err := s.listBackfillItems(ctx, nil, completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := backfillItemIDs(items); !reflect.DeepEqual(got, []string{"01A", "01C"}) {
+		t.Errorf("listed %v, want 01A and 01C", got)
+	}
+	for _, item := range items {
+		if item.Incident == nil {
+			t.Errorf("%s: listed incident not kept", item.ID)
+		}
+	}
+
+	// Given IDs are backfilled without listing
+	items, err = s.listBackfillItems(ctx, []string{"01X", "01B", "01Y"}, completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := backfillItemIDs(items); !reflect.DeepEqual(got, []string{"01X", "01Y"}) {
+		t.Errorf("listed %v, want 01X and 01Y", got)
+	}
+}
+
+func TestListBackfillItemsRepeatedCursor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"incidents": [{"id": "01A"}], "pagination_meta": {"after": "01A"}}`))
+	}))
+	defer server.Close()
+
+	client := incidentio.NewClient("token", server.Client())
+	client.BaseURL = server.URL
+	client.Pagination.PageSize = 1
+	s := &IncidentJiraSync{defaultOrganization: &incidentOrganization{client: client}}
+
+	if _, err := s.listBackfillItems(context.Background(), nil, nil); err == nil || !strings.Contains(err.Error(), "twice") {
+		t.Errorf("listing with a repeated cursor = %v, want an error", err)
+	}
+}
+
+func backfillItemIDs(items []backfillItem) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestBackfillIncidentSkipsIncidentsWithoutIssue(t *testing.T) {
+	s := &IncidentJiraSync{}
+	outcome, err := s.backfillIncident(context.Background(), backfillItem{ID: "01A", Incident: &incidentio.Incident{ID: "01A"}})
+	if outcome != "skipped" || err != nil {
+		t.Errorf("backfill = %q, %v, want skipped", outcome, err)
+	}
+}
+
+func TestStartBackfillRejects(t *testing.T) {
+	dir := t.TempDir()
+	finished := filepath.Join(dir, "finished.json")
+	if err := saveBackfillCheckpoint(finished, backfillCheckpoint{Finished: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		checkpoint string
+		request    backfillRequest
+		want       string
+	}{
+		{name: "resume without a checkpoint file", request: backfillRequest{Resume: true}, want: "BACKFILL_CHECKPOINT_FILE"},
+		{name: "resume a missing checkpoint", checkpoint: filepath.Join(dir, "missing.json"), request: backfillRequest{Resume: true}, want: "failed to read checkpoint"},
+		{name: "resume a finished backfill", checkpoint: finished, request: backfillRequest{Resume: true}, want: "already finished"},
+	}
+	for _, test := range tests {
+		s := &IncidentJiraSync{config: Config{BackfillCheckpointFile: test.checkpoint}}
+		if _, err := s.startBackfill(test.request); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: err = %v, want %q", test.name, err, test.want)
+		}
+		if s.backfill != nil {
+			t.Errorf("%s: a rejected backfill was started", test.name)
+		}
+	}
+
+	// Only one backfill runs at a time
+	s := &IncidentJiraSync{backfill: &backfillRun{progress: backfillProgress{State: backfillRunning}}}
+	if _, err := s.startBackfill(backfillRequest{}); !errors.Is(err, errBackfillRunning) {
+		t.Errorf("second backfill: err = %v, want %v", err, errBackfillRunning)
+	}
+}
+
+func TestBackfillSnapshotEstimatesRemaining(t *testing.T) {
+	run := &backfillRun{
+		progress: backfillProgress{
+			State:     backfillRunning,
+			Completed: 30,
+			Resumed:   10,
+			Remaining: 40,
+			StartedAt: time.Now().Add(-2 * time.Minute),
+		},
+		checkpoint: backfillCheckpoint{Failed: map[string]string{"01C": "boom"}},
+	}
+	progress := run.snapshot()
+
+	// 20 incidents were done by this run in two minutes
+	if rate := fmt.Sprintf("%.0f", progress.IncidentsPerMinute); rate != "10" {
+		t.Errorf("rate = %s per minute, want 10", rate)
+	}
+	if progress.EstimatedRemaining != "4m0s" {
+		t.Errorf("estimated remaining = %q, want 4m0s", progress.EstimatedRemaining)
+	}
+	if progress.Errors["01C"] != "boom" {
+		t.Errorf("errors = %v, want 01C's", progress.Errors)
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// bulkPollInterval is how often a submitted bulk edit is checked
+const bulkPollInterval = 2 * time.Second
+
+// bulkTaskTimeout bounds a bulk edit, including the individual writes it falls back to
+const bulkTaskTimeout = 5 * time.Minute
+
+// bulkWriter coalesces identical field writes to different issues during a backfill into Jira
+// bulk edits. Each write still waits for its own outcome, so the sync behaves as it does with
+// individual writes. Writes the bulk edit API can't express are made individually.
+type bulkWriter struct {
+	s *IncidentJiraSync
+
+	// Jira fields by ID, loaded on first use
+	fieldsMu sync.Mutex
+	fields   map[string]jira.Field
+
+	mu      sync.Mutex
+	pending map[string]*bulkGroup
+
+	// Progress, under mu
+	edits     int
+	issues    int
+	fallbacks int
+}
+
+// bulkGroup is the writes waiting to be sent as one bulk edit
+type bulkGroup struct {
+	fieldIDs []string
+	input    map[string]interface{}
+	writes   []bulkPendingWrite
+	timer    *time.Timer
+}
+
+type bulkPendingWrite struct {
+	issueKey string
+	update   jira.UpdateRequest
+	done     chan error
+}
+
+func newBulkWriter(s *IncidentJiraSync) *bulkWriter {
+	return &bulkWriter{s: s, pending: make(map[string]*bulkGroup)}
+}
+
+type bulkWriterKey struct{}
+
+// withBulkWriter routes the Jira writes made under ctx through a bulk writer
+func withBulkWriter(ctx context.Context, writer *bulkWriter) context.Context {
+	return context.WithValue(ctx, bulkWriterKey{}, writer)
+}
+
+func bulkWriterFrom(ctx context.Context) *bulkWriter {
+	writer, _ := ctx.Value(bulkWriterKey{}).(*bulkWriter)
+	return writer
+}
+
+// jiraFields returns the Jira fields by ID, or nil if they can't be listed
+func (b *bulkWriter) jiraFields(ctx context.Context) map[string]jira.Field {
+	b.fieldsMu.Lock()
+	defer b.fieldsMu.Unlock()
+	if b.fields != nil {
+		return b.fields
+	}
+
+	fields, err := b.s.jira.Fields(ctx)
+	if err != nil {
+		log.Printf("Warning: bulk edits disabled until Jira fields can be listed: %v", err)
+		return nil
+	}
+	b.fields = make(map[string]jira.Field, len(fields))
+	for _, field := range fields {
+		b.fields[field.ID] = field
+	}
+	return b.fields
+}
+
+// bulkEditInput converts field values to the editedFieldsInput of a bulk edit. It reports false
+// when a field is of a kind the bulk edit API doesn't take, or is being cleared.
+func bulkEditInput(fields map[string]jira.Field, values map[string]interface{}) (map[string]interface{}, bool) {
+	input := make(map[string][]map[string]interface{})
+	for fieldID, value := range values {
+		field, known := fields[fieldID]
+		if !known {
+			return nil, false
+		}
+
+		switch schema := field.Schema; {
+		case schema.Type == "number":
+			number, isNumber := value.(float64)
+			if !isNumber {
+				return nil, false
+			}
+			input["clearableNumberFields"] = append(input["clearableNumberFields"], map[string]interface{}{"fieldId": fieldID, "value": number})
+		case schema.Type == "date":
+			date, isString := value.(string)
+			if !isString {
+				return nil, false
+			}
+			input["dateFields"] = append(input["dateFields"], map[string]interface{}{"dateFieldId": fieldID, "date": map[string]string{"formattedDate": date}})
+		case schema.Type == "string" && strings.HasSuffix(schema.Custom, ":textfield"):
+			text, isString := value.(string)
+			if !isString {
+				return nil, false
+			}
+			input["singleLineTextFields"] = append(input["singleLineTextFields"], map[string]interface{}{"fieldId": fieldID, "text": text})
+		case schema.Type == "array" && schema.Items == "string":
+			labels, isList := value.([]string)
+			if !isList {
+				return nil, false
+			}
+			names := make([]map[string]string, 0, len(labels))
+			for _, label := range labels {
+				names = append(names, map[string]string{"name": label})
+			}
+			input["labelsFields"] = append(input["labelsFields"], map[string]interface{}{"fieldId": fieldID, "labels": names, "bulkEditMultiSelectFieldOption": "REPLACE"})
+		default:
+			return nil, false
+		}
+	}
+
+	converted := make(map[string]interface{}, len(input))
+	for kind, entries := range input {
+		converted[kind] = entries
+	}
+	return converted, true
+}
+
+// write queues an issue edit for a bulk edit and waits for its outcome. It reports false, having
+// written nothing, when the edit can't be made in bulk.
+func (b *bulkWriter) write(ctx context.Context, jiraIssueKey string, update jira.UpdateRequest) (bool, error) {
+	if len(update.Fields) == 0 || len(update.Update) > 0 {
+		return false, nil
+	}
+	// A bulk edit is made with the default account
+	if _, overridden := jira.CredentialsFrom(ctx); overridden {
+		return false, nil
+	}
+	fields := b.jiraFields(ctx)
+	if fields == nil {
+		return false, nil
+	}
+	input, ok := bulkEditInput(fields, update.Fields)
+	if !ok {
+		return false, nil
+	}
+
+	fieldIDs := update.FieldIDs()
+	signature, err := json.Marshal([]interface{}{fieldIDs, input})
+	if err != nil {
+		return false, nil
+	}
+	key := string(signature)
+
+	write := bulkPendingWrite{issueKey: jiraIssueKey, update: update, done: make(chan error, 1)}
+
+	b.mu.Lock()
+	group := b.pending[key]
+	if group == nil {
+		group = &bulkGroup{fieldIDs: fieldIDs, input: input}
+		group.timer = time.AfterFunc(b.s.config.BackfillBulkWindow, func() { b.flush(key) })
+		b.pending[key] = group
+	}
+	group.writes = append(group.writes, write)
+	if len(group.writes) >= b.s.config.BackfillBulkChunk {
+		group.timer.Stop()
+		delete(b.pending, key)
+		go b.submit(group)
+	}
+	b.mu.Unlock()
+
+	select {
+	case err := <-write.done:
+		return true, err
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// flush sends the writes waiting under key
+func (b *bulkWriter) flush(key string) {
+	b.mu.Lock()
+	group := b.pending[key]
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	if group != nil {
+		b.submit(group)
+	}
+}
+
+// submit sends a group as one bulk edit. If the bulk edit fails for any issue, every write of
+// the group is made individually, so each gets its own outcome; rewriting the same values to the
+// issues that were edited is harmless.
+func (b *bulkWriter) submit(group *bulkGroup) {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTaskTimeout)
+	defer cancel()
+
+	if len(group.writes) > 1 {
+		seen := make(map[string]bool, len(group.writes))
+		var issueKeys []string
+		for _, write := range group.writes {
+			if !seen[write.issueKey] {
+				seen[write.issueKey] = true
+				issueKeys = append(issueKeys, write.issueKey)
+			}
+		}
+
+		task, err := b.runBulkEdit(ctx, jira.BulkEdit{IssueKeys: issueKeys, FieldIDs: group.fieldIDs, Input: group.input})
+		switch {
+		case err == nil && task.Succeeded():
+			log.Printf("Bulk edited %s of %d issues", strings.Join(group.fieldIDs, ", "), len(issueKeys))
+			jiraBulkEditsTotal.inc("complete")
+			b.recordProgress(len(issueKeys), 0)
+			for _, write := range group.writes {
+				write.done <- nil
+			}
+			return
+		case err != nil:
+			log.Printf("Bulk edit of %d issues failed, writing them individually: %v", len(issueKeys), err)
+			jiraBulkEditsTotal.inc("failed")
+		default:
+			log.Printf("Bulk edit %s ended %s with %d failed and %d inaccessible issues, writing them individually",
+				task.TaskID, task.Status, len(task.FailedAccessibleIssues), task.InvalidOrInaccessibleIssueCount)
+			jiraBulkEditsTotal.inc("partial")
+		}
+	}
+
+	for _, write := range group.writes {
+		write.done <- b.s.jira.UpdateIssue(ctx, write.issueKey, write.update)
+	}
+	b.recordProgress(0, len(group.writes))
+}
+
+// runBulkEdit submits a bulk edit and waits for it to finish
+func (b *bulkWriter) runBulkEdit(ctx context.Context, edit jira.BulkEdit) (*jira.BulkTask, error) {
+	taskID, err := b.s.jira.SubmitBulkEdit(ctx, edit)
+	if err != nil {
+		return nil, err
+	}
+	return b.s.jira.WaitForBulkTask(ctx, taskID, bulkPollInterval)
+}
+
+func (b *bulkWriter) recordProgress(bulkIssues, individualIssues int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if bulkIssues > 0 {
+		b.edits++
+		b.issues += bulkIssues
+		jiraBulkIssuesTotal.add(float64(bulkIssues), "bulk")
+	}
+	if individualIssues > 0 {
+		b.fallbacks += individualIssues
+		jiraBulkIssuesTotal.add(float64(individualIssues), "individual")
+	}
+}
+
+var (
+	jiraBulkEditsTotal = newCounterVec(
+		"incident_jira_webhook_jira_bulk_edits_total",
+		"Jira bulk edits submitted by backfills, by outcome (complete, partial or failed).",
+		"outcome")
+	jiraBulkIssuesTotal = newCounterVec(
+		"incident_jira_webhook_jira_bulk_issues_total",
+		"Coalesced backfill writes, by how they were made (bulk or individual).",
+		"mode")
+)
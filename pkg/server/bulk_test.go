@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+func TestBulkEditInput(t *testing.T) {
+	var fields []jira.Field
+	json.Unmarshal([]byte(`[
+		{"id": "customfield_num", "schema": {"type": "number"}},
+		{"id": "customfield_date", "schema": {"type": "date"}},
+		{"id": "customfield_text", "schema": {"type": "string", "custom": "com.atlassian.jira.plugin.system.customfieldtypes:textfield"}},
+		{"id": "customfield_area", "schema": {"type": "string", "custom": "com.atlassian.jira.plugin.system.customfieldtypes:textarea"}},
+		{"id": "labels", "schema": {"type": "array", "items": "string"}}
+	]`), &fields)
+	byID := make(map[string]jira.Field)
+	for _, field := range fields {
+		byID[field.ID] = field
+	}
+
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		want   string
+		ok     bool
+	}{
+		{
+			name:   "number",
+			values: map[string]interface{}{"customfield_num": 3.0},
+			want:   `{"clearableNumberFields":[{"fieldId":"customfield_num","value":3}]}`,
+			ok:     true,
+		},
+		{
+			name:   "date",
+			values: map[string]interface{}{"customfield_date": "2024-05-01"},
+			want:   `{"dateFields":[{"date":{"formattedDate":"2024-05-01"},"dateFieldId":"customfield_date"}]}`,
+			ok:     true,
+		},
+		{
+			name:   "single line text",
+			values: map[string]interface{}{"customfield_text": "Payments"},
+			want:   `{"singleLineTextFields":[{"fieldId":"customfield_text","text":"Payments"}]}`,
+			ok:     true,
+		},
+		{
+			name:   "labels",
+			values: map[string]interface{}{"labels": []string{"a", "b"}},
+			want:   `{"labelsFields":[{"bulkEditMultiSelectFieldOption":"REPLACE","fieldId":"labels","labels":[{"name":"a"},{"name":"b"}]}]}`,
+			ok:     true,
+		},
+		{name: "unknown field", values: map[string]interface{}{"customfield_missing": "x"}},
+		{name: "multi-line text", values: map[string]interface{}{"customfield_area": "x"}},
+		{name: "cleared number", values: map[string]interface{}{"customfield_num": nil}},
+		{name: "text given a number", values: map[string]interface{}{"customfield_text": 1.0}},
+		{name: "one unsupported field of two", values: map[string]interface{}{"customfield_num": 3.0, "customfield_area": "x"}},
+	}
+	for _, test := range tests {
+		input, ok := bulkEditInput(byID, test.values)
+		if ok != test.ok {
+			t.Errorf("%s: ok = %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		got, _ := json.Marshal(input)
+		if string(got) != test.want {
+			t.Errorf("%s: input = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// fakeBulkJira serves the fields, bulk edit and issue edit endpoints, and records what they got
+type fakeBulkJira struct {
+	mu        sync.Mutex
+	bulkEdits [][]string
+	updated   []string
+	// taskStatus is the status every bulk task reports
+	taskStatus string
+}
+
+func (f *fakeBulkJira) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/rest/api/3/field":
+		w.Write([]byte(`[{"id": "customfield_num", "schema": {"type": "number"}}]`))
+	case r.Method == http.MethodPost && r.URL.Path == "/rest/api/3/bulk/issues/fields":
+		var payload struct {
+			IssueKeys []string `json:"selectedIssueIdsOrKeys"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		sort.Strings(payload.IssueKeys)
+		f.bulkEdits = append(f.bulkEdits, payload.IssueKeys)
+		w.Write([]byte(`{"taskId": "task-1"}`))
+	case r.URL.Path == "/rest/api/3/bulk/queue/task-1":
+		w.Write([]byte(`{"taskId": "task-1", "status": "` + f.taskStatus + `"}`))
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/rest/api/3/issue/"):
+		f.updated = append(f.updated, strings.TrimPrefix(r.URL.Path, "/rest/api/3/issue/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// writeConcurrently makes the same edit to every issue through writer, expecting each to be
+// coalesced
+func writeConcurrently(t *testing.T, writer *bulkWriter, issueKeys []string) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for _, issueKey := range issueKeys {
+		wg.Add(1)
+		go func(issueKey string) {
+			defer wg.Done()
+			update := jira.UpdateRequest{Fields: map[string]interface{}{"customfield_num": 3.0}}
+			bulk, err := writer.write(context.Background(), issueKey, update)
+			if !bulk || err != nil {
+				t.Errorf("%s: write = %v, %v, want a bulk write without error", issueKey, bulk, err)
+			}
+		}(issueKey)
+	}
+	wg.Wait()
+}
+
+func TestBulkWriterCoalescesWrites(t *testing.T) {
+	fake := &fakeBulkJira{taskStatus: jira.BulkTaskComplete}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s := &IncidentJiraSync{
+		config: Config{BackfillBulkWindow: time.Minute, BackfillBulkChunk: 3},
+		jira:   jira.NewClient(server.URL, "user", "token", server.Client()),
+	}
+	writer := newBulkWriter(s)
+	writeConcurrently(t, writer, []string{"OPS-1", "OPS-2", "OPS-3"})
+
+	// Edits the bulk API can't express are left to the caller
+	bulk, err := writer.write(context.Background(), "OPS-4", jira.UpdateRequest{Fields: map[string]interface{}{"summary": "x"}})
+	if bulk || err != nil {
+		t.Errorf("write of an unsupported field = %v, %v, want false", bulk, err)
+	}
+
+	if want := [][]string{{"OPS-1", "OPS-2", "OPS-3"}}; !reflect.DeepEqual(fake.bulkEdits, want) {
+		t.Errorf("bulk edits = %v, want %v", fake.bulkEdits, want)
+	}
+	if len(fake.updated) != 0 {
+		t.Errorf("issues written individually = %v, want none", fake.updated)
+	}
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if writer.edits != 1 || writer.issues != 3 || writer.fallbacks != 0 {
+		t.Errorf("progress = %d edits of %d issues and %d fallbacks, want 1 of 3 and 0", writer.edits, writer.issues, writer.fallbacks)
+	}
+}
+
+func TestBulkWriterFallsBackToIndividualWrites(t *testing.T) {
+	fake := &fakeBulkJira{taskStatus: jira.BulkTaskFailed}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s := &IncidentJiraSync{
+		config: Config{BackfillBulkWindow: time.Minute, BackfillBulkChunk: 2},
+		jira:   jira.NewClient(server.URL, "user", "token", server.Client()),
+	}
+	writer := newBulkWriter(s)
+	writeConcurrently(t, writer, []string{"OPS-1", "OPS-2"})
+
+	if len(fake.bulkEdits) != 1 {
+		t.Errorf("bulk edits = %v, want one", fake.bulkEdits)
+	}
+	sort.Strings(fake.updated)
+	if want := []string{"OPS-1", "OPS-2"}; !reflect.DeepEqual(fake.updated, want) {
+		t.Errorf("issues written individually = %v, want %v", fake.updated, want)
+	}
+}
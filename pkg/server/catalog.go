@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// objectKeyAttribute is the catalog attribute holding the Assets object key, used by mappings
+// that do not pick another attribute
+const objectKeyAttribute = "object key"
+
+// maxCatalogFanOut bounds the referenced catalog entries followed from one attribute when
+// resolving every value of a path
+const maxCatalogFanOut = 50
+
+// errNoCatalogAttribute is returned when a catalog entry has no value for a mapping's attribute
+var errNoCatalogAttribute = errors.New("catalog attribute not set")
+
+// resolveCatalogAttribute returns the text of a catalog entry attribute. A dotted path follows
+// attributes that reference other catalog entries, e.g. "Team.Owner email" reads the owner email
+// of the entry's team.
+func (s *IncidentJiraSync) resolveCatalogAttribute(ctx context.Context, catalogEntryID, path string) (string, error) {
+	segments := strings.Split(path, ".")
+	entryID := catalogEntryID
+	for i, segment := range segments {
+		segment = strings.TrimSpace(segment)
+
+		catalogEntry, err := s.catalogEntry(ctx, entryID)
+		if err != nil {
+			return "", err
+		}
+
+		attrValue, found := catalogEntry.AttributeValue(segment)
+		if !found {
+			log.Printf("No %s found for catalog entry %s", segment, entryID)
+			return "", fmt.Errorf("%w: no %s for catalog entry %s", errNoCatalogAttribute, segment, entryID)
+		}
+
+		if i == len(segments)-1 {
+			value := attrValue.Text()
+			log.Printf("Found %s '%s' for catalog entry %s", path, s.redactor.redactString(value), catalogEntryID)
+			return value, nil
+		}
+
+		referenced := attrValue.CatalogEntryIDs()
+		if len(referenced) == 0 {
+			return "", fmt.Errorf("%w: %s of catalog entry %s does not reference a catalog entry", errNoCatalogAttribute, segment, entryID)
+		}
+		entryID = referenced[0]
+	}
+	return "", fmt.Errorf("%w: empty attribute path", errNoCatalogAttribute)
+}
+
+// resolveCatalogAttributeValues returns every value of a catalog entry attribute. Unlike
+// resolveCatalogAttribute, multi-valued attributes give one value each, and a dotted path
+// follows every referenced entry, e.g. "Jira assets" of a service owning several Assets
+// objects, or "Services.Object key" of a team. Referenced entries without the attribute are
+// skipped.
+func (s *IncidentJiraSync) resolveCatalogAttributeValues(ctx context.Context, catalogEntryID, path string) ([]string, error) {
+	segment, rest, nested := strings.Cut(path, ".")
+	segment = strings.TrimSpace(segment)
+
+	catalogEntry, err := s.catalogEntry(ctx, catalogEntryID)
+	if err != nil {
+		return nil, err
+	}
+	attrValue, found := catalogEntry.AttributeValue(segment)
+	if !found {
+		return nil, fmt.Errorf("%w: no %s for catalog entry %s", errNoCatalogAttribute, segment, catalogEntryID)
+	}
+
+	if !nested {
+		values := attrValue.Texts()
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%w: no %s for catalog entry %s", errNoCatalogAttribute, segment, catalogEntryID)
+		}
+		return values, nil
+	}
+
+	referenced := attrValue.CatalogEntryIDs()
+	if len(referenced) == 0 {
+		return nil, fmt.Errorf("%w: %s of catalog entry %s does not reference a catalog entry", errNoCatalogAttribute, segment, catalogEntryID)
+	}
+	if len(referenced) > maxCatalogFanOut {
+		log.Printf("Warning: %s of catalog entry %s references %d entries, following the first %d", segment, catalogEntryID, len(referenced), maxCatalogFanOut)
+		referenced = referenced[:maxCatalogFanOut]
+	}
+
+	seen := make(map[string]bool)
+	var values []string
+	for _, entryID := range referenced {
+		nestedValues, err := s.resolveCatalogAttributeValues(ctx, entryID, rest)
+		if errors.Is(err, errNoCatalogAttribute) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range nestedValues {
+			if !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%w: no %s for the entries %s of catalog entry %s references", errNoCatalogAttribute, rest, segment, catalogEntryID)
+	}
+	return values, nil
+}
+
+// externalObjectID extracts the Assets object ID from the external ID of a catalog entry as the
+// event carries it, for mappings with use_external_id, saving the catalog API lookup. Entries
+// whose external ID holds no object ID are left to the lookup.
+func (s *IncidentJiraSync) externalObjectID(catalogEntry *incidentio.CatalogEntry, fieldMapping mapping.FieldMapping) (string, bool) {
+	if !fieldMapping.UseExternalID {
+		return "", false
+	}
+	if catalogEntry.ExternalID == "" {
+		externalIDLookupsTotal.inc(fieldMapping.IncidentFieldName, "missing")
+		return "", false
+	}
+	objectID, err := mapping.ExtractObjectID(catalogEntry.ExternalID, fieldMapping.ObjectKeyPatternOr(s.config.ObjectKeyPattern))
+	if err != nil {
+		log.Printf("External ID of catalog entry %s holds no object ID, looking up %s: %v", catalogEntry.ID, fieldMapping.CatalogAttributeOr(objectKeyAttribute), err)
+		externalIDLookupsTotal.inc(fieldMapping.IncidentFieldName, "invalid")
+		return "", false
+	}
+	externalIDLookupsTotal.inc(fieldMapping.IncidentFieldName, "used")
+	return objectID, true
+}
+
+var externalIDLookupsTotal = newCounterVec(
+	"incident_jira_webhook_external_id_lookups_total",
+	"Catalog entries of use_external_id mappings, by incident field and whether the external ID was used or the catalog was looked up because it was missing or invalid.",
+	"field", "outcome")
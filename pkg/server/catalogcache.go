@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// catalogCache keeps catalog entries in memory, filled by the warmer and by lookups, so
+// catalog lookups while handling webhooks don't call incident.io
+type catalogCache struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]cachedCatalogEntry
+}
+
+type cachedCatalogEntry struct {
+	entry     *incidentio.CatalogResponse
+	fetchedAt time.Time
+}
+
+// newCatalogCache returns a cache keeping entries for ttl, or nil when no catalog types are
+// warmed (CATALOG_WARM_TYPES), in which case every lookup calls incident.io
+func newCatalogCache(config Config) *catalogCache {
+	if len(config.CatalogWarmTypes) == 0 {
+		return nil
+	}
+	return &catalogCache{ttl: config.CatalogCacheTTL, entries: make(map[string]cachedCatalogEntry)}
+}
+
+// get returns an entry fetched within the TTL
+func (c *catalogCache) get(catalogEntryID string) (*incidentio.CatalogResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cached, found := c.entries[catalogEntryID]
+	if !found || time.Since(cached.fetchedAt) >= c.ttl {
+		return nil, false
+	}
+	return cached.entry, true
+}
+
+// put caches an entry and reports whether it replaced a different version of it
+func (c *catalogCache) put(entry *incidentio.CatalogResponse, fetchedAt time.Time) (changed bool) {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous, found := c.entries[entry.CatalogEntry.ID]
+	c.entries[entry.CatalogEntry.ID] = cachedCatalogEntry{entry: entry, fetchedAt: fetchedAt}
+	catalogCacheEntries.set(float64(len(c.entries)))
+	return found && catalogEntryChanged(previous.entry, entry)
+}
+
+// catalogEntryChanged compares two versions of a catalog entry by their update time, or by
+// their contents when incident.io didn't send one
+func catalogEntryChanged(previous, current *incidentio.CatalogResponse) bool {
+	if !previous.CatalogEntry.UpdatedAt.IsZero() && !current.CatalogEntry.UpdatedAt.IsZero() {
+		return !previous.CatalogEntry.UpdatedAt.Equal(current.CatalogEntry.UpdatedAt)
+	}
+	return previous.CatalogEntry.Name != current.CatalogEntry.Name ||
+		previous.CatalogEntry.ExternalID != current.CatalogEntry.ExternalID ||
+		!reflect.DeepEqual(previous.CatalogEntry.AttributeValues, current.CatalogEntry.AttributeValues)
+}
+
+// typeIDs returns the catalog types of the cached entries
+func (c *catalogCache) typeIDs() []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	seen := make(map[string]bool)
+	var typeIDs []string
+	for _, cached := range c.entries {
+		if typeID := cached.entry.CatalogEntry.CatalogTypeID; typeID != "" && !seen[typeID] {
+			seen[typeID] = true
+			typeIDs = append(typeIDs, typeID)
+		}
+	}
+	sort.Strings(typeIDs)
+	return typeIDs
+}
+
+// evictMissing drops the cached entries of a catalog type that aren't in present, and
+// returns their IDs
+func (c *catalogCache) evictMissing(typeID string, present map[string]bool) []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var evicted []string
+	for id, cached := range c.entries {
+		if cached.entry.CatalogEntry.CatalogTypeID == typeID && !present[id] {
+			delete(c.entries, id)
+			evicted = append(evicted, id)
+		}
+	}
+	catalogCacheEntries.set(float64(len(c.entries)))
+	return evicted
+}
+
+// size returns the number of cached entries, including expired ones not yet replaced
+func (c *catalogCache) size() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+func (c *catalogCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cachedCatalogEntry)
+	catalogCacheEntries.set(0)
+}
+
+// catalogEntry returns a catalog entry from the cache of the organization ctx is processing, or
+// fetches it from incident.io and caches it
+func (s *IncidentJiraSync) catalogEntry(ctx context.Context, catalogEntryID string) (*incidentio.CatalogResponse, error) {
+	organization := s.organization(ctx)
+	if organization.catalog != nil {
+		if entry, found := organization.catalog.get(catalogEntryID); found {
+			catalogCacheLookupsTotal.inc("hit")
+			return entry, nil
+		}
+		catalogCacheLookupsTotal.inc("miss")
+	}
+
+	entry, err := organization.client.GetCatalogEntry(ctx, catalogEntryID)
+	if err != nil {
+		return nil, err
+	}
+	if organization.catalog.put(entry, time.Now()) {
+		s.forgetCatalogEntry(catalogEntryID, "changed")
+	}
+	return entry, nil
+}
+
+// refreshCatalogType lists every entry of an organization's catalog type into its cache. Entries
+// that changed since they were cached, or that are gone from the catalog, have the state derived
+// from them dropped.
+func (s *IncidentJiraSync) refreshCatalogType(ctx context.Context, organization *incidentOrganization, typeID string) (int, error) {
+	entries, err := organization.client.ListAllCatalogEntries(ctx, typeID)
+	if err != nil {
+		return 0, err
+	}
+
+	fetchedAt := time.Now()
+	present := make(map[string]bool, len(entries))
+	for i := range entries {
+		entry := &entries[i]
+		if entry.CatalogEntry.CatalogTypeID == "" {
+			entry.CatalogEntry.CatalogTypeID = typeID
+		}
+		present[entry.CatalogEntry.ID] = true
+		if organization.catalog.put(entry, fetchedAt) {
+			s.forgetCatalogEntry(entry.CatalogEntry.ID, "changed")
+		}
+	}
+	for _, entryID := range organization.catalog.evictMissing(typeID, present) {
+		s.forgetCatalogEntry(entryID, "removed")
+	}
+	return len(entries), nil
+}
+
+// forgetCatalogEntry drops what was derived from an outdated catalog entry: the Assets object
+// created for it when it had no object key, which a fixed object key replaces
+func (s *IncidentJiraSync) forgetCatalogEntry(catalogEntryID, change string) {
+	log.Printf("Catalog entry %s %s, refreshing what was cached from it", catalogEntryID, change)
+	catalogEntryChangesTotal.inc(change)
+	s.assetsMu.Lock()
+	for key := range s.createdAssetsObjects {
+		if strings.HasSuffix(key, "/"+catalogEntryID) {
+			delete(s.createdAssetsObjects, key)
+		}
+	}
+	s.assetsMu.Unlock()
+}
+
+// warmCatalogCache fetches every entry of an organization's warmed catalog types
+// (CATALOG_WARM_TYPES) into its cache. A type that can't be listed doesn't stop the others.
+func (s *IncidentJiraSync) warmCatalogCache(ctx context.Context, organization *incidentOrganization) error {
+	typeIDs := make([]string, 0, len(organization.warmTypes))
+	for typeID := range organization.warmTypes {
+		typeIDs = append(typeIDs, typeID)
+	}
+	sort.Strings(typeIDs)
+
+	started := time.Now()
+	var errs []error
+	warmed := 0
+	for _, typeID := range typeIDs {
+		count, err := s.refreshCatalogType(ctx, organization, typeID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		warmed += count
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		catalogWarmsTotal.inc("failed")
+		return err
+	}
+	catalogWarmsTotal.inc("complete")
+	log.Printf("Warmed the catalog cache of organization %s with %d entries of %d catalog types in %s", organization.label(), warmed, len(typeIDs), time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// runCatalogWarmer warms an organization's catalog cache at startup and every
+// CATALOG_WARM_INTERVAL
+func (s *IncidentJiraSync) runCatalogWarmer(organization *incidentOrganization) {
+	ticker := time.NewTicker(s.config.CatalogWarmInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.warmCatalogCache(context.Background(), organization); err != nil {
+			log.Printf("Failed to warm the catalog cache of organization %s: %v", organization.label(), err)
+		}
+		<-ticker.C
+	}
+}
+
+// pollCatalogChanges lists the catalog types with entries in an organization's cache, including
+// types cached by lookups rather than warmed, to pick up changed entries before their TTL
+func (s *IncidentJiraSync) pollCatalogChanges(ctx context.Context, organization *incidentOrganization) error {
+	var errs []error
+	for _, typeID := range organization.catalog.typeIDs() {
+		if _, err := s.refreshCatalogType(ctx, organization, typeID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runCatalogChangePoller polls an organization's catalog for changes every
+// CATALOG_CHANGE_POLL_INTERVAL
+func (s *IncidentJiraSync) runCatalogChangePoller(organization *incidentOrganization) {
+	ticker := time.NewTicker(s.config.CatalogChangePollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.pollCatalogChanges(context.Background(), organization); err != nil {
+			log.Printf("Failed to poll the catalog of organization %s for changes: %v", organization.label(), err)
+		}
+	}
+}
+
+var (
+	catalogCacheLookupsTotal = newCounterVec(
+		"incident_jira_webhook_catalog_cache_lookups_total",
+		"Catalog entry lookups, by outcome (hit, or miss when incident.io was called).",
+		"outcome")
+	catalogCacheEntries = newGaugeVec(
+		"incident_jira_webhook_catalog_cache_entries",
+		"Catalog entries held in the catalog cache.")
+	catalogWarmsTotal = newCounterVec(
+		"incident_jira_webhook_catalog_warms_total",
+		"Catalog cache warm-ups, by outcome (complete or failed).",
+		"outcome")
+	catalogEntryChangesTotal = newCounterVec(
+		"incident_jira_webhook_catalog_entry_changes_total",
+		"Cached catalog entries found changed or removed in incident.io, by change (changed or removed).",
+		"change")
+)
@@ -0,0 +1,830 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// Config is the service configuration, read from the environment by LoadConfig
+type Config struct {
+	JiraBaseURL                          string
+	JiraUsername                         string
+	JiraAPIToken                         string
+	IncidentAPIToken                     string
+	IncidentCredentials                  map[string]string
+	JiraCredentials                      map[string]jira.Credentials
+	IncidentOperationCredentials         map[string]string
+	IncidentAPIBaseURL                   string
+	IncidentOrganizations                map[string]IncidentOrganizationConfig
+	WebhookSecret                        string
+	SignatureEnforcement                 string
+	Port                                 string
+	ListenAddresses                      []string
+	AdminListenAddresses                 []string
+	JiraWorkspaceID                      string
+	JiraWorkspaceAutodetect              bool
+	ImpactedComponentFieldName           string
+	ImpactedComponentJiraFieldID         string
+	ResponsibleComponentFieldName        string
+	ResponsibleComponentJiraFieldID      string
+	ImpactedComponentTargets             []mapping.JiraTarget
+	ResponsibleComponentTargets          []mapping.JiraTarget
+	ImpactedComponentObjectKeyPattern    string
+	ResponsibleComponentObjectKeyPattern string
+	ImpactedComponentCatalogAttribute    string
+	ResponsibleComponentCatalogAttribute string
+	ImpactedComponentUseExternalID       bool
+	ResponsibleComponentUseExternalID    bool
+	ImpactedComponentCredential          string
+	ResponsibleComponentCredential       string
+	MappingRulesFile                     string
+	MappingRulesSyncInterval             time.Duration
+	MaxConcurrentEvents                  int
+	MetricsBackends                      map[string]bool
+	StatsDAddr                           string
+	StatsDPrefix                         string
+	StatsDTags                           []string
+	EventQueueSize                       int
+	PriorityRules                        []PriorityRule
+	ProcessingTimeout                    time.Duration
+	LatencyBudget                        time.Duration
+	ReconcileInterval                    time.Duration
+	ConfigLintInterval                   time.Duration
+	CatalogWarmTypes                     map[string]bool
+	CatalogWarmInterval                  time.Duration
+	CatalogCacheTTL                      time.Duration
+	CatalogChangePollInterval            time.Duration
+	ReconcileLookback                    time.Duration
+	LatencyBudgetWindow                  int
+	RetryMaxAttempts                     int
+	RetryBaseDelay                       time.Duration
+	RetryMaxDelay                        time.Duration
+	RetryQueueSize                       int
+	DrainTimeout                         time.Duration
+	RedeliveryBaseDelay                  time.Duration
+	RedeliveryMaxDelay                   time.Duration
+	JiraSkipUnchanged                    bool
+	JiraSecurityLevelCheck               bool
+	JiraAllowedSecurityLevels            map[string]bool
+	JiraCacheTTL                         time.Duration
+	JiraThrottleBelowPercent             int
+	JiraThrottleMaxDelay                 time.Duration
+	JiraCacheMaxEntries                  int
+	SprintFieldName                      string
+	JiraSprintFieldID                    string
+	JiraSprintBoardID                    string
+	AdminAPIKeys                         []AdminAPIKey
+	TLSCertFile                          string
+	TLSKeyFile                           string
+	ReusePort                            bool
+	ShutdownTimeout                      time.Duration
+	Events                               map[string]bool
+	ObjectKeyPattern                     string
+	JiraHTTP                             HTTPClientConfig
+	FailureNoteFieldID                   string
+	FailureNoteNotifyChannel             bool
+	OutboxPollInterval                   time.Duration
+	OutboxBaseDelay                      time.Duration
+	OutboxMaxDelay                       time.Duration
+	OutboxMaxAttempts                    int
+	StatusCategoryJiraFieldID            string
+	StatusCategoryMapping                map[string]string
+	IncidentTypeJiraFieldID              string
+	IncidentTypeMapping                  map[string]string
+	IncidentTypeIssueTypes               map[string]string
+	SelectOptionAutoCreateFields         map[string]bool
+	TimeTrackingUnit                     string
+	JiraHoursPerDay                      int
+	JiraDaysPerWeek                      int
+	WatcherRoles                         map[string]bool
+	LockRedisURL                         string
+	LockKeyPrefix                        string
+	LockTTL                              time.Duration
+	StateStore                           string
+	StateStoreURL                        string
+	StateStoreAutoMigrate                bool
+	DedupStore                           string
+	DedupStoreURL                        string
+	DedupKeyPrefix                       string
+	DeliveryDedupTTL                     time.Duration
+	LogPayloads                          bool
+	DebugIncidentDuration                time.Duration
+	DebugIncidentMaxDuration             time.Duration
+	AccessLog                            string
+	AccessLogSampleRates                 map[string]float64
+	RedactFields                         []string
+	RedactPatterns                       []string
+	IncidentHTTP                         HTTPClientConfig
+	JiraPageSize                         int
+	IncidentPageSize                     int
+	MaxListPages                         int
+	InitialSyncEvents                    map[string]bool
+	MappingRules                         []mapping.Rule
+	ShadowMappingRulesFile               string
+	ShadowMappingRules                   []mapping.Rule
+	AssetsAPIBaseURL                     string
+	AssetsCreateMissingObjects           bool
+	AssetsObjectTypeID                   string
+	AssetsMatchAttribute                 string
+	AssetsAttributeMapping               map[string]string
+	MultiValuePolicy                     string
+	EpicRollupEnabled                    bool
+	EpicIssueTypeName                    string
+	EpicRollupFieldIDs                   []string
+	SeverityLabelPrefix                  string
+	SeverityChangeComments               bool
+	SeverityMentionSeverities            map[string]bool
+	SeverityMentions                     map[string]bool
+	SeverityRankJiraFieldID              string
+	PostmortemSyncEnabled                bool
+	IncidentRemoteLink                   bool
+	PostmortemComment                    bool
+	PostmortemTransition                 string
+	DismissalTransitions                 map[string]string
+	DismissalComment                     bool
+	ClosureSummaryEnabled                bool
+	WorklogOnResolution                  bool
+	WorklogCredential                    string
+	FeatureFlags                         map[string]FeatureFlag
+	SyncMarkerEnabled                    bool
+	SyncMarkerPropertyKey                string
+	WriteVerification                    bool
+	WebhookAutoRegister                  bool
+	PublicURL                            string
+	TimeToAcknowledgeJiraFieldID         string
+	ResponderCountJiraFieldID            string
+	ResponderTeamFieldName               string
+	ResponderTeamJiraFieldID             string
+	EscalationCountJiraFieldID           string
+	TimeToResolveJiraFieldID             string
+	SLAReportedTimestamp                 string
+	SLAAcknowledgedTimestamp             string
+	SLAResolvedTimestamp                 string
+	DueDateTimestamp                     string
+	DueDateSLAOffset                     time.Duration
+	DueDateLocation                      *time.Location
+	TransformTimeout                     time.Duration
+	RelatedIssuesFieldName               string
+	RelatedIssuesFromAttachments         bool
+	RelatedIssuesMax                     int
+	UnmappedFieldMetricLimit             int
+	UnknownEventSamples                  int
+	UnknownEventSampleBytes              int
+	TLSClientCAFile                      string
+	EndpointAuth                         map[string]EndpointAuth
+	MergePolicy                          string
+	TemplatesDir                         string
+	TemplateLanguage                     string
+	TemplateLanguageByIncidentType       map[string]string
+	Templates                            map[string]*template.Template
+	CreateIssues                         bool
+	CreationTemplates                    map[string]*creationTemplate
+	BackfillConcurrency                  int
+	BackfillRate                         int
+	BackfillCheckpointFile               string
+	BackfillBulk                         bool
+	SkipIncidents                        map[string]bool
+	BackfillBulkChunk                    int
+	BackfillBulkWindow                   time.Duration
+}
+
+// LoadConfig reads the configuration from environment variables and the files they point to,
+// and validates it
+func LoadConfig() (Config, error) {
+	envErrorsMu.Lock()
+	envErrors = nil
+	config := configFromEnv()
+	err := errors.Join(envErrors...)
+	envErrorsMu.Unlock()
+	if err != nil {
+		return config, err
+	}
+
+	if err := loadRetryConfig(getEnv("RETRY_CONFIG_FILE", ""), &config); err != nil {
+		return config, fmt.Errorf("invalid retry config: %w", err)
+	}
+	if config.RetryMaxAttempts < 1 || config.RetryQueueSize < 1 {
+		return config, errors.New("RETRY_MAX_ATTEMPTS and RETRY_QUEUE_SIZE must be at least 1")
+	}
+	if config.RetryBaseDelay <= 0 || config.RetryMaxDelay < config.RetryBaseDelay {
+		return config, errors.New("RETRY_BASE_DELAY must be positive and no longer than RETRY_MAX_DELAY")
+	}
+
+	// Validate configuration
+	if config.JiraAPIToken == "" {
+		return config, errors.New("JIRA_API_TOKEN environment variable is required")
+	}
+
+	if err := validateIncidentCredentials(config); err != nil {
+		return config, err
+	}
+
+	if config.JiraBaseURL == "" {
+		return config, errors.New("JIRA_BASE_URL environment variable is required")
+	}
+
+	if config.JiraUsername == "" {
+		return config, errors.New("JIRA_USERNAME environment variable is required")
+	}
+
+	if config.JiraWorkspaceID == "" {
+		return config, errors.New("JIRA_WORKSPACE_ID environment variable is required")
+	}
+
+	if config.ImpactedComponentJiraFieldID == "" {
+		return config, errors.New("IMPACTED_COMPONENT_JIRA_FIELD_ID environment variable is required")
+	}
+
+	if config.ResponsibleComponentJiraFieldID == "" {
+		return config, errors.New("RESPONSIBLE_COMPONENT_JIRA_FIELD_ID environment variable is required")
+	}
+
+	if config.AssetsCreateMissingObjects {
+		if config.AssetsObjectTypeID == "" {
+			return config, errors.New("ASSETS_OBJECT_TYPE_ID environment variable is required when ASSETS_CREATE_MISSING_OBJECTS is enabled")
+		}
+		if len(config.AssetsAttributeMapping) == 0 {
+			return config, errors.New("ASSETS_ATTRIBUTE_MAPPING environment variable is required when ASSETS_CREATE_MISSING_OBJECTS is enabled")
+		}
+	}
+
+	if baseURL, err := url.Parse(config.IncidentAPIBaseURL); err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return config, fmt.Errorf("INCIDENT_API_BASE_URL must be an http or https URL, not %q", config.IncidentAPIBaseURL)
+	}
+
+	if config.WebhookAutoRegister && config.PublicURL == "" {
+		return config, errors.New("PUBLIC_URL environment variable is required when WEBHOOK_AUTO_REGISTER is enabled")
+	}
+
+	if config.BackfillConcurrency < 1 || config.BackfillRate < 1 {
+		return config, errors.New("BACKFILL_CONCURRENCY and BACKFILL_RATE must be at least 1")
+	}
+
+	if config.BackfillBulk && (config.BackfillBulkChunk < 1 || config.BackfillBulkChunk > jira.MaxBulkIssues || config.BackfillBulkWindow <= 0) {
+		return config, fmt.Errorf("BACKFILL_BULK_CHUNK must be between 1 and %d and BACKFILL_BULK_WINDOW positive", jira.MaxBulkIssues)
+	}
+
+	if err := validateMetricsBackends(config.MetricsBackends); err != nil {
+		return config, fmt.Errorf("invalid METRICS_BACKEND: %w", err)
+	}
+
+	location, err := time.LoadLocation(getEnv("DUE_DATE_TIMEZONE", "UTC"))
+	if err != nil {
+		return config, fmt.Errorf("invalid DUE_DATE_TIMEZONE: %w", err)
+	}
+	config.DueDateLocation = location
+
+	if config.StateStore != storeMemory && config.StateStore != storePostgres {
+		return config, fmt.Errorf("unknown STATE_STORE %q, expected %s or %s", config.StateStore, storeMemory, storePostgres)
+	}
+	if config.StateStore == storePostgres && config.StateStoreURL == "" {
+		return config, errors.New("STATE_STORE_URL is required when STATE_STORE is postgres")
+	}
+
+	if config.LockRedisURL != "" && (config.LockTTL <= 0 || (config.ProcessingTimeout > 0 && config.LockTTL <= config.ProcessingTimeout)) {
+		return config, errors.New("LOCK_TTL must be positive and longer than PROCESSING_TIMEOUT")
+	}
+
+	switch config.DedupStore {
+	case dedupState, dedupMemory:
+	case dedupRedis, dedupMemcached:
+		if config.DedupStore == dedupRedis && config.DedupStoreURL == "" {
+			config.DedupStoreURL = config.LockRedisURL
+		}
+		if config.DedupStoreURL == "" {
+			return config, fmt.Errorf("DEDUP_STORE_URL is required when DEDUP_STORE is %s", config.DedupStore)
+		}
+	default:
+		return config, fmt.Errorf("unknown DEDUP_STORE %q, expected %s, %s, %s or %s", config.DedupStore, dedupState, dedupMemory, dedupRedis, dedupMemcached)
+	}
+
+	if config.RelatedIssuesMax < 0 {
+		return config, errors.New("RELATED_ISSUES_MAX cannot be negative")
+	}
+
+	if config.JiraCacheMaxEntries < 1 || config.JiraCacheTTL < 0 {
+		return config, errors.New("JIRA_CACHE_MAX_ENTRIES must be at least 1 and JIRA_CACHE_TTL must not be negative")
+	}
+
+	if config.JiraThrottleBelowPercent < 0 || config.JiraThrottleBelowPercent > 100 {
+		return config, errors.New("JIRA_THROTTLE_BELOW_PERCENT must be between 0 and 100")
+	}
+
+	switch config.TimeTrackingUnit {
+	case "m", "h", "d", "w":
+	default:
+		return config, fmt.Errorf("unknown TIME_TRACKING_UNIT %q (use m, h, d or w)", config.TimeTrackingUnit)
+	}
+	if config.JiraHoursPerDay < 1 || config.JiraDaysPerWeek < 1 {
+		return config, errors.New("JIRA_HOURS_PER_DAY and JIRA_DAYS_PER_WEEK must be at least 1")
+	}
+
+	if config.ConfigLintInterval < 0 {
+		return config, errors.New("CONFIG_LINT_INTERVAL cannot be negative")
+	}
+
+	if len(config.CatalogWarmTypes) > 0 && (config.CatalogWarmInterval <= 0 || config.CatalogCacheTTL <= config.CatalogWarmInterval) {
+		return config, errors.New("CATALOG_WARM_INTERVAL must be positive and shorter than CATALOG_CACHE_TTL")
+	}
+
+	if config.CatalogChangePollInterval < 0 {
+		return config, errors.New("CATALOG_CHANGE_POLL_INTERVAL must not be negative")
+	}
+
+	if config.MappingRulesSyncInterval < 0 {
+		return config, errors.New("MAPPING_RULES_SYNC_INTERVAL must not be negative")
+	}
+
+	if config.DebugIncidentDuration <= 0 || config.DebugIncidentDuration > config.DebugIncidentMaxDuration {
+		return config, errors.New("DEBUG_INCIDENT_DURATION must be positive and at most DEBUG_INCIDENT_MAX_DURATION")
+	}
+
+	if config.ReconcileInterval < 0 || config.ReconcileLookback <= 0 {
+		return config, errors.New("RECONCILE_INTERVAL cannot be negative and RECONCILE_LOOKBACK must be positive")
+	}
+
+	if config.RedeliveryBaseDelay <= 0 || config.RedeliveryMaxDelay < config.RedeliveryBaseDelay {
+		return config, errors.New("REDELIVERY_BASE_DELAY must be positive and no longer than REDELIVERY_MAX_DELAY")
+	}
+
+	if config.OutboxPollInterval <= 0 || config.OutboxBaseDelay <= 0 || config.OutboxMaxDelay < config.OutboxBaseDelay {
+		return config, errors.New("OUTBOX_POLL_INTERVAL and OUTBOX_BASE_DELAY must be positive and OUTBOX_BASE_DELAY no longer than OUTBOX_MAX_DELAY")
+	}
+	if config.OutboxMaxAttempts < 1 {
+		return config, errors.New("OUTBOX_MAX_ATTEMPTS must be at least 1")
+	}
+
+	if config.LatencyBudget < 0 {
+		return config, errors.New("LATENCY_BUDGET cannot be negative")
+	}
+	if config.LatencyBudgetWindow < 1 {
+		return config, errors.New("LATENCY_BUDGET_WINDOW must be at least 1")
+	}
+
+	if config.UnmappedFieldMetricLimit < 0 {
+		return config, errors.New("UNMAPPED_FIELD_METRIC_LIMIT cannot be negative")
+	}
+
+	if config.UnknownEventSamples < 0 || config.UnknownEventSampleBytes < 0 {
+		return config, errors.New("UNKNOWN_EVENT_SAMPLES and UNKNOWN_EVENT_SAMPLE_BYTES cannot be negative")
+	}
+
+	if config.MaxConcurrentEvents < 0 || config.EventQueueSize < 0 {
+		return config, errors.New("MAX_CONCURRENT_EVENTS and EVENT_QUEUE_SIZE cannot be negative")
+	}
+
+	for _, client := range []HTTPClientConfig{config.JiraHTTP, config.IncidentHTTP} {
+		if client.Retries < 0 || client.RetryBudget < 0 || client.RetryDelay < 0 || client.RetryAfterMax < 0 {
+			return config, errors.New("HTTP retries, retry budgets and retry delays cannot be negative")
+		}
+		if client.RateLimit < 0 {
+			return config, errors.New("HTTP_RATE_LIMIT cannot be negative")
+		}
+		if client.MaxConnsPerHost < 0 || client.MaxIdleConnsPerHost < 0 {
+			return config, errors.New("HTTP_MAX_CONNS_PER_HOST and HTTP_MAX_IDLE_CONNS_PER_HOST cannot be negative")
+		}
+		if client.DialTimeout < 0 || client.TLSHandshakeTimeout < 0 || client.IdleConnTimeout < 0 {
+			return config, errors.New("HTTP_DIAL_TIMEOUT, HTTP_TLS_HANDSHAKE_TIMEOUT and HTTP_IDLE_CONN_TIMEOUT cannot be negative")
+		}
+	}
+
+	if config.JiraPageSize < 1 || config.IncidentPageSize < 1 || config.MaxListPages < 1 {
+		return config, errors.New("JIRA_PAGE_SIZE, INCIDENT_PAGE_SIZE and MAX_LIST_PAGES must be at least 1")
+	}
+	if config.IncidentPageSize > incidentio.MaxPageSize {
+		return config, fmt.Errorf("INCIDENT_PAGE_SIZE cannot be more than %d", incidentio.MaxPageSize)
+	}
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return config, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	for eventType := range config.Events {
+		if !incidentio.IsKnownEventType(eventType) {
+			log.Printf("Warning: EVENTS includes %s, which this service cannot process", eventType)
+		}
+	}
+
+	for _, pattern := range []string{config.ObjectKeyPattern, config.ImpactedComponentObjectKeyPattern, config.ResponsibleComponentObjectKeyPattern} {
+		if err := mapping.ValidateObjectKeyPattern(pattern); err != nil {
+			return config, err
+		}
+	}
+
+	if err := mapping.ValidateMultiValuePolicy(config.MultiValuePolicy); err != nil {
+		return config, fmt.Errorf("invalid MULTI_VALUE_POLICY: %w", err)
+	}
+
+	if err := validateMergePolicy(config.MergePolicy); err != nil {
+		return config, fmt.Errorf("invalid MERGE_POLICY: %w", err)
+	}
+
+	if config.MappingRulesFile != "" {
+		rules, err := mapping.LoadRules(config.MappingRulesFile)
+		if err != nil {
+			return config, fmt.Errorf("failed to load mapping rules: %w", err)
+		}
+		config.MappingRules = rules
+		log.Printf("Loaded %d mapping rules from %s", len(rules), config.MappingRulesFile)
+	}
+
+	if config.ShadowMappingRulesFile != "" {
+		rules, err := mapping.LoadRules(config.ShadowMappingRulesFile)
+		if err != nil {
+			return config, fmt.Errorf("failed to load shadow mapping rules: %w", err)
+		}
+		config.ShadowMappingRules = rules
+		log.Printf("Loaded %d shadow mapping rules from %s", len(rules), config.ShadowMappingRulesFile)
+	}
+
+	if err := validateJiraCredentials(config); err != nil {
+		return config, err
+	}
+
+	listenAddrs, err := listenAddresses(getEnv("LISTEN_ADDR", ":"+config.Port), config.Port)
+	if err != nil {
+		return config, fmt.Errorf("invalid LISTEN_ADDR: %w", err)
+	}
+	config.ListenAddresses = listenAddrs
+
+	adminListenAddrs, err := listenAddresses(getEnv("ADMIN_LISTEN_ADDR", ""), config.Port)
+	if err != nil {
+		return config, fmt.Errorf("invalid ADMIN_LISTEN_ADDR: %w", err)
+	}
+	config.AdminListenAddresses = adminListenAddrs
+
+	sampleRates, err := parseSampleRates(getEnv("ACCESS_LOG_SAMPLE_RATES", ""))
+	if err != nil {
+		return config, fmt.Errorf("invalid ACCESS_LOG_SAMPLE_RATES: %w", err)
+	}
+	config.AccessLogSampleRates = sampleRates
+
+	priorityRules, err := loadPriorityRules(getEnv("PRIORITY_RULES_FILE", ""))
+	if err != nil {
+		return config, fmt.Errorf("failed to load priority rules: %w", err)
+	}
+	config.PriorityRules = priorityRules
+
+	templates, err := loadTemplates(config.TemplatesDir)
+	if err != nil {
+		return config, fmt.Errorf("failed to load templates: %w", err)
+	}
+	config.Templates = templates
+	if len(templates) > 0 {
+		log.Printf("Loaded %d templates from %s", len(templates), config.TemplatesDir)
+	}
+
+	creationTemplates, err := loadCreationTemplates(config.TemplatesDir)
+	if err != nil {
+		return config, fmt.Errorf("failed to load templates: %w", err)
+	}
+	config.CreationTemplates = creationTemplates
+	if config.CreateIssues && len(creationTemplates) == 0 {
+		return config, errors.New("CREATE_ISSUES requires a create_issue.json creation template in TEMPLATES_DIR")
+	}
+	// The memory store forgets created issues on restart, and other replicas never see them, so
+	// incidents would get a second issue
+	if config.CreateIssues && config.StateStore != storePostgres {
+		return config, errors.New("CREATE_ISSUES requires STATE_STORE=postgres, which remembers the issue created for each incident across restarts and replicas")
+	}
+
+	featureFlags, err := loadFeatureFlags(getEnv("FEATURE_FLAGS_FILE", ""), getEnv("FEATURE_FLAGS", ""))
+	if err != nil {
+		return config, fmt.Errorf("invalid feature flags: %w", err)
+	}
+	config.FeatureFlags = featureFlags
+
+	endpointAuth, err := loadEndpointAuth(config.WebhookSecret)
+	if err != nil {
+		return config, fmt.Errorf("invalid inbound auth settings: %w", err)
+	}
+	for endpoint, auth := range endpointAuth {
+		for _, check := range auth.Checks {
+			if check == authMTLS && (config.TLSCertFile == "" || config.TLSClientCAFile == "") {
+				return config, fmt.Errorf("AUTH_%s uses mtls, which requires TLS_CERT_FILE, TLS_KEY_FILE and TLS_CLIENT_CA_FILE", strings.ToUpper(endpoint))
+			}
+		}
+	}
+	config.EndpointAuth = endpointAuth
+	if err := validateDismissalTransitions(config.DismissalTransitions); err != nil {
+		return config, fmt.Errorf("invalid DISMISSAL_TRANSITIONS: %w", err)
+	}
+
+	if err := validateSignatureEnforcement(config.SignatureEnforcement); err != nil {
+		return config, fmt.Errorf("invalid SIGNATURE_ENFORCEMENT: %w", err)
+	}
+
+	if err := validateIncidentOrganizations(config); err != nil {
+		return config, err
+	}
+
+	adminAPIKeys, err := parseAdminAPIKeys(getEnv("ADMIN_API_KEYS", ""))
+	if err != nil {
+		return config, fmt.Errorf("invalid ADMIN_API_KEYS: %w", err)
+	}
+	config.AdminAPIKeys = adminAPIKeys
+
+	return config, nil
+}
+
+// configFromEnv reads the configuration from environment variables
+func configFromEnv() Config {
+	config := Config{
+		JiraBaseURL:                     getEnv("JIRA_BASE_URL", ""),
+		JiraUsername:                    getEnv("JIRA_USERNAME", ""),
+		JiraAPIToken:                    getEnv("JIRA_API_TOKEN", ""),
+		JiraCredentials:                 loadJiraCredentials(getEnv("JIRA_CREDENTIALS", "")),
+		IncidentAPIToken:                getEnv("INCIDENT_API_TOKEN", ""),
+		IncidentCredentials:             loadIncidentCredentials(getEnv("INCIDENT_CREDENTIALS", "")),
+		IncidentOperationCredentials:    parseKeyValueList(getEnv("INCIDENT_CREDENTIAL_BY_OPERATION", "")),
+		IncidentAPIBaseURL:              strings.TrimRight(getEnv("INCIDENT_API_BASE_URL", incidentio.DefaultBaseURL), "/"),
+		IncidentOrganizations:           loadIncidentOrganizations(getEnv("INCIDENT_ORGANIZATIONS", "")),
+		WebhookSecret:                   getEnv("WEBHOOK_SECRET", ""),
+		SignatureEnforcement:            getEnv("SIGNATURE_ENFORCEMENT", signatureEnforcementEnforce),
+		Port:                            getEnv("PORT", "5000"),
+		JiraWorkspaceID:                 getEnv("JIRA_WORKSPACE_ID", ""),
+		JiraWorkspaceAutodetect:         getEnvBool("JIRA_WORKSPACE_AUTODETECT", true),
+		ImpactedComponentFieldName:      getEnv("IMPACTED_COMPONENT_FIELD_NAME", "Impacted component"),
+		ImpactedComponentJiraFieldID:    getEnv("IMPACTED_COMPONENT_JIRA_FIELD_ID", ""),
+		ResponsibleComponentFieldName:   getEnv("RESPONSIBLE_COMPONENT_FIELD_NAME", "Responsible components"),
+		ResponsibleComponentJiraFieldID: getEnv("RESPONSIBLE_COMPONENT_JIRA_FIELD_ID", ""),
+		MappingRulesFile:                getEnv("MAPPING_RULES_FILE", ""),
+		MappingRulesSyncInterval:        getEnvDuration("MAPPING_RULES_SYNC_INTERVAL", 30*time.Second),
+		ShadowMappingRulesFile:          getEnv("SHADOW_MAPPING_RULES_FILE", ""),
+		MaxConcurrentEvents:             getEnvInt("MAX_CONCURRENT_EVENTS", 0),
+		MetricsBackends:                 parseList(getEnv("METRICS_BACKEND", metricsPrometheus)),
+		StatsDAddr:                      getEnv("STATSD_ADDR", "127.0.0.1:8125"),
+		StatsDPrefix:                    getEnv("STATSD_PREFIX", "incident_jira_webhook"),
+		EventQueueSize:                  getEnvInt("EVENT_QUEUE_SIZE", 100),
+		ProcessingTimeout:               getEnvDuration("PROCESSING_TIMEOUT", 30*time.Second),
+		LatencyBudget:                   getEnvDuration("LATENCY_BUDGET", 0),
+		ReconcileInterval:               getEnvDuration("RECONCILE_INTERVAL", 0),
+		ConfigLintInterval:              getEnvDuration("CONFIG_LINT_INTERVAL", time.Hour),
+		CatalogWarmTypes:                parseList(getEnv("CATALOG_WARM_TYPES", "")),
+		CatalogWarmInterval:             getEnvDuration("CATALOG_WARM_INTERVAL", 15*time.Minute),
+		CatalogCacheTTL:                 getEnvDuration("CATALOG_CACHE_TTL", time.Hour),
+		CatalogChangePollInterval:       getEnvDuration("CATALOG_CHANGE_POLL_INTERVAL", time.Minute),
+		ReconcileLookback:               getEnvDuration("RECONCILE_LOOKBACK", time.Hour),
+		LatencyBudgetWindow:             getEnvInt("LATENCY_BUDGET_WINDOW", 100),
+		RetryMaxAttempts:                getEnvInt("RETRY_MAX_ATTEMPTS", 5),
+		RetryBaseDelay:                  getEnvDuration("RETRY_BASE_DELAY", 10*time.Second),
+		RetryMaxDelay:                   getEnvDuration("RETRY_MAX_DELAY", 10*time.Minute),
+		RedeliveryBaseDelay:             getEnvDuration("REDELIVERY_BASE_DELAY", 30*time.Second),
+		RedeliveryMaxDelay:              getEnvDuration("REDELIVERY_MAX_DELAY", 10*time.Minute),
+		RetryQueueSize:                  getEnvInt("RETRY_QUEUE_SIZE", 1000),
+		DrainTimeout:                    getEnvDuration("DRAIN_TIMEOUT", 20*time.Second),
+		JiraSkipUnchanged:               getEnvBool("JIRA_SKIP_UNCHANGED", false),
+		JiraSecurityLevelCheck:          getEnvBool("JIRA_SECURITY_LEVEL_CHECK", false),
+		JiraAllowedSecurityLevels:       parseList(getEnv("JIRA_ALLOWED_SECURITY_LEVELS", "")),
+		JiraCacheTTL:                    getEnvDuration("JIRA_CACHE_TTL", 0),
+		JiraThrottleBelowPercent:        getEnvInt("JIRA_THROTTLE_BELOW_PERCENT", 20),
+		JiraThrottleMaxDelay:            getEnvDuration("JIRA_THROTTLE_MAX_DELAY", 2*time.Second),
+		JiraCacheMaxEntries:             getEnvInt("JIRA_CACHE_MAX_ENTRIES", 1000),
+		SprintFieldName:                 getEnv("SPRINT_FIELD_NAME", ""),
+		JiraSprintFieldID:               getEnv("JIRA_SPRINT_FIELD_ID", ""),
+		JiraSprintBoardID:               getEnv("JIRA_SPRINT_BOARD_ID", ""),
+		TLSCertFile:                     getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:                      getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile:                 getEnv("TLS_CLIENT_CA_FILE", ""),
+		ReusePort:                       getEnvBool("SO_REUSEPORT", false),
+		ShutdownTimeout:                 getEnvDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout),
+		Events:                          parseList(getEnv("EVENTS", defaultEvents)),
+		ObjectKeyPattern:                getEnv("OBJECT_KEY_PATTERN", ""),
+		JiraHTTP:                        getHTTPClientConfig("JIRA"),
+		FailureNoteFieldID:              getEnv("FAILURE_NOTE_FIELD_ID", ""),
+		FailureNoteNotifyChannel:        getEnvBool("FAILURE_NOTE_NOTIFY_CHANNEL", true),
+		OutboxPollInterval:              getEnvDuration("OUTBOX_POLL_INTERVAL", 30*time.Second),
+		OutboxBaseDelay:                 getEnvDuration("OUTBOX_BASE_DELAY", 10*time.Second),
+		OutboxMaxDelay:                  getEnvDuration("OUTBOX_MAX_DELAY", 10*time.Minute),
+		OutboxMaxAttempts:               getEnvInt("OUTBOX_MAX_ATTEMPTS", 20),
+		StatusCategoryJiraFieldID:       getEnv("STATUS_CATEGORY_JIRA_FIELD_ID", ""),
+		StatusCategoryMapping:           parseKeyValueList(getEnv("STATUS_CATEGORY_MAPPING", "triage=Triage,live=Live,learning=Learning,closed=Closed")),
+		IncidentTypeJiraFieldID:         getEnv("INCIDENT_TYPE_JIRA_FIELD_ID", ""),
+		IncidentTypeMapping:             parseKeyValueList(getEnv("INCIDENT_TYPE_MAPPING", "")),
+		IncidentTypeIssueTypes:          parseKeyValueList(getEnv("INCIDENT_TYPE_ISSUE_TYPES", "")),
+		SelectOptionAutoCreateFields:    parseList(getEnv("SELECT_OPTION_AUTO_CREATE_FIELDS", "")),
+		TimeTrackingUnit:                strings.ToLower(getEnv("TIME_TRACKING_UNIT", "h")),
+		JiraHoursPerDay:                 getEnvInt("JIRA_HOURS_PER_DAY", 8),
+		JiraDaysPerWeek:                 getEnvInt("JIRA_DAYS_PER_WEEK", 5),
+		WatcherRoles:                    parseList(getEnv("WATCHER_ROLES", "")),
+		LockRedisURL:                    getEnv("LOCK_REDIS_URL", ""),
+		LockKeyPrefix:                   getEnv("LOCK_KEY_PREFIX", "incident-jira-webhook:lock:"),
+		LockTTL:                         getEnvDuration("LOCK_TTL", 60*time.Second),
+		StateStore:                      getEnv("STATE_STORE", storeMemory),
+		StateStoreURL:                   getEnv("STATE_STORE_URL", ""),
+		StateStoreAutoMigrate:           getEnvBool("STATE_STORE_AUTO_MIGRATE", true),
+		DedupStore:                      getEnv("DEDUP_STORE", dedupState),
+		DedupStoreURL:                   getEnv("DEDUP_STORE_URL", ""),
+		DedupKeyPrefix:                  getEnv("DEDUP_KEY_PREFIX", "incident-jira-webhook:delivery:"),
+		DeliveryDedupTTL:                getEnvDuration("DELIVERY_DEDUP_TTL", 24*time.Hour),
+		LogPayloads:                     getEnvBool("LOG_PAYLOADS", false),
+		DebugIncidentDuration:           getEnvDuration("DEBUG_INCIDENT_DURATION", 15*time.Minute),
+		DebugIncidentMaxDuration:        getEnvDuration("DEBUG_INCIDENT_MAX_DURATION", 4*time.Hour),
+		AccessLog:                       getEnv("ACCESS_LOG", ""),
+		RedactFields:                    strings.Split(getEnv("REDACT_FIELDS", ""), ","),
+		RedactPatterns:                  strings.Split(getEnv("REDACT_PATTERNS", ""), ";"),
+		IncidentHTTP:                    getHTTPClientConfig("INCIDENT"),
+		JiraPageSize:                    getEnvInt("JIRA_PAGE_SIZE", jira.DefaultPagination.PageSize),
+		IncidentPageSize:                getEnvInt("INCIDENT_PAGE_SIZE", incidentio.DefaultPagination.PageSize),
+		MaxListPages:                    getEnvInt("MAX_LIST_PAGES", 100),
+		InitialSyncEvents:               parseList(getEnv("INITIAL_SYNC_EVENTS", "")),
+		AssetsAPIBaseURL:                getEnv("ASSETS_API_BASE_URL", "https://api.atlassian.com/jsm/assets"),
+		AssetsCreateMissingObjects:      getEnvBool("ASSETS_CREATE_MISSING_OBJECTS", false),
+		AssetsObjectTypeID:              getEnv("ASSETS_OBJECT_TYPE_ID", ""),
+		AssetsMatchAttribute:            getEnv("ASSETS_MATCH_ATTRIBUTE", "Name"),
+		AssetsAttributeMapping:          parseKeyValueList(getEnv("ASSETS_ATTRIBUTE_MAPPING", "")),
+		MultiValuePolicy:                getEnv("MULTI_VALUE_POLICY", mapping.MultiValueFirst),
+		MergePolicy:                     getEnv("MERGE_POLICY", mergePolicyReplace),
+		TemplatesDir:                    getEnv("TEMPLATES_DIR", ""),
+		BackfillConcurrency:             getEnvInt("BACKFILL_CONCURRENCY", 2),
+		BackfillRate:                    getEnvInt("BACKFILL_RATE", 60),
+		BackfillCheckpointFile:          getEnv("BACKFILL_CHECKPOINT_FILE", ""),
+		BackfillBulk:                    getEnvBool("BACKFILL_BULK", false),
+		SkipIncidents:                   parseList(strings.ToLower(getEnv("SKIP_INCIDENTS", ""))),
+		BackfillBulkChunk:               getEnvInt("BACKFILL_BULK_CHUNK", 100),
+		BackfillBulkWindow:              getEnvDuration("BACKFILL_BULK_WINDOW", 2*time.Second),
+		TemplateLanguage:                getEnv("TEMPLATE_LANGUAGE", "en"),
+		CreateIssues:                    getEnvBool("CREATE_ISSUES", false),
+		TemplateLanguageByIncidentType:  parseKeyValueList(getEnv("TEMPLATE_LANGUAGE_BY_INCIDENT_TYPE", "")),
+		EpicRollupEnabled:               getEnvBool("EPIC_ROLLUP", false),
+		EpicIssueTypeName:               getEnv("EPIC_ISSUE_TYPE", "Epic"),
+		SeverityLabelPrefix:             getEnv("SEVERITY_LABEL_PREFIX", "incident-severity-"),
+		SeverityChangeComments:          getEnvBool("SEVERITY_CHANGE_COMMENTS", false),
+		SeverityMentionSeverities:       parseList(getEnv("SEVERITY_MENTION_SEVERITIES", "")),
+		SeverityMentions:                parseList(getEnv("SEVERITY_MENTIONS", "")),
+		SeverityRankJiraFieldID:         getEnv("SEVERITY_RANK_JIRA_FIELD_ID", ""),
+		PostmortemSyncEnabled:           getEnvBool("POSTMORTEM_SYNC", false),
+		IncidentRemoteLink:              getEnvBool("INCIDENT_REMOTE_LINK", false),
+		PostmortemComment:               getEnvBool("POSTMORTEM_COMMENT", true),
+		PostmortemTransition:            getEnv("POSTMORTEM_TRANSITION", ""),
+		DismissalTransitions:            parseKeyValueList(getEnv("DISMISSAL_TRANSITIONS", "")),
+		DismissalComment:                getEnvBool("DISMISSAL_COMMENT", true),
+		ClosureSummaryEnabled:           getEnvBool("CLOSURE_SUMMARY_ATTACHMENT", false),
+		WorklogOnResolution:             getEnvBool("WORKLOG_ON_RESOLUTION", false),
+		WorklogCredential:               getEnv("WORKLOG_JIRA_CREDENTIAL", ""),
+		SyncMarkerEnabled:               getEnvBool("JIRA_SYNC_MARKER", false),
+		SyncMarkerPropertyKey:           getEnv("JIRA_SYNC_MARKER_PROPERTY", "incident-jira-webhook.last-synced-by"),
+		WriteVerification:               getEnvBool("WRITE_VERIFICATION", false),
+		WebhookAutoRegister:             getEnvBool("WEBHOOK_AUTO_REGISTER", false),
+		PublicURL:                       getEnv("PUBLIC_URL", ""),
+		TimeToAcknowledgeJiraFieldID:    getEnv("TIME_TO_ACKNOWLEDGE_JIRA_FIELD_ID", ""),
+		ResponderCountJiraFieldID:       getEnv("RESPONDER_COUNT_JIRA_FIELD_ID", ""),
+		ResponderTeamFieldName:          getEnv("RESPONDER_TEAM_FIELD", "Team"),
+		ResponderTeamJiraFieldID:        getEnv("RESPONDER_TEAM_JIRA_FIELD_ID", ""),
+		EscalationCountJiraFieldID:      getEnv("ESCALATION_COUNT_JIRA_FIELD_ID", ""),
+		TimeToResolveJiraFieldID:        getEnv("TIME_TO_RESOLVE_JIRA_FIELD_ID", ""),
+		SLAReportedTimestamp:            getEnv("SLA_REPORTED_TIMESTAMP", "Reported at"),
+		SLAAcknowledgedTimestamp:        getEnv("SLA_ACKNOWLEDGED_TIMESTAMP", "Accepted at"),
+		SLAResolvedTimestamp:            getEnv("SLA_RESOLVED_TIMESTAMP", "Resolved at"),
+		DueDateTimestamp:                getEnv("DUE_DATE_TIMESTAMP", ""),
+		DueDateSLAOffset:                getEnvDuration("DUE_DATE_SLA_OFFSET", 0),
+		TransformTimeout:                getEnvDuration("TRANSFORM_TIMEOUT", time.Second),
+		RelatedIssuesFieldName:          getEnv("RELATED_ISSUES_FIELD", ""),
+		RelatedIssuesFromAttachments:    getEnvBool("RELATED_ISSUES_FROM_ATTACHMENTS", false),
+		RelatedIssuesMax:                getEnvInt("RELATED_ISSUES_MAX", 10),
+		UnmappedFieldMetricLimit:        getEnvInt("UNMAPPED_FIELD_METRIC_LIMIT", 100),
+		UnknownEventSamples:             getEnvInt("UNKNOWN_EVENT_SAMPLES", 50),
+		UnknownEventSampleBytes:         getEnvInt("UNKNOWN_EVENT_SAMPLE_BYTES", 4096),
+	}
+
+	config.ImpactedComponentObjectKeyPattern = getEnv("IMPACTED_COMPONENT_OBJECT_KEY_PATTERN", "")
+	config.ResponsibleComponentObjectKeyPattern = getEnv("RESPONSIBLE_COMPONENT_OBJECT_KEY_PATTERN", "")
+	config.ImpactedComponentCatalogAttribute = getEnv("IMPACTED_COMPONENT_CATALOG_ATTRIBUTE", "")
+	config.ResponsibleComponentCatalogAttribute = getEnv("RESPONSIBLE_COMPONENT_CATALOG_ATTRIBUTE", "")
+	config.ImpactedComponentUseExternalID = getEnvBool("IMPACTED_COMPONENT_USE_EXTERNAL_ID", false)
+	config.ResponsibleComponentUseExternalID = getEnvBool("RESPONSIBLE_COMPONENT_USE_EXTERNAL_ID", false)
+	config.ImpactedComponentCredential = getEnv("IMPACTED_COMPONENT_JIRA_CREDENTIAL", "")
+	config.ResponsibleComponentCredential = getEnv("RESPONSIBLE_COMPONENT_JIRA_CREDENTIAL", "")
+	for tag := range parseList(getEnv("STATSD_TAGS", "")) {
+		config.StatsDTags = append(config.StatsDTags, tag)
+	}
+	sort.Strings(config.StatsDTags)
+
+	config.ImpactedComponentTargets = getJiraTargets("IMPACTED_COMPONENT", config.ImpactedComponentJiraFieldID)
+	config.ResponsibleComponentTargets = getJiraTargets("RESPONSIBLE_COMPONENT", config.ResponsibleComponentJiraFieldID)
+
+	// Epics roll up the component fields unless told otherwise
+	for fieldID := range parseList(getEnv("EPIC_ROLLUP_FIELDS", "")) {
+		config.EpicRollupFieldIDs = append(config.EpicRollupFieldIDs, fieldID)
+	}
+	if len(config.EpicRollupFieldIDs) == 0 {
+		config.EpicRollupFieldIDs = append(
+			mapping.FieldMapping{JiraTargets: config.ImpactedComponentTargets}.EnabledFieldIDs(),
+			mapping.FieldMapping{JiraTargets: config.ResponsibleComponentTargets}.EnabledFieldIDs()...)
+	}
+	sort.Strings(config.EpicRollupFieldIDs)
+	return config
+}
+
+// getJiraTargets builds the Jira targets for a built-in mapping: the primary field plus an
+// optional secondary field used for dual-writes while migrating between fields
+func getJiraTargets(prefix, primaryFieldID string) []mapping.JiraTarget {
+	targets := []mapping.JiraTarget{{
+		FieldID: primaryFieldID,
+		Enabled: getEnvBool(prefix+"_JIRA_FIELD_ENABLED", true),
+	}}
+
+	if secondaryFieldID := getEnv(prefix+"_SECONDARY_JIRA_FIELD_ID", ""); secondaryFieldID != "" {
+		targets = append(targets, mapping.JiraTarget{
+			FieldID: secondaryFieldID,
+			Enabled: getEnvBool(prefix+"_SECONDARY_JIRA_FIELD_ENABLED", true),
+		})
+	}
+
+	return targets
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// envErrors collects the malformed values the getEnv* helpers read while LoadConfig reads the
+// environment, so they fail it rather than silently falling back to defaults
+var (
+	envErrorsMu sync.Mutex
+	envErrors   []error
+)
+
+// invalidEnv records a value of key that couldn't be parsed
+func invalidEnv(key, value string, err error) {
+	envErrors = append(envErrors, fmt.Errorf("invalid %s %q: %w", key, value, err))
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		invalidEnv(key, value, errors.New("expected true or false"))
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		invalidEnv(key, value, errors.New("expected an integer"))
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		invalidEnv(key, value, errors.New("expected a duration with a unit, such as 30s"))
+		return defaultValue
+	}
+	return parsed
+}
+
+// parseList parses a comma-separated list into a set
+func parseList(value string) map[string]bool {
+	result := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result[item] = true
+		}
+	}
+	return result
+}
+
+// parseKeyValueList parses "key=value,key=value" into a map
+func parseKeyValueList(value string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		result[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetEnvInvalidValues(t *testing.T) {
+	t.Setenv("TEST_TIMEOUT", "30")
+	t.Setenv("TEST_ENABLED", "yes")
+	t.Setenv("TEST_SIZE", "10k")
+	t.Setenv("TEST_DELAY", "2s")
+
+	envErrorsMu.Lock()
+	defer envErrorsMu.Unlock()
+	envErrors = nil
+	if got := getEnvDuration("TEST_TIMEOUT", time.Minute); got != time.Minute {
+		t.Errorf("getEnvDuration = %v, want the default", got)
+	}
+	if got := getEnvBool("TEST_ENABLED", false); got {
+		t.Errorf("getEnvBool = %v, want the default", got)
+	}
+	if got := getEnvInt("TEST_SIZE", 5); got != 5 {
+		t.Errorf("getEnvInt = %v, want the default", got)
+	}
+	if got := getEnvDuration("TEST_DELAY", time.Minute); got != 2*time.Second {
+		t.Errorf("getEnvDuration = %v, want 2s", got)
+	}
+	if got := getEnvInt("TEST_UNSET", 5); got != 5 {
+		t.Errorf("getEnvInt of an unset variable = %v, want the default", got)
+	}
+
+	if len(envErrors) != 3 {
+		t.Fatalf("%d errors, want 3: %v", len(envErrors), envErrors)
+	}
+	for i, key := range []string{"TEST_TIMEOUT", "TEST_ENABLED", "TEST_SIZE"} {
+		if !strings.Contains(envErrors[i].Error(), key) {
+			t.Errorf("error %q doesn't name %s", envErrors[i], key)
+		}
+	}
+}
+
+func TestLoadConfigRejectsMalformedValues(t *testing.T) {
+	t.Setenv("PROCESSING_TIMEOUT", "30")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "PROCESSING_TIMEOUT") {
+		t.Errorf("LoadConfig() = %v, want an error naming PROCESSING_TIMEOUT", err)
+	}
+}
+
+// setRequiredEnv sets the environment variables LoadConfig requires
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	for key, value := range map[string]string{
+		"JIRA_API_TOKEN":                      "token",
+		"INCIDENT_API_TOKEN":                  "token",
+		"JIRA_BASE_URL":                       "https://example.atlassian.net",
+		"JIRA_USERNAME":                       "sync@example.com",
+		"JIRA_WORKSPACE_ID":                   "workspace",
+		"IMPACTED_COMPONENT_JIRA_FIELD_ID":    "customfield_1",
+		"RESPONSIBLE_COMPONENT_JIRA_FIELD_ID": "customfield_2",
+	} {
+		t.Setenv(key, value)
+	}
+}
+
+func TestLoadConfigLockTTL(t *testing.T) {
+	setRequiredEnv(t)
+	if _, err := LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() with the required variables = %v", err)
+	}
+
+	t.Setenv("LOCK_REDIS_URL", "redis://localhost:6379")
+	t.Setenv("PROCESSING_TIMEOUT", "2m")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "LOCK_TTL") {
+		t.Errorf("LoadConfig() with LOCK_TTL below PROCESSING_TIMEOUT = %v, want an error", err)
+	}
+	t.Setenv("LOCK_TTL", "3m")
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("LoadConfig() with LOCK_TTL above PROCESSING_TIMEOUT = %v", err)
+	}
+}
+
+func TestLoadConfigJiraCache(t *testing.T) {
+	setRequiredEnv(t)
+	for _, env := range []map[string]string{
+		{"JIRA_CACHE_MAX_ENTRIES": "0"},
+		{"JIRA_CACHE_MAX_ENTRIES": "-1"},
+		{"JIRA_CACHE_TTL": "-1m"},
+	} {
+		for key, value := range env {
+			t.Setenv(key, value)
+		}
+		if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "JIRA_CACHE") {
+			t.Errorf("LoadConfig() with %v = %v, want an error", env, err)
+		}
+		t.Setenv("JIRA_CACHE_MAX_ENTRIES", "1")
+		t.Setenv("JIRA_CACHE_TTL", "0")
+	}
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("LoadConfig() with a single cache entry and no TTL = %v", err)
+	}
+}
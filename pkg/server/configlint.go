@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of configuration warnings
+const (
+	// lintMissingJiraField is a configured Jira field that Jira doesn't have (any longer)
+	lintMissingJiraField = "missing_jira_field"
+)
+
+// configWarning is a problem with the configuration found against the live Jira site
+type configWarning struct {
+	Kind    string `json:"kind"`
+	Setting string `json:"setting"`
+	FieldID string `json:"field_id,omitempty"`
+	Message string `json:"message"`
+}
+
+// configLint holds the warnings of the last configuration check
+type configLint struct {
+	mu        sync.Mutex
+	warnings  []configWarning
+	checkedAt time.Time
+}
+
+func (c *configLint) snapshot() ([]configWarning, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]configWarning(nil), c.warnings...), c.checkedAt
+}
+
+// configuredJiraFields returns the settings referencing each Jira field ID
+func configuredJiraFields(config Config) map[string][]string {
+	fields := make(map[string][]string)
+	add := func(fieldID, setting string) {
+		if fieldID != "" {
+			fields[fieldID] = append(fields[fieldID], setting)
+		}
+	}
+
+	for _, target := range config.ImpactedComponentTargets {
+		if target.Enabled {
+			add(target.FieldID, "IMPACTED_COMPONENT_JIRA_FIELD_ID")
+		}
+	}
+	for _, target := range config.ResponsibleComponentTargets {
+		if target.Enabled {
+			add(target.FieldID, "RESPONSIBLE_COMPONENT_JIRA_FIELD_ID")
+		}
+	}
+	if config.SprintFieldName != "" {
+		add(config.JiraSprintFieldID, "JIRA_SPRINT_FIELD_ID")
+	}
+	add(config.StatusCategoryJiraFieldID, "STATUS_CATEGORY_JIRA_FIELD_ID")
+	add(config.IncidentTypeJiraFieldID, "INCIDENT_TYPE_JIRA_FIELD_ID")
+	add(config.SeverityRankJiraFieldID, "SEVERITY_RANK_JIRA_FIELD_ID")
+	add(config.TimeToAcknowledgeJiraFieldID, "TIME_TO_ACKNOWLEDGE_JIRA_FIELD_ID")
+	add(config.TimeToResolveJiraFieldID, "TIME_TO_RESOLVE_JIRA_FIELD_ID")
+	add(config.ResponderCountJiraFieldID, "RESPONDER_COUNT_JIRA_FIELD_ID")
+	add(config.ResponderTeamJiraFieldID, "RESPONDER_TEAM_JIRA_FIELD_ID")
+	add(config.EscalationCountJiraFieldID, "ESCALATION_COUNT_JIRA_FIELD_ID")
+	if config.EpicRollupEnabled {
+		for _, fieldID := range config.EpicRollupFieldIDs {
+			add(fieldID, "EPIC_ROLLUP_FIELDS")
+		}
+	}
+	for _, rule := range config.MappingRules {
+		for incidentField, fieldID := range rule.JiraFields {
+			add(fieldID, fmt.Sprintf("mapping rule %q (%s)", rule.Pattern+rule.Regex, incidentField))
+		}
+	}
+	return fields
+}
+
+// lintConfig checks the configuration against the Jira site, replacing the warnings of the
+// last check. When Jira can't be asked, the last warnings are kept.
+func (s *IncidentJiraSync) lintConfig(ctx context.Context) error {
+	config := s.config
+	config.MappingRules = s.settings().MappingRules
+	configured := configuredJiraFields(config)
+	fields, err := s.jira.Fields(ctx)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		existing[field.ID] = true
+	}
+
+	var warnings []configWarning
+	for fieldID, settings := range configured {
+		if existing[fieldID] {
+			continue
+		}
+		sort.Strings(settings)
+		for _, setting := range settings {
+			warnings = append(warnings, configWarning{
+				Kind:    lintMissingJiraField,
+				Setting: setting,
+				FieldID: fieldID,
+				Message: fmt.Sprintf("Jira has no field %s; writes to it will fail", fieldID),
+			})
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool {
+		if warnings[i].FieldID != warnings[j].FieldID {
+			return warnings[i].FieldID < warnings[j].FieldID
+		}
+		return warnings[i].Setting < warnings[j].Setting
+	})
+
+	for _, warning := range warnings {
+		log.Printf("Warning: %s references Jira field %s, which doesn't exist", warning.Setting, warning.FieldID)
+	}
+	configWarningsGauge.set(float64(len(warnings)), lintMissingJiraField)
+
+	s.lint.mu.Lock()
+	s.lint.warnings, s.lint.checkedAt = warnings, time.Now().UTC()
+	s.lint.mu.Unlock()
+	return nil
+}
+
+// runConfigLinter checks the configuration at startup and every CONFIG_LINT_INTERVAL
+func (s *IncidentJiraSync) runConfigLinter() {
+	ticker := time.NewTicker(s.config.ConfigLintInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := s.processingContext(context.Background())
+		if err := s.lintConfig(ctx); err != nil {
+			log.Printf("Failed to check the configuration against Jira: %v", err)
+		}
+		cancel()
+		<-ticker.C
+	}
+}
+
+var configWarningsGauge = newGaugeVec(
+	"incident_jira_webhook_config_warnings",
+	"Configuration problems found by the last check against Jira, by kind (missing_jira_field).",
+	"kind")
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// unmappedFieldOther labels unmapped fields beyond UNMAPPED_FIELD_METRIC_LIMIT
+const unmappedFieldOther = "other"
+
+// mappingCoverage remembers which unmapped incident fields have their own metric label, so a
+// workspace with many custom fields can't grow the metric without bound
+type mappingCoverage struct {
+	limit int
+
+	mu     sync.Mutex
+	fields map[string]bool
+}
+
+func newMappingCoverage(limit int) *mappingCoverage {
+	return &mappingCoverage{limit: limit, fields: make(map[string]bool)}
+}
+
+// label returns the metric label of an unmapped field
+func (c *mappingCoverage) label(fieldName string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fields[fieldName] {
+		return fieldName
+	}
+	if len(c.fields) >= c.limit {
+		return unmappedFieldOther
+	}
+	c.fields[fieldName] = true
+	return fieldName
+}
+
+// recordConfiguredMappings sets the configured mappings gauge from the built-in mappings and
+// the mapping rules
+func (s *IncidentJiraSync) recordConfiguredMappings() {
+	builtins := 0
+	for _, fieldMapping := range s.getFieldMappings() {
+		if fieldMapping.IncidentFieldName != "" && len(fieldMapping.EnabledFieldIDs()) > 0 {
+			builtins++
+		}
+	}
+	configuredMappings.set(float64(builtins), "builtin")
+	configuredMappings.set(float64(len(s.settings().MappingRules)), "rule")
+}
+
+// recordMappingCoverage counts the fields of a webhook event that have a mapping and those that
+// don't, so incident fields in use but not yet mapped show up in metrics
+func (s *IncidentJiraSync) recordMappingCoverage(incident incidentio.Incident) {
+	matched := 0
+	for _, entry := range incident.CustomFieldEntries {
+		fieldName := entry.CustomField.Name
+		if _, found := s.resolveFieldMapping(fieldName); found {
+			matched++
+			continue
+		}
+		unmappedFieldsTotal.inc(s.coverage.label(fieldName))
+	}
+	webhookMappingsMatched.set(float64(matched))
+}
+
+var (
+	configuredMappings = newGaugeVec(
+		"incident_jira_webhook_configured_mappings",
+		"Field mappings configured, by kind (builtin or rule).",
+		"kind")
+	webhookMappingsMatched = newGaugeVec(
+		"incident_jira_webhook_webhook_mappings_matched",
+		"Incident fields of the last webhook event that matched a mapping.")
+	unmappedFieldsTotal = newCounterVec(
+		"incident_jira_webhook_unmapped_fields_total",
+		"Incident fields seen in webhook events without a mapping, by incident field name.",
+		"field")
+)
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// creationTemplateName is the name of creation templates in TEMPLATES_DIR, with the incident
+// type after it for templates of one type, e.g. create_issue.security.json
+const creationTemplateName = "create_issue"
+
+// defaultCreationSummary is the summary of created issues when their template has none
+const defaultCreationSummary = `{{with .Incident.Reference}}{{.}}: {{end}}{{.Incident.Name}}`
+
+// creationTemplate describes the Jira issue created for an incident without one. Every string in
+// it, including those in the description document and field values, is a Go template rendered
+// with the incident, like the comment templates.
+type creationTemplate struct {
+	Project   string   `json:"project"`
+	IssueType string   `json:"issue_type"`
+	Summary   string   `json:"summary,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
+	// Description is an Atlassian Document Format document
+	Description json.RawMessage `json:"description,omitempty"`
+	// Fields are the initial values of further fields, by Jira field ID, as the Jira API takes
+	// them
+	Fields map[string]json.RawMessage `json:"fields,omitempty"`
+}
+
+// loadCreationTemplates parses every create_issue*.json file in dir, keyed by file name without
+// the extension, e.g. "create_issue.security"
+func loadCreationTemplates(dir string) (map[string]*creationTemplate, error) {
+	templates := make(map[string]*creationTemplate)
+	if dir == "" {
+		return templates, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, creationTemplateName+"*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if name != creationTemplateName && !strings.HasPrefix(name, creationTemplateName+".") {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read creation template: %w", err)
+		}
+		tmpl, err := parseCreationTemplate(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid creation template %s: %w", filepath.Base(path), err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// parseCreationTemplate reads a creation template, checking that each of its strings parses as
+// a template
+func parseCreationTemplate(data []byte) (*creationTemplate, error) {
+	var tmpl creationTemplate
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tmpl); err != nil {
+		return nil, err
+	}
+	if tmpl.Project == "" || tmpl.IssueType == "" {
+		return nil, errors.New("project and issue_type are required")
+	}
+	if tmpl.Summary == "" {
+		tmpl.Summary = defaultCreationSummary
+	}
+
+	if len(tmpl.Description) > 0 {
+		var document struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(tmpl.Description, &document); err != nil || document.Type != "doc" {
+			return nil, errors.New(`description must be an Atlassian Document Format document, {"type": "doc", ...}`)
+		}
+	}
+
+	// Parsing finds syntax errors; execution errors depend on the incident
+	check := func(text string) error {
+		_, err := parseTextTemplate(text)
+		return err
+	}
+	for _, text := range append([]string{tmpl.Project, tmpl.IssueType, tmpl.Summary}, tmpl.Labels...) {
+		if err := check(text); err != nil {
+			return nil, err
+		}
+	}
+	values := map[string]json.RawMessage{"description": tmpl.Description}
+	for fieldID, value := range tmpl.Fields {
+		values["fields."+fieldID] = value
+	}
+	for key, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(value, &decoded); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		if err := walkJSONStrings(decoded, check); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	return &tmpl, nil
+}
+
+func parseTextTemplate(text string) (*template.Template, error) {
+	return template.New(creationTemplateName).Funcs(templateFuncs).Parse(text)
+}
+
+// walkJSONStrings calls fn with every string value in a decoded JSON value
+func walkJSONStrings(value interface{}, fn func(string) error) error {
+	switch value := value.(type) {
+	case string:
+		return fn(value)
+	case map[string]interface{}:
+		for _, child := range value {
+			if err := walkJSONStrings(child, fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			if err := walkJSONStrings(child, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderJSONStrings returns a decoded JSON value with every string in it rendered as a template
+func renderJSONStrings(value interface{}, data templateData) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return renderTextTemplate(value, data)
+	case map[string]interface{}:
+		for key, child := range value {
+			rendered, err := renderJSONStrings(child, data)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = rendered
+		}
+	case []interface{}:
+		for i, child := range value {
+			rendered, err := renderJSONStrings(child, data)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = rendered
+		}
+	}
+	return value, nil
+}
+
+// pruneEmptyText drops the text nodes of a rendered Atlassian Document Format document whose
+// text rendered empty, which Jira rejects, and the nodes left without content by that. The
+// document itself is kept; values that aren't documents are returned as they are.
+func pruneEmptyText(value interface{}) interface{} {
+	if document, isNode := value.(map[string]interface{}); isNode && document["type"] == "doc" {
+		keepADFNode(document)
+	}
+	return value
+}
+
+// keepADFNode prunes the content of a document node, reporting whether the node should be kept
+func keepADFNode(node map[string]interface{}) bool {
+	if node["type"] == "text" {
+		text, _ := node["text"].(string)
+		return text != ""
+	}
+	content, hasContent := node["content"].([]interface{})
+	if !hasContent || len(content) == 0 {
+		return true
+	}
+	kept := content[:0]
+	for _, child := range content {
+		if childNode, isNode := child.(map[string]interface{}); !isNode || keepADFNode(childNode) {
+			kept = append(kept, child)
+		}
+	}
+	node["content"] = kept
+	return len(kept) > 0
+}
+
+func renderTextTemplate(text string, data templateData) (string, error) {
+	tmpl, err := parseTextTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// creationTemplateFor returns the creation template for an incident: the one for its incident
+// type, or create_issue.json
+func (s *IncidentJiraSync) creationTemplateFor(incident incidentio.Incident) (*creationTemplate, bool) {
+	templates := s.settings().CreationTemplates
+	if incident.IncidentType != nil {
+		if tmpl, exists := templates[creationTemplateName+"."+templateSlug(incident.IncidentType.Name)]; exists {
+			return tmpl, true
+		}
+	}
+	tmpl, exists := templates[creationTemplateName]
+	return tmpl, exists
+}
+
+// creationFields renders a creation template for an incident into the fields of the issue to
+// create
+func creationFields(tmpl *creationTemplate, incident incidentio.Incident) (map[string]interface{}, error) {
+	data := templateData{Incident: incident}
+	fields := make(map[string]interface{})
+	for fieldID, value := range tmpl.Fields {
+		var decoded interface{}
+		if err := json.Unmarshal(value, &decoded); err != nil {
+			return nil, err
+		}
+		rendered, err := renderJSONStrings(decoded, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", fieldID, err)
+		}
+		fields[fieldID] = pruneEmptyText(rendered)
+	}
+
+	project, err := renderTextTemplate(tmpl.Project, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render project: %w", err)
+	}
+	issueType, err := renderTextTemplate(tmpl.IssueType, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render issue_type: %w", err)
+	}
+	summary, err := renderTextTemplate(tmpl.Summary, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render summary: %w", err)
+	}
+	fields["project"] = map[string]string{"key": strings.TrimSpace(project)}
+	fields["issuetype"] = map[string]string{"name": strings.TrimSpace(issueType)}
+	fields["summary"] = strings.TrimSpace(summary)
+
+	if len(tmpl.Labels) > 0 {
+		labels := []string{}
+		for _, label := range tmpl.Labels {
+			rendered, err := renderTextTemplate(label, data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to render labels: %w", err)
+			}
+			if rendered = strings.TrimSpace(rendered); rendered != "" {
+				labels = append(labels, rendered)
+			}
+		}
+		fields["labels"] = labels
+	}
+
+	if len(tmpl.Description) > 0 {
+		var document interface{}
+		if err := json.Unmarshal(tmpl.Description, &document); err != nil {
+			return nil, err
+		}
+		rendered, err := renderJSONStrings(document, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render description: %w", err)
+		}
+		fields["description"] = pruneEmptyText(rendered)
+	}
+	return fields, nil
+}
+
+// creationClaimLease is how long a replica holds the claim on creating an incident's issue.
+// Once it expires another replica may take the claim over, finding any issue the first one
+// created by its label.
+const creationClaimLease = 5 * time.Minute
+
+// creationLabel tags the Jira issue created for an incident, so an issue whose creation
+// couldn't be recorded is found again instead of created twice
+func creationLabel(incidentID string) string {
+	return "incident-" + incidentID
+}
+
+// createdIssue returns the Jira issue created for an incident without a linked one, creating it
+// from the incident's creation template on its first event. created is true when it was just
+// created, or found after an earlier creation wasn't recorded, so it is synced in full. The
+// incident is claimed in the state store before Jira is called, so only one replica creates its
+// issue, and the issue is found by its creation label before creating another, in case an
+// earlier creation wasn't recorded.
+func (s *IncidentJiraSync) createdIssue(ctx context.Context, incident incidentio.Incident) (jiraIssueKey string, created bool, err error) {
+	if incident.ID == "" {
+		return "", false, errors.New("no Jira issue found for incident")
+	}
+
+	storeCtx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	jiraIssueKey, claimed, err := s.store.ClaimIssueCreation(storeCtx, incident.ID, creationClaimLease)
+	cancel()
+	if err != nil {
+		// Without knowing whether an issue was already created, creating one could duplicate it
+		return "", false, err
+	}
+	if jiraIssueKey != "" {
+		return jiraIssueKey, false, nil
+	}
+	if !claimed {
+		return "", false, fmt.Errorf("the Jira issue of incident %s is being created elsewhere", incident.ID)
+	}
+
+	jiraIssueKey, created, err = s.createIssueForIncident(ctx, incident)
+	storeCtx, cancel = context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err != nil {
+		if releaseErr := s.store.ReleaseIssueCreation(storeCtx, incident.ID); releaseErr != nil {
+			log.Printf("Warning: failed to release the issue creation claim of incident %s: %v", incident.ID, releaseErr)
+		}
+		return "", false, err
+	}
+	if err := s.store.CompleteIssueCreation(storeCtx, incident.ID, jiraIssueKey); err != nil {
+		return "", false, fmt.Errorf("created Jira issue %s for incident %s but failed to record it: %w", jiraIssueKey, incident.ID, err)
+	}
+	return jiraIssueKey, created, nil
+}
+
+// createIssueForIncident creates an incident's Jira issue, unless one with its creation label
+// already exists
+func (s *IncidentJiraSync) createIssueForIncident(ctx context.Context, incident incidentio.Incident) (string, bool, error) {
+	tmpl, found := s.creationTemplateFor(incident)
+	if !found {
+		return "", false, errors.New("no Jira issue found for incident, and no creation template for its incident type")
+	}
+	fields, err := creationFields(tmpl, incident)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to render creation template: %w", err)
+	}
+
+	label := creationLabel(incident.ID)
+	existing, err := s.jira.SearchIssuesFresh(ctx, fmt.Sprintf(`labels = "%s"`, label), []string{"summary"})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to search for an issue already created: %w", err)
+	}
+	if len(existing) > 0 {
+		// The earlier creation failed to be recorded, and likely to be synced
+		log.Printf("Found Jira issue %s already created for incident %s", existing[0].Key, incident.ID)
+		return existing[0].Key, true, nil
+	}
+
+	labels, _ := fields["labels"].([]string)
+	fields["labels"] = append(labels, label)
+	issue, err := s.jira.CreateIssue(ctx, fields)
+	if err != nil {
+		return "", false, err
+	}
+	log.Printf("Created Jira issue %s for incident %s", issue.Key, incident.ID)
+	s.stream.publish(streamEvent{Type: streamJiraWrite, IssueKey: issue.Key, Outcome: "success", Message: "created for incident " + incident.ID})
+	issuesCreatedTotal.inc()
+	return issue.Key, true, nil
+}
+
+var issuesCreatedTotal = newCounterVec(
+	"incident_jira_webhook_issues_created_total",
+	"Jira issues created from creation templates for incidents without one.")
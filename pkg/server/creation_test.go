@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+func TestCreationTemplates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"create_issue.json": `{"project": "INC", "issue_type": "Incident"}`,
+		"create_issue.security.json": `{
+			"project": "SEC",
+			"issue_type": "Security Incident",
+			"summary": "[{{.Incident.Severity.Name}}] {{.Incident.Name}}",
+			"labels": ["incident", "{{with .Incident.Severity}}{{.Name}}{{end}}", "{{if false}}dropped{{end}}"],
+			"description": {"type": "doc", "version": 1, "content": [
+				{"type": "paragraph", "content": [{"type": "text", "text": "Declared in incident.io: {{.Incident.Permalink}}"}]}
+			]},
+			"fields": {"customfield_10010": {"value": "{{.Incident.Severity.Name}}"}, "customfield_10011": 3}
+		}`,
+		"create_issue_notes.json": `not a template`,
+		"description.tmpl":        `{{.Incident.Name}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templates, err := loadCreationTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 2 {
+		t.Fatalf("loaded %d creation templates, want 2", len(templates))
+	}
+	s := &IncidentJiraSync{}
+	s.reloadable.Store(&reloadableSettings{CreationTemplates: templates})
+
+	incident := incidentio.Incident{
+		Name:         "Payments down",
+		Reference:    "INC-42",
+		Permalink:    "https://app.incident.io/incidents/42",
+		Severity:     &incidentio.Severity{Name: "Critical"},
+		IncidentType: &incidentio.IncidentType{Name: "Security"},
+	}
+	tmpl, found := s.creationTemplateFor(incident)
+	if !found {
+		t.Fatal("no creation template for a security incident")
+	}
+	fields, err := creationFields(tmpl, incident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(fields)
+	want := `{"customfield_10010":{"value":"Critical"},"customfield_10011":3,` +
+		`"description":{"content":[{"content":[{"text":"Declared in incident.io: https://app.incident.io/incidents/42","type":"text"}],"type":"paragraph"}],"type":"doc","version":1},` +
+		`"issuetype":{"name":"Security Incident"},"labels":["incident","Critical"],"project":{"key":"SEC"},"summary":"[Critical] Payments down"}`
+	if string(got) != want {
+		t.Errorf("fields = %s\nwant %s", got, want)
+	}
+
+	incident.IncidentType = &incidentio.IncidentType{Name: "Platform"}
+	tmpl, _ = s.creationTemplateFor(incident)
+	fields, err = creationFields(tmpl, incident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDefault := map[string]interface{}{
+		"project":   map[string]string{"key": "INC"},
+		"issuetype": map[string]string{"name": "Incident"},
+		"summary":   "INC-42: Payments down",
+	}
+	if !reflect.DeepEqual(fields, wantDefault) {
+		t.Errorf("fields = %v, want %v", fields, wantDefault)
+	}
+}
+
+func TestCreationFieldsDropEmptyText(t *testing.T) {
+	tmpl, err := parseCreationTemplate([]byte(`{
+		"project": "INC",
+		"issue_type": "Incident",
+		"description": {"type": "doc", "version": 1, "content": [
+			{"type": "paragraph", "content": [{"type": "text", "text": "{{.Incident.Summary}}"}]},
+			{"type": "paragraph", "content": [{"type": "text", "text": "Summary: ", "marks": [{"type": "strong"}]}, {"type": "text", "text": "{{.Incident.Summary}}"}]},
+			{"type": "rule"}
+		]},
+		"fields": {"customfield_10012": {"type": "doc", "version": 1, "content": [
+			{"type": "bulletList", "content": [{"type": "listItem", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "{{.Incident.Summary}}"}]}]}]}
+		]}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An incident without a summary renders its text nodes empty
+	fields, err := creationFields(tmpl, incidentio.Incident{Name: "Payments down"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(map[string]interface{}{"description": fields["description"], "customfield_10012": fields["customfield_10012"]})
+	want := `{"customfield_10012":{"content":[],"type":"doc","version":1},` +
+		`"description":{"content":[{"content":[{"marks":[{"type":"strong"}],"text":"Summary: ","type":"text"}],"type":"paragraph"},{"type":"rule"}],"type":"doc","version":1}}`
+	if string(got) != want {
+		t.Errorf("fields = %s\nwant %s", got, want)
+	}
+}
+
+func TestParseCreationTemplateErrors(t *testing.T) {
+	tests := map[string]string{
+		`{"issue_type": "Incident"}`:                                                                           "project and issue_type are required",
+		`{"project": "INC", "issue_type": "Incident", "assignee": "me"}`:                                       "unknown field",
+		`{"project": "INC", "issue_type": "Incident", "summary": "{{.Nope"}`:                                   "unclosed action",
+		`{"project": "INC", "issue_type": "Incident", "description": {"type": "paragraph"}}`:                   "Atlassian Document Format",
+		`{"project": "INC", "issue_type": "Incident", "fields": {"customfield_1": ["{{range}}"]}}`:             "fields.customfield_1",
+		`{"project": "INC", "issue_type": "Incident", "description": {"type": "doc", "content": ["{{end}}"]}}`: "description",
+	}
+	for template, want := range tests {
+		if _, err := parseCreationTemplate([]byte(template)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseCreationTemplate(%s) error = %v, want %q", template, err, want)
+		}
+	}
+}
+
+func TestCreatedIssue(t *testing.T) {
+	tests := []struct {
+		name        string
+		claim       func(store *memoryStore)
+		existing    string
+		wantKey     string
+		wantCreated bool
+		wantCreates int
+		wantErr     string
+	}{
+		{name: "first event", wantKey: "INC-1", wantCreated: true, wantCreates: 1},
+		{name: "recorded", claim: func(store *memoryStore) {
+			store.CompleteIssueCreation(context.Background(), "inc_1", "INC-7")
+		}, wantKey: "INC-7"},
+		{name: "claimed elsewhere", claim: func(store *memoryStore) {
+			store.ClaimIssueCreation(context.Background(), "inc_1", time.Minute)
+		}, wantErr: "being created elsewhere"},
+		{name: "expired claim", claim: func(store *memoryStore) {
+			store.ClaimIssueCreation(context.Background(), "inc_1", -time.Minute)
+		}, wantKey: "INC-1", wantCreated: true, wantCreates: 1},
+		{name: "created but not recorded", existing: "INC-3", wantKey: "INC-3", wantCreated: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creates := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/rest/api/3/search/jql":
+					if jql := r.URL.Query().Get("jql"); jql != `labels = "incident-inc_1"` {
+						t.Errorf("jql = %s", jql)
+					}
+					if test.existing == "" {
+						w.Write([]byte(`{"issues": [], "isLast": true}`))
+						return
+					}
+					w.Write([]byte(`{"issues": [{"key": "` + test.existing + `"}], "isLast": true}`))
+				case "/rest/api/3/issue":
+					creates++
+					var request struct {
+						Fields struct {
+							Labels []string `json:"labels"`
+						} `json:"fields"`
+					}
+					json.NewDecoder(r.Body).Decode(&request)
+					if !reflect.DeepEqual(request.Fields.Labels, []string{"incident", "incident-inc_1"}) {
+						t.Errorf("labels = %v", request.Fields.Labels)
+					}
+					w.Write([]byte(`{"key": "INC-1"}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer server.Close()
+
+			tmpl, err := parseCreationTemplate([]byte(`{"project": "INC", "issue_type": "Incident", "labels": ["incident"]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := newMemoryStore()
+			s := &IncidentJiraSync{
+				jira:   jira.NewClient(server.URL, "user", "token", server.Client()),
+				store:  store,
+				stream: newEventStream(nil),
+			}
+			s.reloadable.Store(&reloadableSettings{CreationTemplates: map[string]*creationTemplate{creationTemplateName: tmpl}})
+			if test.claim != nil {
+				test.claim(store)
+			}
+
+			key, created, err := s.createdIssue(context.Background(), incidentio.Incident{ID: "inc_1", Name: "Payments down"})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key != test.wantKey || created != test.wantCreated || creates != test.wantCreates {
+				t.Errorf("createdIssue = %s, %v after %d creates, want %s, %v after %d", key, created, creates, test.wantKey, test.wantCreated, test.wantCreates)
+			}
+			if recorded, _, _ := store.ClaimIssueCreation(context.Background(), "inc_1", time.Minute); recorded != test.wantKey {
+				t.Errorf("recorded issue = %q, want %s", recorded, test.wantKey)
+			}
+		})
+	}
+}
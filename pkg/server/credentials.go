@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// defaultIncidentCredential names INCIDENT_API_TOKEN in INCIDENT_CREDENTIAL_BY_OPERATION
+const defaultIncidentCredential = "default"
+
+// credentialEnvSuffix turns a credential name into the suffix of its variables, e.g.
+// CATALOG_READER for "catalog-reader"
+func credentialEnvSuffix(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+}
+
+// incidentCredentialEnv returns the variable holding the API key of a named credential, e.g.
+// INCIDENT_API_TOKEN_CATALOG_READER for "catalog-reader"
+func incidentCredentialEnv(name string) string {
+	return "INCIDENT_API_TOKEN_" + credentialEnvSuffix(name)
+}
+
+// loadIncidentCredentials reads the API key of each credential named in INCIDENT_CREDENTIALS
+func loadIncidentCredentials(names string) map[string]string {
+	credentials := make(map[string]string)
+	for name := range parseList(names) {
+		credentials[name] = os.Getenv(incidentCredentialEnv(name))
+	}
+	return credentials
+}
+
+// incidentOperationTokens returns the API key of each operation type given its own credential
+func (c Config) incidentOperationTokens() map[string]string {
+	tokens := make(map[string]string, len(c.IncidentOperationCredentials))
+	for operation, name := range c.IncidentOperationCredentials {
+		if name == defaultIncidentCredential {
+			tokens[operation] = c.IncidentAPIToken
+		} else {
+			tokens[operation] = c.IncidentCredentials[name]
+		}
+	}
+	return tokens
+}
+
+// validateIncidentCredentials checks that every operation type has an API key, and that catalog
+// reads given their own credential don't share its key with an operation that writes
+func validateIncidentCredentials(config Config) error {
+	for name, token := range config.IncidentCredentials {
+		if name == defaultIncidentCredential {
+			return fmt.Errorf("INCIDENT_CREDENTIALS can't define %q, which names INCIDENT_API_TOKEN", name)
+		}
+		if token == "" {
+			return fmt.Errorf("%s environment variable is required for incident.io credential %s", incidentCredentialEnv(name), name)
+		}
+	}
+
+	known := make(map[string]bool, len(incidentio.Operations))
+	for _, operation := range incidentio.Operations {
+		known[operation] = true
+	}
+	for operation, name := range config.IncidentOperationCredentials {
+		if !known[operation] {
+			return fmt.Errorf("unknown incident.io operation in INCIDENT_CREDENTIAL_BY_OPERATION: %s (expected one of %s)", operation, strings.Join(incidentio.Operations, ", "))
+		}
+		if _, defined := config.IncidentCredentials[name]; !defined && name != defaultIncidentCredential {
+			return fmt.Errorf("INCIDENT_CREDENTIAL_BY_OPERATION uses credential %s for %s, which INCIDENT_CREDENTIALS doesn't define", name, operation)
+		}
+	}
+
+	if config.IncidentAPIToken == "" {
+		for _, operation := range incidentio.Operations {
+			if name, set := config.IncidentOperationCredentials[operation]; !set || name == defaultIncidentCredential {
+				return errors.New("INCIDENT_API_TOKEN environment variable is required")
+			}
+		}
+	}
+
+	if _, separate := config.IncidentOperationCredentials[incidentio.OperationCatalog]; separate {
+		tokens := config.incidentOperationTokens()
+		token := func(operation string) string {
+			if token, set := tokens[operation]; set {
+				return token
+			}
+			return config.IncidentAPIToken
+		}
+		for _, operation := range []string{incidentio.OperationWrite, incidentio.OperationWebhooks} {
+			if token(incidentio.OperationCatalog) == token(operation) {
+				return fmt.Errorf("incident.io catalog reads must not use the same API key as %s operations", operation)
+			}
+		}
+	}
+	return nil
+}
+
+// loadJiraCredentials reads the account of each credential named in JIRA_CREDENTIALS from
+// JIRA_USERNAME_<NAME> and JIRA_API_TOKEN_<NAME>
+func loadJiraCredentials(names string) map[string]jira.Credentials {
+	credentials := make(map[string]jira.Credentials)
+	for name := range parseList(names) {
+		suffix := credentialEnvSuffix(name)
+		credentials[name] = jira.Credentials{
+			Username: os.Getenv("JIRA_USERNAME_" + suffix),
+			APIToken: os.Getenv("JIRA_API_TOKEN_" + suffix),
+		}
+	}
+	return credentials
+}
+
+// validateJiraCredentials checks that every Jira credential has an account, and that mappings
+// only use credentials JIRA_CREDENTIALS defines
+func validateJiraCredentials(config Config) error {
+	for name, credentials := range config.JiraCredentials {
+		suffix := credentialEnvSuffix(name)
+		if credentials.Username == "" {
+			return fmt.Errorf("JIRA_USERNAME_%s environment variable is required for Jira credential %s", suffix, name)
+		}
+		if credentials.APIToken == "" {
+			return fmt.Errorf("JIRA_API_TOKEN_%s environment variable is required for Jira credential %s", suffix, name)
+		}
+	}
+
+	check := func(name, usedBy string) error {
+		if _, defined := config.JiraCredentials[name]; name != "" && !defined {
+			return fmt.Errorf("%s uses Jira credential %s, which JIRA_CREDENTIALS doesn't define", usedBy, name)
+		}
+		return nil
+	}
+	if err := check(config.ImpactedComponentCredential, "IMPACTED_COMPONENT_JIRA_CREDENTIAL"); err != nil {
+		return err
+	}
+	if err := check(config.ResponsibleComponentCredential, "RESPONSIBLE_COMPONENT_JIRA_CREDENTIAL"); err != nil {
+		return err
+	}
+	if err := check(config.WorklogCredential, "WORKLOG_JIRA_CREDENTIAL"); err != nil {
+		return err
+	}
+	for _, rules := range [][]mapping.Rule{config.MappingRules, config.ShadowMappingRules} {
+		for _, rule := range rules {
+			if err := check(rule.Credential, fmt.Sprintf("mapping rule %q", rule.Pattern+rule.Regex)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// withJiraCredential returns a context whose Jira requests use the mapping's credential, if it
+// names one
+func (s *IncidentJiraSync) withJiraCredential(ctx context.Context, fieldMapping mapping.FieldMapping) context.Context {
+	if fieldMapping.Credential == "" {
+		return ctx
+	}
+	credentials, defined := s.config.JiraCredentials[fieldMapping.Credential]
+	if !defined {
+		return ctx
+	}
+	return jira.WithCredentials(ctx, credentials)
+}
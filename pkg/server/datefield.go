@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// jiraDateTimeFormat is the format Jira takes date-time field values in
+const jiraDateTimeFormat = "2006-01-02T15:04:05.000-0700"
+
+// incidentDateLayouts are the formats incident values are read as dates in, most precise first
+var incidentDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	jiraDateFormat,
+}
+
+// parseIncidentDate reads an incident value as a date or date-time. Values without an offset
+// are in location.
+func parseIncidentDate(text string, location *time.Location) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	for _, layout := range incidentDateLayouts {
+		if parsed, err := time.ParseInLocation(layout, text, location); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date", text)
+}
+
+// incidentDate returns the date a date mapping writes for an incident field: its first value
+// (or catalog attribute), after the mapping's transform, in the mapping's time zone. found is
+// false when the field has no value.
+func (s *IncidentJiraSync) incidentDate(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, fieldMapping mapping.FieldMapping) (date time.Time, found bool, err error) {
+	texts, err := s.selectTexts(ctx, customFieldEntry, fieldMapping)
+	if err != nil || len(texts) == 0 {
+		return time.Time{}, false, err
+	}
+	if len(texts) > 1 {
+		log.Printf("Warning: %s has %d values, writing only the first as the date", fieldMapping.IncidentFieldName, len(texts))
+	}
+
+	location := fieldMapping.Location()
+	date, err = parseIncidentDate(texts[0], location)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s: %w", fieldMapping.IncidentFieldName, err)
+	}
+	return date.In(location), true, nil
+}
+
+// formatJiraDate formats a date for a Jira field: in the mapping's date format, or else in the
+// format Jira takes for the field's schema type
+func formatJiraDate(date time.Time, fieldMapping mapping.FieldMapping, schemaType string) string {
+	switch {
+	case fieldMapping.DateFormat != "":
+		return date.Format(fieldMapping.DateFormat)
+	case schemaType == "date":
+		return date.Format(jiraDateFormat)
+	}
+	return date.Format(jiraDateTimeFormat)
+}
+
+// processDateField writes an incident field to Jira date or date-time fields, in the mapping's
+// date format and time zone. A field without a value clears the Jira fields.
+func (s *IncidentJiraSync) processDateField(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, jiraIssueKey string, fieldMapping mapping.FieldMapping) error {
+	fieldIDs := fieldMapping.EnabledFieldIDs()
+	if len(fieldIDs) == 0 {
+		return nil
+	}
+
+	date, found, err := s.incidentDate(ctx, customFieldEntry, fieldMapping)
+	if err != nil {
+		return err
+	}
+
+	editMeta, err := s.jira.EditMeta(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	for _, fieldID := range fieldIDs {
+		meta, editable := editMeta[fieldID]
+		if !editable {
+			return fmt.Errorf("field %s is not editable on %s", fieldID, jiraIssueKey)
+		}
+		if !found {
+			fields[fieldID] = nil
+			continue
+		}
+		fields[fieldID] = formatJiraDate(date, fieldMapping, meta.Schema.Type)
+	}
+	log.Printf("Mapped %s -> %s", fieldMapping.IncidentFieldName, strings.Join(fieldIDs, ", "))
+
+	return s.updateJiraIssueFields(ctx, jiraIssueKey, fields)
+}
+
+// datesMatch compares a planned date, in RFC 3339, with the value of a Jira date or date-time
+// field. Date fields match on the day in the mapping's time zone, date-time fields on the instant.
+func datesMatch(planned, inJira []string) bool {
+	if len(planned) == 0 || len(inJira) == 0 {
+		return len(planned) == len(inJira)
+	}
+	want, err := time.Parse(time.RFC3339, planned[0])
+	if err != nil {
+		return false
+	}
+	if _, err := time.Parse(jiraDateFormat, inJira[0]); err == nil {
+		return want.Format(jiraDateFormat) == inJira[0]
+	}
+	have, err := parseJiraTime(inJira[0])
+	return err == nil && want.Equal(have)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// debugSession turns on verbose logging for the events of one incident until it expires
+type debugSession struct {
+	Incident  string    `json:"incident"`
+	EnabledBy string    `json:"enabled_by,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// incidentDebugger holds the debug sessions started through /admin/debug/incident, on this
+// replica only. Sessions are keyed like the skip list, by incident ID or reference.
+type incidentDebugger struct {
+	mu       sync.Mutex
+	sessions map[string]debugSession
+}
+
+func newIncidentDebugger() *incidentDebugger {
+	return &incidentDebugger{sessions: make(map[string]debugSession)}
+}
+
+func (d *incidentDebugger) enable(session debugSession) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sessions[skipListKey(session.Incident)] = session
+	debugSessionsGauge.set(float64(len(d.sessions)))
+}
+
+func (d *incidentDebugger) disable(incident string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := skipListKey(incident)
+	_, found := d.sessions[key]
+	delete(d.sessions, key)
+	debugSessionsGauge.set(float64(len(d.sessions)))
+	return found
+}
+
+// active reports whether a session covers the incident with either ID or reference, ending
+// expired sessions
+func (d *incidentDebugger) active(incidentID, reference string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.sessions) == 0 {
+		return false
+	}
+
+	now := time.Now()
+	found := false
+	for _, key := range []string{skipListKey(incidentID), skipListKey(reference)} {
+		session, exists := d.sessions[key]
+		if key == "" || !exists {
+			continue
+		}
+		if now.Before(session.ExpiresAt) {
+			found = true
+			continue
+		}
+		log.Printf("Debug logging for incident %s expired", session.Incident)
+		delete(d.sessions, key)
+	}
+	debugSessionsGauge.set(float64(len(d.sessions)))
+	return found
+}
+
+// any reports whether a session may be running, for webhooks to keep their body to log
+func (d *incidentDebugger) any() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.sessions) > 0
+}
+
+func (d *incidentDebugger) list() []debugSession {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	sessions := []debugSession{}
+	for _, session := range d.sessions {
+		if now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Incident < sessions[j].Incident })
+	return sessions
+}
+
+type debugIncidentKey struct{}
+
+// withIncidentDebug marks ctx for verbose logging when a debug session covers the incident
+func (s *IncidentJiraSync) withIncidentDebug(ctx context.Context, incidentID, reference string) context.Context {
+	if !s.debugger.active(incidentID, reference) {
+		return ctx
+	}
+	return context.WithValue(ctx, debugIncidentKey{}, incidentID)
+}
+
+// debugf logs a message when ctx is processing an incident under a debug session. Values
+// from payloads and API responses must be redacted by the caller.
+func debugf(ctx context.Context, format string, args ...interface{}) {
+	incidentID, debugging := ctx.Value(debugIncidentKey{}).(string)
+	if !debugging {
+		return
+	}
+	log.Printf("DEBUG incident=%s "+format, append([]interface{}{incidentID}, args...)...)
+}
+
+// debugJSON returns a value as redacted JSON for debug logging
+func (s *IncidentJiraSync) debugJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("(unencodable: %v)", err)
+	}
+	return s.redactor.redactJSON(data)
+}
+
+// adminDebugIncidentHandler starts verbose logging for one incident with POST
+// /admin/debug/incident/{id}, for ?duration= or DEBUG_INCIDENT_DURATION, and stops it with
+// DELETE. The incident is named by ID or reference.
+func (s *IncidentJiraSync) adminDebugIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	incident := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/admin/debug/incident/"))
+	if incident == "" || strings.Contains(incident, "/") {
+		http.Error(w, "incident ID or reference is required: /admin/debug/incident/{id}", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		duration := s.config.DebugIncidentDuration
+		if value := r.URL.Query().Get("duration"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				http.Error(w, fmt.Sprintf("invalid duration %q", value), http.StatusBadRequest)
+				return
+			}
+			duration = parsed
+		}
+		if duration > s.config.DebugIncidentMaxDuration {
+			http.Error(w, fmt.Sprintf("duration is over DEBUG_INCIDENT_MAX_DURATION (%s)", s.config.DebugIncidentMaxDuration), http.StatusBadRequest)
+			return
+		}
+
+		session := debugSession{Incident: incident, ExpiresAt: time.Now().Add(duration).UTC()}
+		if key, authenticated := s.authenticateAdmin(r); authenticated {
+			session.EnabledBy = key.Name
+		}
+		s.debugger.enable(session)
+		log.Printf("Debug logging for incident %s enabled by %s until %s", incident, session.EnabledBy, session.ExpiresAt.Format(time.RFC3339))
+		json.NewEncoder(w).Encode(session)
+
+	case http.MethodDelete:
+		if !s.debugger.disable(incident) {
+			http.Error(w, "No debug session for the incident", http.StatusNotFound)
+			return
+		}
+		log.Printf("Debug logging for incident %s disabled", incident)
+		json.NewEncoder(w).Encode(map[string]string{"status": "disabled", "incident": incident})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminDebugIncidentsHandler lists the running debug sessions
+func (s *IncidentJiraSync) adminDebugIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessions": s.debugger.list()})
+}
+
+var debugSessionsGauge = newGaugeVec(
+	"incident_jira_webhook_debug_sessions",
+	"Incidents with verbose debug logging enabled on this replica.")
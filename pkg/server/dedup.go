@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Delivery dedup backends selectable with DEDUP_STORE
+const (
+	// dedupState keeps processed deliveries in the state store (STATE_STORE)
+	dedupState     = "state"
+	dedupMemory    = "memory"
+	dedupRedis     = "redis"
+	dedupMemcached = "memcached"
+)
+
+// deliveryStore remembers processed webhook deliveries, so redeliveries are acknowledged
+// without writing to Jira again
+type deliveryStore interface {
+	// DeliveryProcessed reports whether a webhook delivery was processed within the last ttl
+	DeliveryProcessed(ctx context.Context, deliveryID string, ttl time.Duration) (bool, error)
+	// RecordDelivery stores a processed webhook delivery, forgetting those older than ttl
+	RecordDelivery(ctx context.Context, deliveryID string, ttl time.Duration) error
+}
+
+// newDeliveryStore returns the dedup store selected by DEDUP_STORE; the state store by default
+func newDeliveryStore(config Config, store stateStore) (deliveryStore, error) {
+	switch config.DedupStore {
+	case "", dedupState:
+		return store, nil
+	case dedupMemory:
+		return newMemoryStore(), nil
+	case dedupRedis:
+		client, err := newRedisClient(config.DedupStoreURL)
+		if err != nil {
+			return nil, err
+		}
+		return &redisDeliveryStore{client: client, prefix: config.DedupKeyPrefix}, nil
+	case dedupMemcached:
+		client, err := newMemcachedClient(config.DedupStoreURL)
+		if err != nil {
+			return nil, err
+		}
+		return &memcachedDeliveryStore{client: client, prefix: config.DedupKeyPrefix}, nil
+	}
+	return nil, fmt.Errorf("unknown dedup store: %s", config.DedupStore)
+}
+
+// redisDeliveryStore keeps each processed delivery as a Redis key expiring after the TTL
+type redisDeliveryStore struct {
+	client *redisClient
+	prefix string
+}
+
+func (r *redisDeliveryStore) DeliveryProcessed(ctx context.Context, deliveryID string, ttl time.Duration) (bool, error) {
+	reply, err := r.client.do(ctx, "EXISTS", r.prefix+deliveryID)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up delivery %s: %w", deliveryID, err)
+	}
+	return reply == int64(1), nil
+}
+
+func (r *redisDeliveryStore) RecordDelivery(ctx context.Context, deliveryID string, ttl time.Duration) error {
+	if _, err := r.client.do(ctx, "SET", r.prefix+deliveryID, "1", "PX", fmt.Sprintf("%d", ttl.Milliseconds())); err != nil {
+		return fmt.Errorf("failed to record delivery %s: %w", deliveryID, err)
+	}
+	return nil
+}
+
+// memcachedDeliveryStore keeps each processed delivery as a Memcached item expiring after the
+// TTL. Memcached may evict items early under memory pressure, letting a redelivery through.
+type memcachedDeliveryStore struct {
+	client *memcachedClient
+	prefix string
+}
+
+// key returns the item key of a delivery, hashing IDs memcached can't take as they are
+func (m *memcachedDeliveryStore) key(deliveryID string) string {
+	if key := m.prefix + deliveryID; validMemcachedKey(key) {
+		return key
+	}
+	sum := sha256.Sum256([]byte(deliveryID))
+	return m.prefix + hex.EncodeToString(sum[:])
+}
+
+func (m *memcachedDeliveryStore) DeliveryProcessed(ctx context.Context, deliveryID string, ttl time.Duration) (bool, error) {
+	_, found, err := m.client.get(ctx, m.key(deliveryID))
+	if err != nil {
+		return false, fmt.Errorf("failed to look up delivery %s: %w", deliveryID, err)
+	}
+	return found, nil
+}
+
+func (m *memcachedDeliveryStore) RecordDelivery(ctx context.Context, deliveryID string, ttl time.Duration) error {
+	if err := m.client.set(ctx, m.key(deliveryID), "1", ttl); err != nil {
+		return fmt.Errorf("failed to record delivery %s: %w", deliveryID, err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDeliveryStore(t *testing.T) {
+	state := newMemoryStore()
+	tests := []struct {
+		config  Config
+		check   func(deliveryStore) bool
+		wantErr bool
+	}{
+		{config: Config{}, check: func(store deliveryStore) bool { return store == state }},
+		{config: Config{DedupStore: dedupState}, check: func(store deliveryStore) bool { return store == state }},
+		{config: Config{DedupStore: dedupMemory}, check: func(store deliveryStore) bool {
+			memory, ok := store.(*memoryStore)
+			return ok && memory != state
+		}},
+		{config: Config{DedupStore: dedupRedis, DedupStoreURL: "redis://cache:6379", DedupKeyPrefix: "delivery:"}, check: func(store deliveryStore) bool {
+			redis, ok := store.(*redisDeliveryStore)
+			return ok && redis.prefix == "delivery:"
+		}},
+		{config: Config{DedupStore: dedupMemcached, DedupStoreURL: "memcached://cache", DedupKeyPrefix: "delivery:"}, check: func(store deliveryStore) bool {
+			memcached, ok := store.(*memcachedDeliveryStore)
+			return ok && memcached.prefix == "delivery:"
+		}},
+		{config: Config{DedupStore: dedupMemcached, DedupStoreURL: "redis://cache"}, wantErr: true},
+		{config: Config{DedupStore: "etcd"}, wantErr: true},
+	}
+	for _, test := range tests {
+		store, err := newDeliveryStore(test.config, state)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("newDeliveryStore(%q) succeeded, want an error", test.config.DedupStore)
+			}
+			continue
+		}
+		if err != nil || !test.check(store) {
+			t.Errorf("newDeliveryStore(%q) = %T, %v", test.config.DedupStore, store, err)
+		}
+	}
+}
+
+func TestLoadConfigDedupStore(t *testing.T) {
+	setRequiredEnv(t)
+
+	t.Setenv("DEDUP_STORE", dedupMemcached)
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "DEDUP_STORE_URL is required") {
+		t.Errorf("LoadConfig() with memcached and no URL = %v, want an error", err)
+	}
+	t.Setenv("DEDUP_STORE", "etcd")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), `unknown DEDUP_STORE "etcd"`) {
+		t.Errorf("LoadConfig() with an unknown store = %v, want an error", err)
+	}
+
+	// Redis dedup shares the lock server unless given its own
+	t.Setenv("DEDUP_STORE", dedupRedis)
+	t.Setenv("LOCK_REDIS_URL", "redis://locks:6379")
+	config, err := LoadConfig()
+	if err != nil || config.DedupStoreURL != "redis://locks:6379" {
+		t.Errorf("LoadConfig() = %q, %v, want the lock server", config.DedupStoreURL, err)
+	}
+	t.Setenv("DEDUP_STORE_URL", "redis://dedup:6379")
+	if config, err := LoadConfig(); err != nil || config.DedupStoreURL != "redis://dedup:6379" {
+		t.Errorf("LoadConfig() = %q, %v, want DEDUP_STORE_URL", config.DedupStoreURL, err)
+	}
+}
+
+func TestMemoryDeliveryStoreExpires(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	store.deliveries["old"] = time.Now().Add(-2 * time.Hour)
+
+	if err := store.RecordDelivery(ctx, "new", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if processed, _ := store.DeliveryProcessed(ctx, "new", time.Hour); !processed {
+		t.Error("delivery not processed after recording it")
+	}
+	if processed, _ := store.DeliveryProcessed(ctx, "new", 0); processed {
+		t.Error("delivery processed after its TTL")
+	}
+	if _, kept := store.deliveries["old"]; kept {
+		t.Error("delivery older than the TTL kept after recording another")
+	}
+}
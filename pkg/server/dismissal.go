@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// dismissedCategories are the incident status categories of incidents that turned out not to
+// need a response, whose Jira issues DISMISSAL_TRANSITIONS can close
+var dismissedCategories = map[string]bool{
+	"declined": true,
+	"canceled": true,
+	"merged":   true,
+}
+
+func validateDismissalTransitions(transitions map[string]string) error {
+	for category := range transitions {
+		if !dismissedCategories[category] {
+			return fmt.Errorf("unknown status category %s, expected declined, canceled or merged", category)
+		}
+	}
+	return nil
+}
+
+// syncDismissal closes the Jira issue of a declined, canceled or merged incident: it comments
+// why, if DISMISSAL_COMMENT is on, and applies the transition DISMISSAL_TRANSITIONS maps the
+// status category to, so the issue isn't left open. Each dismissal is handled once.
+func (s *IncidentJiraSync) syncDismissal(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	category := strings.ToLower(incident.IncidentStatus.Category)
+	transition, mapped := s.config.DismissalTransitions[category]
+	if !mapped {
+		return nil
+	}
+	if !s.lastWritten.changed(jiraIssueKey, "dismissal", category) {
+		return nil
+	}
+
+	log.Printf("Incident %s was %s, closing %s", incident.ID, category, jiraIssueKey)
+	if s.config.DismissalComment {
+		data := templateData{Incident: incident}
+		if err := s.addTemplatedComment(ctx, jiraIssueKey, templateDismissalComment, data); err != nil {
+			return fmt.Errorf("failed to comment dismissal: %w", err)
+		}
+	}
+
+	if transition != "" {
+		if err := s.transitionJiraIssue(ctx, jiraIssueKey, transition); err != nil {
+			log.Printf("Warning: failed to transition %s to %s: %v", jiraIssueKey, transition, err)
+		}
+	}
+
+	s.lastWritten.record(jiraIssueKey, "dismissal", category)
+	return nil
+}
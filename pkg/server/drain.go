@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// drainPollInterval is how often a drain checks whether the work in flight has finished
+const drainPollInterval = 100 * time.Millisecond
+
+// savedRetry is a queued field sync saved by a drain for the next replica to start. The field
+// mapping is resolved again when it is replayed, against that replica's configuration.
+type savedRetry struct {
+	IncidentID        string                      `json:"incident_id"`
+	IncidentReference string                      `json:"incident_reference,omitempty"`
+	Organization      string                      `json:"organization,omitempty"`
+	JiraIssueKey      string                      `json:"jira_issue_key"`
+	FieldEntry        incidentio.CustomFieldEntry `json:"field_entry"`
+	Attempts          int                         `json:"attempts"`
+	LastError         string                      `json:"last_error,omitempty"`
+}
+
+// scheduledRetries are the field syncs waiting out their backoff before they are queued, so a
+// drain can take them rather than lose them with the process
+type scheduledRetries struct {
+	mu     sync.Mutex
+	nextID int
+	timers map[int]*scheduledRetry
+}
+
+type scheduledRetry struct {
+	timer *time.Timer
+	item  retryItem
+}
+
+// schedule passes item to queue after delay
+func (r *scheduledRetries) schedule(delay time.Duration, item retryItem, queue func(retryItem)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timers == nil {
+		r.timers = make(map[int]*scheduledRetry)
+	}
+
+	id := r.nextID
+	r.nextID++
+	r.timers[id] = &scheduledRetry{
+		item: item,
+		timer: time.AfterFunc(delay, func() {
+			r.mu.Lock()
+			delete(r.timers, id)
+			r.mu.Unlock()
+			queue(item)
+		}),
+	}
+}
+
+// takeAll cancels the waiting field syncs and returns them. Those whose backoff has just ended
+// are left to reach the queue.
+func (r *scheduledRetries) takeAll() []retryItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var items []retryItem
+	for id, scheduled := range r.timers {
+		if scheduled.timer.Stop() {
+			items = append(items, scheduled.item)
+		}
+		delete(r.timers, id)
+	}
+	return items
+}
+
+// drainResult reports a drain to the caller of /admin/drain
+type drainResult struct {
+	Status   string `json:"status"`
+	Saved    int    `json:"saved_retries"`
+	Lost     int    `json:"lost_retries,omitempty"`
+	InFlight int64  `json:"in_flight"`
+	Duration string `json:"duration"`
+}
+
+// refuseWhileDraining wraps a webhook endpoint to count the deliveries in flight and, once a
+// drain has started, answer new ones 503 with a Retry-After, for the sender to redeliver them
+// to another replica
+func (s *IncidentJiraSync) refuseWhileDraining(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Counted before the check, so a drain that sees no deliveries in flight misses none
+		s.inflight.Add(1)
+		defer s.inflight.Add(-1)
+		if s.draining.Load() {
+			drainRefusedTotal.inc()
+			setRetryAfter(w, s.redeliveryDelay(1))
+			http.Error(w, "Draining for shutdown, deliver to another replica", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// drain stops accepting webhooks, waits up to timeout for the deliveries in flight and the
+// retry queue to finish, and saves the field syncs still queued or waiting out their backoff
+// in the state store
+func (s *IncidentJiraSync) drain(ctx context.Context, timeout time.Duration) drainResult {
+	started := time.Now()
+	if !s.draining.Swap(true) {
+		log.Printf("Draining: refusing new webhooks and finishing queued work within %s", timeout)
+	}
+
+	// Syncs waiting out a backoff would outlast any deadline; later failures are saved by
+	// enqueueRetry
+	pending := s.scheduled.takeAll()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	status := "drained"
+wait:
+	for s.inflight.Load() > 0 || len(s.retryQueue) > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			status = "timed_out"
+			break wait
+		case <-ctx.Done():
+			status = "timed_out"
+			break wait
+		}
+	}
+
+take:
+	for {
+		select {
+		case item := <-s.retryQueue:
+			pending = append(pending, item)
+		default:
+			break take
+		}
+	}
+
+	result := drainResult{Status: status, InFlight: s.inflight.Load(), Duration: time.Since(started).Round(time.Millisecond).String()}
+	if err := s.saveRetries(pending); err != nil {
+		result.Lost = len(pending)
+	} else {
+		result.Saved = len(pending)
+	}
+	log.Printf("Drain %s after %s: %d queued field syncs saved, %d lost, %d deliveries or retries still in flight",
+		status, result.Duration, result.Saved, result.Lost, result.InFlight)
+	return result
+}
+
+// saveRetries stores field syncs for the next replica to replay. Syncs that can't be saved are
+// given up, with a failure note on their incidents.
+func (s *IncidentJiraSync) saveRetries(items []retryItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	saved := make([]savedRetry, 0, len(items))
+	for _, item := range items {
+		entry := savedRetry{
+			IncidentID:        item.IncidentID,
+			IncidentReference: item.IncidentReference,
+			Organization:      item.Organization,
+			JiraIssueKey:      item.JiraIssueKey,
+			FieldEntry:        item.FieldEntry,
+			Attempts:          item.Attempts,
+		}
+		if item.LastError != nil {
+			entry.LastError = item.LastError.Error()
+		}
+		saved = append(saved, entry)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	err := s.store.SaveRetries(ctx, saved)
+	if err != nil {
+		log.Printf("Failed to save %d queued field syncs: %v", len(items), err)
+		for _, item := range items {
+			s.notifySyncFailure(item, "it was still queued when the service shut down")
+		}
+		return err
+	}
+	if s.config.StateStore == "" || s.config.StateStore == storeMemory {
+		log.Printf("Warning: %d queued field syncs are kept in memory (STATE_STORE=memory) and are lost when the process exits", len(items))
+	}
+	retriesSavedTotal.add(float64(len(items)))
+	return nil
+}
+
+// replaySavedRetries queues the field syncs saved by drained replicas. Each is retried after
+// the backoff its attempt count calls for.
+func (s *IncidentJiraSync) replaySavedRetries() {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	saved, err := s.store.TakeRetries(ctx)
+	if err != nil {
+		log.Printf("Warning: failed to read field syncs saved by drained replicas: %v", err)
+		return
+	}
+
+	for _, entry := range saved {
+		item := retryItem{
+			IncidentID:        entry.IncidentID,
+			IncidentReference: entry.IncidentReference,
+			Organization:      entry.Organization,
+			JiraIssueKey:      entry.JiraIssueKey,
+			FieldEntry:        entry.FieldEntry,
+			Attempts:          entry.Attempts,
+		}
+		if entry.LastError != "" {
+			item.LastError = errors.New(entry.LastError)
+		}
+		fieldMapping, found := s.resolveFieldMapping(entry.FieldEntry.CustomField.Name)
+		if !found {
+			log.Printf("Dropping saved sync of %s for %s, the field is no longer mapped", entry.FieldEntry.CustomField.Name, entry.JiraIssueKey)
+			continue
+		}
+		item.FieldMapping = fieldMapping
+		retriesReplayedTotal.inc()
+		s.enqueueRetry(item)
+	}
+	if len(saved) > 0 {
+		log.Printf("Replaying %d field syncs saved by drained replicas", len(saved))
+	}
+}
+
+// adminDrainHandler drains the service before shutdown, answering once the queued work is
+// finished or saved. Call it from a Kubernetes preStop hook so rolling deploys lose no events.
+func (s *IncidentJiraSync) adminDrainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	timeout := s.config.DrainTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, fmt.Sprintf("invalid timeout %q", value), http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+
+	json.NewEncoder(w).Encode(s.drain(r.Context(), timeout))
+}
+
+var (
+	drainRefusedTotal = newCounterVec(
+		"incident_jira_webhook_drain_refused_total",
+		"Webhook deliveries refused with 503 while draining.")
+	retriesSavedTotal = newCounterVec(
+		"incident_jira_webhook_retries_saved_total",
+		"Queued field syncs saved to the state store by a drain.")
+	retriesReplayedTotal = newCounterVec(
+		"incident_jira_webhook_retries_replayed_total",
+		"Field syncs saved by drained replicas and queued again at startup.")
+)
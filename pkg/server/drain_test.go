@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+func TestRefuseWhileDraining(t *testing.T) {
+	s := newRetryTestSync(1)
+	handled := 0
+	handler := s.refuseWhileDraining(func(w http.ResponseWriter, r *http.Request) {
+		if s.inflight.Load() != 1 {
+			t.Errorf("%d deliveries in flight while handling one", s.inflight.Load())
+		}
+		handled++
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/webhook", nil))
+	if w.Code != http.StatusOK || handled != 1 {
+		t.Errorf("status = %d, handled %d times before draining", w.Code, handled)
+	}
+
+	s.draining.Store(true)
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/webhook", nil))
+	if w.Code != http.StatusServiceUnavailable || handled != 1 {
+		t.Errorf("status = %d, handled %d times while draining, want 503", w.Code, handled)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Retry-After = %q, want 2", retryAfter)
+	}
+	if inflight := s.inflight.Load(); inflight != 0 {
+		t.Errorf("%d deliveries in flight after both finished", inflight)
+	}
+}
+
+func TestDrainSavesQueuedRetries(t *testing.T) {
+	s := newRetryTestSync(1)
+	s.config.RetryBaseDelay = time.Hour
+	// One waiting out its backoff and one queued with no worker to take it
+	s.enqueueRetry(testRetryItem(1))
+	s.retryQueue <- testRetryItem(2)
+
+	result := s.drain(context.Background(), 50*time.Millisecond)
+	if result.Status != "timed_out" || result.Saved != 2 || result.Lost != 0 {
+		t.Errorf("drain() = %+v, want timed out with 2 saved", result)
+	}
+	if !s.draining.Load() {
+		t.Error("not draining after drain()")
+	}
+	if len(s.retryQueue) != 0 {
+		t.Errorf("%d retries left in the queue", len(s.retryQueue))
+	}
+
+	saved, err := s.store.TakeRetries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := map[int]bool{}
+	for _, entry := range saved {
+		attempts[entry.Attempts] = entry.JiraIssueKey == "SUP-1" && entry.FieldEntry.CustomField.Name == "Impacted components"
+	}
+	if len(saved) != 2 || !attempts[1] || !attempts[2] {
+		t.Errorf("saved %+v, want both retries", saved)
+	}
+}
+
+func TestDrainWaitsForDeliveries(t *testing.T) {
+	s := newRetryTestSync(1)
+	s.inflight.Add(1)
+	go func() {
+		time.Sleep(2 * drainPollInterval)
+		s.inflight.Add(-1)
+	}()
+
+	result := s.drain(context.Background(), 5*time.Second)
+	if result.Status != "drained" || result.InFlight != 0 || result.Saved != 0 {
+		t.Errorf("drain() = %+v, want drained with nothing saved", result)
+	}
+}
+
+func TestReplaySavedRetries(t *testing.T) {
+	s := newRetryTestSync(1)
+	s.config.RetryBaseDelay, s.config.RetryMaxDelay = time.Hour, time.Hour
+	s.config.ImpactedComponentFieldName = "Impacted components"
+	s.config.ImpactedComponentJiraFieldID = "customfield_1"
+
+	saved := []savedRetry{
+		{IncidentID: "inc_1", JiraIssueKey: "SUP-1", FieldEntry: testRetryItem(1).FieldEntry, Attempts: 1, LastError: "timeout"},
+		{IncidentID: "inc_2", JiraIssueKey: "SUP-2", FieldEntry: incidentio.CustomFieldEntry{CustomField: incidentio.CustomField{Name: "Unmapped"}}, Attempts: 1},
+	}
+	if err := s.store.SaveRetries(context.Background(), saved); err != nil {
+		t.Fatal(err)
+	}
+
+	s.replaySavedRetries()
+	// The field no longer mapped is dropped, the other waits out its backoff
+	items := s.scheduled.takeAll()
+	if len(items) != 1 {
+		t.Fatalf("%d field syncs replayed, want 1", len(items))
+	}
+	item := items[0]
+	if item.JiraIssueKey != "SUP-1" || item.FieldMapping.JiraFieldID != "customfield_1" || item.LastError == nil || item.LastError.Error() != "timeout" {
+		t.Errorf("replayed %+v, want SUP-1 mapped to customfield_1 after a timeout", item)
+	}
+	if left, _ := s.store.TakeRetries(context.Background()); len(left) != 0 {
+		t.Errorf("%d saved field syncs left after replaying", len(left))
+	}
+}
+
+func TestAdminDrainHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{name: "drains", method: http.MethodPost, target: "/admin/drain?timeout=1s", wantStatus: http.StatusOK},
+		{name: "default timeout", method: http.MethodPost, target: "/admin/drain", wantStatus: http.StatusOK},
+		{name: "GET", method: http.MethodGet, target: "/admin/drain", wantStatus: http.StatusMethodNotAllowed},
+		{name: "invalid timeout", method: http.MethodPost, target: "/admin/drain?timeout=soon", wantStatus: http.StatusBadRequest},
+		{name: "negative timeout", method: http.MethodPost, target: "/admin/drain?timeout=-1s", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newRetryTestSync(1)
+			s.config.DrainTimeout = time.Second
+			w := httptest.NewRecorder()
+			s.adminDrainHandler(w, httptest.NewRequest(test.method, test.target, nil))
+			if w.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, test.wantStatus)
+			}
+			if test.wantStatus != http.StatusOK {
+				if s.draining.Load() {
+					t.Error("draining after a rejected request")
+				}
+				return
+			}
+
+			var result drainResult
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != "drained" || !s.draining.Load() {
+				t.Errorf("result = %+v, draining %v, want drained", result, s.draining.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// driftReportLimit is the default number of incidents a drift report checks
+const driftReportLimit = 50
+
+// fieldDrift is a Jira field whose value differs from what its mapping would write
+type fieldDrift struct {
+	Field          string   `json:"field"`
+	Type           string   `json:"type"`
+	JiraFieldID    string   `json:"jira_field_id"`
+	IncidentValues []string `json:"incident_values"`
+	JiraValues     []string `json:"jira_values"`
+}
+
+// incidentDrift is an incident whose Jira issue diverges from it
+type incidentDrift struct {
+	IncidentID string       `json:"incident_id"`
+	Reference  string       `json:"reference,omitempty"`
+	IssueKey   string       `json:"issue_key"`
+	Fields     []fieldDrift `json:"fields"`
+}
+
+type driftError struct {
+	IncidentID string `json:"incident_id"`
+	Error      string `json:"error"`
+}
+
+// driftReport lists the incidents whose Jira issues diverge from incident.io
+type driftReport struct {
+	Since    *time.Time      `json:"since,omitempty"`
+	Checked  int             `json:"checked"`
+	Drifted  []incidentDrift `json:"drifted"`
+	Errors   []driftError    `json:"errors,omitempty"`
+	Complete bool            `json:"complete"`
+}
+
+// jiraValueTexts flattens a Jira field value to comparable texts: Assets object IDs, option
+// values, names (e.g. sprints), issue keys (parents), text and numbers
+func jiraValueTexts(value interface{}) []string {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case string:
+		if value = strings.TrimSpace(value); value != "" {
+			return []string{value}
+		}
+		return nil
+	case float64:
+		return []string{strconv.FormatFloat(value, 'f', -1, 64)}
+	case []interface{}:
+		var texts []string
+		for _, item := range value {
+			texts = append(texts, jiraValueTexts(item)...)
+		}
+		return texts
+	case map[string]interface{}:
+		if value["type"] == "doc" {
+			return jiraValueTexts(documentText(value))
+		}
+		for _, key := range []string{"objectId", "value", "name", "originalEstimate", "key"} {
+			if text, isString := value[key].(string); isString {
+				return jiraValueTexts(text)
+			}
+		}
+	}
+	return nil
+}
+
+// documentText returns the text of an Atlassian Document Format node, paragraphs separated by
+// blank lines as PlainTextDocument writes them
+func documentText(node map[string]interface{}) string {
+	if text, isString := node["text"].(string); isString {
+		return text
+	}
+	if node["type"] == "hardBreak" {
+		return "\n"
+	}
+	content, _ := node["content"].([]interface{})
+	parts := make([]string, 0, len(content))
+	for _, child := range content {
+		if child, isMap := child.(map[string]interface{}); isMap {
+			parts = append(parts, documentText(child))
+		}
+	}
+	if node["type"] == "doc" {
+		return strings.Join(parts, "\n\n")
+	}
+	return strings.Join(parts, "")
+}
+
+// valuesMatch compares planned and Jira values case-insensitively, ignoring order. A sprint
+// field keeps the issue's past sprints, so it matches when it includes the planned sprint, an
+// estimate or epic is left alone when the incident has none, and dates are compared as dates.
+func valuesMatch(mappingType string, planned, inJira []string) bool {
+	if (mappingType == mapping.TypeTimeTracking || mappingType == mapping.TypeParent) && len(planned) == 0 {
+		return true
+	}
+	if mappingType == mapping.TypeDate {
+		return datesMatch(planned, inJira)
+	}
+	have := make(map[string]bool, len(inJira))
+	for _, value := range inJira {
+		have[strings.ToLower(value)] = true
+	}
+	for _, value := range planned {
+		if !have[strings.ToLower(value)] {
+			return false
+		}
+	}
+	if mappingType == mapping.TypeSprint {
+		return true
+	}
+
+	want := make(map[string]bool, len(planned))
+	for _, value := range planned {
+		want[strings.ToLower(value)] = true
+	}
+	return len(want) == len(have)
+}
+
+// incidentDrift compares the mapped fields of an incident with its Jira issue. Nothing is
+// written: values are worked out as in the shadow comparison.
+func (s *IncidentJiraSync) incidentDrift(ctx context.Context, incident incidentio.Incident) ([]fieldDrift, error) {
+	jiraIssueKey := incident.ExternalIssueReference.IssueName
+	ctx = withFlagSubject(ctx, incident)
+
+	type plannedField struct {
+		entry   incidentio.CustomFieldEntry
+		mapping mapping.FieldMapping
+	}
+	var planned []plannedField
+	var fieldIDs []string
+	for _, entry := range incident.CustomFieldEntries {
+		fieldMapping, found := s.resolveFieldMapping(entry.CustomField.Name)
+		if !found || len(fieldMapping.EnabledFieldIDs()) == 0 {
+			continue
+		}
+		planned = append(planned, plannedField{entry: entry, mapping: fieldMapping})
+		fieldIDs = append(fieldIDs, fieldMapping.EnabledFieldIDs()...)
+	}
+	if len(planned) == 0 {
+		return nil, nil
+	}
+
+	var issue struct {
+		Fields map[string]interface{} `json:"fields"`
+	}
+	path := fmt.Sprintf("%s?fields=%s", jira.IssuePath(jiraIssueKey), strings.Join(fieldIDs, ","))
+	if err := s.jira.GetFresh(ctx, path, &issue); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", jiraIssueKey, err)
+	}
+
+	var drifts []fieldDrift
+	for _, field := range planned {
+		plan := s.planField(ctx, field.entry, field.mapping)
+		if plan.Error != "" {
+			return nil, fmt.Errorf("failed to work out %s: %s", field.entry.CustomField.Name, plan.Error)
+		}
+		for _, fieldID := range plan.JiraFieldIDs {
+			inJira := jiraValueTexts(issue.Fields[fieldID])
+			if valuesMatch(plan.Type, plan.Values, inJira) {
+				continue
+			}
+			if inJira == nil {
+				inJira = []string{}
+			}
+			drifts = append(drifts, fieldDrift{
+				Field:          field.entry.CustomField.Name,
+				Type:           plan.Type,
+				JiraFieldID:    fieldID,
+				IncidentValues: plan.Values,
+				JiraValues:     inJira,
+			})
+		}
+	}
+	return drifts, nil
+}
+
+// adminDriftHandler lists the incidents whose Jira fields diverge from incident.io: the given
+// incidents (?incident=, repeatable) or those updated within ?since (RECONCILE_LOOKBACK by
+// default), up to ?limit, of the ?organization named or that of INCIDENT_API_TOKEN
+func (s *IncidentJiraSync) adminDriftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := driftReportLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	lookback := s.config.ReconcileLookback
+	if value := query.Get("since"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "since must be a positive duration, e.g. 2h", http.StatusBadRequest)
+			return
+		}
+		lookback = parsed
+	}
+
+	organization, err := s.requestOrganization(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := withOrganization(r.Context(), organization.name)
+	report := driftReport{Drifted: []incidentDrift{}, Complete: true}
+
+	var incidents []incidentio.Incident
+	if ids := query["incident"]; len(ids) > 0 {
+		for _, id := range ids {
+			incident, err := organization.client.GetIncident(ctx, id)
+			if err != nil {
+				report.Errors = append(report.Errors, driftError{IncidentID: id, Error: err.Error()})
+				continue
+			}
+			incidents = append(incidents, *incident)
+		}
+	} else {
+		since := time.Now().UTC().Add(-lookback)
+		report.Since = &since
+		listed, err := organization.client.ListIncidentsUpdatedSince(ctx, since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		incidents = listed
+	}
+	sort.SliceStable(incidents, func(i, j int) bool { return incidents[i].ID < incidents[j].ID })
+
+	for _, incident := range incidents {
+		if incident.ExternalIssueReference.IssueName == "" {
+			continue
+		}
+		if report.Checked == limit || s.jiraBudget.wait(ctx) != nil {
+			report.Complete = false
+			break
+		}
+
+		report.Checked++
+		fields, err := s.incidentDrift(ctx, incident)
+		if err != nil {
+			report.Errors = append(report.Errors, driftError{IncidentID: incident.ID, Error: err.Error()})
+			continue
+		}
+		if len(fields) > 0 {
+			driftedIncidentsTotal.inc()
+			report.Drifted = append(report.Drifted, incidentDrift{
+				IncidentID: incident.ID,
+				Reference:  incident.Reference,
+				IssueKey:   incident.ExternalIssueReference.IssueName,
+				Fields:     fields,
+			})
+		}
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+var driftedIncidentsTotal = newCounterVec(
+	"incident_jira_webhook_drift_report_incidents_total",
+	"Incidents found by drift reports with Jira fields diverging from incident.io.")
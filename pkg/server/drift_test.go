@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+func TestJiraValueTexts(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{name: "empty", value: `null`},
+		{name: "text", value: `" Payments "`, want: []string{"Payments"}},
+		{name: "blank text", value: `"  "`},
+		{name: "number", value: `2.5`, want: []string{"2.5"}},
+		{name: "Assets objects", value: `[{"id": "ws:1", "objectId": "1"}, {"id": "ws:2", "objectId": "2"}]`, want: []string{"1", "2"}},
+		{name: "option", value: `{"id": "10001", "value": "High"}`, want: []string{"High"}},
+		{name: "sprints", value: `[{"id": 1, "name": "Sprint 1"}, {"id": 2, "name": "Sprint 2"}]`, want: []string{"Sprint 1", "Sprint 2"}},
+		{name: "time tracking", value: `{"originalEstimate": "2h"}`, want: []string{"2h"}},
+		{name: "parent", value: `{"id": "10000", "key": "OPS-1"}`, want: []string{"OPS-1"}},
+		{name: "document", value: `{"type": "doc", "content": [
+			{"type": "paragraph", "content": [{"type": "text", "text": "First"}, {"type": "hardBreak"}, {"type": "text", "text": "line"}]},
+			{"type": "paragraph", "content": [{"type": "text", "text": "Second"}]}
+		]}`, want: []string{"First\nline\n\nSecond"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(test.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			if got := jiraValueTexts(value); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("jiraValueTexts(%s) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestValuesMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		mappingType string
+		planned     []string
+		inJira      []string
+		want        bool
+	}{
+		{name: "same objects in another order", mappingType: mapping.TypeAssets, planned: []string{"1", "2"}, inJira: []string{"2", "1"}, want: true},
+		{name: "object missing", mappingType: mapping.TypeAssets, planned: []string{"1", "2"}, inJira: []string{"1"}},
+		{name: "extra object", mappingType: mapping.TypeAssets, planned: []string{"1"}, inJira: []string{"1", "2"}},
+		{name: "option case", mappingType: mapping.TypeSelect, planned: []string{"high"}, inJira: []string{"High"}, want: true},
+		{name: "cleared", mappingType: mapping.TypeSelect, want: true},
+		{name: "past sprints kept", mappingType: mapping.TypeSprint, planned: []string{"Sprint 2"}, inJira: []string{"Sprint 1", "Sprint 2"}, want: true},
+		{name: "sprint missing", mappingType: mapping.TypeSprint, planned: []string{"Sprint 3"}, inJira: []string{"Sprint 1", "Sprint 2"}},
+		{name: "no estimate", mappingType: mapping.TypeTimeTracking, inJira: []string{"2h"}, want: true},
+		{name: "no epic", mappingType: mapping.TypeParent, inJira: []string{"OPS-1"}, want: true},
+		{name: "date", mappingType: mapping.TypeDate, planned: []string{"2024-03-01T10:00:00Z"}, inJira: []string{"2024-03-01"}, want: true},
+		{name: "other date", mappingType: mapping.TypeDate, planned: []string{"2024-03-01T10:00:00Z"}, inJira: []string{"2024-03-02"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := valuesMatch(test.mappingType, test.planned, test.inJira); got != test.want {
+				t.Errorf("valuesMatch(%s, %q, %q) = %v, want %v", test.mappingType, test.planned, test.inJira, got, test.want)
+			}
+		})
+	}
+}
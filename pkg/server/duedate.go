@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// jiraDueDateField is the Jira system field holding an issue's due date
+const jiraDueDateField = "duedate"
+
+// jiraDateFormat is the format of Jira date fields
+const jiraDateFormat = "2006-01-02"
+
+// dueDateEnabled reports whether due dates are managed at all
+func (s *IncidentJiraSync) dueDateEnabled() bool {
+	return s.config.DueDateTimestamp != "" || s.config.DueDateSLAOffset > 0
+}
+
+// incidentResolved reports whether the incident has its resolved timestamp or is closed
+func (s *IncidentJiraSync) incidentResolved(incident incidentio.Incident) bool {
+	if _, resolved := incident.Timestamp(s.config.SLAResolvedTimestamp); resolved {
+		return true
+	}
+	return incident.IncidentStatus.Category == "closed"
+}
+
+// dueDate returns the date the incident is due: the DUE_DATE_TIMESTAMP incident timestamp
+// (e.g. a follow-up deadline) if set, otherwise DUE_DATE_SLA_OFFSET after the incident was
+// reported
+func (s *IncidentJiraSync) dueDate(incident incidentio.Incident) (time.Time, bool) {
+	if s.config.DueDateTimestamp != "" {
+		if deadline, found := incident.Timestamp(s.config.DueDateTimestamp); found {
+			return deadline, true
+		}
+	}
+	if s.config.DueDateSLAOffset <= 0 {
+		return time.Time{}, false
+	}
+
+	reported, found := incident.Timestamp(s.config.SLAReportedTimestamp)
+	if !found {
+		reported = incident.CreatedAt
+	}
+	if reported.IsZero() {
+		return time.Time{}, false
+	}
+	return reported.Add(s.config.DueDateSLAOffset), true
+}
+
+// syncDueDate keeps the Jira due date on the incident's deadline while it is open, moving it
+// when the deadline or reported time changes, and clears it once the incident is resolved
+func (s *IncidentJiraSync) syncDueDate(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if !s.dueDateEnabled() {
+		return nil
+	}
+
+	// An empty value clears the due date
+	value := ""
+	if !s.incidentResolved(incident) {
+		due, ok := s.dueDate(incident)
+		if !ok {
+			log.Printf("No deadline or reported time on incident %s, skipping due date", incident.ID)
+			return nil
+		}
+		value = due.In(s.config.DueDateLocation).Format(jiraDateFormat)
+	}
+
+	if !s.lastWritten.changed(jiraIssueKey, "due_date", value) {
+		return nil
+	}
+
+	var fieldValue interface{}
+	if value != "" {
+		fieldValue = value
+		log.Printf("Setting due date of %s to %s", jiraIssueKey, value)
+	} else {
+		log.Printf("Incident %s is resolved, clearing due date of %s", incident.ID, jiraIssueKey)
+	}
+	if err := s.updateJiraIssueFields(ctx, jiraIssueKey, map[string]interface{}{jiraDueDateField: fieldValue}); err != nil {
+		return err
+	}
+
+	s.lastWritten.record(jiraIssueKey, "due_date", value)
+	return nil
+}
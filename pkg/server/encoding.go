@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxInboundBodyBytes bounds inbound payloads after decompression, so a small compressed body
+// cannot expand without limit
+const maxInboundBodyBytes = 10 << 20
+
+// utf8BOM is the byte order mark some senders prefix JSON with
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// errUnsupportedMediaType marks bodies rejected with 415 rather than 400
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// checkContentType accepts JSON content types (application/json and +json types) in UTF-8.
+// A missing Content-Type is accepted, as some senders omit it.
+func checkContentType(header string) error {
+	if header == "" {
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return fmt.Errorf("%w: invalid Content-Type %q", errUnsupportedMediaType, header)
+	}
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return fmt.Errorf("%w: %s", errUnsupportedMediaType, mediaType)
+	}
+	if charset, set := params["charset"]; set && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "us-ascii") {
+		return fmt.Errorf("%w: charset %s", errUnsupportedMediaType, charset)
+	}
+	return nil
+}
+
+// bodyReadError is a failure reading an inbound body, e.g. corrupt gzip data or a body over
+// the size limit, as opposed to a body that isn't valid JSON
+type bodyReadError struct {
+	err error
+}
+
+func (e *bodyReadError) Error() string { return e.err.Error() }
+
+func (e *bodyReadError) Unwrap() error { return e.err }
+
+// inboundBody is a request body inflated, limited and stripped of its byte order mark as it is
+// read, so the body is never buffered unless a handler needs it whole
+type inboundBody struct {
+	reader  io.Reader
+	closers []io.Closer
+}
+
+func (b *inboundBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = &bodyReadError{err: err}
+	}
+	return n, err
+}
+
+func (b *inboundBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if closeErr := b.closers[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// bufferedBody is a request body already read whole, e.g. to verify its signature, so the
+// handler can use it without reading or copying it again
+type bufferedBody struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newBufferedBody(data []byte) *bufferedBody {
+	return &bufferedBody{Reader: bytes.NewReader(data), data: data}
+}
+
+func (b *bufferedBody) Close() error { return nil }
+
+// openInboundBody wraps a request body to decompress it according to Content-Encoding, fail
+// past maxInboundBodyBytes and skip a leading UTF-8 byte order mark
+func openInboundBody(w http.ResponseWriter, r *http.Request) (*inboundBody, error) {
+	body := &inboundBody{closers: []io.Closer{r.Body}}
+
+	// Encodings are listed in the order they were applied, so they are undone in reverse
+	var reader io.Reader = r.Body
+	encodings := strings.Split(r.Header.Get("Content-Encoding"), ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch encoding := encodings[i]; strings.ToLower(strings.TrimSpace(encoding)) {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				body.Close()
+				return nil, fmt.Errorf("invalid gzip body: %w", err)
+			}
+			body.closers = append(body.closers, gz)
+			reader = gz
+		default:
+			body.Close()
+			return nil, fmt.Errorf("%w: Content-Encoding %s", errUnsupportedMediaType, encoding)
+		}
+	}
+
+	buffered := bufio.NewReader(http.MaxBytesReader(w, io.NopCloser(reader), maxInboundBodyBytes))
+	if prefix, err := buffered.Peek(len(utf8BOM)); err == nil && bytes.Equal(prefix, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+	}
+	body.reader = buffered
+	return body, nil
+}
+
+// normalizeBody wraps an inbound endpoint so its handler, and its authentication chain, see a
+// plain UTF-8 JSON body: compressed bodies are inflated as they are read, a byte order mark is
+// dropped and content types other than JSON are rejected with 415
+func normalizeBody(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Body == nil {
+			next(w, r)
+			return
+		}
+
+		if err := checkContentType(r.Header.Get("Content-Type")); err != nil {
+			rejectBody(w, r, endpoint, err)
+			return
+		}
+
+		body, err := openInboundBody(w, r)
+		if err != nil {
+			rejectBody(w, r, endpoint, err)
+			return
+		}
+
+		// The length is only known once the body has been read
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		r.Body = body
+		next(w, r)
+	}
+}
+
+func rejectBody(w http.ResponseWriter, r *http.Request, endpoint string, err error) {
+	log.Printf("Rejected %s request body from %s: %v", endpoint, r.RemoteAddr, err)
+	if errors.Is(err, errUnsupportedMediaType) {
+		inboundBodiesRejectedTotal.inc(endpoint, "unsupported_media_type")
+		http.Error(w, "Unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+	inboundBodiesRejectedTotal.inc(endpoint, "invalid_body")
+	http.Error(w, "Failed to read body", http.StatusBadRequest)
+}
+
+var inboundBodiesRejectedTotal = newCounterVec(
+	"incident_jira_webhook_inbound_bodies_rejected_total",
+	"Inbound requests rejected before authentication because of their body or content type, by endpoint.",
+	"endpoint", "reason")
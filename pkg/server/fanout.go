@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// jiraIssueKeyPattern finds Jira issue keys such as OPS-123 in text and issue URLs
+var jiraIssueKeyPattern = regexp.MustCompile(`\b[A-Z][A-Z0-9_]+-[1-9][0-9]*\b`)
+
+// IssueOutcome is the result of syncing an incident to one of its related Jira issues
+type IssueOutcome struct {
+	IssueKey        string   `json:"issue_key"`
+	CompletedFields []string `json:"completed_fields,omitempty"`
+	QueuedFields    []string `json:"queued_fields,omitempty"`
+	FailedFields    []string `json:"failed_fields,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// incomplete describes what was left for the retry queue or rejected by Jira, or is empty when
+// every field of every issue was synced
+func (r ProcessingResult) incomplete() string {
+	var parts []string
+	if len(r.QueuedFields) > 0 {
+		parts = append(parts, fmt.Sprintf("queued for retry: %s", strings.Join(r.QueuedFields, ", ")))
+	}
+	if len(r.FailedFields) > 0 {
+		parts = append(parts, fmt.Sprintf("rejected by Jira: %s", strings.Join(r.FailedFields, ", ")))
+	}
+	for _, outcome := range r.RelatedIssues {
+		switch {
+		case outcome.Error != "":
+			parts = append(parts, fmt.Sprintf("%s failed: %s", outcome.IssueKey, outcome.Error))
+		case len(outcome.QueuedFields) > 0:
+			parts = append(parts, fmt.Sprintf("%s queued for retry: %s", outcome.IssueKey, strings.Join(outcome.QueuedFields, ", ")))
+		}
+		if len(outcome.FailedFields) > 0 {
+			parts = append(parts, fmt.Sprintf("%s rejected by Jira: %s", outcome.IssueKey, strings.Join(outcome.FailedFields, ", ")))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// relatedIssueKeys returns the Jira issues linked to the incident besides its own issue: keys
+// found in the RELATED_ISSUES_FIELD custom field and Jira issues attached to the incident.
+// Discovery failures are logged, so the incident's own issue is still synced.
+func (s *IncidentJiraSync) relatedIssueKeys(ctx context.Context, incident incidentio.Incident, primaryKey string) []string {
+	if s.config.RelatedIssuesFieldName == "" && !s.config.RelatedIssuesFromAttachments {
+		return nil
+	}
+
+	seen := map[string]bool{primaryKey: true}
+	var keys []string
+	addKeys := func(text string) {
+		for _, key := range jiraIssueKeyPattern.FindAllString(text, -1) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if fieldName := s.config.RelatedIssuesFieldName; fieldName != "" {
+		entry, found := findFieldEntry(incident, fieldName)
+		if !found {
+			// Events about one field only carry that field
+			if fullIncident, err := s.incidentClient(ctx).GetIncident(ctx, incident.ID); err != nil {
+				log.Printf("Warning: failed to fetch incident %s for related issues: %v", incident.ID, err)
+			} else {
+				entry, _ = findFieldEntry(*fullIncident, fieldName)
+			}
+		}
+		for _, value := range entry.Values {
+			addKeys(value.Text())
+		}
+	}
+
+	if s.config.RelatedIssuesFromAttachments {
+		attachments, err := s.incidentClient(ctx).ListIncidentAttachments(ctx, incident.ID)
+		if err != nil {
+			log.Printf("Warning: failed to list attachments of incident %s: %v", incident.ID, err)
+		}
+		for _, attachment := range attachments {
+			if attachment.Resource.ResourceType == "jira_issue" {
+				addKeys(attachment.Resource.ExternalID + " " + attachment.Resource.Permalink)
+			}
+		}
+	}
+
+	if len(keys) > s.config.RelatedIssuesMax {
+		log.Printf("Warning: incident %s has %d related issues, syncing the first %d", incident.ID, len(keys), s.config.RelatedIssuesMax)
+		keys = keys[:s.config.RelatedIssuesMax]
+	}
+	return keys
+}
+
+func findFieldEntry(incident incidentio.Incident, fieldName string) (incidentio.CustomFieldEntry, bool) {
+	for _, entry := range incident.CustomFieldEntries {
+		if strings.EqualFold(entry.CustomField.Name, fieldName) {
+			return entry, true
+		}
+	}
+	return incidentio.CustomFieldEntry{}, false
+}
+
+// syncRelatedIssue syncs the incident to one of its related issues. An issue synced for the
+// first time gets every mapped field. If the sync fails, the issue's fields are queued for retry.
+func (s *IncidentJiraSync) syncRelatedIssue(ctx context.Context, incidentData incidentio.WebhookPayload, incident incidentio.Incident, jiraIssueKey string) IssueOutcome {
+	outcome := IssueOutcome{IssueKey: jiraIssueKey}
+
+	if ctx.Err() != nil {
+		outcome.QueuedFields = s.queueRemainingFields(ctx, incident, jiraIssueKey, incident.CustomFieldEntries)
+		relatedIssueSyncsTotal.inc("queued")
+		return outcome
+	}
+
+	newlyLinked := s.lastWritten.changed(jiraIssueKey, "related_incident", incident.ID)
+	result, err := s.syncIssue(ctx, incidentData, incident, jiraIssueKey, newlyLinked)
+	outcome.CompletedFields = result.CompletedFields
+	outcome.QueuedFields = result.QueuedFields
+	outcome.FailedFields = result.FailedFields
+	if errors.Is(err, errIssueRestricted) {
+		outcome.Error = err.Error()
+		relatedIssueSyncsTotal.inc("restricted")
+		return outcome
+	}
+	if err != nil {
+		log.Printf("Failed to sync related issue %s of incident %s: %v", jiraIssueKey, incident.ID, err)
+		outcome.Error = err.Error()
+		outcome.QueuedFields = s.queueRemainingFields(ctx, incident, jiraIssueKey, incident.CustomFieldEntries)
+		relatedIssueSyncsTotal.inc("failed")
+		return outcome
+	}
+
+	s.lastWritten.record(jiraIssueKey, "related_incident", incident.ID)
+	if len(outcome.QueuedFields) > 0 {
+		relatedIssueSyncsTotal.inc("queued")
+	} else {
+		relatedIssueSyncsTotal.inc("success")
+	}
+	return outcome
+}
+
+var relatedIssueSyncsTotal = newCounterVec(
+	"incident_jira_webhook_related_issue_syncs_total",
+	"Syncs of incidents to their related Jira issues, by outcome (success, queued, failed or restricted).",
+	"outcome")
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// Feature flags gating sync behaviors that are rolled out gradually
+const (
+	flagComments      = "comments"
+	flagTransitions   = "transitions"
+	flagPostmortem    = "postmortem"
+	flagEpicRollup    = "epic_rollup"
+	flagBidirectional = "bidirectional"
+)
+
+var knownFlags = map[string]bool{
+	flagComments:      true,
+	flagTransitions:   true,
+	flagPostmortem:    true,
+	flagEpicRollup:    true,
+	flagBidirectional: true,
+}
+
+// FeatureFlag limits a behavior to a share of incidents and/or to some incident types.
+// Flags that aren't configured are on, so each behavior is governed by its own setting alone.
+type FeatureFlag struct {
+	Enabled bool `json:"enabled"`
+	// Percentage of incidents (0-100) the behavior applies to, chosen stably by incident ID
+	Percentage *int `json:"percentage,omitempty"`
+	// IncidentTypes restricts the behavior to incidents of these types (case-insensitive)
+	IncidentTypes []string `json:"incident_types,omitempty"`
+}
+
+// FeatureFlagsFile is the format of FEATURE_FLAGS_FILE
+type FeatureFlagsFile struct {
+	Flags map[string]FeatureFlag `json:"flags"`
+}
+
+// loadFeatureFlags reads flags from an optional JSON file, then applies FEATURE_FLAGS overrides
+// of the form "comments=off,transitions=25%,epic_rollup=on"
+func loadFeatureFlags(path, overrides string) (map[string]FeatureFlag, error) {
+	flags := make(map[string]FeatureFlag)
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var flagsFile FeatureFlagsFile
+		if err := json.Unmarshal(data, &flagsFile); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for name, flag := range flagsFile.Flags {
+			flags[name] = flag
+		}
+	}
+
+	for name, value := range parseKeyValueList(overrides) {
+		flag := flags[name]
+		switch {
+		case value == "on" || value == "true":
+			flag.Enabled, flag.Percentage = true, nil
+		case value == "off" || value == "false":
+			flag.Enabled = false
+		case strings.HasSuffix(value, "%"):
+			percentage, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid percentage for flag %s: %s", name, value)
+			}
+			flag.Enabled, flag.Percentage = true, &percentage
+		default:
+			return nil, fmt.Errorf("invalid value for flag %s: %s", name, value)
+		}
+		flags[name] = flag
+	}
+
+	for name, flag := range flags {
+		if !knownFlags[name] {
+			log.Printf("Warning: unknown feature flag %s", name)
+		}
+		if flag.Percentage != nil && (*flag.Percentage < 0 || *flag.Percentage > 100) {
+			return nil, fmt.Errorf("percentage for flag %s must be between 0 and 100", name)
+		}
+	}
+
+	return flags, nil
+}
+
+type flagSubjectKey struct{}
+
+// withFlagSubject attaches the incident being processed, which flags are evaluated against
+func withFlagSubject(ctx context.Context, incident incidentio.Incident) context.Context {
+	return context.WithValue(ctx, flagSubjectKey{}, incident)
+}
+
+// flagEnabled reports whether a behavior applies to the incident in ctx. Without an incident
+// (e.g. retries) only flags that aren't limited to a subset of incidents are on.
+func (s *IncidentJiraSync) flagEnabled(ctx context.Context, name string) bool {
+	flag, configured := s.settings().FeatureFlags[name]
+	if !configured {
+		return true
+	}
+	if !flag.Enabled {
+		return false
+	}
+
+	incident, hasSubject := ctx.Value(flagSubjectKey{}).(incidentio.Incident)
+	if !hasSubject {
+		return len(flag.IncidentTypes) == 0 && (flag.Percentage == nil || *flag.Percentage == 100)
+	}
+
+	if len(flag.IncidentTypes) > 0 {
+		matched := false
+		for _, incidentType := range flag.IncidentTypes {
+			if incident.IncidentType != nil && strings.EqualFold(incidentType, incident.IncidentType.Name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if flag.Percentage != nil {
+		hash := fnv.New32a()
+		hash.Write([]byte(name + ":" + incident.ID))
+		return int(hash.Sum32()%100) < *flag.Percentage
+	}
+
+	return true
+}
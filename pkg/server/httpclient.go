@@ -0,0 +1,416 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// Upstream names, used as metric labels and environment variable prefixes
+const (
+	upstreamJira     = "jira"
+	upstreamIncident = "incident_io"
+)
+
+// HTTPClientConfig tunes the shared HTTP client used for one upstream API
+type HTTPClientConfig struct {
+	Timeout             time.Duration
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost caps the connections open to an upstream host, idle or in use (0 for no
+	// limit). Requests beyond it wait for a connection, within Timeout.
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	DialTimeout         time.Duration
+	KeepAlive           time.Duration
+	TLSHandshakeTimeout time.Duration
+	ForceAttemptHTTP2   bool
+	InsecureSkipVerify  bool
+
+	// Retries is how many times an idempotent request is retried immediately, within the
+	// webhook delivery, after a transport error or one of RetryStatuses. RetryDelay doubles
+	// with each retry.
+	Retries       int
+	RetryDelay    time.Duration
+	RetryStatuses []int
+	// RetryBudget caps the immediate retries to this upstream across one webhook delivery;
+	// failures beyond it are left to the retry queue
+	RetryBudget int
+	// RetryAfterMax is the longest Retry-After waited for before an immediate retry; responses
+	// asking for longer are left to the retry queue
+	RetryAfterMax time.Duration
+	// RateLimit caps the requests sent to the upstream per minute (0 for no limit)
+	RateLimit int
+}
+
+// getHTTPClientConfig reads client settings for an upstream: PREFIX_HTTP_* variables override
+// the global HTTP_* defaults
+func getHTTPClientConfig(prefix string) HTTPClientConfig {
+	defaults := HTTPClientConfig{
+		Timeout:             getEnvDuration("HTTP_TIMEOUT", 30*time.Second),
+		MaxIdleConnsPerHost: getEnvInt("HTTP_MAX_IDLE_CONNS_PER_HOST", 10),
+		MaxConnsPerHost:     getEnvInt("HTTP_MAX_CONNS_PER_HOST", 0),
+		IdleConnTimeout:     getEnvDuration("HTTP_IDLE_CONN_TIMEOUT", 90*time.Second),
+		DialTimeout:         getEnvDuration("HTTP_DIAL_TIMEOUT", 30*time.Second),
+		KeepAlive:           getEnvDuration("HTTP_KEEP_ALIVE", 30*time.Second),
+		TLSHandshakeTimeout: getEnvDuration("HTTP_TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+		ForceAttemptHTTP2:   getEnvBool("HTTP_FORCE_ATTEMPT_HTTP2", true),
+		InsecureSkipVerify:  getEnvBool("HTTP_INSECURE_SKIP_VERIFY", false),
+		Retries:             getEnvInt("HTTP_RETRIES", 2),
+		RetryDelay:          getEnvDuration("HTTP_RETRY_DELAY", 200*time.Millisecond),
+		RetryStatuses:       parseStatusList(getEnv("HTTP_RETRY_STATUSES", "429,500,502,503,504")),
+		RetryBudget:         getEnvInt("HTTP_RETRY_BUDGET", 6),
+		RetryAfterMax:       getEnvDuration("HTTP_RETRY_AFTER_MAX", 5*time.Second),
+		RateLimit:           getEnvInt("HTTP_RATE_LIMIT", 0),
+	}
+
+	retryStatuses := defaults.RetryStatuses
+	if statuses := getEnv(prefix+"_HTTP_RETRY_STATUSES", ""); statuses != "" {
+		retryStatuses = parseStatusList(statuses)
+	}
+
+	return HTTPClientConfig{
+		Timeout:             getEnvDuration(prefix+"_HTTP_TIMEOUT", defaults.Timeout),
+		MaxIdleConnsPerHost: getEnvInt(prefix+"_HTTP_MAX_IDLE_CONNS_PER_HOST", defaults.MaxIdleConnsPerHost),
+		MaxConnsPerHost:     getEnvInt(prefix+"_HTTP_MAX_CONNS_PER_HOST", defaults.MaxConnsPerHost),
+		IdleConnTimeout:     getEnvDuration(prefix+"_HTTP_IDLE_CONN_TIMEOUT", defaults.IdleConnTimeout),
+		DialTimeout:         getEnvDuration(prefix+"_HTTP_DIAL_TIMEOUT", defaults.DialTimeout),
+		KeepAlive:           getEnvDuration(prefix+"_HTTP_KEEP_ALIVE", defaults.KeepAlive),
+		TLSHandshakeTimeout: getEnvDuration(prefix+"_HTTP_TLS_HANDSHAKE_TIMEOUT", defaults.TLSHandshakeTimeout),
+		ForceAttemptHTTP2:   getEnvBool(prefix+"_HTTP_FORCE_ATTEMPT_HTTP2", defaults.ForceAttemptHTTP2),
+		InsecureSkipVerify:  getEnvBool(prefix+"_HTTP_INSECURE_SKIP_VERIFY", defaults.InsecureSkipVerify),
+		Retries:             getEnvInt(prefix+"_HTTP_RETRIES", defaults.Retries),
+		RetryDelay:          getEnvDuration(prefix+"_HTTP_RETRY_DELAY", defaults.RetryDelay),
+		RetryStatuses:       retryStatuses,
+		RetryBudget:         getEnvInt(prefix+"_HTTP_RETRY_BUDGET", defaults.RetryBudget),
+		RetryAfterMax:       getEnvDuration(prefix+"_HTTP_RETRY_AFTER_MAX", defaults.RetryAfterMax),
+		RateLimit:           getEnvInt(prefix+"_HTTP_RATE_LIMIT", defaults.RateLimit),
+	}
+}
+
+// parseStatusList parses a comma-separated list of HTTP status codes, ignoring invalid entries
+func parseStatusList(value string) []int {
+	var statuses []int
+	for status := range parseList(value) {
+		if code, err := strconv.Atoi(status); err == nil {
+			statuses = append(statuses, code)
+		}
+	}
+	sort.Ints(statuses)
+	return statuses
+}
+
+// newHTTPClient builds the pooled client shared by every request to an upstream. Responses
+// update budget, when given.
+func newHTTPClient(upstream string, config HTTPClientConfig, budget *rateBudget) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   config.DialTimeout,
+			KeepAlive: config.KeepAlive,
+		}).DialContext,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify},
+		ForceAttemptHTTP2:   config.ForceAttemptHTTP2,
+		MaxIdleConns:        config.MaxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     config.MaxConnsPerHost,
+		IdleConnTimeout:     config.IdleConnTimeout,
+		TLSHandshakeTimeout: config.TLSHandshakeTimeout,
+	}
+
+	// The incident.io client's retrying transport serves Jira too, with the delivery's budget
+	retries := &incidentio.Transport{
+		Next: transport,
+		Config: incidentio.RetryConfig{
+			Retries:       config.Retries,
+			Delay:         config.RetryDelay,
+			Statuses:      config.RetryStatuses,
+			RetryAfterMax: config.RetryAfterMax,
+			RateLimit:     config.RateLimit,
+		},
+		Retrying: deliveryRetrying(upstream, config.RetryBudget),
+	}
+	return &http.Client{
+		Timeout:   config.Timeout,
+		Transport: &instrumentedTransport{upstream: upstream, next: retries, budget: budget},
+	}
+}
+
+// deliveryRetriesKey holds the *deliveryRetries of the webhook delivery a request belongs to
+type deliveryRetriesKey struct{}
+
+// deliveryRetries counts the immediate retries made to each upstream while handling one
+// webhook delivery, so a struggling upstream cannot hold a delivery open retry after retry
+type deliveryRetries struct {
+	mu   sync.Mutex
+	used map[string]int
+}
+
+// withDeliveryRetries starts a fresh retry budget for a unit of sync work
+func withDeliveryRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deliveryRetriesKey{}, &deliveryRetries{used: make(map[string]int)})
+}
+
+// take uses one retry of the upstream's budget, reporting false once it is spent
+func (d *deliveryRetries) take(upstream string, budget int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.used[upstream] >= budget {
+		return false
+	}
+	d.used[upstream]++
+	return true
+}
+
+// deliveryRetrying decides whether an upstream's failed request is retried immediately, within
+// the budget of the webhook delivery it belongs to, and counts the retries. Only requests that
+// are safe to repeat get here; everything else is left to the queued retries of the field sync.
+func deliveryRetrying(upstream string, budget int) func(req *http.Request, reason string) bool {
+	return func(req *http.Request, reason string) bool {
+		if reason == incidentio.RetryReasonRetryAfterTooLong {
+			// Too long to hold the delivery open
+			httpRetriesTotal.inc(upstream, reason)
+			return false
+		}
+		retries, _ := req.Context().Value(deliveryRetriesKey{}).(*deliveryRetries)
+		if retries != nil && !retries.take(upstream, budget) {
+			httpRetriesTotal.inc(upstream, "budget_exhausted")
+			return false
+		}
+		httpRetriesTotal.inc(upstream, reason)
+		return true
+	}
+}
+
+// instrumentedTransport records whether each request reused a pooled connection
+type instrumentedTransport struct {
+	upstream string
+	next     http.RoundTripper
+	budget   *rateBudget
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			httpConnectionsTotal.inc(t.upstream, strconv.FormatBool(info.Reused))
+		},
+	}
+	// Spread requests out as the upstream's rate limit budget runs low, rather than run into 429s
+	if t.budget != nil {
+		if err := t.budget.throttle(req.Context(), t.upstream); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err == nil && t.budget != nil {
+		t.budget.observe(t.upstream, resp)
+	}
+	return resp, err
+}
+
+// defaultRateLimitPause is how long to back off after a 429 without a Retry-After header
+const defaultRateLimitPause = time.Minute
+
+// nearLimitDelay slows bulk work down while an upstream reports it is close to its rate limit
+const nearLimitDelay = time.Second
+
+// rateBudget tracks the rate limiting an upstream signals (429 responses with Retry-After, and
+// Atlassian's X-RateLimit-* headers), so bulk work can slow down before webhooks suffer and every
+// request slows down as the remaining budget runs low
+type rateBudget struct {
+	// throttleBelow is the share of the budget left, in percent, below which requests are
+	// spread out until the budget resets (0 disables throttling)
+	throttleBelow int
+	// maxThrottle caps the delay added to one request
+	maxThrottle time.Duration
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+	nearLimit   bool
+	// limit and remaining are the requests allowed and left until resetAt, or 0 and -1 before
+	// the upstream reported them
+	limit     int
+	remaining int
+	resetAt   time.Time
+}
+
+func newRateBudget(throttleBelow int, maxThrottle time.Duration) *rateBudget {
+	return &rateBudget{throttleBelow: throttleBelow, maxThrottle: maxThrottle, remaining: -1}
+}
+
+// rateBudgetStatus is the state of a rate budget, for /admin/status
+type rateBudgetStatus struct {
+	Limit       int        `json:"limit,omitempty"`
+	Remaining   *int       `json:"remaining,omitempty"`
+	ResetAt     *time.Time `json:"reset_at,omitempty"`
+	NearLimit   bool       `json:"near_limit"`
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+}
+
+func (b *rateBudget) status() rateBudgetStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := rateBudgetStatus{Limit: b.limit, NearLimit: b.nearLimit}
+	if b.remaining >= 0 {
+		remaining := b.remaining
+		status.Remaining = &remaining
+	}
+	if !b.resetAt.IsZero() {
+		resetAt := b.resetAt
+		status.ResetAt = &resetAt
+	}
+	if time.Now().Before(b.pausedUntil) {
+		pausedUntil := b.pausedUntil
+		status.PausedUntil = &pausedUntil
+	}
+	return status
+}
+
+// parseRateLimitReset reads X-RateLimit-Reset, which Atlassian sends as an ISO 8601 time;
+// a number of seconds from now is accepted too
+func parseRateLimitReset(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if resetAt, err := time.Parse(time.RFC3339, value); err == nil {
+		return resetAt, true
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Now().Add(time.Duration(seconds) * time.Second), true
+	}
+	return time.Time{}, false
+}
+
+func (b *rateBudget) observe(upstream string, resp *http.Response) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nearLimit = strings.EqualFold(resp.Header.Get("X-RateLimit-NearLimit"), "true")
+	if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil && limit > 0 {
+		b.limit = limit
+		rateLimitBudget.set(float64(limit), upstream, "limit")
+	}
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil && remaining >= 0 {
+		b.remaining = remaining
+		rateLimitBudget.set(float64(remaining), upstream, "remaining")
+	}
+	if resetAt, ok := parseRateLimitReset(resp.Header.Get("X-RateLimit-Reset")); ok {
+		b.resetAt = resetAt
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+	rateLimitedTotal.inc(upstream)
+
+	pause := defaultRateLimitPause
+	if retryAfter, ok := incidentio.RetryAfterDelay(resp.Header.Get("Retry-After"), time.Now()); ok {
+		pause = retryAfter
+	}
+	if until := time.Now().Add(pause); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// pausedFor is how long the upstream asked for no more requests with its last 429
+func (b *rateBudget) pausedFor() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Until(b.pausedUntil)
+}
+
+// spreadDelay is the pause between requests that makes the remaining budget last until it
+// resets, once less than throttleBelow percent of it is left. The caller holds b.mu.
+func (b *rateBudget) spreadDelay() time.Duration {
+	if b.throttleBelow <= 0 || b.limit <= 0 || b.remaining < 0 || b.remaining*100 >= b.limit*b.throttleBelow {
+		return 0
+	}
+	untilReset := time.Until(b.resetAt)
+	if untilReset <= 0 {
+		return 0
+	}
+	return untilReset / time.Duration(b.remaining+1)
+}
+
+// throttle delays a request by the spread delay while the budget is low, or until the pause
+// after a 429 ends, up to maxThrottle
+func (b *rateBudget) throttle(ctx context.Context, upstream string) error {
+	b.mu.Lock()
+	delay := max(b.spreadDelay(), time.Until(b.pausedUntil))
+	b.mu.Unlock()
+	if delay > b.maxThrottle {
+		delay = b.maxThrottle
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	throttledRequestsTotal.inc(upstream)
+	return sleepContext(ctx, delay)
+}
+
+// wait blocks until the upstream has budget for another request
+func (b *rateBudget) wait(ctx context.Context) error {
+	b.mu.Lock()
+	delay := time.Until(b.pausedUntil)
+	if b.nearLimit && delay < nearLimitDelay {
+		delay = nearLimitDelay
+	}
+	if spread := b.spreadDelay(); delay < spread {
+		delay = spread
+	}
+	b.mu.Unlock()
+
+	return sleepContext(ctx, delay)
+}
+
+// sleepContext waits for delay or until ctx is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+var (
+	rateLimitBudget = newGaugeVec(
+		"incident_jira_webhook_rate_limit_budget",
+		"Rate limit budget last reported by an upstream's X-RateLimit headers, by kind (limit or remaining).",
+		"upstream", "kind")
+	rateLimitedTotal = newCounterVec(
+		"incident_jira_webhook_rate_limited_total",
+		"Requests an upstream rejected with 429 Too Many Requests.",
+		"upstream")
+	throttledRequestsTotal = newCounterVec(
+		"incident_jira_webhook_throttled_requests_total",
+		"Requests delayed because the upstream's remaining rate limit budget was low.",
+		"upstream")
+)
+
+var httpConnectionsTotal = newCounterVec(
+	"incident_jira_webhook_http_connections_total",
+	"Outbound HTTP connections obtained per upstream, by whether a pooled connection was reused.",
+	"upstream", "reused")
+
+var httpRetriesTotal = newCounterVec(
+	"incident_jira_webhook_http_retries_total",
+	"Immediate retries of outbound requests per upstream, by status, error or budget_exhausted.",
+	"upstream", "reason")
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers each request with the next of statuses, then 200, counting requests
+func flakyServer(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		if n <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func testRetryConfig() HTTPClientConfig {
+	return HTTPClientConfig{
+		Timeout:       5 * time.Second,
+		Retries:       2,
+		RetryDelay:    time.Millisecond,
+		RetryStatuses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		RetryBudget:   10,
+		RetryAfterMax: 2 * time.Second,
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		header       string
+		retryAfter   string
+		statuses     []int
+		wantStatus   int
+		wantRequests int32
+	}{
+		{name: "transient status", method: http.MethodGet, statuses: []int{503}, wantStatus: 200, wantRequests: 2},
+		{name: "gives up after the retries", method: http.MethodPut, statuses: []int{503, 503, 503}, wantStatus: 503, wantRequests: 3},
+		{name: "other status", method: http.MethodGet, statuses: []int{500}, wantStatus: 500, wantRequests: 1},
+		{name: "POST", method: http.MethodPost, statuses: []int{503}, wantStatus: 503, wantRequests: 1},
+		{name: "POST with an idempotency key", method: http.MethodPost, header: "key", statuses: []int{503}, wantStatus: 200, wantRequests: 2},
+		{name: "Retry-After too long", method: http.MethodGet, retryAfter: "60", statuses: []int{429}, wantStatus: 429, wantRequests: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := flakyServer(t, test.retryAfter, test.statuses...)
+			client := newHTTPClient(upstreamJira, testRetryConfig(), nil)
+
+			req, err := http.NewRequest(test.method, server.URL, strings.NewReader(`{"fields": {}}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.header != "" {
+				req.Header.Set("Idempotency-Key", test.header)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantStatus || requests.Load() != test.wantRequests {
+				t.Errorf("status %d after %d requests, want %d after %d", resp.StatusCode, requests.Load(), test.wantStatus, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryTransportWaitsForRetryAfter(t *testing.T) {
+	server, requests := flakyServer(t, "1", http.StatusTooManyRequests)
+	client := newHTTPClient(upstreamJira, testRetryConfig(), nil)
+
+	started := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests.Load() != 2 {
+		t.Errorf("status %d after %d requests, want 200 after 2", resp.StatusCode, requests.Load())
+	}
+	if waited := time.Since(started); waited < time.Second {
+		t.Errorf("retried after %s, before the Retry-After of 1s", waited)
+	}
+}
+
+func TestRetryTransportBudget(t *testing.T) {
+	server, requests := flakyServer(t, "", 503, 503, 503, 503)
+	config := testRetryConfig()
+	config.RetryBudget = 1
+	client := newHTTPClient(upstreamJira, config, nil)
+
+	// The budget is shared by every request of a delivery
+	ctx := withDeliveryRetries(context.Background())
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if requests.Load() != 3 {
+		t.Errorf("%d requests, want 2 and a single retry", requests.Load())
+	}
+}
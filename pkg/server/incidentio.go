@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// trackIssueLink records the Jira issue linked to an incident and reports whether the issue
+// was attached since the incident was last seen (i.e. it was previously seen without one)
+func (s *IncidentJiraSync) trackIssueLink(incidentID, jiraIssueKey string) bool {
+	if incidentID == "" {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	previous, seen, err := s.store.SwapIssueLink(ctx, incidentID, jiraIssueKey)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return false
+	}
+
+	return seen && jiraIssueKey != "" && previous != jiraIssueKey
+}
+
+// notifySyncFailure tells responders on the incident that a Jira field could not be synced,
+// so they know the Jira issue may be stale. The note goes through the outbox, recorded as the
+// incident's last failure note in the same transaction, so a note already written isn't
+// repeated and one queued isn't lost while incident.io is unavailable.
+func (s *IncidentJiraSync) notifySyncFailure(item retryItem, reason string) {
+	if s.config.FailureNoteFieldID == "" || item.IncidentID == "" {
+		return
+	}
+
+	note := fmt.Sprintf("Jira issue %s was not updated with %q: %s. Values in Jira may be out of date.",
+		item.JiraIssueKey, item.FieldMapping.IncidentFieldName, reason)
+
+	// Failure notes share one field per incident, so the last one is kept by incident
+	key := "incident/" + item.IncidentID
+	if !s.lastWritten.changed(key, "failure_note", note) {
+		return
+	}
+
+	ctx := withOrganization(context.Background(), item.Organization)
+	edit := incidentio.TextFieldEdit(s.config.FailureNoteFieldID, note, s.config.FailureNoteNotifyChannel)
+	if err := s.enqueueIncidentEdit(ctx, item.IncidentID, "failure note", edit, writtenValue{Key: key, Attribute: "failure_note", Value: note}); err != nil {
+		log.Printf("Failed to queue failure note for incident %s: %v", item.IncidentID, err)
+		return
+	}
+
+	log.Printf("Queued note to incident %s that %s was not synced to %s", item.IncidentID, item.FieldMapping.IncidentFieldName, item.JiraIssueKey)
+}
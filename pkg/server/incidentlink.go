@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// incidentIOIcon is the icon shown on remote links to incident.io
+const incidentIOIcon = "https://incident.io/favicon.ico"
+
+func incidentLinkGlobalID(incidentID string) string {
+	return "incident-io-incident:" + incidentID
+}
+
+// incidentRemoteLink returns the remote link from a Jira issue to the incident's homepage
+func (s *IncidentJiraSync) incidentRemoteLink(incident incidentio.Incident) jira.RemoteLink {
+	var link jira.RemoteLink
+	link.GlobalID = incidentLinkGlobalID(incident.ID)
+	link.Relationship = "incident"
+	link.Application = &jira.RemoteLinkApplication{Type: "io.incident", Name: "incident.io"}
+
+	link.Object.URL = incident.Permalink
+	link.Object.Title = incident.Name
+	if incident.Reference != "" {
+		link.Object.Title = incident.Reference + ": " + incident.Name
+	}
+	summary := []string{incident.IncidentStatus.Name}
+	if incident.Severity != nil && incident.Severity.Name != "" {
+		summary = append(summary, incident.Severity.Name)
+	}
+	link.Object.Summary = strings.Join(summary, " · ")
+	link.Object.Icon.URL16x16 = incidentIOIcon
+	link.Object.Icon.Title = "incident.io"
+
+	link.Object.Status = &jira.RemoteLinkStatus{Resolved: s.incidentResolved(incident)}
+	link.Object.Status.Icon.Title = incident.IncidentStatus.Name
+	return link
+}
+
+// syncIncidentLink keeps a remote link from the Jira issue to the incident's homepage, updating
+// its title, status and resolved flag as the incident changes
+func (s *IncidentJiraSync) syncIncidentLink(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if !s.config.IncidentRemoteLink {
+		return nil
+	}
+	if incident.Permalink == "" {
+		log.Printf("No permalink on incident %s, skipping remote link", incident.ID)
+		return nil
+	}
+
+	link := s.incidentRemoteLink(incident)
+	value := fmt.Sprintf("%s|%s|%s|%t", link.Object.URL, link.Object.Title, link.Object.Summary, link.Object.Status.Resolved)
+	if !s.lastWritten.changed(jiraIssueKey, "incident_link", value) {
+		return nil
+	}
+
+	// Posting with the same global ID updates the link in place
+	log.Printf("Updating remote link from %s to incident %s", jiraIssueKey, incident.ID)
+	if err := s.jira.PutRemoteLink(ctx, jiraIssueKey, link); err != nil {
+		return fmt.Errorf("failed to link incident: %w", err)
+	}
+
+	s.lastWritten.record(jiraIssueKey, "incident_link", value)
+	return nil
+}
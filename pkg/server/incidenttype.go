@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// lookupIncidentType returns the value mapped for an incident type name (case-insensitive)
+func lookupIncidentType(mapping map[string]string, incidentType string) (string, bool) {
+	for name, value := range mapping {
+		if strings.EqualFold(name, incidentType) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// syncIncidentType mirrors the incident type to the Jira select field and/or changes the issue
+// type, so Jira triage queues can split by incident type
+func (s *IncidentJiraSync) syncIncidentType(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if incident.IncidentType == nil || incident.IncidentType.Name == "" {
+		return nil
+	}
+	incidentType := incident.IncidentType.Name
+
+	if s.config.IncidentTypeJiraFieldID != "" {
+		// Without a mapping the incident type name is used as the option
+		option := incidentType
+		if len(s.config.IncidentTypeMapping) > 0 {
+			var mapped bool
+			if option, mapped = lookupIncidentType(s.config.IncidentTypeMapping, incidentType); !mapped {
+				log.Printf("No Jira option mapped for incident type %s, skipping", incidentType)
+			}
+		}
+
+		if option != "" && s.lastWritten.changed(jiraIssueKey, "incident_type", option) {
+			log.Printf("Mapped incident type %s -> %s", incidentType, option)
+			fields := map[string]interface{}{
+				s.config.IncidentTypeJiraFieldID: jira.SelectValue{Value: option},
+			}
+			if err := s.updateJiraIssueFields(ctx, jiraIssueKey, fields); err != nil {
+				return err
+			}
+			s.lastWritten.record(jiraIssueKey, "incident_type", option)
+		}
+	}
+
+	issueTypeName, mapped := lookupIncidentType(s.config.IncidentTypeIssueTypes, incidentType)
+	if !mapped || !s.lastWritten.changed(jiraIssueKey, "issue_type", issueTypeName) {
+		return nil
+	}
+	return s.changeIssueType(ctx, jiraIssueKey, issueTypeName)
+}
+
+// changeIssueType changes the issue to the named issue type if the edit API permits it. Types
+// that would need a move between projects or workflows are logged and skipped.
+func (s *IncidentJiraSync) changeIssueType(ctx context.Context, jiraIssueKey, issueTypeName string) error {
+	allowed, err := s.jira.AllowedIssueTypes(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+
+	for _, issueType := range allowed {
+		if !strings.EqualFold(issueType.Name, issueTypeName) {
+			continue
+		}
+
+		log.Printf("Changing issue type of %s to %s", jiraIssueKey, issueType.Name)
+		fields := map[string]interface{}{"issuetype": map[string]string{"id": issueType.ID}}
+		if err := s.updateJiraIssueFields(ctx, jiraIssueKey, fields); err != nil {
+			return err
+		}
+		s.lastWritten.record(jiraIssueKey, "issue_type", issueTypeName)
+		return nil
+	}
+
+	log.Printf("Warning: %s cannot be changed to issue type %s through the edit API, skipping", jiraIssueKey, issueTypeName)
+	s.lastWritten.record(jiraIssueKey, "issue_type", issueTypeName)
+	return nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// streamLatencyBudget is the stream event published when webhook latency crosses its budget
+const streamLatencyBudget = "latency_budget"
+
+// latencyTracker keeps the end-to-end processing latency of recent webhooks and reports when
+// their 95th percentile goes over LATENCY_BUDGET
+type latencyTracker struct {
+	budget time.Duration
+
+	mu       sync.Mutex
+	samples  []time.Duration
+	next     int
+	exceeded bool
+}
+
+func newLatencyTracker(budget time.Duration, window int) *latencyTracker {
+	return &latencyTracker{budget: budget, samples: make([]time.Duration, 0, window)}
+}
+
+// observe records the latency of a webhook and returns the 95th percentile of the window, and
+// whether it crossed the budget (exceeded) or came back under it (recovered) with this webhook
+func (t *latencyTracker) observe(latency time.Duration) (p95 time.Duration, exceeded, recovered bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < cap(t.samples) {
+		t.samples = append(t.samples, latency)
+	} else {
+		t.samples[t.next] = latency
+		t.next = (t.next + 1) % len(t.samples)
+	}
+
+	sorted := append([]time.Duration(nil), t.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p95 = sorted[(len(sorted)*95+99)/100-1]
+
+	if t.budget <= 0 {
+		return p95, false, false
+	}
+	over := p95 > t.budget
+	exceeded = over && !t.exceeded
+	recovered = !over && t.exceeded
+	t.exceeded = over
+	return p95, exceeded, recovered
+}
+
+// recordWebhookLatency tracks how long a webhook took from receipt to response, warning when
+// the 95th percentile of recent webhooks goes over LATENCY_BUDGET
+func (s *IncidentJiraSync) recordWebhookLatency(eventType string, latency time.Duration) {
+	p95, exceeded, recovered := s.latency.observe(latency)
+	webhookLatencyP95Seconds.set(p95.Seconds())
+	webhookLatencySecondsTotal.add(latency.Seconds(), eventType)
+
+	if s.config.LatencyBudget <= 0 {
+		return
+	}
+	over := 0.0
+	if p95 > s.config.LatencyBudget {
+		over = 1
+	}
+	latencyBudgetExceeded.set(over)
+
+	switch {
+	case exceeded:
+		log.Printf("Warning: p95 webhook latency %s is over the %s budget; Jira is lagging behind incident.io", p95.Round(time.Millisecond), s.config.LatencyBudget)
+		latencyBudgetBreachesTotal.inc()
+		s.stream.publish(streamEvent{Type: streamLatencyBudget, Outcome: "exceeded",
+			Message: fmt.Sprintf("p95 latency %s is over the %s budget", p95.Round(time.Millisecond), s.config.LatencyBudget)})
+	case recovered:
+		log.Printf("p95 webhook latency %s is back within the %s budget", p95.Round(time.Millisecond), s.config.LatencyBudget)
+		s.stream.publish(streamEvent{Type: streamLatencyBudget, Outcome: "recovered",
+			Message: fmt.Sprintf("p95 latency %s is within the %s budget", p95.Round(time.Millisecond), s.config.LatencyBudget)})
+	}
+}
+
+var (
+	webhookLatencyP95Seconds = newGaugeVec(
+		"incident_jira_webhook_latency_p95_seconds",
+		"95th percentile of the end-to-end processing latency of recent webhooks.")
+	webhookLatencySecondsTotal = newCounterVec(
+		"incident_jira_webhook_latency_seconds_total",
+		"Total end-to-end processing time of webhooks, by event type; divide by events_total for the mean.",
+		"event_type")
+	latencyBudgetExceeded = newGaugeVec(
+		"incident_jira_webhook_latency_budget_exceeded",
+		"1 while the p95 webhook latency is over LATENCY_BUDGET, else 0.")
+	latencyBudgetBreachesTotal = newCounterVec(
+		"incident_jira_webhook_latency_budget_breaches_total",
+		"Times the p95 webhook latency went over LATENCY_BUDGET.")
+)
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloader serves a TLS certificate from disk and reloads it on demand
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// listenAddresses expands a comma-separated list of listen addresses. Entries without a port
+// (e.g. "::1" or "10.0.0.5") use defaultPort.
+func listenAddresses(value, defaultPort string) ([]string, error) {
+	var addresses []string
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); address == "" {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			host := strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+			if net.ParseIP(host) == nil && host != "localhost" {
+				return nil, fmt.Errorf("invalid listen address %q", address)
+			}
+			address = net.JoinHostPort(host, defaultPort)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// listen returns the listeners for addresses: sockets inherited through systemd socket
+// activation if inherit is set and any were passed, otherwise new sockets
+func listen(config Config, addresses []string, inherit bool) ([]net.Listener, error) {
+	// systemd passes activated sockets starting at fd 3
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); inherit && err == nil && pid == os.Getpid() {
+		if fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); err == nil && fds > 0 {
+			log.Printf("Using %d listeners inherited from systemd socket activation", fds)
+			var listeners []net.Listener
+			for fd := 3; fd < 3+fds; fd++ {
+				listener, err := net.FileListener(os.NewFile(uintptr(fd), "systemd-listener"))
+				if err != nil {
+					return nil, err
+				}
+				listeners = append(listeners, listener)
+			}
+			return listeners, nil
+		}
+	}
+
+	listenConfig := net.ListenConfig{}
+	if config.ReusePort {
+		listenConfig.Control = reusePortControl
+	}
+
+	var listeners []net.Listener
+	for _, address := range addresses {
+		listener, err := listenConfig.Listen(context.Background(), "tcp", address)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenTarget is a set of addresses served by one handler
+type listenTarget struct {
+	name      string
+	addresses []string
+	handler   http.Handler
+	// inherit takes sockets from systemd socket activation instead of the addresses
+	inherit bool
+}
+
+// signalHooks are run on operator signals other than shutdown
+type signalHooks struct {
+	// reload runs on SIGHUP, after the TLS certificate is reloaded
+	reload func()
+	// dump runs on SIGUSR1, where the platform has it
+	dump func()
+	// live reports why the service isn't making progress, withholding systemd watchdog pings
+	live func() error
+}
+
+// runServer serves every target until SIGINT/SIGTERM, reloading the TLS certificate and running
+// hooks.reload on SIGHUP and hooks.dump on SIGUSR1
+func runServer(config Config, targets []listenTarget, hooks signalHooks) error {
+	var tlsConfig *tls.Config
+	var reloader *certReloader
+	if config.TLSCertFile != "" {
+		var err error
+		reloader, err = newCertReloader(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{
+			GetCertificate: reloader.getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+
+		// Client certificates are verified when presented; the mtls auth check requires them
+		if config.TLSClientCAFile != "" {
+			caPEM, err := os.ReadFile(config.TLSClientCAFile)
+			if err != nil {
+				return fmt.Errorf("failed to read TLS client CA: %w", err)
+			}
+			clientCAs := x509.NewCertPool()
+			if !clientCAs.AppendCertsFromPEM(caPEM) {
+				return fmt.Errorf("no certificates found in %s", config.TLSClientCAFile)
+			}
+			tlsConfig.ClientCAs = clientCAs
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	type serving struct {
+		server    *http.Server
+		listeners []net.Listener
+		name      string
+	}
+	var servers []serving
+	for _, target := range targets {
+		listeners, err := listen(config, target.addresses, target.inherit)
+		if err != nil {
+			for _, started := range servers {
+				for _, listener := range started.listeners {
+					listener.Close()
+				}
+			}
+			return fmt.Errorf("failed to listen for %s: %w", target.name, err)
+		}
+		servers = append(servers, serving{
+			server:    &http.Server{Handler: target.handler, TLSConfig: tlsConfig},
+			listeners: listeners,
+			name:      target.name,
+		})
+	}
+
+	shutdownDone := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM}, dumpSignals...)...)
+	// Stop requests of the Windows service control manager arrive as SIGTERM
+	attachServiceSignals(signals)
+	watchdogStop := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				if reloader != nil {
+					if err := reloader.reload(); err != nil {
+						log.Printf("Failed to reload TLS certificate: %v", err)
+					} else {
+						log.Printf("Reloaded TLS certificate from %s", config.TLSCertFile)
+					}
+				}
+				if hooks.reload != nil {
+					hooks.reload()
+				}
+				continue
+			}
+			if isDumpSignal(sig) {
+				if hooks.dump != nil {
+					hooks.dump()
+				}
+				continue
+			}
+
+			log.Printf("Received %s, shutting down", sig)
+			notifyServiceStopping()
+			close(watchdogStop)
+			ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+			for _, started := range servers {
+				if err := started.server.Shutdown(ctx); err != nil {
+					log.Printf("Graceful shutdown of %s failed: %v", started.name, err)
+				}
+			}
+			cancel()
+			return
+		}
+	}()
+
+	errs := make(chan error)
+	serveCount := 0
+	for _, started := range servers {
+		for _, listener := range started.listeners {
+			serveCount++
+			go func(server *http.Server, listener net.Listener, name string) {
+				var err error
+				if reloader != nil {
+					log.Printf("Serving %s over HTTPS on %s", name, listener.Addr())
+					err = server.ServeTLS(listener, "", "")
+				} else {
+					log.Printf("Serving %s over HTTP on %s", name, listener.Addr())
+					err = server.Serve(listener)
+				}
+				errs <- err
+			}(started.server, listener, started.name)
+		}
+	}
+
+	names := make([]string, 0, len(servers))
+	for _, started := range servers {
+		names = append(names, started.name)
+	}
+	notifyServiceReady("Serving " + strings.Join(names, " and "))
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go runSdWatchdog(interval, watchdogStop, hooks.live)
+	}
+
+	// A listener failing stops the service; otherwise wait for shutdown to close them all
+	var firstErr error
+	for i := 0; i < serveCount; i++ {
+		err := <-errs
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && firstErr == nil {
+			firstErr = err
+			for _, started := range servers {
+				started.server.Close()
+			}
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// Serve returns as soon as shutdown starts; wait for in-flight requests to drain
+	<-shutdownDone
+	return nil
+}
+
+// defaultShutdownTimeout bounds how long in-flight webhooks may take to finish on shutdown
+const defaultShutdownTimeout = 30 * time.Second
+
+func isDumpSignal(sig os.Signal) bool {
+	for _, dumpSignal := range dumpSignals {
+		if sig == dumpSignal {
+			return true
+		}
+	}
+	return false
+}
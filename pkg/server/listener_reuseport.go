@@ -0,0 +1,19 @@
+//go:build darwin || freebsd || (linux && !mips && !mipsle && !mips64 && !mips64le)
+
+package server
+
+import (
+	"syscall"
+)
+
+// reusePortControl sets SO_REUSEPORT so several processes can bind the same port during restarts
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
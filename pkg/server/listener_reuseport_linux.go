@@ -0,0 +1,6 @@
+//go:build !mips && !mipsle && !mips64 && !mips64le
+
+package server
+
+// soReusePort is SO_REUSEPORT, which package syscall does not export on Linux
+const soReusePort = 0xf
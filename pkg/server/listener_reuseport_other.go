@@ -0,0 +1,13 @@
+//go:build !darwin && !freebsd && (!linux || mips || mipsle || mips64 || mips64le)
+
+package server
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// reusePortControl is unsupported on this platform
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// issueLocker serializes writes to the same Jira issue. The local locker covers a single
+// replica; the Redis locker extends this across replicas.
+type issueLocker interface {
+	// Lock blocks until the lock for key is held or ctx is done
+	Lock(ctx context.Context, key string) (unlock func(), err error)
+}
+
+// localLocker is an in-process keyed mutex
+type localLocker struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func newLocalLocker() *localLocker {
+	return &localLocker{locks: make(map[string]chan struct{})}
+}
+
+func (l *localLocker) Lock(ctx context.Context, key string) (func(), error) {
+	for {
+		l.mu.Lock()
+		held, exists := l.locks[key]
+		if !exists {
+			released := make(chan struct{})
+			l.locks[key] = released
+			l.mu.Unlock()
+			return func() {
+				l.mu.Lock()
+				delete(l.locks, key)
+				l.mu.Unlock()
+				close(released)
+			}, nil
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-held:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// redisLocker holds locks as Redis keys set with NX and an expiry, so a crashed replica's
+// lock is released after the TTL. While a lock is held its expiry is extended every third of
+// the TTL, so work that runs longer than the TTL keeps it. Unlocking and extending only touch
+// the key if this holder still owns it.
+type redisLocker struct {
+	client    *redisClient
+	prefix    string
+	ttl       time.Duration
+	pollEvery time.Duration
+}
+
+// Deletes the lock key only if it still holds our token
+const redisUnlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// Extends the lock key's expiry to ARGV[2] milliseconds only if it still holds our token
+const redisRefreshScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+
+func (l *redisLocker) Lock(ctx context.Context, key string) (func(), error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+	redisKey := l.prefix + key
+
+	for {
+		reply, err := l.client.do(ctx, "SET", redisKey, token, "NX", "PX", fmt.Sprintf("%d", l.ttl.Milliseconds()))
+		if deadline, set := ctx.Deadline(); err != nil && (ctx.Err() != nil || set && !time.Now().Before(deadline)) {
+			// The deadline ran out during the command. The connection shares it, so it can time
+			// out a moment before ctx reports the deadline.
+			cause := ctx.Err()
+			if cause == nil {
+				cause = context.DeadlineExceeded
+			}
+			return nil, fmt.Errorf("timed out waiting for lock %s: %w", redisKey, cause)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire lock %s: %w", redisKey, err)
+		}
+		if reply == "OK" {
+			break
+		}
+
+		select {
+		case <-time.After(l.pollEvery):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for lock %s: %w", redisKey, ctx.Err())
+		}
+	}
+
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		l.refresh(refreshCtx, redisKey, token)
+	}()
+
+	return func() {
+		stopRefresh()
+		<-refreshed
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := l.client.do(ctx, "EVAL", redisUnlockScript, "1", redisKey, token); err != nil {
+			log.Printf("Failed to release lock %s (it will expire after %s): %v", redisKey, l.ttl, err)
+		}
+	}, nil
+}
+
+// refresh extends a held lock's expiry every third of the TTL until ctx is done. A failed
+// extension is tried again on the next tick, while the lock is still held.
+func (l *redisLocker) refresh(ctx context.Context, redisKey, token string) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		commandCtx, cancel := context.WithTimeout(ctx, l.ttl/3)
+		reply, err := l.client.do(commandCtx, "EVAL", redisRefreshScript, "1", redisKey, token, fmt.Sprintf("%d", l.ttl.Milliseconds()))
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Printf("Failed to extend lock %s: %v", redisKey, err)
+		case reply == int64(0):
+			log.Printf("Warning: lock %s expired while held, another replica may be writing the same issue", redisKey)
+			return
+		}
+	}
+}
+
+// newIssueLocker returns a Redis-backed locker when LOCK_REDIS_URL is set, otherwise a local one
+func newIssueLocker(config Config) (issueLocker, error) {
+	if config.LockRedisURL == "" {
+		return newLocalLocker(), nil
+	}
+
+	client, err := newRedisClient(config.LockRedisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisLocker{
+		client:    client,
+		prefix:    config.LockKeyPrefix,
+		ttl:       config.LockTTL,
+		pollEvery: 100 * time.Millisecond,
+	}, nil
+}
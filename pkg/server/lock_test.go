@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalLockerSerializes(t *testing.T) {
+	locker := newLocalLocker()
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	held, maxHeld := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := locker.Lock(ctx, "SUP-1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			held++
+			if held > maxHeld {
+				maxHeld = held
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			held--
+			mu.Unlock()
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if maxHeld != 1 {
+		t.Errorf("lock was held %d times at once", maxHeld)
+	}
+}
+
+func TestLocalLockerHonorsContext(t *testing.T) {
+	locker := newLocalLocker()
+	unlock, err := locker.Lock(context.Background(), "SUP-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	// Other keys aren't blocked
+	unlockOther, err := locker.Lock(context.Background(), "SUP-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockOther()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := locker.Lock(ctx, "SUP-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lock() of a held key = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestNewIssueLocker(t *testing.T) {
+	if locker, err := newIssueLocker(Config{}); err != nil {
+		t.Errorf("newIssueLocker() = %v", err)
+	} else if _, local := locker.(*localLocker); !local {
+		t.Errorf("newIssueLocker() without LOCK_REDIS_URL = %T, want a local locker", locker)
+	}
+
+	locker, err := newIssueLocker(Config{LockRedisURL: "redis://locks:6379", LockKeyPrefix: "lock:", LockTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redis, ok := locker.(*redisLocker)
+	if !ok || redis.client.addr != "locks:6379" || redis.prefix != "lock:" || redis.ttl != time.Minute {
+		t.Errorf("newIssueLocker() with LOCK_REDIS_URL = %+v, want a Redis locker", locker)
+	}
+
+	if _, err := newIssueLocker(Config{LockRedisURL: "http://locks"}); err == nil {
+		t.Error("newIssueLocker() with an invalid LOCK_REDIS_URL succeeded")
+	}
+}
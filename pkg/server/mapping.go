@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// getFieldMappings returns the built-in field mappings from config, in resolution order
+func (s *IncidentJiraSync) getFieldMappings() []mapping.FieldMapping {
+	fieldMappings := []mapping.FieldMapping{
+		{
+			IncidentFieldName: s.config.ImpactedComponentFieldName,
+			JiraFieldID:       s.config.ImpactedComponentJiraFieldID,
+			JiraTargets:       s.config.ImpactedComponentTargets,
+			ObjectKeyPattern:  s.config.ImpactedComponentObjectKeyPattern,
+			CatalogAttribute:  s.config.ImpactedComponentCatalogAttribute,
+			UseExternalID:     s.config.ImpactedComponentUseExternalID,
+			Credential:        s.config.ImpactedComponentCredential,
+		},
+		{
+			IncidentFieldName: s.config.ResponsibleComponentFieldName,
+			JiraFieldID:       s.config.ResponsibleComponentJiraFieldID,
+			JiraTargets:       s.config.ResponsibleComponentTargets,
+			ObjectKeyPattern:  s.config.ResponsibleComponentObjectKeyPattern,
+			CatalogAttribute:  s.config.ResponsibleComponentCatalogAttribute,
+			UseExternalID:     s.config.ResponsibleComponentUseExternalID,
+			Credential:        s.config.ResponsibleComponentCredential,
+		},
+	}
+
+	if s.config.SprintFieldName != "" && s.config.JiraSprintFieldID != "" {
+		fieldMappings = append(fieldMappings, mapping.FieldMapping{
+			IncidentFieldName: s.config.SprintFieldName,
+			JiraFieldID:       s.config.JiraSprintFieldID,
+			Type:              mapping.TypeSprint,
+		})
+	}
+
+	return fieldMappings
+}
+
+// resolveFieldMapping finds the mapping for an incident custom field, checking the
+// built-in component mappings before the configured mapping rules
+func (s *IncidentJiraSync) resolveFieldMapping(fieldName string) (mapping.FieldMapping, bool) {
+	resolver := mapping.Resolver{
+		Builtins: s.getFieldMappings(),
+		Rules:    s.settings().MappingRules,
+	}
+	return resolver.Resolve(fieldName)
+}
+
+// transformValues runs the mapping's transform on each incident value text, returning the
+// Jira values in order
+func (s *IncidentJiraSync) transformValues(ctx context.Context, fieldMapping mapping.FieldMapping, texts []string) ([]string, error) {
+	incident, _ := ctx.Value(flagSubjectKey{}).(incidentio.Incident)
+
+	var values []string
+	for _, text := range texts {
+		transformed, err := fieldMapping.ApplyTransform(mapping.TransformInput{
+			Value:    text,
+			Values:   texts,
+			Field:    fieldMapping.IncidentFieldName,
+			Incident: incident,
+		}, s.config.TransformTimeout)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, transformed...)
+	}
+	return values, nil
+}
+
+// fieldsForEvent leaves out the custom field entries whose mappings aren't written on an event
+// type, e.g. fields only written at creation. Mappings listing initial_sync are also written
+// on initial syncs.
+func (s *IncidentJiraSync) fieldsForEvent(entries []incidentio.CustomFieldEntry, eventType string, initialSync bool) []incidentio.CustomFieldEntry {
+	applicable := make([]incidentio.CustomFieldEntry, 0, len(entries))
+	for _, entry := range entries {
+		fieldMapping, found := s.resolveFieldMapping(entry.CustomField.Name)
+		if found && !fieldMapping.AppliesTo(eventType) && !(initialSync && fieldMapping.AppliesTo(mapping.EventInitialSync)) {
+			log.Printf("Skipping %s, its mapping isn't written on %s events", entry.CustomField.Name, eventType)
+			continue
+		}
+		applicable = append(applicable, entry)
+	}
+	return applicable
+}
+
+// orderFieldEntries sorts an event's custom field entries into the order their mappings
+// declare, so fields other Jira fields depend on are written first
+func (s *IncidentJiraSync) orderFieldEntries(entries []incidentio.CustomFieldEntry) []incidentio.CustomFieldEntry {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.CustomField.Name
+	}
+
+	order, cycle := mapping.Sequence(names, s.resolveFieldMapping)
+	if len(cycle) > 0 {
+		log.Printf("Warning: mapping dependencies between %s form a cycle, writing them in order", strings.Join(cycle, ", "))
+	}
+
+	ordered := make([]incidentio.CustomFieldEntry, len(entries))
+	for position, i := range order {
+		ordered[position] = entries[i]
+	}
+	return ordered
+}
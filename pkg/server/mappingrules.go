@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// maxMappingRulesBytes bounds the rules document accepted by /admin/mappings/import
+const maxMappingRulesBytes = 1 << 20
+
+// mappingRulesDocument returns rules as a MAPPING_RULES_FILE document, and a checksum that is
+// the same for equal rules however their documents were formatted
+func mappingRulesDocument(rules []mapping.Rule) ([]byte, string, error) {
+	if rules == nil {
+		rules = []mapping.Rule{}
+	}
+	canonical, err := json.Marshal(mapping.RulesFile{Rules: rules})
+	if err != nil {
+		return nil, "", err
+	}
+	hash := sha256.Sum256(canonical)
+
+	var document bytes.Buffer
+	if err := json.Indent(&document, canonical, "", "  "); err != nil {
+		return nil, "", err
+	}
+	document.WriteByte('\n')
+	return document.Bytes(), hex.EncodeToString(hash[:]), nil
+}
+
+// adminMappingsHandler exports the mapping rules in effect as a MAPPING_RULES_FILE document,
+// with their checksum as the ETag
+func (s *IncidentJiraSync) adminMappingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	document, checksum, err := mappingRulesDocument(s.settings().MappingRules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+checksum+`"`)
+	w.Write(document)
+}
+
+// mappingsImportResult is the response of /admin/mappings/import
+type mappingsImportResult struct {
+	// Status is applied, unchanged, or with dry_run would_apply
+	Status   string `json:"status"`
+	Rules    int    `json:"rules"`
+	Checksum string `json:"checksum"`
+	Previous string `json:"previous_checksum,omitempty"`
+}
+
+// adminMappingsImportHandler replaces the mapping rules with the rules document in the body,
+// storing it in the state store, from which every replica applies it, and writing it to
+// MAPPING_RULES_FILE so it outlives restarts and reloads. Importing the rules
+// already in effect changes nothing, so the same document can be applied again and again, and
+// ?dry_run=true only reports whether it would change them.
+func (s *IncidentJiraSync) adminMappingsImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMappingRulesBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read rules: %v", err), http.StatusBadRequest)
+		return
+	}
+	rules, err := mapping.ParseRules(data, "rules")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.config.MappingRulesFile == "" {
+		http.Error(w, "MAPPING_RULES_FILE is required to import mapping rules", http.StatusConflict)
+		return
+	}
+	candidate := s.config
+	candidate.MappingRules = rules
+	if err := validateJiraCredentials(candidate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, checksum, err := mappingRulesDocument(rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mappingsMu.Lock()
+	defer s.mappingsMu.Unlock()
+
+	current := s.settings()
+	_, previous, err := mappingRulesDocument(current.MappingRules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := mappingsImportResult{Status: "unchanged", Rules: len(rules), Checksum: checksum}
+	if checksum == previous {
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	result.Previous = previous
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		result.Status = "would_apply"
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	// Stored first, so other replicas pick the rules up even if this one fails to write its file
+	storeCtx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+	err = s.store.SaveMappingRules(storeCtx, data)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to store imported mapping rules: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.applyMappingRules(data, rules); err != nil {
+		log.Printf("Failed to write imported mapping rules: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result.Status = "applied"
+	log.Printf("Imported %d mapping rules into %s (checksum %s, was %s)", len(rules), s.config.MappingRulesFile, checksum, previous)
+	json.NewEncoder(w).Encode(result)
+}
+
+// applyMappingRules writes a rules document to MAPPING_RULES_FILE, if set, and puts its rules in
+// effect. mappingsMu must be held.
+func (s *IncidentJiraSync) applyMappingRules(document []byte, rules []mapping.Rule) error {
+	if s.config.MappingRulesFile != "" {
+		if err := writeFileAtomically(s.config.MappingRulesFile, document); err != nil {
+			return err
+		}
+	}
+
+	updated := *s.settings()
+	updated.MappingRules = rules
+	s.reloadable.Store(&updated)
+	s.recordConfiguredMappings()
+	return nil
+}
+
+// syncMappingRules applies the rules last imported through the admin API of any replica, when
+// they differ from the rules in effect
+func (s *IncidentJiraSync) syncMappingRules(ctx context.Context) error {
+	document, found, err := s.store.MappingRules(ctx)
+	if err != nil || !found {
+		return err
+	}
+	rules, err := mapping.ParseRules(document, "rules")
+	if err != nil {
+		return fmt.Errorf("stored mapping rules are invalid: %w", err)
+	}
+	_, checksum, err := mappingRulesDocument(rules)
+	if err != nil {
+		return err
+	}
+
+	s.mappingsMu.Lock()
+	defer s.mappingsMu.Unlock()
+
+	_, previous, err := mappingRulesDocument(s.settings().MappingRules)
+	if err != nil {
+		return err
+	}
+	if checksum == previous {
+		return nil
+	}
+	if err := s.applyMappingRules(document, rules); err != nil {
+		return err
+	}
+	log.Printf("Applied %d imported mapping rules from the state store (checksum %s, was %s)", len(rules), checksum, previous)
+	return nil
+}
+
+// runMappingRulesSync applies rules imported on other replicas every MAPPING_RULES_SYNC_INTERVAL
+func (s *IncidentJiraSync) runMappingRulesSync() {
+	ticker := time.NewTicker(s.config.MappingRulesSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		if err := s.syncMappingRules(ctx); err != nil {
+			log.Printf("Failed to apply the imported mapping rules: %v", err)
+		}
+		cancel()
+		<-ticker.C
+	}
+}
+
+// writeFileAtomically replaces a file by renaming a complete copy over it, keeping its mode, so
+// a reload never reads it half written
+func writeFileAtomically(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := temp.Chmod(mode); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// mappingsClient calls the mapping rules endpoints of a running service's admin API
+type mappingsClient struct {
+	adminURL string
+	apiKey   string
+	http     *http.Client
+}
+
+func newMappingsClient(adminURL, apiKey string) (*mappingsClient, error) {
+	if apiKey == "" {
+		return nil, errors.New("ADMIN_API_KEY is required to call the admin API")
+	}
+	return &mappingsClient{
+		adminURL: strings.TrimSuffix(adminURL, "/"),
+		apiKey:   apiKey,
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (c *mappingsClient) call(method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.adminURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// ExportMappings writes the mapping rules in effect on the service at adminURL to out, as a
+// MAPPING_RULES_FILE document
+func ExportMappings(adminURL, apiKey string, out io.Writer) error {
+	client, err := newMappingsClient(adminURL, apiKey)
+	if err != nil {
+		return err
+	}
+	document, err := client.call(http.MethodGet, "/admin/mappings", nil)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(document)
+	return err
+}
+
+// ImportMappings applies a rules document to the service at adminURL, or with dryRun reports
+// whether it would change the rules in effect, and writes the outcome to out
+func ImportMappings(adminURL, apiKey string, document []byte, dryRun bool, out io.Writer) error {
+	if _, err := mapping.ParseRules(document, "rules"); err != nil {
+		return err
+	}
+	client, err := newMappingsClient(adminURL, apiKey)
+	if err != nil {
+		return err
+	}
+
+	path := "/admin/mappings/import"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+	response, err := client.call(http.MethodPost, path, document)
+	if err != nil {
+		return err
+	}
+	var result mappingsImportResult
+	if err := json.Unmarshal(response, &result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	_, err = fmt.Fprintf(out, "%s: %d mapping rules (checksum %s)\n", result.Status, result.Rules, result.Checksum)
+	return err
+}
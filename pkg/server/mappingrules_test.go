@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportedMappingRulesReachOtherReplicas(t *testing.T) {
+	store := newMemoryStore()
+	newReplica := func() *IncidentJiraSync {
+		s := &IncidentJiraSync{
+			config: Config{MappingRulesFile: filepath.Join(t.TempDir(), "mapping-rules.json")},
+			store:  store,
+		}
+		s.reloadable.Store(&reloadableSettings{})
+		return s
+	}
+	importing, other := newReplica(), newReplica()
+
+	document := `{"rules": [{"pattern": "* components", "jira_fields": {"Affected components": "customfield_1"}}]}`
+	recorder := httptest.NewRecorder()
+	importing.adminMappingsImportHandler(recorder, httptest.NewRequest(http.MethodPost, "/admin/mappings/import", strings.NewReader(document)))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"applied"`) {
+		t.Fatalf("import answered %d: %s", recorder.Code, recorder.Body)
+	}
+
+	if err := other.syncMappingRules(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rules := other.settings().MappingRules; len(rules) != 1 || rules[0].Pattern != "* components" {
+		t.Errorf("rules on the other replica = %+v, want the imported rule", rules)
+	}
+	written, err := os.ReadFile(other.config.MappingRulesFile)
+	if err != nil || string(written) != document {
+		t.Errorf("MAPPING_RULES_FILE of the other replica = %q, %v, want the imported document", written, err)
+	}
+
+	// Rules already in effect aren't written again
+	os.Remove(other.config.MappingRulesFile)
+	if err := other.syncMappingRules(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(other.config.MappingRulesFile); !os.IsNotExist(err) {
+		t.Errorf("unchanged rules rewrote MAPPING_RULES_FILE: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memcachedMaxRelativeExpiry is the longest expiry memcached reads as seconds from now; longer
+// ones must be sent as a Unix time
+const memcachedMaxRelativeExpiry = 30 * 24 * time.Hour
+
+// memcachedClient is a minimal client for the memcached text protocol covering the commands
+// this service needs. Keys are spread over the servers by hash. Like the Redis client, each
+// command uses its own connection.
+type memcachedClient struct {
+	servers []string
+}
+
+// newMemcachedClient parses a memcached:// URL listing one or more servers, e.g.
+// memcached://cache-1:11211,cache-2:11211
+func newMemcachedClient(rawURL string) (*memcachedClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Memcached URL: %w", err)
+	}
+	if u.Scheme != "memcached" {
+		return nil, fmt.Errorf("unsupported Memcached URL scheme: %s", u.Scheme)
+	}
+
+	client := &memcachedClient{}
+	for _, server := range strings.Split(u.Host, ",") {
+		if server = strings.TrimSpace(server); server == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "11211")
+		}
+		client.servers = append(client.servers, server)
+	}
+	if len(client.servers) == 0 {
+		return nil, errors.New("Memcached URL lists no servers")
+	}
+	return client, nil
+}
+
+// validMemcachedKey reports whether key can be sent as is: at most 250 bytes, without spaces or
+// control characters
+func validMemcachedKey(key string) bool {
+	if key == "" || len(key) > 250 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// do sends one command for key to the server owning it and passes the reply to handle
+func (c *memcachedClient) do(ctx context.Context, key, command string, handle func(reader *bufio.Reader) error) error {
+	if !validMemcachedKey(key) {
+		return fmt.Errorf("invalid Memcached key %q", key)
+	}
+	server := c.servers[crc32.ChecksumIEEE([]byte(key))%uint32(len(c.servers))]
+
+	conn, err := (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, "tcp", server)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Memcached: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+	}
+
+	if _, err := io.WriteString(conn, command); err != nil {
+		return fmt.Errorf("failed to write Memcached command: %w", err)
+	}
+	return handle(bufio.NewReader(conn))
+}
+
+// readMemcachedLine reads one reply line, turning error replies into errors
+func readMemcachedLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read Memcached reply: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", fmt.Errorf("Memcached error: %s", line)
+	}
+	return line, nil
+}
+
+// memcachedExpiry converts a TTL to memcached's expiry: seconds from now, or a Unix time beyond
+// 30 days
+func memcachedExpiry(ttl time.Duration, now time.Time) int64 {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	if ttl > memcachedMaxRelativeExpiry {
+		return now.Unix() + seconds
+	}
+	return seconds
+}
+
+// get returns the value of key
+func (c *memcachedClient) get(ctx context.Context, key string) (value string, found bool, err error) {
+	err = c.do(ctx, key, "get "+key+"\r\n", func(reader *bufio.Reader) error {
+		for {
+			line, err := readMemcachedLine(reader)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+
+			// VALUE <key> <flags> <bytes>
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[0] != "VALUE" {
+				return fmt.Errorf("unexpected Memcached reply: %q", line)
+			}
+			size, err := strconv.Atoi(fields[3])
+			if err != nil || size < 0 {
+				return fmt.Errorf("unexpected Memcached reply: %q", line)
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return fmt.Errorf("failed to read Memcached reply: %w", err)
+			}
+			value, found = string(data[:size]), true
+		}
+	})
+	return value, found, err
+}
+
+// set stores value under key, expiring after ttl
+func (c *memcachedClient) set(ctx context.Context, key, value string, ttl time.Duration) error {
+	request := fmt.Sprintf("set %s 0 %d %d\r\n%s\r\n", key, memcachedExpiry(ttl, time.Now()), len(value), value)
+	return c.do(ctx, key, request, func(reader *bufio.Reader) error {
+		line, err := readMemcachedLine(reader)
+		if err != nil {
+			return err
+		}
+		if line != "STORED" {
+			return fmt.Errorf("unexpected Memcached reply: %q", line)
+		}
+		return nil
+	})
+}
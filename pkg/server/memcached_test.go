@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached serves get and set from memory, recording the expiry of each item set
+type fakeMemcached struct {
+	addr string
+
+	mu      sync.Mutex
+	items   map[string]string
+	expiry  map[string]string
+	failing bool
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	m := &fakeMemcached{addr: listener.Addr().String(), items: make(map[string]string), expiry: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+	return m
+}
+
+func (m *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(line)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failing {
+		io.WriteString(conn, "SERVER_ERROR out of memory\r\n")
+		return
+	}
+	switch fields[0] {
+	case "get":
+		if value, found := m.items[fields[1]]; found {
+			fmt.Fprintf(conn, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(value), value)
+		}
+		io.WriteString(conn, "END\r\n")
+	case "set":
+		size, _ := strconv.Atoi(fields[4])
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return
+		}
+		m.items[fields[1]] = string(data[:size])
+		m.expiry[fields[1]] = fields[3]
+		io.WriteString(conn, "STORED\r\n")
+	default:
+		io.WriteString(conn, "ERROR\r\n")
+	}
+}
+
+func TestNewMemcachedClient(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    []string
+		wantErr bool
+	}{
+		{url: "memcached://cache-1:11211,cache-2:11212", want: []string{"cache-1:11211", "cache-2:11212"}},
+		{url: "memcached://cache-1,cache-2", want: []string{"cache-1:11211", "cache-2:11211"}},
+		{url: "redis://cache", wantErr: true},
+		{url: "memcached://", wantErr: true},
+	}
+	for _, test := range tests {
+		client, err := newMemcachedClient(test.url)
+		if (err != nil) != test.wantErr || !test.wantErr && !reflect.DeepEqual(client.servers, test.want) {
+			t.Errorf("newMemcachedClient(%q) = %+v, %v, want %q (error %v)", test.url, client, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestValidMemcachedKey(t *testing.T) {
+	tests := map[string]bool{
+		"delivery:msg_1":         true,
+		"":                       false,
+		"with space":             false,
+		"with\nnewline":          false,
+		"del\x7f":                false,
+		strings.Repeat("k", 250): true,
+		strings.Repeat("k", 251): false,
+	}
+	for key, want := range tests {
+		if valid := validMemcachedKey(key); valid != want {
+			t.Errorf("validMemcachedKey(%q) = %v, want %v", key, valid, want)
+		}
+	}
+}
+
+func TestMemcachedExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		ttl  time.Duration
+		want int64
+	}{
+		{ttl: 0, want: 1},
+		{ttl: 1500 * time.Millisecond, want: 2},
+		{ttl: time.Hour, want: 3600},
+		{ttl: memcachedMaxRelativeExpiry, want: int64(memcachedMaxRelativeExpiry / time.Second)},
+		{ttl: 31 * 24 * time.Hour, want: now.Unix() + 31*24*3600},
+	}
+	for _, test := range tests {
+		if expiry := memcachedExpiry(test.ttl, now); expiry != test.want {
+			t.Errorf("memcachedExpiry(%s) = %d, want %d", test.ttl, expiry, test.want)
+		}
+	}
+}
+
+func TestMemcachedClient(t *testing.T) {
+	server := newFakeMemcached(t)
+	client, err := newMemcachedClient("memcached://" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, found, err := client.get(ctx, "key"); err != nil || found {
+		t.Fatalf("get() of a missing key = %v, %v", found, err)
+	}
+	if err := client.set(ctx, "key", "two\r\nlines", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if value, found, err := client.get(ctx, "key"); err != nil || !found || value != "two\r\nlines" {
+		t.Errorf("get() = %q, %v, %v, want the value set", value, found, err)
+	}
+	server.mu.Lock()
+	expiry := server.expiry["key"]
+	server.failing = true
+	server.mu.Unlock()
+	if expiry != "3600" {
+		t.Errorf("expiry = %s, want 3600", expiry)
+	}
+
+	if err := client.set(ctx, "bad key", "1", time.Hour); err == nil {
+		t.Error("set() of an invalid key succeeded")
+	}
+	if _, _, err := client.get(ctx, "key"); err == nil || !strings.Contains(err.Error(), "SERVER_ERROR") {
+		t.Errorf("get() = %v, want the server error", err)
+	}
+}
+
+func TestMemcachedDeliveryStore(t *testing.T) {
+	server := newFakeMemcached(t)
+	client, err := newMemcachedClient("memcached://" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memcachedDeliveryStore{client: client, prefix: "delivery:"}
+	ctx := context.Background()
+
+	// IDs memcached can't take as keys are hashed
+	for _, deliveryID := range []string{"msg_1", "msg with spaces", strings.Repeat("m", 300)} {
+		if processed, err := store.DeliveryProcessed(ctx, deliveryID, time.Hour); err != nil || processed {
+			t.Fatalf("DeliveryProcessed(%q) = %v, %v before recording", deliveryID, processed, err)
+		}
+		if err := store.RecordDelivery(ctx, deliveryID, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if processed, err := store.DeliveryProcessed(ctx, deliveryID, time.Hour); err != nil || !processed {
+			t.Errorf("DeliveryProcessed(%q) = %v, %v after recording", deliveryID, processed, err)
+		}
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, found := server.items["delivery:msg_1"]; !found || len(server.items) != 3 {
+		t.Errorf("items = %v, want msg_1 as is and the others hashed", server.items)
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// Policies for writing component fields
+const (
+	// mergePolicyReplace overwrites the Jira field with the incident's current values
+	mergePolicyReplace = "replace"
+	// mergePolicyMerge adds the incident's values and removes only the values removed in
+	// incident.io, keeping values added to the Jira field by hand
+	mergePolicyMerge = "merge"
+)
+
+func validateMergePolicy(policy string) error {
+	switch policy {
+	case mergePolicyReplace, mergePolicyMerge:
+		return nil
+	}
+	return fmt.Errorf("unknown merge policy: %s", policy)
+}
+
+// tombstones remember catalog entries removed from an incident field until the removal has been
+// applied to the Jira issue, so a failed or retried write still removes them. The memory store
+// keeps them here; the Postgres store keeps them in its tombstones table.
+type tombstones struct {
+	mu      sync.Mutex
+	removed map[string]map[string]incidentio.CatalogEntry
+}
+
+func newTombstones() *tombstones {
+	return &tombstones{removed: make(map[string]map[string]incidentio.CatalogEntry)}
+}
+
+func tombstoneKey(jiraIssueKey, fieldName string) string {
+	return jiraIssueKey + "/" + fieldName
+}
+
+// add records removed catalog entries of an issue's field
+func (t *tombstones) add(jiraIssueKey, fieldName string, entries []incidentio.CatalogEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := tombstoneKey(jiraIssueKey, fieldName)
+	if t.removed[key] == nil {
+		t.removed[key] = make(map[string]incidentio.CatalogEntry)
+	}
+	for _, entry := range entries {
+		t.removed[key][entry.ID] = entry
+	}
+}
+
+// list returns the removed catalog entries of an issue's field
+func (t *tombstones) list(jiraIssueKey, fieldName string) []incidentio.CatalogEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var entries []incidentio.CatalogEntry
+	for _, entry := range t.removed[tombstoneKey(jiraIssueKey, fieldName)] {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// clear forgets removed catalog entries, once removed from Jira or added back in incident.io
+func (t *tombstones) clear(jiraIssueKey, fieldName string, catalogEntryIDs []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := tombstoneKey(jiraIssueKey, fieldName)
+	for _, id := range catalogEntryIDs {
+		delete(t.removed[key], id)
+	}
+	if len(t.removed[key]) == 0 {
+		delete(t.removed, key)
+	}
+}
+
+// recordRemovals compares the event's previous state with the incident and records a tombstone
+// for every catalog entry removed from a mapped field
+func (s *IncidentJiraSync) recordRemovals(previous *incidentio.Incident, incident incidentio.Incident, jiraIssueKey string) {
+	if previous == nil {
+		return
+	}
+
+	for _, previousEntry := range previous.CustomFieldEntries {
+		fieldName := previousEntry.CustomField.Name
+		if _, found := s.resolveFieldMapping(fieldName); !found {
+			continue
+		}
+
+		currentEntry, _ := incident.CustomFieldEntry(previousEntry.CustomField.ID)
+		if removed := incidentio.RemovedCatalogEntries(previousEntry, currentEntry); len(removed) > 0 {
+			log.Printf("%d values removed from %s, recording tombstones for %s", len(removed), fieldName, jiraIssueKey)
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			err := s.store.AddTombstones(ctx, jiraIssueKey, fieldName, removed)
+			cancel()
+			if err != nil {
+				log.Printf("Warning: removals from %s may not reach %s: %v", fieldName, jiraIssueKey, err)
+			}
+		}
+	}
+}
+
+// mergeJiraComponentValues adds values missing from each Jira field and removes the objects of
+// tombstoned catalog entries, leaving any other values in the field alone
+func (s *IncidentJiraSync) mergeJiraComponentValues(ctx context.Context, jiraIssueKey string, fieldIDs []string, values []jira.ComponentValue, currentEntryIDs []string, fieldMapping mapping.FieldMapping) error {
+	fieldName := fieldMapping.IncidentFieldName
+
+	// A value added back in incident.io is no longer removed
+	s.clearTombstones(jiraIssueKey, fieldName, currentEntryIDs)
+
+	// Objects of current entries stay, even when a removed entry shared them
+	current := make(map[string]bool, len(values))
+	for _, value := range values {
+		current[value.ObjectID] = true
+	}
+
+	var removals []jira.ComponentValue
+	var removedEntryIDs []string
+	storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	removed, err := s.store.Tombstones(storeCtx, jiraIssueKey, fieldName)
+	cancel()
+	if err != nil {
+		return err
+	}
+	for _, entry := range removed {
+		entry := entry
+		objects, err := s.resolveObjectIDs(ctx, jiraIssueKey, &entry, fieldMapping)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Failed to resolve removed catalog entry %s, leaving it in Jira: %v", entry.ID, err)
+			continue
+		}
+		for _, object := range objects {
+			if !current[object.ObjectID] {
+				removals = append(removals, object)
+			}
+		}
+		removedEntryIDs = append(removedEntryIDs, entry.ID)
+	}
+
+	var issue struct {
+		Fields map[string][]jira.ComponentValue `json:"fields"`
+	}
+	path := fmt.Sprintf("%s?fields=%s", jira.IssuePath(jiraIssueKey), strings.Join(fieldIDs, ","))
+	if err := s.jira.GetFresh(ctx, path, &issue); err != nil {
+		return fmt.Errorf("failed to read current values: %w", err)
+	}
+
+	update := jira.UpdateRequest{Update: make(map[string][]map[string]interface{})}
+	resulting := make(map[string]interface{})
+	for _, fieldID := range fieldIDs {
+		inJira := make(map[string]bool)
+		for _, value := range issue.Fields[fieldID] {
+			inJira[value.ObjectID] = true
+		}
+
+		var operations []map[string]interface{}
+		for _, value := range s.inFieldWorkspace(ctx, jiraIssueKey, fieldID, values) {
+			if !inJira[value.ObjectID] {
+				operations = append(operations, map[string]interface{}{"add": value})
+				inJira[value.ObjectID] = true
+			}
+		}
+		for _, value := range s.inFieldWorkspace(ctx, jiraIssueKey, fieldID, removals) {
+			if inJira[value.ObjectID] {
+				operations = append(operations, map[string]interface{}{"remove": value})
+				delete(inJira, value.ObjectID)
+			}
+		}
+		if len(operations) == 0 {
+			continue
+		}
+		update.Update[fieldID] = operations
+
+		var result []jira.ComponentValue
+		for objectID := range inJira {
+			result = append(result, s.formatJiraComponentValue(objectID, ""))
+		}
+		resulting[fieldID] = s.inFieldWorkspace(ctx, jiraIssueKey, fieldID, result)
+	}
+
+	if len(update.Update) == 0 {
+		log.Printf("%s already up to date in %s, skipping merge", fieldName, jiraIssueKey)
+		s.clearTombstones(jiraIssueKey, fieldName, removedEntryIDs)
+		return nil
+	}
+
+	if s.config.SyncMarkerEnabled {
+		if err := s.writeSyncMarker(ctx, jiraIssueKey, resulting); err != nil {
+			log.Printf("Warning: failed to write sync marker on %s: %v", jiraIssueKey, err)
+		}
+	}
+
+	if err := s.updateJiraIssue(ctx, jiraIssueKey, update); err != nil {
+		return err
+	}
+
+	s.clearTombstones(jiraIssueKey, fieldName, removedEntryIDs)
+	return nil
+}
+
+// clearTombstones forgets removed catalog entries of an issue's field. Failing to is only
+// logged: the removal is applied again on the next merge, which finds nothing to remove.
+func (s *IncidentJiraSync) clearTombstones(jiraIssueKey, fieldName string, catalogEntryIDs []string) {
+	if len(catalogEntryIDs) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := s.store.ClearTombstones(ctx, jiraIssueKey, fieldName, catalogEntryIDs); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
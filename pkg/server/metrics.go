@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A minimal Prometheus text-format metrics registry, so the service stays free of
+// external dependencies
+
+// metricVec is a counter or gauge with a fixed set of label names
+type metricVec struct {
+	name       string
+	help       string
+	metricType string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+const labelSeparator = "\xff"
+
+var (
+	metricsMu       sync.Mutex
+	registeredVecs  []*metricVec
+	labelValueQuote = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+func newMetricVec(metricType, name, help string, labels ...string) *metricVec {
+	vec := &metricVec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labels:     labels,
+		values:     make(map[string]float64),
+	}
+
+	metricsMu.Lock()
+	registeredVecs = append(registeredVecs, vec)
+	metricsMu.Unlock()
+
+	return vec
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	return newMetricVec("counter", name, help, labels...)
+}
+
+func newGaugeVec(name, help string, labels ...string) *metricVec {
+	return newMetricVec("gauge", name, help, labels...)
+}
+
+// add increases the series identified by labelValues by delta
+func (v *metricVec) add(delta float64, labelValues ...string) {
+	key := strings.Join(labelValues, labelSeparator)
+	v.mu.Lock()
+	v.values[key] += delta
+	v.mu.Unlock()
+	forwardMetric(v, true, delta, labelValues)
+}
+
+func (v *metricVec) inc(labelValues ...string) {
+	v.add(1, labelValues...)
+}
+
+// set replaces the value of the series identified by labelValues
+func (v *metricVec) set(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, labelSeparator)
+	v.mu.Lock()
+	v.values[key] = value
+	v.mu.Unlock()
+	forwardMetric(v, false, value, labelValues)
+}
+
+// write renders the vector in Prometheus text exposition format
+func (v *metricVec) write(b *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.metricType)
+
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b.WriteString(v.name)
+		if len(v.labels) > 0 {
+			labelValues := strings.Split(key, labelSeparator)
+			pairs := make([]string, len(v.labels))
+			for i, label := range v.labels {
+				var value string
+				if i < len(labelValues) {
+					value = labelValues[i]
+				}
+				pairs[i] = fmt.Sprintf(`%s="%s"`, label, labelValueQuote.Replace(value))
+			}
+			fmt.Fprintf(b, "{%s}", strings.Join(pairs, ","))
+		}
+		fmt.Fprintf(b, " %g\n", v.values[key])
+	}
+}
+
+// metricsHandler serves all registered metrics for Prometheus scraping
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	metricsMu.Lock()
+	vecs := append([]*metricVec(nil), registeredVecs...)
+	metricsMu.Unlock()
+
+	for _, vec := range vecs {
+		vec.write(&b)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// Service metrics
+var (
+	webhookEventsTotal = newCounterVec(
+		"incident_jira_webhook_events_total",
+		"Webhook events received for processing, by event type and outcome.",
+		"event_type", "outcome")
+	webhookEventsIgnoredTotal = newCounterVec(
+		"incident_jira_webhook_events_ignored_total",
+		"Webhook events ignored because their type is unknown or not subscribed to, or they were already processed.",
+		"event_type", "reason")
+)
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// backfillCheckpointMigrations upgrade a checkpoint file one version at a time; the index is
+// the version migrated from, so the current version is their count. Append a migration
+// whenever backfillCheckpoint changes in a way older files can't be read as.
+var backfillCheckpointMigrations = []func(checkpoint map[string]interface{}) error{
+	// Files written before checkpoints were versioned already have the version 1 layout
+	func(map[string]interface{}) error { return nil },
+}
+
+// backfillCheckpointVersion is the checkpoint file format written by this release
+var backfillCheckpointVersion = len(backfillCheckpointMigrations)
+
+// migrateBackfillCheckpoint upgrades a checkpoint file's JSON to the current version,
+// refusing files written by a newer release
+func migrateBackfillCheckpoint(data []byte) ([]byte, int, error) {
+	var checkpoint map[string]interface{}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, 0, err
+	}
+	version := 0
+	if number, isNumber := checkpoint["version"].(float64); isNumber {
+		version = int(number)
+	}
+	if version > backfillCheckpointVersion {
+		return nil, version, fmt.Errorf("checkpoint version %d is newer than this release (%d)", version, backfillCheckpointVersion)
+	}
+	if version == backfillCheckpointVersion {
+		return data, version, nil
+	}
+
+	for i := version; i < backfillCheckpointVersion; i++ {
+		if err := backfillCheckpointMigrations[i](checkpoint); err != nil {
+			return nil, version, fmt.Errorf("checkpoint migration %d failed: %w", i+1, err)
+		}
+	}
+	checkpoint["version"] = backfillCheckpointVersion
+	migrated, err := json.Marshal(checkpoint)
+	return migrated, version, err
+}
+
+// Migrate brings the persisted state up to this release's formats and returns, so upgrades can
+// migrate once, before new replicas start (--migrate-only). It migrates the Postgres state
+// store's schema and BACKFILL_CHECKPOINT_FILE; the other stores keep nothing that changes
+// between releases.
+func Migrate(config Config) error {
+	if config.StateStore == storePostgres {
+		store, err := openPostgresStore(config.StateStoreURL, true)
+		if err != nil {
+			return err
+		}
+		store.Close()
+	}
+
+	if path := config.BackfillCheckpointFile; path != "" {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+		migrated, version, err := migrateBackfillCheckpoint(data)
+		if err != nil {
+			return fmt.Errorf("invalid checkpoint %s: %w", path, err)
+		}
+		if version == backfillCheckpointVersion {
+			return nil
+		}
+		var checkpoint backfillCheckpoint
+		if err := json.Unmarshal(migrated, &checkpoint); err != nil {
+			return fmt.Errorf("invalid checkpoint %s: %w", path, err)
+		}
+		if err := saveBackfillCheckpoint(path, checkpoint); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+		log.Printf("Migrated backfill checkpoint from version %d to %d", version, backfillCheckpointVersion)
+	}
+	return nil
+}
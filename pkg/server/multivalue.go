@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// handleRejectedMultipleValues applies the mapping's multi-value policy after Jira rejected a
+// write of several values
+func (s *IncidentJiraSync) handleRejectedMultipleValues(ctx context.Context, jiraIssueKey string, fieldIDs []string, values []jira.ComponentValue, fieldMapping mapping.FieldMapping, writeErr error) error {
+	policy := fieldMapping.MultiValuePolicyOr(s.config.MultiValuePolicy)
+	multiValueFallbacksTotal.inc(fieldMapping.IncidentFieldName, policy)
+
+	switch policy {
+	case mapping.MultiValueFail:
+		return fmt.Errorf("Jira rejected %d values for %s: %w", len(values), fieldMapping.IncidentFieldName, writeErr)
+
+	case mapping.MultiValueAppend:
+		log.Printf("Multiple values failed, writing %d values to %s one at a time", len(values), jiraIssueKey)
+		return s.appendJiraFieldValues(ctx, jiraIssueKey, fieldIDs, values)
+	}
+
+	log.Printf("Multiple values failed, trying with single value: %+v", values[0])
+	if err := s.updateJiraCustomField(ctx, jiraIssueKey, fieldIDs, values[:1]); err != nil {
+		return err
+	}
+
+	if policy == mapping.MultiValueFirstWithComment {
+		dropped := make([]string, 0, len(values)-1)
+		for _, value := range values[1:] {
+			dropped = append(dropped, value.ObjectID)
+		}
+		data := templateData{Field: fieldMapping.IncidentFieldName, Kept: values[0].ObjectID, Dropped: dropped}
+		if err := s.addTemplatedComment(ctx, jiraIssueKey, templateMultiValueComment, data); err != nil {
+			log.Printf("Warning: failed to comment dropped values on %s: %v", jiraIssueKey, err)
+		}
+	}
+
+	return nil
+}
+
+// appendJiraFieldValues sets the first value and adds each remaining value in its own request,
+// for fields that accept the add operation but not a multi-value set
+func (s *IncidentJiraSync) appendJiraFieldValues(ctx context.Context, jiraIssueKey string, fieldIDs []string, values []jira.ComponentValue) error {
+	if s.config.SyncMarkerEnabled {
+		fields := make(map[string]interface{}, len(fieldIDs))
+		for _, fieldID := range fieldIDs {
+			fields[fieldID] = s.inFieldWorkspace(ctx, jiraIssueKey, fieldID, values)
+		}
+		if err := s.writeSyncMarker(ctx, jiraIssueKey, fields); err != nil {
+			log.Printf("Warning: failed to write sync marker on %s: %v", jiraIssueKey, err)
+		}
+	}
+
+	// A field's first value found in its workspace replaces what the field held
+	set := make(map[string]bool, len(fieldIDs))
+	for i := range values {
+		payload := jira.UpdateRequest{Update: make(map[string][]map[string]interface{}, len(fieldIDs))}
+		for _, fieldID := range fieldIDs {
+			inWorkspace := s.inFieldWorkspace(ctx, jiraIssueKey, fieldID, values[i:i+1])
+			if len(inWorkspace) == 0 {
+				continue
+			}
+			value := inWorkspace[0]
+			operation := map[string]interface{}{"add": value}
+			if !set[fieldID] {
+				operation = map[string]interface{}{"set": []jira.ComponentValue{value}}
+				set[fieldID] = true
+			}
+			payload.Update[fieldID] = []map[string]interface{}{operation}
+		}
+		if len(payload.Update) == 0 {
+			continue
+		}
+
+		if err := s.updateJiraIssue(ctx, jiraIssueKey, payload); err != nil {
+			return fmt.Errorf("failed to append value %d of %d: %w", i+1, len(values), err)
+		}
+	}
+	return nil
+}
+
+var multiValueFallbacksTotal = newCounterVec(
+	"incident_jira_webhook_multi_value_fallbacks_total",
+	"Writes where Jira rejected multiple values, by incident field and the policy applied.",
+	"field", "policy")
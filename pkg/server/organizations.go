@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// IncidentOrganizationConfig is an incident.io organization synced besides the one of
+// INCIDENT_API_TOKEN, named in INCIDENT_ORGANIZATIONS. Its webhooks are delivered to
+// /webhook/{name} and share the Jira target and mapping rules.
+type IncidentOrganizationConfig struct {
+	Name          string
+	APIToken      string
+	WebhookSecret string
+	// CatalogWarmTypes are the organization's catalog types cached, as CATALOG_WARM_TYPES
+	CatalogWarmTypes map[string]bool
+}
+
+// incidentOrganizationEnv returns the variable holding a setting of a named organization, e.g.
+// INCIDENT_ORG_ACME_EU_API_TOKEN for "acme-eu"
+func incidentOrganizationEnv(name, setting string) string {
+	return "INCIDENT_ORG_" + credentialEnvSuffix(name) + "_" + setting
+}
+
+// loadIncidentOrganizations reads the settings of each organization named in
+// INCIDENT_ORGANIZATIONS
+func loadIncidentOrganizations(names string) map[string]IncidentOrganizationConfig {
+	organizations := make(map[string]IncidentOrganizationConfig)
+	for name := range parseList(names) {
+		organizations[name] = IncidentOrganizationConfig{
+			Name:             name,
+			APIToken:         os.Getenv(incidentOrganizationEnv(name, "API_TOKEN")),
+			WebhookSecret:    os.Getenv(incidentOrganizationEnv(name, "WEBHOOK_SECRET")),
+			CatalogWarmTypes: parseList(os.Getenv(incidentOrganizationEnv(name, "CATALOG_WARM_TYPES"))),
+		}
+	}
+	return organizations
+}
+
+// validateIncidentOrganizations checks that every organization has an API key and a name that
+// can be a path segment, and a signing secret when webhooks are verified with hmac
+func validateIncidentOrganizations(config Config) error {
+	suffixes := make(map[string]string)
+	for name, organization := range config.IncidentOrganizations {
+		if name == defaultIncidentCredential || strings.ContainsAny(name, "/?#%") {
+			return fmt.Errorf("invalid organization name %q in INCIDENT_ORGANIZATIONS", name)
+		}
+		suffix := credentialEnvSuffix(name)
+		if other, taken := suffixes[suffix]; taken {
+			return fmt.Errorf("organizations %s and %s in INCIDENT_ORGANIZATIONS share the variables INCIDENT_ORG_%s_*", other, name, suffix)
+		}
+		suffixes[suffix] = name
+
+		if organization.APIToken == "" {
+			return fmt.Errorf("%s environment variable is required for incident.io organization %s", incidentOrganizationEnv(name, "API_TOKEN"), name)
+		}
+		if len(organization.CatalogWarmTypes) > 0 && (config.CatalogWarmInterval <= 0 || config.CatalogCacheTTL <= config.CatalogWarmInterval) {
+			return errors.New("CATALOG_WARM_INTERVAL must be positive and shorter than CATALOG_CACHE_TTL")
+		}
+		if auth, configured := config.EndpointAuth[endpointWebhook]; configured && auth.checksSignature(config.SignatureEnforcement) && organization.WebhookSecret == "" {
+			return fmt.Errorf("%s environment variable is required to verify the webhooks of incident.io organization %s", incidentOrganizationEnv(name, "WEBHOOK_SECRET"), name)
+		}
+	}
+	return nil
+}
+
+// incidentOrganization is an incident.io organization the service syncs: its API client, with
+// a connection pool and rate limit budget of its own, and its catalog cache
+type incidentOrganization struct {
+	// name is empty for the organization of INCIDENT_API_TOKEN
+	name       string
+	client     *incidentio.Client
+	budget     *rateBudget
+	catalog    *catalogCache
+	warmTypes  map[string]bool
+	webhookURL string
+	// secretEnv is the variable holding the signing secret of the organization's webhooks
+	secretEnv string
+	secretSet bool
+}
+
+// label names the organization in logs
+func (o *incidentOrganization) label() string {
+	if o.name == "" {
+		return defaultIncidentCredential
+	}
+	return o.name
+}
+
+// newIncidentOrganization builds the client and catalog cache of an INCIDENT_ORGANIZATIONS entry
+func newIncidentOrganization(config Config, organization IncidentOrganizationConfig, redact func([]byte) string) *incidentOrganization {
+	budget := newRateBudget(0, config.IncidentHTTP.RetryAfterMax)
+	client := incidentio.NewClientWithRetries(organization.APIToken, newHTTPClient(upstreamIncident, config.IncidentHTTP, budget), incidentio.RetryConfig{})
+	client.BaseURL = config.IncidentAPIBaseURL
+	client.Pagination = incidentio.Pagination{PageSize: config.IncidentPageSize, MaxPages: config.MaxListPages}
+	client.Redact = redact
+
+	var catalog *catalogCache
+	if len(organization.CatalogWarmTypes) > 0 {
+		catalog = &catalogCache{ttl: config.CatalogCacheTTL, entries: make(map[string]cachedCatalogEntry)}
+	}
+	return &incidentOrganization{
+		name:       organization.Name,
+		client:     client,
+		budget:     budget,
+		catalog:    catalog,
+		warmTypes:  organization.CatalogWarmTypes,
+		webhookURL: "/webhook/" + organization.Name,
+		secretEnv:  incidentOrganizationEnv(organization.Name, "WEBHOOK_SECRET"),
+		secretSet:  organization.WebhookSecret != "",
+	}
+}
+
+type organizationKey struct{}
+
+// withOrganization marks ctx as processing an incident of the named organization
+func withOrganization(ctx context.Context, name string) context.Context {
+	if name == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, organizationKey{}, name)
+}
+
+// organizationName returns the organization ctx is processing an incident of, empty for the
+// organization of INCIDENT_API_TOKEN
+func organizationName(ctx context.Context) string {
+	name, _ := ctx.Value(organizationKey{}).(string)
+	return name
+}
+
+// organizationNamed returns a configured organization, or that of INCIDENT_API_TOKEN for an
+// empty or unknown name
+func (s *IncidentJiraSync) organizationNamed(name string) *incidentOrganization {
+	if organization, found := s.organizations[name]; found {
+		return organization
+	}
+	return s.defaultOrganization
+}
+
+// organization returns the organization ctx is processing an incident of
+func (s *IncidentJiraSync) organization(ctx context.Context) *incidentOrganization {
+	return s.organizationNamed(organizationName(ctx))
+}
+
+// incidentClient returns the incident.io client of the organization ctx is processing
+func (s *IncidentJiraSync) incidentClient(ctx context.Context) *incidentio.Client {
+	return s.organization(ctx).client
+}
+
+// allOrganizations returns every synced organization, that of INCIDENT_API_TOKEN first
+func (s *IncidentJiraSync) allOrganizations() []*incidentOrganization {
+	organizations := []*incidentOrganization{s.defaultOrganization}
+	names := make([]string, 0, len(s.organizations))
+	for name := range s.organizations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		organizations = append(organizations, s.organizations[name])
+	}
+	return organizations
+}
+
+// requestOrganization returns the organization an admin request names with ?organization=, or
+// that of INCIDENT_API_TOKEN without one
+func (s *IncidentJiraSync) requestOrganization(r *http.Request) (*incidentOrganization, error) {
+	return s.lookupOrganization(r.URL.Query().Get("organization"))
+}
+
+// lookupOrganization returns the organization of INCIDENT_ORGANIZATIONS with a name, or that of
+// INCIDENT_API_TOKEN for no name or "default"
+func (s *IncidentJiraSync) lookupOrganization(name string) (*incidentOrganization, error) {
+	if name == "" || name == defaultIncidentCredential {
+		return s.defaultOrganization, nil
+	}
+	organization, found := s.organizations[name]
+	if !found {
+		return nil, fmt.Errorf("unknown organization %q", name)
+	}
+	return organization, nil
+}
+
+// organizationWebhookHandler handles the webhooks of a named organization, delivered to
+// /webhook/{name}
+func (s *IncidentJiraSync) organizationWebhookHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.webhookHandler(w, r.WithContext(withOrganization(r.Context(), name)))
+	}
+}
+
+// organizationWebhookAuth is the authentication chain of /webhook/{name}: that of /webhook,
+// verifying signatures with the organization's signing secret
+func (s *IncidentJiraSync) organizationWebhookAuth(organization IncidentOrganizationConfig) (EndpointAuth, bool) {
+	auth, configured := s.config.EndpointAuth[endpointWebhook]
+	if organization.Name == "" || organization.WebhookSecret == "" {
+		return auth, configured
+	}
+	if !configured {
+		auth.Checks = []string{authHMAC}
+	}
+	auth.HMACSecret = organization.WebhookSecret
+	return auth, true
+}
+
+// organizationStatus is the state of an organization, for /admin/status
+type organizationStatus struct {
+	Webhook        string           `json:"webhook"`
+	CatalogEntries int              `json:"catalog_entries"`
+	RateLimit      rateBudgetStatus `json:"rate_limit"`
+}
+
+func (s *IncidentJiraSync) organizationStatuses() map[string]organizationStatus {
+	statuses := make(map[string]organizationStatus, len(s.organizations))
+	for name, organization := range s.organizations {
+		statuses[name] = organizationStatus{
+			Webhook:        organization.webhookURL,
+			CatalogEntries: organization.catalog.size(),
+			RateLimit:      organization.budget.status(),
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadIncidentOrganizations(t *testing.T) {
+	t.Setenv("INCIDENT_ORG_ACME_EU_API_TOKEN", "eu-token")
+	t.Setenv("INCIDENT_ORG_ACME_EU_WEBHOOK_SECRET", "eu-secret")
+	t.Setenv("INCIDENT_ORG_ACME_EU_CATALOG_WARM_TYPES", "Service,Team")
+	t.Setenv("INCIDENT_ORG_ACME_US_API_TOKEN", "us-token")
+
+	organizations := loadIncidentOrganizations("acme-eu, acme-us")
+	want := map[string]IncidentOrganizationConfig{
+		"acme-eu": {
+			Name:             "acme-eu",
+			APIToken:         "eu-token",
+			WebhookSecret:    "eu-secret",
+			CatalogWarmTypes: map[string]bool{"Service": true, "Team": true},
+		},
+		"acme-us": {Name: "acme-us", APIToken: "us-token", CatalogWarmTypes: map[string]bool{}},
+	}
+	if !reflect.DeepEqual(organizations, want) {
+		t.Errorf("organizations = %+v, want %+v", organizations, want)
+	}
+}
+
+func TestValidateIncidentOrganizations(t *testing.T) {
+	hmacWebhook := map[string]EndpointAuth{endpointWebhook: {Checks: []string{authHMAC}, HMACSecret: "secret"}}
+
+	tests := []struct {
+		name          string
+		organizations []IncidentOrganizationConfig
+		auth          map[string]EndpointAuth
+		enforcement   string
+		want          string
+	}{
+		{
+			name:          "valid",
+			organizations: []IncidentOrganizationConfig{{Name: "acme-eu", APIToken: "token"}},
+		},
+		{
+			name:          "reserved name",
+			organizations: []IncidentOrganizationConfig{{Name: "default", APIToken: "token"}},
+			want:          `invalid organization name "default"`,
+		},
+		{
+			name:          "name that isn't a path segment",
+			organizations: []IncidentOrganizationConfig{{Name: "acme/eu", APIToken: "token"}},
+			want:          "invalid organization name",
+		},
+		{
+			name: "names sharing variables",
+			organizations: []IncidentOrganizationConfig{
+				{Name: "acme-eu", APIToken: "token"},
+				{Name: "acme_eu", APIToken: "token"},
+			},
+			want: "share the variables INCIDENT_ORG_ACME_EU_*",
+		},
+		{
+			name:          "missing API token",
+			organizations: []IncidentOrganizationConfig{{Name: "acme-eu"}},
+			want:          "INCIDENT_ORG_ACME_EU_API_TOKEN environment variable is required",
+		},
+		{
+			name:          "missing signing secret",
+			organizations: []IncidentOrganizationConfig{{Name: "acme-eu", APIToken: "token"}},
+			auth:          hmacWebhook,
+			enforcement:   signatureEnforcementEnforce,
+			want:          "INCIDENT_ORG_ACME_EU_WEBHOOK_SECRET environment variable is required",
+		},
+		{
+			name:          "signatures not checked",
+			organizations: []IncidentOrganizationConfig{{Name: "acme-eu", APIToken: "token"}},
+			auth:          hmacWebhook,
+			enforcement:   signatureEnforcementOff,
+		},
+	}
+	for _, test := range tests {
+		config := Config{
+			IncidentOrganizations: make(map[string]IncidentOrganizationConfig),
+			EndpointAuth:          test.auth,
+			SignatureEnforcement:  test.enforcement,
+		}
+		for _, organization := range test.organizations {
+			config.IncidentOrganizations[organization.Name] = organization
+		}
+
+		err := validateIncidentOrganizations(config)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: err = %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestOrganizationRouting(t *testing.T) {
+	config := Config{IncidentAPIBaseURL: "https://api.incident.io"}
+	defaultOrganization := newIncidentOrganization(config, IncidentOrganizationConfig{APIToken: "default-token"}, nil)
+	acme := newIncidentOrganization(config, IncidentOrganizationConfig{Name: "acme-eu", APIToken: "eu-token"}, nil)
+	s := &IncidentJiraSync{
+		defaultOrganization: defaultOrganization,
+		organizations:       map[string]*incidentOrganization{"acme-eu": acme},
+	}
+
+	ctx := context.Background()
+	if client := s.incidentClient(ctx); client.APIToken != "default-token" {
+		t.Errorf("client without an organization uses %q, want the default token", client.APIToken)
+	}
+	if client := s.incidentClient(withOrganization(ctx, "acme-eu")); client.APIToken != "eu-token" {
+		t.Errorf("client of acme-eu uses %q, want its token", client.APIToken)
+	}
+	if acme.webhookURL != "/webhook/acme-eu" || acme.label() != "acme-eu" || defaultOrganization.label() != defaultIncidentCredential {
+		t.Errorf("acme-eu receives webhooks at %s as %s, default as %s", acme.webhookURL, acme.label(), defaultOrganization.label())
+	}
+
+	for _, name := range []string{"", defaultIncidentCredential} {
+		if organization, err := s.lookupOrganization(name); err != nil || organization != defaultOrganization {
+			t.Errorf("lookup of %q = %v, %v, want the default organization", name, organization, err)
+		}
+	}
+	if organization, err := s.lookupOrganization("acme-eu"); err != nil || organization != acme {
+		t.Errorf("lookup of acme-eu = %v, %v, want acme-eu", organization, err)
+	}
+	if _, err := s.lookupOrganization("acme-us"); err == nil {
+		t.Error("lookup of an unknown organization succeeded")
+	}
+	// Backfills are started for known organizations only
+	if _, err := s.startBackfill(backfillRequest{Organization: "acme-us"}); err == nil || !strings.Contains(err.Error(), "unknown organization") {
+		t.Errorf("backfill of an unknown organization: err = %v", err)
+	}
+
+	if names := []string{s.allOrganizations()[0].label(), s.allOrganizations()[1].label()}; !reflect.DeepEqual(names, []string{defaultIncidentCredential, "acme-eu"}) {
+		t.Errorf("organizations = %v, want the default first", names)
+	}
+}
+
+func TestOrganizationWebhookAuth(t *testing.T) {
+	s := &IncidentJiraSync{config: Config{EndpointAuth: map[string]EndpointAuth{
+		endpointWebhook: {Checks: []string{authHMAC, authBearer}, HMACSecret: "default-secret"},
+	}}}
+
+	auth, configured := s.organizationWebhookAuth(IncidentOrganizationConfig{Name: "acme-eu", WebhookSecret: "eu-secret"})
+	if !configured || auth.HMACSecret != "eu-secret" || !reflect.DeepEqual(auth.Checks, []string{authHMAC, authBearer}) {
+		t.Errorf("auth = %+v, %v, want /webhook's checks with the organization's secret", auth, configured)
+	}
+	// The organization's secret doesn't leak into the shared configuration
+	if secret := s.config.EndpointAuth[endpointWebhook].HMACSecret; secret != "default-secret" {
+		t.Errorf("/webhook secret = %q, want default-secret", secret)
+	}
+
+	s.config.EndpointAuth = nil
+	if auth, configured := s.organizationWebhookAuth(IncidentOrganizationConfig{Name: "acme-eu", WebhookSecret: "eu-secret"}); !configured || !reflect.DeepEqual(auth.Checks, []string{authHMAC}) {
+		t.Errorf("auth without /webhook checks = %+v, %v, want hmac", auth, configured)
+	}
+	if _, configured := s.organizationWebhookAuth(IncidentOrganizationConfig{Name: "acme-eu"}); configured {
+		t.Error("organization without a secret or /webhook checks is authenticated")
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// outboxBatchSize is how many outbox messages a dispatcher claims at a time
+const outboxBatchSize = 10
+
+// outboxMessage is a write back to incident.io waiting in the outbox. Messages are committed to
+// the state store together with the local state they imply, and delivered by the dispatcher
+// until incident.io accepts them, oldest first for each incident.
+type outboxMessage struct {
+	ID           int64                  `json:"id"`
+	Organization string                 `json:"organization,omitempty"`
+	IncidentID   string                 `json:"incident_id"`
+	Description  string                 `json:"description"`
+	Edit         incidentio.EditRequest `json:"edit"`
+	Attempts     int                    `json:"attempts"`
+	CreatedAt    time.Time              `json:"created_at"`
+	NextAttempt  time.Time              `json:"next_attempt"`
+	LastError    string                 `json:"last_error,omitempty"`
+}
+
+// writtenValue is a last-written attribute value committed with an outbox message
+type writtenValue struct {
+	Key       string
+	Attribute string
+	Value     string
+}
+
+// outboxBacklog summarises the messages waiting in the outbox, for /admin/status
+type outboxBacklog struct {
+	Pending int `json:"pending"`
+	// Oldest is when the oldest waiting message was queued
+	Oldest *time.Time `json:"oldest,omitempty"`
+	// DeadLettered counts the messages given up, kept in the store for inspection
+	DeadLettered int `json:"dead_lettered"`
+}
+
+// enqueueIncidentEdit commits an edit of an incident to the outbox, in the organization ctx is
+// processing, in one transaction with the values it records as written, and wakes the
+// dispatcher
+func (s *IncidentJiraSync) enqueueIncidentEdit(ctx context.Context, incidentID, description string, edit incidentio.EditRequest, written ...writtenValue) error {
+	now := time.Now().UTC()
+	message := outboxMessage{
+		Organization: organizationName(ctx),
+		IncidentID:   incidentID,
+		Description:  description,
+		Edit:         edit,
+		CreatedAt:    now,
+		NextAttempt:  now,
+	}
+
+	storeCtx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := s.store.EnqueueOutbox(storeCtx, message, written); err != nil {
+		return err
+	}
+	outboxMessagesTotal.inc("queued")
+
+	select {
+	case s.outboxWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// runOutboxDispatcher delivers outbox messages as they are queued and every
+// OUTBOX_POLL_INTERVAL, so messages left by a failed attempt or by another replica are picked up
+func (s *IncidentJiraSync) runOutboxDispatcher() {
+	ticker := time.NewTicker(s.config.OutboxPollInterval)
+	defer ticker.Stop()
+
+	s.dispatchOutbox()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.outboxWake:
+		}
+		s.dispatchOutbox()
+	}
+}
+
+// outboxLease returns how long claimed outbox messages are leased for: long enough to deliver a
+// batch
+func (s *IncidentJiraSync) outboxLease() time.Duration {
+	return s.config.ProcessingTimeout*outboxBatchSize + time.Minute
+}
+
+// dispatchOutbox delivers the messages that are due until none are left. Claimed messages are
+// leased for long enough to deliver the batch, so other replicas skip them meanwhile.
+func (s *IncidentJiraSync) dispatchOutbox() {
+	s.outboxProgress.begin()
+	defer s.outboxProgress.end()
+	defer s.updateOutboxGauge()
+
+	lease := s.outboxLease()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		messages, err := s.store.ClaimOutbox(ctx, outboxBatchSize, lease)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			return
+		}
+		if len(messages) == 0 {
+			return
+		}
+		for _, message := range messages {
+			s.deliverOutboxMessage(message)
+		}
+	}
+}
+
+// deliverOutboxMessage sends one message to incident.io, removing it from the outbox once
+// accepted and otherwise scheduling another attempt after a backoff. A message incident.io
+// rejects outright, e.g. for an incident or custom field that no longer exists, or that has
+// failed OUTBOX_MAX_ATTEMPTS times, is dead-lettered so the incident's later messages go ahead.
+func (s *IncidentJiraSync) deliverOutboxMessage(message outboxMessage) {
+	ctx, cancel := s.processingContext(withOrganization(context.Background(), message.Organization))
+	err := s.incidentClient(ctx).EditIncident(ctx, message.IncidentID, message.Edit)
+	cancel()
+
+	storeCtx, storeCancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer storeCancel()
+
+	if err == nil {
+		log.Printf("Delivered %s to incident %s", message.Description, message.IncidentID)
+		outboxMessagesTotal.inc("delivered")
+		if err := s.store.CompleteOutbox(storeCtx, message.ID); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		return
+	}
+
+	message.Attempts++
+	if incidentio.IsPermanent(err) || message.Attempts >= s.config.OutboxMaxAttempts {
+		log.Printf("Giving up on %s to incident %s after %d attempts: %v", message.Description, message.IncidentID, message.Attempts, err)
+		outboxMessagesTotal.inc("dead_lettered")
+		if err := s.store.DeadLetterOutbox(storeCtx, message.ID, message.Attempts, err.Error()); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		return
+	}
+	delay := backoffDelay(s.config.OutboxBaseDelay, s.config.OutboxMaxDelay, message.Attempts-1)
+	log.Printf("Failed to deliver %s to incident %s (attempt %d), retrying in %s: %v", message.Description, message.IncidentID, message.Attempts, delay, err)
+	outboxMessagesTotal.inc("failed")
+	if err := s.store.FailOutbox(storeCtx, message.ID, message.Attempts, time.Now().Add(delay), err.Error()); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// outboxBacklog returns what is waiting in the outbox, or nil if the store can't be read
+func (s *IncidentJiraSync) outboxBacklog() *outboxBacklog {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	backlog, err := s.store.OutboxBacklog(ctx)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	return &backlog
+}
+
+func (s *IncidentJiraSync) updateOutboxGauge() {
+	if backlog := s.outboxBacklog(); backlog != nil {
+		outboxPendingGauge.set(float64(backlog.Pending))
+		outboxDeadLetteredGauge.set(float64(backlog.DeadLettered))
+	}
+}
+
+var (
+	outboxMessagesTotal = newCounterVec(
+		"incident_jira_webhook_outbox_messages_total",
+		"Writes back to incident.io through the outbox, by outcome (queued, delivered, failed, dead_lettered).",
+		"outcome")
+	outboxPendingGauge = newGaugeVec(
+		"incident_jira_webhook_outbox_pending",
+		"Writes back to incident.io waiting in the outbox.")
+	outboxDeadLetteredGauge = newGaugeVec(
+		"incident_jira_webhook_outbox_dead_lettered",
+		"Writes back to incident.io given up and kept in the outbox for inspection.")
+)
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+func TestDeliverOutboxMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		attempts     int
+		wantPending  int
+		wantDead     int
+		wantAttempts int
+	}{
+		{name: "delivered", status: http.StatusOK},
+		{name: "unavailable", status: http.StatusServiceUnavailable, wantPending: 1, wantAttempts: 1},
+		{name: "last attempt", status: http.StatusServiceUnavailable, attempts: 2, wantDead: 1, wantAttempts: 3},
+		{name: "incident deleted", status: http.StatusNotFound, wantDead: 1, wantAttempts: 1},
+		{name: "rejected", status: http.StatusUnprocessableEntity, wantDead: 1, wantAttempts: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v2/incidents/inc_1/actions/edit" {
+					t.Errorf("path = %s", r.URL.Path)
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+			client := incidentio.NewClient("token", server.Client())
+			client.BaseURL = server.URL
+
+			store := newMemoryStore()
+			s := &IncidentJiraSync{
+				config:              Config{OutboxBaseDelay: time.Second, OutboxMaxDelay: time.Minute, OutboxMaxAttempts: 3},
+				store:               store,
+				defaultOrganization: &incidentOrganization{client: client},
+			}
+			ctx := context.Background()
+			if err := store.EnqueueOutbox(ctx, outboxMessage{IncidentID: "inc_1", Description: "failure note", Attempts: test.attempts}, nil); err != nil {
+				t.Fatal(err)
+			}
+			claimed, _ := store.ClaimOutbox(ctx, 1, time.Minute)
+			s.deliverOutboxMessage(claimed[0])
+
+			backlog, _ := store.OutboxBacklog(ctx)
+			if backlog.Pending != test.wantPending || backlog.DeadLettered != test.wantDead {
+				t.Errorf("backlog = %+v, want %d pending and %d dead-lettered", backlog, test.wantPending, test.wantDead)
+			}
+			for _, message := range store.outbox.messages {
+				if message.Attempts != test.wantAttempts || message.NextAttempt.Before(time.Now()) || message.LastError == "" {
+					t.Errorf("pending message = %+v, want attempt %d with a backoff", message, test.wantAttempts)
+				}
+			}
+			for _, message := range store.outbox.deadLetters {
+				if message.Attempts != test.wantAttempts || message.LastError == "" {
+					t.Errorf("dead letter = %+v, want attempt %d with its error", message, test.wantAttempts)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatchOutbox(t *testing.T) {
+	var mu sync.Mutex
+	delivered := map[string][]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var edit incidentio.EditRequest
+		if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+			t.Error(err)
+		}
+		incidentID := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/incidents/"), "/")[0]
+		mu.Lock()
+		for _, entry := range edit.Incident.CustomFieldEntries {
+			delivered[incidentID] = append(delivered[incidentID], entry.CustomFieldID)
+		}
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	client := incidentio.NewClientWithRetries("token", server.Client(), incidentio.RetryConfig{})
+	client.BaseURL = server.URL
+
+	s := newRetryTestSync(1)
+	s.config.OutboxMaxAttempts = 3
+	s.defaultOrganization = &incidentOrganization{client: client}
+
+	// More messages than a batch, for two incidents
+	want := map[string][]string{}
+	for i := 0; i < outboxBatchSize+2; i++ {
+		incidentID := fmt.Sprintf("inc_%d", i%2)
+		var edit incidentio.EditRequest
+		edit.Incident.CustomFieldEntries = []incidentio.EditFieldEntry{{CustomFieldID: fmt.Sprint(i)}}
+		if err := s.enqueueIncidentEdit(context.Background(), incidentID, "edit", edit); err != nil {
+			t.Fatal(err)
+		}
+		want[incidentID] = append(want[incidentID], fmt.Sprint(i))
+	}
+	select {
+	case <-s.outboxWake:
+	default:
+		t.Error("dispatcher not woken by the queued messages")
+	}
+
+	s.dispatchOutbox()
+	if !reflect.DeepEqual(delivered, want) {
+		t.Errorf("delivered %v, want %v, in order for each incident", delivered, want)
+	}
+	if backlog := s.outboxBacklog(); backlog == nil || backlog.Pending != 0 || backlog.DeadLettered != 0 {
+		t.Errorf("backlog = %+v, want the outbox empty", backlog)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// rankedValue is a Jira value with the catalog attribute ranking it under the prioritize policy
+type rankedValue struct {
+	value    jira.ComponentValue
+	priority string
+	ranked   bool
+}
+
+// lowerPriority orders priority attribute values, numerically when both are numbers. Values
+// without the attribute come last.
+func lowerPriority(a, b rankedValue) bool {
+	if a.ranked != b.ranked {
+		return a.ranked
+	}
+	aNumber, aErr := strconv.ParseFloat(a.priority, 64)
+	bNumber, bErr := strconv.ParseFloat(b.priority, 64)
+	if aErr == nil && bErr == nil {
+		return aNumber < bNumber
+	}
+	return strings.ToLower(a.priority) < strings.ToLower(b.priority)
+}
+
+// limitFieldValues applies the mapping's max_values, returning the values to write and the
+// object IDs of those left out. entryIDs holds the catalog entry of each value.
+func (s *IncidentJiraSync) limitFieldValues(ctx context.Context, fieldMapping mapping.FieldMapping, values []jira.ComponentValue, entryIDs []string) ([]jira.ComponentValue, []string, error) {
+	if fieldMapping.MaxValues == 0 || len(values) <= fieldMapping.MaxValues {
+		return values, nil, nil
+	}
+
+	policy := fieldMapping.OverflowPolicyOr()
+	valueOverflowsTotal.inc(fieldMapping.IncidentFieldName, policy)
+
+	switch policy {
+	case mapping.OverflowFail:
+		return nil, nil, fmt.Errorf("%s has %d values, more than the %d allowed", fieldMapping.IncidentFieldName, len(values), fieldMapping.MaxValues)
+
+	case mapping.OverflowPrioritize:
+		ranked := make([]rankedValue, len(values))
+		for i, value := range values {
+			ranked[i].value = value
+			priority, err := s.resolveCatalogAttribute(ctx, entryIDs[i], fieldMapping.PriorityAttribute)
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			if err != nil {
+				log.Printf("No %s for catalog entry %s, ranking it last: %v", fieldMapping.PriorityAttribute, entryIDs[i], err)
+				continue
+			}
+			ranked[i].priority, ranked[i].ranked = priority, true
+		}
+		sort.SliceStable(ranked, func(i, j int) bool { return lowerPriority(ranked[i], ranked[j]) })
+
+		values = make([]jira.ComponentValue, len(ranked))
+		for i := range ranked {
+			values[i] = ranked[i].value
+		}
+	}
+
+	dropped := make([]string, 0, len(values)-fieldMapping.MaxValues)
+	for _, value := range values[fieldMapping.MaxValues:] {
+		dropped = append(dropped, value.ObjectID)
+	}
+	log.Printf("%s has %d values, writing %d (%s)", fieldMapping.IncidentFieldName, len(values), fieldMapping.MaxValues, policy)
+	return values[:fieldMapping.MaxValues], dropped, nil
+}
+
+// commentValueOverflow notes on the issue which values didn't fit in the field, once for each
+// set of dropped values
+func (s *IncidentJiraSync) commentValueOverflow(ctx context.Context, jiraIssueKey string, fieldMapping mapping.FieldMapping, dropped []string) {
+	attribute := "overflow:" + fieldMapping.IncidentFieldName
+	key := strings.Join(dropped, ",")
+	if !s.lastWritten.changed(jiraIssueKey, attribute, key) {
+		return
+	}
+
+	data := templateData{Field: fieldMapping.IncidentFieldName, Dropped: dropped, MaxValues: fieldMapping.MaxValues}
+	if err := s.addTemplatedComment(ctx, jiraIssueKey, templateOverflowComment, data); err != nil {
+		log.Printf("Warning: failed to comment values left out of %s on %s: %v", fieldMapping.IncidentFieldName, jiraIssueKey, err)
+		return
+	}
+	s.lastWritten.record(jiraIssueKey, attribute, key)
+}
+
+var valueOverflowsTotal = newCounterVec(
+	"incident_jira_webhook_value_overflows_total",
+	"Writes with more values than the mapping's max_values, by incident field and the overflow policy applied.",
+	"field", "policy")
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+const (
+	// parentFieldID is the Jira system field linking an issue to its parent, which in
+	// team-managed projects (and company-managed ones on newer Jira sites) is its epic
+	parentFieldID = "parent"
+	// epicLinkCustomType is the custom field type of the Epic Link field of company-managed
+	// projects
+	epicLinkCustomType = "com.pyxis.greenhopper.jira:gh-epic-link"
+)
+
+// parentIssueKey returns the issue key in an incident value, e.g. "PROG-12" or
+// https://example.atlassian.net/browse/PROG-12
+func parentIssueKey(text string) (string, bool) {
+	key := jiraIssueKeyPattern.FindString(strings.ToUpper(strings.TrimSpace(text)))
+	return key, key != ""
+}
+
+// epicField returns the field holding an issue's epic: the parent field when the issue's
+// project offers it, otherwise its Epic Link field
+func epicField(editMeta map[string]jira.FieldMeta) (string, bool) {
+	if _, editable := editMeta[parentFieldID]; editable {
+		return parentFieldID, true
+	}
+	for fieldID, meta := range editMeta {
+		if meta.Schema.Custom == epicLinkCustomType {
+			return fieldID, true
+		}
+	}
+	return "", false
+}
+
+// processParentField links the issue to the epic whose key is an incident field's first value,
+// through the parent field or a company-managed Epic Link field. A target the issue's project
+// doesn't offer is replaced by the one it does, so one mapping covers team-managed and
+// company-managed projects. A field without a value leaves the epic alone.
+func (s *IncidentJiraSync) processParentField(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, jiraIssueKey string, fieldMapping mapping.FieldMapping) error {
+	texts, err := s.selectTexts(ctx, customFieldEntry, fieldMapping)
+	if err != nil {
+		return err
+	}
+
+	fieldIDs := fieldMapping.EnabledFieldIDs()
+	if len(fieldIDs) == 0 || len(texts) == 0 {
+		return nil
+	}
+	epicKey, found := parentIssueKey(texts[0])
+	if !found {
+		return fmt.Errorf("%s value %q is not a Jira issue key", fieldMapping.IncidentFieldName, texts[0])
+	}
+	if epicKey == strings.ToUpper(jiraIssueKey) {
+		return fmt.Errorf("%s names %s itself as its parent", fieldMapping.IncidentFieldName, jiraIssueKey)
+	}
+
+	editMeta, err := s.jira.EditMeta(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	for _, fieldID := range fieldIDs {
+		meta, editable := editMeta[fieldID]
+		if !editable {
+			epicFieldID, found := epicField(editMeta)
+			if !found {
+				return fmt.Errorf("%s has neither a parent nor an Epic Link field to edit", jiraIssueKey)
+			}
+			log.Printf("Field %s is not editable on %s, writing the epic to %s instead", fieldID, jiraIssueKey, epicFieldID)
+			fieldID, meta = epicFieldID, editMeta[epicFieldID]
+		}
+
+		switch {
+		case fieldID == parentFieldID:
+			fields[fieldID] = map[string]interface{}{"key": epicKey}
+		case meta.Schema.Custom == epicLinkCustomType:
+			fields[fieldID] = epicKey
+		default:
+			return fmt.Errorf("field %s is neither parent nor an Epic Link field", fieldID)
+		}
+	}
+	log.Printf("Mapped %s -> %s (%s)", fieldMapping.IncidentFieldName, epicKey, jiraIssueKey)
+
+	return s.updateJiraIssueFields(ctx, jiraIssueKey, fields)
+}
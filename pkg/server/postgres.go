@@ -0,0 +1,671 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// postgresDriver is the database/sql driver the Postgres store opens. No driver is linked into
+// the default build; cmd/incident-jira-webhook-postgres links pgx, which registers this name.
+const postgresDriver = "pgx"
+
+// postgresMigrationLock is the advisory lock key held while migrating, so replicas starting
+// together apply each migration once
+const postgresMigrationLock = 0x696a77
+
+// postgresMigrations are applied in order; the index plus one is the schema version. Append
+// new migrations, never edit applied ones.
+var postgresMigrations = []string{
+	`CREATE TABLE sync_state (
+		issue_key  TEXT NOT NULL,
+		attribute  TEXT NOT NULL,
+		value      TEXT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (issue_key, attribute)
+	)`,
+	`CREATE TABLE issue_links (
+		incident_id TEXT PRIMARY KEY,
+		issue_key   TEXT NOT NULL,
+		updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE webhook_deliveries (
+		delivery_id  TEXT PRIMARY KEY,
+		processed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX webhook_deliveries_processed_at ON webhook_deliveries (processed_at)`,
+	`CREATE TABLE sync_history (
+		id          BIGSERIAL PRIMARY KEY,
+		occurred_at TIMESTAMPTZ NOT NULL,
+		type        TEXT NOT NULL,
+		event_type  TEXT NOT NULL DEFAULT '',
+		issue_key   TEXT NOT NULL DEFAULT '',
+		field       TEXT NOT NULL DEFAULT '',
+		outcome     TEXT NOT NULL DEFAULT '',
+		message     TEXT NOT NULL
+	)`,
+	`CREATE INDEX sync_history_issue_key ON sync_history (issue_key, occurred_at)`,
+	`CREATE TABLE skipped_incidents (
+		incident_key TEXT PRIMARY KEY,
+		incident     TEXT NOT NULL,
+		reason       TEXT NOT NULL DEFAULT '',
+		added_by     TEXT NOT NULL DEFAULT '',
+		added_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE pending_retries (
+		id       BIGSERIAL PRIMARY KEY,
+		retry    TEXT NOT NULL,
+		saved_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE sync_history ADD COLUMN duration_ms BIGINT`,
+	`CREATE INDEX sync_history_occurred_at ON sync_history (occurred_at)`,
+	`CREATE TABLE incident_outbox (
+		id           BIGSERIAL PRIMARY KEY,
+		organization TEXT NOT NULL DEFAULT '',
+		incident_id  TEXT NOT NULL,
+		message      TEXT NOT NULL,
+		attempts     INTEGER NOT NULL DEFAULT 0,
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		next_attempt TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_error   TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX incident_outbox_incident ON incident_outbox (organization, incident_id, id)`,
+	`ALTER TABLE incident_outbox ADD COLUMN dead_lettered_at TIMESTAMPTZ`,
+	`CREATE TABLE issue_creations (
+		incident_id   TEXT PRIMARY KEY,
+		issue_key     TEXT NOT NULL DEFAULT '',
+		claimed_until TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`INSERT INTO issue_creations (incident_id, issue_key)
+		SELECT substr(issue_key, length('incident/') + 1), value FROM sync_state
+		WHERE attribute = 'created_issue' AND issue_key LIKE 'incident/%'`,
+	`CREATE TABLE tombstones (
+		issue_key  TEXT NOT NULL,
+		field      TEXT NOT NULL,
+		entry_id   TEXT NOT NULL,
+		entry      TEXT NOT NULL,
+		removed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (issue_key, field, entry_id)
+	)`,
+	`CREATE TABLE mapping_rules (
+		id          INTEGER PRIMARY KEY CHECK (id = 1),
+		document    TEXT NOT NULL,
+		imported_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+}
+
+// postgresStore keeps state in Postgres, shared by every replica pointed at the database
+type postgresStore struct {
+	db *sql.DB
+}
+
+// openPostgresStore connects to the database at url and, with autoMigrate, brings its schema
+// up to date. Without it, a schema behind this release is an error.
+func openPostgresStore(url string, autoMigrate bool) (*postgresStore, error) {
+	if url == "" {
+		return nil, errors.New("STATE_STORE_URL is required for the postgres state store")
+	}
+	if !driverRegistered(postgresDriver) {
+		return nil, fmt.Errorf("this binary has no Postgres driver, build cmd/incident-jira-webhook-postgres instead")
+	}
+
+	db, err := sql.Open(postgresDriver, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+
+	store := &postgresStore{db: db}
+	if err := store.migrate(ctx, autoMigrate); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func driverRegistered(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// migrate applies the migrations newer than the database's schema version in one transaction.
+// Unless apply is set, pending migrations fail instead.
+func (p *postgresStore) migrate(ctx context.Context, apply bool) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start migration: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, postgresMigrationLock); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > len(postgresMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this release (%d)", version, len(postgresMigrations))
+	}
+	if !apply && version < len(postgresMigrations) {
+		return fmt.Errorf("database schema version %d is behind this release (%d); run with --migrate-only to migrate it", version, len(postgresMigrations))
+	}
+
+	for i := version; i < len(postgresMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, postgresMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migrations: %w", err)
+	}
+	if version < len(postgresMigrations) {
+		log.Printf("Migrated state store schema from version %d to %d", version, len(postgresMigrations))
+	}
+	return nil
+}
+
+func (p *postgresStore) LastWritten(ctx context.Context, jiraIssueKey, attribute string) (string, bool, error) {
+	var value string
+	err := p.db.QueryRowContext(ctx,
+		`SELECT value FROM sync_state WHERE issue_key = $1 AND attribute = $2`,
+		jiraIssueKey, attribute).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read sync state: %w", err)
+	}
+	return value, true, nil
+}
+
+func (p *postgresStore) RecordWritten(ctx context.Context, jiraIssueKey, attribute, value string) error {
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO sync_state (issue_key, attribute, value) VALUES ($1, $2, $3)
+		ON CONFLICT (issue_key, attribute) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`,
+		jiraIssueKey, attribute, value)
+	if err != nil {
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) ClaimIssueCreation(ctx context.Context, incidentID string, lease time.Duration) (string, bool, error) {
+	// Only an expired claim that created no issue can be taken over
+	var jiraIssueKey string
+	err := p.db.QueryRowContext(ctx,
+		`INSERT INTO issue_creations (incident_id, claimed_until) VALUES ($1, now() + make_interval(secs => $2))
+		ON CONFLICT (incident_id) DO UPDATE SET claimed_until = EXCLUDED.claimed_until
+			WHERE issue_creations.issue_key = '' AND issue_creations.claimed_until <= now()
+		RETURNING issue_key`,
+		incidentID, lease.Seconds()).Scan(&jiraIssueKey)
+	if err == nil {
+		return "", true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", false, fmt.Errorf("failed to claim issue creation: %w", err)
+	}
+
+	if err := p.db.QueryRowContext(ctx,
+		`SELECT issue_key FROM issue_creations WHERE incident_id = $1`, incidentID).Scan(&jiraIssueKey); err != nil {
+		return "", false, fmt.Errorf("failed to read issue creation: %w", err)
+	}
+	return jiraIssueKey, false, nil
+}
+
+func (p *postgresStore) CompleteIssueCreation(ctx context.Context, incidentID, jiraIssueKey string) error {
+	if _, err := p.db.ExecContext(ctx,
+		`UPDATE issue_creations SET issue_key = $2 WHERE incident_id = $1`, incidentID, jiraIssueKey); err != nil {
+		return fmt.Errorf("failed to record created issue: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) ReleaseIssueCreation(ctx context.Context, incidentID string) error {
+	if _, err := p.db.ExecContext(ctx,
+		`DELETE FROM issue_creations WHERE incident_id = $1 AND issue_key = ''`, incidentID); err != nil {
+		return fmt.Errorf("failed to release issue creation: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) AddTombstones(ctx context.Context, jiraIssueKey, fieldName string, entries []incidentio.CatalogEntry) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to write tombstones: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to write tombstones: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO tombstones (issue_key, field, entry_id, entry) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (issue_key, field, entry_id) DO UPDATE SET entry = EXCLUDED.entry`,
+			jiraIssueKey, fieldName, entry.ID, string(data)); err != nil {
+			return fmt.Errorf("failed to write tombstones: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write tombstones: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) Tombstones(ctx context.Context, jiraIssueKey, fieldName string) ([]incidentio.CatalogEntry, error) {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT entry FROM tombstones WHERE issue_key = $1 AND field = $2`, jiraIssueKey, fieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tombstones: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []incidentio.CatalogEntry
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read tombstones: %w", err)
+		}
+		var entry incidentio.CatalogEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			log.Printf("Warning: skipping unreadable tombstone of %s: %v", jiraIssueKey, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tombstones: %w", err)
+	}
+	return entries, nil
+}
+
+func (p *postgresStore) ClearTombstones(ctx context.Context, jiraIssueKey, fieldName string, catalogEntryIDs []string) error {
+	if len(catalogEntryIDs) == 0 {
+		return nil
+	}
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to clear tombstones: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range catalogEntryIDs {
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM tombstones WHERE issue_key = $1 AND field = $2 AND entry_id = $3`,
+			jiraIssueKey, fieldName, id); err != nil {
+			return fmt.Errorf("failed to clear tombstones: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to clear tombstones: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) SaveMappingRules(ctx context.Context, document []byte) error {
+	if _, err := p.db.ExecContext(ctx,
+		`INSERT INTO mapping_rules (id, document) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET document = EXCLUDED.document, imported_at = now()`,
+		string(document)); err != nil {
+		return fmt.Errorf("failed to store mapping rules: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) MappingRules(ctx context.Context) ([]byte, bool, error) {
+	var document string
+	err := p.db.QueryRowContext(ctx, `SELECT document FROM mapping_rules WHERE id = 1`).Scan(&document)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read mapping rules: %w", err)
+	}
+	return []byte(document), true, nil
+}
+
+func (p *postgresStore) SwapIssueLink(ctx context.Context, incidentID, jiraIssueKey string) (string, bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previous string
+	found := true
+	err = tx.QueryRowContext(ctx, `SELECT issue_key FROM issue_links WHERE incident_id = $1 FOR UPDATE`, incidentID).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		found = false
+	} else if err != nil {
+		return "", false, fmt.Errorf("failed to read issue link: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO issue_links (incident_id, issue_key) VALUES ($1, $2)
+		ON CONFLICT (incident_id) DO UPDATE SET issue_key = EXCLUDED.issue_key, updated_at = now()`,
+		incidentID, jiraIssueKey); err != nil {
+		return "", false, fmt.Errorf("failed to write issue link: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", false, fmt.Errorf("failed to write issue link: %w", err)
+	}
+	return previous, found, nil
+}
+
+func (p *postgresStore) DeliveryProcessed(ctx context.Context, deliveryID string, ttl time.Duration) (bool, error) {
+	var processed bool
+	err := p.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM webhook_deliveries WHERE delivery_id = $1 AND processed_at > now() - make_interval(secs => $2))`,
+		deliveryID, ttl.Seconds()).Scan(&processed)
+	if err != nil {
+		return false, fmt.Errorf("failed to read webhook deliveries: %w", err)
+	}
+	return processed, nil
+}
+
+func (p *postgresStore) RecordDelivery(ctx context.Context, deliveryID string, ttl time.Duration) error {
+	if _, err := p.db.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (delivery_id) VALUES ($1)
+		ON CONFLICT (delivery_id) DO UPDATE SET processed_at = now()`,
+		deliveryID); err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	if _, err := p.db.ExecContext(ctx,
+		`DELETE FROM webhook_deliveries WHERE processed_at <= now() - make_interval(secs => $1)`,
+		ttl.Seconds()); err != nil {
+		return fmt.Errorf("failed to expire webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) AppendHistory(ctx context.Context, event streamEvent) error {
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO sync_history (occurred_at, type, event_type, issue_key, field, outcome, message, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		event.Time, event.Type, event.EventType, event.IssueKey, event.Field, event.Outcome, event.Message,
+		sql.NullInt64{Int64: event.DurationMS, Valid: event.DurationMS > 0})
+	if err != nil {
+		return fmt.Errorf("failed to write sync history: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) ScanHistory(ctx context.Context, from, to time.Time, visit func(streamEvent) error) error {
+	rows, err := p.db.QueryContext(ctx,
+		`SELECT occurred_at, type, event_type, issue_key, field, outcome, message, COALESCE(duration_ms, 0)
+		FROM sync_history WHERE occurred_at >= $1 AND occurred_at < $2 ORDER BY occurred_at`,
+		from, to)
+	if err != nil {
+		return fmt.Errorf("failed to read sync history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event streamEvent
+		if err := rows.Scan(&event.Time, &event.Type, &event.EventType, &event.IssueKey, &event.Field,
+			&event.Outcome, &event.Message, &event.DurationMS); err != nil {
+			return fmt.Errorf("failed to read sync history: %w", err)
+		}
+		if err := visit(event); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read sync history: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) SkippedIncidents(ctx context.Context) ([]skippedIncident, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT incident, reason, added_by, added_at FROM skipped_incidents`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read skip list: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []skippedIncident
+	for rows.Next() {
+		var entry skippedIncident
+		if err := rows.Scan(&entry.Incident, &entry.Reason, &entry.AddedBy, &entry.AddedAt); err != nil {
+			return nil, fmt.Errorf("failed to read skip list: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read skip list: %w", err)
+	}
+	return entries, nil
+}
+
+func (p *postgresStore) SkipIncident(ctx context.Context, entry skippedIncident) error {
+	_, err := p.db.ExecContext(ctx,
+		`INSERT INTO skipped_incidents (incident_key, incident, reason, added_by, added_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (incident_key) DO UPDATE SET incident = EXCLUDED.incident, reason = EXCLUDED.reason,
+			added_by = EXCLUDED.added_by, added_at = EXCLUDED.added_at`,
+		skipListKey(entry.Incident), entry.Incident, entry.Reason, entry.AddedBy, entry.AddedAt)
+	if err != nil {
+		return fmt.Errorf("failed to write skip list: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) UnskipIncident(ctx context.Context, incident string) (bool, error) {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM skipped_incidents WHERE incident_key = $1`, skipListKey(incident))
+	if err != nil {
+		return false, fmt.Errorf("failed to write skip list: %w", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to write skip list: %w", err)
+	}
+	return removed > 0, nil
+}
+
+func (p *postgresStore) SaveRetries(ctx context.Context, retries []savedRetry) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save retries: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, retry := range retries {
+		data, err := json.Marshal(retry)
+		if err != nil {
+			return fmt.Errorf("failed to save retries: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO pending_retries (retry) VALUES ($1)`, string(data)); err != nil {
+			return fmt.Errorf("failed to save retries: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save retries: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) TakeRetries(ctx context.Context) ([]savedRetry, error) {
+	rows, err := p.db.QueryContext(ctx, `DELETE FROM pending_retries RETURNING retry`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read saved retries: %w", err)
+	}
+	defer rows.Close()
+
+	var retries []savedRetry
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read saved retries: %w", err)
+		}
+		var retry savedRetry
+		if err := json.Unmarshal([]byte(data), &retry); err != nil {
+			log.Printf("Warning: dropping unreadable saved retry: %v", err)
+			continue
+		}
+		retries = append(retries, retry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read saved retries: %w", err)
+	}
+	return retries, nil
+}
+
+func (p *postgresStore) EnqueueOutbox(ctx context.Context, message outboxMessage, written []writtenValue) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to queue outbox message: %w", err)
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to queue outbox message: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO incident_outbox (organization, incident_id, message, created_at, next_attempt) VALUES ($1, $2, $3, $4, $5)`,
+		message.Organization, message.IncidentID, string(data), message.CreatedAt, message.NextAttempt); err != nil {
+		return fmt.Errorf("failed to queue outbox message: %w", err)
+	}
+	for _, value := range written {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO sync_state (issue_key, attribute, value) VALUES ($1, $2, $3)
+			ON CONFLICT (issue_key, attribute) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`,
+			value.Key, value.Attribute, value.Value); err != nil {
+			return fmt.Errorf("failed to queue outbox message: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to queue outbox message: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]outboxMessage, error) {
+	// Replicas claiming together skip each other's rows, and a message waits while an older one
+	// of its incident is in the outbox and not dead-lettered
+	rows, err := p.db.QueryContext(ctx,
+		`UPDATE incident_outbox SET next_attempt = now() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM incident_outbox o
+			WHERE next_attempt <= now() AND dead_lettered_at IS NULL AND NOT EXISTS (
+				SELECT 1 FROM incident_outbox older
+				WHERE older.organization = o.organization AND older.incident_id = o.incident_id AND older.id < o.id
+					AND older.dead_lettered_at IS NULL)
+			ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, message, attempts, next_attempt, last_error`,
+		limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []outboxMessage
+	unreadable := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var data string
+		var attempts int
+		var nextAttempt time.Time
+		var lastError string
+		if err := rows.Scan(&id, &data, &attempts, &nextAttempt, &lastError); err != nil {
+			return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+		}
+		var message outboxMessage
+		if err := json.Unmarshal([]byte(data), &message); err != nil {
+			log.Printf("Warning: dead-lettering unreadable outbox message %d: %v", id, err)
+			unreadable[id] = err.Error()
+			continue
+		}
+		message.ID, message.Attempts, message.NextAttempt, message.LastError = id, attempts, nextAttempt, lastError
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+	rows.Close()
+
+	// An unreadable message would otherwise be leased again and again, holding back its incident
+	for id, reason := range unreadable {
+		if _, err := p.db.ExecContext(ctx,
+			`UPDATE incident_outbox SET dead_lettered_at = now(), last_error = $2 WHERE id = $1`,
+			id, "unreadable message: "+reason); err != nil {
+			log.Printf("Warning: failed to dead-letter outbox message %d: %v", id, err)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+func (p *postgresStore) CompleteOutbox(ctx context.Context, id int64) error {
+	if _, err := p.db.ExecContext(ctx, `DELETE FROM incident_outbox WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to remove outbox message: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) FailOutbox(ctx context.Context, id int64, attempts int, nextAttempt time.Time, lastError string) error {
+	if _, err := p.db.ExecContext(ctx,
+		`UPDATE incident_outbox SET attempts = $2, next_attempt = $3, last_error = $4 WHERE id = $1`,
+		id, attempts, nextAttempt, lastError); err != nil {
+		return fmt.Errorf("failed to update outbox message: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) DeadLetterOutbox(ctx context.Context, id int64, attempts int, lastError string) error {
+	if _, err := p.db.ExecContext(ctx,
+		`UPDATE incident_outbox SET attempts = $2, last_error = $3, dead_lettered_at = now() WHERE id = $1`,
+		id, attempts, lastError); err != nil {
+		return fmt.Errorf("failed to dead-letter outbox message: %w", err)
+	}
+	return nil
+}
+
+func (p *postgresStore) OutboxBacklog(ctx context.Context) (outboxBacklog, error) {
+	var backlog outboxBacklog
+	var oldest sql.NullTime
+	if err := p.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FILTER (WHERE dead_lettered_at IS NULL), COUNT(*) FILTER (WHERE dead_lettered_at IS NOT NULL),
+			MIN(created_at) FILTER (WHERE dead_lettered_at IS NULL)
+		FROM incident_outbox`).Scan(&backlog.Pending, &backlog.DeadLettered, &oldest); err != nil {
+		return outboxBacklog{}, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	if oldest.Valid {
+		backlog.Oldest = &oldest.Time
+	}
+	return backlog, nil
+}
+
+func (p *postgresStore) Close() error {
+	return p.db.Close()
+}
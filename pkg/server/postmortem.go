@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+func postmortemGlobalID(incidentID string) string {
+	return "incident-io-postmortem:" + incidentID
+}
+
+// syncPostmortem links a newly published post-mortem document from the Jira issue, comments
+// on the issue and optionally transitions it for review. The remote link doubles as the record
+// that the post-mortem was handled, so this runs once per document even across restarts.
+func (s *IncidentJiraSync) syncPostmortem(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if !s.config.PostmortemSyncEnabled || incident.PostmortemDocumentURL == "" || !s.flagEnabled(ctx, flagPostmortem) {
+		return nil
+	}
+
+	if !s.lastWritten.changed(jiraIssueKey, "postmortem", incident.PostmortemDocumentURL) {
+		return nil
+	}
+
+	globalID := postmortemGlobalID(incident.ID)
+	existing, err := s.jira.RemoteLinks(ctx, jiraIssueKey, globalID)
+	if err != nil {
+		log.Printf("Failed to read remote links of %s, assuming post-mortem not linked: %v", jiraIssueKey, err)
+	}
+	for _, link := range existing {
+		if link.Object.URL == incident.PostmortemDocumentURL {
+			s.lastWritten.record(jiraIssueKey, "postmortem", incident.PostmortemDocumentURL)
+			return nil
+		}
+	}
+
+	log.Printf("Linking post-mortem of incident %s to %s", incident.ID, jiraIssueKey)
+
+	// Posting with the same global ID replaces the link, so a re-published document moves it
+	var link jira.RemoteLink
+	link.GlobalID = globalID
+	link.Object.URL = incident.PostmortemDocumentURL
+	link.Object.Title = "Post-mortem: " + incident.Name
+	link.Object.Icon.URL16x16 = incidentIOIcon
+	link.Object.Icon.Title = "incident.io"
+	if err := s.jira.PutRemoteLink(ctx, jiraIssueKey, link); err != nil {
+		return fmt.Errorf("failed to link post-mortem: %w", err)
+	}
+
+	if s.config.PostmortemComment {
+		data := templateData{Incident: incident, PostmortemURL: incident.PostmortemDocumentURL}
+		if err := s.addTemplatedComment(ctx, jiraIssueKey, templatePostmortemComment, data); err != nil {
+			log.Printf("Warning: failed to comment post-mortem on %s: %v", jiraIssueKey, err)
+		}
+	}
+
+	if s.config.PostmortemTransition != "" {
+		if err := s.transitionJiraIssue(ctx, jiraIssueKey, s.config.PostmortemTransition); err != nil {
+			log.Printf("Warning: failed to transition %s to %s: %v", jiraIssueKey, s.config.PostmortemTransition, err)
+		}
+	}
+
+	s.lastWritten.record(jiraIssueKey, "postmortem", incident.PostmortemDocumentURL)
+	return nil
+}
+
+// transitionJiraIssue applies the transition whose name, or target status name, matches name
+func (s *IncidentJiraSync) transitionJiraIssue(ctx context.Context, jiraIssueKey, name string) error {
+	if !s.flagEnabled(ctx, flagTransitions) {
+		log.Printf("Transitions disabled by feature flag, not transitioning %s", jiraIssueKey)
+		return nil
+	}
+
+	transition, err := s.jira.TransitionIssue(ctx, jiraIssueKey, name)
+	if err != nil {
+		return err
+	}
+	log.Printf("Transitioned %s via %s to %s", jiraIssueKey, transition.Name, transition.To.Name)
+	return nil
+}
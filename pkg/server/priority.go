@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// Event priorities, lowest first
+const (
+	priorityLow = iota
+	priorityNormal
+	priorityHigh
+)
+
+var priorityNames = map[string]int{
+	"low":    priorityLow,
+	"normal": priorityNormal,
+	"high":   priorityHigh,
+}
+
+func priorityName(priority int) string {
+	for name, value := range priorityNames {
+		if value == priority {
+			return name
+		}
+	}
+	return "normal"
+}
+
+// PriorityRule assigns a priority to events matching every condition it sets. Conditions are
+// case-insensitive; an empty list matches anything.
+type PriorityRule struct {
+	Priority         string   `json:"priority"`
+	EventTypes       []string `json:"event_types,omitempty"`
+	StatusCategories []string `json:"status_categories,omitempty"`
+	Severities       []string `json:"severities,omitempty"`
+	IncidentTypes    []string `json:"incident_types,omitempty"`
+}
+
+// PriorityRulesFile is the format of PRIORITY_RULES_FILE
+type PriorityRulesFile struct {
+	Rules []PriorityRule `json:"rules"`
+}
+
+// defaultPriorityRules put open incidents ahead of everything else and edits to finished
+// incidents last
+var defaultPriorityRules = []PriorityRule{
+	{Priority: "high", StatusCategories: []string{"triage", "live"}},
+	{Priority: "low", StatusCategories: []string{"closed", "declined", "canceled", "merged"}},
+}
+
+// loadPriorityRules reads priority rules from a JSON file, or returns the default rules
+func loadPriorityRules(path string) ([]PriorityRule, error) {
+	if path == "" {
+		return defaultPriorityRules, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var rulesFile PriorityRulesFile
+	if err := json.Unmarshal(data, &rulesFile); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for i, rule := range rulesFile.Rules {
+		if _, known := priorityNames[rule.Priority]; !known {
+			return nil, fmt.Errorf("rule %d has invalid priority %q, expected low, normal or high", i, rule.Priority)
+		}
+	}
+	return rulesFile.Rules, nil
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyEvent returns the priority of the first rule matching the event, or normal
+func (s *IncidentJiraSync) classifyEvent(payload incidentio.WebhookPayload) int {
+	incident := payload.Incident
+	severity, incidentType := "", ""
+	if incident.Severity != nil {
+		severity = incident.Severity.Name
+	}
+	if incident.IncidentType != nil {
+		incidentType = incident.IncidentType.Name
+	}
+
+	for _, rule := range s.settings().PriorityRules {
+		if matchesAny(rule.EventTypes, payload.EventType) &&
+			matchesAny(rule.StatusCategories, incident.IncidentStatus.Category) &&
+			matchesAny(rule.Severities, severity) &&
+			matchesAny(rule.IncidentTypes, incidentType) {
+			return priorityNames[rule.Priority]
+		}
+	}
+	return priorityNormal
+}
+
+// errShed is returned to events dropped to make room for more important work
+var errShed = errors.New("shed under load")
+
+type admissionWaiter struct {
+	priority int
+	ready    chan error
+}
+
+// admission limits how many events are processed at once. Waiting events are admitted highest
+// priority first; when the queue is full the lowest-priority waiting event is shed, or the new
+// event if nothing waiting is less important.
+type admission struct {
+	mu        sync.Mutex
+	limit     int
+	queueSize int
+	running   int
+	// waiters is in arrival order
+	waiters []*admissionWaiter
+}
+
+func newAdmission(limit, queueSize int) *admission {
+	return &admission{limit: limit, queueSize: queueSize}
+}
+
+// acquire waits for a processing slot. The returned release must be called when done.
+func (a *admission) acquire(ctx context.Context, priority int) (func(), error) {
+	if a == nil || a.limit <= 0 {
+		return func() {}, nil
+	}
+
+	a.mu.Lock()
+	if a.running < a.limit && len(a.waiters) == 0 {
+		a.running++
+		a.mu.Unlock()
+		return a.release, nil
+	}
+
+	if len(a.waiters) >= a.queueSize {
+		victim := a.lowestWaiter()
+		if victim < 0 || a.waiters[victim].priority >= priority {
+			a.mu.Unlock()
+			return nil, errShed
+		}
+		a.waiters[victim].ready <- errShed
+		a.waiters = append(a.waiters[:victim], a.waiters[victim+1:]...)
+	}
+
+	waiter := &admissionWaiter{priority: priority, ready: make(chan error, 1)}
+	a.waiters = append(a.waiters, waiter)
+	eventsWaiting.set(float64(len(a.waiters)))
+	a.mu.Unlock()
+
+	select {
+	case err := <-waiter.ready:
+		if err != nil {
+			return nil, err
+		}
+		return a.release, nil
+	case <-ctx.Done():
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		for i, w := range a.waiters {
+			if w == waiter {
+				a.waiters = append(a.waiters[:i], a.waiters[i+1:]...)
+				eventsWaiting.set(float64(len(a.waiters)))
+				return nil, ctx.Err()
+			}
+		}
+		// Admitted or shed while giving up
+		if err := <-waiter.ready; err == nil {
+			a.releaseLocked()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// lowestWaiter returns the index of the newest waiter with the lowest priority, or -1
+func (a *admission) lowestWaiter() int {
+	lowest := -1
+	for i, waiter := range a.waiters {
+		if lowest < 0 || waiter.priority <= a.waiters[lowest].priority {
+			lowest = i
+		}
+	}
+	return lowest
+}
+
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.releaseLocked()
+}
+
+// releaseLocked frees a slot and hands it to the oldest waiter of the highest priority
+func (a *admission) releaseLocked() {
+	a.running--
+	if len(a.waiters) == 0 {
+		return
+	}
+
+	next := 0
+	for i, waiter := range a.waiters {
+		if waiter.priority > a.waiters[next].priority {
+			next = i
+		}
+	}
+	a.waiters[next].ready <- nil
+	a.waiters = append(a.waiters[:next], a.waiters[next+1:]...)
+	eventsWaiting.set(float64(len(a.waiters)))
+	a.running++
+}
+
+var (
+	eventsShedTotal = newCounterVec(
+		"incident_jira_webhook_events_shed_total",
+		"Webhook events rejected with 503 under load, by event type and priority.",
+		"event_type", "priority")
+	eventsWaiting = newGaugeVec(
+		"incident_jira_webhook_events_waiting",
+		"Webhook events waiting for a processing slot.")
+)
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// waitForWaiters waits until n events are queued for a slot
+func waitForWaiters(t *testing.T, a *admission, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mu.Lock()
+		waiting := len(a.waiters)
+		a.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events waiting, want %d", waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdmissionOrdersByPriority(t *testing.T) {
+	a := newAdmission(1, 4)
+	release, err := a.acquire(context.Background(), priorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan string, 4)
+	waiters := []struct {
+		name     string
+		priority int
+	}{
+		{"low", priorityLow},
+		{"first normal", priorityNormal},
+		{"high", priorityHigh},
+		{"second normal", priorityNormal},
+	}
+	for i, waiter := range waiters {
+		go func(name string, priority int) {
+			release, err := a.acquire(context.Background(), priority)
+			if err != nil {
+				admitted <- err.Error()
+				return
+			}
+			admitted <- name
+			release()
+		}(waiter.name, waiter.priority)
+		waitForWaiters(t, a, i+1)
+	}
+
+	release()
+	for _, want := range []string{"high", "first normal", "second normal", "low"} {
+		if got := <-admitted; got != want {
+			t.Errorf("admitted %q, want %q", got, want)
+		}
+	}
+}
+
+func TestAdmissionShedsLowestPriority(t *testing.T) {
+	a := newAdmission(1, 1)
+	release, err := a.acquire(context.Background(), priorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lowErr := make(chan error, 1)
+	go func() {
+		_, err := a.acquire(context.Background(), priorityLow)
+		lowErr <- err
+	}()
+	waitForWaiters(t, a, 1)
+
+	highAdmitted := make(chan error, 1)
+	go func() {
+		release, err := a.acquire(context.Background(), priorityHigh)
+		if err == nil {
+			release()
+		}
+		highAdmitted <- err
+	}()
+	if err := <-lowErr; !errors.Is(err, errShed) {
+		t.Errorf("low priority waiter got %v, want %v", err, errShed)
+	}
+	waitForWaiters(t, a, 1)
+
+	// Nothing waiting is less important than a new low priority event
+	if _, err := a.acquire(context.Background(), priorityLow); !errors.Is(err, errShed) {
+		t.Errorf("acquire() with a full queue = %v, want %v", err, errShed)
+	}
+
+	release()
+	if err := <-highAdmitted; err != nil {
+		t.Errorf("high priority waiter got %v, want a slot", err)
+	}
+}
+
+func TestAdmissionHonorsContext(t *testing.T) {
+	a := newAdmission(1, 1)
+	release, err := a.acquire(context.Background(), priorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := a.acquire(ctx, priorityHigh); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	waitForWaiters(t, a, 0)
+
+	// The slot given up is free again
+	release()
+	release, err = a.acquire(context.Background(), priorityLow)
+	if err != nil {
+		t.Fatalf("acquire() after release = %v", err)
+	}
+	release()
+}
+
+func TestAdmissionUnlimited(t *testing.T) {
+	for _, a := range []*admission{nil, newAdmission(0, 0)} {
+		for i := 0; i < 3; i++ {
+			if _, err := a.acquire(context.Background(), priorityLow); err != nil {
+				t.Errorf("acquire() without a limit = %v", err)
+			}
+		}
+	}
+}
+
+func TestClassifyEvent(t *testing.T) {
+	payload := func(eventType, status, severity, incidentType string) incidentio.WebhookPayload {
+		incident := incidentio.Incident{IncidentStatus: incidentio.IncidentStatus{Category: status}}
+		if severity != "" {
+			incident.Severity = &incidentio.Severity{Name: severity}
+		}
+		if incidentType != "" {
+			incident.IncidentType = &incidentio.IncidentType{Name: incidentType}
+		}
+		return incidentio.WebhookPayload{EventType: eventType, Incident: incident}
+	}
+	rules := []PriorityRule{
+		{Priority: "high", Severities: []string{"Critical"}, IncidentTypes: []string{"Security"}},
+		{Priority: "low", EventTypes: []string{"public_incident.incident_updated_v2"}, StatusCategories: []string{"closed"}},
+	}
+
+	tests := []struct {
+		name    string
+		rules   []PriorityRule
+		payload incidentio.WebhookPayload
+		want    int
+	}{
+		{name: "default live", rules: defaultPriorityRules, payload: payload("", "live", "", ""), want: priorityHigh},
+		{name: "default merged", rules: defaultPriorityRules, payload: payload("", "merged", "", ""), want: priorityLow},
+		{name: "default learning", rules: defaultPriorityRules, payload: payload("", "learning", "", ""), want: priorityNormal},
+		{name: "every condition", rules: rules, payload: payload("", "live", "critical", "SECURITY"), want: priorityHigh},
+		{name: "some conditions", rules: rules, payload: payload("", "live", "Critical", ""), want: priorityNormal},
+		{name: "event type", rules: rules, payload: payload("public_incident.incident_updated_v2", "closed", "", ""), want: priorityLow},
+		{name: "other event type", rules: rules, payload: payload("public_incident.incident_created_v2", "closed", "", ""), want: priorityNormal},
+		{name: "first match", rules: append([]PriorityRule{{Priority: "normal"}}, rules...), payload: payload("", "live", "Critical", "Security"), want: priorityNormal},
+		{name: "no rules", payload: payload("", "live", "Critical", "Security"), want: priorityNormal},
+	}
+	for _, test := range tests {
+		s := &IncidentJiraSync{config: Config{PriorityRules: test.rules}}
+		if got := s.classifyEvent(test.payload); got != test.want {
+			t.Errorf("%s: classifyEvent() = %s, want %s", test.name, priorityName(got), priorityName(test.want))
+		}
+	}
+}
+
+func TestLoadPriorityRules(t *testing.T) {
+	if rules, err := loadPriorityRules(""); err != nil || !reflect.DeepEqual(rules, defaultPriorityRules) {
+		t.Errorf("loadPriorityRules(\"\") = %v, %v, want the default rules", rules, err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []PriorityRule
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			content: `{"rules": [{"priority": "high", "severities": ["Critical"]}]}`,
+			want:    []PriorityRule{{Priority: "high", Severities: []string{"Critical"}}},
+		},
+		{name: "invalid priority", content: `{"rules": [{"priority": "urgent"}]}`, wantErr: `rule 0 has invalid priority "urgent"`},
+		{name: "malformed", content: `{"rules": [`, wantErr: "failed to parse"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "priority.json")
+		if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		rules, err := loadPriorityRules(path)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: err = %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(rules, test.want) {
+			t.Errorf("%s: loadPriorityRules() = %+v, %v, want %+v", test.name, rules, err, test.want)
+		}
+	}
+
+	if _, err := loadPriorityRules(filepath.Join(t.TempDir(), "missing.json")); err == nil || !strings.Contains(err.Error(), "failed to read") {
+		t.Errorf("loadPriorityRules() of a missing file = %v, want a read error", err)
+	}
+}
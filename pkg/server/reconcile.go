@@ -0,0 +1,310 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// reconcileEventType is the event type reconciliation sweeps sync incidents under
+const reconcileEventType = "reconcile"
+
+// reconcileSweep is the outcome of one reconciliation sweep
+type reconcileSweep struct {
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Since is the earliest update of the incidents checked
+	Since     time.Time `json:"since"`
+	Incidents int       `json:"incidents"`
+	InSync    int       `json:"in_sync"`
+	Repaired  int       `json:"repaired"`
+	Skipped   int       `json:"skipped"`
+	Failed    int       `json:"failed"`
+	// RepairedIssues lists the Jira fields rewritten on each issue that had drifted
+	RepairedIssues map[string][]string `json:"repaired_issues,omitempty"`
+	Error          string              `json:"error,omitempty"`
+}
+
+// reconciler runs reconciliation sweeps one at a time and keeps the last one
+type reconciler struct {
+	mu      sync.Mutex
+	running bool
+	last    *reconcileSweep
+}
+
+// driftCheck collects the Jira fields found to differ from incident.io while reconciling one
+// incident
+type driftCheck struct {
+	mu      sync.Mutex
+	drifted map[string][]string
+}
+
+func (c *driftCheck) record(jiraIssueKey string, fieldIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.drifted == nil {
+		c.drifted = make(map[string][]string)
+	}
+	c.drifted[jiraIssueKey] = append(c.drifted[jiraIssueKey], fieldIDs...)
+}
+
+type driftCheckKey struct{}
+
+// withDriftCheck makes the Jira writes under ctx skip fields that already hold the value
+func withDriftCheck(ctx context.Context, check *driftCheck) context.Context {
+	return context.WithValue(ctx, driftCheckKey{}, check)
+}
+
+func driftCheckFrom(ctx context.Context) *driftCheck {
+	check, _ := ctx.Value(driftCheckKey{}).(*driftCheck)
+	return check
+}
+
+// jsonSubset reports whether have holds want: objects need every key of want with a matching
+// value (Jira adds IDs and links to what was written), arrays need matching elements in order
+func jsonSubset(want, have interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		haveMap, isMap := have.(map[string]interface{})
+		if !isMap {
+			return false
+		}
+		for key, value := range want {
+			if !jsonSubset(value, haveMap[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		haveList, isList := have.([]interface{})
+		if !isList || len(haveList) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !jsonSubset(want[i], haveList[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(want, have)
+}
+
+// writeNeeded reports whether an edit changes the issue, recording the fields it changes.
+// Edits made of operations (add, remove) can't be compared and are always made.
+func (s *IncidentJiraSync) writeNeeded(ctx context.Context, check *driftCheck, jiraIssueKey string, update jira.UpdateRequest) bool {
+	fieldIDs := update.FieldIDs()
+	if len(update.Update) > 0 || len(update.Fields) == 0 {
+		check.record(jiraIssueKey, fieldIDs)
+		return true
+	}
+
+	var issue struct {
+		Fields map[string]interface{} `json:"fields"`
+	}
+	path := fmt.Sprintf("%s?fields=%s", jira.IssuePath(jiraIssueKey), strings.Join(fieldIDs, ","))
+	if err := s.jira.GetFresh(ctx, path, &issue); err != nil {
+		log.Printf("Warning: failed to read %s to check for drift, writing anyway: %v", jiraIssueKey, err)
+		check.record(jiraIssueKey, fieldIDs)
+		return true
+	}
+
+	var drifted []string
+	for fieldID, value := range update.Fields {
+		var want interface{}
+		encoded, err := json.Marshal(value)
+		if err == nil {
+			err = json.Unmarshal(encoded, &want)
+		}
+		if err != nil || !jsonSubset(want, issue.Fields[fieldID]) {
+			drifted = append(drifted, fieldID)
+		}
+	}
+	if len(drifted) == 0 {
+		return false
+	}
+
+	sort.Strings(drifted)
+	log.Printf("%s drifted from incident.io in %s, repairing", strings.Join(drifted, ", "), jiraIssueKey)
+	for _, fieldID := range drifted {
+		reconcileDriftedFieldsTotal.inc(fieldID)
+	}
+	check.record(jiraIssueKey, drifted)
+	return true
+}
+
+// runReconciler sweeps recently updated incidents every RECONCILE_INTERVAL
+func (s *IncidentJiraSync) runReconciler() {
+	log.Printf("Reconciling incidents updated in the last %s every %s", s.config.ReconcileLookback, s.config.ReconcileInterval)
+	ticker := time.NewTicker(s.config.ReconcileInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.reconcile(context.Background()); err != nil && !errors.Is(err, errReconcileRunning) {
+			log.Printf("Reconciliation sweep failed: %v", err)
+		}
+	}
+}
+
+var errReconcileRunning = errors.New("a reconciliation sweep is already running")
+
+// reconcile syncs every incident updated within RECONCILE_LOOKBACK, in every organization, to
+// its Jira issues, writing only the fields whose Jira value no longer matches, so missed or
+// failed webhooks are repaired
+func (s *IncidentJiraSync) reconcile(ctx context.Context) (*reconcileSweep, error) {
+	s.reconciler.mu.Lock()
+	if s.reconciler.running {
+		s.reconciler.mu.Unlock()
+		return nil, errReconcileRunning
+	}
+	s.reconciler.running = true
+	s.reconciler.mu.Unlock()
+
+	sweep := &reconcileSweep{StartedAt: time.Now().UTC()}
+	sweep.Since = sweep.StartedAt.Add(-s.config.ReconcileLookback)
+	defer func() {
+		finished := time.Now().UTC()
+		sweep.FinishedAt = &finished
+		s.reconciler.mu.Lock()
+		s.reconciler.running = false
+		s.reconciler.last = sweep
+		s.reconciler.mu.Unlock()
+	}()
+
+	// Every organization is swept, that of INCIDENT_API_TOKEN first
+	for _, organization := range s.allOrganizations() {
+		incidents, err := organization.client.ListIncidentsUpdatedSince(ctx, sweep.Since)
+		if err != nil {
+			sweep.Error = err.Error()
+			reconcileSweepsTotal.inc("failed")
+			return sweep, err
+		}
+
+		for _, incident := range incidents {
+			if incident.ExternalIssueReference.IssueName == "" {
+				continue
+			}
+			if err := s.jiraBudget.wait(ctx); err != nil {
+				sweep.Error = err.Error()
+				reconcileSweepsTotal.inc("failed")
+				return sweep, err
+			}
+
+			sweep.Incidents++
+			outcome, drifted, err := s.reconcileIncident(withOrganization(ctx, organization.name), incident)
+			reconcileIncidentsTotal.inc(outcome)
+			switch outcome {
+			case "in_sync":
+				sweep.InSync++
+			case "repaired":
+				sweep.Repaired++
+				if sweep.RepairedIssues == nil {
+					sweep.RepairedIssues = make(map[string][]string)
+				}
+				for issueKey, fieldIDs := range drifted {
+					sweep.RepairedIssues[issueKey] = fieldIDs
+				}
+			case "skipped":
+				sweep.Skipped++
+			default:
+				sweep.Failed++
+				log.Printf("Reconciliation of incident %s failed: %v", incident.ID, err)
+			}
+		}
+	}
+
+	log.Printf("Reconciled %d incidents: %d in sync, %d repaired, %d skipped, %d failed",
+		sweep.Incidents, sweep.InSync, sweep.Repaired, sweep.Skipped, sweep.Failed)
+	reconcileSweepsTotal.inc("complete")
+	return sweep, nil
+}
+
+// reconcileIncident syncs one incident with drift checks and returns "in_sync", "repaired",
+// "skipped" or "failed", with the fields rewritten on each issue
+func (s *IncidentJiraSync) reconcileIncident(ctx context.Context, incident incidentio.Incident) (string, map[string][]string, error) {
+	check := &driftCheck{}
+	ctx, cancel := s.processingContext(withDriftCheck(ctx, check))
+	defer cancel()
+
+	payload := incidentio.WebhookPayload{EventType: reconcileEventType, Incident: incident}
+	_, err := s.processIncidentUpdate(ctx, payload)
+
+	check.mu.Lock()
+	defer check.mu.Unlock()
+	switch {
+	case errors.Is(err, errIncidentSkipped), errors.Is(err, errIssueRestricted):
+		return "skipped", nil, nil
+	case err != nil:
+		return "failed", check.drifted, err
+	case len(check.drifted) > 0:
+		return "repaired", check.drifted, nil
+	}
+	return "in_sync", nil, nil
+}
+
+// adminReconcileHandler reports the reconciliation schedule and the last sweep
+func (s *IncidentJiraSync) adminReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.reconciler.mu.Lock()
+	defer s.reconciler.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"interval":   s.config.ReconcileInterval.String(),
+		"lookback":   s.config.ReconcileLookback.String(),
+		"running":    s.reconciler.running,
+		"last_sweep": s.reconciler.last,
+	})
+}
+
+// adminReconcileRunHandler starts a reconciliation sweep now
+func (s *IncidentJiraSync) adminReconcileRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.reconciler.mu.Lock()
+	running := s.reconciler.running
+	s.reconciler.mu.Unlock()
+	if running {
+		http.Error(w, errReconcileRunning.Error(), http.StatusConflict)
+		return
+	}
+
+	go func() {
+		if _, err := s.reconcile(context.Background()); err != nil && !errors.Is(err, errReconcileRunning) {
+			log.Printf("Reconciliation sweep failed: %v", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "started"})
+}
+
+var (
+	reconcileSweepsTotal = newCounterVec(
+		"incident_jira_webhook_reconcile_sweeps_total",
+		"Reconciliation sweeps, by outcome (complete or failed).",
+		"outcome")
+	reconcileIncidentsTotal = newCounterVec(
+		"incident_jira_webhook_reconcile_incidents_total",
+		"Incidents checked by reconciliation sweeps, by outcome (in_sync, repaired, skipped or failed).",
+		"outcome")
+	reconcileDriftedFieldsTotal = newCounterVec(
+		"incident_jira_webhook_reconcile_drifted_fields_total",
+		"Jira fields found out of sync with incident.io and rewritten by reconciliation sweeps, by Jira field.",
+		"field")
+)
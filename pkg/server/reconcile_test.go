@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+func TestJSONSubset(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		have string
+		ok   bool
+	}{
+		{name: "equal", want: `{"value": "High"}`, have: `{"value": "High"}`, ok: true},
+		{name: "extra keys in Jira", want: `{"value": "High"}`, have: `{"value": "High", "id": "10001", "self": "https://jira"}`, ok: true},
+		{name: "different value", want: `{"value": "High"}`, have: `{"value": "Low"}`},
+		{name: "missing key", want: `{"value": "High"}`, have: `{"id": "10001"}`},
+		{name: "list in order", want: `[{"objectId": "1"}, {"objectId": "2"}]`, have: `[{"objectId": "1", "id": "ws:1"}, {"objectId": "2", "id": "ws:2"}]`, ok: true},
+		{name: "list out of order", want: `[{"objectId": "1"}, {"objectId": "2"}]`, have: `[{"objectId": "2"}, {"objectId": "1"}]`},
+		{name: "list of another length", want: `[{"objectId": "1"}]`, have: `[{"objectId": "1"}, {"objectId": "2"}]`},
+		{name: "cleared field", want: `null`, have: `null`, ok: true},
+		{name: "set field", want: `null`, have: `"text"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var want, have interface{}
+			json.Unmarshal([]byte(test.want), &want)
+			json.Unmarshal([]byte(test.have), &have)
+			if got := jsonSubset(want, have); got != test.ok {
+				t.Errorf("jsonSubset(%s, %s) = %v, want %v", test.want, test.have, got, test.ok)
+			}
+		})
+	}
+}
+
+func TestWriteNeeded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/3/issue/OPS-1" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"fields": {"customfield_1": {"value": "High", "id": "10001"}, "customfield_2": "old"}}`))
+	}))
+	defer server.Close()
+	s := &IncidentJiraSync{jira: jira.NewClient(server.URL, "user", "token", server.Client())}
+
+	tests := []struct {
+		name        string
+		update      jira.UpdateRequest
+		wantNeeded  bool
+		wantDrifted []string
+	}{
+		{
+			name:   "in sync",
+			update: jira.UpdateRequest{Fields: map[string]interface{}{"customfield_1": jira.SelectValue{Value: "High"}}},
+		},
+		{
+			name: "drifted",
+			update: jira.UpdateRequest{Fields: map[string]interface{}{
+				"customfield_1": jira.SelectValue{Value: "High"},
+				"customfield_2": "new",
+			}},
+			wantNeeded:  true,
+			wantDrifted: []string{"customfield_2"},
+		},
+		{
+			name: "operations",
+			update: jira.UpdateRequest{Update: map[string][]map[string]interface{}{
+				"customfield_3": {{"add": jira.ComponentValue{ID: "ws:1", ObjectID: "1"}}},
+			}},
+			wantNeeded:  true,
+			wantDrifted: []string{"customfield_3"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			check := &driftCheck{}
+			if got := s.writeNeeded(context.Background(), check, "OPS-1", test.update); got != test.wantNeeded {
+				t.Errorf("writeNeeded = %v, want %v", got, test.wantNeeded)
+			}
+			if !reflect.DeepEqual(check.drifted["OPS-1"], test.wantDrifted) {
+				t.Errorf("drifted fields = %v, want %v", check.drifted["OPS-1"], test.wantDrifted)
+			}
+		})
+	}
+}
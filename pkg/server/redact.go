@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const redactedValue = "[REDACTED]"
+
+// redactor removes sensitive data from payloads and values before they are logged.
+// Paths are dot-separated JSON paths where "*" matches any object key or array element,
+// e.g. "incident.summary" or "*.custom_field_entries.*.values". Patterns are regular
+// expressions; any string value or log text matching one is replaced.
+type redactor struct {
+	paths    [][]string
+	patterns []*regexp.Regexp
+}
+
+func newRedactor(paths, patterns []string) (*redactor, error) {
+	r := &redactor{}
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path != "" {
+			r.paths = append(r.paths, strings.Split(path, "."))
+		}
+	}
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// redactString replaces every match of the configured patterns in s
+func (r *redactor) redactString(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, redactedValue)
+	}
+	return s
+}
+
+// redactJSON returns data with configured paths and matching values redacted. Data that
+// isn't valid JSON is treated as text.
+func (r *redactor) redactJSON(data []byte) string {
+	if len(r.paths) == 0 && len(r.patterns) == 0 {
+		return string(data)
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return r.redactString(string(data))
+	}
+
+	document = r.redactValue(document, nil)
+	redacted, err := json.Marshal(document)
+	if err != nil {
+		return redactedValue
+	}
+	return string(redacted)
+}
+
+// redactPartialJSON redacts the start of a JSON document cut short, which can't be parsed to
+// find the configured paths. With paths configured it is withheld entirely; otherwise the
+// patterns are applied to it as text.
+func (r *redactor) redactPartialJSON(data []byte) string {
+	if len(r.paths) > 0 {
+		return redactedValue
+	}
+	return r.redactString(string(data))
+}
+
+// redactValue walks a decoded JSON value, tracking the path from the document root
+func (r *redactor) redactValue(value interface{}, path []string) interface{} {
+	if r.pathRedacted(path) {
+		return redactedValue
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = r.redactValue(child, append(path, key))
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.redactValue(child, append(path, fmt.Sprintf("%d", i)))
+		}
+	case string:
+		return r.redactString(v)
+	}
+	return value
+}
+
+func (r *redactor) pathRedacted(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	for _, redactedPath := range r.paths {
+		if len(redactedPath) != len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range redactedPath {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
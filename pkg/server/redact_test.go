@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRedactJSON(t *testing.T) {
+	r, err := newRedactor(
+		[]string{"incident.summary", "*.custom_field_entries.*.values", " "},
+		[]string{`[\w.]+@example\.com`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redacted := r.redactJSON([]byte(`{
+		"incident": {"summary": "secret", "name": "Reported by ops@example.com"},
+		"public_incident.incident_updated_v2": {"custom_field_entries": [{"values": ["a"], "custom_field": {"name": "Team"}}]}
+	}`))
+	var got, want interface{}
+	if err := json.Unmarshal([]byte(redacted), &got); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(`{
+		"incident": {"summary": "[REDACTED]", "name": "Reported by [REDACTED]"},
+		"public_incident.incident_updated_v2": {"custom_field_entries": [{"values": "[REDACTED]", "custom_field": {"name": "Team"}}]}
+	}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactJSON() = %s", redacted)
+	}
+
+	if text := r.redactJSON([]byte("not JSON from ops@example.com")); text != "not JSON from [REDACTED]" {
+		t.Errorf("redactJSON() of text = %q", text)
+	}
+}
+
+func TestNewRedactorRejectsInvalidPatterns(t *testing.T) {
+	if _, err := newRedactor(nil, []string{"("}); err == nil {
+		t.Error("invalid pattern was accepted")
+	}
+	r, err := newRedactor(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := `{"a": 1}`; r.redactJSON([]byte(body)) != body {
+		t.Error("redactor without paths or patterns changed the body")
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisClient is a minimal RESP client covering the few commands this service needs.
+// Each command uses its own connection, which keeps the client simple and is cheap
+// compared to the Jira calls it guards.
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	useTLS   bool
+}
+
+// newRedisClient parses a redis:// or rediss:// URL, e.g. redis://:password@host:6379/0
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported Redis URL scheme: %s", u.Scheme)
+	}
+
+	client := &redisClient{addr: u.Host, useTLS: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		client.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if password, set := u.User.Password(); set {
+		client.password = password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if client.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database: %s", db)
+		}
+	}
+	return client, nil
+}
+
+// do runs a single command and returns its reply: string, int64, nil or []interface{}
+func (c *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if c.useTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+	}
+
+	reader := bufio.NewReader(conn)
+	if c.password != "" {
+		if _, err := roundTrip(conn, reader, "AUTH", c.password); err != nil {
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := roundTrip(conn, reader, "SELECT", strconv.Itoa(c.db)); err != nil {
+			return nil, err
+		}
+	}
+
+	return roundTrip(conn, reader, args...)
+}
+
+func roundTrip(conn net.Conn, reader *bufio.Reader, args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return nil, fmt.Errorf("failed to write Redis command: %w", err)
+	}
+	return readReply(reader)
+}
+
+// readReply parses one RESP reply
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Redis reply: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty Redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("Redis error: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, fmt.Errorf("failed to read Redis reply: %w", err)
+		}
+		return string(buf[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected Redis reply: %q", line)
+}
@@ -0,0 +1,373 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the Redis commands this service sends from memory, ignoring expiries
+type fakeRedis struct {
+	addr     string
+	password string
+
+	mu       sync.Mutex
+	data     map[string]string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	r := &fakeRedis{addr: listener.Addr().String(), password: password, data: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := r.password == ""
+	for {
+		command, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range command.([]interface{}) {
+			args = append(args, arg.(string))
+		}
+		if args[0] != "AUTH" && !authenticated {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		conn.Write([]byte(r.reply(args, &authenticated)))
+	}
+}
+
+func (r *fakeRedis) reply(args []string, authenticated *bool) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, args[0])
+
+	switch args[0] {
+	case "AUTH":
+		if args[1] != r.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authenticated = true
+		return "+OK\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "SET":
+		if _, exists := r.data[args[1]]; exists && len(args) > 3 && args[3] == "NX" {
+			return "$-1\r\n"
+		}
+		r.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "EXISTS":
+		if _, exists := r.data[args[1]]; exists {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "EVAL":
+		// The unlock and refresh scripts: delete or extend KEYS[1] if it holds ARGV[1]
+		if r.data[args[3]] != args[4] {
+			return ":0\r\n"
+		}
+		switch args[1] {
+		case redisUnlockScript:
+			delete(r.data, args[3])
+		case redisRefreshScript:
+			r.commands = append(r.commands, "PEXPIRE")
+		default:
+			return "-ERR unknown script\r\n"
+		}
+		return ":1\r\n"
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+// received returns the commands received so far, by name
+func (r *fakeRedis) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		reply   string
+		want    interface{}
+		wantErr bool
+	}{
+		{reply: "+OK\r\n", want: "OK"},
+		{reply: ":42\r\n", want: int64(42)},
+		{reply: "$5\r\nhello\r\n", want: "hello"},
+		{reply: "$0\r\n\r\n", want: ""},
+		{reply: "$-1\r\n", want: nil},
+		{reply: "*2\r\n$1\r\na\r\n:1\r\n", want: []interface{}{"a", int64(1)}},
+		{reply: "-ERR unknown command\r\n", wantErr: true},
+		{reply: "\r\n", wantErr: true},
+		{reply: "$5\r\nhel", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := readReply(bufio.NewReader(strings.NewReader(test.reply)))
+		if (err != nil) != test.wantErr || !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("readReply(%q) = %#v, %v, want %#v (error %v)", test.reply, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestRoundTripEncodesCommand(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		reader := bufio.NewReader(server)
+		var command strings.Builder
+		for i := 0; i < 7; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			command.WriteString(line)
+		}
+		received <- command.String()
+		server.Write([]byte("+OK\r\n"))
+	}()
+
+	reply, err := roundTrip(client, bufio.NewReader(client), "SET", "key", "a b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "OK" {
+		t.Errorf("reply = %#v, want OK", reply)
+	}
+	if command, want := <-received, "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$3\r\na b\r\n"; command != want {
+		t.Errorf("command = %q, want %q", command, want)
+	}
+}
+
+func TestNewRedisClient(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    redisClient
+		wantErr bool
+	}{
+		{url: "redis://cache", want: redisClient{addr: "cache:6379"}},
+		{url: "rediss://:secret@cache:6380/2", want: redisClient{addr: "cache:6380", password: "secret", db: 2, useTLS: true}},
+		{url: "http://cache", wantErr: true},
+		{url: "redis://cache/db", wantErr: true},
+	}
+	for _, test := range tests {
+		client, err := newRedisClient(test.url)
+		if (err != nil) != test.wantErr || !test.wantErr && *client != test.want {
+			t.Errorf("newRedisClient(%q) = %+v, %v, want %+v (error %v)", test.url, client, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestRedisClientAuthenticatesAndSelects(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	client, err := newRedisClient("redis://:secret@" + server.addr + "/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := client.do(context.Background(), "EXISTS", "key")
+	if err != nil || reply != int64(0) {
+		t.Fatalf("do() = %#v, %v, want 0", reply, err)
+	}
+	if commands := server.received(); !reflect.DeepEqual(commands, []string{"AUTH", "SELECT", "EXISTS"}) {
+		t.Errorf("commands = %q, want AUTH, SELECT, then the command", commands)
+	}
+
+	client.password = "wrong"
+	if _, err := client.do(context.Background(), "EXISTS", "key"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("do() with a wrong password = %v, want the Redis error", err)
+	}
+}
+
+func TestRedisLocker(t *testing.T) {
+	server := newFakeRedis(t, "")
+	client, err := newRedisClient("redis://" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := &redisLocker{client: client, prefix: "lock:", ttl: time.Minute, pollEvery: time.Millisecond}
+
+	unlock, err := locker.Lock(context.Background(), "SUP-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := locker.Lock(ctx, "SUP-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lock() of a held key = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// A lock taken over after expiring isn't released by its previous holder
+	server.mu.Lock()
+	server.data["lock:SUP-1"] = "another holder"
+	server.mu.Unlock()
+	unlock()
+	server.mu.Lock()
+	holder := server.data["lock:SUP-1"]
+	delete(server.data, "lock:SUP-1")
+	server.mu.Unlock()
+	if holder != "another holder" {
+		t.Errorf("unlock released another holder's lock")
+	}
+
+	unlock, err = locker.Lock(context.Background(), "SUP-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, held := server.data["lock:SUP-1"]; held {
+		t.Error("unlock left the lock held")
+	}
+}
+
+func TestRedisLockerWaitsForRelease(t *testing.T) {
+	server := newFakeRedis(t, "")
+	client, err := newRedisClient("redis://" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := &redisLocker{client: client, prefix: "lock:", ttl: time.Minute, pollEvery: time.Millisecond}
+
+	unlock, err := locker.Lock(context.Background(), "SUP-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan error, 1)
+	go func() {
+		unlock, err := locker.Lock(context.Background(), "SUP-1")
+		if err == nil {
+			unlock()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("Lock() of a held key returned %v before it was released", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("Lock() after release = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock() still waiting after the key was released")
+	}
+
+	// Other keys don't wait
+	unlock, err = locker.Lock(context.Background(), "SUP-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestRedisLockerUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client, err := newRedisClient("redis://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := &redisLocker{client: client, prefix: "lock:", ttl: time.Minute, pollEvery: time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := locker.Lock(ctx, "SUP-1"); err == nil || !strings.Contains(err.Error(), "failed to acquire lock lock:SUP-1") {
+		t.Errorf("Lock() without Redis = %v, want an error acquiring the lock", err)
+	}
+}
+
+func TestRedisLockerRefreshes(t *testing.T) {
+	server := newFakeRedis(t, "")
+	client, err := newRedisClient("redis://" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := &redisLocker{client: client, prefix: "lock:", ttl: 30 * time.Millisecond, pollEvery: time.Millisecond}
+
+	unlock, err := locker.Lock(context.Background(), "SUP-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	unlock()
+
+	refreshes := 0
+	for _, command := range server.received() {
+		if command == "PEXPIRE" {
+			refreshes++
+		}
+	}
+	if refreshes < 2 {
+		t.Errorf("lock held for over three TTLs was extended %d times", refreshes)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if commands := server.received(); commands[len(commands)-1] != "EVAL" {
+		t.Errorf("commands = %q, want none after unlocking", commands)
+	}
+}
+
+func TestRedisDeliveryStore(t *testing.T) {
+	server := newFakeRedis(t, "")
+	client, err := newRedisClient("redis://" + server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &redisDeliveryStore{client: client, prefix: "delivery:"}
+	ctx := context.Background()
+
+	if processed, err := store.DeliveryProcessed(ctx, "msg_1", time.Hour); err != nil || processed {
+		t.Fatalf("DeliveryProcessed() = %v, %v before recording", processed, err)
+	}
+	if err := store.RecordDelivery(ctx, "msg_1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if processed, err := store.DeliveryProcessed(ctx, "msg_1", time.Hour); err != nil || !processed {
+		t.Errorf("DeliveryProcessed() = %v, %v after recording", processed, err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, found := server.data["delivery:msg_1"]; !found {
+		t.Errorf("keys = %v, want the prefixed delivery ID", server.data)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// webhookEventTypes returns the sorted event types this service should be subscribed to
+func (s *IncidentJiraSync) webhookEventTypes() []string {
+	seen := make(map[string]bool)
+	var eventTypes []string
+	for _, events := range []map[string]bool{s.config.Events, s.config.InitialSyncEvents} {
+		for eventType := range events {
+			if !seen[eventType] {
+				seen[eventType] = true
+				eventTypes = append(eventTypes, eventType)
+			}
+		}
+	}
+	sort.Strings(eventTypes)
+	return eventTypes
+}
+
+// registerWebhookEndpoint makes sure a webhook endpoint of an incident.io organization delivers
+// the subscribed event types to the organization's webhook URL on this service, creating or
+// updating the endpoint as needed
+func (s *IncidentJiraSync) registerWebhookEndpoint(ctx context.Context, organization *incidentOrganization) error {
+	webhookURL := strings.TrimRight(s.config.PublicURL, "/") + organization.webhookURL
+	eventTypes := s.webhookEventTypes()
+
+	endpoints, err := organization.client.ListWebhookEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range endpoints {
+		if strings.TrimRight(endpoint.URL, "/") != webhookURL {
+			continue
+		}
+
+		existing := append([]string(nil), endpoint.EventTypes...)
+		sort.Strings(existing)
+		if strings.Join(existing, ",") == strings.Join(eventTypes, ",") {
+			log.Printf("incident.io webhook endpoint %s already delivers %s to %s", endpoint.ID, strings.Join(eventTypes, ", "), webhookURL)
+		} else {
+			endpoint.EventTypes = eventTypes
+			if _, err := organization.client.UpdateWebhookEndpoint(ctx, endpoint); err != nil {
+				return err
+			}
+			log.Printf("Updated incident.io webhook endpoint %s to deliver %s", endpoint.ID, strings.Join(eventTypes, ", "))
+		}
+		logSigningSecretGuidance(organization, endpoint.ID)
+		return nil
+	}
+
+	created, err := organization.client.CreateWebhookEndpoint(ctx, incidentio.WebhookEndpoint{URL: webhookURL, EventTypes: eventTypes})
+	if err != nil {
+		return err
+	}
+	log.Printf("Created incident.io webhook endpoint %s delivering %s to %s", created.ID, strings.Join(eventTypes, ", "), webhookURL)
+	logSigningSecretGuidance(organization, created.ID)
+	return nil
+}
+
+// logSigningSecretGuidance explains where the signing secret of an endpoint goes. The secret
+// itself is never logged.
+func logSigningSecretGuidance(organization *incidentOrganization, endpointID string) {
+	if organization.secretSet {
+		return
+	}
+	log.Printf("%s is not set: copy the signing secret of webhook endpoint %s from Settings > Webhooks in incident.io into %s", organization.secretEnv, endpointID, organization.secretEnv)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// reloadableSettings are the settings read from files that SIGHUP applies without a restart
+type reloadableSettings struct {
+	MappingRules       []mapping.Rule
+	ShadowMappingRules []mapping.Rule
+	PriorityRules      []PriorityRule
+	FeatureFlags       map[string]FeatureFlag
+	Templates          map[string]*template.Template
+	CreationTemplates  map[string]*creationTemplate
+}
+
+// reloadableConfigFields are the Config fields held in reloadableSettings
+var reloadableConfigFields = map[string]bool{
+	"MappingRules":       true,
+	"ShadowMappingRules": true,
+	"PriorityRules":      true,
+	"FeatureFlags":       true,
+	"Templates":          true,
+	"CreationTemplates":  true,
+}
+
+func reloadableFrom(config Config) *reloadableSettings {
+	return &reloadableSettings{
+		MappingRules:       config.MappingRules,
+		ShadowMappingRules: config.ShadowMappingRules,
+		PriorityRules:      config.PriorityRules,
+		FeatureFlags:       config.FeatureFlags,
+		Templates:          config.Templates,
+		CreationTemplates:  config.CreationTemplates,
+	}
+}
+
+// settings returns the reloadable settings in effect
+func (s *IncidentJiraSync) settings() *reloadableSettings {
+	if current := s.reloadable.Load(); current != nil {
+		return current
+	}
+	return reloadableFrom(s.config)
+}
+
+// reloadConfig reads the configuration again, on SIGHUP. Mapping rules, priority rules, feature
+// flags and templates take effect at once; other changed settings are logged as needing a
+// restart. A configuration that fails to load is rejected and the running one kept.
+func (s *IncidentJiraSync) reloadConfig() {
+	// Rules imported through the admin API are written to MAPPING_RULES_FILE before they are
+	// applied, so reading the file and storing its rules must not interleave with an import
+	s.mappingsMu.Lock()
+	defer s.mappingsMu.Unlock()
+
+	config, err := LoadConfig()
+	if err != nil {
+		log.Printf("Failed to reload the configuration, keeping the running one: %v", err)
+		configReloadsTotal.inc("failed")
+		return
+	}
+
+	s.reloadable.Store(reloadableFrom(config))
+	s.recordConfiguredMappings()
+	configReloadsTotal.inc("success")
+	log.Printf("Reloaded configuration: %d mapping rules, %d shadow rules, %d priority rules, %d feature flags, %d templates",
+		len(config.MappingRules), len(config.ShadowMappingRules), len(config.PriorityRules), len(config.FeatureFlags), len(config.Templates)+len(config.CreationTemplates))
+	if changed := restartRequiredChanges(s.config, config); len(changed) > 0 {
+		log.Printf("Warning: changed settings need a restart to take effect: %s", strings.Join(changed, ", "))
+	}
+}
+
+// restartRequiredChanges names the Config fields, other than the reloadable ones, that differ
+func restartRequiredChanges(running, loaded Config) []string {
+	var changed []string
+	runningValue, loadedValue := reflect.ValueOf(running), reflect.ValueOf(loaded)
+	for i := 0; i < runningValue.NumField(); i++ {
+		name := runningValue.Type().Field(i).Name
+		if reloadableConfigFields[name] || !runningValue.Type().Field(i).IsExported() {
+			continue
+		}
+		if !reflect.DeepEqual(runningValue.Field(i).Interface(), loadedValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// secretSettingName matches the settings whose values are never dumped
+var secretSettingName = regexp.MustCompile(`(?i)token|secret|password|apikey`)
+
+// describeSetting turns a setting into JSON-friendly values for the SIGUSR1 dump: durations and
+// patterns as text, templates by name, secrets and URL passwords redacted
+func describeSetting(value reflect.Value) interface{} {
+	if !value.IsValid() {
+		return nil
+	}
+	if value.CanInterface() {
+		switch typed := value.Interface().(type) {
+		case time.Duration:
+			return typed.String()
+		case *time.Location:
+			if typed == nil {
+				return nil
+			}
+			return typed.String()
+		case *regexp.Regexp:
+			if typed == nil {
+				return nil
+			}
+			return typed.String()
+		case *template.Template:
+			if typed == nil {
+				return nil
+			}
+			return "(template " + typed.Name() + ")"
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return describeSetting(value.Elem())
+	case reflect.String:
+		text := value.String()
+		if parsed, err := url.Parse(text); err == nil && parsed.User != nil {
+			return parsed.Redacted()
+		}
+		return text
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return nil
+		}
+		items := make([]interface{}, value.Len())
+		for i := range items {
+			items[i] = describeSetting(value.Index(i))
+		}
+		return items
+	case reflect.Map:
+		if value.IsNil() {
+			return nil
+		}
+		entries := make(map[string]interface{}, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			entries[fmt.Sprint(iter.Key().Interface())] = describeSetting(iter.Value())
+		}
+		return entries
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if secretSettingName.MatchString(field.Name) {
+				if !value.Field(i).IsZero() {
+					fields[field.Name] = redactedValue
+				}
+				continue
+			}
+			fields[field.Name] = describeSetting(value.Field(i))
+		}
+		return fields
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return nil
+	}
+	return value.Interface()
+}
+
+// dumpState logs the effective configuration, secrets redacted, and runtime stats, on SIGUSR1
+func (s *IncidentJiraSync) dumpState() {
+	config := s.config
+	settings := s.settings()
+	config.MappingRules, config.ShadowMappingRules = settings.MappingRules, settings.ShadowMappingRules
+	config.PriorityRules, config.FeatureFlags, config.Templates = settings.PriorityRules, settings.FeatureFlags, settings.Templates
+
+	described := describeSetting(reflect.ValueOf(config))
+	if encoded, err := json.Marshal(described); err != nil {
+		log.Printf("Failed to dump the configuration: %v", err)
+	} else {
+		log.Printf("Effective configuration: %s", encoded)
+	}
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	stats := map[string]interface{}{
+		"goroutines":          runtime.NumGoroutine(),
+		"heap_alloc_bytes":    memory.HeapAlloc,
+		"retry_queue_depth":   len(s.retryQueue),
+		"jira_cache_entries":  s.jira.Cache.Len(),
+		"jira_rate_limit":     s.jiraBudget.status(),
+		"incident_rate_limit": s.incidentBudget.status(),
+	}
+	if s.unknownEvents != nil {
+		types, _, untracked := s.unknownEvents.snapshot()
+		stats["unknown_event_types"], stats["untracked_unknown_deliveries"] = len(types), untracked
+	}
+	if warnings, checkedAt := s.lint.snapshot(); !checkedAt.IsZero() {
+		stats["config_warnings"] = len(warnings)
+	}
+	encoded, err := json.Marshal(stats)
+	if err != nil {
+		log.Printf("Failed to dump runtime stats: %v", err)
+		return
+	}
+	log.Printf("Runtime stats: %s", encoded)
+}
+
+var configReloadsTotal = newCounterVec(
+	"incident_jira_webhook_config_reloads_total",
+	"Configuration reloads on SIGHUP, by outcome (success or failed).",
+	"outcome")
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Formats a reliability report is printed in
+const (
+	reportFormatText     = "text"
+	reportFormatJSON     = "json"
+	reportFormatMarkdown = "markdown"
+)
+
+// defaultReportWindow is the period a reliability report covers unless told otherwise, a week
+// for the weekly ops review
+const defaultReportWindow = 7 * 24 * time.Hour
+
+// reportTopCount is how many failure causes and fields a reliability report lists
+const reportTopCount = 10
+
+// maxFailureCauseLength keeps failure causes readable in a report
+const maxFailureCauseLength = 160
+
+// errNoHistory is returned for reports against a state store that keeps no sync history
+var errNoHistory = errors.New("the memory state store keeps no sync history, reports need STATE_STORE=postgres")
+
+// failureCauseIDs are the incident and catalog IDs replaced in failure causes, so the same
+// failure on different incidents counts as one cause
+var failureCauseIDs = regexp.MustCompile(`\b[0-9A-Z]{26}\b|\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+
+// markdownCell escapes a failure cause for a markdown table cell, keeping its <issue> and <id>
+// placeholders from being read as HTML
+var markdownCell = strings.NewReplacer("|", `\|`, "<", "&lt;", ">", "&gt;")
+
+// reliabilityReport summarizes the sync history of a period
+type reliabilityReport struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Webhooks processed, by outcome; ignored webhooks count towards none of the rates
+	Processed int `json:"webhooks_processed"`
+	Succeeded int `json:"webhooks_succeeded"`
+	Partial   int `json:"webhooks_partial"`
+	Failed    int `json:"webhooks_failed"`
+	Ignored   int `json:"webhooks_ignored"`
+	// SuccessRate is the share of processed webhooks that succeeded or were accepted with
+	// fields queued for retry; nil when none were processed
+	SuccessRate       *float64 `json:"success_rate"`
+	LatencyP95Seconds *float64 `json:"latency_p95_seconds"`
+
+	JiraWrites       int `json:"jira_writes"`
+	JiraWritesFailed int `json:"jira_writes_failed"`
+
+	FailureCauses []failureCause `json:"top_failure_causes"`
+	FailingFields []failingField `json:"most_failing_fields"`
+}
+
+// failureCause is a failure message, with its IDs and issue keys replaced, and how often a
+// webhook or Jira write failed with it
+type failureCause struct {
+	Source string `json:"source"`
+	Cause  string `json:"cause"`
+	Count  int    `json:"count"`
+}
+
+// failingField is a Jira field and the number of failed writes it was part of
+type failingField struct {
+	FieldID  string `json:"field_id"`
+	Failures int    `json:"failures"`
+}
+
+// normalizeFailureCause strips what differs between occurrences of the same failure from an
+// event's message
+func normalizeFailureCause(event streamEvent) string {
+	message := event.Message
+	if event.Type == streamJiraWrite && event.Field != "" {
+		message = strings.TrimPrefix(message, event.Field+": ")
+	}
+	message = jiraIssueKeyPattern.ReplaceAllString(message, "<issue>")
+	message = failureCauseIDs.ReplaceAllString(message, "<id>")
+	if runes := []rune(message); len(runes) > maxFailureCauseLength {
+		message = string(runes[:maxFailureCauseLength]) + "..."
+	}
+	return message
+}
+
+// buildReliabilityReport aggregates the sync history from from up to to
+func buildReliabilityReport(ctx context.Context, history stateStore, from, to time.Time) (reliabilityReport, error) {
+	report := reliabilityReport{From: from, To: to}
+	var latencies []int64
+	causes := make(map[failureCause]int)
+	fields := make(map[string]int)
+
+	err := history.ScanHistory(ctx, from, to, func(event streamEvent) error {
+		switch event.Type {
+		case streamWebhookProcessed:
+			switch event.Outcome {
+			case "success":
+				report.Succeeded++
+			case "partial":
+				report.Partial++
+			case "failed":
+				report.Failed++
+				causes[failureCause{Source: "webhook", Cause: normalizeFailureCause(event)}]++
+			default:
+				report.Ignored++
+				return nil
+			}
+			report.Processed++
+			if event.DurationMS > 0 {
+				latencies = append(latencies, event.DurationMS)
+			}
+		case streamJiraWrite:
+			report.JiraWrites++
+			if event.Outcome != "failed" {
+				return nil
+			}
+			report.JiraWritesFailed++
+			causes[failureCause{Source: "jira_write", Cause: normalizeFailureCause(event)}]++
+			if event.Field != "" {
+				for _, fieldID := range strings.Split(event.Field, ", ") {
+					fields[fieldID]++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if report.Processed > 0 {
+		rate := float64(report.Succeeded+report.Partial) / float64(report.Processed)
+		report.SuccessRate = &rate
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		p95 := (time.Duration(latencies[(len(latencies)*95+99)/100-1]) * time.Millisecond).Seconds()
+		report.LatencyP95Seconds = &p95
+	}
+
+	report.FailureCauses = []failureCause{}
+	for cause, count := range causes {
+		cause.Count = count
+		report.FailureCauses = append(report.FailureCauses, cause)
+	}
+	sort.Slice(report.FailureCauses, func(i, j int) bool {
+		a, b := report.FailureCauses[i], report.FailureCauses[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Source+a.Cause < b.Source+b.Cause
+	})
+	if len(report.FailureCauses) > reportTopCount {
+		report.FailureCauses = report.FailureCauses[:reportTopCount]
+	}
+
+	report.FailingFields = []failingField{}
+	for fieldID, failures := range fields {
+		report.FailingFields = append(report.FailingFields, failingField{FieldID: fieldID, Failures: failures})
+	}
+	sort.Slice(report.FailingFields, func(i, j int) bool {
+		a, b := report.FailingFields[i], report.FailingFields[j]
+		if a.Failures != b.Failures {
+			return a.Failures > b.Failures
+		}
+		return a.FieldID < b.FieldID
+	})
+	if len(report.FailingFields) > reportTopCount {
+		report.FailingFields = report.FailingFields[:reportTopCount]
+	}
+	return report, nil
+}
+
+// parseReportTime reads a report bound: a duration before now, an RFC 3339 time or a date (in
+// UTC). Empty is fallback before now.
+func parseReportTime(value string, now time.Time, fallback time.Duration) (time.Time, error) {
+	if value == "" {
+		return now.Add(-fallback), nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil && ago >= 0 {
+		return now.Add(-ago), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+	if parsed, err := time.Parse(jiraDateFormat, value); err == nil {
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a duration ago, an RFC 3339 time or a date", value)
+}
+
+// reportWindow reads the since and until bounds of a report, by default the week up to now
+func reportWindow(since, until string) (from, to time.Time, err error) {
+	now := time.Now().UTC()
+	if from, err = parseReportTime(since, now, defaultReportWindow); err != nil {
+		return from, to, err
+	}
+	if to, err = parseReportTime(until, now, 0); err != nil {
+		return from, to, err
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("the report starts (%s) after it ends (%s)", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	return from, to, nil
+}
+
+func validReportFormat(format string) bool {
+	switch format {
+	case reportFormatText, reportFormatJSON, reportFormatMarkdown:
+		return true
+	}
+	return false
+}
+
+// formatRate prints a success rate as a percentage
+func formatRate(rate *float64) string {
+	if rate == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.2f%%", *rate*100)
+}
+
+// formatSeconds prints a latency
+func formatSeconds(seconds *float64) string {
+	if seconds == nil {
+		return "n/a"
+	}
+	return (time.Duration(*seconds * float64(time.Second))).Round(time.Millisecond).String()
+}
+
+// writeReport prints a reliability report as text, JSON or a markdown section for the ops
+// review notes
+func writeReport(out io.Writer, report reliabilityReport, format string) error {
+	window := fmt.Sprintf("%s to %s", report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+	var b strings.Builder
+	switch format {
+	case reportFormatJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(report)
+
+	case reportFormatMarkdown:
+		fmt.Fprintf(&b, "## Sync reliability, %s\n\n", window)
+		fmt.Fprintf(&b, "| Metric | Value |\n|--------|-------|\n")
+		fmt.Fprintf(&b, "| Webhooks processed | %d |\n", report.Processed)
+		fmt.Fprintf(&b, "| Succeeded / partial / failed | %d / %d / %d |\n", report.Succeeded, report.Partial, report.Failed)
+		fmt.Fprintf(&b, "| Ignored | %d |\n", report.Ignored)
+		fmt.Fprintf(&b, "| Success rate | %s |\n", formatRate(report.SuccessRate))
+		fmt.Fprintf(&b, "| p95 latency | %s |\n", formatSeconds(report.LatencyP95Seconds))
+		fmt.Fprintf(&b, "| Jira writes (failed) | %d (%d) |\n", report.JiraWrites, report.JiraWritesFailed)
+		if len(report.FailureCauses) > 0 {
+			fmt.Fprintf(&b, "\n### Top failure causes\n\n| Count | Source | Cause |\n|-------|--------|-------|\n")
+			for _, cause := range report.FailureCauses {
+				fmt.Fprintf(&b, "| %d | %s | %s |\n", cause.Count, cause.Source, markdownCell.Replace(cause.Cause))
+			}
+		}
+		if len(report.FailingFields) > 0 {
+			fmt.Fprintf(&b, "\n### Most failing fields\n\n| Field | Failed writes |\n|-------|---------------|\n")
+			for _, field := range report.FailingFields {
+				fmt.Fprintf(&b, "| `%s` | %d |\n", field.FieldID, field.Failures)
+			}
+		}
+
+	default:
+		fmt.Fprintf(&b, "Sync reliability, %s\n\n", window)
+		fmt.Fprintf(&b, "Webhooks processed:  %d (%d succeeded, %d partial, %d failed; %d ignored)\n",
+			report.Processed, report.Succeeded, report.Partial, report.Failed, report.Ignored)
+		fmt.Fprintf(&b, "Success rate:        %s\n", formatRate(report.SuccessRate))
+		fmt.Fprintf(&b, "p95 latency:         %s\n", formatSeconds(report.LatencyP95Seconds))
+		fmt.Fprintf(&b, "Jira writes:         %d (%d failed)\n", report.JiraWrites, report.JiraWritesFailed)
+		if len(report.FailureCauses) > 0 {
+			fmt.Fprintf(&b, "\nTop failure causes:\n")
+			for _, cause := range report.FailureCauses {
+				fmt.Fprintf(&b, "  %6d  %-10s  %s\n", cause.Count, cause.Source, cause.Cause)
+			}
+		}
+		if len(report.FailingFields) > 0 {
+			fmt.Fprintf(&b, "\nMost failing fields:\n")
+			for _, field := range report.FailingFields {
+				fmt.Fprintf(&b, "  %6d  %s\n", field.Failures, field.FieldID)
+			}
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// Report prints a reliability report of the sync history kept in the state store: the webhook
+// success rate, p95 latency, top failure causes and most failing fields between since and until.
+// Each is a duration before now, an RFC 3339 time or a date; since defaults to a week ago and
+// until to now. format is text, json or markdown.
+func Report(config Config, since, until, format string, out io.Writer) error {
+	if !validReportFormat(format) {
+		return fmt.Errorf("unknown report format %q, expected text, json or markdown", format)
+	}
+	from, to, err := reportWindow(since, until)
+	if err != nil {
+		return err
+	}
+
+	store, err := newStateStore(config)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := buildReliabilityReport(context.Background(), store, from, to)
+	if err != nil {
+		return err
+	}
+	return writeReport(out, report, format)
+}
+
+// adminReportHandler returns a reliability report of the sync history, for the window in since
+// and until, as JSON unless format asks for text or markdown
+func (s *IncidentJiraSync) adminReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = reportFormatJSON
+	}
+	if !validReportFormat(format) {
+		http.Error(w, fmt.Sprintf("unknown format %q, expected text, json or markdown", format), http.StatusBadRequest)
+		return
+	}
+	from, to, err := reportWindow(query.Get("since"), query.Get("until"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := buildReliabilityReport(r.Context(), s.store, from, to)
+	if errors.Is(err, errNoHistory) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case reportFormatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case reportFormatMarkdown:
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	}
+	writeReport(w, report, format)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// responderMetadataEnabled reports whether any responder metadata is written
+func (s *IncidentJiraSync) responderMetadataEnabled() bool {
+	return s.config.ResponderCountJiraFieldID != "" || s.config.ResponderTeamJiraFieldID != "" || s.config.EscalationCountJiraFieldID != ""
+}
+
+// responderCount returns the number of distinct people holding a role on the incident
+func responderCount(incident incidentio.Incident) int {
+	responders := make(map[string]bool)
+	for _, assignment := range incident.RoleAssignments {
+		if assignment.Assignee != nil && assignment.Assignee.ID != "" {
+			responders[assignment.Assignee.ID] = true
+		}
+	}
+	return len(responders)
+}
+
+// escalationCount returns how many escalations were raised for the incident
+func (s *IncidentJiraSync) escalationCount(ctx context.Context, incident incidentio.Incident) (int, error) {
+	escalations, err := s.incidentClient(ctx).ListEscalations(ctx, incident.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, escalation := range escalations {
+		for _, related := range escalation.RelatedIncidents {
+			if related.ID == incident.ID {
+				count++
+				break
+			}
+		}
+	}
+	return count, nil
+}
+
+// syncResponderMetadata writes the number of responders, the owning team and the number of
+// escalations to their Jira fields, for reporting on Jira data. Values are only written when
+// they change.
+func (s *IncidentJiraSync) syncResponderMetadata(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if !s.responderMetadataEnabled() {
+		return nil
+	}
+
+	// Events about one field don't carry the role assignments or the other fields
+	_, hasTeam := findFieldEntry(incident, s.config.ResponderTeamFieldName)
+	if len(incident.RoleAssignments) == 0 || (s.config.ResponderTeamJiraFieldID != "" && !hasTeam) {
+		if fullIncident, err := s.incidentClient(ctx).GetIncident(ctx, incident.ID); err != nil {
+			log.Printf("Warning: failed to fetch incident %s for responder metadata: %v", incident.ID, err)
+		} else {
+			incident.RoleAssignments = fullIncident.RoleAssignments
+			incident.CustomFieldEntries = fullIncident.CustomFieldEntries
+		}
+	}
+
+	fields := make(map[string]interface{})
+	written := make(map[string]string)
+	set := func(attribute, fieldID, value string, fieldValue interface{}) {
+		if fieldID == "" || !s.lastWritten.changed(jiraIssueKey, attribute, value) {
+			return
+		}
+		fields[fieldID] = fieldValue
+		written[attribute] = value
+	}
+
+	if fieldID := s.config.ResponderCountJiraFieldID; fieldID != "" && len(incident.RoleAssignments) > 0 {
+		count := responderCount(incident)
+		set("responder_count", fieldID, fmt.Sprint(count), count)
+	}
+
+	if fieldID := s.config.ResponderTeamJiraFieldID; fieldID != "" {
+		team := ""
+		if entry, found := findFieldEntry(incident, s.config.ResponderTeamFieldName); found && len(entry.Values) > 0 {
+			team = strings.TrimSpace(entry.Values[0].Text())
+		}
+		// An empty team clears the field
+		var fieldValue interface{}
+		if team != "" {
+			fieldValue = team
+		}
+		set("responder_team", fieldID, team, fieldValue)
+	}
+
+	if fieldID := s.config.EscalationCountJiraFieldID; fieldID != "" {
+		count, err := s.escalationCount(ctx, incident)
+		if err != nil {
+			log.Printf("Warning: failed to count escalations of incident %s: %v", incident.ID, err)
+		} else {
+			set("escalation_count", fieldID, fmt.Sprint(count), count)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	log.Printf("Writing responder metadata of incident %s to %s", incident.ID, jiraIssueKey)
+	if err := s.updateJiraIssueFields(ctx, jiraIssueKey, fields); err != nil {
+		return err
+	}
+
+	for attribute, value := range written {
+		s.lastWritten.record(jiraIssueKey, attribute, value)
+	}
+	return nil
+}
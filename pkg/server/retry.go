@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// retryItem is a single field sync that could not be completed while handling its webhook
+type retryItem struct {
+	IncidentID        string
+	IncidentReference string
+	JiraIssueKey      string
+	FieldEntry        incidentio.CustomFieldEntry
+	FieldMapping      mapping.FieldMapping
+	Attempts          int
+	LastError         error
+	// Organization is the INCIDENT_ORGANIZATIONS organization of the incident, if any
+	Organization string
+}
+
+// enqueueRetry schedules a field sync to be retried after a backoff based on its attempt count.
+// While draining, it is saved for the next replica instead.
+func (s *IncidentJiraSync) enqueueRetry(item retryItem) {
+	if item.Attempts >= s.config.RetryMaxAttempts {
+		log.Printf("Giving up on %s for %s after %d attempts", item.FieldMapping.IncidentFieldName, item.JiraIssueKey, item.Attempts)
+		s.notifySyncFailure(item, fmt.Sprintf("gave up after %d attempts: %v", item.Attempts, item.LastError))
+		return
+	}
+	if s.draining.Load() {
+		s.saveRetries([]retryItem{item})
+		return
+	}
+
+	s.scheduled.schedule(s.retryDelay(item.Attempts), item, func(item retryItem) {
+		select {
+		case s.retryQueue <- item:
+		default:
+			log.Printf("Retry queue full, dropping %s for %s", item.FieldMapping.IncidentFieldName, item.JiraIssueKey)
+			s.notifySyncFailure(item, "the retry queue is full")
+		}
+	})
+}
+
+// retryDelay returns the backoff before retrying a field sync that failed attempts times:
+// RETRY_BASE_DELAY doubled on each attempt, up to RETRY_MAX_DELAY
+func (s *IncidentJiraSync) retryDelay(attempts int) time.Duration {
+	return backoffDelay(s.config.RetryBaseDelay, s.config.RetryMaxDelay, attempts)
+}
+
+// backoffDelay returns base doubled doublings times, up to maxDelay, without overflowing
+func backoffDelay(base, maxDelay time.Duration, doublings int) time.Duration {
+	doublings = max(doublings, 0)
+	if base > maxDelay>>doublings {
+		return maxDelay
+	}
+	return base << doublings
+}
+
+// runRetryWorker processes queued field syncs until the queue is closed
+func (s *IncidentJiraSync) runRetryWorker() {
+	for item := range s.retryQueue {
+		s.inflight.Add(1)
+		s.retryProgress.begin()
+		s.retryField(item)
+		s.retryProgress.end()
+		s.inflight.Add(-1)
+	}
+}
+
+// retryField makes one more attempt at a queued field sync, queueing it again if it fails
+func (s *IncidentJiraSync) retryField(item retryItem) {
+	item.Attempts++
+	log.Printf("Retrying %s for %s (attempt %d)", item.FieldMapping.IncidentFieldName, item.JiraIssueKey, item.Attempts)
+
+	ctx, cancel := s.processingContext(withOrganization(context.Background(), item.Organization))
+	ctx = s.withIncidentDebug(ctx, item.IncidentID, item.IncidentReference)
+	err := s.checkSkipList(ctx, item.IncidentID, item.IncidentReference)
+	if errors.Is(err, errIncidentSkipped) {
+		cancel()
+		log.Printf("Dropping retry of %s for %s, its incident is on the skip list", item.FieldMapping.IncidentFieldName, item.JiraIssueKey)
+		return
+	}
+	if err == nil {
+		err = s.checkSecurityLevel(ctx, item.JiraIssueKey)
+	}
+	if errors.Is(err, errIssueRestricted) {
+		cancel()
+		log.Printf("Dropping retry of %s for %s, its security level is not allowed", item.FieldMapping.IncidentFieldName, item.JiraIssueKey)
+		return
+	}
+	var unlock func()
+	if err == nil {
+		unlock, err = s.locker.Lock(ctx, item.JiraIssueKey)
+	}
+	if err == nil {
+		err = s.processField(ctx, item.FieldEntry, item.JiraIssueKey, item.FieldMapping)
+		unlock()
+	}
+	cancel()
+
+	if err != nil {
+		log.Printf("Retry of %s for %s failed: %v", item.FieldMapping.IncidentFieldName, item.JiraIssueKey, err)
+		item.LastError = err
+		// Jira won't accept the write however often it is retried
+		if jira.IsPermanent(err) {
+			log.Printf("Giving up on %s for %s, Jira rejected it", item.FieldMapping.IncidentFieldName, item.JiraIssueKey)
+			s.notifySyncFailure(item, err.Error())
+			return
+		}
+		s.enqueueRetry(item)
+		return
+	}
+
+	log.Printf("Retry of %s for %s succeeded", item.FieldMapping.IncidentFieldName, item.JiraIssueKey)
+}
+
+// processingContext bounds a unit of sync work by PROCESSING_TIMEOUT and gives it its own
+// budget of immediate HTTP retries
+func (s *IncidentJiraSync) processingContext(parent context.Context) (context.Context, context.CancelFunc) {
+	parent = withDeliveryRetries(parent)
+	if s.config.ProcessingTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, s.config.ProcessingTimeout)
+}
+
+// RetryConfigFile is the format of RETRY_CONFIG_FILE. Settings it contains override the
+// environment; durations are strings such as "200ms".
+type RetryConfigFile struct {
+	// Queue configures queued retries of field syncs that failed during their webhook
+	Queue struct {
+		MaxAttempts *int    `json:"max_attempts"`
+		BaseDelay   *string `json:"base_delay"`
+		MaxDelay    *string `json:"max_delay"`
+		Size        *int    `json:"size"`
+	} `json:"queue"`
+	// Upstreams configures immediate retries, keyed by "jira" or "incident_io"
+	Upstreams map[string]UpstreamRetryConfig `json:"upstreams"`
+}
+
+// UpstreamRetryConfig configures immediate retries of requests to one upstream
+type UpstreamRetryConfig struct {
+	Retries  *int    `json:"retries"`
+	Delay    *string `json:"delay"`
+	Statuses []int   `json:"statuses"`
+	// Budget caps immediate retries to the upstream across one webhook delivery
+	Budget *int `json:"budget"`
+	// RetryAfterMax is the longest Retry-After waited for before an immediate retry
+	RetryAfterMax *string `json:"retry_after_max"`
+	// RateLimit caps the requests sent to the upstream per minute
+	RateLimit *int `json:"rate_limit"`
+}
+
+// loadRetryConfig applies the settings of an optional retry config file to config
+func loadRetryConfig(path string, config *Config) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var file RetryConfigFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if file.Queue.MaxAttempts != nil {
+		config.RetryMaxAttempts = *file.Queue.MaxAttempts
+	}
+	if file.Queue.Size != nil {
+		config.RetryQueueSize = *file.Queue.Size
+	}
+	if file.Queue.BaseDelay != nil {
+		if config.RetryBaseDelay, err = time.ParseDuration(*file.Queue.BaseDelay); err != nil {
+			return fmt.Errorf("invalid queue base_delay: %w", err)
+		}
+	}
+	if file.Queue.MaxDelay != nil {
+		if config.RetryMaxDelay, err = time.ParseDuration(*file.Queue.MaxDelay); err != nil {
+			return fmt.Errorf("invalid queue max_delay: %w", err)
+		}
+	}
+
+	for upstream, retries := range file.Upstreams {
+		var client *HTTPClientConfig
+		switch upstream {
+		case upstreamJira:
+			client = &config.JiraHTTP
+		case upstreamIncident:
+			client = &config.IncidentHTTP
+		default:
+			return fmt.Errorf("unknown upstream %q, expected %s or %s", upstream, upstreamJira, upstreamIncident)
+		}
+
+		if retries.Retries != nil {
+			client.Retries = *retries.Retries
+		}
+		if retries.Budget != nil {
+			client.RetryBudget = *retries.Budget
+		}
+		if retries.Statuses != nil {
+			client.RetryStatuses = retries.Statuses
+		}
+		if retries.Delay != nil {
+			if client.RetryDelay, err = time.ParseDuration(*retries.Delay); err != nil {
+				return fmt.Errorf("invalid %s delay: %w", upstream, err)
+			}
+		}
+		if retries.RetryAfterMax != nil {
+			if client.RetryAfterMax, err = time.ParseDuration(*retries.RetryAfterMax); err != nil {
+				return fmt.Errorf("invalid %s retry_after_max: %w", upstream, err)
+			}
+		}
+		if retries.RateLimit != nil {
+			client.RateLimit = *retries.RateLimit
+		}
+	}
+
+	log.Printf("Loaded retry settings from %s", path)
+	return nil
+}
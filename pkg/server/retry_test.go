@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// newRetryTestSync returns a service with a memory store, a retry queue of queueSize and
+// failure notes enabled
+func newRetryTestSync(queueSize int) *IncidentJiraSync {
+	store := newMemoryStore()
+	return &IncidentJiraSync{
+		config: Config{
+			RetryMaxAttempts:    3,
+			RetryBaseDelay:      time.Millisecond,
+			RetryMaxDelay:       time.Second,
+			FailureNoteFieldID:  "note_field",
+			RedeliveryBaseDelay: 2 * time.Second,
+			RedeliveryMaxDelay:  time.Minute,
+		},
+		store:       store,
+		lastWritten: newLastWrittenValues(store),
+		retryQueue:  make(chan retryItem, queueSize),
+		outboxWake:  make(chan struct{}, 1),
+		jiraBudget:  newRateBudget(0, time.Minute),
+	}
+}
+
+func testRetryItem(attempts int) retryItem {
+	return retryItem{
+		IncidentID:   "inc_1",
+		JiraIssueKey: "SUP-1",
+		FieldEntry:   incidentio.CustomFieldEntry{CustomField: incidentio.CustomField{ID: "field_1", Name: "Impacted components"}},
+		FieldMapping: mapping.FieldMapping{IncidentFieldName: "Impacted components", JiraFieldID: "customfield_1"},
+		Attempts:     attempts,
+		LastError:    errors.New("timeout"),
+	}
+}
+
+// failureNote returns the failure note queued for inc_1, waiting briefly for one to arrive
+func failureNote(t *testing.T, s *IncidentJiraSync, wait time.Duration) string {
+	t.Helper()
+	deadline := time.Now().Add(wait)
+	for {
+		note, found, err := s.store.LastWritten(context.Background(), "incident/inc_1", "failure_note")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found || time.Now().After(deadline) {
+			return note
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEnqueueRetryQueuesAfterBackoff(t *testing.T) {
+	s := newRetryTestSync(1)
+	s.enqueueRetry(testRetryItem(1))
+
+	select {
+	case item := <-s.retryQueue:
+		if item.Attempts != 1 || item.JiraIssueKey != "SUP-1" {
+			t.Errorf("queued %+v", item)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry wasn't queued after its backoff")
+	}
+	if note := failureNote(t, s, 0); note != "" {
+		t.Errorf("failure note %q for a queued retry", note)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	s := newRetryTestSync(1)
+	s.config.RetryBaseDelay = 10 * time.Second
+	s.config.RetryMaxDelay = 10 * time.Minute
+	tests := map[int]time.Duration{
+		0:    10 * time.Second,
+		1:    20 * time.Second,
+		5:    320 * time.Second,
+		6:    10 * time.Minute,
+		40:   10 * time.Minute,
+		64:   10 * time.Minute,
+		1000: 10 * time.Minute,
+	}
+	for attempts, want := range tests {
+		if got := s.retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestLoadRetryConfigQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry.json")
+	if err := os.WriteFile(path, []byte(`{"queue": {"max_attempts": 8, "base_delay": "1s", "max_delay": "1m", "size": 10}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var config Config
+	if err := loadRetryConfig(path, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.RetryMaxAttempts != 8 || config.RetryBaseDelay != time.Second || config.RetryMaxDelay != time.Minute || config.RetryQueueSize != 10 {
+		t.Errorf("config = %+v", config)
+	}
+}
+
+func TestEnqueueRetryGivesUp(t *testing.T) {
+	s := newRetryTestSync(1)
+	s.enqueueRetry(testRetryItem(3))
+
+	if items := s.scheduled.takeAll(); len(items) != 0 {
+		t.Errorf("scheduled %d retries past the last attempt", len(items))
+	}
+	if note := failureNote(t, s, 0); !strings.Contains(note, "gave up after 3 attempts: timeout") {
+		t.Errorf("failure note = %q, want one giving up", note)
+	}
+	if backlog, _ := s.store.OutboxBacklog(context.Background()); backlog.Pending != 1 {
+		t.Errorf("%d outbox messages, want the failure note", backlog.Pending)
+	}
+}
+
+func TestEnqueueRetryQueueFull(t *testing.T) {
+	s := newRetryTestSync(0)
+	s.enqueueRetry(testRetryItem(0))
+
+	if note := failureNote(t, s, 5*time.Second); !strings.Contains(note, "the retry queue is full") {
+		t.Errorf("failure note = %q, want one for a full queue", note)
+	}
+}
+
+func TestEnqueueRetryWhileDraining(t *testing.T) {
+	s := newRetryTestSync(1)
+	s.draining.Store(true)
+	s.enqueueRetry(testRetryItem(2))
+
+	saved, err := s.store.TakeRetries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].JiraIssueKey != "SUP-1" || saved[0].Attempts != 2 || saved[0].LastError != "timeout" {
+		t.Errorf("saved %+v, want the retry", saved)
+	}
+	if items := s.scheduled.takeAll(); len(items) != 0 {
+		t.Errorf("scheduled %d retries while draining", len(items))
+	}
+}
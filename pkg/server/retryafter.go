@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redeliveryBackoff counts the consecutive failed deliveries of each incident, so the
+// Retry-After sent with a failure grows exponentially while the incident keeps failing
+type redeliveryBackoff struct {
+	maxDelay time.Duration
+
+	mu       sync.Mutex
+	failures map[string]redeliveryFailures
+}
+
+type redeliveryFailures struct {
+	count int
+	last  time.Time
+}
+
+func newRedeliveryBackoff(maxDelay time.Duration) *redeliveryBackoff {
+	return &redeliveryBackoff{maxDelay: maxDelay, failures: make(map[string]redeliveryFailures)}
+}
+
+// fail records a failed delivery for an incident and returns its consecutive failures.
+// Incidents that haven't failed for twice the longest delay start over.
+func (b *redeliveryBackoff) fail(incidentID string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for id, failures := range b.failures {
+		if now.Sub(failures.last) > 2*b.maxDelay {
+			delete(b.failures, id)
+		}
+	}
+	failures := b.failures[incidentID]
+	failures.count++
+	failures.last = now
+	b.failures[incidentID] = failures
+	return failures.count
+}
+
+// succeed forgets an incident's failures once a delivery of it is processed
+func (b *redeliveryBackoff) succeed(incidentID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, incidentID)
+}
+
+// redeliveryDelay is how long a webhook source should wait before redelivering after the given
+// number of consecutive failures: REDELIVERY_BASE_DELAY doubled with each failure, at least
+// until Jira's rate limit pause ends, and at most REDELIVERY_MAX_DELAY
+func (s *IncidentJiraSync) redeliveryDelay(failures int) time.Duration {
+	delay := s.config.RedeliveryMaxDelay
+	if failures < 1 {
+		failures = 1
+	}
+	if failures <= 32 {
+		delay = min(s.config.RedeliveryBaseDelay<<(failures-1), s.config.RedeliveryMaxDelay)
+	}
+	if paused := s.jiraBudget.pausedFor(); paused > delay {
+		delay = min(paused, s.config.RedeliveryMaxDelay)
+	}
+	return delay
+}
+
+// setRetryAfter sets the Retry-After header to a delay in whole seconds, rounded up
+func setRetryAfter(w http.ResponseWriter, delay time.Duration) {
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// severityLabel returns the Jira label for an incident severity, e.g. "incident-severity-major".
+// Jira labels cannot contain spaces.
+func (s *IncidentJiraSync) severityLabel(incident incidentio.Incident) string {
+	if s.config.SeverityLabelPrefix == "" || incident.Severity == nil || incident.Severity.Name == "" {
+		return ""
+	}
+	return s.config.SeverityLabelPrefix + strings.ToLower(strings.Join(strings.Fields(incident.Severity.Name), "-"))
+}
+
+// rollupEpic copies the epic's rollup fields and the incident severity label to every child
+// issue of the epic, skipping children that already match
+func (s *IncidentJiraSync) rollupEpic(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	var epic struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	path := fmt.Sprintf("%s?fields=%s", jira.IssuePath(jiraIssueKey), strings.Join(append([]string{"issuetype"}, s.config.EpicRollupFieldIDs...), ","))
+	if err := s.jira.Get(ctx, path, &epic); err != nil {
+		return err
+	}
+
+	var issueType struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(epic.Fields["issuetype"], &issueType)
+	if !strings.EqualFold(issueType.Name, s.config.EpicIssueTypeName) {
+		return nil
+	}
+
+	label := s.severityLabel(incident)
+	children, err := s.jira.SearchIssues(ctx, fmt.Sprintf("parent = %s", jiraIssueKey), append([]string{"labels"}, s.config.EpicRollupFieldIDs...))
+	if err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, child := range children {
+		payload := jira.UpdateRequest{Fields: make(map[string]interface{})}
+
+		for _, fieldID := range s.config.EpicRollupFieldIDs {
+			var epicValue, childValue interface{}
+			json.Unmarshal(epic.Fields[fieldID], &epicValue)
+			json.Unmarshal(child.Fields[fieldID], &childValue)
+			if canonicalFieldValue(epicValue) != canonicalFieldValue(childValue) {
+				payload.Fields[fieldID] = epic.Fields[fieldID]
+			}
+		}
+
+		if label != "" {
+			var labels []string
+			json.Unmarshal(child.Fields["labels"], &labels)
+
+			var operations []map[string]interface{}
+			hasLabel := false
+			for _, existing := range labels {
+				switch {
+				case existing == label:
+					hasLabel = true
+				case strings.HasPrefix(existing, s.config.SeverityLabelPrefix):
+					operations = append(operations, map[string]interface{}{"remove": existing})
+				}
+			}
+			if !hasLabel {
+				operations = append(operations, map[string]interface{}{"add": label})
+			}
+			if len(operations) > 0 {
+				payload.Update = map[string][]map[string]interface{}{"labels": operations}
+			}
+		}
+
+		if len(payload.Fields) == 0 && len(payload.Update) == 0 {
+			continue
+		}
+
+		if err := s.updateJiraIssue(ctx, child.Key, payload); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Failed to roll up %s to %s: %v", jiraIssueKey, child.Key, err)
+			continue
+		}
+		updated++
+	}
+
+	log.Printf("Rolled up %s to %d of %d child issues", jiraIssueKey, updated, len(children))
+	return nil
+}
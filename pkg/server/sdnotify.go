@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// sdNotify sends a state change to systemd when it runs the service as a Type=notify unit.
+// Without NOTIFY_SOCKET it does nothing.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	// Names starting with @ are in the abstract namespace, which Go handles
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("Warning: failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("Warning: failed to notify systemd: %v", err)
+	}
+}
+
+// sdWatchdogInterval returns how often to ping systemd's watchdog, half its WatchdogSec, or 0
+// when the unit has no watchdog for this process
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// runSdWatchdog pings systemd's watchdog until stop is closed, as long as live reports the
+// service making progress. A process that stops pinging, because it is wedged, is restarted by
+// systemd.
+func runSdWatchdog(interval time.Duration, stop <-chan struct{}, live func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := live(); err != nil {
+				log.Printf("Warning: not pinging the systemd watchdog: %v", err)
+				continue
+			}
+			sdNotify("WATCHDOG=1")
+		case <-stop:
+			return
+		}
+	}
+}
+
+// loopProgress records when a background loop started its current unit of work, so a loop
+// stuck in one can be told from an idle one
+type loopProgress struct {
+	busySince atomic.Int64
+}
+
+func (p *loopProgress) begin() {
+	p.busySince.Store(time.Now().UnixNano())
+}
+
+func (p *loopProgress) end() {
+	p.busySince.Store(0)
+}
+
+// busyFor returns how long the current unit of work has run, or 0 while idle
+func (p *loopProgress) busyFor() time.Duration {
+	since := p.busySince.Load()
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+// healthProbeTimeout bounds how long the watchdog's liveness check waits for the state /health
+// reports
+const healthProbeTimeout = 5 * time.Second
+
+// checkLive returns why the service is wedged, or nil: the state /health reports can't be read,
+// or the retry worker or outbox dispatcher has been stuck on one unit of work well past the time
+// it is allowed. Without PROCESSING_TIMEOUT that time is unbounded, and only /health is checked.
+func (s *IncidentJiraSync) checkLive() error {
+	answered := make(chan struct{})
+	go func() {
+		s.lint.snapshot()
+		close(answered)
+	}()
+	select {
+	case <-answered:
+	case <-time.After(healthProbeTimeout):
+		return fmt.Errorf("/health couldn't read its state within %s", healthProbeTimeout)
+	}
+
+	if s.config.ProcessingTimeout <= 0 {
+		return nil
+	}
+	if busy, limit := s.retryProgress.busyFor(), s.config.ProcessingTimeout+time.Minute; busy > limit {
+		return fmt.Errorf("the retry worker has been on one field sync for %s", busy.Round(time.Second))
+	}
+	if busy, limit := s.outboxProgress.busyFor(), s.outboxLease(); busy > limit {
+		return fmt.Errorf("the outbox dispatcher has been on one batch for %s", busy.Round(time.Second))
+	}
+	return nil
+}
+
+// notifyServiceReady tells the service manager, systemd or the Windows service control
+// manager, that the service is serving
+func notifyServiceReady(status string) {
+	sdNotify("READY=1\nSTATUS=" + status)
+	reportServiceRunning()
+}
+
+// notifyServiceStopping tells the service manager that shutdown has started
+func notifyServiceStopping() {
+	sdNotify("STOPPING=1\nSTATUS=Shutting down")
+	reportServiceStopping()
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckLive(t *testing.T) {
+	s := &IncidentJiraSync{config: Config{ProcessingTimeout: time.Second}}
+	if err := s.checkLive(); err != nil {
+		t.Fatalf("checkLive() of an idle service = %v", err)
+	}
+
+	s.retryProgress.begin()
+	if err := s.checkLive(); err != nil {
+		t.Errorf("checkLive() during a retry = %v", err)
+	}
+	s.retryProgress.busySince.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	if err := s.checkLive(); err == nil || !strings.Contains(err.Error(), "retry worker") {
+		t.Errorf("checkLive() with a stuck retry = %v, want an error naming the retry worker", err)
+	}
+	s.retryProgress.end()
+
+	s.outboxProgress.busySince.Store(time.Now().Add(-time.Hour).UnixNano())
+	if err := s.checkLive(); err == nil || !strings.Contains(err.Error(), "outbox") {
+		t.Errorf("checkLive() with a stuck outbox batch = %v, want an error naming the outbox dispatcher", err)
+	}
+
+	// Without a processing timeout, work may take any time
+	s.config.ProcessingTimeout = 0
+	if err := s.checkLive(); err != nil {
+		t.Errorf("checkLive() without PROCESSING_TIMEOUT = %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// streamRestrictedIssue is the stream event published when an issue is skipped for its
+// security level
+const streamRestrictedIssue = "restricted_issue"
+
+// securityLevelNotVisible stands for the level of an issue the service can't see at all, which
+// Jira answers as not found
+const securityLevelNotVisible = "(not visible)"
+
+// errIssueRestricted stops the sync of an issue whose security level the service may not touch
+var errIssueRestricted = errors.New("issue security level is not allowed")
+
+// securityLevelAllowed reports whether a security level is in JIRA_ALLOWED_SECURITY_LEVELS, by
+// ID or by name in any case
+func securityLevelAllowed(allowed map[string]bool, id, name string) bool {
+	if allowed[id] {
+		return true
+	}
+	for level := range allowed {
+		if strings.EqualFold(level, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecurityLevel returns errIssueRestricted when JIRA_SECURITY_LEVEL_CHECK is on and the
+// issue has a security level outside JIRA_ALLOWED_SECURITY_LEVELS, or can't be seen by the
+// service at all. Issues without a security level are always allowed.
+func (s *IncidentJiraSync) checkSecurityLevel(ctx context.Context, jiraIssueKey string) error {
+	if !s.config.JiraSecurityLevelCheck {
+		return nil
+	}
+
+	var issue struct {
+		Fields struct {
+			Security *struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"security"`
+		} `json:"fields"`
+	}
+	err := s.jira.Get(ctx, jira.IssuePath(jiraIssueKey)+"?fields=security", &issue)
+	switch {
+	case errors.Is(err, jira.ErrNotFound), errors.Is(err, jira.ErrPermission):
+		return s.restrictIssue(jiraIssueKey, securityLevelNotVisible)
+	case err != nil:
+		return fmt.Errorf("failed to read the security level of %s: %w", jiraIssueKey, err)
+	}
+
+	level := issue.Fields.Security
+	if level == nil || securityLevelAllowed(s.config.JiraAllowedSecurityLevels, level.ID, level.Name) {
+		return nil
+	}
+	return s.restrictIssue(jiraIssueKey, level.Name)
+}
+
+// restrictIssue alerts that an issue is skipped for its security level and returns the error
+// skipping it
+func (s *IncidentJiraSync) restrictIssue(jiraIssueKey, level string) error {
+	log.Printf("Warning: skipping %s, its security level %s is not in JIRA_ALLOWED_SECURITY_LEVELS", jiraIssueKey, level)
+	restrictedIssuesTotal.inc(level)
+	s.stream.publish(streamEvent{Type: streamRestrictedIssue, IssueKey: jiraIssueKey, Outcome: "skipped",
+		Message: fmt.Sprintf("security level %s is not allowed", level)})
+	return fmt.Errorf("%w: %s has security level %s", errIssueRestricted, jiraIssueKey, level)
+}
+
+var restrictedIssuesTotal = newCounterVec(
+	"incident_jira_webhook_restricted_issues_total",
+	"Syncs skipped because the Jira issue's security level isn't allowed, by security level.",
+	"level")
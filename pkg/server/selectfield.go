@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// processSelectField writes the text of an incident field's values (or, for catalog entries,
+// of the mapping's catalog attribute), after the mapping's transform, as options of Jira select
+// fields. Values without a matching option fail the sync, unless the Jira field is allowed to
+// have options created for it.
+func (s *IncidentJiraSync) processSelectField(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, jiraIssueKey string, fieldMapping mapping.FieldMapping) error {
+	texts, err := s.selectTexts(ctx, customFieldEntry, fieldMapping)
+	if err != nil {
+		return err
+	}
+
+	fieldIDs := fieldMapping.EnabledFieldIDs()
+	if len(fieldIDs) == 0 {
+		return nil
+	}
+
+	editMeta, err := s.jira.EditMeta(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	for _, fieldID := range fieldIDs {
+		meta, editable := editMeta[fieldID]
+		if !editable {
+			return fmt.Errorf("field %s is not editable on %s", fieldID, jiraIssueKey)
+		}
+
+		var options []jira.SelectValue
+		for _, text := range texts {
+			option, err := s.resolveSelectOption(ctx, jiraIssueKey, fieldID, meta, text)
+			if err != nil {
+				return err
+			}
+			options = append(options, jira.SelectValue{Value: option})
+		}
+		log.Printf("Mapped %s -> %d options of %s", fieldMapping.IncidentFieldName, len(options), fieldID)
+
+		switch {
+		case meta.Schema.Type == "array":
+			if options == nil {
+				options = []jira.SelectValue{}
+			}
+			fields[fieldID] = options
+		case len(options) == 0:
+			fields[fieldID] = nil
+		default:
+			if len(options) > 1 {
+				log.Printf("Warning: %s is a single-select field, writing only %s", fieldID, s.redactor.redactString(options[0].Value))
+			}
+			fields[fieldID] = options[0]
+		}
+	}
+
+	return s.updateJiraIssueFields(ctx, jiraIssueKey, fields)
+}
+
+// selectTexts returns the option texts a select mapping writes for an incident field
+func (s *IncidentJiraSync) selectTexts(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, fieldMapping mapping.FieldMapping) ([]string, error) {
+	var texts []string
+	for _, value := range customFieldEntry.Values {
+		text := value.Text()
+		if value.ValueCatalogEntry != nil && fieldMapping.CatalogAttribute != "" {
+			var err error
+			if text, err = s.resolveCatalogAttribute(ctx, value.ValueCatalogEntry.ID, fieldMapping.CatalogAttribute); err != nil {
+				return nil, err
+			}
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return s.transformValues(ctx, fieldMapping, texts)
+}
+
+// resolveSelectOption returns the Jira option matching text (case-insensitive), creating it
+// when the field is in SELECT_OPTION_AUTO_CREATE_FIELDS
+func (s *IncidentJiraSync) resolveSelectOption(ctx context.Context, jiraIssueKey, fieldID string, meta jira.FieldMeta, text string) (string, error) {
+	for _, allowed := range meta.AllowedValues {
+		if strings.EqualFold(allowed.Value, text) {
+			return allowed.Value, nil
+		}
+	}
+
+	if !s.config.SelectOptionAutoCreateFields[fieldID] {
+		return "", fmt.Errorf("%s has no option %q", fieldID, s.redactor.redactString(text))
+	}
+
+	projectID, err := s.jira.IssueProjectID(ctx, jiraIssueKey)
+	if err != nil {
+		return "", err
+	}
+	contextID, err := s.jira.FieldContextID(ctx, fieldID, projectID)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Creating option %s of %s in context %s", s.redactor.redactString(text), fieldID, contextID)
+	if err := s.jira.CreateFieldOption(ctx, fieldID, contextID, text); err != nil {
+		return "", err
+	}
+	selectOptionsCreatedTotal.inc(fieldID)
+
+	// The cached edit metadata no longer lists every option
+	if s.jira.Cache != nil {
+		s.jira.Cache.InvalidatePrefix(s.jira.BaseURL + jira.IssuePath(jiraIssueKey))
+	}
+	return text, nil
+}
+
+var selectOptionsCreatedTotal = newCounterVec(
+	"incident_jira_webhook_select_options_created_total",
+	"Jira select field options created for incident values, by Jira field.",
+	"field")
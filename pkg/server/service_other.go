@@ -0,0 +1,18 @@
+//go:build !windows
+
+package server
+
+import "os"
+
+// RunAsService runs run under the Windows service control manager. Elsewhere services are
+// run by the service manager directly, so it does nothing and reports false.
+func RunAsService(name string, run func() error) (bool, error) {
+	return false, nil
+}
+
+// attachServiceSignals has no service controls to forward on this platform
+func attachServiceSignals(signals chan<- os.Signal) {}
+
+func reportServiceRunning() {}
+
+func reportServiceStopping() {}
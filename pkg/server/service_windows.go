@@ -0,0 +1,184 @@
+//go:build windows
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Service control manager API, from advapi32.dll
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+// Constants of the service control manager API
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented             = 120
+	errorServiceSpecificError           = 1066
+	errorFailedServiceControllerConnect = 1063
+
+	// serviceWaitHint is how long, in milliseconds, the control manager waits between
+	// progress reports while starting or stopping
+	serviceWaitHint = 60000
+)
+
+// serviceStatus is SERVICE_STATUS
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry is SERVICE_TABLE_ENTRYW
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// windowsService is the service run by RunAsService. The control manager calls back into
+// package-level functions, so there is one per process.
+type windowsService struct {
+	name *uint16
+	run  func() error
+	err  error
+
+	mu      sync.Mutex
+	handle  uintptr
+	status  serviceStatus
+	signals chan<- os.Signal
+}
+
+var activeService *windowsService
+
+// RunAsService runs run under the Windows service control manager when the process was
+// started by it, reporting the service running once it serves and stopped when run returns. A
+// stop or shutdown request shuts the service down like SIGTERM. It reports false, without
+// running anything, when the process wasn't started as a service.
+func RunAsService(name string, run func() error) (bool, error) {
+	serviceName, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return false, err
+	}
+	activeService = &windowsService{name: serviceName, run: run}
+
+	table := []serviceTableEntry{{name: serviceName, proc: syscall.NewCallback(serviceMain)}, {}}
+	// Blocks until the service stops
+	if ok, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); ok == 0 {
+		var errno syscall.Errno
+		if errors.As(err, &errno) && errno == errorFailedServiceControllerConnect {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	return true, activeService.err
+}
+
+// serviceMain is the ServiceMain the control manager runs the service in
+func serviceMain(argc, argv uintptr) uintptr {
+	service := activeService
+	handle, _, err := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(service.name)), syscall.NewCallback(serviceControlHandler), 0)
+	if handle == 0 {
+		service.err = fmt.Errorf("failed to register the service control handler: %w", err)
+		return 0
+	}
+	service.mu.Lock()
+	service.handle = handle
+	service.mu.Unlock()
+
+	service.setState(serviceStartPending, 0, 0)
+	if service.err = service.run(); service.err != nil {
+		service.setState(serviceStopped, 0, 1)
+		return 0
+	}
+	service.setState(serviceStopped, 0, 0)
+	return 0
+}
+
+// serviceControlHandler is the HandlerEx the control manager sends controls to
+func serviceControlHandler(control, eventType, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		activeService.setState(serviceStopPending, 0, 0)
+		activeService.mu.Lock()
+		signals := activeService.signals
+		activeService.mu.Unlock()
+		if signals != nil {
+			select {
+			case signals <- syscall.SIGTERM:
+			default:
+			}
+		}
+		return 0
+	case serviceControlInterrogate:
+		return 0
+	}
+	return errorCallNotImplemented
+}
+
+// setState reports the service's state to the control manager. A non-zero exitCode reports a
+// failed run.
+func (w *windowsService) setState(state, accepts, exitCode uint32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.handle == 0 {
+		return
+	}
+
+	w.status = serviceStatus{ServiceType: serviceWin32OwnProcess, CurrentState: state, ControlsAccepted: accepts}
+	if exitCode != 0 {
+		w.status.Win32ExitCode = errorServiceSpecificError
+		w.status.ServiceSpecificExitCode = exitCode
+	}
+	if state == serviceStartPending || state == serviceStopPending {
+		w.status.WaitHint = serviceWaitHint
+	}
+	procSetServiceStatus.Call(w.handle, uintptr(unsafe.Pointer(&w.status)))
+}
+
+// attachServiceSignals has stop and shutdown requests delivered to signals as SIGTERM
+func attachServiceSignals(signals chan<- os.Signal) {
+	if activeService == nil {
+		return
+	}
+	activeService.mu.Lock()
+	activeService.signals = signals
+	activeService.mu.Unlock()
+}
+
+func reportServiceRunning() {
+	if activeService != nil {
+		activeService.setState(serviceRunning, serviceAcceptStop|serviceAcceptShutdown, 0)
+	}
+}
+
+func reportServiceStopping() {
+	if activeService != nil {
+		activeService.setState(serviceStopPending, 0, 0)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// severityMentionGroupPrefix marks a Jira group in SEVERITY_MENTIONS, e.g. "group:sre-oncall"
+const severityMentionGroupPrefix = "group:"
+
+// mentionsSeverity reports whether changes to the severity (case-insensitive) mention
+// SEVERITY_MENTIONS
+func (s *IncidentJiraSync) mentionsSeverity(severity string) bool {
+	for mentioned := range s.config.SeverityMentionSeverities {
+		if strings.EqualFold(mentioned, severity) {
+			return true
+		}
+	}
+	return false
+}
+
+// severityMentionAccountIDs resolves SEVERITY_MENTIONS, email addresses and groups, to Jira
+// account IDs. Entries that can't be resolved are logged and skipped.
+func (s *IncidentJiraSync) severityMentionAccountIDs(ctx context.Context) []string {
+	seen := make(map[string]bool)
+	var accountIDs []string
+	add := func(accountID string) {
+		if accountID != "" && !seen[accountID] {
+			seen[accountID] = true
+			accountIDs = append(accountIDs, accountID)
+		}
+	}
+
+	mentions := make([]string, 0, len(s.config.SeverityMentions))
+	for mention := range s.config.SeverityMentions {
+		mentions = append(mentions, mention)
+	}
+	sort.Strings(mentions)
+
+	for _, mention := range mentions {
+		if group, isGroup := strings.CutPrefix(mention, severityMentionGroupPrefix); isGroup {
+			members, err := s.jira.GroupMemberAccountIDs(ctx, group)
+			if err != nil {
+				log.Printf("Warning: not mentioning group %s: %v", group, err)
+				continue
+			}
+			for _, accountID := range members {
+				add(accountID)
+			}
+			continue
+		}
+
+		accountID, err := s.resolveAccountID(ctx, mention)
+		if err != nil {
+			log.Printf("Warning: not mentioning %s: %v", s.redactor.redactString(mention), err)
+			continue
+		}
+		add(accountID)
+	}
+	return accountIDs
+}
+
+// syncSeverityChange comments on the Jira issue when the incident's severity changes, if
+// SEVERITY_CHANGE_COMMENTS is on, or when it changes to one of SEVERITY_MENTION_SEVERITIES,
+// then @mentioning SEVERITY_MENTIONS so they are notified inside Jira
+func (s *IncidentJiraSync) syncSeverityChange(ctx context.Context, incidentData incidentio.WebhookPayload, incident incidentio.Incident, jiraIssueKey string) error {
+	if incident.Severity == nil || incident.Severity.Name == "" {
+		return nil
+	}
+	severity := incident.Severity.Name
+	mention := s.mentionsSeverity(severity)
+	if !s.config.SeverityChangeComments && !mention {
+		return nil
+	}
+	if !s.lastWritten.changed(jiraIssueKey, "severity", severity) {
+		return nil
+	}
+
+	previous := ""
+	if incidentData.PreviousState != nil && incidentData.PreviousState.Severity != nil {
+		previous = incidentData.PreviousState.Severity.Name
+	}
+	if previous == severity {
+		s.lastWritten.record(jiraIssueKey, "severity", severity)
+		return nil
+	}
+
+	var accountIDs []string
+	if mention {
+		accountIDs = s.severityMentionAccountIDs(ctx)
+	}
+	log.Printf("Commenting severity %s on %s, mentioning %d people", severity, jiraIssueKey, len(accountIDs))
+	data := templateData{Incident: incident, PreviousSeverity: previous}
+	if err := s.addTemplatedComment(ctx, jiraIssueKey, templateSeverityComment, data, accountIDs...); err != nil {
+		return err
+	}
+
+	s.lastWritten.record(jiraIssueKey, "severity", severity)
+	return nil
+}
+
+// syncSeverityRank writes the rank of the incident's severity to SEVERITY_RANK_JIRA_FIELD_ID, a
+// Jira number field, so issues sort by severity rather than by severity name. Events without a
+// severity leave the field alone.
+func (s *IncidentJiraSync) syncSeverityRank(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if s.config.SeverityRankJiraFieldID == "" || incident.Severity == nil || incident.Severity.ID == "" {
+		return nil
+	}
+
+	rank := incident.Severity.Rank
+	if !s.lastWritten.changed(jiraIssueKey, "severity_rank", fmt.Sprint(rank)) {
+		return nil
+	}
+
+	log.Printf("Mapped severity %s -> rank %d", incident.Severity.Name, rank)
+	fields := map[string]interface{}{s.config.SeverityRankJiraFieldID: rank}
+	if err := s.updateJiraIssueFields(ctx, jiraIssueKey, fields); err != nil {
+		return err
+	}
+
+	s.lastWritten.record(jiraIssueKey, "severity_rank", fmt.Sprint(rank))
+	return nil
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// shadowRecentDifferences is how many differences the shadow report keeps
+const shadowRecentDifferences = 100
+
+// plannedWrite is what a mapping would write to Jira for one incident field
+type plannedWrite struct {
+	Type         string   `json:"type"`
+	JiraFieldIDs []string `json:"jira_field_ids"`
+	Values       []string `json:"values"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// shadowDifference is an incident field the shadow profile would write differently
+type shadowDifference struct {
+	Time       time.Time     `json:"time"`
+	IncidentID string        `json:"incident_id"`
+	IssueKey   string        `json:"issue_key"`
+	Field      string        `json:"field"`
+	Active     *plannedWrite `json:"active"`
+	Shadow     *plannedWrite `json:"shadow"`
+}
+
+type shadowFieldCounts struct {
+	Compared  int `json:"compared"`
+	Differing int `json:"differing"`
+}
+
+// shadowReport accumulates the comparison of the active and shadow mapping profiles
+type shadowReport struct {
+	mu          sync.Mutex
+	since       time.Time
+	events      int
+	fields      map[string]*shadowFieldCounts
+	differences []shadowDifference
+}
+
+func newShadowReport() *shadowReport {
+	return &shadowReport{since: time.Now().UTC(), fields: make(map[string]*shadowFieldCounts)}
+}
+
+func (r *shadowReport) record(field string, difference *shadowDifference) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := r.fields[field]
+	if counts == nil {
+		counts = &shadowFieldCounts{}
+		r.fields[field] = counts
+	}
+	counts.Compared++
+	if difference == nil {
+		return
+	}
+
+	counts.Differing++
+	r.differences = append(r.differences, *difference)
+	if len(r.differences) > shadowRecentDifferences {
+		r.differences = r.differences[len(r.differences)-shadowRecentDifferences:]
+	}
+}
+
+func (r *shadowReport) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.since = time.Now().UTC()
+	r.events = 0
+	r.fields = make(map[string]*shadowFieldCounts)
+	r.differences = nil
+}
+
+// shadowResolver resolves mappings with the shadow profile: the built-in mappings and
+// SHADOW_MAPPING_RULES_FILE
+func (s *IncidentJiraSync) shadowResolver() mapping.Resolver {
+	return mapping.Resolver{Builtins: s.getFieldMappings(), Rules: s.settings().ShadowMappingRules}
+}
+
+// planField works out what a mapping would write for an incident field without writing
+// anything: Assets objects are not created and select options are not looked up or created
+func (s *IncidentJiraSync) planField(ctx context.Context, entry incidentio.CustomFieldEntry, fieldMapping mapping.FieldMapping) *plannedWrite {
+	plan := &plannedWrite{Type: fieldMapping.Type, JiraFieldIDs: fieldMapping.EnabledFieldIDs(), Values: []string{}}
+	if plan.Type == "" {
+		plan.Type = mapping.TypeAssets
+	}
+	sort.Strings(plan.JiraFieldIDs)
+
+	var err error
+	switch plan.Type {
+	case mapping.TypeAssets:
+		for _, value := range entry.Values {
+			if value.ValueCatalogEntry == nil || value.ValueCatalogEntry.ID == "" {
+				continue
+			}
+			if objectID, found := s.externalObjectID(value.ValueCatalogEntry, fieldMapping); found {
+				plan.Values = append(plan.Values, objectID)
+				continue
+			}
+			objectKeys, keyErr := s.resolveCatalogAttributeValues(ctx, value.ValueCatalogEntry.ID, fieldMapping.CatalogAttributeOr(objectKeyAttribute))
+			if keyErr != nil {
+				plan.Values = append(plan.Values, "unresolved: "+value.ValueCatalogEntry.ID)
+				continue
+			}
+			for _, objectKey := range objectKeys {
+				objectID, idErr := mapping.ExtractObjectID(objectKey, fieldMapping.ObjectKeyPatternOr(s.config.ObjectKeyPattern))
+				if idErr != nil {
+					plan.Values = append(plan.Values, "unresolved: "+objectKey)
+					continue
+				}
+				plan.Values = append(plan.Values, objectID)
+			}
+		}
+	case mapping.TypeSelect:
+		plan.Values, err = s.selectTexts(ctx, entry, fieldMapping)
+	case mapping.TypeText:
+		var text string
+		if text, err = s.textFieldValue(ctx, entry, fieldMapping); text != "" {
+			plan.Values = []string{text}
+		}
+	case mapping.TypeTimeTracking:
+		var estimate string
+		if estimate, err = s.timeTrackingEstimate(ctx, entry, fieldMapping); estimate != "" {
+			plan.Values = []string{estimate}
+		}
+	case mapping.TypeParent:
+		var texts []string
+		if texts, err = s.selectTexts(ctx, entry, fieldMapping); err == nil && len(texts) > 0 {
+			if epicKey, found := parentIssueKey(texts[0]); found {
+				plan.Values = []string{epicKey}
+			} else {
+				err = fmt.Errorf("%s value %q is not a Jira issue key", fieldMapping.IncidentFieldName, texts[0])
+			}
+		}
+	case mapping.TypeDate:
+		// Planned in RFC 3339 rather than the mapping's date format, to compare with Jira
+		var date time.Time
+		var found bool
+		if date, found, err = s.incidentDate(ctx, entry, fieldMapping); found {
+			plan.Values = []string{date.Format(time.RFC3339)}
+		}
+	case mapping.TypeSprint:
+		for _, value := range entry.Values {
+			if name := strings.TrimSpace(value.Text()); name != "" {
+				plan.Values, err = s.transformValues(ctx, fieldMapping, []string{name})
+				if len(plan.Values) > 1 {
+					plan.Values = plan.Values[:1]
+				}
+				break
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown mapping type: %s", plan.Type)
+	}
+
+	if err != nil {
+		plan.Error = err.Error()
+	}
+	if plan.Values == nil {
+		plan.Values = []string{}
+	}
+	return plan
+}
+
+// evaluateShadow compares what the active and shadow mapping profiles would write for the
+// fields in an event and records the differences in the shadow report
+func (s *IncidentJiraSync) evaluateShadow(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) {
+	s.shadow.mu.Lock()
+	s.shadow.events++
+	s.shadow.mu.Unlock()
+
+	shadowResolver := s.shadowResolver()
+	for _, entry := range incident.CustomFieldEntries {
+		fieldName := entry.CustomField.Name
+
+		var active, shadow *plannedWrite
+		if fieldMapping, found := s.resolveFieldMapping(fieldName); found {
+			active = s.planField(ctx, entry, fieldMapping)
+		}
+		if fieldMapping, found := shadowResolver.Resolve(fieldName); found {
+			shadow = s.planField(ctx, entry, fieldMapping)
+		}
+		if active == nil && shadow == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			log.Printf("Shadow evaluation of incident %s ran out of time", incident.ID)
+			return
+		}
+
+		if reflect.DeepEqual(active, shadow) {
+			s.shadow.record(fieldName, nil)
+			continue
+		}
+
+		log.Printf("Shadow profile would write %s of %s differently", fieldName, jiraIssueKey)
+		shadowDifferencesTotal.inc(fieldName)
+		s.shadow.record(fieldName, &shadowDifference{
+			Time:       time.Now().UTC(),
+			IncidentID: incident.ID,
+			IssueKey:   jiraIssueKey,
+			Field:      fieldName,
+			Active:     active,
+			Shadow:     shadow,
+		})
+	}
+}
+
+// startShadowEvaluation evaluates the shadow profile in the background, so it never delays
+// or fails a webhook
+func (s *IncidentJiraSync) startShadowEvaluation(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) {
+	if s.config.ShadowMappingRulesFile == "" {
+		return
+	}
+
+	organization := organizationName(ctx)
+	go func() {
+		ctx, cancel := s.processingContext(withOrganization(withFlagSubject(context.Background(), incident), organization))
+		defer cancel()
+		s.evaluateShadow(ctx, incident, jiraIssueKey)
+	}()
+}
+
+// adminShadowHandler reports how the shadow mapping profile compares with the active one
+func (s *IncidentJiraSync) adminShadowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.config.ShadowMappingRulesFile == "" {
+		http.Error(w, "No shadow mapping profile configured", http.StatusNotFound)
+		return
+	}
+
+	s.shadow.mu.Lock()
+	defer s.shadow.mu.Unlock()
+
+	compared, differing := 0, 0
+	for _, counts := range s.shadow.fields {
+		compared += counts.Compared
+		differing += counts.Differing
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"since":              s.shadow.since,
+		"events":             s.shadow.events,
+		"fields_compared":    compared,
+		"fields_differing":   differing,
+		"by_field":           s.shadow.fields,
+		"recent_differences": s.shadow.differences,
+	})
+}
+
+// adminShadowResetHandler clears the shadow report to start a fresh comparison
+func (s *IncidentJiraSync) adminShadowResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.shadow.reset()
+	json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
+}
+
+var shadowDifferencesTotal = newCounterVec(
+	"incident_jira_webhook_shadow_differences_total",
+	"Incident fields the shadow mapping profile would write differently from the active one, by incident field.",
+	"field")
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package server
+
+import "os"
+
+// dumpSignals is empty where there is no SIGUSR1
+var dumpSignals []os.Signal
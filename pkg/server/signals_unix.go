@@ -0,0 +1,11 @@
+//go:build unix
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignals make the service log its effective configuration and runtime stats
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// simulationFixture is a recorded webhook payload and the upstream responses the sync needs
+// to process it. Responses are keyed by method and path, with or without the query, e.g.
+// "GET /rest/api/3/issue/OPS-1/editmeta". A fixture may also be a bare webhook payload.
+type simulationFixture struct {
+	Payload   json.RawMessage            `json:"payload"`
+	Responses map[string]json.RawMessage `json:"responses,omitempty"`
+}
+
+// SimulationReport is the outcome of running every fixture of a directory
+type SimulationReport struct {
+	Fixtures []FixtureResult `json:"fixtures"`
+}
+
+// FixtureResult is the outcome of one fixture and the upstream requests it made, in order
+type FixtureResult struct {
+	Fixture    string             `json:"fixture"`
+	EventType  string             `json:"event_type"`
+	IncidentID string             `json:"incident_id,omitempty"`
+	IssueKey   string             `json:"issue_key,omitempty"`
+	Outcome    string             `json:"outcome"`
+	Error      string             `json:"error,omitempty"`
+	Result     *ProcessingResult  `json:"result,omitempty"`
+	Requests   []SimulatedRequest `json:"requests"`
+	Unanswered []string           `json:"unanswered,omitempty"`
+}
+
+// SimulatedRequest is an upstream request made during a simulation
+type SimulatedRequest struct {
+	Method string          `json:"method"`
+	Host   string          `json:"host"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	// Status is the status the fixture answered with
+	Status int `json:"status"`
+}
+
+// fixtureTransport answers upstream requests from a fixture and records them. Reads missing
+// from the fixture get 404; writes missing from it succeed with an empty object.
+type fixtureTransport struct {
+	responses map[string]json.RawMessage
+
+	mu         sync.Mutex
+	requests   []SimulatedRequest
+	unanswered []string
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded := SimulatedRequest{Method: req.Method, Host: req.URL.Host, Path: req.URL.Path}
+	if req.URL.RawQuery != "" {
+		recorded.Path += "?" + req.URL.Query().Encode()
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > 0 {
+			if json.Valid(body) {
+				recorded.Body = body
+			} else {
+				recorded.Body, _ = json.Marshal(string(body))
+			}
+		}
+	}
+
+	response, found := t.responses[req.Method+" "+recorded.Path]
+	if !found {
+		response, found = t.responses[req.Method+" "+req.URL.Path]
+	}
+	recorded.Status = http.StatusOK
+	switch {
+	case found:
+	case req.Method == http.MethodGet:
+		recorded.Status = http.StatusNotFound
+		response = json.RawMessage(`{"errorMessages":["not in the fixture"]}`)
+	default:
+		response = json.RawMessage(`{}`)
+	}
+
+	t.mu.Lock()
+	t.requests = append(t.requests, recorded)
+	if recorded.Status == http.StatusNotFound {
+		t.unanswered = append(t.unanswered, req.Method+" "+recorded.Path)
+	}
+	t.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: recorded.Status,
+		Status:     fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(response)),
+		Request:    req,
+	}, nil
+}
+
+// simulationConfig keeps a simulation from touching shared state or running anything in the
+// background, so the same fixtures always produce the same report
+func simulationConfig(config Config) Config {
+	config.StateStore = storeMemory
+	config.DedupStore = dedupState
+	config.LockRedisURL = ""
+	config.MetricsBackends = map[string]bool{metricsPrometheus: true}
+	config.ShadowMappingRulesFile = ""
+	config.WebhookAutoRegister = false
+	config.JiraCacheTTL = 0
+	return config
+}
+
+// Simulate runs the webhook payloads saved in the JSON files of a directory through the
+// configuration, answering upstream requests from the fixtures, and writes a report of the
+// requests each would make to out. Each fixture starts from empty state.
+func Simulate(config Config, fixturesDir string, out io.Writer) error {
+	paths, err := filepath.Glob(filepath.Join(fixturesDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no fixtures (*.json) in %s", fixturesDir)
+	}
+	sort.Strings(paths)
+
+	report := SimulationReport{Fixtures: []FixtureResult{}}
+	for _, path := range paths {
+		result, err := simulateFixture(simulationConfig(config), path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		report.Fixtures = append(report.Fixtures, result)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func simulateFixture(config Config, path string) (FixtureResult, error) {
+	result := FixtureResult{Fixture: filepath.Base(path), Requests: []SimulatedRequest{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return result, err
+	}
+	var fixture simulationFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return result, fmt.Errorf("invalid fixture: %w", err)
+	}
+	if len(fixture.Payload) == 0 {
+		fixture.Payload = data
+	}
+	var payload incidentio.WebhookPayload
+	if err := json.Unmarshal(fixture.Payload, &payload); err != nil {
+		return result, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	s, err := NewIncidentJiraSync(config)
+	if err != nil {
+		return result, err
+	}
+	defer s.store.Close()
+
+	transport := &fixtureTransport{responses: fixture.Responses}
+	s.jira.HTTPClient = &http.Client{Transport: transport}
+	for _, organization := range s.allOrganizations() {
+		organization.client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	incident := payload.Incident
+	result.EventType = payload.EventType
+	result.IncidentID = incident.ID
+	result.IssueKey = incident.ExternalIssueReference.IssueName
+
+	if reason := s.eventIgnoreReason(payload.EventType); reason != "" {
+		result.Outcome = "ignored"
+		result.Error = reason
+		return result, nil
+	}
+
+	ctx, cancel := s.processingContext(context.Background())
+	defer cancel()
+
+	processed, err := s.processIncidentUpdate(ctx, payload)
+	result.Result = &processed
+	switch {
+	case errors.Is(err, errIncidentSkipped):
+		result.Outcome = "ignored"
+		result.Error = "skip_list"
+	case errors.Is(err, errIssueRestricted):
+		result.Outcome = "ignored"
+		result.Error = "restricted"
+	case err != nil:
+		result.Outcome = "failed"
+		result.Error = err.Error()
+	case processed.incomplete() != "":
+		result.Outcome = "partial"
+		result.Error = processed.incomplete()
+	default:
+		result.Outcome = "success"
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	result.Requests = append(result.Requests, transport.requests...)
+	result.Unanswered = transport.unanswered
+	return result, nil
+}
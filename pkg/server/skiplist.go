@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errIncidentSkipped stops the sync of an incident on the skip list
+var errIncidentSkipped = errors.New("incident is on the skip list")
+
+// skippedIncident is an entry of the skip list: an incident ID or reference (e.g. INC-42)
+// whose Jira issues are left alone
+type skippedIncident struct {
+	Incident string    `json:"incident"`
+	Reason   string    `json:"reason,omitempty"`
+	AddedBy  string    `json:"added_by,omitempty"`
+	AddedAt  time.Time `json:"added_at"`
+	// Source is "config" for SKIP_INCIDENTS, which can't be removed at runtime, or "admin"
+	Source string `json:"source"`
+}
+
+// skipListKey normalizes an incident ID or reference for matching
+func skipListKey(incident string) string {
+	return strings.ToLower(strings.TrimSpace(incident))
+}
+
+// skipEntry returns the skip list entry matching the incident's ID or reference
+func (s *IncidentJiraSync) skipEntry(ctx context.Context, incidentID, reference string) (skippedIncident, bool, error) {
+	keys := []string{skipListKey(incidentID)}
+	if reference != "" {
+		keys = append(keys, skipListKey(reference))
+	}
+
+	for _, key := range keys {
+		if key != "" && s.config.SkipIncidents[key] {
+			return skippedIncident{Incident: key, Source: "config"}, true, nil
+		}
+	}
+
+	entries, err := s.store.SkippedIncidents(ctx)
+	if err != nil {
+		return skippedIncident{}, false, err
+	}
+	for _, entry := range entries {
+		for _, key := range keys {
+			if key != "" && skipListKey(entry.Incident) == key {
+				entry.Source = "admin"
+				return entry, true, nil
+			}
+		}
+	}
+	return skippedIncident{}, false, nil
+}
+
+// checkSkipList returns errIncidentSkipped if the incident is on the skip list. If the list
+// can't be read the incident is not synced either, since it may be on it.
+func (s *IncidentJiraSync) checkSkipList(ctx context.Context, incidentID, reference string) error {
+	entry, skipped, err := s.skipEntry(ctx, incidentID, reference)
+	if err != nil {
+		return fmt.Errorf("failed to read skip list: %w", err)
+	}
+	if !skipped {
+		return nil
+	}
+
+	log.Printf("Incident %s is on the skip list (%s), not syncing it", incidentID, entry.Incident)
+	incidentsSkippedTotal.inc(entry.Source)
+	return errIncidentSkipped
+}
+
+// adminSkipListHandler lists the skip list
+func (s *IncidentJiraSync) adminSkipListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var entries []skippedIncident
+	for key := range s.config.SkipIncidents {
+		entries = append(entries, skippedIncident{Incident: key, Source: "config"})
+	}
+	stored, err := s.store.SkippedIncidents(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, entry := range stored {
+		entry.Source = "admin"
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Incident < entries[j].Incident })
+
+	if entries == nil {
+		entries = []skippedIncident{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"incidents": entries})
+}
+
+// skipListRequest is the body of POST /admin/skip/add and /admin/skip/remove
+type skipListRequest struct {
+	Incident string `json:"incident"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func decodeSkipListRequest(w http.ResponseWriter, r *http.Request) (skipListRequest, bool) {
+	var request skipListRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return request, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return request, false
+	}
+	if skipListKey(request.Incident) == "" {
+		http.Error(w, "incident is required", http.StatusBadRequest)
+		return request, false
+	}
+	return request, true
+}
+
+// adminSkipAddHandler adds an incident to the skip list
+func (s *IncidentJiraSync) adminSkipAddHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeSkipListRequest(w, r)
+	if !ok {
+		return
+	}
+
+	entry := skippedIncident{Incident: strings.TrimSpace(request.Incident), Reason: request.Reason, AddedAt: time.Now().UTC(), Source: "admin"}
+	if key, authenticated := s.authenticateAdmin(r); authenticated {
+		entry.AddedBy = key.Name
+	}
+	if err := s.store.SkipIncident(r.Context(), entry); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Added %s to the skip list: %s", entry.Incident, entry.Reason)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// adminSkipRemoveHandler takes an incident off the skip list
+func (s *IncidentJiraSync) adminSkipRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeSkipListRequest(w, r)
+	if !ok {
+		return
+	}
+	if s.config.SkipIncidents[skipListKey(request.Incident)] {
+		http.Error(w, "Incident is skipped by SKIP_INCIDENTS, remove it from the configuration", http.StatusConflict)
+		return
+	}
+
+	removed, err := s.store.UnskipIncident(r.Context(), request.Incident)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Incident is not on the skip list", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Removed %s from the skip list", strings.TrimSpace(request.Incident))
+	json.NewEncoder(w).Encode(map[string]string{"status": "removed"})
+}
+
+var incidentsSkippedTotal = newCounterVec(
+	"incident_jira_webhook_incidents_skipped_total",
+	"Syncs not made because the incident is on the skip list, by where it was listed (config or admin).",
+	"source")
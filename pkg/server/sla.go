@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// slaMinutes returns the minutes from the incident being reported until the named timestamp,
+// or until now while that timestamp is not set yet. final reports whether the value will not
+// change any more.
+func (s *IncidentJiraSync) slaMinutes(incident incidentio.Incident, timestampName string, now time.Time) (minutes float64, final bool, ok bool) {
+	reported, found := incident.Timestamp(s.config.SLAReportedTimestamp)
+	if !found {
+		reported = incident.CreatedAt
+	}
+	if reported.IsZero() {
+		return 0, false, false
+	}
+
+	end, final := incident.Timestamp(timestampName)
+	if !final {
+		end = now
+	}
+	if end.Before(reported) {
+		end = reported
+	}
+	return math.Round(end.Sub(reported).Minutes()), final, true
+}
+
+// syncSLAFields writes the time to acknowledge and time to resolve, in minutes, to their Jira
+// number fields. Until the incident is acknowledged or resolved the elapsed time is refreshed on
+// every event; afterwards the final value is written once.
+func (s *IncidentJiraSync) syncSLAFields(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	now := time.Now()
+	fields := make(map[string]interface{})
+	written := make(map[string]string)
+
+	for _, sla := range []struct {
+		attribute string
+		fieldID   string
+		timestamp string
+	}{
+		{"time_to_acknowledge", s.config.TimeToAcknowledgeJiraFieldID, s.config.SLAAcknowledgedTimestamp},
+		{"time_to_resolve", s.config.TimeToResolveJiraFieldID, s.config.SLAResolvedTimestamp},
+	} {
+		if sla.fieldID == "" {
+			continue
+		}
+
+		minutes, final, ok := s.slaMinutes(incident, sla.timestamp, now)
+		if !ok {
+			log.Printf("No reported time on incident %s, skipping %s", incident.ID, sla.attribute)
+			continue
+		}
+
+		value := fmt.Sprintf("%g", minutes)
+		if !s.lastWritten.changed(jiraIssueKey, sla.attribute, value) {
+			continue
+		}
+		if final {
+			log.Printf("Final %s of incident %s: %s minutes", sla.attribute, incident.ID, value)
+		}
+		fields[sla.fieldID] = minutes
+		written[sla.attribute] = value
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if err := s.updateJiraIssueFields(ctx, jiraIssueKey, fields); err != nil {
+		return err
+	}
+
+	for attribute, value := range written {
+		s.lastWritten.record(jiraIssueKey, attribute, value)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// findSprintBoardID returns the configured board, or the first scrum board of the issue's project
+func (s *IncidentJiraSync) findSprintBoardID(ctx context.Context, jiraIssueKey string) (string, error) {
+	if s.config.JiraSprintBoardID != "" {
+		return s.config.JiraSprintBoardID, nil
+	}
+
+	return s.jira.FindScrumBoardID(ctx, jira.ProjectKey(jiraIssueKey))
+}
+
+// processSprintField resolves the sprint named by an incident field and sets the Jira Sprint field
+func (s *IncidentJiraSync) processSprintField(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, jiraIssueKey string, fieldMapping mapping.FieldMapping) error {
+	var sprintName string
+	for _, value := range customFieldEntry.Values {
+		if sprintName = strings.TrimSpace(value.Text()); sprintName != "" {
+			break
+		}
+	}
+
+	if sprintName != "" {
+		names, err := s.transformValues(ctx, fieldMapping, []string{sprintName})
+		if err != nil {
+			return err
+		}
+		sprintName = ""
+		if len(names) > 0 {
+			sprintName = names[0]
+		}
+	}
+
+	if sprintName == "" {
+		log.Printf("No sprint set in %s, skipping", fieldMapping.IncidentFieldName)
+		return nil
+	}
+
+	boardID, err := s.findSprintBoardID(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+
+	sprintID, err := s.jira.FindSprintID(ctx, boardID, sprintName)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Mapped sprint %s -> %d", s.redactor.redactString(sprintName), sprintID)
+
+	fields := make(map[string]interface{})
+	for _, fieldID := range fieldMapping.EnabledFieldIDs() {
+		fields[fieldID] = sprintID
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return s.updateJiraIssueFields(ctx, jiraIssueKey, fields)
+}
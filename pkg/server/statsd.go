@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics backends selectable with METRICS_BACKEND
+const (
+	metricsPrometheus = "prometheus"
+	metricsStatsD     = "statsd"
+	metricsDogStatsD  = "dogstatsd"
+)
+
+// statsdMaxPacket keeps packets within a typical MTU
+const statsdMaxPacket = 1432
+
+// statsdFlushInterval bounds how long a metric waits to be sent
+const statsdFlushInterval = time.Second
+
+// metricsSink receives every counter increment and gauge update as it happens
+type metricsSink interface {
+	count(vec *metricVec, delta float64, labelValues []string)
+	gauge(vec *metricVec, value float64, labelValues []string)
+}
+
+// activeSinks are the push exporters metric updates are forwarded to
+var activeSinks atomic.Pointer[[]metricsSink]
+
+func forwardMetric(vec *metricVec, counter bool, value float64, labelValues []string) {
+	sinks := activeSinks.Load()
+	if sinks == nil {
+		return
+	}
+	for _, sink := range *sinks {
+		if counter {
+			sink.count(vec, value, labelValues)
+		} else {
+			sink.gauge(vec, value, labelValues)
+		}
+	}
+}
+
+// validateMetricsBackends checks the METRICS_BACKEND list
+func validateMetricsBackends(backends map[string]bool) error {
+	for backend := range backends {
+		switch backend {
+		case metricsPrometheus, metricsStatsD, metricsDogStatsD:
+		default:
+			return fmt.Errorf("unknown metrics backend %q, expected %s, %s or %s", backend, metricsPrometheus, metricsStatsD, metricsDogStatsD)
+		}
+	}
+	if backends[metricsStatsD] && backends[metricsDogStatsD] {
+		return fmt.Errorf("choose one of %s and %s", metricsStatsD, metricsDogStatsD)
+	}
+	return nil
+}
+
+// startMetricsExporters starts the StatsD exporter if METRICS_BACKEND selects one
+func startMetricsExporters(config Config) error {
+	dogStatsD := config.MetricsBackends[metricsDogStatsD]
+	if !dogStatsD && !config.MetricsBackends[metricsStatsD] {
+		return nil
+	}
+
+	conn, err := net.Dial("udp", config.StatsDAddr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to StatsD at %s: %w", config.StatsDAddr, err)
+	}
+
+	sink := &statsdSink{
+		conn:      conn,
+		prefix:    config.StatsDPrefix,
+		dogStatsD: dogStatsD,
+		tags:      config.StatsDTags,
+		lines:     make(chan string, 10000),
+	}
+	go sink.run()
+
+	activeSinks.Store(&[]metricsSink{sink})
+	log.Printf("Sending metrics to StatsD at %s", config.StatsDAddr)
+	return nil
+}
+
+// statsdSink sends metrics over UDP in StatsD or DogStatsD format. Plain StatsD has no tags,
+// so label values are appended to the metric name.
+type statsdSink struct {
+	conn      net.Conn
+	prefix    string
+	dogStatsD bool
+	tags      []string
+	lines     chan string
+}
+
+// statsdName turns a Prometheus metric name into a dotted StatsD name, dropping the service
+// prefix (replaced by STATSD_PREFIX) and the _total suffix of counters
+func (s *statsdSink) statsdName(vec *metricVec, labelValues []string) string {
+	name := strings.TrimPrefix(vec.name, "incident_jira_webhook_")
+	name = strings.TrimSuffix(name, "_total")
+	if s.prefix != "" {
+		name = s.prefix + "." + name
+	}
+
+	if !s.dogStatsD {
+		for _, value := range labelValues {
+			name += "." + statsdSanitizeName(value)
+		}
+	}
+	return name
+}
+
+func (s *statsdSink) statsdTags(vec *metricVec, labelValues []string) string {
+	if !s.dogStatsD {
+		return ""
+	}
+
+	tags := append([]string(nil), s.tags...)
+	for i, label := range vec.labels {
+		if i < len(labelValues) && labelValues[i] != "" {
+			tags = append(tags, label+":"+statsdSanitizeTag(labelValues[i]))
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+// statsdSanitizeTag replaces the characters that delimit StatsD lines and tags
+var statsdSanitizeTag = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_", " ", "_").Replace
+
+// statsdSanitizeName also replaces dots, which separate the parts of a StatsD name
+func statsdSanitizeName(value string) string {
+	return strings.ReplaceAll(statsdSanitizeTag(value), ".", "_")
+}
+
+func (s *statsdSink) count(vec *metricVec, delta float64, labelValues []string) {
+	s.send(fmt.Sprintf("%s:%g|c%s", s.statsdName(vec, labelValues), delta, s.statsdTags(vec, labelValues)))
+}
+
+func (s *statsdSink) gauge(vec *metricVec, value float64, labelValues []string) {
+	s.send(fmt.Sprintf("%s:%g|g%s", s.statsdName(vec, labelValues), value, s.statsdTags(vec, labelValues)))
+}
+
+// send queues a line without blocking; lines are dropped if the exporter falls behind
+func (s *statsdSink) send(line string) {
+	select {
+	case s.lines <- line:
+	default:
+	}
+}
+
+// run batches lines into packets, sending each when full or after statsdFlushInterval
+func (s *statsdSink) run() {
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+
+	var packet strings.Builder
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := s.conn.Write([]byte(packet.String())); err != nil {
+			log.Printf("Failed to send metrics to StatsD: %v", err)
+		}
+		packet.Reset()
+	}
+
+	for {
+		select {
+		case line := <-s.lines:
+			if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+				flush()
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// lastWrittenValues remembers the last value written per issue and attribute in the state
+// store, so incident-level attributes are only written to Jira when they change
+type lastWrittenValues struct {
+	store stateStore
+}
+
+func newLastWrittenValues(store stateStore) *lastWrittenValues {
+	return &lastWrittenValues{store: store}
+}
+
+// changed reports whether value differs from the last one written. If the store can't be read
+// the value counts as changed, as writing it again is harmless.
+func (l *lastWrittenValues) changed(jiraIssueKey, attribute, value string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	last, found, err := l.store.LastWritten(ctx, jiraIssueKey, attribute)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return true
+	}
+	return !found || last != value
+}
+
+func (l *lastWrittenValues) record(jiraIssueKey, attribute, value string) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := l.store.RecordWritten(ctx, jiraIssueKey, attribute, value); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// syncStatusCategory writes the Jira select option mapped from the incident's status category
+// (e.g. live -> "Live") whenever the category changes
+func (s *IncidentJiraSync) syncStatusCategory(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if s.config.StatusCategoryJiraFieldID == "" {
+		return nil
+	}
+
+	category := strings.ToLower(incident.IncidentStatus.Category)
+	if category == "" {
+		return nil
+	}
+
+	option, mapped := s.config.StatusCategoryMapping[category]
+	if !mapped {
+		log.Printf("No Jira option mapped for status category %s, skipping", category)
+		return nil
+	}
+
+	if !s.lastWritten.changed(jiraIssueKey, "status_category", option) {
+		return nil
+	}
+
+	log.Printf("Mapped status category %s -> %s", category, option)
+	fields := map[string]interface{}{
+		s.config.StatusCategoryJiraFieldID: jira.SelectValue{Value: option},
+	}
+	if err := s.updateJiraIssueFields(ctx, jiraIssueKey, fields); err != nil {
+		return err
+	}
+
+	s.lastWritten.record(jiraIssueKey, "status_category", option)
+	return nil
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// State store backends selectable with STATE_STORE
+const (
+	storeMemory   = "memory"
+	storePostgres = "postgres"
+)
+
+// storeTimeout bounds a single state store call made outside a request context
+const storeTimeout = 5 * time.Second
+
+// stateStore holds the state the service keeps between webhooks: attribute values last written
+// to each issue, the issue each incident was last seen linked to, catalog entries removed from
+// incident fields, the mapping rules last imported, processed webhook deliveries, the incident
+// skip list, the history of Jira writes, field syncs saved by drains and the outbox of writes
+// back to incident.io. The memory store covers a single replica; the Postgres store shares
+// state between replicas.
+type stateStore interface {
+	// LastWritten returns the value last written to an issue attribute
+	LastWritten(ctx context.Context, jiraIssueKey, attribute string) (value string, found bool, err error)
+	// RecordWritten stores the value written to an issue attribute
+	RecordWritten(ctx context.Context, jiraIssueKey, attribute, value string) error
+	// ClaimIssueCreation claims creating the Jira issue of an incident for lease. It returns the
+	// issue already created for the incident, if any, and claimed false while another claim holds.
+	ClaimIssueCreation(ctx context.Context, incidentID string, lease time.Duration) (jiraIssueKey string, claimed bool, err error)
+	// CompleteIssueCreation records the issue created for an incident under a claim
+	CompleteIssueCreation(ctx context.Context, incidentID, jiraIssueKey string) error
+	// ReleaseIssueCreation gives up a claim that created no issue
+	ReleaseIssueCreation(ctx context.Context, incidentID string) error
+	// AddTombstones records catalog entries removed from an incident field, until the removal
+	// has been applied to the issue
+	AddTombstones(ctx context.Context, jiraIssueKey, fieldName string, entries []incidentio.CatalogEntry) error
+	// Tombstones returns the removed catalog entries of an issue's field
+	Tombstones(ctx context.Context, jiraIssueKey, fieldName string) ([]incidentio.CatalogEntry, error)
+	// ClearTombstones forgets removed catalog entries of an issue's field
+	ClearTombstones(ctx context.Context, jiraIssueKey, fieldName string, catalogEntryIDs []string) error
+	// SaveMappingRules stores the rules document last imported through the admin API
+	SaveMappingRules(ctx context.Context, document []byte) error
+	// MappingRules returns the rules document last imported through the admin API
+	MappingRules(ctx context.Context) (document []byte, found bool, err error)
+	// SwapIssueLink stores the issue linked to an incident and returns the one stored before
+	SwapIssueLink(ctx context.Context, incidentID, jiraIssueKey string) (previous string, found bool, err error)
+	// Processed webhook deliveries, unless DEDUP_STORE selects another store
+	deliveryStore
+	// AppendHistory stores a processing event; the memory store keeps no history
+	AppendHistory(ctx context.Context, event streamEvent) error
+	// ScanHistory passes the stored events from from up to to, oldest first, to visit
+	ScanHistory(ctx context.Context, from, to time.Time, visit func(streamEvent) error) error
+	// SkippedIncidents returns the skip list entries added at runtime
+	SkippedIncidents(ctx context.Context) ([]skippedIncident, error)
+	// SkipIncident adds or replaces a skip list entry
+	SkipIncident(ctx context.Context, entry skippedIncident) error
+	// UnskipIncident removes a skip list entry and reports whether there was one
+	UnskipIncident(ctx context.Context, incident string) (bool, error)
+	// SaveRetries stores queued field syncs left by a drain; the memory store keeps them only
+	// until the process exits
+	SaveRetries(ctx context.Context, retries []savedRetry) error
+	// TakeRetries removes and returns the saved field syncs
+	TakeRetries(ctx context.Context) ([]savedRetry, error)
+	// EnqueueOutbox adds a write back to incident.io to the outbox and records the values it
+	// writes, in one transaction; the memory store keeps the outbox only until the process exits
+	EnqueueOutbox(ctx context.Context, message outboxMessage, written []writtenValue) error
+	// ClaimOutbox leases up to limit due outbox messages, each the oldest of its incident
+	ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]outboxMessage, error)
+	// CompleteOutbox removes a delivered outbox message
+	CompleteOutbox(ctx context.Context, id int64) error
+	// FailOutbox records a failed delivery and when to attempt the message next
+	FailOutbox(ctx context.Context, id int64, attempts int, nextAttempt time.Time, lastError string) error
+	// DeadLetterOutbox sets aside a message that won't be delivered. It is kept for inspection
+	// but no longer claimed, nor holds back the later messages of its incident.
+	DeadLetterOutbox(ctx context.Context, id int64, attempts int, lastError string) error
+	// OutboxBacklog counts the waiting and dead-lettered outbox messages
+	OutboxBacklog(ctx context.Context) (outboxBacklog, error)
+	Close() error
+}
+
+// newStateStore returns the store selected by STATE_STORE
+func newStateStore(config Config) (stateStore, error) {
+	switch config.StateStore {
+	case "", storeMemory:
+		return newMemoryStore(), nil
+	case storePostgres:
+		return openPostgresStore(config.StateStoreURL, config.StateStoreAutoMigrate)
+	}
+	return nil, fmt.Errorf("unknown state store: %s", config.StateStore)
+}
+
+// memoryStore is the in-process state store
+type memoryStore struct {
+	mu         sync.Mutex
+	written    map[string]string
+	issueLinks map[string]string
+	creations  map[string]issueCreation
+	tombstones *tombstones
+	deliveries map[string]time.Time
+	skipped    map[string]skippedIncident
+	retries    []savedRetry
+	outbox     memoryOutbox
+	rules      []byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		written:    make(map[string]string),
+		issueLinks: make(map[string]string),
+		creations:  make(map[string]issueCreation),
+		tombstones: newTombstones(),
+		deliveries: make(map[string]time.Time),
+		skipped:    make(map[string]skippedIncident),
+		outbox:     memoryOutbox{messages: make(map[int64]outboxMessage), deadLetters: make(map[int64]outboxMessage)},
+	}
+}
+
+func (m *memoryStore) LastWritten(ctx context.Context, jiraIssueKey, attribute string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, found := m.written[jiraIssueKey+"/"+attribute]
+	return value, found, nil
+}
+
+func (m *memoryStore) RecordWritten(ctx context.Context, jiraIssueKey, attribute, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.written[jiraIssueKey+"/"+attribute] = value
+	return nil
+}
+
+// issueCreation is the claim on creating an incident's Jira issue, and the issue once created
+type issueCreation struct {
+	jiraIssueKey string
+	claimedUntil time.Time
+}
+
+func (m *memoryStore) ClaimIssueCreation(ctx context.Context, incidentID string, lease time.Duration) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	creation, found := m.creations[incidentID]
+	if found && (creation.jiraIssueKey != "" || time.Now().Before(creation.claimedUntil)) {
+		return creation.jiraIssueKey, false, nil
+	}
+	m.creations[incidentID] = issueCreation{claimedUntil: time.Now().Add(lease)}
+	return "", true, nil
+}
+
+func (m *memoryStore) CompleteIssueCreation(ctx context.Context, incidentID, jiraIssueKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.creations[incidentID] = issueCreation{jiraIssueKey: jiraIssueKey}
+	return nil
+}
+
+func (m *memoryStore) ReleaseIssueCreation(ctx context.Context, incidentID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.creations[incidentID].jiraIssueKey == "" {
+		delete(m.creations, incidentID)
+	}
+	return nil
+}
+
+func (m *memoryStore) AddTombstones(ctx context.Context, jiraIssueKey, fieldName string, entries []incidentio.CatalogEntry) error {
+	m.tombstones.add(jiraIssueKey, fieldName, entries)
+	return nil
+}
+
+func (m *memoryStore) Tombstones(ctx context.Context, jiraIssueKey, fieldName string) ([]incidentio.CatalogEntry, error) {
+	return m.tombstones.list(jiraIssueKey, fieldName), nil
+}
+
+func (m *memoryStore) ClearTombstones(ctx context.Context, jiraIssueKey, fieldName string, catalogEntryIDs []string) error {
+	m.tombstones.clear(jiraIssueKey, fieldName, catalogEntryIDs)
+	return nil
+}
+
+func (m *memoryStore) SaveMappingRules(ctx context.Context, document []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules = append([]byte(nil), document...)
+	return nil
+}
+
+func (m *memoryStore) MappingRules(ctx context.Context) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rules, m.rules != nil, nil
+}
+
+func (m *memoryStore) SwapIssueLink(ctx context.Context, incidentID, jiraIssueKey string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous, found := m.issueLinks[incidentID]
+	m.issueLinks[incidentID] = jiraIssueKey
+	return previous, found, nil
+}
+
+func (m *memoryStore) DeliveryProcessed(ctx context.Context, deliveryID string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	processedAt, found := m.deliveries[deliveryID]
+	return found && time.Since(processedAt) < ttl, nil
+}
+
+func (m *memoryStore) RecordDelivery(ctx context.Context, deliveryID string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, processedAt := range m.deliveries {
+		if now.Sub(processedAt) >= ttl {
+			delete(m.deliveries, id)
+		}
+	}
+	m.deliveries[deliveryID] = now
+	return nil
+}
+
+func (m *memoryStore) AppendHistory(ctx context.Context, event streamEvent) error {
+	return nil
+}
+
+func (m *memoryStore) ScanHistory(ctx context.Context, from, to time.Time, visit func(streamEvent) error) error {
+	return errNoHistory
+}
+
+func (m *memoryStore) SkippedIncidents(ctx context.Context) ([]skippedIncident, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]skippedIncident, 0, len(m.skipped))
+	for _, entry := range m.skipped {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (m *memoryStore) SkipIncident(ctx context.Context, entry skippedIncident) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.skipped[skipListKey(entry.Incident)] = entry
+	return nil
+}
+
+func (m *memoryStore) UnskipIncident(ctx context.Context, incident string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := skipListKey(incident)
+	_, found := m.skipped[key]
+	delete(m.skipped, key)
+	return found, nil
+}
+
+func (m *memoryStore) SaveRetries(ctx context.Context, retries []savedRetry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries = append(m.retries, retries...)
+	return nil
+}
+
+func (m *memoryStore) TakeRetries(ctx context.Context) ([]savedRetry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	retries := m.retries
+	m.retries = nil
+	return retries, nil
+}
+
+// memoryOutbox holds the memory store's outbox, which lasts until the process exits
+type memoryOutbox struct {
+	nextID      int64
+	messages    map[int64]outboxMessage
+	deadLetters map[int64]outboxMessage
+}
+
+// claim returns up to limit due messages, skipping those of incidents with an older message
+// still waiting, and leases them until now plus lease
+func (o *memoryOutbox) claim(limit int, lease time.Duration) []outboxMessage {
+	ids := make([]int64, 0, len(o.messages))
+	for id := range o.messages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	now := time.Now()
+	waiting := make(map[string]bool)
+	var claimed []outboxMessage
+	for _, id := range ids {
+		message := o.messages[id]
+		incident := message.Organization + "/" + message.IncidentID
+		if waiting[incident] {
+			continue
+		}
+		waiting[incident] = true
+		if message.NextAttempt.After(now) || len(claimed) == limit {
+			continue
+		}
+		message.NextAttempt = now.Add(lease)
+		o.messages[id] = message
+		claimed = append(claimed, message)
+	}
+	return claimed
+}
+
+func (m *memoryStore) EnqueueOutbox(ctx context.Context, message outboxMessage, written []writtenValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outbox.nextID++
+	message.ID = m.outbox.nextID
+	m.outbox.messages[message.ID] = message
+	for _, value := range written {
+		m.written[value.Key+"/"+value.Attribute] = value.Value
+	}
+	return nil
+}
+
+func (m *memoryStore) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]outboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.outbox.claim(limit, lease), nil
+}
+
+func (m *memoryStore) CompleteOutbox(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.outbox.messages, id)
+	return nil
+}
+
+func (m *memoryStore) FailOutbox(ctx context.Context, id int64, attempts int, nextAttempt time.Time, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	message, found := m.outbox.messages[id]
+	if !found {
+		return nil
+	}
+	message.Attempts = attempts
+	message.NextAttempt = nextAttempt
+	message.LastError = lastError
+	m.outbox.messages[id] = message
+	return nil
+}
+
+func (m *memoryStore) DeadLetterOutbox(ctx context.Context, id int64, attempts int, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	message, found := m.outbox.messages[id]
+	if !found {
+		return nil
+	}
+	message.Attempts = attempts
+	message.LastError = lastError
+	delete(m.outbox.messages, id)
+	m.outbox.deadLetters[id] = message
+	return nil
+}
+
+func (m *memoryStore) OutboxBacklog(ctx context.Context) (outboxBacklog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	backlog := outboxBacklog{Pending: len(m.outbox.messages), DeadLettered: len(m.outbox.deadLetters)}
+	for _, message := range m.outbox.messages {
+		if backlog.Oldest == nil || message.CreatedAt.Before(*backlog.Oldest) {
+			createdAt := message.CreatedAt
+			backlog.Oldest = &createdAt
+		}
+	}
+	return backlog, nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// deliveryProcessed reports whether a webhook delivery was already processed. Deliveries
+// without an ID, and every delivery when DELIVERY_DEDUP_TTL is 0, are processed.
+func (s *IncidentJiraSync) deliveryProcessed(deliveryID string) bool {
+	if deliveryID == "" || s.config.DeliveryDedupTTL <= 0 {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	processed, err := s.deliveries.DeliveryProcessed(ctx, deliveryID, s.config.DeliveryDedupTTL)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return false
+	}
+	return processed
+}
+
+// recordDelivery remembers a processed webhook delivery so a redelivery is skipped
+func (s *IncidentJiraSync) recordDelivery(deliveryID string) {
+	if deliveryID == "" || s.config.DeliveryDedupTTL <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := s.deliveries.RecordDelivery(ctx, deliveryID, s.config.DeliveryDedupTTL); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
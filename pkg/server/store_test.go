@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+func TestMemoryOutboxClaim(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	now := time.Now()
+	enqueue := func(organization, incidentID, description string, nextAttempt time.Time) {
+		t.Helper()
+		message := outboxMessage{Organization: organization, IncidentID: incidentID, Description: description, CreatedAt: now, NextAttempt: nextAttempt}
+		if err := store.EnqueueOutbox(ctx, message, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enqueue("", "inc_1", "first of inc_1", now)
+	enqueue("", "inc_1", "second of inc_1", now)
+	enqueue("", "inc_2", "inc_2 backing off", now.Add(time.Hour))
+	enqueue("", "inc_2", "behind the backoff", now)
+	enqueue("other", "inc_1", "inc_1 of another organization", now)
+	enqueue("", "inc_3", "over the limit", now)
+
+	claimed, err := store.ClaimOutbox(ctx, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var descriptions []string
+	for _, message := range claimed {
+		descriptions = append(descriptions, message.Description)
+	}
+	if len(descriptions) != 2 || descriptions[0] != "first of inc_1" || descriptions[1] != "inc_1 of another organization" {
+		t.Fatalf("claimed %q, want the oldest due message of each incident, up to the limit", descriptions)
+	}
+
+	// Leased messages aren't claimed again until the lease ends
+	claimed, _ = store.ClaimOutbox(ctx, 10, time.Minute)
+	if len(claimed) != 1 || claimed[0].Description != "over the limit" {
+		t.Fatalf("claimed %+v, want only the message left over", claimed)
+	}
+
+	if err := store.CompleteOutbox(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.FailOutbox(ctx, 6, 1, now.Add(-time.Second), "timeout"); err != nil {
+		t.Fatal(err)
+	}
+	claimed, _ = store.ClaimOutbox(ctx, 10, time.Minute)
+	if len(claimed) != 2 || claimed[0].Description != "second of inc_1" || claimed[1].Description != "over the limit" || claimed[1].Attempts != 1 || claimed[1].LastError != "timeout" {
+		t.Errorf("claimed %+v, want the next of inc_1 and the failed message", claimed)
+	}
+
+	backlog, err := store.OutboxBacklog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backlog.Pending != 5 || backlog.Oldest == nil || !backlog.Oldest.Equal(now) {
+		t.Errorf("backlog = %+v, want 5 pending", backlog)
+	}
+}
+
+func TestEnqueueOutboxRecordsWrittenValues(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	written := []writtenValue{{Key: "incident/inc_1", Attribute: "failure_note", Value: "note"}}
+	if err := store.EnqueueOutbox(ctx, outboxMessage{IncidentID: "inc_1"}, written); err != nil {
+		t.Fatal(err)
+	}
+	if value, found, _ := store.LastWritten(ctx, "incident/inc_1", "failure_note"); !found || value != "note" {
+		t.Errorf("LastWritten() = %q, %v, want the value queued with the message", value, found)
+	}
+}
+
+func TestMemoryOutboxDeadLetter(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	for _, description := range []string{"rejected", "next"} {
+		if err := store.EnqueueOutbox(ctx, outboxMessage{IncidentID: "inc_1", Description: description}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.DeadLetterOutbox(ctx, 1, 3, "not found"); err != nil {
+		t.Fatal(err)
+	}
+	// The incident's next message no longer waits behind it
+	claimed, _ := store.ClaimOutbox(ctx, 10, time.Minute)
+	if len(claimed) != 1 || claimed[0].Description != "next" {
+		t.Errorf("claimed %+v, want the message after the dead letter", claimed)
+	}
+
+	backlog, _ := store.OutboxBacklog(ctx)
+	if backlog.Pending != 1 || backlog.DeadLettered != 1 {
+		t.Errorf("backlog = %+v, want 1 pending and 1 dead-lettered", backlog)
+	}
+	if dead := store.outbox.deadLetters[1]; dead.Attempts != 3 || dead.LastError != "not found" {
+		t.Errorf("dead letter = %+v", dead)
+	}
+}
+
+func TestMemoryTombstones(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	removed := []incidentio.CatalogEntry{{ID: "entry_1", Name: "Payments"}, {ID: "entry_2", Name: "Search"}}
+	if err := store.AddTombstones(ctx, "OPS-1", "Affected services", removed); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := store.Tombstones(ctx, "OPS-1", "Affected services")
+	if len(entries) != 2 {
+		t.Errorf("tombstones = %+v, want both removed entries", entries)
+	}
+	if entries, _ := store.Tombstones(ctx, "OPS-2", "Affected services"); len(entries) != 0 {
+		t.Errorf("tombstones of another issue = %+v, want none", entries)
+	}
+
+	store.ClearTombstones(ctx, "OPS-1", "Affected services", []string{"entry_1"})
+	entries, _ = store.Tombstones(ctx, "OPS-1", "Affected services")
+	if len(entries) != 1 || entries[0].ID != "entry_2" {
+		t.Errorf("tombstones after clearing entry_1 = %+v, want entry_2", entries)
+	}
+}
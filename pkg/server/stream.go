@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// Types of live stream events
+const (
+	streamWebhookReceived  = "webhook_received"
+	streamWebhookProcessed = "webhook_processed"
+	streamMapping          = "mapping"
+	streamJiraWrite        = "jira_write"
+)
+
+// streamHeartbeatInterval keeps idle streams open through proxies
+const streamHeartbeatInterval = 15 * time.Second
+
+// streamSubscriberBuffer is how many events a slow subscriber may fall behind before events
+// are dropped for it
+const streamSubscriberBuffer = 100
+
+// streamEvent is one entry of the live event stream
+type streamEvent struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	EventType string    `json:"event_type,omitempty"`
+	IssueKey  string    `json:"issue_key,omitempty"`
+	Field     string    `json:"field,omitempty"`
+	Outcome   string    `json:"outcome,omitempty"`
+	Message   string    `json:"message"`
+	// DurationMS is how long a processed webhook took from receipt, in milliseconds
+	DurationMS int64 `json:"duration_ms,omitempty"`
+}
+
+// eventStream fans events out to the connected /admin/stream clients. Publishing never blocks
+// webhook processing: subscribers that fall behind miss events.
+type eventStream struct {
+	mu          sync.Mutex
+	subscribers map[chan streamEvent]struct{}
+
+	// Jira writes and webhook outcomes are also kept as sync history
+	history stateStore
+}
+
+func newEventStream(history stateStore) *eventStream {
+	return &eventStream{subscribers: make(map[chan streamEvent]struct{}), history: history}
+}
+
+func (e *eventStream) subscribe() (chan streamEvent, func()) {
+	events := make(chan streamEvent, streamSubscriberBuffer)
+	e.mu.Lock()
+	e.subscribers[events] = struct{}{}
+	e.mu.Unlock()
+
+	return events, func() {
+		e.mu.Lock()
+		delete(e.subscribers, events)
+		e.mu.Unlock()
+	}
+}
+
+func (e *eventStream) publish(event streamEvent) {
+	event.Time = time.Now().UTC()
+	if e.history != nil && (event.Type == streamJiraWrite || event.Type == streamWebhookProcessed) {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		if err := e.history.AppendHistory(ctx, event); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		cancel()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.subscribers) == 0 {
+		return
+	}
+	for events := range e.subscribers {
+		select {
+		case events <- event:
+		default:
+			streamEventsDroppedTotal.inc()
+		}
+	}
+}
+
+// adminStreamHandler serves a live feed of webhook receipts, mapping decisions and Jira write
+// results as server-sent events
+func (s *IncidentJiraSync) adminStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events, unsubscribe := s.stream.subscribe()
+	defer unsubscribe()
+	log.Printf("Live event stream opened by %s", r.RemoteAddr)
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Printf("Live event stream closed by %s", r.RemoteAddr)
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// publishWebhookOutcome adds the result of handling a webhook to the live stream
+func (s *IncidentJiraSync) publishWebhookOutcome(payload incidentio.WebhookPayload, outcome, message string) {
+	s.publishProcessedWebhook(payload, outcome, message, 0)
+}
+
+// publishProcessedWebhook publishes the outcome of a webhook that was processed, with the time
+// it took from receipt, which reliability reports take the latency from
+func (s *IncidentJiraSync) publishProcessedWebhook(payload incidentio.WebhookPayload, outcome, message string, latency time.Duration) {
+	s.stream.publish(streamEvent{
+		Type:       streamWebhookProcessed,
+		EventType:  payload.EventType,
+		IssueKey:   payload.Incident.ExternalIssueReference.IssueName,
+		Outcome:    outcome,
+		Message:    message,
+		DurationMS: latency.Milliseconds(),
+	})
+}
+
+var streamEventsDroppedTotal = newCounterVec(
+	"incident_jira_webhook_stream_events_dropped_total",
+	"Live stream events dropped because a subscriber fell behind.")
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// closureSummaryFilename is the name of the summary attached to the issue of a closed incident
+func closureSummaryFilename(incident incidentio.Incident) string {
+	name := incident.ID
+	if incident.Reference != "" {
+		name = incident.Reference
+	}
+	return fmt.Sprintf("incident-summary-%s.md", templateSlug(name))
+}
+
+// syncClosureSummary attaches a Markdown summary of the incident to the Jira issue when the
+// incident is closed, as a point-in-time record for auditors. An existing attachment of the same
+// name counts as done, so each incident gets one summary even across restarts.
+func (s *IncidentJiraSync) syncClosureSummary(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if !s.config.ClosureSummaryEnabled || incident.IncidentStatus.Category != "closed" {
+		return nil
+	}
+
+	filename := closureSummaryFilename(incident)
+	if !s.lastWritten.changed(jiraIssueKey, "closure_summary", filename) {
+		return nil
+	}
+
+	attachments, err := s.jira.Attachments(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		if attachment.Filename == filename {
+			s.lastWritten.record(jiraIssueKey, "closure_summary", filename)
+			return nil
+		}
+	}
+
+	// Webhook payloads can omit the role assignments and timestamps the summary needs
+	if fullIncident, err := s.incidentClient(ctx).GetIncident(ctx, incident.ID); err != nil {
+		log.Printf("Warning: failed to fetch incident %s, summarising the webhook payload: %v", incident.ID, err)
+	} else {
+		incident = *fullIncident
+	}
+
+	updates, err := s.incidentClient(ctx).ListIncidentUpdates(ctx, incident.ID)
+	if err != nil {
+		log.Printf("Warning: failed to fetch updates of incident %s, summarising without them: %v", incident.ID, err)
+	}
+
+	data := templateData{Incident: incident, IssueKey: jiraIssueKey, Updates: updates, GeneratedAt: time.Now()}
+	summary, ok, err := s.renderTemplate(ctx, templateClosureSummary, data)
+	if err != nil || !ok {
+		return err
+	}
+
+	log.Printf("Attaching closure summary of incident %s to %s", incident.ID, jiraIssueKey)
+	if err := s.jira.AddAttachment(ctx, jiraIssueKey, filename, []byte(summary+"\n")); err != nil {
+		return fmt.Errorf("failed to attach closure summary: %w", err)
+	}
+	s.stream.publish(streamEvent{Type: streamJiraWrite, IssueKey: jiraIssueKey, Outcome: "success", Message: "attachment " + filename})
+
+	s.lastWritten.record(jiraIssueKey, "closure_summary", filename)
+	return nil
+}
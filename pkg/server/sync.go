@@ -0,0 +1,1048 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// defaultEvents are the event types processed when EVENTS is not set
+const defaultEvents = "incident.custom_field_updated,public_incident.incident_updated_v2"
+
+// eventIgnoreReason returns why an event type should be ignored ("unknown" or
+// "unsubscribed"), or an empty string if it should be processed
+func (s *IncidentJiraSync) eventIgnoreReason(eventType string) string {
+	if !incidentio.IsKnownEventType(eventType) && !s.config.InitialSyncEvents[eventType] {
+		return "unknown"
+	}
+	if !s.config.Events[eventType] && !s.config.InitialSyncEvents[eventType] {
+		return "unsubscribed"
+	}
+	return ""
+}
+
+// eventTypeLabel returns the event type to label metrics with. Types neither known nor
+// configured, which the sender controls, are counted as "other" so they can't add unbounded
+// series; the unknown event sampler keeps their names.
+func (s *IncidentJiraSync) eventTypeLabel(eventType string) string {
+	if incidentio.KnownEventTypes[eventType] || s.config.Events[eventType] || s.config.InitialSyncEvents[eventType] {
+		return eventType
+	}
+	return "other"
+}
+
+// IncidentJiraSync handles the synchronization logic
+type IncidentJiraSync struct {
+	config Config
+
+	// Clients for the upstream APIs, each with its own connection pool
+	jira     *jira.Client
+	incident *incidentio.Client
+
+	// Assets objects created (or matched) for catalog entries without an object key, and the
+	// lookups running for them
+	assetsMu             sync.Mutex
+	createdAssetsObjects map[string]string
+	assetsLookups        map[string]*assetsLookup
+
+	// Field syncs waiting to be retried, and those waiting out their backoff first
+	retryQueue chan retryItem
+	scheduled  scheduledRetries
+
+	// Wakes the outbox dispatcher when a write back to incident.io is queued
+	outboxWake chan struct{}
+
+	// How long the retry worker and outbox dispatcher have been busy, for the systemd watchdog
+	retryProgress  loopProgress
+	outboxProgress loopProgress
+
+	// Set by /admin/drain; webhook deliveries and retries in flight are counted for it
+	draining atomic.Bool
+	inflight atomic.Int64
+
+	// Webhook events processing or waiting to, by priority
+	admission *admission
+
+	// Catalog entries cached for lookups, when CATALOG_WARM_TYPES are warmed
+	catalog *catalogCache
+
+	// The incident.io organization of INCIDENT_API_TOKEN, using incident and catalog, and
+	// those of INCIDENT_ORGANIZATIONS by name
+	defaultOrganization *incidentOrganization
+	organizations       map[string]*incidentOrganization
+
+	// Jira account IDs of incident responders, by email address
+	accountIDs *accountIDCache
+
+	// Issue links, processed deliveries, written values and sync history
+	store stateStore
+	// Processed webhook deliveries, in the state store or the DEDUP_STORE
+	deliveries deliveryStore
+
+	// Incident-level attribute values last written to each issue
+	lastWritten *lastWrittenValues
+
+	// Comparison of SHADOW_MAPPING_RULES_FILE with the active mapping rules
+	shadow *shadowReport
+
+	// Serializes writes per Jira issue
+	locker issueLocker
+
+	// Removes sensitive data from logged payloads and values
+	redactor *redactor
+
+	// Live feed of processing for /admin/stream
+	stream *eventStream
+
+	// Rate limiting signalled by Jira, which backfills respect, and by incident.io
+	jiraBudget     *rateBudget
+	incidentBudget *rateBudget
+
+	// Consecutive failed deliveries by incident, for the Retry-After of failures
+	redeliveries *redeliveryBackoff
+
+	// The running or last backfill
+	backfillMu sync.Mutex
+	backfill   *backfillRun
+
+	// Assets workspaces of Assets fields, by project
+	workspaces *assetsWorkspaces
+
+	// Periodic reconciliation sweeps
+	reconciler reconciler
+
+	// End-to-end latency of recent webhooks, checked against LATENCY_BUDGET
+	latency *latencyTracker
+
+	// Incident fields seen without a mapping, for the unmapped fields metric
+	coverage *mappingCoverage
+
+	// Last deliveries ignored as unknown or unsubscribed, for /admin/unknown-events
+	unknownEvents *unknownEvents
+
+	// Incidents logged verbosely, started through /admin/debug/incident
+	debugger *incidentDebugger
+
+	// Warnings of the last configuration check against Jira, reported by /health
+	lint configLint
+
+	// Settings reloaded on SIGHUP, replacing those of config
+	reloadable atomic.Pointer[reloadableSettings]
+	// Serializes imports of mapping rules through the admin API with configuration reloads
+	mappingsMu sync.Mutex
+}
+
+// NewIncidentJiraSync builds the sync service for a loaded configuration
+func NewIncidentJiraSync(config Config) (*IncidentJiraSync, error) {
+	payloadRedactor, err := newRedactor(config.RedactFields, config.RedactPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction settings: %w", err)
+	}
+
+	locker, err := newIssueLocker(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure issue locking: %w", err)
+	}
+
+	if err := startMetricsExporters(config); err != nil {
+		return nil, err
+	}
+
+	store, err := newStateStore(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store: %w", err)
+	}
+
+	deliveries, err := newDeliveryStore(config, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure delivery dedup: %w", err)
+	}
+
+	jiraBudget := newRateBudget(config.JiraThrottleBelowPercent, config.JiraThrottleMaxDelay)
+	jiraClient := jira.NewClient(config.JiraBaseURL, config.JiraUsername, config.JiraAPIToken, newHTTPClient(upstreamJira, config.JiraHTTP, jiraBudget))
+	jiraClient.Cache = jira.NewResponseCache(config.JiraCacheTTL, config.JiraCacheMaxEntries)
+	jiraClient.AssetsBaseURL = config.AssetsAPIBaseURL
+	jiraClient.WorkspaceID = config.JiraWorkspaceID
+	jiraClient.Pagination = jira.Pagination{PageSize: config.JiraPageSize, MaxPages: config.MaxListPages}
+	jiraClient.Redact = payloadRedactor.redactJSON
+
+	incidentBudget := newRateBudget(0, config.IncidentHTTP.RetryAfterMax)
+	// newHTTPClient retries with the INCIDENT_HTTP_* settings and the delivery's budget, so the
+	// client adds no retries of its own
+	incidentClient := incidentio.NewClientWithRetries(config.IncidentAPIToken, newHTTPClient(upstreamIncident, config.IncidentHTTP, incidentBudget), incidentio.RetryConfig{})
+	incidentClient.BaseURL = config.IncidentAPIBaseURL
+	incidentClient.OperationTokens = config.incidentOperationTokens()
+	incidentClient.Pagination = incidentio.Pagination{PageSize: config.IncidentPageSize, MaxPages: config.MaxListPages}
+	incidentClient.Redact = payloadRedactor.redactJSON
+
+	s := &IncidentJiraSync{
+		config:               config,
+		jira:                 jiraClient,
+		incident:             incidentClient,
+		createdAssetsObjects: make(map[string]string),
+		assetsLookups:        make(map[string]*assetsLookup),
+		retryQueue:           make(chan retryItem, config.RetryQueueSize),
+		outboxWake:           make(chan struct{}, 1),
+		admission:            newAdmission(config.MaxConcurrentEvents, config.EventQueueSize),
+		catalog:              newCatalogCache(config),
+		accountIDs:           newAccountIDCache(),
+		store:                store,
+		deliveries:           deliveries,
+		lastWritten:          newLastWrittenValues(store),
+		shadow:               newShadowReport(),
+		locker:               locker,
+		redactor:             payloadRedactor,
+		stream:               newEventStream(store),
+		jiraBudget:           jiraBudget,
+		incidentBudget:       incidentBudget,
+		redeliveries:         newRedeliveryBackoff(config.RedeliveryMaxDelay),
+		workspaces:           newAssetsWorkspaces(),
+		latency:              newLatencyTracker(config.LatencyBudget, config.LatencyBudgetWindow),
+		coverage:             newMappingCoverage(config.UnmappedFieldMetricLimit),
+		unknownEvents:        newUnknownEvents(config.UnknownEventSamples, config.UnknownEventSampleBytes),
+		debugger:             newIncidentDebugger(),
+	}
+	s.defaultOrganization = &incidentOrganization{
+		client:     incidentClient,
+		budget:     incidentBudget,
+		catalog:    s.catalog,
+		warmTypes:  config.CatalogWarmTypes,
+		webhookURL: "/webhook",
+		secretEnv:  "WEBHOOK_SECRET",
+		secretSet:  config.WebhookSecret != "",
+	}
+	s.organizations = make(map[string]*incidentOrganization, len(config.IncidentOrganizations))
+	for name, organization := range config.IncidentOrganizations {
+		s.organizations[name] = newIncidentOrganization(config, organization, payloadRedactor.redactJSON)
+	}
+	s.reloadable.Store(reloadableFrom(config))
+	s.recordConfiguredMappings()
+	return s, nil
+}
+
+// resolveObjectIDs returns the Jira Assets objects for a catalog entry, one for each value of a
+// multi-valued attribute, creating the Assets object when the entry has no object key and
+// creation is enabled. Objects are resolved once in each distinct workspace the mapping's Jira
+// fields use on the issue, and carry their ID in each of them.
+func (s *IncidentJiraSync) resolveObjectIDs(ctx context.Context, jiraIssueKey string, catalogEntry *incidentio.CatalogEntry, fieldMapping mapping.FieldMapping) ([]jira.ComponentValue, error) {
+	workspaces := s.fieldWorkspaces(ctx, jiraIssueKey, fieldMapping.EnabledFieldIDs())
+
+	if objectID, found := s.externalObjectID(catalogEntry, fieldMapping); found {
+		value, err := s.inWorkspaces(ctx, workspaces, objectID, "")
+		if err != nil {
+			return nil, err
+		}
+		return []jira.ComponentValue{value}, nil
+	}
+
+	objectKeys, err := s.resolveCatalogAttributeValues(ctx, catalogEntry.ID, fieldMapping.CatalogAttributeOr(objectKeyAttribute))
+	if errors.Is(err, errNoCatalogAttribute) && s.config.AssetsCreateMissingObjects {
+		value, err := s.ensureInWorkspaces(ctx, workspaces, catalogEntry)
+		if err != nil {
+			return nil, err
+		}
+		return []jira.ComponentValue{value}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract the numeric IDs
+	values := make([]jira.ComponentValue, 0, len(objectKeys))
+	for _, objectKey := range objectKeys {
+		objectID, err := mapping.ExtractObjectID(objectKey, fieldMapping.ObjectKeyPatternOr(s.config.ObjectKeyPattern))
+		var value jira.ComponentValue
+		if err == nil {
+			value, err = s.inWorkspaces(ctx, workspaces, objectID, mapping.NormalizeObjectKey(objectKey))
+		}
+		if err != nil {
+			if len(objectKeys) == 1 || ctx.Err() != nil {
+				return nil, err
+			}
+			log.Printf("Skipping a value of catalog entry %s: %v", catalogEntry.ID, err)
+			continue
+		}
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no object IDs in the %d values of catalog entry %s", len(objectKeys), catalogEntry.ID)
+	}
+	return values, nil
+}
+
+// formatJiraComponentValue formats component value for Jira API, in JIRA_WORKSPACE_ID. Writes
+// move values to the workspace of each field with inFieldWorkspace.
+func (s *IncidentJiraSync) formatJiraComponentValue(objectID, catalogEntryID string) jira.ComponentValue {
+	return s.formatJiraComponentValueIn(s.config.JiraWorkspaceID, objectID)
+}
+
+// formatJiraComponentValueIn formats component value for Jira API in an Assets workspace
+func (s *IncidentJiraSync) formatJiraComponentValueIn(workspaceID, objectID string) jira.ComponentValue {
+	return jira.ComponentValue{
+		ID:       fmt.Sprintf("%s:%s", workspaceID, objectID),
+		ObjectID: objectID,
+	}
+}
+
+// updateJiraCustomField updates one or more custom fields in Jira with the provided values in a
+// single request. A field none of whose values were found in its Assets workspace is left
+// alone rather than cleared.
+func (s *IncidentJiraSync) updateJiraCustomField(ctx context.Context, jiraIssueKey string, fieldIDs []string, values []jira.ComponentValue) error {
+	// Each Assets field may use its own workspace
+	inWorkspace := make(map[string][]jira.ComponentValue, len(fieldIDs))
+	for _, fieldID := range fieldIDs {
+		fieldValues := s.inFieldWorkspace(ctx, jiraIssueKey, fieldID, values)
+		if len(fieldValues) == 0 && len(values) > 0 {
+			log.Printf("None of the values of %s were found in its Assets workspace, leaving it unchanged on %s", fieldID, jiraIssueKey)
+			continue
+		}
+		inWorkspace[fieldID] = fieldValues
+	}
+	if len(inWorkspace) == 0 {
+		return fmt.Errorf("none of the values were found in the Assets workspaces of %s", strings.Join(fieldIDs, ", "))
+	}
+
+	if s.config.JiraSkipUnchanged {
+		unchanged, err := s.jiraFieldsUnchanged(ctx, jiraIssueKey, inWorkspace)
+		if err != nil {
+			log.Printf("Failed to read current values of %s, updating anyway: %v", jiraIssueKey, err)
+		} else if unchanged {
+			log.Printf("%s already up to date in %s, skipping update", strings.Join(fieldIDs, ", "), jiraIssueKey)
+			return nil
+		}
+	}
+
+	fields := make(map[string]interface{}, len(inWorkspace))
+	for fieldID, fieldValues := range inWorkspace {
+		fields[fieldID] = fieldValues
+	}
+	return s.updateJiraIssueFields(ctx, jiraIssueKey, fields)
+}
+
+// updateJiraIssueFields sets the given fields on a Jira issue in a single request
+func (s *IncidentJiraSync) updateJiraIssueFields(ctx context.Context, jiraIssueKey string, fields map[string]interface{}) error {
+	return s.updateJiraIssue(ctx, jiraIssueKey, jira.UpdateRequest{Fields: fields})
+}
+
+// updateJiraIssue sends an edit request for a Jira issue
+func (s *IncidentJiraSync) updateJiraIssue(ctx context.Context, jiraIssueKey string, update jira.UpdateRequest) error {
+	// Reconciliation sweeps only rewrite fields that drifted
+	if check := driftCheckFrom(ctx); check != nil && !s.writeNeeded(ctx, check, jiraIssueKey, update) {
+		return nil
+	}
+
+	// Mark the change before making it, so the resulting Jira webhook always finds the marker
+	if s.config.SyncMarkerEnabled && len(update.Fields) > 0 {
+		if err := s.writeSyncMarker(ctx, jiraIssueKey, update.Fields); err != nil {
+			log.Printf("Warning: failed to write sync marker on %s: %v", jiraIssueKey, err)
+		}
+	}
+
+	debugf(ctx, "editing %s: %s", jiraIssueKey, s.debugJSON(update))
+
+	// Backfills coalesce identical writes into bulk edits where they can
+	var err error
+	handled := false
+	if bulk := bulkWriterFrom(ctx); bulk != nil {
+		handled, err = bulk.write(ctx, jiraIssueKey, update)
+	}
+	if !handled {
+		err = s.jira.UpdateIssue(ctx, jiraIssueKey, update)
+		// Jira answers 204 even when it silently drops invalid option values
+		if err == nil && s.config.WriteVerification {
+			err = s.verifyWrite(ctx, jiraIssueKey, update)
+		}
+	}
+	fieldIDs := strings.Join(update.FieldIDs(), ", ")
+	event := streamEvent{Type: streamJiraWrite, IssueKey: jiraIssueKey, Field: fieldIDs, Outcome: "success", Message: fieldIDs}
+	if err != nil {
+		event.Outcome = "failed"
+		event.Message = fmt.Sprintf("%s: %v", event.Message, err)
+	}
+	debugf(ctx, "edit of %s %s: %s", jiraIssueKey, event.Outcome, event.Message)
+	s.stream.publish(event)
+	return err
+}
+
+// jiraFieldsUnchanged reports whether every field already holds exactly its given Assets objects
+func (s *IncidentJiraSync) jiraFieldsUnchanged(ctx context.Context, jiraIssueKey string, values map[string][]jira.ComponentValue) (bool, error) {
+	var issue struct {
+		Fields map[string][]jira.ComponentValue `json:"fields"`
+	}
+
+	fieldIDs := make([]string, 0, len(values))
+	for fieldID := range values {
+		fieldIDs = append(fieldIDs, fieldID)
+	}
+	sort.Strings(fieldIDs)
+
+	path := fmt.Sprintf("%s?fields=%s", jira.IssuePath(jiraIssueKey), strings.Join(fieldIDs, ","))
+	if err := s.jira.GetFresh(ctx, path, &issue); err != nil {
+		return false, err
+	}
+
+	for fieldID, fieldValues := range values {
+		if jira.ObjectIDSet(issue.Fields[fieldID]) != jira.ObjectIDSet(fieldValues) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// addJiraComment adds a plain-text comment to a Jira issue, mentioning the given accounts,
+// unless comments are disabled for the incident by feature flag
+func (s *IncidentJiraSync) addJiraComment(ctx context.Context, jiraIssueKey, text string, mentionAccountIDs ...string) error {
+	if !s.flagEnabled(ctx, flagComments) {
+		log.Printf("Comments disabled by feature flag, not commenting on %s", jiraIssueKey)
+		return nil
+	}
+	return s.jira.AddComment(ctx, jiraIssueKey, text, mentionAccountIDs...)
+}
+
+// processComponentField processes a component custom field and updates the corresponding Jira field
+func (s *IncidentJiraSync) processComponentField(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, jiraIssueKey string, fieldMapping mapping.FieldMapping) error {
+	var jiraValues []jira.ComponentValue
+	var catalogEntryIDs, valueEntryIDs []string
+	mappedObjects := make(map[string]bool)
+
+	for _, value := range customFieldEntry.Values {
+		if value.ValueCatalogEntry == nil {
+			continue
+		}
+
+		catalogEntry := value.ValueCatalogEntry
+		if catalogEntry.ID == "" {
+			continue
+		}
+		catalogEntryIDs = append(catalogEntryIDs, catalogEntry.ID)
+
+		// Get the object ID from the catalog entry's object key, or the mapping's catalog attribute
+		objects, err := s.resolveObjectIDs(ctx, jiraIssueKey, catalogEntry, fieldMapping)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Failed to resolve object ID for catalog entry %s: %v", catalogEntry.ID, err)
+			s.stream.publish(streamEvent{
+				Type:     streamMapping,
+				IssueKey: jiraIssueKey,
+				Field:    fieldMapping.IncidentFieldName,
+				Outcome:  "failed",
+				Message:  fmt.Sprintf("catalog entry %s: %v", catalogEntry.ID, err),
+			})
+			continue
+		}
+
+		for _, jiraValue := range objects {
+			objectID := jiraValue.ObjectID
+			// Entries can share objects
+			if mappedObjects[objectID] {
+				continue
+			}
+			mappedObjects[objectID] = true
+
+			jiraValues = append(jiraValues, jiraValue)
+			valueEntryIDs = append(valueEntryIDs, catalogEntry.ID)
+
+			log.Printf("Mapped %s -> %+v", s.redactor.redactString(catalogEntry.Name), jiraValue)
+			s.stream.publish(streamEvent{
+				Type:     streamMapping,
+				IssueKey: jiraIssueKey,
+				Field:    fieldMapping.IncidentFieldName,
+				Message:  fmt.Sprintf("%s -> object %s", s.redactor.redactString(catalogEntry.Name), objectID),
+			})
+		}
+	}
+
+	fieldIDs := fieldMapping.EnabledFieldIDs()
+	if len(fieldIDs) == 0 {
+		log.Printf("No enabled Jira fields for %s, skipping", fieldMapping.IncidentFieldName)
+		return nil
+	}
+
+	jiraValues, dropped, err := s.limitFieldValues(ctx, fieldMapping, jiraValues, valueEntryIDs)
+	if err != nil {
+		return err
+	}
+
+	if s.config.MergePolicy == mergePolicyMerge {
+		err = s.mergeJiraComponentValues(ctx, jiraIssueKey, fieldIDs, jiraValues, catalogEntryIDs, fieldMapping)
+	} else if len(jiraValues) > 0 {
+		// Update Jira field
+		err = s.updateJiraCustomField(ctx, jiraIssueKey, fieldIDs, jiraValues)
+
+		// If Jira rejects multiple values, fall back according to the mapping's policy
+		if len(jiraValues) > 1 && (errors.Is(err, jira.ErrValidation) || errors.Is(err, errWriteNotApplied)) {
+			err = s.handleRejectedMultipleValues(ctx, jiraIssueKey, fieldIDs, jiraValues, fieldMapping, err)
+		}
+	}
+
+	if err == nil && len(dropped) > 0 {
+		s.commentValueOverflow(ctx, jiraIssueKey, fieldMapping, dropped)
+	}
+	return err
+}
+
+// ProcessingResult records which mapped fields were synced while handling a webhook, which
+// were queued for retry because processing ran out of time, and which Jira rejected
+type ProcessingResult struct {
+	CompletedFields []string `json:"completed_fields"`
+	QueuedFields    []string `json:"queued_fields,omitempty"`
+	// FailedFields were rejected by Jira in a way retrying won't fix, and got a failure note
+	FailedFields []string `json:"failed_fields,omitempty"`
+	// RelatedIssues are the outcomes of the other Jira issues linked to the incident
+	RelatedIssues []IssueOutcome `json:"related_issues,omitempty"`
+}
+
+// processField syncs one incident custom field according to its mapping type
+func (s *IncidentJiraSync) processField(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, jiraIssueKey string, fieldMapping mapping.FieldMapping) error {
+	ctx = s.withJiraCredential(ctx, fieldMapping)
+	switch fieldMapping.Type {
+	case "", mapping.TypeAssets:
+		return s.processComponentField(ctx, customFieldEntry, jiraIssueKey, fieldMapping)
+	case mapping.TypeSprint:
+		return s.processSprintField(ctx, customFieldEntry, jiraIssueKey, fieldMapping)
+	case mapping.TypeSelect:
+		return s.processSelectField(ctx, customFieldEntry, jiraIssueKey, fieldMapping)
+	case mapping.TypeText:
+		return s.processTextField(ctx, customFieldEntry, jiraIssueKey, fieldMapping)
+	case mapping.TypeTimeTracking:
+		return s.processTimeTrackingField(ctx, customFieldEntry, jiraIssueKey, fieldMapping)
+	case mapping.TypeParent:
+		return s.processParentField(ctx, customFieldEntry, jiraIssueKey, fieldMapping)
+	case mapping.TypeDate:
+		return s.processDateField(ctx, customFieldEntry, jiraIssueKey, fieldMapping)
+	}
+	return fmt.Errorf("unknown mapping type: %s", fieldMapping.Type)
+}
+
+// processIncidentUpdate syncs an incident to its Jira issue and then to the other Jira issues
+// linked to it. A failure on the incident's own issue fails the event; failures on related
+// issues are reported in the result.
+func (s *IncidentJiraSync) processIncidentUpdate(ctx context.Context, incidentData incidentio.WebhookPayload) (ProcessingResult, error) {
+	// Extract the incident data based on event type
+	incident := incidentData.Incident
+
+	// Get Jira issue key
+	jiraIssueKey := incident.ExternalIssueReference.IssueName
+	if jiraIssueKey == "" && !s.config.CreateIssues {
+		s.trackIssueLink(incident.ID, jiraIssueKey)
+		return ProcessingResult{}, fmt.Errorf("no Jira issue found for incident")
+	}
+
+	// Issues of incidents on the skip list are curated by hand
+	if err := s.checkSkipList(ctx, incident.ID, incident.Reference); err != nil {
+		return ProcessingResult{}, err
+	}
+
+	// With CREATE_ISSUES, an incident without a Jira issue gets one from its creation template,
+	// which is then synced in full
+	created := false
+	if jiraIssueKey == "" {
+		var err error
+		if jiraIssueKey, created, err = s.createdIssue(ctx, incident); err != nil {
+			return ProcessingResult{}, err
+		}
+	}
+	newlyLinked := s.trackIssueLink(incident.ID, jiraIssueKey) || created
+
+	ctx = withFlagSubject(ctx, incident)
+	ctx = s.withIncidentDebug(ctx, incident.ID, incident.Reference)
+	s.recordMappingCoverage(incident)
+	s.startShadowEvaluation(ctx, incident, jiraIssueKey)
+
+	result, err := s.syncIssue(ctx, incidentData, incident, jiraIssueKey, newlyLinked)
+	if err != nil {
+		return result, err
+	}
+
+	for _, relatedKey := range s.relatedIssueKeys(ctx, incident, jiraIssueKey) {
+		result.RelatedIssues = append(result.RelatedIssues, s.syncRelatedIssue(ctx, incidentData, incident, relatedKey))
+	}
+	return result, nil
+}
+
+// syncIssue syncs the incident's mapped fields and incident-level attributes to one Jira issue.
+// A newly linked issue gets every mapped field rather than only those in the event.
+func (s *IncidentJiraSync) syncIssue(ctx context.Context, incidentData incidentio.WebhookPayload, incident incidentio.Incident, jiraIssueKey string, newlyLinked bool) (ProcessingResult, error) {
+	var result ProcessingResult
+
+	log.Printf("Processing incident update for Jira issue: %s", jiraIssueKey)
+
+	// Only one webhook (across replicas, when a Redis lock is configured) writes an issue at a time
+	unlock, err := s.locker.Lock(ctx, jiraIssueKey)
+	if err != nil {
+		return result, fmt.Errorf("failed to lock %s: %w", jiraIssueKey, err)
+	}
+	defer unlock()
+
+	// Issues behind a security level the service may not touch are left alone
+	if err := s.checkSecurityLevel(ctx, jiraIssueKey); err != nil {
+		return result, err
+	}
+
+	if s.config.MergePolicy == mergePolicyMerge {
+		s.recordRemovals(incidentData.PreviousState, incident, jiraIssueKey)
+	}
+
+	// A newly attached issue gets every mapped field, not just the ones in this event
+	initialSync := newlyLinked || s.config.InitialSyncEvents[incidentData.EventType]
+	if initialSync {
+		log.Printf("Running initial sync of incident %s to %s", incident.ID, jiraIssueKey)
+		fullIncident, err := s.incidentClient(ctx).GetIncident(ctx, incident.ID)
+		if err != nil {
+			log.Printf("Failed to fetch incident %s for initial sync, using event fields: %v", incident.ID, err)
+		} else {
+			incident.CustomFieldEntries = fullIncident.CustomFieldEntries
+		}
+
+		if err := s.syncDescription(ctx, incident, jiraIssueKey); err != nil {
+			log.Printf("Warning: failed to write description of %s: %v", jiraIssueKey, err)
+		}
+	}
+
+	// Process custom fields, in the order their mappings ask for
+	entries := s.orderFieldEntries(s.fieldsForEvent(incident.CustomFieldEntries, incidentData.EventType, initialSync))
+	for i, fieldEntry := range entries {
+		fieldName := fieldEntry.CustomField.Name
+
+		fieldMapping, found := s.resolveFieldMapping(fieldName)
+		if !found {
+			debugf(ctx, "field %q has no mapping, skipping it", fieldName)
+			continue
+		}
+		debugf(ctx, "field %q maps as %q to %s, values: %s", fieldName, fieldMapping.Type,
+			strings.Join(fieldMapping.EnabledFieldIDs(), ", "), s.debugJSON(fieldEntry.Values))
+
+		// Out of time: hand this field and everything after it to the retry queue
+		if ctx.Err() != nil {
+			result.QueuedFields = s.queueRemainingFields(ctx, incident, jiraIssueKey, entries[i:])
+			break
+		}
+
+		log.Printf("Processing %s field", fieldName)
+		if err := s.processField(ctx, fieldEntry, jiraIssueKey, fieldMapping); err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Processing timed out during %s", fieldName)
+				result.QueuedFields = s.queueRemainingFields(ctx, incident, jiraIssueKey, entries[i:])
+				break
+			}
+			// Jira dropped the value without an error; retry it later rather than fail the sync
+			if errors.Is(err, errWriteNotApplied) {
+				log.Printf("Write of %s was not applied: %v", fieldName, err)
+				result.QueuedFields = append(result.QueuedFields, s.queueRemainingFields(ctx, incident, jiraIssueKey, entries[i:i+1])...)
+				continue
+			}
+			// Jira won't accept this field however often it is redelivered; sync the rest
+			if jira.IsPermanent(err) {
+				log.Printf("Jira rejected %s for %s: %v", fieldName, jiraIssueKey, err)
+				s.notifySyncFailure(retryItem{
+					IncidentID:        incident.ID,
+					IncidentReference: incident.Reference,
+					Organization:      organizationName(ctx),
+					JiraIssueKey:      jiraIssueKey,
+					FieldEntry:        fieldEntry,
+					FieldMapping:      fieldMapping,
+					LastError:         err,
+				}, err.Error())
+				result.FailedFields = append(result.FailedFields, fieldName)
+				continue
+			}
+			log.Printf("Failed to process %s: %v", fieldName, err)
+			return result, err
+		}
+		result.CompletedFields = append(result.CompletedFields, fieldName)
+	}
+
+	// Out of time, or fields left for the retry queue: the incident-level attributes are synced
+	// with the next event rather than written over a partially updated issue
+	if ctx.Err() != nil || len(result.QueuedFields) > 0 {
+		log.Printf("Skipping incident-level attributes of %s: %d fields queued for retry", jiraIssueKey, len(result.QueuedFields))
+		return result, nil
+	}
+
+	// Sync incident-level attributes
+	if err := s.syncStatusCategory(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync status category: %v", err)
+		return result, err
+	}
+
+	if err := s.syncIncidentType(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync incident type: %v", err)
+		return result, err
+	}
+
+	if err := s.syncWatchers(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync watchers: %v", err)
+		return result, err
+	}
+
+	if err := s.syncSeverityChange(ctx, incidentData, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to comment severity change: %v", err)
+		return result, err
+	}
+
+	if err := s.syncSeverityRank(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync severity rank: %v", err)
+		return result, err
+	}
+
+	if err := s.syncSLAFields(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync SLA fields: %v", err)
+		return result, err
+	}
+
+	if err := s.syncResponderMetadata(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync responder metadata: %v", err)
+		return result, err
+	}
+
+	if err := s.syncDueDate(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync due date: %v", err)
+		return result, err
+	}
+
+	if err := s.syncIncidentLink(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync incident link: %v", err)
+		return result, err
+	}
+
+	if err := s.syncPostmortem(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync post-mortem: %v", err)
+		return result, err
+	}
+
+	if err := s.syncDismissal(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to sync dismissal: %v", err)
+		return result, err
+	}
+
+	if err := s.syncClosureSummary(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to attach closure summary: %v", err)
+		return result, err
+	}
+
+	if err := s.syncResolutionWorklog(ctx, incident, jiraIssueKey); err != nil {
+		log.Printf("Failed to log time in incident: %v", err)
+		return result, err
+	}
+
+	// Carry the incident context down to the tickets under a linked epic
+	if s.config.EpicRollupEnabled && s.flagEnabled(ctx, flagEpicRollup) {
+		if err := s.rollupEpic(ctx, incident, jiraIssueKey); err != nil {
+			log.Printf("Warning: failed to roll up %s to its child issues: %v", jiraIssueKey, err)
+		}
+	}
+
+	return result, nil
+}
+
+// queueRemainingFields queues every mapped field in entries for retry and returns their names
+func (s *IncidentJiraSync) queueRemainingFields(ctx context.Context, incident incidentio.Incident, jiraIssueKey string, entries []incidentio.CustomFieldEntry) []string {
+	var queued []string
+	for _, fieldEntry := range entries {
+		fieldMapping, found := s.resolveFieldMapping(fieldEntry.CustomField.Name)
+		if !found {
+			continue
+		}
+		s.enqueueRetry(retryItem{
+			IncidentID:        incident.ID,
+			IncidentReference: incident.Reference,
+			Organization:      organizationName(ctx),
+			JiraIssueKey:      jiraIssueKey,
+			FieldEntry:        fieldEntry,
+			FieldMapping:      fieldMapping,
+		})
+		queued = append(queued, fieldEntry.CustomField.Name)
+	}
+	log.Printf("Queued %d fields for retry on %s: %s", len(queued), jiraIssueKey, strings.Join(queued, ", "))
+	return queued
+}
+
+// HTTP handlers
+func (s *IncidentJiraSync) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	received := time.Now()
+
+	// Log webhook receipt for monitoring
+	log.Printf("Webhook received from %s", r.RemoteAddr)
+
+	// Parse the payload as it is read. The body is only kept when it is logged, or when the
+	// signature check has already read it; to be sampled, only its start is kept.
+	var body []byte
+	var bodyTruncated bool
+	var copied *sampleBuffer
+	var reader io.Reader = r.Body
+	if buffered, isBuffered := r.Body.(*bufferedBody); isBuffered {
+		body = buffered.data
+	} else if s.config.LogPayloads || s.debugger.any() {
+		copied = &sampleBuffer{}
+		reader = io.TeeReader(r.Body, copied)
+	} else if s.unknownEvents != nil {
+		copied = &sampleBuffer{limit: s.config.UnknownEventSampleBytes}
+		reader = io.TeeReader(r.Body, copied)
+	}
+	payload, err := incidentio.DecodeWebhookPayload(reader)
+	if copied != nil {
+		body, bodyTruncated = copied.Bytes(), copied.truncated
+	}
+	if s.config.LogPayloads {
+		log.Printf("Webhook payload: %s", s.redactor.redactJSON(body))
+	}
+
+	var readErr *bodyReadError
+	if errors.As(err, &readErr) {
+		rejectBody(w, r, endpointWebhook, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to decode JSON payload: %v", err)
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	for _, warning := range payload.Warnings {
+		log.Printf("Warning: skipped part of the %s payload: %s", payload.EventType, warning)
+	}
+	if !s.config.LogPayloads {
+		debugf(s.withIncidentDebug(r.Context(), payload.Incident.ID, payload.Incident.Reference),
+			"%s payload: %s", payload.EventType, s.redactor.redactJSON(body))
+	}
+
+	// Log event details for monitoring
+	log.Printf("Processing event type: %s", payload.EventType)
+	s.stream.publish(streamEvent{
+		Type:      streamWebhookReceived,
+		EventType: payload.EventType,
+		IssueKey:  payload.Incident.ExternalIssueReference.IssueName,
+		Message:   fmt.Sprintf("incident %s from %s", payload.Incident.ID, r.RemoteAddr),
+	})
+
+	// incident.io redelivers events it didn't see acknowledged, which may already be processed
+	deliveryID := r.Header.Get("webhook-id")
+	if s.deliveryProcessed(deliveryID) {
+		log.Printf("Ignoring redelivery %s of a processed %s event", deliveryID, payload.EventType)
+		webhookEventsIgnoredTotal.inc(s.eventTypeLabel(payload.EventType), "duplicate")
+		s.publishWebhookOutcome(payload, "ignored", "duplicate delivery "+deliveryID)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "duplicate"})
+		return
+	}
+
+	// Only process subscribed event types
+	if reason := s.eventIgnoreReason(payload.EventType); reason != "" {
+		log.Printf("Ignoring %s event type: %s", reason, payload.EventType)
+		webhookEventsIgnoredTotal.inc(s.eventTypeLabel(payload.EventType), reason)
+		if s.unknownEvents != nil {
+			sample := s.redactor.redactJSON(body)
+			if bodyTruncated {
+				sample = s.redactor.redactPartialJSON(body)
+			}
+			s.unknownEvents.record(payload.EventType, reason, sample, bodyTruncated)
+		}
+		s.publishWebhookOutcome(payload, "ignored", reason)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ignored"})
+		return
+	}
+
+	// Under load, wait for a processing slot behind more important events, or make way for them
+	priority := s.classifyEvent(payload)
+	release, err := s.admission.acquire(r.Context(), priority)
+	if err != nil {
+		log.Printf("Shedding %s event (%s priority): %v", payload.EventType, priorityName(priority), err)
+		eventsShedTotal.inc(payload.EventType, priorityName(priority))
+		webhookEventsTotal.inc(payload.EventType, "shed")
+		s.publishWebhookOutcome(payload, "shed", priorityName(priority)+" priority")
+		setRetryAfter(w, s.redeliveryDelay(1))
+		http.Error(w, "Overloaded, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	// Process the incident update
+	ctx, cancel := s.processingContext(r.Context())
+	defer cancel()
+
+	result, err := s.processIncidentUpdate(ctx, payload)
+	if errors.Is(err, errIncidentSkipped) {
+		webhookEventsIgnoredTotal.inc(payload.EventType, "skip_list")
+		s.publishWebhookOutcome(payload, "ignored", "incident on the skip list")
+		s.recordDelivery(deliveryID)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ignored"})
+		return
+	}
+	if errors.Is(err, errIssueRestricted) {
+		webhookEventsIgnoredTotal.inc(payload.EventType, "restricted")
+		s.publishWebhookOutcome(payload, "ignored", err.Error())
+		s.recordDelivery(deliveryID)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ignored"})
+		return
+	}
+	latency := time.Since(received)
+	s.recordWebhookLatency(payload.EventType, latency)
+	if err != nil {
+		webhookEventsTotal.inc(payload.EventType, "failed")
+		s.publishProcessedWebhook(payload, "failed", err.Error(), latency)
+		log.Printf("Failed to process incident update: %v", err)
+		setRetryAfter(w, s.redeliveryDelay(s.redeliveries.fail(payload.Incident.ID)))
+		http.Error(w, "Processing failed", http.StatusInternalServerError)
+		return
+	}
+
+	s.redeliveries.succeed(payload.Incident.ID)
+	if incomplete := result.incomplete(); incomplete != "" {
+		s.recordDelivery(deliveryID)
+		webhookEventsTotal.inc(payload.EventType, "partial")
+		s.publishProcessedWebhook(payload, "partial", incomplete, latency)
+		log.Printf("Partially processed incident update, %s", incomplete)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":           "partial",
+			"completed_fields": result.CompletedFields,
+			"queued_fields":    result.QueuedFields,
+			"failed_fields":    result.FailedFields,
+			"related_issues":   result.RelatedIssues,
+		})
+		return
+	}
+
+	s.recordDelivery(deliveryID)
+	webhookEventsTotal.inc(payload.EventType, "success")
+	s.publishProcessedWebhook(payload, "success", fmt.Sprintf("synced: %s", strings.Join(result.CompletedFields, ", ")), latency)
+	log.Printf("Successfully processed incident update")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// healthHandler reports the service healthy, or degraded while the last configuration check
+// found problems. Both answer 200, as restarting doesn't fix the configuration. A draining
+// service answers 503, so load balancers stop sending it webhooks.
+func (s *IncidentJiraSync) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "draining"})
+		return
+	}
+
+	warnings, checkedAt := s.lint.snapshot()
+	w.WriteHeader(http.StatusOK)
+	if len(warnings) == 0 {
+		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "degraded",
+		"warnings":   warnings,
+		"checked_at": checkedAt,
+	})
+}
+
+// Handler returns the HTTP routes of the service. The admin API is included unless it has a
+// listener of its own (ADMIN_LISTEN_ADDR).
+func (s *IncidentJiraSync) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", s.refuseWhileDraining(normalizeBody(endpointWebhook, s.requireAuth(endpointWebhook, s.webhookHandler))))
+	for name, organization := range s.config.IncidentOrganizations {
+		handler := s.organizationWebhookHandler(name)
+		if auth, configured := s.organizationWebhookAuth(organization); configured {
+			handler = s.authenticate(endpointWebhook, auth, handler)
+		}
+		mux.HandleFunc(s.organizations[name].webhookURL, s.refuseWhileDraining(normalizeBody(endpointWebhook, handler)))
+	}
+	mux.HandleFunc("/health", s.healthHandler)
+	s.registerMetricsRoute(mux)
+	if s.config.SyncMarkerEnabled {
+		mux.HandleFunc("/jira-webhook", s.refuseWhileDraining(normalizeBody(endpointJiraWebhook, s.requireAuth(endpointJiraWebhook, s.jiraWebhookHandler))))
+	}
+	if len(s.config.AdminListenAddresses) == 0 {
+		s.registerAdminRoutes(mux)
+	}
+	return mux
+}
+
+// registerMetricsRoute serves /metrics for Prometheus unless METRICS_BACKEND leaves it out
+func (s *IncidentJiraSync) registerMetricsRoute(mux *http.ServeMux) {
+	if s.config.MetricsBackends[metricsPrometheus] {
+		mux.HandleFunc("/metrics", s.requireAuth(endpointMetrics, metricsHandler))
+	}
+}
+
+// AdminHandler returns the routes served on the separate admin listener: the admin API,
+// health and metrics
+func (s *IncidentJiraSync) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.healthHandler)
+	s.registerMetricsRoute(mux)
+	s.registerAdminRoutes(mux)
+	return mux
+}
+
+// Run registers the incident.io webhook endpoint if enabled, starts the retry worker and outbox dispatcher and serves the HTTP routes until shutdown
+func (s *IncidentJiraSync) Run() error {
+	if s.config.WebhookAutoRegister {
+		for _, organization := range s.allOrganizations() {
+			ctx, cancel := context.WithTimeout(context.Background(), s.config.ProcessingTimeout)
+			if err := s.registerWebhookEndpoint(ctx, organization); err != nil {
+				log.Printf("Warning: failed to register the incident.io webhook endpoint of organization %s: %v", organization.label(), err)
+			}
+			cancel()
+		}
+	}
+
+	defer s.store.Close()
+
+	go s.runRetryWorker()
+	go s.replaySavedRetries()
+	go s.runOutboxDispatcher()
+	if s.config.ReconcileInterval > 0 {
+		go s.runReconciler()
+	}
+	if s.config.ConfigLintInterval > 0 {
+		go s.runConfigLinter()
+	}
+	// Only the Postgres store is shared with other replicas that may import rules
+	if s.config.StateStore == storePostgres && s.config.MappingRulesSyncInterval > 0 {
+		go s.runMappingRulesSync()
+	}
+	for _, organization := range s.allOrganizations() {
+		if organization.catalog == nil {
+			continue
+		}
+		go s.runCatalogWarmer(organization)
+		if s.config.CatalogChangePollInterval > 0 {
+			go s.runCatalogChangePoller(organization)
+		}
+	}
+
+	var accessLog *accessLogger
+	if s.config.AccessLog != "" {
+		var err error
+		if accessLog, err = newAccessLogger(s.config.AccessLog, s.config.AccessLogSampleRates); err != nil {
+			return err
+		}
+	}
+
+	targets := []listenTarget{{name: "webhooks", addresses: s.config.ListenAddresses, handler: accessLog.wrap("webhooks", s.Handler()), inherit: true}}
+	if len(s.config.AdminListenAddresses) > 0 {
+		targets = append(targets, listenTarget{name: "admin API", addresses: s.config.AdminListenAddresses, handler: accessLog.wrap("admin", s.AdminHandler())})
+	}
+	return runServer(s.config, targets, signalHooks{reload: s.reloadConfig, dump: s.dumpState, live: s.checkLive})
+}
@@ -0,0 +1,19 @@
+package server
+
+import "testing"
+
+func TestEventTypeLabel(t *testing.T) {
+	s := &IncidentJiraSync{config: Config{Events: map[string]bool{"public_incident.incident_created_v2": true}}}
+	tests := map[string]string{
+		"public_incident.incident_created_v2":  "public_incident.incident_created_v2",
+		"public_incident.incident_updated_v2":  "public_incident.incident_updated_v2",
+		"public_incident.incident_updated_v97": "other",
+		"attacker.chosen_name_1234":            "other",
+		"":                                     "other",
+	}
+	for eventType, want := range tests {
+		if got := s.eventTypeLabel(eventType); got != want {
+			t.Errorf("eventTypeLabel(%q) = %q, want %q", eventType, got, want)
+		}
+	}
+}
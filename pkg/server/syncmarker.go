@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// syncMarkerSource identifies this service in the last-synced-by issue property
+const syncMarkerSource = "incident-jira-webhook"
+
+// SyncMarker is stored as a Jira issue property after every write so the Jira webhook
+// receiver can recognise the resulting issue_updated events as our own. Fingerprints holds
+// the fingerprint of the value last written to each field.
+type SyncMarker struct {
+	SyncedBy     string            `json:"syncedBy"`
+	Fingerprints map[string]string `json:"fingerprints"`
+	SyncedAt     time.Time         `json:"syncedAt"`
+}
+
+// fieldFingerprint hashes a field value in a form that is stable between what we write and
+// what Jira returns: objects reduce to their objectId, value, id or name and lists are sorted
+func fieldFingerprint(value interface{}) string {
+	hash := sha256.Sum256([]byte(canonicalFieldValue(value)))
+	return hex.EncodeToString(hash[:])
+}
+
+func canonicalFieldValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, canonicalFieldValue(item))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		for _, key := range []string{"objectId", "value", "id", "name"} {
+			if identity, exists := v[key]; exists {
+				return canonicalFieldValue(identity)
+			}
+		}
+		return ""
+	case float64:
+		return fmt.Sprintf("%g", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// normalizeFields round-trips fields through JSON so typed values fingerprint like decoded ones
+func normalizeFields(fields map[string]interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(encoded, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// writeSyncMarker records the fingerprints of the fields about to be written to an issue
+func (s *IncidentJiraSync) writeSyncMarker(ctx context.Context, jiraIssueKey string, fields map[string]interface{}) error {
+	if !s.flagEnabled(ctx, flagBidirectional) {
+		return nil
+	}
+
+	normalized, err := normalizeFields(fields)
+	if err != nil {
+		return fmt.Errorf("failed to normalize fields: %w", err)
+	}
+
+	// Merge with the existing marker so fields written by earlier requests stay recognisable
+	marker, err := s.readSyncMarker(ctx, jiraIssueKey)
+	if err != nil || marker == nil {
+		marker = &SyncMarker{SyncedBy: syncMarkerSource, Fingerprints: make(map[string]string)}
+	}
+	for fieldID, value := range normalized {
+		marker.Fingerprints[fieldID] = fieldFingerprint(value)
+	}
+	marker.SyncedAt = time.Now().UTC()
+
+	return s.jira.SetIssueProperty(ctx, jiraIssueKey, s.config.SyncMarkerPropertyKey, marker)
+}
+
+// readSyncMarker returns the last-synced-by property of an issue, or nil when it has none
+func (s *IncidentJiraSync) readSyncMarker(ctx context.Context, jiraIssueKey string) (*SyncMarker, error) {
+	var property struct {
+		Value SyncMarker `json:"value"`
+	}
+	if err := s.jira.GetIssueProperty(ctx, jiraIssueKey, s.config.SyncMarkerPropertyKey, &property); err != nil {
+		return nil, err
+	}
+	if property.Value.SyncedBy != syncMarkerSource || property.Value.Fingerprints == nil {
+		return nil, nil
+	}
+	return &property.Value, nil
+}
+
+// isOwnChange reports whether a Jira issue_updated event only touched fields this service
+// wrote, and those fields now hold exactly the values it wrote
+func (s *IncidentJiraSync) isOwnChange(ctx context.Context, event jira.WebhookEvent) (bool, error) {
+	marker, err := s.readSyncMarker(ctx, event.Issue.Key)
+	if err != nil || marker == nil {
+		return false, err
+	}
+
+	if len(event.Changelog.Items) == 0 {
+		return false, nil
+	}
+
+	for _, item := range event.Changelog.Items {
+		fingerprint, synced := marker.Fingerprints[item.FieldID]
+		if !synced {
+			return false, nil
+		}
+
+		var value interface{}
+		if raw, exists := event.Issue.Fields[item.FieldID]; exists {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return false, fmt.Errorf("failed to decode field %s: %w", item.FieldID, err)
+			}
+		}
+		if fieldFingerprint(value) != fingerprint {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// jiraWebhookHandler receives Jira issue webhooks and drops the echoes of our own writes
+func (s *IncidentJiraSync) jiraWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var event jira.WebhookEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		var readErr *bodyReadError
+		if errors.As(err, &readErr) {
+			rejectBody(w, r, endpointJiraWebhook, err)
+			return
+		}
+		log.Printf("Failed to decode Jira webhook payload: %v", err)
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	if event.WebhookEvent != "jira:issue_updated" || event.Issue.Key == "" {
+		jiraWebhookEventsTotal.inc(event.WebhookEvent, "ignored")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ignored"})
+		return
+	}
+
+	ctx, cancel := s.processingContext(r.Context())
+	defer cancel()
+
+	own, err := s.isOwnChange(ctx, event)
+	if err != nil {
+		log.Printf("Failed to check sync marker on %s, treating change as external: %v", event.Issue.Key, err)
+	}
+	if own {
+		log.Printf("Skipping Jira change to %s: fingerprint matches our last sync", event.Issue.Key)
+		jiraWebhookEventsTotal.inc(event.WebhookEvent, "loop_skipped")
+		json.NewEncoder(w).Encode(map[string]string{"status": "skipped"})
+		return
+	}
+
+	log.Printf("External Jira change to %s by %s", event.Issue.Key, s.redactor.redactString(event.User.DisplayName))
+	jiraWebhookEventsTotal.inc(event.WebhookEvent, "accepted")
+	json.NewEncoder(w).Encode(map[string]string{"status": "accepted"})
+}
+
+var jiraWebhookEventsTotal = newCounterVec(
+	"incident_jira_webhook_jira_events_total",
+	"Jira webhook events received, by event and outcome (accepted, loop_skipped, ignored).",
+	"event", "outcome")
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// Templates for text written to Jira
+const (
+	templatePostmortemComment = "postmortem_comment"
+	templateMultiValueComment = "multi_value_comment"
+	templateDescription       = "description"
+	templateClosureSummary    = "closure_summary"
+	templateSeverityComment   = "severity_comment"
+	templateOverflowComment   = "overflow_comment"
+	templateDismissalComment  = "dismissal_comment"
+	templateWorklogComment    = "worklog_comment"
+)
+
+// defaultTemplates are used when TEMPLATES_DIR has no matching template. The description has
+// no default, so it is only written when a template for it is provided.
+var defaultTemplates = map[string]string{
+	templatePostmortemComment: "The post-mortem for this incident has been published: {{.PostmortemURL}}",
+	templateSeverityComment:   `Severity {{with .PreviousSeverity}}changed from {{.}} {{else}}set {{end}}to {{.Incident.Severity.Name}}.`,
+	templateDismissalComment:  `The incident was {{.Incident.IncidentStatus.Category}} in incident.io, so this issue is being closed.{{with .Incident.Permalink}} {{.}}{{end}}`,
+	templateOverflowComment:   `{{.Field}} takes at most {{.MaxValues}} values, so objects {{join .Dropped ", "}} were not synced.`,
+	templateWorklogComment:    `Time in incident {{with .Incident.Reference}}{{.}}{{else}}{{.Incident.ID}}{{end}}, from reported to resolved: {{.Duration}}.`,
+	templateMultiValueComment: `Jira rejected multiple values for {{.Field}}, so only object {{.Kept}} was set. Not synced: objects {{join .Dropped ", "}}.`,
+	templateClosureSummary: `# {{with .Incident.Reference}}{{.}}: {{end}}{{.Incident.Name}}
+
+- Status: {{.Incident.IncidentStatus.Name}}
+{{with .Incident.Severity}}- Severity: {{.Name}}
+{{end}}{{with .Incident.IncidentType}}- Type: {{.Name}}
+{{end}}{{with .Incident.Permalink}}- incident.io: {{.}}
+{{end}}- Jira issue: {{.IssueKey}}
+- Summary generated: {{timestamp .GeneratedAt}}
+{{with .Incident.Summary}}
+## Summary
+
+{{.}}
+{{end}}
+## Key fields
+{{range .Incident.CustomFieldEntries}}{{if .Values}}
+- {{.CustomField.Name}}: {{values .Values}}{{end}}{{end}}
+
+## Participants
+{{range .Incident.RoleAssignments}}{{if .Assignee}}
+- {{.Role.Name}}: {{.Assignee.Name}}{{end}}{{end}}
+
+## Timestamps
+{{range .Incident.TimestampValues}}{{if .Value}}
+- {{.IncidentTimestamp.Name}}: {{timestamp .Value.Value}}{{end}}{{end}}
+
+## Updates
+{{range .Updates}}
+- {{timestamp .CreatedAt}}{{with .NewIncidentStatus}} [{{.Name}}]{{end}}{{with .Updater.User}} {{.Name}}{{end}}: {{.Message}}{{end}}
+`,
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"values": func(values []incidentio.Value) string {
+		texts := make([]string, 0, len(values))
+		for _, value := range values {
+			texts = append(texts, value.Text())
+		}
+		return strings.Join(texts, ", ")
+	},
+	"timestamp": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	},
+}
+
+// templateData is what templates are rendered with
+type templateData struct {
+	Incident      incidentio.Incident
+	IssueKey      string
+	Field         string
+	Kept          string
+	Dropped       []string
+	PostmortemURL string
+	Updates       []incidentio.IncidentUpdate
+	GeneratedAt   time.Time
+	// PreviousSeverity is the severity before a change, if known
+	PreviousSeverity string
+	// MaxValues is the value limit of the field, for overflow comments
+	MaxValues int
+	// Duration is the time in the incident, for worklog comments
+	Duration time.Duration
+}
+
+// loadTemplates parses every *.tmpl file in dir, keyed by file name without the extension,
+// e.g. "postmortem_comment.security.de"
+func loadTemplates(dir string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	if dir == "" {
+		return templates, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		tmpl, err := template.New(name).Funcs(templateFuncs).Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", filepath.Base(path), err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// templateSlug turns an incident type name into the form used in template file names
+func templateSlug(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), "-"))
+}
+
+// templateLanguage returns the language for an incident: the language configured for its
+// incident type, or TEMPLATE_LANGUAGE
+func (s *IncidentJiraSync) templateLanguage(incident incidentio.Incident) string {
+	if incident.IncidentType != nil {
+		for incidentType, language := range s.config.TemplateLanguageByIncidentType {
+			if strings.EqualFold(incidentType, incident.IncidentType.Name) {
+				return language
+			}
+		}
+	}
+	return s.config.TemplateLanguage
+}
+
+// findTemplate returns the most specific template for the incident in ctx, trying
+// name.<incident type>.<language>, name.<incident type>, name.<language> and name in turn
+func (s *IncidentJiraSync) findTemplate(ctx context.Context, name string) (*template.Template, error) {
+	var candidates []string
+	incident, hasSubject := ctx.Value(flagSubjectKey{}).(incidentio.Incident)
+	language := s.config.TemplateLanguage
+	if hasSubject {
+		language = s.templateLanguage(incident)
+		if incident.IncidentType != nil {
+			incidentType := templateSlug(incident.IncidentType.Name)
+			candidates = append(candidates, name+"."+incidentType+"."+language, name+"."+incidentType)
+		}
+	}
+	candidates = append(candidates, name+"."+language, name)
+
+	for _, candidate := range candidates {
+		if tmpl, exists := s.settings().Templates[candidate]; exists {
+			return tmpl, nil
+		}
+	}
+
+	text, exists := defaultTemplates[name]
+	if !exists {
+		return nil, nil
+	}
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+// renderTemplate renders the named template for the incident in ctx. ok is false when there is
+// no template, which only happens for templates without a default.
+func (s *IncidentJiraSync) renderTemplate(ctx context.Context, name string, data templateData) (text string, ok bool, err error) {
+	if incident, hasSubject := ctx.Value(flagSubjectKey{}).(incidentio.Incident); hasSubject && data.Incident.ID == "" {
+		data.Incident = incident
+	}
+
+	tmpl, err := s.findTemplate(ctx, name)
+	if err != nil || tmpl == nil {
+		return "", false, err
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", false, fmt.Errorf("failed to render %s: %w", tmpl.Name(), err)
+	}
+	return strings.TrimSpace(rendered.String()), true, nil
+}
+
+// syncDescription writes the issue description from the description template, once per incident
+// and issue, when the issue is first synced
+func (s *IncidentJiraSync) syncDescription(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	description, ok, err := s.renderTemplate(ctx, templateDescription, templateData{Incident: incident, IssueKey: jiraIssueKey})
+	if err != nil || !ok {
+		return err
+	}
+
+	if !s.lastWritten.changed(jiraIssueKey, "description", description) {
+		return nil
+	}
+
+	log.Printf("Writing description of %s from template", jiraIssueKey)
+	fields := map[string]interface{}{"description": jira.PlainTextDocument(description)}
+	if err := s.updateJiraIssueFields(ctx, jiraIssueKey, fields); err != nil {
+		return err
+	}
+
+	s.lastWritten.record(jiraIssueKey, "description", description)
+	return nil
+}
+
+// addTemplatedComment renders the named comment template and adds it to the issue, mentioning
+// the given accounts
+func (s *IncidentJiraSync) addTemplatedComment(ctx context.Context, jiraIssueKey, name string, data templateData, mentionAccountIDs ...string) error {
+	data.IssueKey = jiraIssueKey
+	comment, ok, err := s.renderTemplate(ctx, name, data)
+	if err != nil || !ok || comment == "" {
+		return err
+	}
+	return s.addJiraComment(ctx, jiraIssueKey, comment, mentionAccountIDs...)
+}
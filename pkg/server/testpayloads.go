@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// maxTestPayloadValues bounds the values generated for each multi-value field
+const maxTestPayloadValues = 10
+
+// testPayloadRequest asks for a sample webhook delivery
+type testPayloadRequest struct {
+	// IssueKey is the Jira issue the sample incident is attached to
+	IssueKey  string `json:"issue_key"`
+	EventType string `json:"event_type,omitempty"`
+	Severity  string `json:"severity,omitempty"`
+	// Values is the number of values generated for multi-value fields (1 by default)
+	Values int `json:"values,omitempty"`
+	// Fields restricts the sample to these incident fields
+	Fields []string `json:"fields,omitempty"`
+	// URL is where the delivery is meant to be sent, for the curl command
+	URL string `json:"url,omitempty"`
+	// Organization names the INCIDENT_ORGANIZATIONS organization the delivery is from
+	Organization string `json:"organization,omitempty"`
+}
+
+// sampleField is an incident field filled in by the generator
+type sampleField struct {
+	Field  string   `json:"field"`
+	Type   string   `json:"type"`
+	Values []string `json:"values"`
+}
+
+// skippedSampleField is a mapped incident field the generator could not fill in
+type skippedSampleField struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// testPayload is a sample incident.io delivery, signed like incident.io signs them
+type testPayload struct {
+	URL     string               `json:"url"`
+	Signed  bool                 `json:"signed"`
+	Expires *time.Time           `json:"expires_at,omitempty"`
+	Headers map[string]string    `json:"headers"`
+	Payload json.RawMessage      `json:"payload"`
+	Fields  []sampleField        `json:"fields"`
+	Skipped []skippedSampleField `json:"skipped,omitempty"`
+	Curl    string               `json:"curl"`
+}
+
+// sampleFieldValues returns values for a mapped incident field: real catalog entries or options
+// of the field, or made-up text and numbers
+func (s *IncidentJiraSync) sampleFieldValues(ctx context.Context, field incidentio.CustomField, fieldMapping mapping.FieldMapping, count int) ([]incidentio.Value, error) {
+	switch {
+	case field.CatalogTypeID != "":
+		entries, err := s.incidentClient(ctx).ListCatalogEntries(ctx, field.CatalogTypeID, count)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]incidentio.Value, 0, len(entries))
+		for i := range entries {
+			values = append(values, incidentio.Value{ValueCatalogEntry: &entries[i]})
+		}
+		return values, nil
+	case strings.HasSuffix(field.FieldType, "_select"):
+		options, err := s.incidentClient(ctx).ListCustomFieldOptions(ctx, field.ID)
+		if err != nil {
+			return nil, err
+		}
+		if field.FieldType == "single_select" {
+			count = 1
+		}
+		if len(options) < count {
+			count = len(options)
+		}
+		values := make([]incidentio.Value, 0, count)
+		for i := range options[:count] {
+			values = append(values, incidentio.Value{ValueOption: &options[i]})
+		}
+		return values, nil
+	case field.FieldType == "numeric" || fieldMapping.Type == mapping.TypeTimeTracking:
+		return []incidentio.Value{{ValueNumeric: "2"}}, nil
+	case fieldMapping.Type == mapping.TypeDate:
+		return []incidentio.Value{{ValueText: time.Now().UTC().Truncate(time.Second).Format(time.RFC3339)}}, nil
+	case field.FieldType == "link":
+		return []incidentio.Value{{ValueText: "https://example.com/" + strings.ToLower(strings.Join(strings.Fields(field.Name), "-"))}}, nil
+	}
+	return []incidentio.Value{{ValueText: "Sample " + field.Name}}, nil
+}
+
+// signTestPayload signs a body the way incident.io signs deliveries, returning the headers
+func signTestPayload(body []byte, secret string, now time.Time) (map[string]string, error) {
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	id := "msg_test_" + hex.EncodeToString(idBytes)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	signature, err := webhookSignature(id, timestamp, body, secret)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"webhook-id":        id,
+		"webhook-timestamp": timestamp,
+		"webhook-signature": "v1," + base64.StdEncoding.EncodeToString(signature),
+	}, nil
+}
+
+// adminTestPayloadHandler generates a realistic incident.io delivery for the configured
+// mappings, using the organisation's real custom fields and catalog entries, signed with the
+// webhook secret so it can be sent to a staging deployment as is
+func (s *IncidentJiraSync) adminTestPayloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request testPayloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	request.IssueKey = strings.TrimSpace(request.IssueKey)
+	if request.IssueKey == "" {
+		http.Error(w, "issue_key is required", http.StatusBadRequest)
+		return
+	}
+	if request.EventType == "" {
+		request.EventType = "public_incident.incident_updated_v2"
+	}
+	if request.Values == 0 {
+		request.Values = 1
+	}
+	if request.Values < 1 || request.Values > maxTestPayloadValues {
+		http.Error(w, fmt.Sprintf("values must be between 1 and %d", maxTestPayloadValues), http.StatusBadRequest)
+		return
+	}
+	wanted := make(map[string]bool, len(request.Fields))
+	for _, field := range request.Fields {
+		wanted[strings.ToLower(field)] = true
+	}
+
+	organization, err := s.lookupOrganization(request.Organization)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := withOrganization(r.Context(), organization.name)
+
+	customFields, err := organization.client.ListCustomFields(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	sort.Slice(customFields, func(i, j int) bool { return customFields[i].Name < customFields[j].Name })
+
+	now := time.Now().UTC()
+	incident := incidentio.Incident{
+		ID:                     "01TEST" + strconv.FormatInt(now.UnixNano(), 36),
+		Name:                   "Sample incident for webhook testing",
+		Reference:              "INC-TEST",
+		ExternalIssueReference: incidentio.ExternalIssueReference{Provider: "jira", IssueName: request.IssueKey},
+		IncidentStatus:         incidentio.IncidentStatus{Name: "Investigating", Category: "live"},
+		CreatedAt:              now,
+	}
+	if request.Severity != "" {
+		incident.Severity = &incidentio.Severity{Name: request.Severity}
+	}
+
+	delivery := testPayload{Fields: []sampleField{}, Headers: map[string]string{"Content-Type": "application/json"}}
+	for _, field := range customFields {
+		if len(wanted) > 0 && !wanted[strings.ToLower(field.Name)] {
+			continue
+		}
+		fieldMapping, found := s.resolveFieldMapping(field.Name)
+		if !found || len(fieldMapping.EnabledFieldIDs()) == 0 {
+			continue
+		}
+
+		values, err := s.sampleFieldValues(ctx, field, fieldMapping, request.Values)
+		if err == nil && len(values) == 0 {
+			err = errors.New("no catalog entries or options to pick from")
+		}
+		if err != nil {
+			delivery.Skipped = append(delivery.Skipped, skippedSampleField{Field: field.Name, Reason: err.Error()})
+			continue
+		}
+
+		sample := sampleField{Field: field.Name, Type: fieldMapping.Type, Values: make([]string, 0, len(values))}
+		if sample.Type == "" {
+			sample.Type = mapping.TypeAssets
+		}
+		for _, value := range values {
+			sample.Values = append(sample.Values, value.Text())
+		}
+		delivery.Fields = append(delivery.Fields, sample)
+		incident.CustomFieldEntries = append(incident.CustomFieldEntries, incidentio.CustomFieldEntry{CustomField: field, Values: values})
+	}
+
+	incidentKey := "incident"
+	if strings.HasPrefix(request.EventType, "public_incident.") {
+		incidentKey = request.EventType
+	}
+	body, err := json.Marshal(map[string]interface{}{"event_type": request.EventType, incidentKey: incident})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	delivery.Payload = body
+
+	if auth, configured := s.organizationWebhookAuth(s.config.IncidentOrganizations[organization.name]); configured && auth.HMACSecret != "" {
+		headers, err := signTestPayload(body, auth.HMACSecret, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for name, value := range headers {
+			delivery.Headers[name] = value
+		}
+		expires := now.Add(webhookSignatureTolerance)
+		delivery.Signed, delivery.Expires = true, &expires
+	}
+
+	delivery.URL = request.URL
+	if delivery.URL == "" {
+		delivery.URL = strings.TrimRight(s.config.PublicURL, "/") + organization.webhookURL
+	}
+	curl := []string{"curl -X POST " + shellQuote(delivery.URL)}
+	headerNames := make([]string, 0, len(delivery.Headers))
+	for name := range delivery.Headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		curl = append(curl, "-H "+shellQuote(name+": "+delivery.Headers[name]))
+	}
+	curl = append(curl, "--data-raw "+shellQuote(string(body)))
+	delivery.Curl = strings.Join(curl, " ")
+
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// maxSingleLineTextLength is the most characters Jira takes in a single-line text field
+const maxSingleLineTextLength = 255
+
+// processTextField writes the text of an incident field's first value (or, for catalog entries,
+// of the mapping's catalog attribute), after the mapping's transform, to Jira text or URL
+// fields. Mappings with a join separator write every value, joined. A field without a value
+// clears the Jira fields.
+func (s *IncidentJiraSync) processTextField(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, jiraIssueKey string, fieldMapping mapping.FieldMapping) error {
+	fieldIDs := fieldMapping.EnabledFieldIDs()
+	if len(fieldIDs) == 0 {
+		return nil
+	}
+
+	text, err := s.textFieldValue(ctx, customFieldEntry, fieldMapping)
+	if err != nil {
+		return err
+	}
+
+	editMeta, err := s.jira.EditMeta(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{})
+	for _, fieldID := range fieldIDs {
+		meta, editable := editMeta[fieldID]
+		if !editable {
+			return fmt.Errorf("field %s is not editable on %s", fieldID, jiraIssueKey)
+		}
+
+		switch {
+		case text == "":
+			fields[fieldID] = nil
+		case strings.HasSuffix(meta.Schema.Custom, ":textarea"):
+			// Multi-line text fields take Atlassian Document Format
+			fields[fieldID] = jira.PlainTextDocument(text)
+		case strings.HasSuffix(meta.Schema.Custom, ":textfield") && utf8.RuneCountInString(text) > maxSingleLineTextLength:
+			log.Printf("Warning: %s is longer than the %d characters %s takes, writing the start of it", fieldMapping.IncidentFieldName, maxSingleLineTextLength, fieldID)
+			fields[fieldID] = string([]rune(text)[:maxSingleLineTextLength-1]) + "…"
+		default:
+			fields[fieldID] = text
+		}
+	}
+	log.Printf("Mapped %s -> %s", fieldMapping.IncidentFieldName, strings.Join(fieldIDs, ", "))
+
+	return s.updateJiraIssueFields(ctx, jiraIssueKey, fields)
+}
+
+// textFieldValue returns the text a text mapping writes: the field's first value or, for
+// mappings with a join separator, every value rendered with the value template and joined
+func (s *IncidentJiraSync) textFieldValue(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, fieldMapping mapping.FieldMapping) (string, error) {
+	var texts []string
+	var err error
+	if fieldMapping.HasValueTemplate() {
+		texts, err = s.renderValueTemplates(ctx, customFieldEntry, fieldMapping)
+	} else {
+		texts, err = s.selectTexts(ctx, customFieldEntry, fieldMapping)
+	}
+	if err != nil || len(texts) == 0 {
+		return "", err
+	}
+
+	if fieldMapping.Joins() {
+		return strings.Join(texts, fieldMapping.JoinSeparator), nil
+	}
+	if len(texts) > 1 {
+		log.Printf("Warning: %s has %d values, writing only the first to text fields", fieldMapping.IncidentFieldName, len(texts))
+	}
+	return texts[0], nil
+}
+
+// renderValueTemplates renders the mapping's value template for each value of an incident
+// field, with the attributes of its catalog entry. Values rendering blank are dropped.
+func (s *IncidentJiraSync) renderValueTemplates(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, fieldMapping mapping.FieldMapping) ([]string, error) {
+	incident, _ := ctx.Value(flagSubjectKey{}).(incidentio.Incident)
+
+	var texts []string
+	for _, value := range customFieldEntry.Values {
+		input := mapping.ValueTemplateInput{
+			Value:      strings.TrimSpace(value.Text()),
+			Attributes: map[string]string{},
+			Field:      fieldMapping.IncidentFieldName,
+			Incident:   incident,
+		}
+		if value.ValueCatalogEntry != nil {
+			catalogEntry, err := s.catalogEntry(ctx, value.ValueCatalogEntry.ID)
+			if err != nil {
+				return nil, err
+			}
+			input.Attributes = catalogEntry.Attributes()
+			if fieldMapping.CatalogAttribute != "" {
+				text, err := s.resolveCatalogAttribute(ctx, value.ValueCatalogEntry.ID, fieldMapping.CatalogAttribute)
+				if err != nil {
+					return nil, err
+				}
+				input.Value = strings.TrimSpace(text)
+			}
+		}
+
+		text, err := fieldMapping.RenderValue(input, s.config.TransformTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return texts, nil
+}
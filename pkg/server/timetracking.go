@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+// timeTrackingFieldID is the Jira system field holding an issue's estimates
+const timeTrackingFieldID = "timetracking"
+
+// parseEstimate reads a duration in Jira's notation ("1w 2d 3h 30m", "1.5h") into minutes.
+// A bare number is in defaultUnit. Days and weeks are converted with hoursPerDay and
+// daysPerWeek, as Jira does with its time tracking settings.
+func parseEstimate(text, defaultUnit string, hoursPerDay, daysPerWeek int) (int, error) {
+	unitMinutes := map[string]float64{
+		"m": 1,
+		"h": 60,
+		"d": float64(60 * hoursPerDay),
+		"w": float64(60 * hoursPerDay * daysPerWeek),
+	}
+
+	parts := strings.Fields(strings.ToLower(text))
+	if len(parts) == 0 {
+		return 0, fmt.Errorf("empty estimate")
+	}
+	var minutes float64
+	for _, part := range parts {
+		number, unit := part, defaultUnit
+		if last := part[len(part)-1:]; unitMinutes[last] != 0 {
+			number, unit = part[:len(part)-1], last
+		} else if len(parts) > 1 {
+			return 0, fmt.Errorf("estimate %q has a part without a unit", text)
+		}
+		value, err := strconv.ParseFloat(number, 64)
+		if err != nil || value < 0 || math.IsInf(value, 0) {
+			return 0, fmt.Errorf("invalid estimate %q", text)
+		}
+		minutes += value * unitMinutes[unit]
+	}
+	return int(math.Round(minutes)), nil
+}
+
+// formatEstimate writes minutes in Jira's notation, the way Jira displays estimates
+func formatEstimate(minutes, hoursPerDay, daysPerWeek int) string {
+	if minutes == 0 {
+		return "0m"
+	}
+
+	var parts []string
+	for _, unit := range []struct {
+		suffix  string
+		minutes int
+	}{
+		{"w", 60 * hoursPerDay * daysPerWeek},
+		{"d", 60 * hoursPerDay},
+		{"h", 60},
+		{"m", 1},
+	} {
+		if count := minutes / unit.minutes; count > 0 {
+			parts = append(parts, strconv.Itoa(count)+unit.suffix)
+			minutes %= unit.minutes
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// timeTrackingEstimate returns the original estimate to write for an incident field: its first
+// value (or catalog attribute), after the mapping's transform, in Jira's notation. It is empty
+// when the field has no value.
+func (s *IncidentJiraSync) timeTrackingEstimate(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, fieldMapping mapping.FieldMapping) (string, error) {
+	texts, err := s.selectTexts(ctx, customFieldEntry, fieldMapping)
+	if err != nil || len(texts) == 0 {
+		return "", err
+	}
+	if len(texts) > 1 {
+		log.Printf("Warning: %s has %d values, using only the first as the estimate", fieldMapping.IncidentFieldName, len(texts))
+	}
+
+	minutes, err := parseEstimate(texts[0], s.config.TimeTrackingUnit, s.config.JiraHoursPerDay, s.config.JiraDaysPerWeek)
+	if err != nil {
+		return "", err
+	}
+	return formatEstimate(minutes, s.config.JiraHoursPerDay, s.config.JiraDaysPerWeek), nil
+}
+
+// processTimeTrackingField writes an incident field to the original estimate of the issue's
+// time tracking. A field without a value leaves the estimate alone.
+func (s *IncidentJiraSync) processTimeTrackingField(ctx context.Context, customFieldEntry incidentio.CustomFieldEntry, jiraIssueKey string, fieldMapping mapping.FieldMapping) error {
+	fieldIDs := fieldMapping.EnabledFieldIDs()
+	if len(fieldIDs) == 0 {
+		return nil
+	}
+	for _, fieldID := range fieldIDs {
+		if fieldID != timeTrackingFieldID {
+			return fmt.Errorf("timetracking mappings write the %s field, not %s", timeTrackingFieldID, fieldID)
+		}
+	}
+
+	estimate, err := s.timeTrackingEstimate(ctx, customFieldEntry, fieldMapping)
+	if err != nil {
+		return err
+	}
+	if estimate == "" {
+		log.Printf("%s has no value, leaving the estimate of %s alone", fieldMapping.IncidentFieldName, jiraIssueKey)
+		return nil
+	}
+
+	editMeta, err := s.jira.EditMeta(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+	if _, editable := editMeta[timeTrackingFieldID]; !editable {
+		return fmt.Errorf("time tracking is not enabled or not on the edit screen of %s", jiraIssueKey)
+	}
+
+	log.Printf("Mapped %s -> original estimate %s", fieldMapping.IncidentFieldName, estimate)
+	return s.updateJiraIssueFields(ctx, jiraIssueKey, map[string]interface{}{
+		timeTrackingFieldID: map[string]string{"originalEstimate": estimate},
+	})
+}
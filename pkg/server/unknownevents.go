@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unknownEventTypesMax bounds the distinct event types counted, so a sender making up types
+// can't grow memory without limit
+const unknownEventTypesMax = 100
+
+// unknownEventSample is an ignored delivery kept for /admin/unknown-events, with its body
+// redacted and truncated
+type unknownEventSample struct {
+	EventType  string    `json:"event_type"`
+	Reason     string    `json:"reason"`
+	ReceivedAt time.Time `json:"received_at"`
+	Body       string    `json:"body"`
+	Truncated  bool      `json:"truncated,omitempty"`
+}
+
+// unknownEventType counts the ignored deliveries of one event type
+type unknownEventType struct {
+	EventType string    `json:"event_type"`
+	Reason    string    `json:"reason"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// unknownEvents keeps the last deliveries ignored as unknown or unsubscribed in a ring buffer,
+// and counts them by event type, to discover event types worth handling
+type unknownEvents struct {
+	mu       sync.Mutex
+	samples  []unknownEventSample
+	next     int
+	full     bool
+	maxBytes int
+	types    map[string]*unknownEventType
+	// untracked counts deliveries of event types beyond unknownEventTypesMax
+	untracked int
+}
+
+// newUnknownEvents returns a buffer keeping size samples of at most maxBytes each, or nil when
+// size is 0
+func newUnknownEvents(size, maxBytes int) *unknownEvents {
+	if size <= 0 {
+		return nil
+	}
+	return &unknownEvents{
+		samples:  make([]unknownEventSample, size),
+		maxBytes: maxBytes,
+		types:    make(map[string]*unknownEventType),
+	}
+}
+
+// sampleBuffer keeps the first limit bytes written to it and discards the rest, so a delivery
+// can be sampled without holding all of it. A limit of 0 keeps everything.
+type sampleBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *sampleBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 && b.Len()+len(p) > b.limit {
+		b.truncated = true
+		b.Buffer.Write(p[:b.limit-b.Len()])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// normalizeEventType groups event types differing only in case or surrounding space
+func normalizeEventType(eventType string) string {
+	eventType = strings.ToLower(strings.TrimSpace(eventType))
+	if eventType == "" {
+		return "(none)"
+	}
+	return eventType
+}
+
+// record keeps an ignored delivery whose body has already been redacted. truncated is set when
+// only the start of the body was read.
+func (u *unknownEvents) record(eventType, reason, body string, truncated bool) {
+	now := time.Now().UTC()
+	eventType = normalizeEventType(eventType)
+
+	sample := unknownEventSample{EventType: eventType, Reason: reason, ReceivedAt: now, Body: body, Truncated: truncated}
+	if u.maxBytes > 0 && len(body) > u.maxBytes {
+		sample.Body, sample.Truncated = strings.ToValidUTF8(body[:u.maxBytes], ""), true
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.samples[u.next] = sample
+	u.next = (u.next + 1) % len(u.samples)
+	u.full = u.full || u.next == 0
+
+	key := reason + " " + eventType
+	counted, exists := u.types[key]
+	if !exists {
+		if len(u.types) >= unknownEventTypesMax {
+			u.untracked++
+			return
+		}
+		counted = &unknownEventType{EventType: eventType, Reason: reason, FirstSeen: now}
+		u.types[key] = counted
+	}
+	counted.Count++
+	counted.LastSeen = now
+}
+
+// snapshot returns the counted event types, most seen first, and the samples, newest first
+func (u *unknownEvents) snapshot() ([]unknownEventType, []unknownEventSample, int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	types := make([]unknownEventType, 0, len(u.types))
+	for _, counted := range u.types {
+		types = append(types, *counted)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].Count != types[j].Count {
+			return types[i].Count > types[j].Count
+		}
+		return types[i].EventType < types[j].EventType
+	})
+
+	count := u.next
+	if u.full {
+		count = len(u.samples)
+	}
+	samples := make([]unknownEventSample, 0, count)
+	for i := 1; i <= count; i++ {
+		samples = append(samples, u.samples[(u.next-i+len(u.samples))%len(u.samples)])
+	}
+	return types, samples, u.untracked
+}
+
+// adminUnknownEventsHandler lists the event types ignored as unknown or unsubscribed and the
+// last deliveries of them
+func (s *IncidentJiraSync) adminUnknownEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.unknownEvents == nil {
+		http.Error(w, "Unknown event sampling is disabled (UNKNOWN_EVENT_SAMPLES=0)", http.StatusNotFound)
+		return
+	}
+
+	types, samples, untracked := s.unknownEvents.snapshot()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"event_types":          types,
+		"untracked_deliveries": untracked,
+		"samples":              samples,
+	})
+}
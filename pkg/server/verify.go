@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// errWriteNotApplied marks a write Jira accepted without applying it, e.g. an invalid option
+// value dropped with a 204
+var errWriteNotApplied = errors.New("Jira accepted the write but did not apply it")
+
+// valueApplied reports whether a Jira field value read back holds a value written. Jira adds
+// IDs and links to objects, returns sprints and options as objects, and reformats times, so
+// the comparison is looser than equality.
+func valueApplied(want, have interface{}) bool {
+	switch want := want.(type) {
+	case nil:
+		switch have := have.(type) {
+		case nil:
+			return true
+		case string:
+			return have == ""
+		case []interface{}:
+			return len(have) == 0
+		}
+		return false
+	case []interface{}:
+		haveList, isList := have.([]interface{})
+		if !isList || len(haveList) != len(want) {
+			return false
+		}
+		for _, wanted := range want {
+			found := false
+			for _, item := range haveList {
+				if valueApplied(wanted, item) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		if want["type"] == "doc" {
+			haveMap, isMap := have.(map[string]interface{})
+			return isMap && strings.TrimSpace(documentText(want)) == strings.TrimSpace(documentText(haveMap))
+		}
+		haveMap, isMap := have.(map[string]interface{})
+		if !isMap {
+			return false
+		}
+		for key, value := range want {
+			if !valueApplied(value, haveMap[key]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if reflect.DeepEqual(want, have) {
+		return true
+	}
+	switch have := have.(type) {
+	case map[string]interface{}:
+		// An option or sprint written by value or ID
+		for _, key := range []string{"id", "value", "name", "key"} {
+			if have[key] != nil && fmt.Sprint(have[key]) == fmt.Sprint(want) {
+				return true
+			}
+		}
+	case []interface{}:
+		// A sprint field keeps the issue's past sprints
+		for _, item := range have {
+			if _, isMap := item.(map[string]interface{}); isMap && valueApplied(want, item) {
+				return true
+			}
+		}
+	case string:
+		wantText, isText := want.(string)
+		if !isText {
+			return false
+		}
+		wantTime, wantErr := parseJiraTime(wantText)
+		haveTime, haveErr := parseJiraTime(have)
+		return wantErr == nil && haveErr == nil && wantTime.Equal(haveTime)
+	}
+	return false
+}
+
+// parseJiraTime reads a date-time as this service writes it or as Jira returns it
+func parseJiraTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000-0700"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("not a date-time: %s", value)
+}
+
+// verifyWrite reads back the fields an edit set and checks Jira applied them, returning
+// errWriteNotApplied naming the fields that don't hold the written values. Edits made of
+// operations (add, remove) are not verified.
+func (s *IncidentJiraSync) verifyWrite(ctx context.Context, jiraIssueKey string, update jira.UpdateRequest) error {
+	if len(update.Fields) == 0 {
+		return nil
+	}
+
+	fieldIDs := make([]string, 0, len(update.Fields))
+	for fieldID := range update.Fields {
+		fieldIDs = append(fieldIDs, fieldID)
+	}
+	sort.Strings(fieldIDs)
+
+	var issue struct {
+		Fields map[string]interface{} `json:"fields"`
+	}
+	path := fmt.Sprintf("%s?fields=%s", jira.IssuePath(jiraIssueKey), strings.Join(fieldIDs, ","))
+	if err := s.jira.GetFresh(ctx, path, &issue); err != nil {
+		// The write itself succeeded, so a failed read doesn't fail the sync
+		log.Printf("Warning: failed to read %s back to verify the write: %v", jiraIssueKey, err)
+		writeVerificationsTotal.inc("unverified")
+		return nil
+	}
+
+	var dropped []string
+	for _, fieldID := range fieldIDs {
+		var want interface{}
+		encoded, err := json.Marshal(update.Fields[fieldID])
+		if err == nil {
+			err = json.Unmarshal(encoded, &want)
+		}
+		if err != nil || !valueApplied(want, issue.Fields[fieldID]) {
+			dropped = append(dropped, fieldID)
+			writeVerificationFailuresTotal.inc(fieldID)
+		}
+	}
+	if len(dropped) > 0 {
+		writeVerificationsTotal.inc("not_applied")
+		return fmt.Errorf("%w: %s on %s", errWriteNotApplied, strings.Join(dropped, ", "), jiraIssueKey)
+	}
+
+	writeVerificationsTotal.inc("verified")
+	return nil
+}
+
+var (
+	writeVerificationsTotal = newCounterVec(
+		"incident_jira_webhook_write_verifications_total",
+		"Jira writes read back to verify them, by outcome (verified, not_applied or unverified).",
+		"outcome")
+	writeVerificationFailuresTotal = newCounterVec(
+		"incident_jira_webhook_write_verification_failures_total",
+		"Jira fields found not to hold the value written after Jira accepted the write, by Jira field.",
+		"field")
+)
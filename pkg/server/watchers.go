@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+)
+
+// accountIDCache remembers the Jira account ID of each email address looked up
+type accountIDCache struct {
+	mu         sync.Mutex
+	accountIDs map[string]string
+}
+
+func newAccountIDCache() *accountIDCache {
+	return &accountIDCache{accountIDs: make(map[string]string)}
+}
+
+// resolveAccountID returns the Jira account ID for an email address, searching Jira on a cache miss
+func (s *IncidentJiraSync) resolveAccountID(ctx context.Context, email string) (string, error) {
+	email = strings.ToLower(email)
+
+	s.accountIDs.mu.Lock()
+	accountID, cached := s.accountIDs.accountIDs[email]
+	s.accountIDs.mu.Unlock()
+	if cached {
+		return accountID, nil
+	}
+
+	accountID, err := s.jira.FindUserAccountID(ctx, email)
+	if err != nil {
+		return "", err
+	}
+
+	s.accountIDs.mu.Lock()
+	s.accountIDs.accountIDs[email] = accountID
+	s.accountIDs.mu.Unlock()
+	return accountID, nil
+}
+
+// watcherEmails returns the email addresses of the people holding the roles in WATCHER_ROLES
+func (s *IncidentJiraSync) watcherEmails(incident incidentio.Incident) []string {
+	var emails []string
+	for _, assignment := range incident.RoleAssignments {
+		if assignment.Assignee == nil || assignment.Assignee.Email == "" {
+			continue
+		}
+		if !s.watchedRole(assignment.Role.Name) {
+			continue
+		}
+		emails = append(emails, strings.ToLower(assignment.Assignee.Email))
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+// watchedRole reports whether holders of the role (case-insensitive) become watchers
+func (s *IncidentJiraSync) watchedRole(role string) bool {
+	for watched := range s.config.WatcherRoles {
+		if watched == "*" || strings.EqualFold(watched, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncWatchers adds the incident's responders in WATCHER_ROLES as watchers of the Jira issue,
+// so they get Jira notifications. Watchers are only added, never removed.
+func (s *IncidentJiraSync) syncWatchers(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if len(s.config.WatcherRoles) == 0 {
+		return nil
+	}
+
+	emails := s.watcherEmails(incident)
+	if len(emails) == 0 || !s.lastWritten.changed(jiraIssueKey, "watchers", strings.Join(emails, ",")) {
+		return nil
+	}
+
+	current, err := s.jira.Watchers(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+	watching := make(map[string]bool, len(current))
+	for _, accountID := range current {
+		watching[accountID] = true
+	}
+
+	for _, email := range emails {
+		accountID, err := s.resolveAccountID(ctx, email)
+		if err != nil {
+			// People without a Jira account are skipped rather than failing the sync
+			log.Printf("Warning: not adding %s as a watcher of %s: %v", s.redactor.redactString(email), jiraIssueKey, err)
+			continue
+		}
+		if watching[accountID] {
+			continue
+		}
+
+		if err := s.jira.AddWatcher(ctx, jiraIssueKey, accountID); err != nil {
+			return err
+		}
+		watching[accountID] = true
+		log.Printf("Added %s as a watcher of %s", s.redactor.redactString(email), jiraIssueKey)
+	}
+
+	s.lastWritten.record(jiraIssueKey, "watchers", strings.Join(emails, ","))
+	return nil
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// worklogPropertyKey is the worklog property marking the worklogs logged for an incident
+const worklogPropertyKey = "incident-jira-webhook.incident"
+
+// worklogProperty is the value of worklogPropertyKey
+type worklogProperty struct {
+	IncidentID string `json:"incident_id"`
+}
+
+// timeInIncident returns how long the incident ran, from being reported until it was resolved.
+// ok is false until it is resolved.
+func (s *IncidentJiraSync) timeInIncident(incident incidentio.Incident) (started time.Time, duration time.Duration, ok bool) {
+	resolved, found := incident.Timestamp(s.config.SLAResolvedTimestamp)
+	if !found {
+		return time.Time{}, 0, false
+	}
+	reported, found := incident.Timestamp(s.config.SLAReportedTimestamp)
+	if !found {
+		reported = incident.CreatedAt
+	}
+	if reported.IsZero() {
+		return time.Time{}, 0, false
+	}
+
+	// Jira logs whole minutes, at least one
+	duration = max(resolved.Sub(reported).Round(time.Minute), time.Minute)
+	return reported, duration, true
+}
+
+// syncResolutionWorklog logs the time in the incident on its Jira issue once it is resolved, as
+// WORKLOG_JIRA_CREDENTIAL. Related issues are left out so the time is only counted once. A
+// worklog already marked with the incident counts as done, so each incident is logged once even
+// across restarts.
+func (s *IncidentJiraSync) syncResolutionWorklog(ctx context.Context, incident incidentio.Incident, jiraIssueKey string) error {
+	if !s.config.WorklogOnResolution || jiraIssueKey != incident.ExternalIssueReference.IssueName {
+		return nil
+	}
+	started, duration, resolved := s.timeInIncident(incident)
+	if !resolved || !s.lastWritten.changed(jiraIssueKey, "resolution_worklog", incident.ID) {
+		return nil
+	}
+
+	if credentials, defined := s.config.JiraCredentials[s.config.WorklogCredential]; defined {
+		ctx = jira.WithCredentials(ctx, credentials)
+	}
+
+	worklogs, err := s.jira.Worklogs(ctx, jiraIssueKey)
+	if err != nil {
+		return err
+	}
+	for _, worklog := range worklogs {
+		value, marked := worklog.Property(worklogPropertyKey)
+		if !marked {
+			continue
+		}
+		var property worklogProperty
+		if json.Unmarshal(value, &property) == nil && property.IncidentID == incident.ID {
+			s.lastWritten.record(jiraIssueKey, "resolution_worklog", incident.ID)
+			return nil
+		}
+	}
+
+	comment, _, err := s.renderTemplate(ctx, templateWorklogComment, templateData{Incident: incident, IssueKey: jiraIssueKey, Duration: duration})
+	if err != nil {
+		return err
+	}
+	marker, err := json.Marshal(worklogProperty{IncidentID: incident.ID})
+	if err != nil {
+		return err
+	}
+	worklog := jira.Worklog{
+		Started:          started.Format(jira.WorklogTimeFormat),
+		TimeSpentSeconds: int64(duration / time.Second),
+		Properties:       []jira.EntityProperty{{Key: worklogPropertyKey, Value: marker}},
+	}
+	if comment != "" {
+		worklog.Comment = jira.PlainTextDocument(comment)
+	}
+
+	log.Printf("Logging %s in incident %s on %s", duration, incident.ID, jiraIssueKey)
+	if err := s.jira.AddWorklog(ctx, jiraIssueKey, worklog); err != nil {
+		return fmt.Errorf("failed to log time in incident: %w", err)
+	}
+	s.stream.publish(streamEvent{Type: streamJiraWrite, IssueKey: jiraIssueKey, Outcome: "success", Message: "worklog " + duration.String()})
+	worklogsAddedTotal.inc()
+
+	s.lastWritten.record(jiraIssueKey, "resolution_worklog", incident.ID)
+	return nil
+}
+
+var worklogsAddedTotal = newCounterVec(
+	"incident_jira_webhook_worklogs_added_total",
+	"Worklogs of the time in an incident added to Jira issues on resolution.")
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+)
+
+// Issues rarely move between projects, but they can, and every issue written would otherwise
+// stay cached for the life of the process
+const (
+	issueProjectTTL        = time.Hour
+	maxCachedIssueProjects = 10000
+)
+
+// assetsWorkspaces caches the Assets workspace of each Assets field per Jira project, and the
+// project of recently written issues
+type assetsWorkspaces struct {
+	mu            sync.Mutex
+	byField       map[string]string
+	issueProjects map[string]cachedIssueProject
+}
+
+type cachedIssueProject struct {
+	projectID string
+	cachedAt  time.Time
+}
+
+func newAssetsWorkspaces() *assetsWorkspaces {
+	return &assetsWorkspaces{byField: make(map[string]string), issueProjects: make(map[string]cachedIssueProject)}
+}
+
+// cachedIssueProject returns the cached project of an issue, unless it has expired
+func (w *assetsWorkspaces) cachedIssueProject(jiraIssueKey string, now time.Time) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cached, found := w.issueProjects[jiraIssueKey]
+	if !found {
+		return "", false
+	}
+	if now.Sub(cached.cachedAt) >= issueProjectTTL {
+		delete(w.issueProjects, jiraIssueKey)
+		return "", false
+	}
+	return cached.projectID, true
+}
+
+// cacheIssueProject records the project of an issue. When the cache is full, expired issues
+// are dropped first and then arbitrary ones.
+func (w *assetsWorkspaces) cacheIssueProject(jiraIssueKey, projectID string, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, found := w.issueProjects[jiraIssueKey]; !found && len(w.issueProjects) >= maxCachedIssueProjects {
+		for key, cached := range w.issueProjects {
+			if now.Sub(cached.cachedAt) >= issueProjectTTL {
+				delete(w.issueProjects, key)
+			}
+		}
+		for key := range w.issueProjects {
+			if len(w.issueProjects) < maxCachedIssueProjects {
+				break
+			}
+			delete(w.issueProjects, key)
+		}
+	}
+	w.issueProjects[jiraIssueKey] = cachedIssueProject{projectID: projectID, cachedAt: now}
+}
+
+// issueProject returns the project ID of an issue
+func (s *IncidentJiraSync) issueProject(ctx context.Context, jiraIssueKey string) (string, error) {
+	if projectID, cached := s.workspaces.cachedIssueProject(jiraIssueKey, time.Now()); cached {
+		return projectID, nil
+	}
+
+	projectID, err := s.jira.IssueProjectID(ctx, jiraIssueKey)
+	if err != nil {
+		return "", err
+	}
+	s.workspaces.cacheIssueProject(jiraIssueKey, projectID, time.Now())
+	return projectID, nil
+}
+
+// assetsWorkspaceID returns the Assets workspace an Assets field uses on an issue: the workspace
+// of the field context that applies to the issue's project. It falls back to JIRA_WORKSPACE_ID
+// when detection is off or the field configuration can't be read.
+func (s *IncidentJiraSync) assetsWorkspaceID(ctx context.Context, jiraIssueKey, fieldID string) string {
+	if !s.config.JiraWorkspaceAutodetect {
+		return s.config.JiraWorkspaceID
+	}
+
+	projectID, err := s.issueProject(ctx, jiraIssueKey)
+	if err != nil {
+		log.Printf("Warning: using JIRA_WORKSPACE_ID for %s on %s: %v", fieldID, jiraIssueKey, err)
+		return s.config.JiraWorkspaceID
+	}
+
+	cacheKey := fieldID + "/" + projectID
+	s.workspaces.mu.Lock()
+	workspaceID, cached := s.workspaces.byField[cacheKey]
+	s.workspaces.mu.Unlock()
+	if cached {
+		return workspaceID
+	}
+
+	workspaceID, err = s.detectAssetsWorkspace(ctx, fieldID, projectID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return s.config.JiraWorkspaceID
+		}
+		// Detection usually fails for lack of permission, so don't ask again for every write
+		log.Printf("Warning: failed to detect the Assets workspace of %s, using JIRA_WORKSPACE_ID: %v", fieldID, err)
+		workspaceID = ""
+	}
+	if workspaceID == "" {
+		workspaceID = s.config.JiraWorkspaceID
+	} else if workspaceID != s.config.JiraWorkspaceID {
+		log.Printf("%s uses Assets workspace %s in project %s", fieldID, workspaceID, projectID)
+	}
+
+	s.workspaces.mu.Lock()
+	s.workspaces.byField[cacheKey] = workspaceID
+	s.workspaces.mu.Unlock()
+	return workspaceID
+}
+
+func (s *IncidentJiraSync) detectAssetsWorkspace(ctx context.Context, fieldID, projectID string) (string, error) {
+	contextID, err := s.jira.FieldContextID(ctx, fieldID, projectID)
+	if err != nil {
+		return "", err
+	}
+	return s.jira.AssetsFieldWorkspaceID(ctx, fieldID, contextID)
+}
+
+// fieldWorkspaces returns the distinct Assets workspaces of fields on an issue, in field order
+func (s *IncidentJiraSync) fieldWorkspaces(ctx context.Context, jiraIssueKey string, fieldIDs []string) []string {
+	if len(fieldIDs) == 0 {
+		return []string{s.config.JiraWorkspaceID}
+	}
+
+	var workspaces []string
+	seen := make(map[string]bool, len(fieldIDs))
+	for _, fieldID := range fieldIDs {
+		workspaceID := s.assetsWorkspaceID(ctx, jiraIssueKey, fieldID)
+		if !seen[workspaceID] {
+			seen[workspaceID] = true
+			workspaces = append(workspaces, workspaceID)
+		}
+	}
+	return workspaces
+}
+
+// inWorkspaces returns an object of JIRA_WORKSPACE_ID, where catalog object keys point, with
+// the IDs of the same object in each of the workspaces, found by its object key. Workspaces
+// the object can't be found in are left out, so fields using them skip the value.
+func (s *IncidentJiraSync) inWorkspaces(ctx context.Context, workspaces []string, objectID, objectKey string) (jira.ComponentValue, error) {
+	value := s.formatJiraComponentValue(objectID, "")
+	if len(workspaces) == 1 && workspaces[0] == s.config.JiraWorkspaceID {
+		return value, nil
+	}
+
+	value.WorkspaceObjectIDs = make(map[string]string, len(workspaces))
+	var lastErr error
+	for _, workspaceID := range workspaces {
+		if workspaceID == s.config.JiraWorkspaceID {
+			value.WorkspaceObjectIDs[workspaceID] = objectID
+			continue
+		}
+
+		// Bare object IDs carry no key to search other workspaces by
+		if objectKey == "" || objectKey == objectID {
+			key, err := s.assetsObject(ctx, s.config.JiraWorkspaceID+"/id:"+objectID, func() (string, error) {
+				object, err := s.jira.GetAssetsObject(ctx, s.config.JiraWorkspaceID, objectID)
+				if err != nil {
+					return "", fmt.Errorf("failed to read Assets object %s: %w", objectID, err)
+				}
+				return object.ObjectKey, nil
+			})
+			if err != nil {
+				return jira.ComponentValue{}, err
+			}
+			objectKey = key
+		}
+
+		workspaceObjectID, err := s.assetsObjectWithKey(ctx, workspaceID, objectKey)
+		if err != nil {
+			if ctx.Err() != nil {
+				return jira.ComponentValue{}, ctx.Err()
+			}
+			log.Printf("Failed to find Assets object %s in workspace %s: %v", objectKey, workspaceID, err)
+			lastErr = err
+			continue
+		}
+		value.WorkspaceObjectIDs[workspaceID] = workspaceObjectID
+	}
+	if len(value.WorkspaceObjectIDs) == 0 {
+		return jira.ComponentValue{}, lastErr
+	}
+	return value, nil
+}
+
+// ensureInWorkspaces returns the Assets object of a catalog entry without an object key,
+// finding or creating it in each of the workspaces. Workspaces it can't be created in are
+// left out, so fields using them skip the value.
+func (s *IncidentJiraSync) ensureInWorkspaces(ctx context.Context, workspaces []string, catalogEntry *incidentio.CatalogEntry) (jira.ComponentValue, error) {
+	objectIDs := make(map[string]string, len(workspaces))
+	var value jira.ComponentValue
+	var lastErr error
+	for _, workspaceID := range workspaces {
+		objectID, err := s.ensureAssetsObject(ctx, workspaceID, catalogEntry)
+		if err != nil {
+			if ctx.Err() != nil {
+				return jira.ComponentValue{}, ctx.Err()
+			}
+			log.Printf("Failed to create Assets object for catalog entry %s in workspace %s: %v", catalogEntry.ID, workspaceID, err)
+			lastErr = err
+			continue
+		}
+		if len(objectIDs) == 0 {
+			value = s.formatJiraComponentValueIn(workspaceID, objectID)
+		}
+		objectIDs[workspaceID] = objectID
+	}
+	if len(objectIDs) == 0 {
+		return jira.ComponentValue{}, lastErr
+	}
+	value.WorkspaceObjectIDs = objectIDs
+	return value, nil
+}
+
+// inFieldWorkspace returns values with their IDs in the Assets workspace of a field. Values
+// that weren't resolved in that workspace are left out.
+func (s *IncidentJiraSync) inFieldWorkspace(ctx context.Context, jiraIssueKey, fieldID string, values []jira.ComponentValue) []jira.ComponentValue {
+	if len(values) == 0 {
+		return values
+	}
+
+	workspaceID := s.assetsWorkspaceID(ctx, jiraIssueKey, fieldID)
+	converted := make([]jira.ComponentValue, 0, len(values))
+	for _, value := range values {
+		objectID := value.ObjectID
+		if value.WorkspaceObjectIDs != nil {
+			var found bool
+			if objectID, found = value.WorkspaceObjectIDs[workspaceID]; !found {
+				log.Printf("Leaving object %s out of %s on %s, it wasn't found in workspace %s", value.ObjectID, fieldID, jiraIssueKey, workspaceID)
+				continue
+			}
+		}
+		converted = append(converted, s.formatJiraComponentValueIn(workspaceID, objectID))
+	}
+	return converted
+}
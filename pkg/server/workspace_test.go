@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/magzbaxter/incident-jira-webhook/pkg/incidentio"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/jira"
+	"github.com/magzbaxter/incident-jira-webhook/pkg/mapping"
+)
+
+func TestResolveObjectIDsPerFieldWorkspace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/workspace/ws-a/v1/object/3":
+			w.Write([]byte(`{"id": "3", "objectKey": "PIN-3"}`))
+		case "/workspace/ws-b/v1/object/aql":
+			var request jira.AssetsAQLRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			if request.QLQuery != `Key = "PIN-3"` {
+				t.Errorf("query = %s", request.QLQuery)
+			}
+			w.Write([]byte(`{"values": [{"id": "77", "objectKey": "PIN-3"}]}`))
+		case "/workspace/ws-c/v1/object/aql":
+			w.Write([]byte(`{"values": []}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	jiraClient := jira.NewClient(server.URL, "user", "token", server.Client())
+	jiraClient.AssetsBaseURL = server.URL
+	s := &IncidentJiraSync{
+		config:               Config{JiraWorkspaceID: "ws-a", JiraWorkspaceAutodetect: true},
+		jira:                 jiraClient,
+		workspaces:           newAssetsWorkspaces(),
+		createdAssetsObjects: make(map[string]string),
+		assetsLookups:        make(map[string]*assetsLookup),
+	}
+	s.workspaces.cacheIssueProject("OPS-1", "10000", time.Now())
+	s.workspaces.byField["customfield_1/10000"] = "ws-a"
+	s.workspaces.byField["customfield_2/10000"] = "ws-b"
+	s.workspaces.byField["customfield_3/10000"] = "ws-c"
+
+	fieldMapping := mapping.FieldMapping{
+		UseExternalID: true,
+		JiraTargets: []mapping.JiraTarget{
+			{FieldID: "customfield_1", Enabled: true},
+			{FieldID: "customfield_2", Enabled: true},
+			{FieldID: "customfield_3", Enabled: true},
+		},
+	}
+	ctx := context.Background()
+	values, err := s.resolveObjectIDs(ctx, "OPS-1", &incidentio.CatalogEntry{ID: "entry", ExternalID: "3"}, fieldMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 {
+		t.Fatalf("values = %+v, want one", values)
+	}
+
+	tests := []struct {
+		fieldID string
+		want    []jira.ComponentValue
+	}{
+		{fieldID: "customfield_1", want: []jira.ComponentValue{{ID: "ws-a:3", ObjectID: "3"}}},
+		{fieldID: "customfield_2", want: []jira.ComponentValue{{ID: "ws-b:77", ObjectID: "77"}}},
+		{fieldID: "customfield_3", want: []jira.ComponentValue{}},
+	}
+	for _, test := range tests {
+		got := s.inFieldWorkspace(ctx, "OPS-1", test.fieldID, values)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: values = %+v, want %+v", test.fieldID, got, test.want)
+		}
+	}
+}
+
+func TestIssueProjectCacheBounded(t *testing.T) {
+	workspaces := newAssetsWorkspaces()
+	now := time.Now()
+
+	workspaces.cacheIssueProject("OPS-1", "10000", now)
+	if projectID, found := workspaces.cachedIssueProject("OPS-1", now.Add(time.Minute)); !found || projectID != "10000" {
+		t.Errorf("cached project = %q, %v, want 10000", projectID, found)
+	}
+	if _, found := workspaces.cachedIssueProject("OPS-1", now.Add(issueProjectTTL)); found {
+		t.Error("project still cached after the TTL")
+	}
+
+	for i := 0; i < maxCachedIssueProjects+10; i++ {
+		workspaces.cacheIssueProject(fmt.Sprintf("OPS-%d", i), "10000", now)
+	}
+	if len(workspaces.issueProjects) != maxCachedIssueProjects {
+		t.Errorf("%d projects cached, want at most %d", len(workspaces.issueProjects), maxCachedIssueProjects)
+	}
+}